@@ -7,6 +7,7 @@ import (
 
 	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -41,6 +42,7 @@ func createDefaultCategories(ctx context.Context) {
 
 	var docs []interface{}
 	for _, cat := range categories {
+		cat.Attributes = services.DefaultCategoryAttributes(cat.Slug)
 		docs = append(docs, cat)
 	}
 
@@ -107,6 +109,7 @@ func createAdminUser(ctx context.Context) {
 	admin := models.User{
 		ID: primitive.NewObjectID(), Username: "admin", Email: "admin@autoboy.ng", Password: hashedPassword,
 		Phone: "2348000000000", UserType: models.UserTypeAdmin, Status: models.UserStatusActive,
+		AdminRoles: []string{models.AdminRoleSuperAdmin},
 		IsEmailVerified: true, IsPhoneVerified: true, CreatedAt: time.Now(), UpdatedAt: time.Now(),
 		Profile: models.Profile{
 			FirstName: "System", LastName: "Administrator", VerificationStatus: models.VerificationStatusVerified,
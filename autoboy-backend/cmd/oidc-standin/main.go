@@ -0,0 +1,30 @@
+// Command oidc-standin is a local OpenID Connect provider for trying
+// Google and Apple sign-in without either. Point GOOGLE_OIDC_ISSUER or
+// APPLE_OIDC_ISSUER at it; it approves every sign-in straight away as the
+// user named in the authorize request (email, sub, given_name,
+// family_name and email_verified query parameters), and /mint hands out an
+// ID token directly for testing the app flow.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"autoboy-backend/oidcstandin"
+)
+
+func main() {
+	addr := flag.String("addr", ":9400", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9400", "issuer URL clients reach this server at")
+	alg := flag.String("alg", "RS256", "ID token signing algorithm: RS256 (like Google) or ES256 (like Apple)")
+	flag.Parse()
+
+	s, err := oidcstandin.New(*issuer, *alg)
+	if err != nil {
+		log.Fatalf("Failed to start the stand-in: %v", err)
+	}
+
+	log.Printf("OIDC stand-in for %s listening on %s", s.Issuer(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.Handler()))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+
+	"github.com/joho/godotenv"
+)
+
+// reconcile compares wallet balances with the double-entry ledger and exits
+// non-zero when they disagree, so it can run from cron or CI.
+func main() {
+	tolerance := flag.Float64("tolerance", 0.01, "ignore differences up to this amount")
+	asJSON := flag.Bool("json", false, "print the full report as JSON")
+	openBalances := flag.Bool("post-opening-balances", false, "post opening entries for wallets with no ledger history, then reconcile again")
+	flag.Parse()
+
+	godotenv.Load()
+
+	if err := config.InitializeDatabase(); err != nil {
+		log.Fatalf("❌ Database connection failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	report, err := ledger.Reconcile(ctx, *tolerance)
+	if err != nil {
+		log.Fatalf("❌ Reconciliation failed: %v", err)
+	}
+
+	if *openBalances {
+		posted, err := ledger.PostOpeningBalances(ctx, report)
+		if err != nil {
+			log.Fatalf("❌ Failed to post opening balances: %v", err)
+		}
+		log.Printf("Posted %d opening balance entries", posted)
+
+		if report, err = ledger.Reconcile(ctx, *tolerance); err != nil {
+			log.Fatalf("❌ Reconciliation failed: %v", err)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+
+	log.Printf("Checked %d wallets", report.WalletsChecked)
+	if !report.Balanced() {
+		log.Printf("❌ Ledger does not balance: debits %.2f, credits %.2f", report.TotalDebits, report.TotalCredits)
+	}
+	for _, drift := range report.Drifts {
+		note := ""
+		if drift.Unposted {
+			note = " (no ledger history)"
+		}
+		log.Printf("⚠️  %s %s (%s): wallet %.2f, ledger %.2f, drift %.2f%s",
+			drift.UserID.Hex(), drift.Field, drift.Currency, drift.WalletAmount, drift.LedgerAmount, drift.Difference, note)
+	}
+
+	if !report.Clean() {
+		log.Printf("❌ Found %d drifting wallet fields", len(report.Drifts))
+		os.Exit(1)
+	}
+	log.Println("✅ Wallets match the ledger")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/joho/godotenv"
+)
+
+// reindex rebuilds the product search index from Mongo and writes it to
+// SEARCH_INDEX_PATH. Run it while the API is stopped; a running server keeps
+// its own copy in memory, so use POST /admin/system/search/reindex there.
+func main() {
+	godotenv.Load()
+
+	if err := config.InitializeDatabase(); err != nil {
+		log.Fatalf("❌ Database connection failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	indexed, err := services.NewSearchService().Rebuild(ctx)
+	if err != nil {
+		log.Fatalf("❌ Failed to rebuild search index: %v", err)
+	}
+	log.Printf("✅ Indexed %d products into %s", indexed, utils.GetEnv("SEARCH_INDEX_PATH", "./data/search/products.idx"))
+}
@@ -24,6 +24,10 @@ type Collections struct {
 	// User related collections
 	Users               *mongo.Collection
 	UserSessions        *mongo.Collection
+	TwoFactorChallenges *mongo.Collection
+	LoginThrottles      *mongo.Collection
+	OAuthStates         *mongo.Collection
+	SocialSignups       *mongo.Collection
 	UserActivities      *mongo.Collection
 	FollowRelationships *mongo.Collection
 	UserWallets         *mongo.Collection
@@ -38,7 +42,11 @@ type Collections struct {
 	ProductViews     *mongo.Collection
 	ProductFlags     *mongo.Collection
 	ProductAnalytics *mongo.Collection
+	ProductEvents    *mongo.Collection
+	ProductBumps     *mongo.Collection
+	ProductImports   *mongo.Collection
 	CartItems        *mongo.Collection
+	StockReservations *mongo.Collection
 
 	// Order related collections
 	Orders         *mongo.Collection
@@ -46,16 +54,20 @@ type Collections struct {
 	OrderDisputes  *mongo.Collection
 	OrderTracking  *mongo.Collection
 	OrderReturns   *mongo.Collection
+	Checkouts      *mongo.Collection
 
 	// Payment related collections
 	Payments         *mongo.Collection
 	Transactions     *mongo.Collection
 	Escrows          *mongo.Collection
 	WalletTransactions *mongo.Collection
+	Wallets          *mongo.Collection
 	PaymentWebhooks  *mongo.Collection
 	Refunds          *mongo.Collection
 	BankAccounts     *mongo.Collection
 	Withdrawals      *mongo.Collection
+	WithdrawalCounters *mongo.Collection
+	LedgerEntries    *mongo.Collection
 
 	// Chat related collections
 	Conversations    *mongo.Collection
@@ -70,6 +82,7 @@ type Collections struct {
 
 	// System collections
 	AdminLogs        *mongo.Collection
+	AdminRoles       *mongo.Collection
 	SystemSettings   *mongo.Collection
 	APIKeys          *mongo.Collection
 	Notifications    *mongo.Collection
@@ -87,6 +100,7 @@ type Collections struct {
 	SavedSearches           *mongo.Collection
 	SearchHistory           *mongo.Collection
 	SearchSuggestions       *mongo.Collection
+	Recommendations         *mongo.Collection
 	Wishlists               *mongo.Collection
 	WishlistItems           *mongo.Collection
 	WishlistShares          *mongo.Collection
@@ -191,6 +205,10 @@ func (db *Database) InitializeCollections() *Collections {
 		// User related collections
 		Users:               db.Database.Collection("users"),
 		UserSessions:        db.Database.Collection("user_sessions"),
+		TwoFactorChallenges: db.Database.Collection("two_factor_challenges"),
+		LoginThrottles:      db.Database.Collection("login_throttles"),
+		OAuthStates:         db.Database.Collection("oauth_states"),
+		SocialSignups:       db.Database.Collection("social_signups"),
 		UserActivities:      db.Database.Collection("user_activities"),
 		FollowRelationships: db.Database.Collection("follow_relationships"),
 		UserWallets:         db.Database.Collection("user_wallets"),
@@ -205,7 +223,11 @@ func (db *Database) InitializeCollections() *Collections {
 		ProductViews:     db.Database.Collection("product_views"),
 		ProductFlags:     db.Database.Collection("product_flags"),
 		ProductAnalytics: db.Database.Collection("product_analytics"),
+		ProductEvents:    db.Database.Collection("product_events"),
+		ProductBumps:     db.Database.Collection("product_bumps"),
+		ProductImports:   db.Database.Collection("product_imports"),
 		CartItems:        db.Database.Collection("cart_items"),
+		StockReservations: db.Database.Collection("stock_reservations"),
 
 		// Order related collections
 		Orders:        db.Database.Collection("orders"),
@@ -213,16 +235,20 @@ func (db *Database) InitializeCollections() *Collections {
 		OrderDisputes: db.Database.Collection("order_disputes"),
 		OrderTracking: db.Database.Collection("order_tracking"),
 		OrderReturns:  db.Database.Collection("order_returns"),
+		Checkouts:     db.Database.Collection("checkouts"),
 
 		// Payment related collections
 		Payments:           db.Database.Collection("payments"),
 		Transactions:       db.Database.Collection("transactions"),
 		Escrows:            db.Database.Collection("escrows"),
 		WalletTransactions: db.Database.Collection("wallet_transactions"),
+		Wallets:            db.Database.Collection("wallets"),
 		PaymentWebhooks:    db.Database.Collection("payment_webhooks"),
 		Refunds:            db.Database.Collection("refunds"),
 		BankAccounts:       db.Database.Collection("bank_accounts"),
 		Withdrawals:        db.Database.Collection("withdrawals"),
+		WithdrawalCounters: db.Database.Collection("withdrawal_counters"),
+		LedgerEntries:      db.Database.Collection("ledger_entries"),
 
 		// Chat related collections
 		Conversations:     db.Database.Collection("conversations"),
@@ -237,6 +263,7 @@ func (db *Database) InitializeCollections() *Collections {
 
 		// System collections
 		AdminLogs:             db.Database.Collection("admin_logs"),
+		AdminRoles:            db.Database.Collection("admin_roles"),
 		SystemSettings:        db.Database.Collection("system_settings"),
 		APIKeys:               db.Database.Collection("api_keys"),
 		Notifications:         db.Database.Collection("notifications"),
@@ -254,6 +281,7 @@ func (db *Database) InitializeCollections() *Collections {
 		SavedSearches:           db.Database.Collection("saved_searches"),
 		SearchHistory:           db.Database.Collection("search_history"),
 		SearchSuggestions:       db.Database.Collection("search_suggestions"),
+		Recommendations:         db.Database.Collection("recommendations"),
 		Wishlists:               db.Database.Collection("wishlists"),
 		WishlistItems:           db.Database.Collection("wishlist_items"),
 		WishlistShares:          db.Database.Collection("wishlist_shares"),
@@ -342,6 +370,9 @@ func (db *Database) createUserIndexes(ctx context.Context, coll *Collections) er
 		{Keys: bson.D{{Key: "profile.premium_status", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
 		{Keys: bson.D{{Key: "profile.rating", Value: -1}}},
+		{Keys: bson.D{{Key: "unlock_token_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "social_accounts.provider", Value: 1}, {Key: "social_accounts.subject", Value: 1}}},
+		{Keys: bson.D{{Key: "admin_roles", Value: 1}}, Options: options.Index().SetSparse(true)},
 	}
 
 	_, err := coll.Users.Indexes().CreateMany(ctx, userIndexes)
@@ -353,6 +384,8 @@ func (db *Database) createUserIndexes(ctx context.Context, coll *Collections) er
 	sessionIndexes := []mongo.IndexModel{
 		{Keys: bson.D{{Key: "user_id", Value: 1}}},
 		{Keys: bson.D{{Key: "session_token", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "previous_tokens", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "device_info.device_id", Value: 1}}},
 		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
 		{Keys: bson.D{{Key: "is_active", Value: 1}}},
 	}
@@ -362,14 +395,82 @@ func (db *Database) createUserIndexes(ctx context.Context, coll *Collections) er
 		return err
 	}
 
+	// Two-factor challenges indexes
+	challengeIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	_, err = coll.TwoFactorChallenges.Indexes().CreateMany(ctx, challengeIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Login throttles indexes
+	throttleIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "ip_address", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "ip_address", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	_, err = coll.LoginThrottles.Indexes().CreateMany(ctx, throttleIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Social sign-in indexes
+	oauthStateIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	_, err = coll.OAuthStates.Indexes().CreateMany(ctx, oauthStateIndexes)
+	if err != nil {
+		return err
+	}
+
+	signupIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	_, err = coll.SocialSignups.Indexes().CreateMany(ctx, signupIndexes)
+	if err != nil {
+		return err
+	}
+
 	// User activities indexes
 	activityIndexes := []mongo.IndexModel{
 		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: -1}}},
 		{Keys: bson.D{{Key: "action", Value: 1}}},
 		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "ip_address", Value: 1}, {Key: "timestamp", Value: -1}}},
 	}
 
 	_, err = coll.UserActivities.Indexes().CreateMany(ctx, activityIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Admin staff roles and action log indexes
+	adminRoleIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}
+
+	_, err = coll.AdminRoles.Indexes().CreateMany(ctx, adminRoleIndexes)
+	if err != nil {
+		return err
+	}
+
+	adminLogIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "admin_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "target_user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	}
+
+	_, err = coll.AdminLogs.Indexes().CreateMany(ctx, adminLogIndexes)
 	return err
 }
 
@@ -389,6 +490,11 @@ func (db *Database) createProductIndexes(ctx context.Context, coll *Collections)
 		{Keys: bson.D{{Key: "is_featured", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
 		{Keys: bson.D{{Key: "view_count", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "popularity_score", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "is_trending", Value: -1}, {Key: "popularity_score", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "boost_expires_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "seller_id", Value: 1}, {Key: "sku", Value: 1}}, Options: options.Index().SetSparse(true)},
 		{Keys: bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}, {Key: "tags", Value: "text"}}},
 		{Keys: bson.D{{Key: "location.coordinates", Value: "2dsphere"}}},
 	}
@@ -422,6 +528,72 @@ func (db *Database) createProductIndexes(ctx context.Context, coll *Collections)
 	}
 
 	_, err = coll.ProductReviews.Indexes().CreateMany(ctx, reviewIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Product views indexes
+	viewIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "viewed_at", Value: -1}}},
+		{Keys: bson.D{{Key: "viewed_at", Value: -1}}},
+	}
+
+	_, err = coll.ProductViews.Indexes().CreateMany(ctx, viewIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Product events indexes
+	eventIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "occurred_at", Value: -1}}},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "occurred_at", Value: -1}}},
+	}
+
+	_, err = coll.ProductEvents.Indexes().CreateMany(ctx, eventIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Product analytics indexes
+	productAnalyticsIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "date", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "date", Value: -1}}},
+	}
+
+	_, err = coll.ProductAnalytics.Indexes().CreateMany(ctx, productAnalyticsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Product bumps indexes
+	bumpIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seller_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+
+	_, err = coll.ProductBumps.Indexes().CreateMany(ctx, bumpIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Product imports indexes
+	importIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "seller_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+	}
+
+	_, err = coll.ProductImports.Indexes().CreateMany(ctx, importIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Recommendations indexes
+	recommendationIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "subject_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+	}
+
+	_, err = coll.Recommendations.Indexes().CreateMany(ctx, recommendationIndexes)
 	return err
 }
 
@@ -436,6 +608,7 @@ func (db *Database) createOrderIndexes(ctx context.Context, coll *Collections) e
 		{Keys: bson.D{{Key: "payment_status", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
 		{Keys: bson.D{{Key: "total_amount", Value: 1}}},
+		{Keys: bson.D{{Key: "checkout_id", Value: 1}}, Options: options.Index().SetSparse(true)},
 	}
 
 	_, err := coll.Orders.Indexes().CreateMany(ctx, orderIndexes)
@@ -443,6 +616,39 @@ func (db *Database) createOrderIndexes(ctx context.Context, coll *Collections) e
 		return err
 	}
 
+	// Checkouts indexes
+	checkoutIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "checkout_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "buyer_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "payment_reference", Value: 1}}, Options: options.Index().SetSparse(true)},
+	}
+
+	_, err = coll.Checkouts.Indexes().CreateMany(ctx, checkoutIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Order tracking indexes
+	trackingIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "timestamp", Value: 1}}},
+	}
+
+	_, err = coll.OrderTracking.Indexes().CreateMany(ctx, trackingIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Stock reservations indexes
+	reservationIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	}
+
+	_, err = coll.StockReservations.Indexes().CreateMany(ctx, reservationIndexes)
+	if err != nil {
+		return err
+	}
+
 	// Swap deals indexes
 	swapIndexes := []mongo.IndexModel{
 		{Keys: bson.D{{Key: "swap_number", Value: 1}}, Options: options.Index().SetUnique(true)},
@@ -465,6 +671,7 @@ func (db *Database) createPaymentIndexes(ctx context.Context, coll *Collections)
 		{Keys: bson.D{{Key: "order_id", Value: 1}}},
 		{Keys: bson.D{{Key: "status", Value: 1}}},
 		{Keys: bson.D{{Key: "payment_gateway", Value: 1}}},
+		{Keys: bson.D{{Key: "gateway_reference", Value: 1}}},
 		{Keys: bson.D{{Key: "gateway_payment_id", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
 	}
@@ -480,9 +687,109 @@ func (db *Database) createPaymentIndexes(ctx context.Context, coll *Collections)
 		{Keys: bson.D{{Key: "transaction_number", Value: 1}}, Options: options.Index().SetUnique(true)},
 		{Keys: bson.D{{Key: "type", Value: 1}}},
 		{Keys: bson.D{{Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "payment_id", Value: 1}, {Key: "type", Value: 1}}},
+		{Keys: bson.D{{Key: "reference_type", Value: 1}, {Key: "reference_id", Value: 1}}},
 	}
 
 	_, err = coll.WalletTransactions.Indexes().CreateMany(ctx, walletIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Wallets indexes
+	walletBalanceIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}
+
+	_, err = coll.Wallets.Indexes().CreateMany(ctx, walletBalanceIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Escrows indexes
+	escrowIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "escrow_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		{Keys: bson.D{{Key: "payer_id", Value: 1}}},
+		{Keys: bson.D{{Key: "payee_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "auto_release_date", Value: 1}}},
+	}
+
+	_, err = coll.Escrows.Indexes().CreateMany(ctx, escrowIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Payment webhooks indexes
+	webhookIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "gateway", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+
+	_, err = coll.PaymentWebhooks.Indexes().CreateMany(ctx, webhookIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Ledger entries indexes
+	ledgerIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entry_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "lines.account", Value: 1}}},
+		{Keys: bson.D{{Key: "reference_type", Value: 1}, {Key: "reference_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	}
+
+	_, err = coll.LedgerEntries.Indexes().CreateMany(ctx, ledgerIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Bank accounts indexes
+	bankAccountIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "bank_code", Value: 1}, {Key: "account_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}
+
+	_, err = coll.BankAccounts.Indexes().CreateMany(ctx, bankAccountIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Withdrawals indexes
+	withdrawalIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "withdrawal_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "gateway_reference", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "bank_account_id", Value: 1}, {Key: "status", Value: 1}}},
+	}
+
+	_, err = coll.Withdrawals.Indexes().CreateMany(ctx, withdrawalIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Refunds indexes
+	refundIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "refund_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "payment_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+
+	_, err = coll.Refunds.Indexes().CreateMany(ctx, refundIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Order returns indexes
+	returnIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "order_item_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "requested_by", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "seller_id", Value: 1}, {Key: "status", Value: 1}}},
+	}
+
+	_, err = coll.OrderReturns.Indexes().CreateMany(ctx, returnIndexes)
 	return err
 }
 
@@ -586,6 +893,7 @@ func (db *Database) createAlertDealIndexes(ctx context.Context, coll *Collection
 		{Keys: bson.D{{Key: "user_id", Value: 1}}},
 		{Keys: bson.D{{Key: "is_active", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "is_active", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
 	}
 
 	_, err = coll.SavedSearches.Indexes().CreateMany(ctx, savedSearchIndexes)
@@ -593,6 +901,29 @@ func (db *Database) createAlertDealIndexes(ctx context.Context, coll *Collection
 		return err
 	}
 
+	// Search history indexes
+	searchHistoryIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "normalized_query", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetSparse(true)},
+	}
+
+	_, err = coll.SearchHistory.Indexes().CreateMany(ctx, searchHistoryIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Search suggestions indexes
+	searchSuggestionIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "query", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "is_active", Value: 1}, {Key: "count", Value: -1}}},
+	}
+
+	_, err = coll.SearchSuggestions.Indexes().CreateMany(ctx, searchSuggestionIndexes)
+	if err != nil {
+		return err
+	}
+
 	// Wishlists indexes
 	wishlistIndexes := []mongo.IndexModel{
 		{Keys: bson.D{{Key: "user_id", Value: 1}}},
@@ -707,6 +1038,21 @@ func (db *Database) createDisputeReportIndexes(ctx context.Context, coll *Collec
 	return err
 }
 
+// WithTransaction runs fn inside a multi-document MongoDB transaction.
+// All collection calls inside fn must use the provided session context.
+func (db *Database) WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
 // CloseDatabase closes the MongoDB connection
 func (db *Database) CloseDatabase(ctx context.Context) error {
 	return db.Client.Disconnect(ctx)
@@ -0,0 +1,209 @@
+package geo
+
+// state is a Nigerian state with the coordinates of its capital
+type state struct {
+	Name    string
+	Capital string
+	Lat     float64
+	Lng     float64
+	Aliases []string
+}
+
+// city is a town or district with its approximate centre
+type city struct {
+	Name  string
+	State string
+	Lat   float64
+	Lng   float64
+}
+
+// states lists the 36 states and the Federal Capital Territory
+var states = []state{
+	{Name: "Abia", Capital: "Umuahia", Lat: 5.5250, Lng: 7.4940},
+	{Name: "Adamawa", Capital: "Yola", Lat: 9.2035, Lng: 12.4954},
+	{Name: "Akwa Ibom", Capital: "Uyo", Lat: 5.0377, Lng: 7.9128, Aliases: []string{"akwaibom"}},
+	{Name: "Anambra", Capital: "Awka", Lat: 6.2100, Lng: 7.0700},
+	{Name: "Bauchi", Capital: "Bauchi", Lat: 10.3158, Lng: 9.8442},
+	{Name: "Bayelsa", Capital: "Yenagoa", Lat: 4.9267, Lng: 6.2676},
+	{Name: "Benue", Capital: "Makurdi", Lat: 7.7322, Lng: 8.5391},
+	{Name: "Borno", Capital: "Maiduguri", Lat: 11.8311, Lng: 13.1510},
+	{Name: "Cross River", Capital: "Calabar", Lat: 4.9757, Lng: 8.3417},
+	{Name: "Delta", Capital: "Asaba", Lat: 6.1980, Lng: 6.7319},
+	{Name: "Ebonyi", Capital: "Abakaliki", Lat: 6.3249, Lng: 8.1137},
+	{Name: "Edo", Capital: "Benin City", Lat: 6.3350, Lng: 5.6037},
+	{Name: "Ekiti", Capital: "Ado Ekiti", Lat: 7.6233, Lng: 5.2209},
+	{Name: "Enugu", Capital: "Enugu", Lat: 6.4584, Lng: 7.5464},
+	{Name: "FCT", Capital: "Abuja", Lat: 9.0765, Lng: 7.3986, Aliases: []string{"abuja", "federal capital territory", "fct abuja"}},
+	{Name: "Gombe", Capital: "Gombe", Lat: 10.2897, Lng: 11.1673},
+	{Name: "Imo", Capital: "Owerri", Lat: 5.4836, Lng: 7.0333},
+	{Name: "Jigawa", Capital: "Dutse", Lat: 11.7560, Lng: 9.3389},
+	{Name: "Kaduna", Capital: "Kaduna", Lat: 10.5105, Lng: 7.4165},
+	{Name: "Kano", Capital: "Kano", Lat: 12.0022, Lng: 8.5920},
+	{Name: "Katsina", Capital: "Katsina", Lat: 12.9908, Lng: 7.6018},
+	{Name: "Kebbi", Capital: "Birnin Kebbi", Lat: 12.4539, Lng: 4.1975},
+	{Name: "Kogi", Capital: "Lokoja", Lat: 7.8023, Lng: 6.7333},
+	{Name: "Kwara", Capital: "Ilorin", Lat: 8.4966, Lng: 4.5421},
+	{Name: "Lagos", Capital: "Ikeja", Lat: 6.6018, Lng: 3.3515},
+	{Name: "Nasarawa", Capital: "Lafia", Lat: 8.4939, Lng: 8.5153, Aliases: []string{"nassarawa"}},
+	{Name: "Niger", Capital: "Minna", Lat: 9.5836, Lng: 6.5463},
+	{Name: "Ogun", Capital: "Abeokuta", Lat: 7.1475, Lng: 3.3619},
+	{Name: "Ondo", Capital: "Akure", Lat: 7.2571, Lng: 5.2058},
+	{Name: "Osun", Capital: "Osogbo", Lat: 7.7827, Lng: 4.5418},
+	{Name: "Oyo", Capital: "Ibadan", Lat: 7.3775, Lng: 3.9470},
+	{Name: "Plateau", Capital: "Jos", Lat: 9.8965, Lng: 8.8583},
+	{Name: "Rivers", Capital: "Port Harcourt", Lat: 4.8156, Lng: 7.0498},
+	{Name: "Sokoto", Capital: "Sokoto", Lat: 13.0059, Lng: 5.2476},
+	{Name: "Taraba", Capital: "Jalingo", Lat: 8.8937, Lng: 11.3596},
+	{Name: "Yobe", Capital: "Damaturu", Lat: 11.7470, Lng: 11.9608},
+	{Name: "Zamfara", Capital: "Gusau", Lat: 12.1628, Lng: 6.6614},
+}
+
+// cities lists state capitals and other towns and districts sellers
+// commonly give as their location
+var cities = []city{
+	// Lagos
+	{"Ikeja", "Lagos", 6.6018, 3.3515},
+	{"Lagos Island", "Lagos", 6.4541, 3.3947},
+	{"Victoria Island", "Lagos", 6.4281, 3.4219},
+	{"Ikoyi", "Lagos", 6.4500, 3.4333},
+	{"Lekki", "Lagos", 6.4698, 3.5852},
+	{"Ajah", "Lagos", 6.4667, 3.5667},
+	{"Surulere", "Lagos", 6.5000, 3.3500},
+	{"Yaba", "Lagos", 6.5095, 3.3711},
+	{"Gbagada", "Lagos", 6.5536, 3.3878},
+	{"Maryland", "Lagos", 6.5710, 3.3670},
+	{"Ojota", "Lagos", 6.5867, 3.3800},
+	{"Oshodi", "Lagos", 6.5550, 3.3435},
+	{"Apapa", "Lagos", 6.4489, 3.3590},
+	{"Festac", "Lagos", 6.4667, 3.2833},
+	{"Agege", "Lagos", 6.6180, 3.3209},
+	{"Alimosho", "Lagos", 6.6100, 3.2958},
+	{"Ikorodu", "Lagos", 6.6194, 3.5105},
+	{"Epe", "Lagos", 6.5841, 3.9834},
+	{"Badagry", "Lagos", 6.4153, 2.8813},
+	// FCT
+	{"Abuja", "FCT", 9.0765, 7.3986},
+	{"Garki", "FCT", 9.0333, 7.4833},
+	{"Wuse", "FCT", 9.0700, 7.4800},
+	{"Maitama", "FCT", 9.0900, 7.5000},
+	{"Gwarinpa", "FCT", 9.1100, 7.4000},
+	{"Kubwa", "FCT", 9.1550, 7.3400},
+	{"Lugbe", "FCT", 8.9800, 7.3800},
+	{"Nyanya", "FCT", 9.0100, 7.5700},
+	{"Gwagwalada", "FCT", 8.9433, 7.0839},
+	// South West
+	{"Abeokuta", "Ogun", 7.1475, 3.3619},
+	{"Ota", "Ogun", 6.6930, 3.2300},
+	{"Ijebu Ode", "Ogun", 6.8194, 3.9173},
+	{"Sagamu", "Ogun", 6.8322, 3.6319},
+	{"Ifo", "Ogun", 6.8150, 3.1950},
+	{"Ibadan", "Oyo", 7.3775, 3.9470},
+	{"Ogbomosho", "Oyo", 8.1337, 4.2407},
+	{"Oyo", "Oyo", 7.8500, 3.9300},
+	{"Iseyin", "Oyo", 7.9667, 3.6000},
+	{"Osogbo", "Osun", 7.7827, 4.5418},
+	{"Ile-Ife", "Osun", 7.4824, 4.5603},
+	{"Ilesa", "Osun", 7.6167, 4.7333},
+	{"Ede", "Osun", 7.7333, 4.4333},
+	{"Akure", "Ondo", 7.2571, 5.2058},
+	{"Ondo", "Ondo", 7.1000, 4.8333},
+	{"Owo", "Ondo", 7.1962, 5.5868},
+	{"Ado Ekiti", "Ekiti", 7.6233, 5.2209},
+	{"Ikere", "Ekiti", 7.4833, 5.2333},
+	// South South
+	{"Port Harcourt", "Rivers", 4.8156, 7.0498},
+	{"Obio Akpor", "Rivers", 4.8500, 7.0000},
+	{"Eleme", "Rivers", 4.7900, 7.1200},
+	{"Bonny", "Rivers", 4.4500, 7.1667},
+	{"Asaba", "Delta", 6.1980, 6.7319},
+	{"Warri", "Delta", 5.5167, 5.7500},
+	{"Sapele", "Delta", 5.8941, 5.6767},
+	{"Ughelli", "Delta", 5.4897, 5.9914},
+	{"Agbor", "Delta", 6.2500, 6.2000},
+	{"Benin City", "Edo", 6.3350, 5.6037},
+	{"Auchi", "Edo", 7.0667, 6.2667},
+	{"Ekpoma", "Edo", 6.7500, 6.1333},
+	{"Uyo", "Akwa Ibom", 5.0377, 7.9128},
+	{"Eket", "Akwa Ibom", 4.6500, 7.9333},
+	{"Ikot Ekpene", "Akwa Ibom", 5.1794, 7.7149},
+	{"Calabar", "Cross River", 4.9757, 8.3417},
+	{"Ikom", "Cross River", 5.9667, 8.7167},
+	{"Ogoja", "Cross River", 6.6584, 8.7992},
+	{"Yenagoa", "Bayelsa", 4.9267, 6.2676},
+	// South East
+	{"Enugu", "Enugu", 6.4584, 7.5464},
+	{"Nsukka", "Enugu", 6.8567, 7.3958},
+	{"Awka", "Anambra", 6.2100, 7.0700},
+	{"Onitsha", "Anambra", 6.1667, 6.7833},
+	{"Nnewi", "Anambra", 6.0186, 6.9173},
+	{"Ekwulobia", "Anambra", 6.0333, 7.0833},
+	{"Owerri", "Imo", 5.4836, 7.0333},
+	{"Orlu", "Imo", 5.7950, 7.0350},
+	{"Okigwe", "Imo", 5.8294, 7.3503},
+	{"Umuahia", "Abia", 5.5250, 7.4940},
+	{"Aba", "Abia", 5.1066, 7.3667},
+	{"Ohafia", "Abia", 5.6167, 7.8333},
+	{"Abakaliki", "Ebonyi", 6.3249, 8.1137},
+	{"Afikpo", "Ebonyi", 5.8925, 7.9354},
+	// North Central
+	{"Ilorin", "Kwara", 8.4966, 4.5421},
+	{"Offa", "Kwara", 8.1491, 4.7207},
+	{"Lokoja", "Kogi", 7.8023, 6.7333},
+	{"Okene", "Kogi", 7.5500, 6.2333},
+	{"Anyigba", "Kogi", 7.4833, 7.1667},
+	{"Makurdi", "Benue", 7.7322, 8.5391},
+	{"Gboko", "Benue", 7.3256, 9.0017},
+	{"Otukpo", "Benue", 7.1900, 8.1300},
+	{"Jos", "Plateau", 9.8965, 8.8583},
+	{"Bukuru", "Plateau", 9.8000, 8.8667},
+	{"Minna", "Niger", 9.5836, 6.5463},
+	{"Bida", "Niger", 9.0833, 6.0167},
+	{"Suleja", "Niger", 9.1806, 7.1794},
+	{"Lafia", "Nasarawa", 8.4939, 8.5153},
+	{"Keffi", "Nasarawa", 8.8486, 7.8736},
+	{"Mararaba", "Nasarawa", 9.0167, 7.5833},
+	// North West
+	{"Kaduna", "Kaduna", 10.5105, 7.4165},
+	{"Zaria", "Kaduna", 11.0855, 7.7199},
+	{"Kafanchan", "Kaduna", 9.5833, 8.3000},
+	{"Kano", "Kano", 12.0022, 8.5920},
+	{"Wudil", "Kano", 11.8094, 8.8389},
+	{"Katsina", "Katsina", 12.9908, 7.6018},
+	{"Funtua", "Katsina", 11.5233, 7.3081},
+	{"Daura", "Katsina", 13.0333, 8.3167},
+	{"Sokoto", "Sokoto", 13.0059, 5.2476},
+	{"Birnin Kebbi", "Kebbi", 12.4539, 4.1975},
+	{"Argungu", "Kebbi", 12.7448, 4.5251},
+	{"Gusau", "Zamfara", 12.1628, 6.6614},
+	{"Dutse", "Jigawa", 11.7560, 9.3389},
+	{"Hadejia", "Jigawa", 12.4500, 10.0400},
+	// North East
+	{"Bauchi", "Bauchi", 10.3158, 9.8442},
+	{"Azare", "Bauchi", 11.6765, 10.1948},
+	{"Maiduguri", "Borno", 11.8311, 13.1510},
+	{"Biu", "Borno", 10.6111, 12.1950},
+	{"Yola", "Adamawa", 9.2035, 12.4954},
+	{"Jimeta", "Adamawa", 9.2792, 12.4583},
+	{"Mubi", "Adamawa", 10.2676, 13.2644},
+	{"Gombe", "Gombe", 10.2897, 11.1673},
+	{"Jalingo", "Taraba", 8.8937, 11.3596},
+	{"Wukari", "Taraba", 7.8711, 9.7778},
+	{"Damaturu", "Yobe", 11.7470, 11.9608},
+	{"Potiskum", "Yobe", 11.7133, 11.0781},
+}
+
+// cityAliases maps common alternative spellings to a gazetteer city
+var cityAliases = map[string]string{
+	"vi":            "victoria island",
+	"ph":            "port harcourt",
+	"portharcourt":  "port harcourt",
+	"benin":         "benin city",
+	"ife":           "ile ife",
+	"ado":           "ado ekiti",
+	"oshogbo":       "osogbo",
+	"ogbomoso":      "ogbomosho",
+	"sango ota":     "ota",
+	"sango":         "ota",
+	"festac town":   "festac",
+	"lekki phase 1": "lekki",
+}
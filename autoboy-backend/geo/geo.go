@@ -0,0 +1,228 @@
+// Package geo geocodes Nigerian addresses against an offline gazetteer of
+// states, cities and districts, and measures distances between points.
+package geo
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const earthRadiusKm = 6371.0
+
+// Point is a position in decimal degrees
+type Point struct {
+	Lat float64 `json:"latitude"`
+	Lng float64 `json:"longitude"`
+}
+
+// Coordinates returns the point in the [longitude, latitude] order used by
+// ProductLocation and GeoJSON
+func (p Point) Coordinates() []float64 {
+	return []float64{p.Lng, p.Lat}
+}
+
+// Valid reports whether the point is a real position
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180 && (p.Lat != 0 || p.Lng != 0)
+}
+
+// FromCoordinates reads a [longitude, latitude] pair
+func FromCoordinates(coordinates []float64) (Point, bool) {
+	if len(coordinates) != 2 {
+		return Point{}, false
+	}
+	p := Point{Lat: coordinates[1], Lng: coordinates[0]}
+	return p, p.Valid()
+}
+
+// Precision says how closely a place was matched
+type Precision string
+
+const (
+	PrecisionCity  Precision = "city"
+	PrecisionState Precision = "state"
+)
+
+// Place is a geocoded location
+type Place struct {
+	City      string    `json:"city"`
+	State     string    `json:"state"`
+	Country   string    `json:"country"`
+	Point     Point     `json:"point"`
+	Precision Precision `json:"precision"`
+}
+
+// DistanceKm returns the great-circle distance between two points
+func DistanceKm(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Geocode resolves an address to a place. The city and state fields are
+// tried first; otherwise the address is scanned for a known city, then for
+// a state, whose capital is used. A city name shared by several states is
+// resolved with the state when one is given.
+func Geocode(address, cityName, stateName string) (Place, bool) {
+	st, hasState := findState(stateName)
+	if !hasState {
+		st, hasState = scanState(address)
+	}
+
+	if c, ok := findCity(cityName, st, hasState); ok {
+		return cityPlace(c), true
+	}
+	if c, ok := scanCity(address, st, hasState); ok {
+		return cityPlace(c), true
+	}
+	if hasState {
+		return Place{
+			City:      st.Capital,
+			State:     st.Name,
+			Country:   "Nigeria",
+			Point:     Point{Lat: st.Lat, Lng: st.Lng},
+			Precision: PrecisionState,
+		}, true
+	}
+	return Place{}, false
+}
+
+// Nearest returns the gazetteer city closest to a point, if one lies within
+// maxKm
+func Nearest(p Point, maxKm float64) (Place, bool) {
+	best, bestKm := -1, maxKm
+	for i, c := range cities {
+		if d := DistanceKm(p, Point{Lat: c.Lat, Lng: c.Lng}); d <= bestKm {
+			best, bestKm = i, d
+		}
+	}
+	if best < 0 {
+		return Place{}, false
+	}
+	return cityPlace(cities[best]), true
+}
+
+// States returns the state names in alphabetical order
+func States() []string {
+	names := make([]string, 0, len(states))
+	for _, st := range states {
+		names = append(names, st.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func findState(name string) (state, bool) {
+	key := normalize(name)
+	key = strings.TrimSuffix(key, " state")
+	if key == "" {
+		return state{}, false
+	}
+	for _, st := range states {
+		if normalize(st.Name) == key || strings.ReplaceAll(normalize(st.Name), " ", "") == key {
+			return st, true
+		}
+		for _, alias := range st.Aliases {
+			if alias == key {
+				return st, true
+			}
+		}
+	}
+	return state{}, false
+}
+
+func findCity(name string, st state, hasState bool) (city, bool) {
+	key := normalize(name)
+	if alias, ok := cityAliases[key]; ok {
+		key = alias
+	}
+	if key == "" {
+		return city{}, false
+	}
+
+	var match city
+	found := false
+	for _, c := range cities {
+		if normalize(c.Name) != key {
+			continue
+		}
+		if hasState && c.State == st.Name {
+			return c, true
+		}
+		if !found {
+			match, found = c, true
+		}
+	}
+	return match, found
+}
+
+// scanCity looks for the longest gazetteer city named in the address,
+// preferring cities in the given state
+func scanCity(address string, st state, hasState bool) (city, bool) {
+	text := " " + normalize(address) + " "
+	var best city
+	bestScore := 0
+	for _, c := range cities {
+		name := normalize(c.Name)
+		if !strings.Contains(text, " "+name+" ") {
+			continue
+		}
+		score := len(name) * 2
+		if hasState && c.State == st.Name {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	for alias, name := range cityAliases {
+		if len(alias) < 4 || !strings.Contains(text, " "+alias+" ") {
+			continue
+		}
+		if c, ok := findCity(name, st, hasState); ok && len(alias)*2 > bestScore {
+			best, bestScore = c, len(alias)*2
+		}
+	}
+	return best, bestScore > 0
+}
+
+// scanState looks for a state named in the address
+func scanState(address string) (state, bool) {
+	text := " " + normalize(address) + " "
+	for _, st := range states {
+		names := append([]string{normalize(st.Name)}, st.Aliases...)
+		for _, name := range names {
+			if strings.Contains(text, " "+name+" ") {
+				return st, true
+			}
+		}
+	}
+	return state{}, false
+}
+
+func cityPlace(c city) Place {
+	return Place{
+		City:      c.Name,
+		State:     c.State,
+		Country:   "Nigeria",
+		Point:     Point{Lat: c.Lat, Lng: c.Lng},
+		Precision: PrecisionCity,
+	}
+}
+
+// normalize lowercases text and collapses punctuation and spacing, so
+// "Ile-Ife," and "ile ife" compare equal
+func normalize(text string) string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
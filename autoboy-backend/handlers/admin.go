@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"autoboy-backend/config"
+	"autoboy-backend/middleware"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -16,10 +19,16 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type AdminHandler struct{}
+type AdminHandler struct {
+	searchService *services.SearchService
+	loginGuard    *services.LoginGuardService
+}
 
-func NewAdminHandler() *AdminHandler {
-	return &AdminHandler{}
+func NewAdminHandler(searchService *services.SearchService, loginGuard *services.LoginGuardService) *AdminHandler {
+	return &AdminHandler{
+		searchService: searchService,
+		loginGuard:    loginGuard,
+	}
 }
 
 // GetAdminDashboard gets admin dashboard analytics
@@ -254,23 +263,11 @@ func (h *AdminHandler) UpdateUserStatus(c *gin.Context) {
 	}
 
 	// Log admin action
-	adminUserID := c.GetString("user_id")
-	adminObjID, _ := primitive.ObjectIDFromHex(adminUserID)
-	
-	adminLog := bson.M{
-		"_id": primitive.NewObjectID(),
-		"admin_id": adminObjID,
-		"action": "update_user_status",
-		"target_user_id": userObjID,
-		"details": bson.M{
-			"old_status": "", // Would need to fetch old status first
-			"new_status": req.Status,
-			"reason": req.Reason,
-		},
-		"created_at": time.Now(),
-	}
-	
-	utils.DB.Collection("admin_logs").InsertOne(ctx, adminLog)
+	middleware.SetAdminLog(c, "update_user_status", &userObjID, bson.M{
+		"old_status": "", // Would need to fetch old status first
+		"new_status": req.Status,
+		"reason": req.Reason,
+	})
 
 	utils.SuccessResponse(c, http.StatusOK, "User status updated successfully", nil)
 }
@@ -318,13 +315,17 @@ func (h *AdminHandler) ApproveProduct(c *gin.Context) {
 
 	_, err := config.Coll.Products.UpdateOne(ctx, 
 		bson.M{"_id": productObjID},
-		bson.M{"$set": bson.M{"status": models.ProductStatusActive, "updated_at": time.Now()}},
+		bson.M{"$set": bson.M{"status": models.ProductStatusActive, "published_at": time.Now(), "updated_at": time.Now()}},
 	)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to approve product", err.Error())
 		return
 	}
 
+	if err := h.searchService.IndexProduct(ctx, productObjID); err != nil {
+		log.Printf("Failed to index product %s: %v", productID, err)
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Product approved successfully", nil)
 }
 
@@ -344,6 +345,10 @@ func (h *AdminHandler) RejectProduct(c *gin.Context) {
 		return
 	}
 
+	if err := h.searchService.IndexProduct(ctx, productObjID); err != nil {
+		log.Printf("Failed to index product %s: %v", productID, err)
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Product rejected successfully", nil)
 }
 
@@ -379,4 +384,102 @@ func (h *AdminHandler) GetSystemAnalytics(c *gin.Context) {
 	}
 
 	utils.SuccessResponse(c, http.StatusOK, "System analytics retrieved successfully", analytics)
-}
\ No newline at end of file
+}
+
+// GetLoginAttempts lists failed sign-ins, lockouts and unlocks, filtered by
+// user, IP address or action
+func (h *AdminHandler) GetLoginAttempts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := services.LoginAttemptFilter{
+		IPAddress: c.Query("ip"),
+		Action:    c.Query("action"),
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		userObjID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid user ID", nil)
+			return
+		}
+		filter.UserID = userObjID
+	}
+	if hours, _ := strconv.Atoi(c.Query("hours")); hours > 0 {
+		filter.Since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	attempts, total, err := h.loginGuard.Attempts(ctx, filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch login attempts", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Login attempts retrieved successfully", gin.H{
+		"attempts": attempts,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		},
+	})
+}
+
+// GetLoginAttemptSources ranks IP addresses by failed sign-ins over the last
+// few hours, with how many accounts each tried
+func (h *AdminHandler) GetLoginAttemptSources(c *gin.Context) {
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if hours < 1 {
+		hours = 24
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	sources, err := h.loginGuard.Sources(ctx, time.Now().Add(-time.Duration(hours)*time.Hour), limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch login attempt sources", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Login attempt sources retrieved successfully", sources)
+}
+
+// UnlockUser lifts every sign-in lock on a user's account
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid user ID", nil)
+		return
+	}
+	adminObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.loginGuard.AdminUnlock(ctx, userObjID, adminObjID, c.ClientIP(), c.Request.UserAgent())
+	if err == services.ErrUserNotFound {
+		utils.NotFoundResponse(c, "User not found")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to unlock user", err.Error())
+		return
+	}
+
+	middleware.SetAdminLog(c, "unlock_user", &userObjID, nil)
+
+	utils.SuccessResponse(c, http.StatusOK, "User unlocked successfully", nil)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autoboy-backend/middleware"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminRoleHandler manages admin staff roles and the admin action log
+type AdminRoleHandler struct {
+	roles *services.AdminRoleService
+}
+
+func NewAdminRoleHandler(roles *services.AdminRoleService) *AdminRoleHandler {
+	return &AdminRoleHandler{roles: roles}
+}
+
+type adminRoleRequest struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// GetPermissions lists every admin permission and the ones the caller holds
+func (h *AdminRoleHandler) GetPermissions(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	granted, err := middleware.AdminPermissions(ctx, user)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch permissions", err.Error())
+		return
+	}
+	mine := []string{}
+	for _, permission := range models.AdminPermissions {
+		if middleware.HasPermission(granted, permission.Name) {
+			mine = append(mine, permission.Name)
+		}
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Permissions retrieved successfully", gin.H{
+		"permissions": models.AdminPermissions,
+		"roles":       user.AdminRoles,
+		"granted":     mine,
+	})
+}
+
+// GetRoles lists staff roles
+func (h *AdminRoleHandler) GetRoles(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	roles, err := h.roles.Roles(ctx)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch roles", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Roles retrieved successfully", roles)
+}
+
+// CreateRole adds a custom staff role
+func (h *AdminRoleHandler) CreateRole(c *gin.Context) {
+	var req adminRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	role, err := h.roles.CreateRole(ctx, user, services.AdminRoleInput{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	})
+	if !handleAdminRoleError(c, err) {
+		return
+	}
+
+	middleware.SetAdminLog(c, "create_role", nil, map[string]interface{}{
+		"role":        role.Name,
+		"permissions": role.Permissions,
+	})
+	utils.CreatedResponse(c, "Role created successfully", role)
+}
+
+// UpdateRole replaces a staff role's permissions
+func (h *AdminRoleHandler) UpdateRole(c *gin.Context) {
+	var req adminRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	before, after, err := h.roles.UpdateRole(ctx, user, c.Param("name"), services.AdminRoleInput{
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	})
+	if !handleAdminRoleError(c, err) {
+		return
+	}
+
+	middleware.SetAdminLog(c, "update_role", nil, map[string]interface{}{
+		"role":            after.Name,
+		"old_permissions": before.Permissions,
+		"new_permissions": after.Permissions,
+	})
+	utils.SuccessResponse(c, http.StatusOK, "Role updated successfully", after)
+}
+
+// DeleteRole removes a custom staff role nobody holds
+func (h *AdminRoleHandler) DeleteRole(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	role, err := h.roles.DeleteRole(ctx, c.Param("name"))
+	if !handleAdminRoleError(c, err) {
+		return
+	}
+
+	middleware.SetAdminLog(c, "delete_role", nil, map[string]interface{}{
+		"role":        role.Name,
+		"permissions": role.Permissions,
+	})
+	utils.SuccessResponse(c, http.StatusOK, "Role deleted successfully", nil)
+}
+
+// GetStaff lists admin accounts with their roles
+func (h *AdminRoleHandler) GetStaff(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	staff, err := h.roles.Staff(ctx)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch staff", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Staff retrieved successfully", staff)
+}
+
+// AssignUserRoles sets the roles an admin holds
+func (h *AdminRoleHandler) AssignUserRoles(c *gin.Context) {
+	userObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid user ID", nil)
+		return
+	}
+
+	var req struct {
+		Roles []string `json:"roles" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	previous, err := h.roles.AssignRoles(ctx, user, userObjID, req.Roles)
+	if !handleAdminRoleError(c, err) {
+		return
+	}
+
+	middleware.SetAdminLog(c, "assign_roles", &userObjID, map[string]interface{}{
+		"old_roles": previous,
+		"new_roles": req.Roles,
+	})
+	utils.SuccessResponse(c, http.StatusOK, "Roles assigned successfully", nil)
+}
+
+// GetAdminLogs pages through the admin action log
+func (h *AdminRoleHandler) GetAdminLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := services.AdminLogFilter{Action: c.Query("action")}
+	if adminID := c.Query("admin_id"); adminID != "" {
+		adminObjID, err := primitive.ObjectIDFromHex(adminID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid admin ID", nil)
+			return
+		}
+		filter.AdminID = adminObjID
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		userObjID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid user ID", nil)
+			return
+		}
+		filter.TargetUserID = userObjID
+	}
+	if hours, _ := strconv.Atoi(c.Query("hours")); hours > 0 {
+		filter.Since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	logs, total, err := h.roles.Logs(ctx, filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch admin logs", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Admin logs retrieved successfully", gin.H{
+		"logs": logs,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		},
+	})
+}
+
+// handleAdminRoleError writes the error response and reports whether the request may continue
+func handleAdminRoleError(c *gin.Context, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case services.ErrAdminRoleNotFound, services.ErrUserNotFound:
+		utils.NotFoundResponse(c, err.Error())
+	case services.ErrAdminRoleExists, services.ErrAdminRoleInUse, services.ErrLastSuperAdmin:
+		utils.ConflictResponse(c, err.Error())
+	case services.ErrAdminRoleProtected, services.ErrAdminRoleBuiltIn, services.ErrPermissionEscalation:
+		utils.ForbiddenResponse(c, err.Error())
+	case services.ErrAdminRoleInvalidName, services.ErrUnknownPermission, services.ErrNotAdminUser:
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to update admin roles", err.Error())
+	}
+	return false
+}
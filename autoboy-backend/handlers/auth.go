@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"autoboy-backend/config"
@@ -20,15 +22,21 @@ import (
 
 // AuthHandler handles authentication-related requests
 type AuthHandler struct {
-	emailService *services.EmailService
-	smsService   *services.SMSService
+	emailService     *services.EmailService
+	smsService       *services.SMSService
+	twoFactorService *services.TwoFactorService
+	loginGuard       *services.LoginGuardService
+	socialLogin      *services.SocialLoginService
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(emailService *services.EmailService, smsService *services.SMSService) *AuthHandler {
+func NewAuthHandler(emailService *services.EmailService, smsService *services.SMSService, twoFactorService *services.TwoFactorService, loginGuard *services.LoginGuardService, socialLogin *services.SocialLoginService) *AuthHandler {
 	return &AuthHandler{
-		emailService: emailService,
-		smsService:   smsService,
+		emailService:     emailService,
+		smsService:       smsService,
+		twoFactorService: twoFactorService,
+		loginGuard:       loginGuard,
+		socialLogin:      socialLogin,
 	}
 }
 
@@ -160,15 +168,8 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token immediately for seamless login
-	token, err := middleware.GenerateToken(&user)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to generate token", err.Error())
-		return
-	}
-
-	// Create user session
-	err = middleware.CreateUserSession(user.ID, token, c.ClientIP(), c.Request.UserAgent())
+	// Sign the user in on this device immediately for seamless login
+	tokens, err := middleware.CreateUserSession(&user, middleware.DeviceFromRequest(c), c.ClientIP())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create session", err.Error())
 		return
@@ -219,8 +220,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			"profile":          user.Profile,
 			"created_at":       user.CreatedAt,
 		},
-		"token":      token,
-		"expires_in": 86400,
+		"token":              tokens.AccessToken,
+		"refresh_token":      tokens.RefreshToken,
+		"expires_in":         tokens.ExpiresIn,
+		"refresh_expires_in": tokens.RefreshExpiresIn,
+		"session_id":         tokens.SessionID,
 	}
 
 	utils.CreatedResponse(c, "User registered successfully. Please verify your email and phone number.", response)
@@ -241,29 +245,25 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	var user models.User
 	err := config.Coll.Users.FindOne(ctx, bson.M{"email": req.Email}).Decode(&user)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.loginGuard.RecordUnknownAccount(ctx, req.Email, c.ClientIP(), c.Request.UserAgent())
+		}
 		utils.UnauthorizedResponse(c, "Invalid email or password")
 		return
 	}
 
-	// Check if account is locked
-	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
-		utils.UnauthorizedResponse(c, "Account is temporarily locked due to multiple failed login attempts")
+	// Check if the account, or this address on it, is locked out
+	if until, err := h.loginGuard.Allow(ctx, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		lockoutResponse(c, err, until)
 		return
 	}
 
-	// Verify password
+	// Verify password; repeated failures lock for longer each time
 	if !utils.CheckPasswordHash(req.Password, user.Password) {
-		// Increment login attempts
-		attempts := user.LoginAttempts + 1
-		update := bson.M{"$set": bson.M{"login_attempts": attempts}}
-		
-		// Lock account after 5 failed attempts
-		if attempts >= 5 {
-			lockUntil := time.Now().Add(30 * time.Minute)
-			update["$set"].(bson.M)["locked_until"] = lockUntil
+		if until, err := h.loginGuard.RecordFailure(ctx, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			lockoutResponse(c, err, until)
+			return
 		}
-		
-		config.Coll.Users.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
 		utils.UnauthorizedResponse(c, "Invalid email or password")
 		return
 	}
@@ -275,64 +275,274 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Reset login attempts on successful login
-	config.Coll.Users.UpdateOne(ctx,
-		bson.M{"_id": user.ID},
-		bson.M{
-			"$set": bson.M{
-				"login_attempts": 0,
-				"last_login":     time.Now(),
-			},
-			"$unset": bson.M{"locked_until": ""},
-		},
-	)
+	h.loginGuard.RecordSuccess(ctx, &user, c.ClientIP())
+
+	h.completeSignIn(ctx, c, &user)
+}
+
+// completeSignIn signs in a user who has proved their first factor. With
+// two-factor on, it only opens a challenge for the second.
+func (h *AuthHandler) completeSignIn(ctx context.Context, c *gin.Context, user *models.User) {
+	if user.TwoFactorEnabled {
+		challenge, err := h.twoFactorService.StartLogin(ctx, user, c.ClientIP())
+		if err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to start two-factor sign-in", err.Error())
+			return
+		}
+		utils.SuccessResponse(c, http.StatusOK, "Two-factor authentication required", gin.H{
+			"two_factor_required": true,
+			"challenge":           challenge,
+		})
+		return
+	}
+
+	h.signIn(c, user)
+}
+
+// UnlockAccount lifts a sign-in lock with the link emailed when the
+// account was locked
+func (h *AuthHandler) UnlockAccount(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(&user)
+	err := h.loginGuard.Unlock(ctx, req.Token, c.ClientIP(), c.Request.UserAgent())
+	if errors.Is(err, services.ErrUnlockTokenInvalid) {
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	}
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to generate token", err.Error())
+		utils.InternalServerErrorResponse(c, "Failed to unlock account", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Account unlocked. You can sign in again", nil)
+}
+
+// lockoutResponse tells a client it is locked out of an account and when
+// to try again
+func lockoutResponse(c *gin.Context, err error, until time.Time) {
+	retryAfter := int(time.Until(until).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	utils.ErrorResponse(c, http.StatusTooManyRequests, err.Error(), gin.H{
+		"locked_until": until,
+		"retry_after":  retryAfter,
+	})
+}
+
+// VerifyLoginTwoFactor finishes signing in with the second factor for a
+// challenge from Login: a code from the user's app, an SMS code or a
+// backup code
+func (h *AuthHandler) VerifyLoginTwoFactor(c *gin.Context) {
+	var req struct {
+		ChallengeToken string                 `json:"challenge_token" binding:"required"`
+		Code           string                 `json:"code" binding:"required"`
+		Method         models.TwoFactorMethod `json:"method"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.twoFactorService.CompleteLogin(ctx, req.ChallengeToken, req.Method, req.Code)
+	if !handleTwoFactorError(c, err) {
+		return
+	}
+
+	h.signIn(c, user)
+}
+
+// SendLoginTwoFactorCode texts a code for a sign-in challenge, as a
+// fallback for users without their authenticator app or to resend one
+func (h *AuthHandler) SendLoginTwoFactorCode(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleTwoFactorError(c, h.twoFactorService.SendLoginCode(ctx, req.ChallengeToken)) {
 		return
 	}
 
-	// Create user session
-	err = middleware.CreateUserSession(user.ID, token, c.ClientIP(), c.Request.UserAgent())
+	utils.SuccessResponse(c, http.StatusOK, "Verification code sent", nil)
+}
+
+// signIn opens a session on this device for a user who has proved who they
+// are and writes the login response
+func (h *AuthHandler) signIn(c *gin.Context, user *models.User) {
+	tokens, err := middleware.CreateUserSession(user, middleware.DeviceFromRequest(c), c.ClientIP())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create session", err.Error())
 		return
 	}
 
-	// Set cookie
-	c.SetCookie("auth_token", token, 86400, "/", "", false, true)
+	// Set cookies
+	setAuthCookies(c, tokens)
 
 	// Return response
 	response := map[string]interface{}{
 		"user": map[string]interface{}{
-			"id":               user.ID.Hex(),
-			"username":         user.Username,
-			"email":            user.Email,
-			"user_type":        user.UserType,
-			"status":           user.Status,
-			"is_email_verified": user.IsEmailVerified,
-			"is_phone_verified": user.IsPhoneVerified,
-			"profile":          user.Profile,
+			"id":                 user.ID.Hex(),
+			"username":           user.Username,
+			"email":              user.Email,
+			"user_type":          user.UserType,
+			"status":             user.Status,
+			"is_email_verified":  user.IsEmailVerified,
+			"is_phone_verified":  user.IsPhoneVerified,
+			"two_factor_enabled": user.TwoFactorEnabled,
+			"profile":            user.Profile,
 		},
-		"token":      token,
-		"expires_in": 86400,
+		"token":              tokens.AccessToken,
+		"refresh_token":      tokens.RefreshToken,
+		"expires_in":         tokens.ExpiresIn,
+		"refresh_expires_in": tokens.RefreshExpiresIn,
+		"session_id":         tokens.SessionID,
 	}
 
 	utils.SuccessResponse(c, http.StatusOK, "Login successful", response)
 }
 
+// RefreshToken swaps a refresh token, from the body or the refresh_token
+// cookie, for a new access token and refresh token. A refresh token that
+// was already used signs its session out.
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request data", err.Error())
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie("refresh_token")
+	}
+	if req.RefreshToken == "" {
+		utils.BadRequestResponse(c, "Refresh token is required", nil)
+		return
+	}
+
+	tokens, err := middleware.RefreshUserSession(req.RefreshToken, middleware.DeviceFromRequest(c), c.ClientIP())
+	if errors.Is(err, middleware.ErrRefreshTokenInvalid) || errors.Is(err, middleware.ErrRefreshTokenReused) {
+		clearAuthCookies(c)
+		utils.UnauthorizedResponse(c, err.Error())
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to refresh session", err.Error())
+		return
+	}
+
+	setAuthCookies(c, tokens)
+	utils.SuccessResponse(c, http.StatusOK, "Token refreshed successfully", tokens)
+}
+
+// GetSessions lists the devices the user is signed in on
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	sessions, err := middleware.GetUserSessions(userID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get sessions", err.Error())
+		return
+	}
+	current := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.Hex() == current
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Sessions retrieved successfully", sessions)
+}
+
+// RevokeSession signs one of the user's devices out
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid session ID", nil)
+		return
+	}
+
+	err = middleware.RevokeUserSession(userID, sessionID, models.SessionRevokedByUser)
+	if errors.Is(err, middleware.ErrSessionNotFound) {
+		utils.NotFoundResponse(c, "Session not found")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to revoke session", err.Error())
+		return
+	}
+	if sessionID.Hex() == c.GetString("session_id") {
+		clearAuthCookies(c)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Session revoked successfully", nil)
+}
+
+// RevokeSessions signs the user out everywhere else, or everywhere with
+// ?include_current=true
+func (h *AuthHandler) RevokeSessions(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	includeCurrent := c.Query("include_current") == "true"
+
+	var keep primitive.ObjectID
+	if !includeCurrent {
+		keep, _ = primitive.ObjectIDFromHex(c.GetString("session_id"))
+	}
+	revoked, err := middleware.RevokeUserSessions(userID, keep, models.SessionRevokedByUser)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to revoke sessions", err.Error())
+		return
+	}
+	if includeCurrent {
+		clearAuthCookies(c)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Sessions revoked successfully", gin.H{
+		"revoked": revoked,
+	})
+}
+
+// setAuthCookies stores a token pair for browser clients. The refresh token
+// cookie is only sent to the auth endpoints.
+func setAuthCookies(c *gin.Context, tokens *middleware.TokenPair) {
+	c.SetCookie("auth_token", tokens.AccessToken, tokens.ExpiresIn, "/", "", false, true)
+	c.SetCookie("refresh_token", tokens.RefreshToken, tokens.RefreshExpiresIn, "/api/v1/auth", "", false, true)
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie("auth_token", "", -1, "/", "", false, true)
+	c.SetCookie("refresh_token", "", -1, "/api/v1/auth", "", false, true)
+}
+
 // Logout handles user logout
 func (h *AuthHandler) Logout(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	token, _ := c.Get("token")
 
 	if userID != nil && token != nil {
-		middleware.InvalidateUserSession(userID.(string), token.(string))
+		middleware.InvalidateUserSession(userID.(string), c.GetString("session_id"), token.(string))
 	}
 
-	// Clear cookie
-	c.SetCookie("auth_token", "", -1, "/", "", false, true)
+	// Clear cookies
+	clearAuthCookies(c)
 
 	utils.SuccessResponse(c, http.StatusOK, "Logout successful", nil)
 }
@@ -544,10 +754,14 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 			"$set": bson.M{
 				"password": hashedPassword,
 				"updated_at": time.Now(),
+				"login_attempts": 0,
 			},
 			"$unset": bson.M{
 				"password_reset_token": "",
 				"password_reset_expires": "",
+				"locked_until": "",
+				"unlock_token_hash": "",
+				"unlock_token_expiry": "",
 			},
 		},
 	)
@@ -557,6 +771,11 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	// Whoever knew the old password is signed out everywhere
+	if _, err := middleware.RevokeUserSessions(user.ID, primitive.NilObjectID, models.SessionRevokedPasswordChange); err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", user.ID.Hex(), err)
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Password reset successfully", nil)
 }
 
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"time"
 
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -11,10 +15,12 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type BuyerDashboardHandler struct{}
+type BuyerDashboardHandler struct {
+	recommendationService *services.RecommendationService
+}
 
-func NewBuyerDashboardHandler() *BuyerDashboardHandler {
-	return &BuyerDashboardHandler{}
+func NewBuyerDashboardHandler(recommendationService *services.RecommendationService) *BuyerDashboardHandler {
+	return &BuyerDashboardHandler{recommendationService: recommendationService}
 }
 
 // GetBuyerDashboard gets buyer dashboard data
@@ -39,6 +45,14 @@ func (h *BuyerDashboardHandler) GetBuyerDashboard(c *gin.Context) {
 	// Calculate total spent (mock for now)
 	totalSpent := float64(completedOrders) * 125000
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	recommendations, err := h.recommendationService.ForUser(ctx, userObjID, 10)
+	if err != nil {
+		log.Printf("Failed to load recommendations for %s: %v", userID, err)
+		recommendations = []services.RecommendedProduct{}
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Buyer dashboard data retrieved", gin.H{
 		"total_orders":     totalOrders,
 		"active_orders":    activeOrders,
@@ -46,7 +60,7 @@ func (h *BuyerDashboardHandler) GetBuyerDashboard(c *gin.Context) {
 		"wishlist_count":   wishlistCount,
 		"total_spent":      totalSpent,
 		"recent_activity":  []interface{}{},
-		"recommendations":  []interface{}{},
+		"recommendations":  recommendations,
 	})
 }
 
@@ -74,4 +88,19 @@ func (h *BuyerDashboardHandler) GetBuyerRecentActivity(c *gin.Context) {
 		"recent_reviews": []interface{}{}, // TODO: Implement when review system is ready
 		"recent_wishlist": []interface{}{}, // TODO: Implement
 	})
-}
\ No newline at end of file
+}
+// GetBuyerRecommendations gets the buyer's "recommended for you" feed
+func (h *BuyerDashboardHandler) GetBuyerRecommendations(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	recommendations, err := h.recommendationService.ForUser(ctx, userObjID, recommendationLimit(c))
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get recommendations", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Recommendations retrieved", recommendations)
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -14,10 +16,12 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type CartHandler struct{}
+type CartHandler struct {
+	promos *services.PromoService
+}
 
-func NewCartHandler() *CartHandler {
-	return &CartHandler{}
+func NewCartHandler(promos *services.PromoService) *CartHandler {
+	return &CartHandler{promos: promos}
 }
 
 type CartItem struct {
@@ -32,17 +36,6 @@ type CartItem struct {
 	Product       *models.Product    `bson:"product,omitempty" json:"product,omitempty"`
 }
 
-type PromoCode struct {
-	Code        string    `bson:"code" json:"code"`
-	Type        string    `bson:"type" json:"type"` // percentage, fixed, shipping
-	Discount    float64   `bson:"discount" json:"discount"`
-	Description string    `bson:"description" json:"description"`
-	MinAmount   float64   `bson:"min_amount" json:"min_amount"`
-	MaxDiscount float64   `bson:"max_discount" json:"max_discount"`
-	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
-	IsActive    bool      `bson:"is_active" json:"is_active"`
-}
-
 func (h *CartHandler) GetCart(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	userObjID, _ := primitive.ObjectIDFromHex(userID.(string))
@@ -531,7 +524,9 @@ func (h *CartHandler) GetAbandonedCarts(c *gin.Context) {
 	utils.SuccessResponse(c, http.StatusOK, "Abandoned carts retrieved", response)
 }
 
-// ApplyPromoCode applies a promo code to the cart
+// ApplyPromoCode prices a promo code against the cart. The code itself is
+// sent again with the checkout; shipping codes are priced there, once the
+// delivery fee is known.
 func (h *CartHandler) ApplyPromoCode(c *gin.Context) {
 	var req struct {
 		Code string `json:"code" binding:"required"`
@@ -545,19 +540,11 @@ func (h *CartHandler) ApplyPromoCode(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	userObjID, _ := primitive.ObjectIDFromHex(userID.(string))
 
-	// Validate promo code
-	promoCode := h.validatePromoCode(req.Code)
-	if promoCode == nil {
-		utils.BadRequestResponse(c, "Invalid or expired promo code", nil)
-		return
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Get cart total
 	pipeline := []bson.M{
-		{"$match": bson.M{"user_id": userObjID}},
+		{"$match": bson.M{"user_id": userObjID, "saved_for_later": bson.M{"$ne": true}}},
 		{"$lookup": bson.M{
 			"from":         "products",
 			"localField":   "product_id",
@@ -565,106 +552,51 @@ func (h *CartHandler) ApplyPromoCode(c *gin.Context) {
 			"as":           "product",
 		}},
 		{"$unwind": "$product"},
-		{"$group": bson.M{
-			"_id": nil,
-			"subtotal": bson.M{"$sum": bson.M{"$multiply": []interface{}{"$price", "$quantity"}}},
-		}},
 	}
 
 	cursor, err := config.Coll.CartItems.Aggregate(ctx, pipeline)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to calculate cart total", err.Error())
+		utils.InternalServerErrorResponse(c, "Failed to load cart", err.Error())
 		return
 	}
 	defer cursor.Close(ctx)
 
-	var result []bson.M
-	cursor.All(ctx, &result)
-
-	if len(result) == 0 {
+	var items []CartItem
+	if err := cursor.All(ctx, &items); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to load cart", err.Error())
+		return
+	}
+	if len(items) == 0 {
 		utils.BadRequestResponse(c, "Cart is empty", nil)
 		return
 	}
 
-	subtotal := result[0]["subtotal"].(float64)
-
-	// Check minimum amount
-	if subtotal < promoCode.MinAmount {
-		utils.BadRequestResponse(c, "Minimum order amount not met", gin.H{
-			"required": promoCode.MinAmount,
-			"current": subtotal,
+	lines := make([]services.PromoLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, services.PromoLine{
+			ProductID:  item.ProductID,
+			CategoryID: item.Product.CategoryID,
+			Amount:     item.Price * float64(item.Quantity),
 		})
-		return
 	}
 
-	// Calculate discount
-	discount := h.calculateDiscount(subtotal, promoCode)
+	promo, discount, err := h.promos.Quote(ctx, req.Code, userObjID, items[0].Product.Currency, lines, 0)
+	switch {
+	case errors.Is(err, services.ErrPromoInvalid):
+		utils.BadRequestResponse(c, "Invalid or expired promo code", nil)
+		return
+	case errors.Is(err, services.ErrPromoNotApplicable):
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	case err != nil:
+		utils.InternalServerErrorResponse(c, "Failed to apply promo code", err.Error())
+		return
+	}
 
-	// Store promo code application (you might want to create a separate collection)
 	utils.SuccessResponse(c, http.StatusOK, "Promo code applied successfully", gin.H{
-		"code": promoCode.Code,
-		"discount": discount,
-		"description": promoCode.Description,
+		"code":        promo.Code,
+		"type":        promo.Type,
+		"discount":    discount,
+		"description": promo.Description,
 	})
-}
-
-// Helper function to validate promo code
-func (h *CartHandler) validatePromoCode(code string) *PromoCode {
-	promoCodes := map[string]*PromoCode{
-		"SAVE10": {
-			Code:        "SAVE10",
-			Type:        "percentage",
-			Discount:    0.1,
-			Description: "10% off your order",
-			MinAmount:   10000,
-			MaxDiscount: 50000,
-			ExpiresAt:   time.Now().AddDate(0, 1, 0),
-			IsActive:    true,
-		},
-		"WELCOME": {
-			Code:        "WELCOME",
-			Type:        "fixed",
-			Discount:    5000,
-			Description: "₦5,000 off your first order",
-			MinAmount:   20000,
-			MaxDiscount: 5000,
-			ExpiresAt:   time.Now().AddDate(0, 1, 0),
-			IsActive:    true,
-		},
-		"FREESHIP": {
-			Code:        "FREESHIP",
-			Type:        "shipping",
-			Discount:    0,
-			Description: "Free shipping",
-			MinAmount:   0,
-			MaxDiscount: 2500,
-			ExpiresAt:   time.Now().AddDate(0, 1, 0),
-			IsActive:    true,
-		},
-	}
-
-	promoCode, exists := promoCodes[code]
-	if !exists || !promoCode.IsActive || time.Now().After(promoCode.ExpiresAt) {
-		return nil
-	}
-
-	return promoCode
-}
-
-// Helper function to calculate discount
-func (h *CartHandler) calculateDiscount(subtotal float64, promoCode *PromoCode) float64 {
-	switch promoCode.Type {
-	case "percentage":
-		discount := subtotal * promoCode.Discount
-		if promoCode.MaxDiscount > 0 && discount > promoCode.MaxDiscount {
-			return promoCode.MaxDiscount
-		}
-		return discount
-	case "fixed":
-		return promoCode.Discount
-	case "shipping":
-		return promoCode.MaxDiscount // shipping cost
-	default:
-		return 0
-	}
 }
\ No newline at end of file
@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -14,10 +18,18 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type CategoryHandler struct{}
+type CategoryHandler struct {
+	searchService    *services.SearchService
+	rankingService   *services.RankingService
+	lifecycleService *services.ListingLifecycleService
+}
 
-func NewCategoryHandler() *CategoryHandler {
-	return &CategoryHandler{}
+func NewCategoryHandler(searchService *services.SearchService, rankingService *services.RankingService, lifecycleService *services.ListingLifecycleService) *CategoryHandler {
+	return &CategoryHandler{
+		searchService:    searchService,
+		rankingService:   rankingService,
+		lifecycleService: lifecycleService,
+	}
 }
 
 func (h *CategoryHandler) GetCategories(c *gin.Context) {
@@ -95,8 +107,9 @@ func (h *CategoryHandler) GetCategories(c *gin.Context) {
 
 		// Insert default categories
 		var docs []interface{}
-		for _, cat := range defaultCategories {
-			docs = append(docs, cat)
+		for i := range defaultCategories {
+			defaultCategories[i].Attributes = services.DefaultCategoryAttributes(defaultCategories[i].Slug)
+			docs = append(docs, defaultCategories[i])
 		}
 		config.Coll.Categories.InsertMany(ctx, docs)
 		categories = defaultCategories
@@ -128,4 +141,293 @@ func (h *CategoryHandler) GetCategory(c *gin.Context) {
 	}
 
 	utils.SuccessResponse(c, http.StatusOK, "Category retrieved successfully", category)
-}
\ No newline at end of file
+}
+
+// GetCategoryAttributes returns the attribute schema listings in a category
+// are validated against
+func (h *CategoryHandler) GetCategoryAttributes(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid category ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": objID}).Decode(&category); err != nil {
+		utils.NotFoundResponse(c, "Category not found")
+		return
+	}
+
+	attributes := category.Attributes
+	if attributes == nil {
+		attributes = []models.CategoryAttribute{}
+	}
+	utils.SuccessResponse(c, http.StatusOK, "Category attributes retrieved successfully", attributes)
+}
+
+// SetCategoryAttributes replaces a category's attribute schema (admin)
+func (h *CategoryHandler) SetCategoryAttributes(c *gin.Context) {
+	var req struct {
+		Attributes []models.CategoryAttribute `json:"attributes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	h.saveAttributes(c, func([]models.CategoryAttribute) []models.CategoryAttribute {
+		return req.Attributes
+	})
+}
+
+// UpsertCategoryAttribute adds an attribute to a category's schema, or
+// replaces the one with the same name (admin)
+func (h *CategoryHandler) UpsertCategoryAttribute(c *gin.Context) {
+	var attr models.CategoryAttribute
+	if err := c.ShouldBindJSON(&attr); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if name := c.Param("name"); name != "" {
+		attr.Name = name
+	}
+
+	h.saveAttributes(c, func(current []models.CategoryAttribute) []models.CategoryAttribute {
+		updated := make([]models.CategoryAttribute, 0, len(current)+1)
+		replaced := false
+		for _, existing := range current {
+			if strings.EqualFold(existing.Name, strings.TrimSpace(attr.Name)) {
+				existing, replaced = attr, true
+			}
+			updated = append(updated, existing)
+		}
+		if !replaced {
+			updated = append(updated, attr)
+		}
+		return updated
+	})
+}
+
+// DeleteCategoryAttribute removes an attribute from a category's schema.
+// Listings keep the value as a free-form specification. (admin)
+func (h *CategoryHandler) DeleteCategoryAttribute(c *gin.Context) {
+	name := c.Param("name")
+	h.saveAttributes(c, func(current []models.CategoryAttribute) []models.CategoryAttribute {
+		updated := make([]models.CategoryAttribute, 0, len(current))
+		for _, existing := range current {
+			if !strings.EqualFold(existing.Name, name) {
+				updated = append(updated, existing)
+			}
+		}
+		return updated
+	})
+}
+
+// saveAttributes applies a change to a category's attribute schema,
+// validates the result and reindexes the category's listings so search
+// facets follow the new schema
+func (h *CategoryHandler) saveAttributes(c *gin.Context, change func([]models.CategoryAttribute) []models.CategoryAttribute) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid category ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": objID}).Decode(&category); err != nil {
+		utils.NotFoundResponse(c, "Category not found")
+		return
+	}
+
+	attributes, err := services.ValidateAttributeSchema(change(category.Attributes))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAttributeSchema) {
+			utils.BadRequestResponse(c, "Invalid attribute schema", err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to update attributes", err.Error())
+		return
+	}
+
+	_, err = config.Coll.Categories.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{
+			"attributes": attributes,
+			"updated_at": time.Now(),
+		},
+	})
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update attributes", err.Error())
+		return
+	}
+
+	if _, err := h.searchService.IndexCategory(ctx, objID); err != nil {
+		log.Printf("Failed to reindex category %s: %v", objID.Hex(), err)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Category attributes updated successfully", attributes)
+}
+
+// GetCategoryRanking returns a category's ranking threshold overrides and
+// the thresholds the ranking job applies (admin)
+func (h *CategoryHandler) GetCategoryRanking(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid category ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": objID}).Decode(&category); err != nil {
+		utils.NotFoundResponse(c, "Category not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Category ranking retrieved successfully", gin.H{
+		"overrides": category.Ranking,
+		"effective": h.rankingService.Thresholds(category.Ranking),
+		"defaults":  h.rankingService.Defaults(),
+	})
+}
+
+// SetCategoryRanking replaces a category's ranking threshold overrides;
+// zero fields fall back to the defaults (admin). The flags change on the
+// ranking job's next run.
+func (h *CategoryHandler) SetCategoryRanking(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid category ID", nil)
+		return
+	}
+
+	var ranking models.CategoryRanking
+	if err := c.ShouldBindJSON(&ranking); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	problems := make(map[string]string)
+	if ranking.HotScore < 0 {
+		problems["hot_score"] = "must not be negative"
+	}
+	if ranking.TrendingScore < 0 {
+		problems["trending_score"] = "must not be negative"
+	}
+	if ranking.TrendingPercent < 0 || ranking.TrendingPercent > 100 {
+		problems["trending_percent"] = "must be between 0 and 100"
+	}
+	if ranking.NewDays < 0 {
+		problems["new_days"] = "must not be negative"
+	}
+	if len(problems) > 0 {
+		utils.ValidationErrorResponse(c, problems)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"ranking": ranking, "updated_at": time.Now()}}
+	if ranking == (models.CategoryRanking{}) {
+		update = bson.M{"$unset": bson.M{"ranking": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	result, err := config.Coll.Categories.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update category ranking", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		utils.NotFoundResponse(c, "Category not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Category ranking updated successfully", gin.H{
+		"overrides": ranking,
+		"effective": h.rankingService.Thresholds(&ranking),
+	})
+}
+
+// GetCategoryLifecycle returns a category's listing lifetime overrides and
+// the lifetimes listings in it get, per seller tier (admin)
+func (h *CategoryHandler) GetCategoryLifecycle(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid category ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": objID}).Decode(&category); err != nil {
+		utils.NotFoundResponse(c, "Category not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Category lifecycle retrieved successfully", gin.H{
+		"overrides": category.Lifecycle,
+		"effective": h.lifecycleService.ListingDays(category.Lifecycle),
+		"defaults":  h.lifecycleService.Defaults(),
+	})
+}
+
+// SetCategoryLifecycle replaces a category's listing lifetime overrides;
+// tiers left out fall back to the defaults (admin). Listings already live
+// keep their expiry until renewed or relisted.
+func (h *CategoryHandler) SetCategoryLifecycle(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid category ID", nil)
+		return
+	}
+
+	var lifecycle models.CategoryLifecycle
+	if err := c.ShouldBindJSON(&lifecycle); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	problems := make(map[string]string)
+	for tier, days := range lifecycle.ListingDays {
+		if !services.ValidPremiumTier(tier) {
+			problems["listing_days."+string(tier)] = "is not a seller tier"
+		} else if days < 0 || days > 365 {
+			problems["listing_days."+string(tier)] = "must be between 0 and 365"
+		} else if days == 0 {
+			delete(lifecycle.ListingDays, tier)
+		}
+	}
+	if len(problems) > 0 {
+		utils.ValidationErrorResponse(c, problems)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"lifecycle": lifecycle, "updated_at": time.Now()}}
+	if len(lifecycle.ListingDays) == 0 {
+		update = bson.M{"$unset": bson.M{"lifecycle": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	result, err := config.Coll.Categories.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update category lifecycle", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		utils.NotFoundResponse(c, "Category not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Category lifecycle updated successfully", gin.H{
+		"overrides": lifecycle,
+		"effective": h.lifecycleService.ListingDays(&lifecycle),
+	})
+}
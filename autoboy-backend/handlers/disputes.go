@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
+	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -11,10 +17,18 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type DisputeHandler struct{}
+type DisputeHandler struct {
+	escrowService *services.EscrowService
+	refundService *services.RefundService
+	orderService  *services.OrderService
+}
 
-func NewDisputeHandler() *DisputeHandler {
-	return &DisputeHandler{}
+func NewDisputeHandler(escrowService *services.EscrowService, refundService *services.RefundService, orderService *services.OrderService) *DisputeHandler {
+	return &DisputeHandler{
+		escrowService: escrowService,
+		refundService: refundService,
+		orderService:  orderService,
+	}
 }
 
 // GetDisputes gets user's disputes
@@ -63,24 +77,80 @@ func (h *DisputeHandler) CreateDispute(c *gin.Context) {
 		return
 	}
 
-	orderObjID, _ := primitive.ObjectIDFromHex(req.OrderID)
+	orderObjID, err := primitive.ObjectIDFromHex(req.OrderID)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var order models.Order
+	if err := config.Coll.Orders.FindOne(ctx, bson.M{"_id": orderObjID, "buyer_id": userObjID}).Decode(&order); err != nil {
+		utils.NotFoundResponse(c, "Order not found")
+		return
+	}
+
+	if order.PaymentStatus != models.PaymentStatusPaid {
+		utils.BadRequestResponse(c, "Only paid orders can be disputed", nil)
+		return
+	}
 
+	if !services.CanTransition(order.Status, models.OrderStatusDisputed, services.OrderActorBuyer) {
+		utils.BadRequestResponse(c, "This order cannot be disputed in its current status", nil)
+		return
+	}
+
+	openCount, _ := utils.DB.Collection("disputes").CountDocuments(ctx, bson.M{
+		"order_id": orderObjID,
+		"status":   bson.M{"$in": []models.DisputeStatus{models.DisputeStatusOpen, models.DisputeStatusUnderReview, models.DisputeStatusEscalated}},
+	})
+	if openCount > 0 {
+		utils.ConflictResponse(c, "An open dispute already exists for this order")
+		return
+	}
+
+	now := time.Now()
 	dispute := models.Dispute{
-		ID:          primitive.NewObjectID(),
-		OrderID:     orderObjID,
-		BuyerID:     userObjID,
-		Reason:      models.DisputeReason(req.Reason),
-		Description: req.Description,
-		Status:      models.DisputeStatusOpen,
-		CreatedAt:   utils.GetCurrentTime(),
+		ID:             primitive.NewObjectID(),
+		OrderID:        orderObjID,
+		BuyerID:        userObjID,
+		SellerID:       order.SellerID,
+		Reason:         models.DisputeReason(req.Reason),
+		Description:    req.Description,
+		Status:         models.DisputeStatusOpen,
+		DisputedAmount: order.TotalAmount,
+		Priority:       3,
+		LastActivity:   now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if len(order.Items) > 0 {
+		dispute.ProductID = order.Items[0].ProductID
 	}
 
-	_, err := utils.DB.Collection("disputes").InsertOne(c, dispute)
+	_, err = utils.DB.Collection("disputes").InsertOne(ctx, dispute)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create dispute", err.Error())
 		return
 	}
 
+	// Freeze escrow so funds cannot be released while the dispute is open
+	if err := h.escrowService.FreezeForDispute(ctx, orderObjID, dispute.ID); err != nil && err != services.ErrEscrowNotFound {
+		log.Printf("Failed to freeze escrow for order %s: %v", order.OrderNumber, err)
+	}
+
+	if _, err := h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: orderObjID,
+		To:      models.OrderStatusDisputed,
+		Actor:   services.OrderActorBuyer,
+		ActorID: userObjID,
+		Note:    "Dispute opened: " + req.Reason,
+	}); err != nil {
+		log.Printf("Failed to mark order %s disputed: %v", order.OrderNumber, err)
+	}
+
 	utils.SuccessResponse(c, http.StatusCreated, "Dispute created successfully", gin.H{
 		"dispute_id": dispute.ID,
 	})
@@ -151,28 +221,98 @@ func (h *DisputeHandler) ResolveDispute(c *gin.Context) {
 		Resolution   string  `json:"resolution" binding:"required"`
 		AdminNotes   string  `json:"admin_notes"`
 		RefundAmount float64 `json:"refund_amount"`
+		RefundTo     string  `json:"refund_to" binding:"omitempty,oneof=original wallet"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":        "resolved",
-			"resolution":    req.Resolution,
-			"admin_notes":   req.AdminNotes,
-			"refund_amount": req.RefundAmount,
-			"resolved_at":   utils.GetCurrentTime(),
-			"updated_at":    utils.GetCurrentTime(),
-		},
+	if req.RefundAmount < 0 {
+		utils.BadRequestResponse(c, "Refund amount cannot be negative", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var dispute models.Dispute
+	if err := utils.DB.Collection("disputes").FindOne(ctx, bson.M{"_id": objID}).Decode(&dispute); err != nil {
+		utils.NotFoundResponse(c, "Dispute not found")
+		return
+	}
+
+	if dispute.Status == models.DisputeStatusResolved || dispute.Status == models.DisputeStatusClosed {
+		utils.BadRequestResponse(c, "Dispute is already resolved", nil)
+		return
 	}
 
-	_, err = utils.DB.Collection("disputes").UpdateOne(c, bson.M{"_id": objID}, update)
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	// Refund the buyer's share, then release whatever is left to the seller
+	refunded := false
+	if req.RefundAmount > 0 {
+		refundTo := models.RefundDestination(req.RefundTo)
+		if refundTo == "" {
+			refundTo = models.RefundDestinationWallet
+		}
+		_, err := h.refundService.Refund(ctx, services.RefundRequest{
+			OrderID:     dispute.OrderID,
+			Type:        models.RefundTypePartial,
+			Amount:      req.RefundAmount,
+			Destination: refundTo,
+			Reason:      req.Resolution,
+			RequestedBy: adminID,
+			DisputeID:   &dispute.ID,
+		})
+		if !handleRefundError(c, err) {
+			return
+		}
+		refunded = true
+	}
+
+	err = h.escrowService.SettleDispute(ctx, dispute.OrderID, 0, adminID, req.Resolution)
+	switch {
+	case err == nil, err == services.ErrEscrowNotFound:
+	case err == services.ErrEscrowNotHeld && refunded:
+		// The refund used up the escrow
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to settle escrow", err.Error())
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"status":        models.DisputeStatusResolved,
+		"resolution":    req.Resolution,
+		"admin_notes":   req.AdminNotes,
+		"refund_amount": req.RefundAmount,
+		"resolved_by":   adminID,
+		"resolved_at":   now,
+		"updated_at":    now,
+	}
+	if req.RefundAmount > 0 {
+		set["refunded_at"] = now
+	}
+
+	_, err = utils.DB.Collection("disputes").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": set})
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to resolve dispute", err.Error())
 		return
 	}
 
+	// A full refund has already moved the order on; otherwise the settled
+	// order is complete
+	_, err = h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: dispute.OrderID,
+		To:      models.OrderStatusCompleted,
+		Actor:   services.OrderActorAdmin,
+		ActorID: adminID,
+		Note:    "Dispute resolved: " + req.Resolution,
+	})
+	if err != nil && !errors.Is(err, services.ErrOrderTransition) {
+		log.Printf("Failed to complete order %s after dispute: %v", dispute.OrderID.Hex(), err)
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Dispute resolved successfully", nil)
 }
\ No newline at end of file
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type EscrowHandler struct {
+	escrowService *services.EscrowService
+}
+
+func NewEscrowHandler(escrowService *services.EscrowService) *EscrowHandler {
+	return &EscrowHandler{
+		escrowService: escrowService,
+	}
+}
+
+// GetEscrows lists escrows for admins, optionally filtered by status
+func (h *EscrowHandler) GetEscrows(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.Query("status")
+
+	filter := bson.M{}
+	if status != "" && status != "all" {
+		filter["status"] = status
+	}
+	if disputed := c.Query("disputed"); disputed != "" {
+		filter["is_disputed"] = disputed == "true"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := config.Coll.Escrows.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count escrows", err.Error())
+		return
+	}
+
+	page, limit, totalPages, offset := utils.CalculatePagination(page, limit, total)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := config.Coll.Escrows.Find(ctx, filter, opts)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch escrows", err.Error())
+		return
+	}
+	defer cursor.Close(ctx)
+
+	escrows := []models.Escrow{}
+	if err := cursor.All(ctx, &escrows); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to decode escrows", err.Error())
+		return
+	}
+
+	utils.SuccessResponseWithMeta(c, http.StatusOK, "Escrows retrieved successfully", escrows, &utils.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// ReleaseEscrow releases an escrow to the seller. When an amount is given
+// only that part is released and the remainder stays held.
+func (h *EscrowHandler) ReleaseEscrow(c *gin.Context) {
+	escrowID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid escrow ID", nil)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if req.Amount > 0 {
+		err = h.escrowService.PartialRelease(ctx, escrowID, req.Amount, adminID, req.Reason)
+	} else {
+		err = h.escrowService.Release(ctx, escrowID, adminID, req.Reason)
+	}
+	if !h.handleEscrowError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Escrow released successfully", nil)
+}
+
+// RefundEscrow returns escrowed funds to the buyer's wallet
+func (h *EscrowHandler) RefundEscrow(c *gin.Context) {
+	escrowID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid escrow ID", nil)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.escrowService.Refund(ctx, escrowID, req.Amount, adminID, req.Reason)
+	if !h.handleEscrowError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Escrow refunded successfully", nil)
+}
+
+// handleEscrowError writes the error response and reports whether the request may continue
+func (h *EscrowHandler) handleEscrowError(c *gin.Context, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case services.ErrEscrowNotFound:
+		utils.NotFoundResponse(c, "Escrow not found")
+	case services.ErrEscrowNotHeld, services.ErrEscrowAmount:
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Escrow operation failed", err.Error())
+	}
+	return false
+}
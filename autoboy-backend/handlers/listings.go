@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListingHandler lets sellers renew, auto-relist and bump their listings
+type ListingHandler struct {
+	lifecycleService *services.ListingLifecycleService
+}
+
+func NewListingHandler(lifecycleService *services.ListingLifecycleService) *ListingHandler {
+	return &ListingHandler{lifecycleService: lifecycleService}
+}
+
+// RenewListing gives a listing a fresh lifetime, putting an expired or sold
+// listing that has stock back on sale
+func (h *ListingHandler) RenewListing(c *gin.Context) {
+	sellerID, productID, ok := listingIDs(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	product, err := h.lifecycleService.Renew(ctx, sellerID, productID)
+	if !handleListingError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Listing renewed successfully", gin.H{
+		"id":         product.ID,
+		"status":     product.Status,
+		"expires_at": product.ExpiresAt,
+	})
+}
+
+// SetAutoRelist turns automatic relisting on expiry on or off
+func (h *ListingHandler) SetAutoRelist(c *gin.Context) {
+	sellerID, productID, ok := listingIDs(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleListingError(c, h.lifecycleService.SetAutoRelist(ctx, sellerID, productID, *req.Enabled)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Auto-relist updated successfully", gin.H{
+		"auto_relist": *req.Enabled,
+	})
+}
+
+// BumpListing raises a listing's boost level for a number of days, paid
+// from the seller's wallet or from their free monthly bumps
+func (h *ListingHandler) BumpListing(c *gin.Context) {
+	sellerID, productID, ok := listingIDs(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Level int  `json:"level" binding:"required"`
+		Days  int  `json:"days" binding:"required"`
+		Free  bool `json:"free"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	bump, err := h.lifecycleService.Bump(ctx, sellerID, productID, services.BumpRequest{
+		Level: req.Level,
+		Days:  req.Days,
+		Free:  req.Free,
+	})
+	if !handleListingError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, "Listing bumped successfully", bump)
+}
+
+// GetBumps returns bump pricing, the seller's free bumps left this month
+// and their recent bumps
+func (h *ListingHandler) GetBumps(c *gin.Context) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	options, err := h.lifecycleService.BumpOptions(ctx, sellerID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get bump options", err.Error())
+		return
+	}
+	bumps, err := h.lifecycleService.Bumps(ctx, sellerID, 50)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get bumps", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Bumps retrieved successfully", gin.H{
+		"options": options,
+		"bumps":   bumps,
+	})
+}
+
+// listingIDs reads the signed-in seller and the listing in the path
+func listingIDs(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid product ID", nil)
+		return sellerID, productID, false
+	}
+	return sellerID, productID, true
+}
+
+// handleListingError writes the response for a lifecycle service error and
+// reports whether the request may continue
+func handleListingError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrListingNotFound):
+		utils.NotFoundResponse(c, "Product not found")
+	case errors.Is(err, services.ErrListingNotActive),
+		errors.Is(err, services.ErrListingNotRenewable),
+		errors.Is(err, services.ErrListingOutOfStock),
+		errors.Is(err, services.ErrInvalidBump),
+		errors.Is(err, services.ErrNoFreeBumps),
+		errors.Is(err, services.ErrBumpDowngrade),
+		errors.Is(err, services.ErrInsufficientBalance):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to update listing", err.Error())
+	}
+	return false
+}
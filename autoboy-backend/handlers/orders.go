@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -18,17 +20,26 @@ import (
 )
 
 type OrderHandler struct {
-	paymentService *services.PaymentService
-	emailService   *services.EmailService
+	paymentService  *services.PaymentService
+	emailService    *services.EmailService
+	checkoutService  *services.CheckoutService
+	orderService     *services.OrderService
+	refundService    *services.RefundService
 }
 
-func NewOrderHandler(paymentService *services.PaymentService, emailService *services.EmailService) *OrderHandler {
+func NewOrderHandler(paymentService *services.PaymentService, emailService *services.EmailService, checkoutService *services.CheckoutService, orderService *services.OrderService, refundService *services.RefundService) *OrderHandler {
 	return &OrderHandler{
-		paymentService: paymentService,
-		emailService:   emailService,
+		paymentService:   paymentService,
+		emailService:     emailService,
+		checkoutService:  checkoutService,
+		orderService:     orderService,
+		refundService:    refundService,
 	}
 }
 
+// CreateOrder places the given items. Items from several sellers are split
+// into one order per seller under a shared checkout; single-seller purchases
+// still respond with the order itself.
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	var req struct {
 		Items []struct {
@@ -37,6 +48,7 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		} `json:"items" binding:"required,min=1"`
 		ShippingAddress models.Address `json:"shipping_address" binding:"required"`
 		PaymentMethod   string         `json:"payment_method" binding:"required"`
+		PromoCode       string         `json:"promo_code"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -47,126 +59,158 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	buyerID, _ := primitive.ObjectIDFromHex(userID.(string))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	// Calculate order total and validate products
-	var orderItems []models.OrderItem
-	var subtotal float64
-	var sellerID primitive.ObjectID
-
+	var items []services.CheckoutItem
 	for _, item := range req.Items {
 		productObjID, err := primitive.ObjectIDFromHex(item.ProductID)
 		if err != nil {
 			utils.BadRequestResponse(c, "Invalid product ID", nil)
 			return
 		}
+		items = append(items, services.CheckoutItem{ProductID: productObjID, Quantity: item.Quantity})
+	}
 
-		var product models.Product
-		err = config.Coll.Products.FindOne(ctx, bson.M{
-			"_id":    productObjID,
-			"status": models.ProductStatusActive,
-		}).Decode(&product)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-		if err != nil {
-			utils.BadRequestResponse(c, "Product not found or inactive", nil)
-			return
-		}
+	checkout, orders, ok := h.checkout(ctx, c, services.CheckoutRequest{
+		BuyerID:         buyerID,
+		Items:           items,
+		ShippingAddress: req.ShippingAddress,
+		PaymentMethod:   req.PaymentMethod,
+		PromoCode:       req.PromoCode,
+	})
+	if !ok {
+		return
+	}
 
-		if product.Quantity < item.Quantity {
-			utils.BadRequestResponse(c, "Insufficient product quantity", nil)
-			return
-		}
+	if len(orders) == 1 {
+		utils.CreatedResponse(c, "Order created successfully", orders[0])
+		return
+	}
+	utils.CreatedResponse(c, "Orders created successfully", checkout)
+}
 
-		// Set seller ID from first product (assuming single seller per order)
-		if len(orderItems) == 0 {
-			sellerID = product.SellerID
-		} else if sellerID != product.SellerID {
-			utils.BadRequestResponse(c, "Cannot order from multiple sellers in one order", nil)
-			return
-		}
+// CheckoutCart places the buyer's whole cart in one go, creating one order
+// per seller under a single checkout that is paid once
+func (h *OrderHandler) CheckoutCart(c *gin.Context) {
+	var req struct {
+		ShippingAddress models.Address `json:"shipping_address" binding:"required"`
+		PaymentMethod   string         `json:"payment_method" binding:"required"`
+		PromoCode       string         `json:"promo_code"`
+	}
 
-		totalPrice := product.Price * float64(item.Quantity)
-		subtotal += totalPrice
-
-		orderItem := models.OrderItem{
-			ID:           primitive.NewObjectID(),
-			ProductID:    productObjID,
-			ProductTitle: product.Title,
-			Quantity:     item.Quantity,
-			UnitPrice:    product.Price,
-			TotalPrice:   totalPrice,
-			Currency:     product.Currency,
-			Status:       models.OrderStatusPending,
-			ProductSnapshot: models.ProductSnapshot{
-				Title:       product.Title,
-				Description: product.Description,
-				Brand:       product.Brand,
-				Model:       product.Model,
-				Condition:   product.Condition,
-				CategoryID:  product.CategoryID,
-			},
-		}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
 
-		if len(product.Images) > 0 {
-			orderItem.ProductImage = product.Images[0].URL
-		}
+	buyerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
 
-		orderItems = append(orderItems, orderItem)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	items, err := h.checkoutService.CartItems(ctx, buyerID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch cart", err.Error())
+		return
 	}
 
-	// Create order
-	order := models.Order{
-		ID:              primitive.NewObjectID(),
-		OrderNumber:     utils.GenerateOrderNumber(),
+	checkout, _, ok := h.checkout(ctx, c, services.CheckoutRequest{
 		BuyerID:         buyerID,
-		SellerID:        sellerID,
-		Items:           orderItems,
-		SubtotalAmount:  subtotal,
-		ShippingAmount:  0, // Calculate based on location
-		TaxAmount:       0, // Calculate based on location
-		DiscountAmount:  0,
-		TotalAmount:     subtotal,
-		Currency:        "NGN",
-		Status:          models.OrderStatusPending,
-		PaymentStatus:   models.PaymentStatusPending,
+		Items:           items,
 		ShippingAddress: req.ShippingAddress,
-		BillingAddress:  req.ShippingAddress,
 		PaymentMethod:   req.PaymentMethod,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
+		PromoCode:       req.PromoCode,
+	})
+	if !ok {
+		return
 	}
 
-	// Insert order
-	_, err := config.Coll.Orders.InsertOne(ctx, order)
+	utils.CreatedResponse(c, "Checkout created successfully", checkout)
+}
+
+// GetCheckout returns one of the buyer's checkouts with its orders
+func (h *OrderHandler) GetCheckout(c *gin.Context) {
+	checkoutID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to create order", err.Error())
+		utils.BadRequestResponse(c, "Invalid checkout ID", nil)
 		return
 	}
+	buyerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
 
-	// Update product quantities
-	for _, item := range req.Items {
-		productObjID, _ := primitive.ObjectIDFromHex(item.ProductID)
-		config.Coll.Products.UpdateOne(ctx,
-			bson.M{"_id": productObjID},
-			bson.M{"$inc": bson.M{"quantity": -item.Quantity}},
-		)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	checkout, err := h.checkoutService.Get(ctx, checkoutID, buyerID)
+	if err == services.ErrCheckoutNotFound {
+		utils.NotFoundResponse(c, "Checkout not found")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch checkout", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Checkout retrieved successfully", checkout)
+}
+
+// checkout creates the orders with their stock reserved, takes the bought
+// products out of the cart and emails the buyer. It writes the error
+// response itself and reports whether the request may continue.
+func (h *OrderHandler) checkout(ctx context.Context, c *gin.Context, req services.CheckoutRequest) (*models.Checkout, []*models.Order, bool) {
+	checkout, orders, err := h.checkoutService.Create(ctx, req)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrCheckoutEmpty):
+		utils.BadRequestResponse(c, "Cart is empty", nil)
+		return nil, nil, false
+	case errors.Is(err, services.ErrProductUnavailable):
+		utils.BadRequestResponse(c, "Product not found or inactive", nil)
+		return nil, nil, false
+	case errors.Is(err, services.ErrInsufficientStock):
+		utils.BadRequestResponse(c, "Insufficient product quantity", err.Error())
+		return nil, nil, false
+	case errors.Is(err, services.ErrInsufficientBalance):
+		utils.BadRequestResponse(c, "Insufficient wallet balance", nil)
+		return nil, nil, false
+	case errors.Is(err, services.ErrMixedCurrency), errors.Is(err, services.ErrWalletCurrency),
+		errors.Is(err, services.ErrPromoInvalid), errors.Is(err, services.ErrPromoNotApplicable):
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return nil, nil, false
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to create order", err.Error())
+		return nil, nil, false
+	}
+
+	var productIDs []primitive.ObjectID
+	for _, order := range orders {
+		for _, item := range order.Items {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		checkout.Orders = append(checkout.Orders, *order)
 	}
 
-	// Clear cart items for this user
-	config.Coll.CartItems.DeleteMany(ctx, bson.M{"user_id": buyerID})
+	// Remove the bought products from the cart
+	config.Coll.CartItems.DeleteMany(ctx, bson.M{
+		"user_id":    req.BuyerID,
+		"product_id": bson.M{"$in": productIDs},
+	})
 
 	// Send order confirmation email
 	var buyer models.User
-	config.Coll.Users.FindOne(ctx, bson.M{"_id": buyerID}).Decode(&buyer)
+	config.Coll.Users.FindOne(ctx, bson.M{"_id": req.BuyerID}).Decode(&buyer)
+	reference := checkout.CheckoutNumber
+	if len(orders) == 1 {
+		reference = orders[0].OrderNumber
+	}
 	go h.emailService.SendOrderConfirmationEmail(
 		buyer.Email,
 		buyer.Profile.FirstName,
-		order.OrderNumber,
-		order.TotalAmount,
+		reference,
+		checkout.TotalAmount,
 	)
 
-	utils.CreatedResponse(c, "Order created successfully", order)
+	return checkout, orders, true
 }
 
 func (h *OrderHandler) GetUserOrders(c *gin.Context) {
@@ -234,46 +278,25 @@ func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	result, err := config.Coll.Orders.UpdateOne(ctx,
-		bson.M{
-			"_id":      orderObjID,
-			"buyer_id": buyerID,
-			"status":   bson.M{"$in": []models.OrderStatus{models.OrderStatusPending, models.OrderStatusConfirmed}},
-		},
-		bson.M{
-			"$set": bson.M{
-				"status":       models.OrderStatusCancelled,
-				"cancelled_at": time.Now(),
-				"cancelled_by": buyerID,
-				"updated_at":   time.Now(),
-			},
-		},
-	)
-
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to cancel order", err.Error())
-		return
-	}
-
-	if result.MatchedCount == 0 {
-		utils.BadRequestResponse(c, "Order cannot be cancelled or not found", nil)
+	// Cancelling hands an unpaid order's reserved stock back and refunds a
+	// paid one
+	order, err := h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: orderObjID,
+		To:      models.OrderStatusCancelled,
+		Actor:   services.OrderActorBuyer,
+		ActorID: buyerID,
+		Note:    "Cancelled by buyer",
+	})
+	if !handleOrderError(c, err) {
 		return
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, "Order cancelled successfully", nil)
+	utils.SuccessResponse(c, http.StatusOK, "Order cancelled successfully", order)
 }
 
-func (h *OrderHandler) RequestReturn(c *gin.Context) {
-	var req struct {
-		Reason      string `json:"reason" binding:"required"`
-		Description string `json:"description" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data", err.Error())
-		return
-	}
-
+// ConfirmDelivery lets the buyer confirm receipt, completing the order and
+// releasing the escrowed funds to the seller
+func (h *OrderHandler) ConfirmDelivery(c *gin.Context) {
 	orderID := c.Param("id")
 	orderObjID, err := primitive.ObjectIDFromHex(orderID)
 	if err != nil {
@@ -287,26 +310,37 @@ func (h *OrderHandler) RequestReturn(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Create return request
-	returnRequest := models.OrderReturn{
-		ID:          primitive.NewObjectID(),
-		OrderID:     orderObjID,
-		RequestedBy: buyerID,
-		Reason:      req.Reason,
-		Description: req.Description,
-		ReturnType:  "return",
-		Status:      models.ReturnStatusRequested,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	_, err = h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: orderObjID,
+		To:      models.OrderStatusCompleted,
+		Actor:   services.OrderActorBuyer,
+		ActorID: buyerID,
+		Note:    "Buyer confirmed delivery",
+	})
+	if !handleOrderError(c, err) {
+		return
 	}
 
-	_, err = config.Coll.OrderReturns.InsertOne(ctx, returnRequest)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to create return request", err.Error())
+	utils.SuccessResponse(c, http.StatusOK, "Delivery confirmed successfully", nil)
+}
+
+// RequestReturn asks to send an item back for a refund
+func (h *OrderHandler) RequestReturn(c *gin.Context) {
+	var req struct {
+		OrderItemID string   `json:"order_item_id" binding:"required"`
+		Quantity    int      `json:"quantity" binding:"omitempty,min=1"`
+		Reason      string   `json:"reason" binding:"required"`
+		Description string   `json:"description" binding:"required"`
+		Images      []string `json:"images"`
+		RefundTo    string   `json:"refund_to" binding:"omitempty,oneof=original wallet"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
 		return
 	}
 
-	utils.CreatedResponse(c, "Return request submitted successfully", returnRequest)
+	h.openReturn(c, services.ReturnTypeReturn, req.OrderItemID, req.Quantity, req.Reason, req.Description, req.Images, req.RefundTo)
 }
 
 func (h *OrderHandler) GetSellerOrders(c *gin.Context) {
@@ -360,9 +394,12 @@ func (h *OrderHandler) GetSellerOrder(c *gin.Context) {
 	utils.SuccessResponse(c, http.StatusOK, "Seller order retrieved successfully", order)
 }
 
+// UpdateOrderStatus lets a seller move one of their orders to the next
+// status the order state machine allows them
 func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 	var req struct {
 		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -383,58 +420,22 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	update := bson.M{
-		"$set": bson.M{
-			"status":     models.OrderStatus(req.Status),
-			"updated_at": time.Now(),
-		},
-	}
-
-	// Add timestamp for specific statuses
-	switch req.Status {
-	case string(models.OrderStatusConfirmed):
-		update["$set"].(bson.M)["confirmed_at"] = time.Now()
-	case string(models.OrderStatusProcessing):
-		update["$set"].(bson.M)["processed_at"] = time.Now()
-	case string(models.OrderStatusShipped):
-		update["$set"].(bson.M)["shipped_at"] = time.Now()
-	case string(models.OrderStatusDelivered):
-		update["$set"].(bson.M)["delivered_at"] = time.Now()
-	}
-
-	result, err := config.Coll.Orders.UpdateOne(ctx,
-		bson.M{
-			"_id":       orderObjID,
-			"seller_id": sellerID,
-		},
-		update,
-	)
-
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to update order status", err.Error())
+	order, err := h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: orderObjID,
+		To:      models.OrderStatus(req.Status),
+		Actor:   services.OrderActorSeller,
+		ActorID: sellerID,
+		Note:    req.Note,
+	})
+	if errors.Is(err, services.ErrOrderTransition) {
+		respondTransitionNotAllowed(ctx, c, orderObjID, services.OrderActorSeller, err)
 		return
 	}
-
-	if result.MatchedCount == 0 {
-		utils.NotFoundResponse(c, "Order not found")
+	if !handleOrderError(c, err) {
 		return
 	}
 
-	// Get order and buyer info for email notification
-	var order models.Order
-	config.Coll.Orders.FindOne(ctx, bson.M{"_id": orderObjID}).Decode(&order)
-	var buyer models.User
-	config.Coll.Users.FindOne(ctx, bson.M{"_id": order.BuyerID}).Decode(&buyer)
-
-	// Send order status update email
-	go h.emailService.SendOrderStatusEmail(
-		buyer.Email,
-		buyer.Profile.FirstName,
-		order.OrderNumber,
-		req.Status,
-	)
-
-	utils.SuccessResponse(c, http.StatusOK, "Order status updated successfully", nil)
+	utils.SuccessResponse(c, http.StatusOK, "Order status updated successfully", order)
 }
 
 func (h *OrderHandler) ShipOrder(c *gin.Context) {
@@ -461,33 +462,22 @@ func (h *OrderHandler) ShipOrder(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	result, err := config.Coll.Orders.UpdateOne(ctx,
-		bson.M{
-			"_id":       orderObjID,
-			"seller_id": sellerID,
-		},
-		bson.M{
-			"$set": bson.M{
-				"status":          models.OrderStatusShipped,
-				"tracking_number": req.TrackingNumber,
-				"carrier_name":    req.CarrierName,
-				"shipped_at":      time.Now(),
-				"updated_at":      time.Now(),
-			},
+	order, err := h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: orderObjID,
+		To:      models.OrderStatusShipped,
+		Actor:   services.OrderActorSeller,
+		ActorID: sellerID,
+		Note:    fmt.Sprintf("Shipped with %s (%s)", req.CarrierName, req.TrackingNumber),
+		Set: bson.M{
+			"tracking_number": req.TrackingNumber,
+			"carrier_name":    req.CarrierName,
 		},
-	)
-
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to ship order", err.Error())
-		return
-	}
-
-	if result.MatchedCount == 0 {
-		utils.NotFoundResponse(c, "Order not found")
+	})
+	if !handleOrderError(c, err) {
 		return
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, "Order shipped successfully", nil)
+	utils.SuccessResponse(c, http.StatusOK, "Order shipped successfully", order)
 }
 
 func (h *OrderHandler) TrackOrder(c *gin.Context) {
@@ -577,12 +567,15 @@ func (h *OrderHandler) GetAllTransactions(c *gin.Context) {
 	utils.SuccessResponseWithMeta(c, http.StatusOK, "Transactions retrieved successfully", orders, meta)
 }
 
-// RequestRefund handles refund requests
+// RequestRefund asks for a refund without sending anything back, for one
+// item or, with no item given, the whole order
 func (h *OrderHandler) RequestRefund(c *gin.Context) {
 	var req struct {
-		Reason      string  `json:"reason" binding:"required"`
-		Description string  `json:"description" binding:"required"`
-		Amount      float64 `json:"amount"`
+		OrderItemID string `json:"order_item_id"`
+		Quantity    int    `json:"quantity" binding:"omitempty,min=1"`
+		Reason      string `json:"reason" binding:"required"`
+		Description string `json:"description" binding:"required"`
+		RefundTo    string `json:"refund_to" binding:"omitempty,oneof=original wallet"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -590,65 +583,128 @@ func (h *OrderHandler) RequestRefund(c *gin.Context) {
 		return
 	}
 
-	orderID := c.Param("id")
-	orderObjID, err := primitive.ObjectIDFromHex(orderID)
+	h.openReturn(c, services.ReturnTypeRefund, req.OrderItemID, req.Quantity, req.Reason, req.Description, nil, req.RefundTo)
+}
+
+// openReturn creates a return or refund-only request on the buyer's order
+func (h *OrderHandler) openReturn(c *gin.Context, returnType, orderItemID string, quantity int, reason, description string, images []string, refundTo string) {
+	orderObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		utils.BadRequestResponse(c, "Invalid order ID", nil)
 		return
 	}
 
-	userID, _ := c.Get("user_id")
-	buyerID, _ := primitive.ObjectIDFromHex(userID.(string))
+	var itemObjID primitive.ObjectID
+	if orderItemID != "" {
+		if itemObjID, err = primitive.ObjectIDFromHex(orderItemID); err != nil {
+			utils.BadRequestResponse(c, "Invalid order item ID", nil)
+			return
+		}
+	}
+
+	buyerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Get order details
-	var order models.Order
-	err = config.Coll.Orders.FindOne(ctx, bson.M{
-		"_id": orderObjID,
-		"buyer_id": buyerID,
-	}).Decode(&order)
+	ret, err := h.refundService.RequestReturn(ctx, services.ReturnRequest{
+		OrderID:     orderObjID,
+		BuyerID:     buyerID,
+		OrderItemID: itemObjID,
+		Quantity:    quantity,
+		ReturnType:  returnType,
+		Reason:      reason,
+		Description: description,
+		Images:      images,
+		RefundTo:    models.RefundDestination(refundTo),
+	})
+	if !handleRefundError(c, err) {
+		return
+	}
 
-	if err != nil {
-		utils.NotFoundResponse(c, "Order not found")
+	if returnType == services.ReturnTypeRefund {
+		utils.CreatedResponse(c, "Refund request submitted successfully", ret)
 		return
 	}
+	utils.CreatedResponse(c, "Return request submitted successfully", ret)
+}
 
-	// Validate refund amount
-	refundAmount := req.Amount
-	if refundAmount <= 0 || refundAmount > order.TotalAmount {
-		refundAmount = order.TotalAmount
+// AdminUpdateOrderStatus lets an admin move any order to a status the order
+// state machine allows admins, e.g. to resolve a stuck or disputed order
+func (h *OrderHandler) AdminUpdateOrderStatus(c *gin.Context) {
+	var req struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
 	}
 
-	// Create refund request
-	refundRequest := models.OrderReturn{
-		ID:          primitive.NewObjectID(),
-		OrderID:     orderObjID,
-		RequestedBy: buyerID,
-		Reason:      req.Reason,
-		Description: req.Description,
-		ReturnType:  "refund",
-		RefundAmount: refundAmount,
-		Status:      models.ReturnStatusRequested,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
 	}
 
-	_, err = config.Coll.OrderReturns.InsertOne(ctx, refundRequest)
+	orderObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to create refund request", err.Error())
+		utils.BadRequestResponse(c, "Invalid order ID", nil)
 		return
 	}
 
-	// Update order status
-	config.Coll.Orders.UpdateOne(ctx,
-		bson.M{"_id": orderObjID},
-		bson.M{"$set": bson.M{
-			"refund_status": "requested",
-			"updated_at": time.Now(),
-		}},
-	)
+	userID, _ := c.Get("user_id")
+	adminID, _ := primitive.ObjectIDFromHex(userID.(string))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	order, err := h.orderService.Transition(ctx, services.OrderTransition{
+		OrderID: orderObjID,
+		To:      models.OrderStatus(req.Status),
+		Actor:   services.OrderActorAdmin,
+		ActorID: adminID,
+		Note:    req.Note,
+	})
+	if errors.Is(err, services.ErrOrderTransition) {
+		respondTransitionNotAllowed(ctx, c, orderObjID, services.OrderActorAdmin, err)
+		return
+	}
+	if !handleOrderError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Order status updated successfully", order)
+}
+
+// respondTransitionNotAllowed rejects a status change and tells the caller
+// which statuses they could move the order to instead
+func respondTransitionNotAllowed(ctx context.Context, c *gin.Context, orderID primitive.ObjectID, actor services.OrderActor, err error) {
+	var order models.Order
+	if findErr := config.Coll.Orders.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); findErr != nil {
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	}
+	utils.BadRequestResponse(c, err.Error(), gin.H{
+		"current_status": order.Status,
+		"allowed":        services.AllowedTransitions(order.Status, actor),
+	})
+}
 
-	utils.CreatedResponse(c, "Refund request submitted successfully", refundRequest)
-}
\ No newline at end of file
+// handleOrderError writes the response for an order service error and
+// reports whether the request may continue
+func handleOrderError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrOrderNotFound):
+		utils.NotFoundResponse(c, "Order not found")
+	case errors.Is(err, services.ErrOrderTransition):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	case errors.Is(err, services.ErrEscrowNotHeld):
+		utils.BadRequestResponse(c, "Escrow for this order cannot be released", nil)
+	case errors.Is(err, services.ErrOrderRefundPending):
+		// The cancellation stands; the refund is retried in the background
+		utils.SuccessResponse(c, http.StatusAccepted, "Order cancelled; the refund will follow shortly", nil)
+	case errors.Is(err, services.ErrRefundNotAllowed):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to update order", err.Error())
+	}
+	return false
+}
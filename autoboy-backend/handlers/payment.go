@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentHandler struct {
+	paymentService *services.PaymentService
+	webhookService *services.WebhookService
+	refundService  *services.RefundService
+}
+
+func NewPaymentHandler(paymentService *services.PaymentService, webhookService *services.WebhookService, refundService *services.RefundService) *PaymentHandler {
+	return &PaymentHandler{
+		paymentService: paymentService,
+		webhookService: webhookService,
+		refundService:  refundService,
+	}
+}
+
+type InitializePaymentRequest struct {
+	Email      string                 `json:"email" binding:"required"`
+	Amount     int64                  `json:"amount" binding:"omitempty,gt=0"` // Amount in kobo (minor units); only wallet top-ups use it
+	Currency   string                 `json:"currency"`
+	Gateway    string                 `json:"gateway"`
+	Type       string                 `json:"type"`
+	OrderID    string                 `json:"order_id"`
+	CheckoutID string                 `json:"checkout_id"`
+	PlanID     string                 `json:"plan_id"`
+	Callback   string                 `json:"callback_url"`
+	Metadata   map[string]interface{} `json:"metadata"`
+}
+
+// InitializePayment records a pending payment and starts checkout with the
+// gateway chosen for the currency
+func (h *PaymentHandler) InitializePayment(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		utils.UnauthorizedResponse(c, "Invalid user")
+		return
+	}
+
+	var req InitializePaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	// Older clients pass the order id in metadata
+	if req.OrderID == "" {
+		if orderID, ok := req.Metadata["order_id"].(string); ok {
+			req.OrderID = orderID
+		}
+	}
+
+	paymentType := models.PaymentType(req.Type)
+	if paymentType == "" {
+		paymentType = models.PaymentTypePremium
+		if req.OrderID != "" || req.CheckoutID != "" {
+			paymentType = models.PaymentTypeOrder
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Everything but a top-up is priced here; the client's amount is ignored
+	amount := float64(req.Amount) / 100
+	var orderID, checkoutID *primitive.ObjectID
+	if paymentType == models.PaymentTypePremium {
+		plan, err := premiumPlan(c, req.PlanID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid subscription plan", err.Error())
+			return
+		}
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]interface{})
+		}
+		req.Metadata["plan_id"] = plan.ID
+		amount = plan.Price
+		req.Currency = "NGN"
+	} else if paymentType == models.PaymentTypeTopup {
+		if req.Amount <= 0 {
+			utils.BadRequestResponse(c, "Amount is required", nil)
+			return
+		}
+	} else if req.CheckoutID == "" && req.OrderID == "" {
+		utils.BadRequestResponse(c, "An order or checkout is required", nil)
+		return
+	}
+
+	if req.CheckoutID != "" {
+		checkoutObjID, err := primitive.ObjectIDFromHex(req.CheckoutID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid checkout ID", err.Error())
+			return
+		}
+		checkoutID = &checkoutObjID
+	} else if req.OrderID != "" {
+		orderObjID, err := primitive.ObjectIDFromHex(req.OrderID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid order ID", err.Error())
+			return
+		}
+
+		var order models.Order
+		if err := config.Coll.Orders.FindOne(ctx, bson.M{"_id": orderObjID, "buyer_id": userID}).Decode(&order); err != nil {
+			utils.NotFoundResponse(c, "Order not found")
+			return
+		}
+		if order.PaymentStatus == models.PaymentStatusPaid {
+			utils.ConflictResponse(c, "Order has already been paid")
+			return
+		}
+		if order.Status != models.OrderStatusPending {
+			utils.ConflictResponse(c, "Order is no longer awaiting payment")
+			return
+		}
+		orderID = &order.ID
+		// Orders are priced in their own currency; the client's is ignored
+		amount = order.TotalAmount
+		req.Currency = order.Currency
+		// Orders from a multi-seller checkout are paid together
+		checkoutID = order.CheckoutID
+	}
+
+	if checkoutID != nil {
+		var checkout models.Checkout
+		if err := config.Coll.Checkouts.FindOne(ctx, bson.M{"_id": *checkoutID, "buyer_id": userID}).Decode(&checkout); err != nil {
+			utils.NotFoundResponse(c, "Checkout not found")
+			return
+		}
+		if checkout.PaymentStatus == models.PaymentStatusPaid {
+			utils.ConflictResponse(c, "Checkout has already been paid")
+			return
+		}
+		// Every order of the checkout must still be waiting for the payment
+		settled, err := config.Coll.Orders.CountDocuments(ctx, bson.M{
+			"checkout_id": checkout.ID,
+			"status":      bson.M{"$ne": models.OrderStatusPending},
+		})
+		if err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to check checkout", err.Error())
+			return
+		}
+		if settled > 0 {
+			utils.ConflictResponse(c, "Checkout is no longer awaiting payment")
+			return
+		}
+		orderID = nil
+		if len(checkout.OrderIDs) == 1 {
+			orderID = &checkout.OrderIDs[0]
+		}
+		amount = checkout.TotalAmount
+		req.Currency = checkout.Currency
+	}
+
+	payment, result, err := h.paymentService.InitializePayment(ctx, services.PaymentRequest{
+		UserID:      userID,
+		OrderID:     orderID,
+		CheckoutID:  checkoutID,
+		Type:        paymentType,
+		Amount:      amount,
+		Currency:    req.Currency,
+		Email:       req.Email,
+		Gateway:     models.PaymentGateway(strings.ToLower(req.Gateway)),
+		CallbackURL: req.Callback,
+		Metadata:    req.Metadata,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.GetHeader("User-Agent"),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrGatewayNotConfigured) {
+			utils.InternalServerErrorResponse(c, "Payment service not configured", err.Error())
+			return
+		}
+		utils.BadRequestResponse(c, "Payment initialization failed", err.Error())
+		return
+	}
+
+	// Link the reference to the order so the webhook can find it
+	if checkoutID != nil {
+		now := time.Now()
+		config.Coll.Checkouts.UpdateOne(ctx, bson.M{"_id": *checkoutID}, bson.M{"$set": bson.M{
+			"payment_reference": payment.GatewayReference,
+			"updated_at":        now,
+		}})
+		config.Coll.Orders.UpdateMany(ctx, bson.M{"checkout_id": *checkoutID}, bson.M{"$set": bson.M{
+			"payment_reference": payment.GatewayReference,
+			"updated_at":        now,
+		}})
+	} else if orderID != nil {
+		config.Coll.Orders.UpdateOne(ctx, bson.M{"_id": *orderID}, bson.M{"$set": bson.M{
+			"payment_reference": payment.GatewayReference,
+			"updated_at":        time.Now(),
+		}})
+	}
+
+	response := gin.H{
+		"checkout_url": result.AuthorizationURL,
+		"reference":    payment.GatewayReference,
+		"access_code":  result.AccessCode,
+		"gateway":      payment.PaymentGateway,
+		"amount":       payment.Amount,
+		"currency":     payment.Currency,
+		"fee":          payment.GatewayFee,
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Payment initialized successfully", response)
+}
+
+// VerifyPayment checks the status of one of the caller's payments with its
+// gateway
+func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		utils.UnauthorizedResponse(c, "Invalid user")
+		return
+	}
+
+	reference := c.Param("reference")
+	if reference == "" {
+		utils.BadRequestResponse(c, "Payment reference is required", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	payment, err := h.paymentService.VerifyUserPayment(ctx, userID, reference)
+	if err == services.ErrPaymentNotFound {
+		utils.NotFoundResponse(c, "Payment not found")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to verify payment", err.Error())
+		return
+	}
+
+	if payment.Status != models.PaymentStatusPaid {
+		utils.BadRequestResponse(c, fmt.Sprintf("Payment status: %s", payment.Status), payment)
+		return
+	}
+
+	if payment.PaymentType == models.PaymentTypePremium {
+		// Payments from before plans were priced here bought a month
+		months := 1
+		if planID, ok := payment.Metadata["plan_id"].(string); ok {
+			if plan, err := NewSubscriptionHandler().getSubscriptionPlan(planID); err == nil && payment.Amount >= plan.Price {
+				months = plan.DurationMonths
+			}
+		}
+		if err := h.paymentService.ApplyPremium(ctx, payment, months); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to activate premium membership", err.Error())
+			return
+		}
+	}
+
+	// TODO: Send confirmation email
+	utils.SuccessResponse(c, http.StatusOK, "Payment verified successfully", payment)
+}
+
+// premiumPlan is the subscription plan a premium payment buys. Clients that
+// don't name one get the monthly plan for their user type.
+func premiumPlan(c *gin.Context, planID string) (*models.SubscriptionPlan, error) {
+	userType, _ := c.Get("user_type")
+	prefix := "buyer"
+	if userType == models.UserTypeSeller {
+		prefix = "seller"
+	}
+	if planID == "" {
+		planID = prefix + "_monthly"
+	}
+
+	plan, err := NewSubscriptionHandler().getSubscriptionPlan(planID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown plan %q", planID)
+	}
+	if plan.UserType != prefix {
+		return nil, fmt.Errorf("plan %q is for %s accounts", planID, plan.UserType)
+	}
+	return plan, nil
+}
+
+// ProcessRefund refunds a paid payment through its gateway (admins with the
+// orders.refund permission). Order payments go through the refund engine so
+// the order, escrow and seller earnings are reversed too.
+func (h *PaymentHandler) ProcessRefund(c *gin.Context) {
+	var req struct {
+		Reference string  `json:"reference" binding:"required"`
+		Amount    float64 `json:"amount"`
+		Reason    string  `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	payment, err := h.paymentService.FindByReference(ctx, req.Reference)
+	if err == services.ErrPaymentNotFound {
+		utils.NotFoundResponse(c, "Payment not found")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to load payment", err.Error())
+		return
+	}
+
+	if payment.PaymentType == models.PaymentTypeOrder && payment.OrderID != nil {
+		adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+		refundReq := services.RefundRequest{
+			OrderID:     *payment.OrderID,
+			Type:        models.RefundTypeFull,
+			Reason:      req.Reason,
+			RequestedBy: adminID,
+		}
+		if req.Amount > 0 {
+			refundReq.Type = models.RefundTypePartial
+			refundReq.Amount = req.Amount
+		}
+
+		refund, err := h.refundService.Refund(ctx, refundReq)
+		if !handleRefundError(c, err) {
+			return
+		}
+		utils.SuccessResponse(c, http.StatusOK, "Refund processed successfully", refund)
+		return
+	}
+
+	// A checkout payment is split between several sellers' orders, so each
+	// order is refunded in full through the refund engine
+	if payment.PaymentType == models.PaymentTypeOrder && payment.CheckoutID != nil {
+		if req.Amount > 0 {
+			utils.BadRequestResponse(c, "Partial refunds of a multi-seller checkout must be issued per order", nil)
+			return
+		}
+
+		var checkout models.Checkout
+		if err := config.Coll.Checkouts.FindOne(ctx, bson.M{"_id": *payment.CheckoutID}).Decode(&checkout); err != nil {
+			utils.NotFoundResponse(c, "Checkout not found")
+			return
+		}
+
+		adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+		refunds := []*models.Refund{}
+		for _, orderID := range checkout.OrderIDs {
+			refund, err := h.refundService.Refund(ctx, services.RefundRequest{
+				OrderID:     orderID,
+				Type:        models.RefundTypeFull,
+				Reason:      req.Reason,
+				RequestedBy: adminID,
+			})
+			if errors.Is(err, services.ErrRefundNotAllowed) || errors.Is(err, services.ErrRefundAmount) {
+				// Already refunded or never paid
+				continue
+			}
+			if !handleRefundError(c, err) {
+				return
+			}
+			refunds = append(refunds, refund)
+		}
+		utils.SuccessResponse(c, http.StatusOK, "Refund processed successfully", refunds)
+		return
+	}
+
+	refund, err := h.paymentService.RefundPayment(ctx, payment, req.Amount, req.Reason)
+	if err != nil {
+		utils.BadRequestResponse(c, "Refund failed", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Refund processed successfully", refund)
+}
+
+// HandlePaymentDispute handles payment disputes
+func (h *PaymentHandler) HandlePaymentDispute(c *gin.Context) {
+	var req struct {
+		TransactionID string `json:"transaction_id" binding:"required"`
+		Evidence      string `json:"evidence" binding:"required"`
+		UploadURL     string `json:"upload_url"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	// Store dispute in database
+	dispute := bson.M{
+		"_id":            primitive.NewObjectID(),
+		"transaction_id": req.TransactionID,
+		"evidence":       req.Evidence,
+		"upload_url":     req.UploadURL,
+		"status":         "pending",
+		"created_at":     time.Now(),
+	}
+
+	_, err := utils.DB.Collection("payment_disputes").InsertOne(c, dispute)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to record dispute", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Dispute recorded successfully", dispute)
+}
+
+// HandleWebhook receives gateway webhooks. The endpoint is public, so the
+// gateway signature is the only proof of where the event came from.
+// Without a :gateway segment the event is treated as Paystack's.
+func (h *PaymentHandler) HandleWebhook(c *gin.Context) {
+	gateway := models.PaymentGateway(strings.ToLower(c.Param("gateway")))
+	if gateway == "" {
+		gateway = models.PaymentGatewayPaystack
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		utils.BadRequestResponse(c, "Failed to read webhook body", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	webhook, err := h.webhookService.Receive(ctx, gateway, c.Request.Header, body, c.ClientIP())
+	switch {
+	case err == services.ErrDuplicateWebhook:
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	case errors.Is(err, services.ErrInvalidSignature):
+		utils.UnauthorizedResponse(c, "Invalid webhook signature")
+		return
+	case errors.Is(err, services.ErrGatewayNotConfigured):
+		utils.NotFoundResponse(c, "Unknown payment gateway")
+		return
+	case webhook == nil && err != nil:
+		utils.BadRequestResponse(c, "Invalid webhook data", err.Error())
+		return
+	case err != nil:
+		// Stored as failed; it can be retried from the admin endpoint
+		log.Printf("%s webhook %s (%s) failed: %v", gateway, webhook.EventID, webhook.Event, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
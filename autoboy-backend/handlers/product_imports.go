@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductImportHandler lets sellers import and export their listings in bulk
+type ProductImportHandler struct {
+	importService *services.ProductImportService
+	maxFileBytes  int64
+}
+
+func NewProductImportHandler(importService *services.ProductImportService) *ProductImportHandler {
+	return &ProductImportHandler{
+		importService: importService,
+		maxFileBytes:  int64(utils.GetEnvAsInt("PRODUCT_IMPORT_MAX_FILE_MB", 5)) << 20,
+	}
+}
+
+// GetImportColumns documents the bulk listing format
+func (h *ProductImportHandler) GetImportColumns(c *gin.Context) {
+	utils.SuccessResponse(c, http.StatusOK, "Import columns retrieved successfully", gin.H{
+		"formats": []models.ProductImportFormat{models.ProductImportCSV, models.ProductImportJSON},
+		"columns": services.ImportColumns,
+	})
+}
+
+// ImportProducts accepts a CSV or JSON file of listings, sent as the "file"
+// form field, and queues it. The format comes from ?format= or the file's
+// extension.
+func (h *ProductImportHandler) ImportProducts(c *gin.Context) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequestResponse(c, "A file is required", err.Error())
+		return
+	}
+	if header.Size > h.maxFileBytes {
+		utils.BadRequestResponse(c, fmt.Sprintf("File is larger than %d MB", h.maxFileBytes>>20), nil)
+		return
+	}
+
+	format := models.ProductImportFormat(strings.ToLower(c.Query("format")))
+	if format == "" {
+		format = models.ProductImportFormat(strings.ToLower(strings.TrimPrefix(filepath.Ext(header.Filename), ".")))
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		utils.BadRequestResponse(c, "Failed to read file", err.Error())
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	imp, err := h.importService.Submit(ctx, sellerID, format, filepath.Base(header.Filename), file)
+	if !handleImportError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusAccepted, "Import queued successfully", imp)
+}
+
+// GetImports returns the seller's recent imports
+func (h *ProductImportHandler) GetImports(c *gin.Context) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	imports, err := h.importService.Imports(ctx, sellerID, limit)
+	if !handleImportError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Imports retrieved successfully", imports)
+}
+
+// GetImport returns an import's progress and the rows it could not apply
+func (h *ProductImportHandler) GetImport(c *gin.Context) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	importID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid import ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	imp, err := h.importService.Import(ctx, sellerID, importID)
+	if !handleImportError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Import retrieved successfully", imp)
+}
+
+// ExportProducts downloads the seller's listings in the import format, so
+// they can be edited and uploaded again
+func (h *ProductImportHandler) ExportProducts(c *gin.Context) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	format := models.ProductImportFormat(strings.ToLower(c.DefaultQuery("format", "csv")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if _, err := h.importService.Export(ctx, sellerID, format, &buf); !handleImportError(c, err) {
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == models.ProductImportJSON {
+		contentType = "application/json; charset=utf-8"
+	}
+	fileName := fmt.Sprintf("autoboy-listings-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// handleImportError writes the response for an import service error and
+// reports whether the request may continue
+func handleImportError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrImportNotFound):
+		utils.NotFoundResponse(c, "Import not found")
+	case errors.Is(err, services.ErrImportFormat),
+		errors.Is(err, services.ErrImportFile),
+		errors.Is(err, services.ErrImportTooLarge):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to process import", err.Error())
+	}
+	return false
+}
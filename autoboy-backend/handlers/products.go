@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"autoboy-backend/config"
+	"autoboy-backend/geo"
 	"autoboy-backend/models"
 	"autoboy-backend/services"
 	"autoboy-backend/utils"
@@ -19,13 +23,19 @@ import (
 
 // ProductHandler handles product-related requests
 type ProductHandler struct {
-	imageService *services.ImageService
+	imageService          *services.ImageService
+	searchService         *services.SearchService
+	recommendationService *services.RecommendationService
+	eventTracker          *services.EventTracker
 }
 
 // NewProductHandler creates a new product handler
-func NewProductHandler(imageService *services.ImageService) *ProductHandler {
+func NewProductHandler(imageService *services.ImageService, searchService *services.SearchService, recommendationService *services.RecommendationService, eventTracker *services.EventTracker) *ProductHandler {
 	return &ProductHandler{
-		imageService: imageService,
+		imageService:          imageService,
+		searchService:         searchService,
+		recommendationService: recommendationService,
+		eventTracker:          eventTracker,
 	}
 }
 
@@ -50,6 +60,7 @@ type CreateProductRequest struct {
 }
 
 type ProductLocationReq struct {
+	Address    string    `json:"address"`
 	City       string    `json:"city" binding:"required"`
 	State      string    `json:"state" binding:"required"`
 	Country    string    `json:"country" binding:"required"`
@@ -92,6 +103,12 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 
+	location, err := productLocation(req.Location)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid location", err.Error())
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -106,6 +123,11 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 
+	specifications, ok := validateSpecifications(c, &category, req.Specifications)
+	if !ok {
+		return
+	}
+
 	// Create product
 	sellerID, _ := primitive.ObjectIDFromHex(userID.(string))
 	product := models.Product{
@@ -120,15 +142,9 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		Brand:       req.Brand,
 		Model:       req.Model,
 		Color:       req.Color,
-		Specifications: req.Specifications,
+		Specifications: specifications,
 		Quantity:    req.Quantity,
-		Location: models.ProductLocation{
-			City:        req.Location.City,
-			State:       req.Location.State,
-			Country:     req.Location.Country,
-			PostalCode:  req.Location.PostalCode,
-			Coordinates: req.Location.Coordinates,
-		},
+		Location: location,
 		SwapAvailable: req.SwapAvailable,
 		Status:        getProductStatus(currentUser.UserType),
 		IsFeatured:    false,
@@ -136,6 +152,9 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
+	if product.Status == models.ProductStatusActive {
+		product.PublishedAt = &product.CreatedAt
+	}
 
 	// Process swap preferences
 	for _, sp := range req.SwapPreferences {
@@ -181,6 +200,8 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 
+	h.reindex(ctx, product.ID)
+
 	utils.CreatedResponse(c, "Product created successfully", product)
 }
 
@@ -236,6 +257,13 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 		filter["price"] = priceFilter
 	}
 
+	// Ranking flags: hot=true, trending=true, new=true
+	for _, flag := range []string{"hot", "trending", "new"} {
+		if c.Query(flag) == "true" {
+			filter["is_"+flag] = true
+		}
+	}
+
 	// Location filter
 	if location != "" {
 		filter["$or"] = []bson.M{
@@ -247,9 +275,11 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 	// Calculate pagination
 	page, limit, totalPages, offset := utils.CalculatePagination(page, limit, 0)
 
-	// Sort options
+	// Sort options; popular ranks by the ranking job's score
 	sort := bson.D{}
-	if sortOrder == "desc" {
+	if sortBy == "popular" {
+		sort = append(sort, bson.E{Key: "popularity_score", Value: -1}, bson.E{Key: "created_at", Value: -1})
+	} else if sortOrder == "desc" {
 		sort = append(sort, bson.E{Key: sortBy, Value: -1})
 	} else {
 		sort = append(sort, bson.E{Key: sortBy, Value: 1})
@@ -307,7 +337,6 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 				0,
 			}},
 			"in_stock": bson.M{"$gt": []interface{}{"$quantity", 0}},
-			"is_new": bson.M{"$gte": []interface{}{"$created_at", time.Now().AddDate(0, 0, -30)}},
 		}},
 		{"$project": bson.M{
 			"seller":      0,
@@ -365,30 +394,19 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 		return
 	}
 
-	// Increment view count
-	go func() {
-		config.Coll.Products.UpdateOne(context.Background(),
-			bson.M{"_id": objID},
-			bson.M{"$inc": bson.M{"view_count": 1}},
-		)
-
-		// Log product view
-		userID, _ := c.Get("user_id")
-		view := models.ProductView{
-			ID:        primitive.NewObjectID(),
+	// Record the view; the analytics rollup adds it to view_count. Sellers
+	// looking at their own listing are not counted.
+	userID := trackingUser(c)
+	if userID == nil || *userID != product.SellerID {
+		h.eventTracker.TrackView(models.ProductView{
 			ProductID: objID,
+			UserID:    userID,
 			IPAddress: c.ClientIP(),
 			UserAgent: c.Request.UserAgent(),
-			ViewedAt:  time.Now(),
-		}
-		
-		if userID != nil {
-			userObjID, _ := primitive.ObjectIDFromHex(userID.(string))
-			view.UserID = &userObjID
-		}
-		
-		config.Coll.ProductViews.InsertOne(context.Background(), view)
-	}()
+			Referrer:  c.Request.Referer(),
+			SessionID: trackingSession(c),
+		})
+	}
 
 	utils.SuccessResponse(c, http.StatusOK, "Product retrieved successfully", product)
 }
@@ -408,6 +426,12 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	location, err := productLocation(req.Location)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid location", err.Error())
+		return
+	}
+
 	userID, _ := c.Get("user_id")
 	sellerID, _ := primitive.ObjectIDFromHex(userID.(string))
 
@@ -426,6 +450,16 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": existingProduct.CategoryID}).Decode(&category); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to load product category", err.Error())
+		return
+	}
+	specifications, ok := validateSpecifications(c, &category, req.Specifications)
+	if !ok {
+		return
+	}
+
 	// Update product
 	update := bson.M{
 		"$set": bson.M{
@@ -438,13 +472,8 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 			"model":        req.Model,
 			"color":        req.Color,
 			"quantity":     req.Quantity,
-			"location": models.ProductLocation{
-				City:        req.Location.City,
-				State:       req.Location.State,
-				Country:     req.Location.Country,
-				PostalCode:  req.Location.PostalCode,
-				Coordinates: req.Location.Coordinates,
-			},
+			"location":     location,
+			"specifications": specifications,
 			"swap_available": req.SwapAvailable,
 			"tags":           req.Tags,
 			"updated_at":     time.Now(),
@@ -457,6 +486,8 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	h.reindex(ctx, objID)
+
 	utils.SuccessResponse(c, http.StatusOK, "Product updated successfully", nil)
 }
 
@@ -499,9 +530,61 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
+	h.searchService.RemoveProduct(objID)
+
 	utils.SuccessResponse(c, http.StatusOK, "Product deleted successfully", nil)
 }
 
+// reindex refreshes a product in the search index. A failure only leaves
+// search stale until the next change or rebuild, so it is logged rather
+// than failing the request.
+func (h *ProductHandler) reindex(ctx context.Context, productID primitive.ObjectID) {
+	if err := h.searchService.IndexProduct(ctx, productID); err != nil {
+		log.Printf("Failed to index product %s: %v", productID.Hex(), err)
+	}
+}
+
+// validateSpecifications checks a listing's specifications against its
+// category's attribute schema, responding with the failing attributes when
+// they do not fit
+func validateSpecifications(c *gin.Context, category *models.Category, specs map[string]interface{}) (map[string]interface{}, bool) {
+	cleaned, problems := services.ValidateSpecifications(category.Attributes, specs)
+	if len(problems) > 0 {
+		details := make(map[string]string, len(problems))
+		for name, problem := range problems {
+			details["specifications."+name] = name + " " + problem
+		}
+		utils.ValidationErrorResponse(c, details)
+		return nil, false
+	}
+	return cleaned, true
+}
+
+// productLocation builds a listing's location from the request. Coordinates
+// are optional; without them the seller's address, city and state are
+// geocoded against the offline gazetteer so the listing still shows up in
+// radius searches.
+func productLocation(req ProductLocationReq) (models.ProductLocation, error) {
+	location := models.ProductLocation{
+		Address:     utils.SanitizeString(req.Address),
+		City:        req.City,
+		State:       req.State,
+		Country:     req.Country,
+		PostalCode:  req.PostalCode,
+		Coordinates: req.Coordinates,
+	}
+	if len(req.Coordinates) > 0 {
+		if _, ok := geo.FromCoordinates(req.Coordinates); !ok {
+			return location, errors.New("coordinates must be [longitude, latitude]")
+		}
+		return location, nil
+	}
+	if place, ok := geo.Geocode(req.Address, req.City, req.State); ok {
+		location.Coordinates = place.Point.Coordinates()
+	}
+	return location, nil
+}
+
 // getProductStatus returns appropriate status based on user type
 func getProductStatus(userType models.UserType) models.ProductStatus {
 	if userType == models.UserTypeAdmin {
@@ -510,7 +593,8 @@ func getProductStatus(userType models.UserType) models.ProductStatus {
 	return models.ProductStatusDraft
 }
 
-// GetProductRecommendations gets recommended products based on current product
+// GetProductRecommendations gets what buyers of a product also bought and
+// viewed, plus similar listings
 func (h *ProductHandler) GetProductRecommendations(c *gin.Context) {
 	productID := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(productID)
@@ -530,62 +614,43 @@ func (h *ProductHandler) GetProductRecommendations(c *gin.Context) {
 		return
 	}
 
-	// Build recommendation pipeline
-	pipeline := []bson.M{
-		{"$match": bson.M{
-			"_id": bson.M{"$ne": objID},
-			"status": models.ProductStatusActive,
-		}},
+	recommendations, err := h.recommendationService.ForProduct(ctx, &product, recommendationLimit(c))
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get recommendations", err.Error())
+		return
 	}
 
-	// Add scoring based on similarity
-	pipeline = append(pipeline, bson.M{
-		"$addFields": bson.M{
-			"score": bson.M{
-				"$add": []interface{}{
-					// Same category: +10 points
-					bson.M{"$cond": []interface{}{
-						bson.M{"$eq": []interface{}{"$category_id", product.CategoryID}},
-						10, 0,
-					}},
-					// Same brand: +5 points
-					bson.M{"$cond": []interface{}{
-						bson.M{"$eq": []interface{}{"$brand", product.Brand}},
-						5, 0,
-					}},
-					// Similar price range: +3 points
-					bson.M{"$cond": []interface{}{
-						bson.M{"$and": []interface{}{
-							bson.M{"$gte": []interface{}{"$price", product.Price * 0.7}},
-							bson.M{"$lte": []interface{}{"$price", product.Price * 1.3}},
-						}},
-						3, 0,
-					}},
-					// Same condition: +2 points
-					bson.M{"$cond": []interface{}{
-						bson.M{"$eq": []interface{}{"$condition", product.Condition}},
-						2, 0,
-					}},
-				},
-			},
-		},
-	})
+	utils.SuccessResponse(c, http.StatusOK, "Recommendations retrieved", recommendations)
+}
 
-	// Sort by score and limit
-	pipeline = append(pipeline, bson.M{"$sort": bson.M{"score": -1, "created_at": -1}})
-	pipeline = append(pipeline, bson.M{"$limit": 10})
+// GetHomeRecommendations gets the home page lists: a personal feed for
+// signed-in users and trending products for everyone
+func (h *ProductHandler) GetHomeRecommendations(c *gin.Context) {
+	var userID *primitive.ObjectID
+	if id, err := primitive.ObjectIDFromHex(c.GetString("user_id")); err == nil {
+		userID = &id
+	}
 
-	cursor, err := config.Coll.Products.Aggregate(ctx, pipeline)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	home, err := h.recommendationService.Home(ctx, userID, recommendationLimit(c))
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get recommendations", err.Error())
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var recommendations []bson.M
-	cursor.All(ctx, &recommendations)
+	utils.SuccessResponse(c, http.StatusOK, "Recommendations retrieved", home)
+}
 
-	utils.SuccessResponse(c, http.StatusOK, "Recommendations retrieved", recommendations)
+// recommendationLimit reads the limit query parameter, 10 by default and
+// at most 50
+func recommendationLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		return 10
+	}
+	return min(limit, 50)
 }
 
 // GetProductVariants gets product variants (similar products from same seller)
@@ -659,4 +724,72 @@ func (h *ProductHandler) GetProductVariants(c *gin.Context) {
 		"variants": variantGroups,
 		"total_variants": len(variants),
 	})
-}
\ No newline at end of file
+}
+
+// TrackProductEvent records a funnel event the client sees but the server
+// does not: a share, a tap to contact the seller, or a buy button click.
+// Saves and swap requests are recorded when they happen.
+func (h *ProductHandler) TrackProductEvent(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid product ID", nil)
+		return
+	}
+
+	var req struct {
+		Type models.ProductEventType `json:"type" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	switch req.Type {
+	case models.ProductEventShare, models.ProductEventContactSeller, models.ProductEventPurchaseClick:
+	default:
+		utils.BadRequestResponse(c, "Unsupported event type", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var product models.Product
+	err = config.Coll.Products.FindOne(ctx,
+		bson.M{"_id": objID, "status": models.ProductStatusActive},
+		options.FindOne().SetProjection(bson.M{"seller_id": 1}),
+	).Decode(&product)
+	if err != nil {
+		utils.NotFoundResponse(c, "Product not found")
+		return
+	}
+
+	recorded := false
+	if userID := trackingUser(c); userID == nil || *userID != product.SellerID {
+		recorded = h.eventTracker.TrackEvent(models.ProductEvent{
+			ProductID: objID,
+			Type:      req.Type,
+			UserID:    userID,
+			SessionID: trackingSession(c),
+			IPAddress: c.ClientIP(),
+		})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Event recorded", gin.H{"recorded": recorded})
+}
+
+// trackingUser returns the signed-in user, or nil for guests
+func trackingUser(c *gin.Context) *primitive.ObjectID {
+	if id, err := primitive.ObjectIDFromHex(c.GetString("user_id")); err == nil {
+		return &id
+	}
+	return nil
+}
+
+// trackingSession returns the client's X-Session-ID, or a key derived from
+// its IP address and user agent when it sends none
+func trackingSession(c *gin.Context) string {
+	if id := strings.TrimSpace(c.GetHeader("X-Session-ID")); id != "" && len(id) <= 128 {
+		return id
+	}
+	return services.SessionKey(c.ClientIP(), c.Request.UserAgent())
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefundHandler struct {
+	refundService *services.RefundService
+}
+
+func NewRefundHandler(refundService *services.RefundService) *RefundHandler {
+	return &RefundHandler{
+		refundService: refundService,
+	}
+}
+
+// GetMyReturns lists the buyer's return and refund requests
+func (h *RefundHandler) GetMyReturns(c *gin.Context) {
+	buyerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	h.listReturns(c, bson.M{"requested_by": buyerID})
+}
+
+// GetSellerReturns lists return requests on the seller's orders
+func (h *RefundHandler) GetSellerReturns(c *gin.Context) {
+	sellerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	h.listReturns(c, bson.M{"seller_id": sellerID})
+}
+
+// GetReturns lists all return requests for admins
+func (h *RefundHandler) GetReturns(c *gin.Context) {
+	h.listReturns(c, bson.M{})
+}
+
+// ShipReturn lets the buyer add return shipping details
+func (h *RefundHandler) ShipReturn(c *gin.Context) {
+	returnID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", nil)
+		return
+	}
+
+	var req struct {
+		CarrierName    string `json:"carrier_name" binding:"required"`
+		TrackingNumber string `json:"tracking_number" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	buyerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ret, err := h.refundService.ShipReturn(ctx, returnID, buyerID, req.CarrierName, req.TrackingNumber)
+	if !handleRefundError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Return shipping recorded", ret)
+}
+
+// ApproveReturn accepts a return request (seller or admin)
+func (h *RefundHandler) ApproveReturn(c *gin.Context) {
+	returnID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", nil)
+		return
+	}
+	actorID, isAdmin := returnActor(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ret, err := h.refundService.ApproveReturn(ctx, returnID, actorID, isAdmin)
+	if !handleRefundError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Return approved successfully", ret)
+}
+
+// RejectReturn declines a return request (seller or admin)
+func (h *RefundHandler) RejectReturn(c *gin.Context) {
+	returnID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", nil)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	actorID, isAdmin := returnActor(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ret, err := h.refundService.RejectReturn(ctx, returnID, actorID, isAdmin, req.Reason)
+	if !handleRefundError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Return rejected successfully", ret)
+}
+
+// ReceiveReturn confirms the returned item arrived, which restocks it and
+// refunds the buyer (seller or admin)
+func (h *RefundHandler) ReceiveReturn(c *gin.Context) {
+	returnID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", nil)
+		return
+	}
+	actorID, isAdmin := returnActor(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ret, err := h.refundService.ReceiveReturn(ctx, returnID, actorID, isAdmin)
+	if !handleRefundError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Return received and refunded", ret)
+}
+
+// RetryReturnRefund retries the refund for a return whose refund failed (admin only)
+func (h *RefundHandler) RetryReturnRefund(c *gin.Context) {
+	returnID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid return ID", nil)
+		return
+	}
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ret, err := h.refundService.RefundReturn(ctx, returnID, adminID)
+	if !handleRefundError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Return refunded successfully", ret)
+}
+
+// GetRefundable shows what is left to refund on an order (admin only)
+func (h *RefundHandler) GetRefundable(c *gin.Context) {
+	orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var order models.Order
+	if err := config.Coll.Orders.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); err != nil {
+		utils.NotFoundResponse(c, "Order not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Refundable amounts retrieved successfully", h.refundService.Refundable(&order))
+}
+
+// RefundOrder issues a full, partial or shipping-only refund on an order (admin only)
+func (h *RefundHandler) RefundOrder(c *gin.Context) {
+	orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid order ID", nil)
+		return
+	}
+
+	var req struct {
+		Type  string `json:"type" binding:"required,oneof=full partial shipping"`
+		Items []struct {
+			OrderItemID string `json:"order_item_id" binding:"required"`
+			Quantity    int    `json:"quantity" binding:"required,min=1"`
+		} `json:"items"`
+		IncludeShipping bool    `json:"include_shipping"`
+		Amount          float64 `json:"amount"`
+		Destination     string  `json:"destination" binding:"omitempty,oneof=original wallet"`
+		Restock         bool    `json:"restock"`
+		Reason          string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	refundReq := services.RefundRequest{
+		OrderID:         orderID,
+		Type:            models.RefundType(req.Type),
+		IncludeShipping: req.IncludeShipping,
+		Amount:          req.Amount,
+		Destination:     models.RefundDestination(req.Destination),
+		Restock:         req.Restock,
+		Reason:          req.Reason,
+		RequestedBy:     adminID,
+	}
+	for _, item := range req.Items {
+		itemID, err := primitive.ObjectIDFromHex(item.OrderItemID)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid order item ID", nil)
+			return
+		}
+		refundReq.Items = append(refundReq.Items, services.RefundLine{OrderItemID: itemID, Quantity: item.Quantity})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	refund, err := h.refundService.Refund(ctx, refundReq)
+	if !handleRefundError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Refund issued successfully", refund)
+}
+
+// GetRefunds lists refunds for admins, optionally filtered by status or order
+func (h *RefundHandler) GetRefunds(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" && status != "all" {
+		filter["status"] = status
+	}
+	if orderID, err := primitive.ObjectIDFromHex(c.Query("order_id")); err == nil {
+		filter["order_id"] = orderID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := config.Coll.Refunds.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count refunds", err.Error())
+		return
+	}
+
+	page, limit, totalPages, offset := utils.CalculatePagination(page, limit, total)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := config.Coll.Refunds.Find(ctx, filter, opts)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch refunds", err.Error())
+		return
+	}
+	defer cursor.Close(ctx)
+
+	refunds := []models.Refund{}
+	if err := cursor.All(ctx, &refunds); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to decode refunds", err.Error())
+		return
+	}
+
+	utils.SuccessResponseWithMeta(c, http.StatusOK, "Refunds retrieved successfully", refunds, &utils.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+func (h *RefundHandler) listReturns(c *gin.Context, filter bson.M) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if status := c.Query("status"); status != "" && status != "all" {
+		filter["status"] = status
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := config.Coll.OrderReturns.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count returns", err.Error())
+		return
+	}
+
+	page, limit, totalPages, offset := utils.CalculatePagination(page, limit, total)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := config.Coll.OrderReturns.Find(ctx, filter, opts)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch returns", err.Error())
+		return
+	}
+	defer cursor.Close(ctx)
+
+	returns := []models.OrderReturn{}
+	if err := cursor.All(ctx, &returns); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to decode returns", err.Error())
+		return
+	}
+
+	utils.SuccessResponseWithMeta(c, http.StatusOK, "Returns retrieved successfully", returns, &utils.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// returnActor returns the caller and whether they act as an admin
+func returnActor(c *gin.Context) (primitive.ObjectID, bool) {
+	actorID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	userType, _ := c.Get("user_type")
+	return actorID, userType == models.UserTypeAdmin
+}
+
+// handleRefundError writes the error response and reports whether the request may continue
+func handleRefundError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrOrderNotFound):
+		utils.NotFoundResponse(c, "Order not found")
+	case errors.Is(err, services.ErrReturnNotFound):
+		utils.NotFoundResponse(c, "Return not found")
+	case errors.Is(err, services.ErrReturnState), errors.Is(err, services.ErrReturnExists):
+		utils.ConflictResponse(c, err.Error())
+	case errors.Is(err, services.ErrRefundNotAllowed),
+		errors.Is(err, services.ErrRefundAmount),
+		errors.Is(err, services.ErrRefundItem),
+		errors.Is(err, services.ErrReturnNotDelivered),
+		errors.Is(err, services.ErrEscrowAmount),
+		errors.Is(err, services.ErrClawback):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Refund failed", err.Error())
+	}
+	return false
+}
@@ -3,9 +3,12 @@ package handlers
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -14,22 +17,22 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type SavedSearchHandler struct{}
-
-type SavedSearch struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Query       string             `bson:"query" json:"query"`
-	Filters     map[string]interface{} `bson:"filters" json:"filters"`
-	ResultCount int                `bson:"result_count" json:"result_count"`
-	IsActive    bool               `bson:"is_active" json:"is_active"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
-	LastChecked time.Time          `bson:"last_checked" json:"last_checked"`
+type SavedSearchHandler struct {
+	savedSearchService *services.SavedSearchService
 }
 
-func NewSavedSearchHandler() *SavedSearchHandler {
-	return &SavedSearchHandler{}
+type SavedSearchRequest struct {
+	Name      string                      `json:"name"`
+	Query     string                      `json:"query" binding:"required"`
+	Filters   models.SearchFilters        `json:"filters"`
+	Frequency models.SavedSearchFrequency `json:"frequency"`
+	Channels  []models.SavedSearchChannel `json:"channels"`
+}
+
+func NewSavedSearchHandler(savedSearchService *services.SavedSearchService) *SavedSearchHandler {
+	return &SavedSearchHandler{
+		savedSearchService: savedSearchService,
+	}
 }
 
 func (h *SavedSearchHandler) GetSavedSearches(c *gin.Context) {
@@ -49,22 +52,21 @@ func (h *SavedSearchHandler) GetSavedSearches(c *gin.Context) {
 	}
 	defer cursor.Close(ctx)
 
-	var searches []SavedSearch
+	searches := []models.SavedSearch{}
 	cursor.All(ctx, &searches)
 
 	utils.SuccessResponse(c, http.StatusOK, "Saved searches retrieved", searches)
 }
 
 func (h *SavedSearchHandler) CreateSavedSearch(c *gin.Context) {
-	var req struct {
-		Query   string                 `json:"query" binding:"required"`
-		Filters map[string]interface{} `json:"filters"`
-	}
-
+	var req SavedSearchRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.BadRequestResponse(c, "Invalid request data", err.Error())
 		return
 	}
+	if !h.normalizeRequest(c, &req) {
+		return
+	}
 
 	userID, _ := c.Get("user_id")
 	userObjID, _ := primitive.ObjectIDFromHex(userID.(string))
@@ -73,10 +75,10 @@ func (h *SavedSearchHandler) CreateSavedSearch(c *gin.Context) {
 	defer cancel()
 
 	// Check if search already exists
-	var existing SavedSearch
+	var existing models.SavedSearch
 	err := config.Coll.SavedSearches.FindOne(ctx, bson.M{
-		"user_id": userObjID,
-		"query":   req.Query,
+		"user_id":   userObjID,
+		"query":     req.Query,
 		"is_active": true,
 	}).Decode(&existing)
 
@@ -85,16 +87,21 @@ func (h *SavedSearchHandler) CreateSavedSearch(c *gin.Context) {
 		return
 	}
 
-	search := SavedSearch{
+	// New matches are counted from now, not from every listing ever published
+	now := time.Now()
+	search := models.SavedSearch{
 		ID:          primitive.NewObjectID(),
 		UserID:      userObjID,
+		Name:        req.Name,
 		Query:       req.Query,
 		Filters:     req.Filters,
 		ResultCount: 0,
 		IsActive:    true,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-		LastChecked: time.Now(),
+		Frequency:   req.Frequency,
+		Channels:    req.Channels,
+		LastChecked: &now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
 	}
 
 	_, err = config.Coll.SavedSearches.InsertOne(ctx, search)
@@ -106,6 +113,54 @@ func (h *SavedSearchHandler) CreateSavedSearch(c *gin.Context) {
 	utils.CreatedResponse(c, "Search saved successfully", search)
 }
 
+// UpdateSavedSearch changes a saved search's query, filters and how its
+// owner is told about new matches
+func (h *SavedSearchHandler) UpdateSavedSearch(c *gin.Context) {
+	searchObjID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid saved search ID", nil)
+		return
+	}
+
+	var req SavedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if !h.normalizeRequest(c, &req) {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	userObjID, _ := primitive.ObjectIDFromHex(userID.(string))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := config.Coll.SavedSearches.UpdateOne(ctx,
+		bson.M{"_id": searchObjID, "user_id": userObjID, "is_active": true},
+		bson.M{"$set": bson.M{
+			"name":       req.Name,
+			"query":      req.Query,
+			"filters":    req.Filters,
+			"frequency":  req.Frequency,
+			"channels":   req.Channels,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update saved search", err.Error())
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		utils.NotFoundResponse(c, "Saved search not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Saved search updated", nil)
+}
+
 func (h *SavedSearchHandler) DeleteSavedSearch(c *gin.Context) {
 	searchID := c.Param("id")
 	searchObjID, _ := primitive.ObjectIDFromHex(searchID)
@@ -131,4 +186,33 @@ func (h *SavedSearchHandler) DeleteSavedSearch(c *gin.Context) {
 	}
 
 	utils.SuccessResponse(c, http.StatusOK, "Saved search deleted", nil)
-}
\ No newline at end of file
+}
+
+// normalizeRequest fills in defaults and checks the frequency and channels,
+// responding with an error when they are not supported
+func (h *SavedSearchHandler) normalizeRequest(c *gin.Context, req *SavedSearchRequest) bool {
+	req.Query = strings.TrimSpace(req.Query)
+	req.Name = utils.SanitizeString(req.Name)
+	if req.Name == "" {
+		req.Name = req.Query
+	}
+
+	if req.Frequency == "" {
+		req.Frequency = h.savedSearchService.DefaultFrequency()
+	}
+	if !services.ValidSavedSearchFrequency(req.Frequency) {
+		utils.BadRequestResponse(c, "Frequency must be instant, daily or weekly", nil)
+		return false
+	}
+
+	if len(req.Channels) == 0 {
+		req.Channels = services.DefaultSavedSearchChannels
+	}
+	for _, channel := range req.Channels {
+		if !services.ValidSavedSearchChannel(channel) {
+			utils.BadRequestResponse(c, "Channels must be in_app, email or websocket", nil)
+			return false
+		}
+	}
+	return true
+}
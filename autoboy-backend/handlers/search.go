@@ -2,144 +2,342 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"autoboy-backend/config"
+	"autoboy-backend/geo"
 	"autoboy-backend/models"
+	"autoboy-backend/search"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type SearchHandler struct{}
+type SearchHandler struct {
+	searchService    *services.SearchService
+	analyticsService *services.SearchAnalyticsService
+}
 
-func NewSearchHandler() *SearchHandler {
-	return &SearchHandler{}
+func NewSearchHandler(searchService *services.SearchService, analyticsService *services.SearchAnalyticsService) *SearchHandler {
+	return &SearchHandler{
+		searchService:    searchService,
+		analyticsService: analyticsService,
+	}
 }
 
-// SearchProducts performs advanced product search
+// SearchProducts searches active listings through the product search index.
+// Queries tolerate typos and unfinished words; results carry facet counts
+// for category, brand, condition, price range and the filterable category
+// attributes, which are filtered with spec[name]=a,b and, for numbers,
+// spec_min[name] and spec_max[name]. Searches near a point
+// (lat/lng, or a place name in near) may be limited to a radius in km and
+// return each listing's distance. The first page of every search is logged
+// and its search_id returned for click tracking.
 func (h *SearchHandler) SearchProducts(c *gin.Context) {
 	query := c.Query("q")
 	category := c.Query("category")
+	brand := c.Query("brand")
 	minPrice := c.Query("min_price")
 	maxPrice := c.Query("max_price")
 	condition := c.Query("condition")
 	location := c.Query("location")
-	sortBy := c.DefaultQuery("sort", "created_at")
+	sortBy := c.Query("sort")
 	sortOrder := c.DefaultQuery("order", "desc")
+	radius := c.Query("radius")
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-
-	// Build filter
-	filter := bson.M{"status": "active"}
-
-	if query != "" {
-		filter["$text"] = bson.M{"$search": query}
+	if page < 1 {
+		page = 1
 	}
-
-	if category != "" {
-		if categoryObjID, err := primitive.ObjectIDFromHex(category); err == nil {
-			filter["category_id"] = categoryObjID
-		}
+	if limit < 1 || limit > 100 {
+		limit = 20
 	}
 
-	if minPrice != "" || maxPrice != "" {
-		priceFilter := bson.M{}
-		if minPrice != "" {
-			if min, err := strconv.ParseFloat(minPrice, 64); err == nil {
-				priceFilter["$gte"] = min
-			}
-		}
-		if maxPrice != "" {
-			if max, err := strconv.ParseFloat(maxPrice, 64); err == nil {
-				priceFilter["$lte"] = max
-			}
-		}
-		if len(priceFilter) > 0 {
-			filter["price"] = priceFilter
-		}
+	near, err := nearPoint(c.Query("lat"), c.Query("lng"), c.Query("near"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid location", err.Error())
+		return
 	}
 
-	if condition != "" {
-		filter["condition"] = condition
+	searchQuery := search.Query{
+		Text:       query,
+		Categories: splitList(category),
+		Brands:     splitList(brand),
+		Conditions: splitList(condition),
+		Location:   location,
+		Near:       near,
+		Attributes: attributeFilters(c.QueryMap("spec")),
+		Ranges:     attributeRanges(c.QueryMap("spec_min"), c.QueryMap("spec_max")),
+		Sort:       searchSort(sortBy, sortOrder, query, near != nil),
+		Offset:     (page - 1) * limit,
+		Limit:      limit,
 	}
-
-	if location != "" {
-		filter["$or"] = []bson.M{
-			{"location.city": bson.M{"$regex": location, "$options": "i"}},
-			{"location.state": bson.M{"$regex": location, "$options": "i"}},
-		}
+	if min, err := strconv.ParseFloat(minPrice, 64); err == nil {
+		searchQuery.MinPrice = min
 	}
-
-	// Build sort
-	sort := bson.D{}
-	if sortOrder == "desc" {
-		sort = append(sort, bson.E{Key: sortBy, Value: -1})
-	} else {
-		sort = append(sort, bson.E{Key: sortBy, Value: 1})
+	if max, err := strconv.ParseFloat(maxPrice, 64); err == nil {
+		searchQuery.MaxPrice = max
+	}
+	if km, err := strconv.ParseFloat(radius, 64); err == nil && km > 0 {
+		searchQuery.RadiusKm = km
 	}
-
-	// Calculate pagination
-	skip := (page - 1) * limit
-	opts := options.Find().SetSort(sort).SetSkip(int64(skip)).SetLimit(int64(limit))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var products []models.Product
-	cursor, err := config.Coll.Products.Find(ctx, filter, opts)
+	result, err := h.searchService.Search(ctx, searchQuery)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "Search failed", err.Error())
 		return
 	}
-	defer cursor.Close(c)
 
-	if err = cursor.All(c, &products); err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to decode results", err.Error())
-		return
-	}
-
-	// Get total count
-	total, _ := config.Coll.Products.CountDocuments(ctx, filter)
-
-	utils.SuccessResponse(c, http.StatusOK, "Search completed", gin.H{
-		"products": products,
+	response := gin.H{
+		"products": result.Products,
+		"facets":   result.Facets,
+		"relaxed":  result.Relaxed,
 		"pagination": gin.H{
 			"page":  page,
 			"limit": limit,
-			"total": total,
+			"total": result.Total,
 		},
 		"filters_applied": gin.H{
-			"query":     query,
-			"category":  category,
-			"min_price": minPrice,
-			"max_price": maxPrice,
-			"condition": condition,
-			"location":  location,
+			"query":      query,
+			"category":   category,
+			"brand":      brand,
+			"min_price":  minPrice,
+			"max_price":  maxPrice,
+			"condition":  condition,
+			"location":   location,
+			"near":       near,
+			"radius":     radius,
+			"spec":       searchQuery.Attributes,
+			"spec_range": searchQuery.Ranges,
 		},
+	}
+	if page == 1 {
+		response["search_id"] = h.logSearch(c, "search", query, searchFilters(searchQuery), result.Total)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Search completed", response)
+}
+
+// searchFilters records the filters of an index query for search history
+func searchFilters(q search.Query) models.SearchFilters {
+	filters := models.SearchFilters{
+		Brands:         q.Brands,
+		Specifications: q.Attributes,
+		SortBy:         q.Sort,
+		Location:       models.LocationFilter{City: q.Location, Radius: q.RadiusKm},
+	}
+	for _, category := range q.Categories {
+		if id, err := primitive.ObjectIDFromHex(category); err == nil {
+			filters.Categories = append(filters.Categories, id)
+		}
+	}
+	for _, condition := range q.Conditions {
+		filters.Conditions = append(filters.Conditions, models.ProductCondition(condition))
+	}
+	if q.MinPrice > 0 {
+		filters.PriceMin = &q.MinPrice
+	}
+	if q.MaxPrice > 0 {
+		filters.PriceMax = &q.MaxPrice
+	}
+	if q.Near != nil {
+		filters.Location.Latitude, filters.Location.Longitude = q.Near.Lat, q.Near.Lng
+	}
+	return filters
+}
+
+// Geocode resolves an address against the offline Nigerian gazetteer
+func (h *SearchHandler) Geocode(c *gin.Context) {
+	address := c.Query("address")
+	city := c.Query("city")
+	state := c.Query("state")
+	if address == "" && city == "" && state == "" {
+		utils.BadRequestResponse(c, "Address, city or state is required", nil)
+		return
+	}
+
+	place, ok := geo.Geocode(address, city, state)
+	if !ok {
+		utils.NotFoundResponse(c, "Location not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Location found", place)
+}
+
+// nearPoint reads the point a search is centred on, either as lat/lng or
+// as a place name to geocode. It returns nil when neither is given.
+func nearPoint(lat, lng, near string) (*geo.Point, error) {
+	if lat != "" || lng != "" {
+		latitude, latErr := strconv.ParseFloat(lat, 64)
+		longitude, lngErr := strconv.ParseFloat(lng, 64)
+		point := geo.Point{Lat: latitude, Lng: longitude}
+		if latErr != nil || lngErr != nil || !point.Valid() {
+			return nil, errors.New("lat and lng must both be valid coordinates")
+		}
+		return &point, nil
+	}
+	if near != "" {
+		place, ok := geo.Geocode(near, near, near)
+		if !ok {
+			return nil, errors.New("unknown place: " + near)
+		}
+		return &place.Point, nil
+	}
+	return nil, nil
+}
+
+// RebuildIndex regenerates the product search index from the database
+func (h *SearchHandler) RebuildIndex(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	indexed, err := h.searchService.Rebuild(ctx)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to rebuild search index", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Search index rebuilt", gin.H{
+		"indexed": indexed,
 	})
 }
 
-// AdvancedSearch performs advanced search with filters
+// searchSort maps the listing sort parameters onto an index sort order.
+// Text searches rank by relevance unless another order is asked for, and
+// other searches near a point rank nearest first.
+func searchSort(sortBy, order, query string, near bool) string {
+	switch sortBy {
+	case "distance":
+		return "distance"
+	case "popular":
+		return "popular"
+	case "price":
+		if order == "asc" {
+			return "price_asc"
+		}
+		return "price_desc"
+	case "created_at", "newest":
+		return "newest"
+	case "relevance":
+		return "relevance"
+	}
+	if query != "" {
+		return "relevance"
+	}
+	if near {
+		return "distance"
+	}
+	return "newest"
+}
+
+// attributeFilters reads spec[name]=a,b parameters
+func attributeFilters(params map[string]string) map[string][]string {
+	filters := make(map[string][]string, len(params))
+	for name, value := range params {
+		if values := splitList(value); len(values) > 0 {
+			filters[name] = values
+		}
+	}
+	return filters
+}
+
+// attributeRanges reads spec_min[name] and spec_max[name] parameters
+func attributeRanges(mins, maxes map[string]string) map[string]search.Range {
+	ranges := make(map[string]search.Range)
+	for name, value := range mins {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			r := ranges[name]
+			r.Min = n
+			ranges[name] = r
+		}
+	}
+	for name, value := range maxes {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			r := ranges[name]
+			r.Max = n
+			ranges[name] = r
+		}
+	}
+	return ranges
+}
+
+// specificationFilter matches listings whose specifications hold any of the
+// given values per attribute, ignoring case, and whose numeric attributes
+// fall in range. Names that could address other fields are skipped.
+func specificationFilter(values map[string][]string, ranges map[string]search.Range) bson.M {
+	filter := bson.M{}
+	for name, wanted := range values {
+		if len(wanted) == 0 || strings.ContainsAny(name, ".$") {
+			continue
+		}
+		in := make([]interface{}, 0, len(wanted)*2)
+		for _, value := range wanted {
+			in = append(in, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"})
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				in = append(in, n)
+			}
+		}
+		filter["specifications."+name] = bson.M{"$in": in}
+	}
+	for name, r := range ranges {
+		if strings.ContainsAny(name, ".$") || (r.Min == 0 && r.Max == 0) {
+			continue
+		}
+		bounds := bson.M{}
+		if r.Min != 0 {
+			bounds["$gte"] = r.Min
+		}
+		if r.Max != 0 {
+			bounds["$lte"] = r.Max
+		}
+		filter["specifications."+name] = bounds
+	}
+	return filter
+}
+
+// splitList reads a comma-separated query parameter
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// AdvancedSearch performs advanced search with filters. A near filter
+// limits results to a radius around a point or a geocoded city and returns
+// each product's distance_km.
 func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 	var req struct {
-		Query      string   `json:"query"`
-		Categories []string `json:"categories"`
-		MinPrice   float64  `json:"min_price"`
-		MaxPrice   float64  `json:"max_price"`
-		Conditions []string `json:"conditions"`
-		Locations  []string `json:"locations"`
-		Rating     float64  `json:"min_rating"`
-		SortBy     string   `json:"sort_by"`
-		SortOrder  string   `json:"sort_order"`
-		Page       int      `json:"page"`
-		Limit      int      `json:"limit"`
+		Query               string                  `json:"query"`
+		Categories          []string                `json:"categories"`
+		MinPrice            float64                 `json:"min_price"`
+		MaxPrice            float64                 `json:"max_price"`
+		Conditions          []string                `json:"conditions"`
+		Locations           []string                `json:"locations"`
+		Near                *models.LocationFilter  `json:"near"`
+		Specifications      map[string][]string     `json:"specifications"`
+		SpecificationRanges map[string]search.Range `json:"specification_ranges"`
+		Rating              float64                 `json:"min_rating"`
+		SortBy              string                  `json:"sort_by"`
+		SortOrder           string                  `json:"sort_order"`
+		Page                int                     `json:"page"`
+		Limit               int                     `json:"limit"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -147,6 +345,16 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 		return
 	}
 
+	var near *geo.Point
+	if req.Near != nil {
+		point, err := locationPoint(*req.Near)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid near filter", err.Error())
+			return
+		}
+		near = point
+	}
+
 	// Set defaults
 	if req.Page == 0 {
 		req.Page = 1
@@ -154,9 +362,21 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 	if req.Limit == 0 {
 		req.Limit = 20
 	}
+	if req.SortBy == "" && near != nil {
+		req.SortBy = "distance_km"
+		if req.SortOrder == "" {
+			req.SortOrder = "asc"
+		}
+	}
 	if req.SortBy == "" {
 		req.SortBy = "created_at"
 	}
+	if req.SortBy == "popular" {
+		req.SortBy = "popularity_score"
+		if req.SortOrder == "" {
+			req.SortOrder = "desc"
+		}
+	}
 	if req.SortOrder == "" {
 		req.SortOrder = "desc"
 	}
@@ -166,9 +386,9 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 		{"$match": bson.M{"status": "active"}},
 	}
 
-	// Text search
+	// Text search through the product search index
 	if req.Query != "" {
-		pipeline[0]["$match"].(bson.M)["$text"] = bson.M{"$search": req.Query}
+		pipeline[0]["$match"].(bson.M)["_id"] = bson.M{"$in": h.searchService.MatchingIDs(req.Query, 1000)}
 	}
 
 	// Category filter
@@ -201,6 +421,11 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 		pipeline[0]["$match"].(bson.M)["condition"] = bson.M{"$in": req.Conditions}
 	}
 
+	// Category attribute filters
+	for field, condition := range specificationFilter(req.Specifications, req.SpecificationRanges) {
+		pipeline[0]["$match"].(bson.M)[field] = condition
+	}
+
 	// Location filter
 	if len(req.Locations) > 0 {
 		locationFilters := []bson.M{}
@@ -215,6 +440,25 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 		pipeline[0]["$match"].(bson.M)["$or"] = locationFilters
 	}
 
+	// Radius filter; $geoNear has to open the pipeline, so it takes over the match
+	if near != nil {
+		geoNear := bson.M{
+			"near": bson.M{
+				"type":        "Point",
+				"coordinates": near.Coordinates(),
+			},
+			"key":                "location.coordinates",
+			"distanceField":      "distance_km",
+			"distanceMultiplier": 0.001,
+			"spherical":          true,
+			"query":              pipeline[0]["$match"],
+		}
+		if req.Near.Radius > 0 {
+			geoNear["maxDistance"] = req.Near.Radius * 1000
+		}
+		pipeline[0] = bson.M{"$geoNear": geoNear}
+	}
+
 	// Add rating lookup and filter
 	if req.Rating > 0 {
 		pipeline = append(pipeline, bson.M{
@@ -262,73 +506,170 @@ func (h *SearchHandler) AdvancedSearch(c *gin.Context) {
 	var products []bson.M
 	cursor.All(c, &products)
 
-	utils.SuccessResponse(c, http.StatusOK, "Advanced search completed", gin.H{
+	response := gin.H{
 		"products": products,
 		"pagination": gin.H{
 			"page": req.Page,
 			"limit": req.Limit,
 			"total": len(products),
 		},
-	})
+	}
+	if req.Page == 1 {
+		filters := models.SearchFilters{
+			Specifications: req.Specifications,
+			SortBy:         req.SortBy,
+			SortOrder:      req.SortOrder,
+		}
+		for _, cat := range req.Categories {
+			if objID, err := primitive.ObjectIDFromHex(cat); err == nil {
+				filters.Categories = append(filters.Categories, objID)
+			}
+		}
+		for _, condition := range req.Conditions {
+			filters.Conditions = append(filters.Conditions, models.ProductCondition(condition))
+		}
+		if req.MinPrice > 0 {
+			filters.PriceMin = &req.MinPrice
+		}
+		if req.MaxPrice > 0 {
+			filters.PriceMax = &req.MaxPrice
+		}
+		if req.Near != nil {
+			filters.Location = *req.Near
+		}
+		response["search_id"] = h.logSearch(c, "advanced", req.Query, filters, len(products))
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Advanced search completed", response)
 }
 
-// GetSearchSuggestions provides search suggestions
+// locationPoint reads the centre of a near filter, geocoding the city and
+// state when no coordinates are given
+func locationPoint(filter models.LocationFilter) (*geo.Point, error) {
+	if filter.Latitude != 0 || filter.Longitude != 0 {
+		point := geo.Point{Lat: filter.Latitude, Lng: filter.Longitude}
+		if !point.Valid() {
+			return nil, errors.New("latitude and longitude must be valid coordinates")
+		}
+		return &point, nil
+	}
+	if place, ok := geo.Geocode("", filter.City, filter.State); ok {
+		return &place.Point, nil
+	}
+	return nil, errors.New("latitude and longitude, or a known city or state, are required")
+}
+
+// GetSearchSuggestions autocompletes a partly typed query from popular
+// searches, listing titles, brands and categories
 func (h *SearchHandler) GetSearchSuggestions(c *gin.Context) {
 	query := c.Query("q")
 	if query == "" {
 		utils.BadRequestResponse(c, "Query parameter is required", nil)
 		return
 	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "5"))
+	if limit < 1 || limit > 10 {
+		limit = 5
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	suggestions, err := h.analyticsService.Suggest(ctx, query, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get search suggestions", err.Error())
+		return
+	}
 
-	// Get product name suggestions
-	productPipeline := []bson.M{
-		{"$match": bson.M{
-			"status": "active",
-			"name": bson.M{"$regex": query, "$options": "i"},
-		}},
-		{"$project": bson.M{"name": 1}},
-		{"$limit": 5},
+	utils.SuccessResponse(c, http.StatusOK, "Search suggestions retrieved", suggestions)
+}
+
+// RecordSearchClick credits a result click to the search it came from, for
+// click-through reporting
+func (h *SearchHandler) RecordSearchClick(c *gin.Context) {
+	var req struct {
+		SearchID  string `json:"search_id" binding:"required"`
+		ProductID string `json:"product_id" binding:"required"`
+		Position  int    `json:"position" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	searchID, err := primitive.ObjectIDFromHex(req.SearchID)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid search ID", nil)
+		return
+	}
+	productID, err := primitive.ObjectIDFromHex(req.ProductID)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid product ID", nil)
+		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	productCursor, _ := config.Coll.Products.Aggregate(ctx, productPipeline)
-	var productSuggestions []bson.M
-	productCursor.All(c, &productSuggestions)
+	if err := h.analyticsService.RecordClick(ctx, searchID, productID, req.Position); err != nil {
+		if errors.Is(err, services.ErrSearchNotFound) {
+			utils.NotFoundResponse(c, "Search not found")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to record click", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Click recorded", nil)
+}
 
-	// Get category suggestions
-	categoryPipeline := []bson.M{
-		{"$match": bson.M{
-			"name": bson.M{"$regex": query, "$options": "i"},
-		}},
-		{"$project": bson.M{"name": 1}},
-		{"$limit": 3},
+// GetSearchReport reports top queries, queries that found nothing and
+// click-through over the last days (admin)
+func (h *SearchHandler) GetSearchReport(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
 	}
 
-	categoryCursor, _ := config.Coll.Categories.Aggregate(ctx, categoryPipeline)
-	var categorySuggestions []bson.M
-	categoryCursor.All(c, &categorySuggestions)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	// Popular searches (mock data - in real app, track search queries)
-	popularSearches := []string{
-		"iPhone", "Samsung Galaxy", "MacBook", "PlayStation", "Nike Shoes",
-		"Toyota Camry", "Honda Civic", "Furniture", "Electronics", "Fashion",
+	report, err := h.analyticsService.Report(ctx, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to build search report", err.Error())
+		return
 	}
 
-	var filteredPopular []string
-	for _, search := range popularSearches {
-		if len(filteredPopular) >= 3 {
-			break
-		}
-		if strings.Contains(strings.ToLower(search), strings.ToLower(query)) {
-			filteredPopular = append(filteredPopular, search)
+	utils.SuccessResponse(c, http.StatusOK, "Search report retrieved", report)
+}
+
+// logSearch records a search in the background and returns its ID, which
+// the client sends back with result clicks
+func (h *SearchHandler) logSearch(c *gin.Context, source, query string, filters models.SearchFilters, results int) primitive.ObjectID {
+	entry := services.SearchLog{
+		ID:        primitive.NewObjectID(),
+		Query:     query,
+		Source:    source,
+		Filters:   filters,
+		Results:   results,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	if userID, exists := c.Get("user_id"); exists {
+		if userObjID, err := primitive.ObjectIDFromHex(userID.(string)); err == nil {
+			entry.UserID = &userObjID
 		}
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, "Search suggestions retrieved", gin.H{
-		"products": productSuggestions,
-		"categories": categorySuggestions,
-		"popular": filteredPopular,
-	})
-}
\ No newline at end of file
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := h.analyticsService.Log(ctx, entry); err != nil {
+			log.Printf("Failed to log search: %v", err)
+		}
+	}()
+	return entry.ID
+}
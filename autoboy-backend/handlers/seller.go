@@ -420,7 +420,12 @@ func (h *SellerHandler) GetProducts(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := config.Coll.Products.Find(ctx, bson.M{"seller_id": sellerObjID})
+	filter := bson.M{"seller_id": sellerObjID}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := config.Coll.Products.Find(ctx, filter)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to fetch products", err.Error())
 		return
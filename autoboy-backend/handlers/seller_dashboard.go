@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
 
+	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -11,10 +16,12 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type SellerDashboardHandler struct{}
+type SellerDashboardHandler struct {
+	productAnalyticsService *services.ProductAnalyticsService
+}
 
-func NewSellerDashboardHandler() *SellerDashboardHandler {
-	return &SellerDashboardHandler{}
+func NewSellerDashboardHandler(productAnalyticsService *services.ProductAnalyticsService) *SellerDashboardHandler {
+	return &SellerDashboardHandler{productAnalyticsService: productAnalyticsService}
 }
 
 // GetSellerDashboard gets seller dashboard data
@@ -110,6 +117,74 @@ func (h *SellerDashboardHandler) GetSellerRevenueAnalytics(c *gin.Context) {
 	})
 }
 
+// GetSellerListingAnalytics gets views, funnel events and conversion for
+// each of the seller's listings over the last `days` days
+func (h *SellerDashboardHandler) GetSellerListingAnalytics(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	from, to := analyticsPeriod(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listings, err := h.productAnalyticsService.ForSeller(ctx, userObjID, from, to)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get listing analytics", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Listing analytics retrieved", gin.H{
+		"from":     services.AnalyticsDay(from),
+		"to":       services.AnalyticsDay(to),
+		"listings": listings,
+	})
+}
+
+// GetSellerListingAnalyticsDetail gets one of the seller's listings' daily
+// analytics over the last `days` days
+func (h *SellerDashboardHandler) GetSellerListingAnalyticsDetail(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid product ID", err.Error())
+		return
+	}
+	from, to := analyticsPeriod(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var product models.Product
+	err = config.Coll.Products.FindOne(ctx, bson.M{
+		"_id":       productID,
+		"seller_id": userObjID,
+		"status":    bson.M{"$ne": models.ProductStatusDeleted},
+	}).Decode(&product)
+	if err != nil {
+		utils.NotFoundResponse(c, "Product not found")
+		return
+	}
+
+	listing, err := h.productAnalyticsService.ForProduct(ctx, &product, from, to)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get listing analytics", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Listing analytics retrieved", listing)
+}
+
+// analyticsPeriod reads the `days` query parameter (default 30, at most 365)
+// into a period ending today
+func analyticsPeriod(c *gin.Context) (time.Time, time.Time) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 {
+		days = 30
+	}
+	days = min(days, 365)
+	to := time.Now()
+	return to.AddDate(0, 0, -(days - 1)), to
+}
+
 // GetSellerProfile gets seller profile
 func (h *SellerDashboardHandler) GetSellerProfile(c *gin.Context) {
 	userID := c.GetString("user_id")
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SocialRegisterRequest finishes signing up with Google or Apple: the
+// provider gave the email and name, the rest comes from the user
+type SocialRegisterRequest struct {
+	RegistrationToken string `json:"registration_token" binding:"required"`
+	Username          string `json:"username" binding:"required,min=3,max=30"`
+	Phone             string `json:"phone" binding:"required"`
+	FirstName         string `json:"first_name"`
+	LastName          string `json:"last_name"`
+	UserType          string `json:"user_type" binding:"required,oneof=buyer seller"`
+	AcceptTerms       bool   `json:"accept_terms" binding:"required"`
+
+	// Seller-specific fields
+	ShopName     string `json:"shop_name,omitempty"`
+	ShopLocation string `json:"shop_location,omitempty"`
+	AccountType  string `json:"account_type,omitempty"`
+}
+
+// GetSocialProviders lists the providers users can sign in with
+func (h *AuthHandler) GetSocialProviders(c *gin.Context) {
+	utils.SuccessResponse(c, http.StatusOK, "Sign-in providers retrieved successfully", gin.H{
+		"providers": h.socialLogin.Providers(),
+	})
+}
+
+// AuthorizeSocial starts a browser sign-in with a provider and returns the
+// URL to send the user to
+func (h *AuthHandler) AuthorizeSocial(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authorization, err := h.socialLogin.Authorize(ctx, models.SocialProvider(c.Param("provider")), c.ClientIP())
+	if !handleSocialLoginError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Redirect to the provider to sign in", authorization)
+}
+
+// SocialCallback finishes a browser sign-in with the code and state the
+// provider redirected back with. Apple posts them as a form, with the
+// user's name on their first sign-in.
+func (h *AuthHandler) SocialCallback(c *gin.Context) {
+	var req struct {
+		Code      string `json:"code" form:"code" binding:"required"`
+		State     string `json:"state" form:"state" binding:"required"`
+		User      string `json:"user" form:"user"`
+		FirstName string `json:"first_name" form:"first_name"`
+		LastName  string `json:"last_name" form:"last_name"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	name := &services.SocialIdentity{FirstName: req.FirstName, LastName: req.LastName}
+	if req.User != "" {
+		var appleUser struct {
+			Name struct {
+				FirstName string `json:"firstName"`
+				LastName  string `json:"lastName"`
+			} `json:"name"`
+		}
+		if json.Unmarshal([]byte(req.User), &appleUser) == nil && name.FirstName == "" {
+			name.FirstName, name.LastName = appleUser.Name.FirstName, appleUser.Name.LastName
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := h.socialLogin.Callback(ctx, models.SocialProvider(c.Param("provider")), req.Code, req.State, name)
+	if !handleSocialLoginError(c, err) {
+		return
+	}
+	h.finishSocialSignIn(ctx, c, result)
+}
+
+// SocialTokenSignIn signs in with an ID token from the Google or Apple SDK
+// in the mobile app
+func (h *AuthHandler) SocialTokenSignIn(c *gin.Context) {
+	var req struct {
+		IDToken   string `json:"id_token" binding:"required"`
+		Nonce     string `json:"nonce"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := h.socialLogin.SignInWithIDToken(ctx, models.SocialProvider(c.Param("provider")), req.IDToken, req.Nonce,
+		&services.SocialIdentity{FirstName: req.FirstName, LastName: req.LastName})
+	if !handleSocialLoginError(c, err) {
+		return
+	}
+	h.finishSocialSignIn(ctx, c, result)
+}
+
+// CompleteSocialSignup opens an account for a Google or Apple user who had
+// none, and signs them in. Their phone number still needs verifying.
+func (h *AuthHandler) CompleteSocialSignup(c *gin.Context) {
+	var req SocialRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	if !utils.IsValidPhone(req.Phone) {
+		utils.BadRequestResponse(c, "Invalid phone number format", nil)
+		return
+	}
+	if req.UserType == "seller" {
+		if req.ShopName == "" || req.ShopLocation == "" || req.AccountType == "" {
+			utils.BadRequestResponse(c, "Missing required seller fields: shop_name, shop_location, account_type", nil)
+			return
+		}
+		if req.AccountType != "business" && req.AccountType != "individual" {
+			utils.BadRequestResponse(c, "Invalid account_type. Must be 'business' or 'individual'", nil)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.socialLogin.Register(ctx, req.RegistrationToken, services.SocialRegistration{
+		Username:     req.Username,
+		Phone:        req.Phone,
+		UserType:     models.UserType(req.UserType),
+		FirstName:    req.FirstName,
+		LastName:     req.LastName,
+		ShopName:     req.ShopName,
+		ShopLocation: req.ShopLocation,
+		AccountType:  req.AccountType,
+	})
+	if !handleSocialLoginError(c, err) {
+		return
+	}
+
+	// Send the phone verification OTP, as registration does
+	phoneOTP := utils.GenerateOTP()
+	go h.smsService.SendOTP(user.Phone, phoneOTP)
+	config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"phone_otp":      phoneOTP,
+			"otp_expires_at": time.Now().Add(10 * time.Minute),
+		}},
+	)
+
+	h.signIn(c, user)
+}
+
+// UnlinkSocialAccount stops a provider being used to sign in to the account
+func (h *AuthHandler) UnlinkSocialAccount(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleSocialLoginError(c, h.socialLogin.Unlink(ctx, user, models.SocialProvider(c.Param("provider")))) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Sign-in provider unlinked", nil)
+}
+
+// SendPhoneLoginCode texts a sign-in code to a verified phone number. The
+// response is the same whether or not the number has an account.
+func (h *AuthHandler) SendPhoneLoginCode(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if !utils.IsValidPhone(req.Phone) {
+		utils.BadRequestResponse(c, "Invalid phone number format", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const sent = "If the number belongs to an account, a sign-in code has been sent"
+	var user models.User
+	err := config.Coll.Users.FindOne(ctx, bson.M{"phone": utils.NormalizePhone(req.Phone)}).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && (!user.IsPhoneVerified || user.Status != models.UserStatusActive)) {
+		utils.SuccessResponse(c, http.StatusOK, sent, nil)
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to send sign-in code", err.Error())
+		return
+	}
+
+	if until, err := h.loginGuard.Allow(ctx, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		lockoutResponse(c, err, until)
+		return
+	}
+	if !handleTwoFactorError(c, h.twoFactorService.SendPhoneLoginCode(ctx, &user)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, sent, nil)
+}
+
+// PhoneLogin signs in with a phone number and the code texted to it,
+// without a password. Wrong codes count towards the account lockout.
+func (h *AuthHandler) PhoneLogin(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+		Code  string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := config.Coll.Users.FindOne(ctx, bson.M{"phone": utils.NormalizePhone(req.Phone), "is_phone_verified": true}).Decode(&user)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "Invalid phone number or code")
+		return
+	}
+
+	if until, err := h.loginGuard.Allow(ctx, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		lockoutResponse(c, err, until)
+		return
+	}
+
+	err = h.twoFactorService.CheckPhoneLoginCode(ctx, &user, req.Code)
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+		if until, err := h.loginGuard.RecordFailure(ctx, &user, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			lockoutResponse(c, err, until)
+			return
+		}
+		utils.UnauthorizedResponse(c, "Invalid phone number or code")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to verify sign-in code", err.Error())
+		return
+	}
+
+	if user.Status != models.UserStatusActive {
+		utils.UnauthorizedResponse(c, "Account is not active. Please contact support.")
+		return
+	}
+
+	h.loginGuard.RecordSuccess(ctx, &user, c.ClientIP())
+	h.completeSignIn(ctx, c, &user)
+}
+
+// finishSocialSignIn signs in the user a provider identity belongs to, or
+// asks the client to finish registering. Proving the identity lifts any
+// lock from password guessing, as the emailed unlock link does.
+func (h *AuthHandler) finishSocialSignIn(ctx context.Context, c *gin.Context, result *services.SocialSignIn) {
+	if result.Signup != nil {
+		utils.SuccessResponse(c, http.StatusOK, "Finish signing up to continue", gin.H{
+			"registration_required": true,
+			"registration":          result.Signup,
+		})
+		return
+	}
+
+	user := result.User
+	if user.Status != models.UserStatusActive {
+		utils.UnauthorizedResponse(c, "Account is not active. Please contact support.")
+		return
+	}
+
+	h.loginGuard.RecordSuccess(ctx, user, c.ClientIP())
+	h.completeSignIn(ctx, c, user)
+}
+
+// handleSocialLoginError writes the response for a social sign-in service
+// error and reports whether the request may continue
+func handleSocialLoginError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrSocialProviderDisabled):
+		utils.NotFoundResponse(c, err.Error())
+	case errors.Is(err, services.ErrSocialTokenInvalid),
+		errors.Is(err, services.ErrSocialStateInvalid),
+		errors.Is(err, services.ErrSocialSignupNotFound):
+		utils.UnauthorizedResponse(c, err.Error())
+	case errors.Is(err, services.ErrSocialAccountExists):
+		utils.ConflictResponse(c, err.Error())
+	case errors.Is(err, services.ErrSocialEmailUnverified),
+		errors.Is(err, services.ErrSocialNotLinked),
+		errors.Is(err, services.ErrSocialLastSignIn):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Social sign-in failed", err.Error())
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -11,10 +12,12 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type SwapHandler struct{}
+type SwapHandler struct {
+	eventTracker *services.EventTracker
+}
 
-func NewSwapHandler() *SwapHandler {
-	return &SwapHandler{}
+func NewSwapHandler(eventTracker *services.EventTracker) *SwapHandler {
+	return &SwapHandler{eventTracker: eventTracker}
 }
 
 // CreateSwapDeal creates a new swap deal proposal
@@ -63,6 +66,14 @@ func (h *SwapHandler) CreateSwapDeal(c *gin.Context) {
 		return
 	}
 
+	h.eventTracker.TrackEvent(models.ProductEvent{
+		ProductID: recipientProductObjID,
+		Type:      models.ProductEventSwapRequest,
+		UserID:    &userObjID,
+		SessionID: trackingSession(c),
+		IPAddress: c.ClientIP(),
+	})
+
 	utils.SuccessResponse(c, http.StatusCreated, "Swap deal created successfully", gin.H{
 		"swap_id": swapDeal.ID,
 		"swap_number": swapDeal.SwapNumber,
@@ -7,6 +7,7 @@ import (
 
 	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -229,6 +230,7 @@ func (h *SystemHandler) createDefaultCategories(ctx context.Context) error {
 
 	var docs []interface{}
 	for _, cat := range categories {
+		cat.Attributes = services.DefaultCategoryAttributes(cat.Slug)
 		docs = append(docs, cat)
 	}
 
@@ -241,6 +243,7 @@ func (h *SystemHandler) createAdminUser(ctx context.Context) error {
 	admin := models.User{
 		ID: primitive.NewObjectID(), Username: "admin", Email: "admin@autoboy.ng", Password: hashedPassword,
 		Phone: "2348000000000", UserType: models.UserTypeAdmin, Status: models.UserStatusActive,
+		AdminRoles: []string{models.AdminRoleSuperAdmin},
 		IsEmailVerified: true, IsPhoneVerified: true, CreatedAt: time.Now(), UpdatedAt: time.Now(),
 		Profile: models.Profile{
 			FirstName: "System", LastName: "Administrator", VerificationStatus: models.VerificationStatusVerified,
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TwoFactorHandler lets users turn two-factor authentication on and off and
+// verify their session before sensitive actions
+type TwoFactorHandler struct {
+	twoFactorService *services.TwoFactorService
+}
+
+// twoFactorCodeRequest is a second-factor code; the method defaults to the
+// user's own
+type twoFactorCodeRequest struct {
+	Code   string                 `json:"code" binding:"required"`
+	Method models.TwoFactorMethod `json:"method"`
+}
+
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{twoFactorService: twoFactorService}
+}
+
+// GetTwoFactorStatus returns the user's two-factor settings
+func (h *TwoFactorHandler) GetTwoFactorStatus(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	utils.SuccessResponse(c, http.StatusOK, "Two-factor status retrieved successfully", h.twoFactorService.Status(user))
+}
+
+// SetupTOTP starts authenticator app enrolment, returning the secret and
+// the otpauth:// URI to show as a QR code
+func (h *TwoFactorHandler) SetupTOTP(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	setup, err := h.twoFactorService.SetupTOTP(ctx, user)
+	if !handleTwoFactorError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Scan the QR code with your authenticator app, then confirm a code", setup)
+}
+
+// EnableTOTP turns authenticator app two-factor on with a first code, and
+// returns the one-time backup codes
+func (h *TwoFactorHandler) EnableTOTP(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	codes, err := h.twoFactorService.EnableTOTP(ctx, user, req.Code)
+	if !handleTwoFactorError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Two-factor authentication enabled. Store your backup codes somewhere safe", gin.H{
+		"backup_codes": codes,
+	})
+}
+
+// SetupSMS texts a code to confirm the user's phone for SMS two-factor
+func (h *TwoFactorHandler) SetupSMS(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleTwoFactorError(c, h.twoFactorService.SetupSMS(ctx, user)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Verification code sent", nil)
+}
+
+// EnableSMS turns SMS two-factor on with the code SetupSMS sent, and
+// returns the one-time backup codes
+func (h *TwoFactorHandler) EnableSMS(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	codes, err := h.twoFactorService.EnableSMS(ctx, user, req.Code)
+	if !handleTwoFactorError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Two-factor authentication enabled. Store your backup codes somewhere safe", gin.H{
+		"backup_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns two-factor off; it takes the password and a
+// current code
+func (h *TwoFactorHandler) DisableTwoFactor(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	var req struct {
+		Password string                 `json:"password" binding:"required"`
+		Code     string                 `json:"code" binding:"required"`
+		Method   models.TwoFactorMethod `json:"method"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+	if !utils.CheckPasswordHash(req.Password, user.Password) {
+		utils.BadRequestResponse(c, "Password is incorrect", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleTwoFactorError(c, h.twoFactorService.Disable(ctx, user, req.Method, req.Code)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Two-factor authentication disabled", nil)
+}
+
+// RegenerateBackupCodes replaces the user's backup codes
+func (h *TwoFactorHandler) RegenerateBackupCodes(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	codes, err := h.twoFactorService.RegenerateBackupCodes(ctx, user, req.Method, req.Code)
+	if !handleTwoFactorError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Backup codes regenerated. The old ones no longer work", gin.H{
+		"backup_codes": codes,
+	})
+}
+
+// SendStepUpCode texts a code for verifying the current session
+func (h *TwoFactorHandler) SendStepUpCode(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	sessionID, _ := primitive.ObjectIDFromHex(c.GetString("session_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleTwoFactorError(c, h.twoFactorService.SendStepUpCode(ctx, user, sessionID)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Verification code sent", nil)
+}
+
+// StepUp verifies the current session with a second factor so it can make
+// sensitive changes, such as withdrawals, for a few minutes
+func (h *TwoFactorHandler) StepUp(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+	sessionID, _ := primitive.ObjectIDFromHex(c.GetString("session_id"))
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	until, err := h.twoFactorService.StepUp(ctx, user, sessionID, req.Method, req.Code)
+	if !handleTwoFactorError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Session verified", gin.H{
+		"verified_until": until,
+	})
+}
+
+// handleTwoFactorError writes the response for a two-factor service error
+// and reports whether the request may continue
+func handleTwoFactorError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrChallengeNotFound),
+		errors.Is(err, services.ErrChallengeAttempts),
+		errors.Is(err, services.ErrStepUpSessionRequired):
+		utils.UnauthorizedResponse(c, err.Error())
+	case errors.Is(err, services.ErrTwoFactorCodeTooSoon),
+		errors.Is(err, services.ErrStepUpLocked):
+		utils.ErrorResponse(c, http.StatusTooManyRequests, err.Error(), nil)
+	case errors.Is(err, services.ErrTwoFactorEnabled),
+		errors.Is(err, services.ErrTwoFactorNotEnabled),
+		errors.Is(err, services.ErrTwoFactorSetupMissing),
+		errors.Is(err, services.ErrTwoFactorMethod),
+		errors.Is(err, services.ErrInvalidTwoFactorCode),
+		errors.Is(err, services.ErrPhoneNotVerified):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Two-factor authentication failed", err.Error())
+	}
+	return false
+}
@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 
 	"autoboy-backend/config"
+	"autoboy-backend/middleware"
 	"autoboy-backend/models"
 	"autoboy-backend/services"
 	"autoboy-backend/utils"
@@ -119,17 +121,17 @@ func (h *UserHandler) DeleteAddress(c *gin.Context) {
 // Wishlist methods moved to dedicated WishlistHandler
 // These are kept for backward compatibility but should use WishlistHandler
 func (h *UserHandler) GetWishlist(c *gin.Context) {
-	wishlistHandler := NewWishlistHandler()
+	wishlistHandler := NewWishlistHandler(services.GetServices().Events)
 	wishlistHandler.GetWishlist(c)
 }
 
 func (h *UserHandler) AddToWishlist(c *gin.Context) {
-	wishlistHandler := NewWishlistHandler()
+	wishlistHandler := NewWishlistHandler(services.GetServices().Events)
 	wishlistHandler.AddToWishlist(c)
 }
 
 func (h *UserHandler) RemoveFromWishlist(c *gin.Context) {
-	wishlistHandler := NewWishlistHandler()
+	wishlistHandler := NewWishlistHandler(services.GetServices().Events)
 	wishlistHandler.RemoveFromWishlist(c)
 }
 
@@ -251,7 +253,16 @@ func (h *UserHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, "Password changed successfully", nil)
+	// Sign out every other device; this one stays signed in
+	currentSession, _ := primitive.ObjectIDFromHex(c.GetString("session_id"))
+	revoked, err := middleware.RevokeUserSessions(currentUser.ID, currentSession, models.SessionRevokedPasswordChange)
+	if err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", currentUser.ID.Hex(), err)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Password changed successfully", gin.H{
+		"sessions_revoked": revoked,
+	})
 }
 
 func (h *UserHandler) DeleteAccount(c *gin.Context) {
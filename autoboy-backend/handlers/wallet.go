@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -12,10 +18,18 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type WalletHandler struct{}
+type WalletHandler struct {
+	walletService     *services.WalletService
+	paymentService    *services.PaymentService
+	withdrawalService *services.WithdrawalService
+}
 
-func NewWalletHandler() *WalletHandler {
-	return &WalletHandler{}
+func NewWalletHandler(walletService *services.WalletService, paymentService *services.PaymentService, withdrawalService *services.WithdrawalService) *WalletHandler {
+	return &WalletHandler{
+		walletService:     walletService,
+		paymentService:    paymentService,
+		withdrawalService: withdrawalService,
+	}
 }
 
 // GetWalletBalance gets user's wallet balance
@@ -23,21 +37,131 @@ func (h *WalletHandler) GetWalletBalance(c *gin.Context) {
 	userID := c.GetString("user_id")
 	userObjID, _ := primitive.ObjectIDFromHex(userID)
 
-	var wallet models.Wallet
-	err := utils.DB.Collection("wallets").FindOne(c, bson.M{"user_id": userObjID}).Decode(&wallet)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	wallet, err := h.walletService.GetOrCreateWallet(ctx, userObjID)
 	if err != nil {
-		// Create wallet if doesn't exist
-		wallet = models.Wallet{
-			ID:      primitive.NewObjectID(),
-			UserID:  userObjID,
-			Balance: 0,
-		}
-		utils.DB.Collection("wallets").InsertOne(c, wallet)
+		utils.InternalServerErrorResponse(c, "Failed to load wallet", err.Error())
+		return
 	}
 
 	utils.SuccessResponse(c, http.StatusOK, "Wallet balance retrieved", gin.H{
-		"balance": wallet.Balance,
-		"currency": "NGN",
+		"balance":      wallet.Balance,
+		"held_balance": wallet.HeldBalance,
+		"currency":     wallet.Currency,
+	})
+}
+
+// InitializeTopup starts a gateway checkout that credits the wallet once paid
+func (h *WalletHandler) InitializeTopup(c *gin.Context) {
+	userID := c.GetString("user_id")
+	userObjID, _ := primitive.ObjectIDFromHex(userID)
+
+	var req struct {
+		Amount   float64 `json:"amount" binding:"required,min=100"`
+		Currency string  `json:"currency"`
+		Gateway  string  `json:"gateway"`
+		Callback string  `json:"callback_url"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	email := ""
+	if user, ok := c.Get("user"); ok {
+		email = user.(*models.User).Email
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Top-ups are credited 1:1, so they must be paid in the wallet's currency
+	wallet, err := h.walletService.GetOrCreateWallet(ctx, userObjID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to load wallet", err.Error())
+		return
+	}
+	if req.Currency != "" && !strings.EqualFold(req.Currency, wallet.Currency) {
+		utils.BadRequestResponse(c, services.ErrWalletCurrency.Error(), gin.H{"wallet_currency": wallet.Currency})
+		return
+	}
+
+	payment, result, err := h.paymentService.InitializePayment(ctx, services.PaymentRequest{
+		UserID:      userObjID,
+		Type:        models.PaymentTypeTopup,
+		Amount:      utils.RoundCurrency(req.Amount),
+		Currency:    wallet.Currency,
+		Email:       email,
+		Gateway:     models.PaymentGateway(strings.ToLower(req.Gateway)),
+		CallbackURL: req.Callback,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.GetHeader("User-Agent"),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrGatewayNotConfigured) {
+			utils.InternalServerErrorResponse(c, "Payment service not configured", err.Error())
+			return
+		}
+		utils.BadRequestResponse(c, "Top-up initialization failed", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Top-up initialized successfully", gin.H{
+		"checkout_url": result.AuthorizationURL,
+		"reference":    payment.GatewayReference,
+		"access_code":  result.AccessCode,
+		"gateway":      payment.PaymentGateway,
+		"amount":       payment.Amount,
+		"currency":     payment.Currency,
+	})
+}
+
+// VerifyTopup checks a top-up with its gateway and credits the wallet if it
+// was paid. The webhook credits it too; whichever arrives first wins.
+func (h *WalletHandler) VerifyTopup(c *gin.Context) {
+	userID := c.GetString("user_id")
+	userObjID, _ := primitive.ObjectIDFromHex(userID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	payment, err := h.paymentService.FindByReference(ctx, c.Param("reference"))
+	if err != nil || payment.UserID != userObjID || payment.PaymentType != models.PaymentTypeTopup {
+		utils.NotFoundResponse(c, "Top-up not found")
+		return
+	}
+
+	if payment.Status != models.PaymentStatusPaid {
+		payment, err = h.paymentService.VerifyPayment(ctx, payment.GatewayReference)
+		if err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to verify top-up", err.Error())
+			return
+		}
+		if payment.Status != models.PaymentStatusPaid {
+			utils.BadRequestResponse(c, "Top-up status: "+string(payment.Status), payment)
+			return
+		}
+	}
+
+	txn, err := h.walletService.CreditTopup(ctx, payment)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to credit wallet", err.Error())
+		return
+	}
+
+	wallet, err := h.walletService.GetOrCreateWallet(ctx, userObjID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to load wallet", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Wallet topped up successfully", gin.H{
+		"payment":     payment,
+		"transaction": txn,
+		"balance":     wallet.Balance,
 	})
 }
 
@@ -66,60 +190,186 @@ func (h *WalletHandler) GetWalletTransactions(c *gin.Context) {
 	})
 }
 
-// RequestWithdrawal creates a withdrawal request
+// RequestWithdrawal holds funds in the wallet and queues a withdrawal to one
+// of the user's verified bank accounts
 func (h *WalletHandler) RequestWithdrawal(c *gin.Context) {
-	userID := c.GetString("user_id")
-	userObjID, _ := primitive.ObjectIDFromHex(userID)
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
 
 	var req struct {
-		Amount      float64 `json:"amount" binding:"required,min=1000"`
-		BankAccount string  `json:"bank_account" binding:"required"`
-		BankName    string  `json:"bank_name" binding:"required"`
-		AccountName string  `json:"account_name" binding:"required"`
+		Amount        float64 `json:"amount" binding:"required,gt=0"`
+		BankAccountID string  `json:"bank_account_id" binding:"required"`
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
 		return
 	}
 
-	// Check wallet balance
-	var wallet models.Wallet
-	err := utils.DB.Collection("wallets").FindOne(c, bson.M{"user_id": userObjID}).Decode(&wallet)
+	bankAccountID, err := primitive.ObjectIDFromHex(req.BankAccountID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "Wallet not found", err.Error())
+		utils.BadRequestResponse(c, "Invalid bank account ID", nil)
 		return
 	}
 
-	if wallet.Balance < req.Amount {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Insufficient balance", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	withdrawal, err := h.withdrawalService.Request(ctx, userObjID, req.Amount, bankAccountID)
+	if !handleWithdrawalError(c, err) {
 		return
 	}
 
-	// Create withdrawal request
-	withdrawal := models.WithdrawalRequest{
-		ID:          primitive.NewObjectID(),
-		UserID:      userObjID,
-		Amount:      req.Amount,
-		BankAccount: req.BankAccount,
-		BankName:    req.BankName,
-		AccountName: req.AccountName,
-		Status:      "pending",
-		CreatedAt:   primitive.NewDateTimeFromTime(utils.GetCurrentTime()),
+	utils.SuccessResponse(c, http.StatusCreated, "Withdrawal request created successfully", withdrawal)
+}
+
+// GetWithdrawals lists the user's withdrawals
+func (h *WalletHandler) GetWithdrawals(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(50)
+	cursor, err := config.Coll.Withdrawals.Find(ctx, bson.M{"user_id": userObjID}, opts)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch withdrawals", err.Error())
+		return
 	}
+	defer cursor.Close(ctx)
+
+	withdrawals := []models.Withdrawal{}
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to decode withdrawals", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Withdrawals retrieved successfully", withdrawals)
+}
+
+// GetWithdrawalLimits returns the user's withdrawal limits and what has been used
+func (h *WalletHandler) GetWithdrawalLimits(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	limits, usage, err := h.withdrawalService.Limits(ctx, userObjID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch withdrawal limits", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Withdrawal limits retrieved successfully", gin.H{
+		"limits": limits,
+		"usage":  usage,
+	})
+}
 
-	_, err = utils.DB.Collection("withdrawal_requests").InsertOne(c, withdrawal)
+// CancelWithdrawal cancels a withdrawal that has not been sent and returns the funds
+func (h *WalletHandler) CancelWithdrawal(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	withdrawalID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to create withdrawal request", err.Error())
+		utils.BadRequestResponse(c, "Invalid withdrawal ID", nil)
 		return
 	}
 
-	// Update wallet balance (hold the amount)
-	update := bson.M{"$inc": bson.M{"balance": -req.Amount, "held_balance": req.Amount}}
-	utils.DB.Collection("wallets").UpdateOne(c, bson.M{"user_id": userObjID}, update)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	utils.SuccessResponse(c, http.StatusCreated, "Withdrawal request created successfully", gin.H{
-		"withdrawal_id": withdrawal.ID,
-		"status": "pending",
+	withdrawal, err := h.withdrawalService.Cancel(ctx, withdrawalID, userObjID)
+	if !handleWithdrawalError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Withdrawal cancelled successfully", withdrawal)
+}
+
+// GetBankAccounts lists the user's payout bank accounts
+func (h *WalletHandler) GetBankAccounts(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accounts, err := h.withdrawalService.ListBankAccounts(ctx, userObjID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch bank accounts", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Bank accounts retrieved successfully", accounts)
+}
+
+// AddBankAccount verifies a bank account with the gateway and saves it
+func (h *WalletHandler) AddBankAccount(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	var req struct {
+		BankName      string `json:"bank_name" binding:"required"`
+		BankCode      string `json:"bank_code" binding:"required"`
+		AccountNumber string `json:"account_number" binding:"required,numeric,len=10"`
+		Currency      string `json:"currency"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	account, err := h.withdrawalService.AddBankAccount(ctx, userObjID, services.BankAccountRequest{
+		BankName:      req.BankName,
+		BankCode:      req.BankCode,
+		AccountNumber: req.AccountNumber,
+		Currency:      req.Currency,
 	})
-}
\ No newline at end of file
+	if err == services.ErrBankAccountExists {
+		utils.ConflictResponse(c, "Bank account already added")
+		return
+	}
+	if err != nil {
+		utils.BadRequestResponse(c, "Failed to verify bank account", err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Bank account added successfully", account)
+}
+
+// SetDefaultBankAccount makes a bank account the default for withdrawals
+func (h *WalletHandler) SetDefaultBankAccount(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	accountID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid bank account ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleWithdrawalError(c, h.withdrawalService.SetDefaultBankAccount(ctx, userObjID, accountID)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Default bank account updated", nil)
+}
+
+// DeleteBankAccount removes a bank account with no withdrawals in progress
+func (h *WalletHandler) DeleteBankAccount(c *gin.Context) {
+	userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	accountID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid bank account ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !handleWithdrawalError(c, h.withdrawalService.DeleteBankAccount(ctx, userObjID, accountID)) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Bank account removed", nil)
+}
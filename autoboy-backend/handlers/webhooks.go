@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhookService,
+	}
+}
+
+// GetWebhooks lists stored gateway events for admins
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" && status != "all" {
+		filter["status"] = status
+	}
+	if gateway := c.Query("gateway"); gateway != "" {
+		filter["gateway"] = gateway
+	}
+	if event := c.Query("event"); event != "" {
+		filter["event"] = event
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := config.Coll.PaymentWebhooks.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count webhooks", err.Error())
+		return
+	}
+
+	page, limit, totalPages, offset := utils.CalculatePagination(page, limit, total)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := config.Coll.PaymentWebhooks.Find(ctx, filter, opts)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch webhooks", err.Error())
+		return
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := []models.PaymentWebhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to decode webhooks", err.Error())
+		return
+	}
+
+	utils.SuccessResponseWithMeta(c, http.StatusOK, "Webhooks retrieved successfully", webhooks, &utils.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// RetryWebhook re-processes a failed gateway event
+func (h *WebhookHandler) RetryWebhook(c *gin.Context) {
+	webhookID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid webhook ID", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	webhook, err := h.webhookService.Retry(ctx, webhookID)
+	switch {
+	case err == services.ErrWebhookNotFound:
+		utils.NotFoundResponse(c, "Webhook not found")
+		return
+	case err == services.ErrWebhookNotFailed:
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	case webhook == nil && err != nil:
+		utils.InternalServerErrorResponse(c, "Failed to retry webhook", err.Error())
+		return
+	case err != nil:
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Webhook processing failed again", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Webhook processed successfully", webhook)
+}
@@ -7,6 +7,7 @@ import (
 
 	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/services"
 	"autoboy-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -14,10 +15,12 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type WishlistHandler struct{}
+type WishlistHandler struct {
+	eventTracker *services.EventTracker
+}
 
-func NewWishlistHandler() *WishlistHandler {
-	return &WishlistHandler{}
+func NewWishlistHandler(eventTracker *services.EventTracker) *WishlistHandler {
+	return &WishlistHandler{eventTracker: eventTracker}
 }
 
 func (h *WishlistHandler) GetWishlist(c *gin.Context) {
@@ -131,6 +134,18 @@ func (h *WishlistHandler) AddToWishlist(c *gin.Context) {
 		)
 	}
 
+	config.Coll.Products.UpdateOne(ctx, bson.M{"_id": productObjID}, bson.M{"$inc": bson.M{"wishlist_count": 1}})
+	NewActivityHandler().LogActivity(userObjID, models.ActivityWishlistAdd, "product", productObjID.Hex(), nil, c)
+	if product.SellerID != userObjID {
+		h.eventTracker.TrackEvent(models.ProductEvent{
+			ProductID: productObjID,
+			Type:      models.ProductEventSave,
+			UserID:    &userObjID,
+			SessionID: trackingSession(c),
+			IPAddress: c.ClientIP(),
+		})
+	}
+
 	utils.SuccessResponse(c, http.StatusOK, "Product added to wishlist", nil)
 }
 
@@ -144,7 +159,7 @@ func (h *WishlistHandler) RemoveFromWishlist(c *gin.Context) {
 	defer cancel()
 
 	result, err := config.Coll.Wishlists.UpdateOne(ctx,
-		bson.M{"user_id": userObjID},
+		bson.M{"user_id": userObjID, "product_ids": productObjID},
 		bson.M{
 			"$pull": bson.M{"product_ids": productObjID},
 			"$set":  bson.M{"updated_at": time.Now()},
@@ -157,9 +172,14 @@ func (h *WishlistHandler) RemoveFromWishlist(c *gin.Context) {
 	}
 
 	if result.MatchedCount == 0 {
-		utils.NotFoundResponse(c, "Wishlist not found")
+		utils.NotFoundResponse(c, "Product not in wishlist")
 		return
 	}
 
+	config.Coll.Products.UpdateOne(ctx,
+		bson.M{"_id": productObjID, "wishlist_count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"wishlist_count": -1}},
+	)
+
 	utils.SuccessResponse(c, http.StatusOK, "Product removed from wishlist", nil)
 }
\ No newline at end of file
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/services"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WithdrawalHandler struct {
+	withdrawalService *services.WithdrawalService
+}
+
+func NewWithdrawalHandler(withdrawalService *services.WithdrawalService) *WithdrawalHandler {
+	return &WithdrawalHandler{
+		withdrawalService: withdrawalService,
+	}
+}
+
+// GetWithdrawals lists withdrawals for admins, optionally filtered by status
+func (h *WithdrawalHandler) GetWithdrawals(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.Query("status")
+
+	filter := bson.M{}
+	if status != "" && status != "all" {
+		filter["status"] = status
+	}
+	if userID, err := primitive.ObjectIDFromHex(c.Query("user_id")); err == nil {
+		filter["user_id"] = userID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := config.Coll.Withdrawals.CountDocuments(ctx, filter)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to count withdrawals", err.Error())
+		return
+	}
+
+	page, limit, totalPages, offset := utils.CalculatePagination(page, limit, total)
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := config.Coll.Withdrawals.Find(ctx, filter, opts)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to fetch withdrawals", err.Error())
+		return
+	}
+	defer cursor.Close(ctx)
+
+	withdrawals := []models.Withdrawal{}
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to decode withdrawals", err.Error())
+		return
+	}
+
+	utils.SuccessResponseWithMeta(c, http.StatusOK, "Withdrawals retrieved successfully", withdrawals, &utils.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// ApproveWithdrawal queues a requested withdrawal for the next payout batch
+func (h *WithdrawalHandler) ApproveWithdrawal(c *gin.Context) {
+	withdrawalID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid withdrawal ID", nil)
+		return
+	}
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	withdrawal, err := h.withdrawalService.Approve(ctx, withdrawalID, adminID)
+	if !handleWithdrawalError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Withdrawal approved successfully", withdrawal)
+}
+
+// RejectWithdrawal refuses a withdrawal and returns the funds to the wallet
+func (h *WithdrawalHandler) RejectWithdrawal(c *gin.Context) {
+	withdrawalID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid withdrawal ID", nil)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data", err.Error())
+		return
+	}
+
+	adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	withdrawal, err := h.withdrawalService.Reject(ctx, withdrawalID, adminID, req.Reason)
+	if !handleWithdrawalError(c, err) {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Withdrawal rejected successfully", withdrawal)
+}
+
+// ProcessWithdrawals sends a payout batch now instead of waiting for the job
+func (h *WithdrawalHandler) ProcessWithdrawals(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	sent, failed, err := h.withdrawalService.ProcessBatch(ctx)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to process withdrawals", err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Withdrawal batch processed", gin.H{
+		"sent":   sent,
+		"failed": failed,
+	})
+}
+
+// handleWithdrawalError writes the error response and reports whether the request may continue
+func handleWithdrawalError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrWithdrawalNotFound):
+		utils.NotFoundResponse(c, "Withdrawal not found")
+	case errors.Is(err, services.ErrBankAccountNotFound):
+		utils.NotFoundResponse(c, "Bank account not found")
+	case errors.Is(err, services.ErrWithdrawalState), errors.Is(err, services.ErrBankAccountInUse):
+		utils.ConflictResponse(c, err.Error())
+	case errors.Is(err, services.ErrInsufficientBalance):
+		utils.BadRequestResponse(c, "Insufficient balance", nil)
+	case errors.Is(err, services.ErrWithdrawalLimit), errors.Is(err, services.ErrBankAccountUnverified),
+		errors.Is(err, services.ErrWalletCurrency):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Withdrawal operation failed", err.Error())
+	}
+	return false
+}
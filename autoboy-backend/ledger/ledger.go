@@ -0,0 +1,274 @@
+// Package ledger is the platform's double-entry ledger. Every movement of
+// money is posted as a balanced journal entry, so wallet balances, escrow
+// and revenue can always be rebuilt and audited from the entries alone.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrUnbalanced    = errors.New("ledger entry does not balance")
+	ErrInvalidLine   = errors.New("ledger line must have exactly one positive side")
+	ErrAlreadyPosted = errors.New("ledger entry already posted")
+)
+
+// Account identifies a ledger account. The prefix before the first colon is
+// the account class, which decides whether the account is debit- or
+// credit-normal.
+type Account string
+
+const (
+	// Money collected by gateways and not yet paid out
+	AccountGatewayClearing Account = "asset:gateway_clearing"
+	// Buyer funds held until an order is released or refunded
+	AccountEscrow Account = "liability:escrow"
+	// Funds received that no other account claims yet
+	AccountSuspense Account = "liability:suspense"
+	// Platform commission taken from escrow releases
+	AccountCommissionRevenue Account = "revenue:commission"
+	// Premium membership and seller plan charges
+	AccountSubscriptionRevenue Account = "revenue:subscriptions"
+	// Paid listing bumps
+	AccountPromotionRevenue Account = "revenue:promotions"
+	// Processing fees charged by payment gateways
+	AccountGatewayFees Account = "expense:gateway_fees"
+	// Discounts funded by the platform rather than the seller
+	AccountPromoSubsidies Account = "expense:promo_subsidies"
+	// Refunds that do not come out of escrow or a wallet
+	AccountRefunds Account = "expense:refunds"
+	// Balances that existed before the ledger was introduced
+	AccountOpeningBalances Account = "equity:opening_balances"
+)
+
+const (
+	walletPrefix     = "liability:wallet:"
+	heldWalletPrefix = "liability:wallet_held:"
+)
+
+// defaultCurrency is used for entries posted without a currency
+const defaultCurrency = "NGN"
+
+// BalanceKey identifies an account's balance in one currency. Amounts in
+// different currencies are never added together.
+type BalanceKey struct {
+	Account  Account
+	Currency string
+}
+
+// WalletAccount is the user's available wallet balance
+func WalletAccount(userID primitive.ObjectID) Account {
+	return Account(walletPrefix + userID.Hex())
+}
+
+// HeldAccount is the part of the user's wallet held for pending withdrawals
+func HeldAccount(userID primitive.ObjectID) Account {
+	return Account(heldWalletPrefix + userID.Hex())
+}
+
+// CreditNormal reports whether credits increase the account's balance
+func (a Account) CreditNormal() bool {
+	class := strings.SplitN(string(a), ":", 2)[0]
+	switch class {
+	case "liability", "revenue", "equity":
+		return true
+	}
+	return false
+}
+
+// Line is one side of a journal entry
+type Line struct {
+	Account Account
+	Debit   float64
+	Credit  float64
+}
+
+// Debit returns a line debiting the account
+func Debit(account Account, amount float64) Line {
+	return Line{Account: account, Debit: amount}
+}
+
+// Credit returns a line crediting the account
+func Credit(account Account, amount float64) Line {
+	return Line{Account: account, Credit: amount}
+}
+
+// Entry is a journal entry to be posted
+type Entry struct {
+	// IdempotencyKey makes posting safe to repeat: a second entry with the
+	// same key is rejected with ErrAlreadyPosted. Leave it empty for entries
+	// posted inside a transaction, where a duplicate key would abort it.
+	IdempotencyKey string
+	Description    string
+	Currency       string
+	ReferenceType  string
+	ReferenceID    *primitive.ObjectID
+	Lines          []Line
+	Metadata       map[string]interface{}
+}
+
+// Post validates and stores a journal entry. Pass a mongo.SessionContext to
+// post it in the same transaction as the balance change it records.
+func Post(ctx context.Context, entry Entry) (*models.LedgerEntry, error) {
+	lines, err := validate(entry.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := entry.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	doc := &models.LedgerEntry{
+		ID:             primitive.NewObjectID(),
+		EntryNumber:    utils.GenerateLedgerEntryNumber(),
+		IdempotencyKey: entry.IdempotencyKey,
+		Description:    entry.Description,
+		Currency:       currency,
+		ReferenceType:  entry.ReferenceType,
+		ReferenceID:    entry.ReferenceID,
+		Lines:          lines,
+		Metadata:       entry.Metadata,
+		CreatedAt:      time.Now(),
+	}
+
+	if _, err := config.Coll.LedgerEntries.InsertOne(ctx, doc); err != nil {
+		if entry.IdempotencyKey != "" && mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyPosted
+		}
+		return nil, fmt.Errorf("failed to post ledger entry: %w", err)
+	}
+	return doc, nil
+}
+
+// Balance returns an account's balance in currency, in its normal direction
+func Balance(ctx context.Context, account Account, currency string) (float64, error) {
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	balances, err := sumAccounts(ctx, bson.M{"lines.account": string(account)})
+	if err != nil {
+		return 0, err
+	}
+	return balances[BalanceKey{Account: account, Currency: currency}], nil
+}
+
+// Balances returns the balance of every account starting with prefix, in
+// each currency the account has been posted in
+func Balances(ctx context.Context, prefix string) (map[BalanceKey]float64, error) {
+	return sumAccounts(ctx, bson.M{"lines.account": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}})
+}
+
+// TrialBalance returns total debits and credits across the whole ledger.
+// They are equal unless entries were written around Post.
+func TrialBalance(ctx context.Context) (debits, credits float64, err error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"debits":  bson.M{"$sum": "$lines.debit"},
+			"credits": bson.M{"$sum": "$lines.credit"},
+		}}},
+	}
+
+	cursor, err := config.Coll.LedgerEntries.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Debits  float64 `bson:"debits"`
+		Credits float64 `bson:"credits"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, 0, err
+	}
+	if len(totals) == 0 {
+		return 0, 0, nil
+	}
+	return utils.RoundCurrency(totals[0].Debits), utils.RoundCurrency(totals[0].Credits), nil
+}
+
+func sumAccounts(ctx context.Context, match bson.M) (map[BalanceKey]float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"account": "$lines.account", "currency": "$currency"},
+			"debits":  bson.M{"$sum": "$lines.debit"},
+			"credits": bson.M{"$sum": "$lines.credit"},
+		}}},
+	}
+
+	cursor, err := config.Coll.LedgerEntries.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Key struct {
+			Account  string `bson:"account"`
+			Currency string `bson:"currency"`
+		} `bson:"_id"`
+		Debits  float64 `bson:"debits"`
+		Credits float64 `bson:"credits"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	balances := make(map[BalanceKey]float64, len(rows))
+	for _, row := range rows {
+		key := BalanceKey{Account: Account(row.Key.Account), Currency: row.Key.Currency}
+		if key.Currency == "" {
+			key.Currency = defaultCurrency
+		}
+		if key.Account.CreditNormal() {
+			balances[key] = utils.RoundCurrency(balances[key] + row.Credits - row.Debits)
+		} else {
+			balances[key] = utils.RoundCurrency(balances[key] + row.Debits - row.Credits)
+		}
+	}
+	return balances, nil
+}
+
+func validate(lines []Line) ([]models.LedgerLine, error) {
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%w: an entry needs at least two lines", ErrUnbalanced)
+	}
+
+	var debits, credits float64
+	out := make([]models.LedgerLine, 0, len(lines))
+	for _, line := range lines {
+		debit := utils.RoundCurrency(line.Debit)
+		credit := utils.RoundCurrency(line.Credit)
+		if line.Account == "" || debit < 0 || credit < 0 || (debit > 0) == (credit > 0) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidLine, line.Account)
+		}
+		debits += debit
+		credits += credit
+		out = append(out, models.LedgerLine{Account: string(line.Account), Debit: debit, Credit: credit})
+	}
+
+	if utils.RoundCurrency(debits) != utils.RoundCurrency(credits) {
+		return nil, fmt.Errorf("%w: debits %.2f, credits %.2f", ErrUnbalanced, debits, credits)
+	}
+	return out, nil
+}
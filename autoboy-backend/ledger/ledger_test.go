@@ -0,0 +1,105 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidate(t *testing.T) {
+	wallet := WalletAccount(primitive.NewObjectID())
+	tests := []struct {
+		name    string
+		lines   []Line
+		wantErr error
+	}{
+		{
+			name:  "balanced",
+			lines: []Line{Debit(AccountGatewayClearing, 1500), Credit(AccountEscrow, 1500)},
+		},
+		{
+			name: "balanced over several lines",
+			lines: []Line{
+				Debit(AccountEscrow, 1000),
+				Credit(wallet, 950),
+				Credit(AccountCommissionRevenue, 50),
+			},
+		},
+		{
+			name: "balanced once rounded to the cent",
+			lines: []Line{
+				Debit(AccountEscrow, 100.004),
+				Credit(wallet, 50.001),
+				Credit(AccountCommissionRevenue, 49.999),
+			},
+		},
+		{
+			name: "unbalanced once each line is rounded",
+			lines: []Line{
+				Debit(AccountEscrow, 100),
+				Credit(wallet, 33.333),
+				Credit(wallet, 33.333),
+				Credit(wallet, 33.334),
+			},
+			wantErr: ErrUnbalanced,
+		},
+		{
+			name:    "unbalanced",
+			lines:   []Line{Debit(AccountGatewayClearing, 1500), Credit(AccountEscrow, 1499.99)},
+			wantErr: ErrUnbalanced,
+		},
+		{
+			name:    "single line",
+			lines:   []Line{Debit(AccountGatewayClearing, 1500)},
+			wantErr: ErrUnbalanced,
+		},
+		{
+			name:    "both sides on one line",
+			lines:   []Line{{Account: AccountEscrow, Debit: 10, Credit: 10}, Credit(wallet, 0)},
+			wantErr: ErrInvalidLine,
+		},
+		{
+			name:    "zero line",
+			lines:   []Line{Debit(AccountEscrow, 0), Credit(wallet, 0)},
+			wantErr: ErrInvalidLine,
+		},
+		{
+			name:    "negative amount",
+			lines:   []Line{Debit(AccountEscrow, -10), Credit(wallet, -10)},
+			wantErr: ErrInvalidLine,
+		},
+		{
+			name:    "no account",
+			lines:   []Line{Debit("", 10), Credit(wallet, 10)},
+			wantErr: ErrInvalidLine,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := validate(tt.lines)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if len(out) != len(tt.lines) {
+				t.Fatalf("validate() returned %d lines, want %d", len(out), len(tt.lines))
+			}
+		})
+	}
+}
+
+func TestValidateRoundsLines(t *testing.T) {
+	out, err := validate([]Line{Debit(AccountEscrow, 10.006), Credit(AccountSuspense, 10.01)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0].Debit != 10.01 {
+		t.Fatalf("debit = %v, want 10.01", out[0].Debit)
+	}
+}
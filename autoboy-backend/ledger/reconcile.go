@@ -0,0 +1,182 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Drift is a wallet field that disagrees with its ledger account
+type Drift struct {
+	UserID       primitive.ObjectID `json:"user_id"`
+	Account      Account            `json:"account"`
+	Currency     string             `json:"currency"`
+	Field        string             `json:"field"`
+	WalletAmount float64            `json:"wallet_amount"`
+	LedgerAmount float64            `json:"ledger_amount"`
+	Difference   float64            `json:"difference"`
+	// Unposted is set when the account has no ledger history at all,
+	// which usually means the wallet predates the ledger
+	Unposted bool `json:"unposted"`
+}
+
+// Report is the result of reconciling wallets against the ledger
+type Report struct {
+	WalletsChecked int     `json:"wallets_checked"`
+	TotalDebits    float64 `json:"total_debits"`
+	TotalCredits   float64 `json:"total_credits"`
+	Drifts         []Drift `json:"drifts"`
+}
+
+// Balanced reports whether the ledger itself balances
+func (r *Report) Balanced() bool {
+	return r.TotalDebits == r.TotalCredits
+}
+
+// Clean reports whether there is no drift anywhere
+func (r *Report) Clean() bool {
+	return r.Balanced() && len(r.Drifts) == 0
+}
+
+// Reconcile compares every wallet's balance and held_balance with the
+// ledger accounts that back them, in the wallet's currency. Ledger balances
+// in any other currency are drift of their own. Differences within
+// tolerance are ignored.
+func Reconcile(ctx context.Context, tolerance float64) (*Report, error) {
+	report := &Report{}
+
+	var err error
+	report.TotalDebits, report.TotalCredits, err = TrialBalance(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("trial balance: %w", err)
+	}
+
+	available, err := Balances(ctx, walletPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("wallet balances: %w", err)
+	}
+	held, err := Balances(ctx, heldWalletPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("held balances: %w", err)
+	}
+
+	cursor, err := config.Coll.Wallets.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	seen := make(map[BalanceKey]bool)
+	for cursor.Next(ctx) {
+		var wallet models.Wallet
+		if err := cursor.Decode(&wallet); err != nil {
+			return nil, err
+		}
+		report.WalletsChecked++
+
+		currency := wallet.Currency
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		for _, check := range []struct {
+			key      BalanceKey
+			field    string
+			amount   float64
+			balances map[BalanceKey]float64
+		}{
+			{BalanceKey{WalletAccount(wallet.UserID), currency}, "balance", wallet.Balance, available},
+			{BalanceKey{HeldAccount(wallet.UserID), currency}, "held_balance", wallet.HeldBalance, held},
+		} {
+			seen[check.key] = true
+			ledgerAmount, posted := check.balances[check.key]
+			if drift := compare(wallet.UserID, check.key, check.field, check.amount, ledgerAmount, tolerance); drift != nil {
+				drift.Unposted = !posted
+				report.Drifts = append(report.Drifts, *drift)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	// Ledger accounts whose wallet document is missing, or that hold money
+	// in a currency other than the wallet's
+	for _, balances := range []map[BalanceKey]float64{available, held} {
+		for key, amount := range balances {
+			if seen[key] {
+				continue
+			}
+			field := "balance"
+			if strings.HasPrefix(string(key.Account), heldWalletPrefix) {
+				field = "held_balance"
+			}
+			if drift := compare(accountUserID(key.Account), key, field, 0, amount, tolerance); drift != nil {
+				report.Drifts = append(report.Drifts, *drift)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// PostOpeningBalances records the current balance of every wallet that has
+// no ledger history yet against equity:opening_balances. It is meant to be
+// run once when the ledger is introduced and skips accounts already posted.
+func PostOpeningBalances(ctx context.Context, report *Report) (int, error) {
+	posted := 0
+	for _, drift := range report.Drifts {
+		if !drift.Unposted || drift.WalletAmount <= 0 {
+			continue
+		}
+		userID := drift.UserID
+		_, err := Post(ctx, Entry{
+			IdempotencyKey: "opening:" + string(drift.Account),
+			Description:    fmt.Sprintf("Opening %s for wallet %s", drift.Field, userID.Hex()),
+			Currency:       drift.Currency,
+			ReferenceType:  "wallet",
+			ReferenceID:    &userID,
+			Lines: []Line{
+				Debit(AccountOpeningBalances, drift.WalletAmount),
+				Credit(drift.Account, drift.WalletAmount),
+			},
+		})
+		if err == ErrAlreadyPosted {
+			continue
+		}
+		if err != nil {
+			return posted, err
+		}
+		posted++
+	}
+	return posted, nil
+}
+
+func compare(userID primitive.ObjectID, key BalanceKey, field string, walletAmount, ledgerAmount, tolerance float64) *Drift {
+	diff := utils.RoundCurrency(walletAmount - ledgerAmount)
+	if math.Abs(diff) <= tolerance {
+		return nil
+	}
+	return &Drift{
+		UserID:       userID,
+		Account:      key.Account,
+		Currency:     key.Currency,
+		Field:        field,
+		WalletAmount: utils.RoundCurrency(walletAmount),
+		LedgerAmount: ledgerAmount,
+		Difference:   diff,
+	}
+}
+
+func accountUserID(account Account) primitive.ObjectID {
+	parts := strings.Split(string(account), ":")
+	id, _ := primitive.ObjectIDFromHex(parts[len(parts)-1])
+	return id
+}
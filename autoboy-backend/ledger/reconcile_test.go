@@ -0,0 +1,34 @@
+package ledger
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompare(t *testing.T) {
+	userID := primitive.NewObjectID()
+	key := BalanceKey{Account: WalletAccount(userID), Currency: "NGN"}
+
+	if drift := compare(userID, key, "balance", 1000.004, 1000, 0.01); drift != nil {
+		t.Fatalf("compare() = %+v within tolerance, want no drift", drift)
+	}
+
+	drift := compare(userID, key, "balance", 1000, 1250.5, 0.01)
+	if drift == nil {
+		t.Fatal("compare() found no drift")
+	}
+	if drift.Difference != -250.5 || drift.Currency != "NGN" || drift.UserID != userID {
+		t.Fatalf("compare() = %+v, want a -250.50 NGN drift for the user", drift)
+	}
+}
+
+func TestAccountUserID(t *testing.T) {
+	userID := primitive.NewObjectID()
+	if got := accountUserID(WalletAccount(userID)); got != userID {
+		t.Fatalf("accountUserID() = %s, want %s", got.Hex(), userID.Hex())
+	}
+	if got := accountUserID(AccountEscrow); !got.IsZero() {
+		t.Fatalf("accountUserID(escrow) = %s, want none", got.Hex())
+	}
+}
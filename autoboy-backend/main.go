@@ -62,6 +62,13 @@ func main() {
 	// Initialize services
 	services.InitializeServices()
 
+	// Create the built-in admin staff roles
+	rolesCtx, rolesCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := services.GetServices().AdminRoles.EnsureDefaultRoles(rolesCtx); err != nil {
+		log.Printf("Warning: failed to create default admin roles: %v", err)
+	}
+	rolesCancel()
+
 	// Initialize rate limiters
 	middleware.InitializeRateLimiters()
 
@@ -70,6 +77,10 @@ func main() {
 		log.Printf("Warning: Redis initialization failed: %v", err)
 	}
 
+	// Start background jobs
+	scheduler := services.StartBackgroundJobs()
+	defer scheduler.Stop()
+
 	// Set Gin mode
 	if os.Getenv("GIN_MODE") == "release" {
 		gin.SetMode(gin.ReleaseMode)
@@ -121,6 +132,14 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Write product views and events still buffered in memory
+	services.GetServices().Events.Stop()
+
+	// Persist search index changes made since the last flush
+	if err := services.GetServices().Search.Flush(); err != nil {
+		log.Printf("Failed to save search index: %v", err)
+	}
+
 	log.Println("✅ Server exited gracefully")
 }
 
@@ -152,7 +171,7 @@ func setupMiddleware(router *gin.Engine) {
 		AllowHeaders: []string{
 			"Origin", "Content-Type", "Accept", "Authorization",
 			"X-Requested-With", "X-API-Key", "X-Device-ID",
-			"X-CSRF-Token",
+			"X-CSRF-Token", "X-Session-ID",
 		},
 		ExposeHeaders: []string{
 			"Content-Length", "X-Total-Count", "X-Page-Count",
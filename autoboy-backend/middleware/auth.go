@@ -19,9 +19,10 @@ import (
 
 // JWTClaims represents the JWT token claims
 type JWTClaims struct {
-	UserID   string `json:"user_id"`
-	Email    string `json:"email"`
-	UserType string `json:"user_type"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	UserType  string `json:"user_type"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -51,7 +52,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Check if session is valid
-		if !isSessionValid(claims.UserID, token) {
+		if !isSessionValid(claims, token) {
 			utils.ErrorResponse(c, http.StatusUnauthorized, "Session expired or invalid", nil)
 			c.Abort()
 			return
@@ -62,9 +63,10 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("user", user)
 		c.Set("user_type", user.UserType)
 		c.Set("token", token)
+		c.Set("session_id", claims.SessionID)
 
 		// Update last activity
-		go updateUserActivity(user.ID, c.ClientIP(), c.Request.UserAgent())
+		go updateUserActivity(user.ID, claims.SessionID, c.ClientIP(), c.Request.UserAgent())
 
 		c.Next()
 	}
@@ -91,11 +93,12 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if isSessionValid(claims.UserID, token) {
+		if isSessionValid(claims, token) {
 			c.Set("user_id", user.ID.Hex())
 			c.Set("user", user)
 			c.Set("user_type", user.UserType)
 			c.Set("token", token)
+			c.Set("session_id", claims.SessionID)
 		}
 
 		c.Next()
@@ -209,30 +212,42 @@ func getUserFromClaims(claims *JWTClaims) (*models.User, error) {
 	return &user, nil
 }
 
-// isSessionValid checks if the session is still valid
-func isSessionValid(userID, token string) bool {
+// isSessionValid checks if the session the token belongs to is still
+// signed in. Tokens issued before sessions were named in the claims were
+// stored as the session token itself.
+func isSessionValid(claims *JWTClaims, token string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	// Convert userID string to ObjectID
-	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	userObjectID, err := primitive.ObjectIDFromHex(claims.UserID)
 	if err != nil {
 		return false
 	}
 
+	filter := bson.M{
+		"user_id":    userObjectID,
+		"is_active":  true,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	if claims.SessionID != "" {
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			return false
+		}
+		filter["_id"] = sessionID
+	} else {
+		filter["session_token"] = token
+	}
+
 	var session models.UserSession
-	err = config.Coll.UserSessions.FindOne(ctx, bson.M{
-		"user_id":       userObjectID,
-		"session_token": token,
-		"is_active":     true,
-		"expires_at":    bson.M{"$gt": time.Now()},
-	}).Decode(&session)
+	err = config.Coll.UserSessions.FindOne(ctx, filter).Decode(&session)
 
 	return err == nil
 }
 
 // updateUserActivity updates user's last activity
-func updateUserActivity(userID primitive.ObjectID, ipAddress, userAgent string) {
+func updateUserActivity(userID primitive.ObjectID, sessionID, ipAddress, userAgent string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -241,16 +256,25 @@ func updateUserActivity(userID primitive.ObjectID, ipAddress, userAgent string)
 		bson.M{"_id": userID},
 		bson.M{"$set": bson.M{"last_login": time.Now()}},
 	)
+
+	// Update the session's last use, shown in the user's device list
+	if id, err := primitive.ObjectIDFromHex(sessionID); err == nil {
+		config.Coll.UserSessions.UpdateOne(ctx,
+			bson.M{"_id": id},
+			bson.M{"$set": bson.M{"last_activity": time.Now(), "ip_address": ipAddress}},
+		)
+	}
 }
 
-// GenerateToken generates a new JWT token for user
-func GenerateToken(user *models.User) (string, error) {
+// GenerateToken generates a short-lived access token for a user's session
+func GenerateToken(user *models.User, sessionID primitive.ObjectID) (string, error) {
 	claims := JWTClaims{
-		UserID:   user.ID.Hex(),
-		Email:    user.Email,
-		UserType: string(user.UserType),
+		UserID:    user.ID.Hex(),
+		Email:     user.Email,
+		UserType:  string(user.UserType),
+		SessionID: sessionID.Hex(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "autoboy-api",
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"regexp"
+	"strings"
+
+	"autoboy-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appUserAgent matches the mobile app's user agent, e.g.
+// "AutoBoy/2.4.1 (Android 14; Pixel 8)"
+var appUserAgent = regexp.MustCompile(`(?i)\bautoboy/([0-9][\w.\-]*)`)
+
+var (
+	androidVersion = regexp.MustCompile(`Android ([0-9][0-9._]*)`)
+	iosVersion     = regexp.MustCompile(`(?:iPhone|CPU) OS ([0-9][0-9_]*)|iOS ([0-9][0-9._]*)`)
+	macVersion     = regexp.MustCompile(`Mac OS X ([0-9][0-9_]*)`)
+	windowsVersion = regexp.MustCompile(`Windows NT ([0-9.]+)`)
+)
+
+// browsers are checked in order, since most user agents name several
+var browsers = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([0-9]+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([0-9]+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([0-9]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([0-9]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([0-9]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([0-9]+).*Safari/`)},
+}
+
+var windowsReleases = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+}
+
+// DeviceFromRequest describes the device a request came from, using the
+// mobile app's X-Device-ID and X-App-Version headers when present
+func DeviceFromRequest(c *gin.Context) models.DeviceInfo {
+	return ParseDeviceInfo(c.Request.UserAgent(), c.GetHeader("X-Device-ID"), c.GetHeader("X-App-Version"))
+}
+
+// ParseDeviceInfo works out the OS, browser, device type and app version
+// from a user agent. An app version header wins over the one in the user
+// agent.
+func ParseDeviceInfo(userAgent, deviceID, appVersion string) models.DeviceInfo {
+	info := models.DeviceInfo{
+		UserAgent:  userAgent,
+		DeviceID:   strings.TrimSpace(deviceID),
+		AppVersion: strings.TrimSpace(appVersion),
+		OS:         parseOS(userAgent),
+		DeviceType: "desktop",
+	}
+
+	if m := appUserAgent.FindStringSubmatch(userAgent); m != nil {
+		if info.AppVersion == "" {
+			info.AppVersion = m[1]
+		}
+		info.Browser = "AutoBoy App"
+	} else {
+		for _, browser := range browsers {
+			if m := browser.pattern.FindStringSubmatch(userAgent); m != nil {
+				info.Browser = browser.name + " " + m[1]
+				break
+			}
+		}
+	}
+
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile") && info.Browser != "AutoBoy App"):
+		info.DeviceType = "tablet"
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "iphone") ||
+		strings.Contains(ua, "android") || info.AppVersion != "" || info.DeviceID != "":
+		info.DeviceType = "mobile"
+	}
+	return info
+}
+
+func parseOS(userAgent string) string {
+	switch {
+	case androidVersion.MatchString(userAgent):
+		return "Android " + androidVersion.FindStringSubmatch(userAgent)[1]
+	case iosVersion.MatchString(userAgent):
+		m := iosVersion.FindStringSubmatch(userAgent)
+		version := m[1]
+		if version == "" {
+			version = m[2]
+		}
+		return "iOS " + strings.ReplaceAll(version, "_", ".")
+	case windowsVersion.MatchString(userAgent):
+		version := windowsVersion.FindStringSubmatch(userAgent)[1]
+		if release, ok := windowsReleases[version]; ok {
+			version = release
+		}
+		return "Windows " + version
+	case macVersion.MatchString(userAgent):
+		return "macOS " + strings.ReplaceAll(macVersion.FindStringSubmatch(userAgent)[1], "_", ".")
+	case strings.Contains(userAgent, "CrOS"):
+		return "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		return "Linux"
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// adminRoleCache keeps each role's permissions in memory so permission
+// checks don't read Mongo on every admin request. Changes made through
+// this instance clear it at once; other instances pick them up when it
+// expires.
+type adminRoleCache struct {
+	mu       sync.RWMutex
+	roles    map[string][]string
+	loadedAt time.Time
+}
+
+var adminRoles = &adminRoleCache{}
+
+// InvalidateAdminRoles drops cached role permissions after a role changes
+func InvalidateAdminRoles() {
+	adminRoles.mu.Lock()
+	adminRoles.roles = nil
+	adminRoles.mu.Unlock()
+}
+
+func (rc *adminRoleCache) get(ctx context.Context) (map[string][]string, error) {
+	ttl := time.Duration(utils.GetEnvAsInt("ADMIN_ROLE_CACHE_SECONDS", 30)) * time.Second
+
+	rc.mu.RLock()
+	roles, loadedAt := rc.roles, rc.loadedAt
+	rc.mu.RUnlock()
+	if roles != nil && time.Since(loadedAt) < ttl {
+		return roles, nil
+	}
+
+	cursor, err := config.Coll.AdminRoles.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var docs []models.AdminRole
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	roles = make(map[string][]string, len(docs))
+	for _, role := range docs {
+		roles[role.Name] = role.Permissions
+	}
+
+	rc.mu.Lock()
+	rc.roles, rc.loadedAt = roles, time.Now()
+	rc.mu.Unlock()
+	return roles, nil
+}
+
+// AdminPermissions returns every permission an admin's roles grant. Users
+// who are not admins have none, whatever roles they carry.
+func AdminPermissions(ctx context.Context, user *models.User) (map[string]bool, error) {
+	granted := make(map[string]bool)
+	if user.UserType != models.UserTypeAdmin || len(user.AdminRoles) == 0 {
+		return granted, nil
+	}
+
+	roles, err := adminRoles.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range user.AdminRoles {
+		for _, permission := range roles[name] {
+			granted[permission] = true
+		}
+	}
+	return granted, nil
+}
+
+// HasPermission reports whether a granted permission set includes permission
+func HasPermission(granted map[string]bool, permission string) bool {
+	return granted[models.PermissionAll] || granted[permission]
+}
+
+// RequirePermission lets admin staff through only when one of their roles
+// grants permission
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			utils.ErrorResponse(c, http.StatusUnauthorized, "Authentication required", nil)
+			c.Abort()
+			return
+		}
+		c.Set("admin_permission", permission)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		granted, err := AdminPermissions(ctx, user.(*models.User))
+		if err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to check permissions", err.Error())
+			c.Abort()
+			return
+		}
+		if !HasPermission(granted, permission) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Insufficient permissions", "Requires the "+permission+" permission")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SetAdminLog names the action an admin request is logged under and adds
+// details to its log entry. Handlers call it before responding; requests
+// that don't are logged under their route.
+func SetAdminLog(c *gin.Context, action string, targetUserID *primitive.ObjectID, details map[string]interface{}) {
+	c.Set("admin_log_action", action)
+	if targetUserID != nil {
+		c.Set("admin_log_target", *targetUserID)
+	}
+	if details != nil {
+		c.Set("admin_log_details", details)
+	}
+}
+
+// AdminAudit writes every state-changing admin request to the admin log
+// once it has been handled, including ones refused for lack of permission
+func AdminAudit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+
+		adminID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+		entry := models.AdminLog{
+			ID:         primitive.NewObjectID(),
+			AdminID:    adminID,
+			Action:     c.GetString("admin_log_action"),
+			Permission: c.GetString("admin_permission"),
+			Method:     c.Request.Method,
+			Path:       c.FullPath(),
+			ResourceID: c.Param("id"),
+			StatusCode: c.Writer.Status(),
+			IPAddress:  c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			CreatedAt:  time.Now(),
+		}
+		if entry.Action == "" {
+			entry.Action = c.Request.Method + " " + c.FullPath()
+		}
+		if target, ok := c.Get("admin_log_target"); ok {
+			targetID := target.(primitive.ObjectID)
+			entry.TargetUserID = &targetID
+		}
+		if details, ok := c.Get("admin_log_details"); ok {
+			entry.Details = details.(map[string]interface{})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := config.Coll.AdminLogs.InsertOne(ctx, entry); err != nil {
+			log.Printf("Failed to write admin log for %s %s: %v", entry.Method, entry.Path, err)
+		}
+	}
+}
@@ -2,60 +2,309 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
 	"time"
 
 	"autoboy-backend/config"
 	"autoboy-backend/models"
+	"autoboy-backend/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// CreateUserSession creates a new user session
-func CreateUserSession(userID primitive.ObjectID, token, ipAddress, userAgent string) error {
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; the session has been signed out")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// previousTokensKept is how many rotated-out refresh tokens a session
+// remembers for reuse detection
+const previousTokensKept = 20
+
+// TokenPair is what a client gets on sign-in and on every refresh
+type TokenPair struct {
+	AccessToken      string             `json:"token"`
+	RefreshToken     string             `json:"refresh_token"`
+	ExpiresIn        int                `json:"expires_in"`
+	RefreshExpiresIn int                `json:"refresh_expires_in"`
+	SessionID        primitive.ObjectID `json:"session_id"`
+}
+
+// AccessTokenTTL is how long an access token is accepted
+func AccessTokenTTL() time.Duration {
+	return time.Duration(utils.GetEnvAsInt("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute
+}
+
+// RefreshTokenTTL is how long a session lasts without being refreshed.
+// Every refresh extends it, so a device in regular use stays signed in.
+func RefreshTokenTTL() time.Duration {
+	return time.Duration(utils.GetEnvAsInt("REFRESH_TOKEN_TTL_DAYS", 30)) * 24 * time.Hour
+}
+
+// refreshReuseGrace is how long the refresh token a session just rotated
+// out is still accepted, so a client that lost the response to a refresh
+// can retry instead of being signed out
+func refreshReuseGrace() time.Duration {
+	return time.Duration(utils.GetEnvAsInt("REFRESH_TOKEN_REUSE_GRACE_SECONDS", 30)) * time.Second
+}
+
+// CreateUserSession signs a user in on a device and returns their first
+// token pair. An earlier session from the same app install is replaced.
+func CreateUserSession(user *models.User, device models.DeviceInfo, ipAddress string) (*TokenPair, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	session := models.UserSession{
 		ID:           primitive.NewObjectID(),
-		UserID:       userID,
-		SessionToken: token,
-		DeviceInfo: models.DeviceInfo{
-			UserAgent:  userAgent,
-			DeviceType: "web",
-		},
+		UserID:       user.ID,
+		SessionToken: hashRefreshToken(refreshToken),
+		DeviceInfo:   device,
 		IPAddress:    ipAddress,
 		IsActive:     true,
-		ExpiresAt:    time.Now().Add(24 * time.Hour),
-		CreatedAt:    time.Now(),
-		LastActivity: time.Now(),
+		ExpiresAt:    now.Add(RefreshTokenTTL()),
+		CreatedAt:    now,
+		LastActivity: now,
 	}
 
-	_, err := config.Coll.UserSessions.InsertOne(ctx, session)
-	return err
+	if device.DeviceID != "" {
+		_, err := config.Coll.UserSessions.UpdateMany(ctx,
+			bson.M{"user_id": user.ID, "device_info.device_id": device.DeviceID, "is_active": true},
+			revokeUpdate(models.SessionRevokedReplaced, now),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := config.Coll.UserSessions.InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+	return issueTokens(user, session.ID, refreshToken)
 }
 
-// InvalidateUserSession invalidates a user session
-func InvalidateUserSession(userID, token string) error {
+// RefreshUserSession swaps a refresh token for a new token pair. Each
+// refresh token works once: presenting one that was already swapped means
+// it was copied, so the whole session is revoked.
+func RefreshUserSession(refreshToken string, device models.DeviceInfo, ipAddress string) (*TokenPair, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Convert userID string to ObjectID
-	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	hash := hashRefreshToken(refreshToken)
+	var session models.UserSession
+	err := config.Coll.UserSessions.FindOne(ctx, bson.M{"session_token": hash}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		err = config.Coll.UserSessions.FindOne(ctx, bson.M{"previous_tokens": hash}).Decode(&session)
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !session.IsActive || !session.ExpiresAt.After(time.Now()) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		if !withinReuseGrace(&session, hash) {
+			if _, err := config.Coll.UserSessions.UpdateOne(ctx,
+				bson.M{"_id": session.ID, "is_active": true},
+				revokeUpdate(models.SessionRevokedTokenReuse, time.Now()),
+			); err != nil {
+				return nil, err
+			}
+			log.Printf("Refresh token reuse on session %s of user %s from %s; session revoked",
+				session.ID.Hex(), session.UserID.Hex(), ipAddress)
+			return nil, ErrRefreshTokenReused
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if !session.IsActive || !session.ExpiresAt.After(time.Now()) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	var user models.User
+	err = config.Coll.Users.FindOne(ctx, bson.M{"_id": session.UserID, "status": models.UserStatusActive}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRefreshTokenInvalid
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = config.Coll.UserSessions.UpdateOne(ctx,
-		bson.M{
-			"user_id":       userObjectID,
-			"session_token": token,
-		},
+	next, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	set := bson.M{
+		"session_token": hashRefreshToken(next),
+		"ip_address":    ipAddress,
+		"expires_at":    now.Add(RefreshTokenTTL()),
+		"last_activity": now,
+		"rotated_at":    now,
+	}
+	if device.AppVersion != "" {
+		set["device_info.app_version"] = device.AppVersion
+	}
+	// Swapping against the token read above means two refreshes racing with
+	// the same token cannot both win
+	result, err := config.Coll.UserSessions.UpdateOne(ctx,
+		bson.M{"_id": session.ID, "session_token": session.SessionToken, "is_active": true},
 		bson.M{
-			"$set": bson.M{
-				"is_active": false,
-			},
+			"$set":  set,
+			"$push": bson.M{"previous_tokens": bson.M{"$each": []string{session.SessionToken}, "$slice": -previousTokensKept}},
 		},
 	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrRefreshTokenInvalid
+	}
+	return issueTokens(&user, session.ID, next)
+}
+
+// withinReuseGrace reports whether hash is the token the session rotated
+// out last, presented again soon enough to be a retry
+func withinReuseGrace(session *models.UserSession, hash string) bool {
+	n := len(session.PreviousTokens)
+	return n > 0 && session.PreviousTokens[n-1] == hash &&
+		session.RotatedAt != nil && time.Since(*session.RotatedAt) < refreshReuseGrace()
+}
+
+// GetUserSessions returns the user's signed-in devices, most recently used
+// first
+func GetUserSessions(userID primitive.ObjectID) ([]models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := config.Coll.UserSessions.Find(ctx,
+		bson.M{"user_id": userID, "is_active": true, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_activity", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.UserSession{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession signs one of the user's sessions out
+func RevokeUserSession(userID, sessionID primitive.ObjectID, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := config.Coll.UserSessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "user_id": userID, "is_active": true},
+		revokeUpdate(reason, time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions signs all of the user's sessions out apart from
+// except, which may be zero. It returns how many were revoked.
+func RevokeUserSessions(userID, except primitive.ObjectID, reason string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "is_active": true}
+	if !except.IsZero() {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+	result, err := config.Coll.UserSessions.UpdateMany(ctx, filter, revokeUpdate(reason, time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// InvalidateUserSession signs out the session a request was made with.
+// Access tokens issued before sessions were named in the claims are
+// matched by the token itself.
+func InvalidateUserSession(userID, sessionID, token string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	if sessionID == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err = config.Coll.UserSessions.UpdateOne(ctx,
+			bson.M{"user_id": userObjectID, "session_token": token},
+			revokeUpdate(models.SessionRevokedLogout, time.Now()),
+		)
+		return err
+	}
+
+	sessionObjectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return err
+	}
+	err = RevokeUserSession(userObjectID, sessionObjectID, models.SessionRevokedLogout)
+	if err == ErrSessionNotFound {
+		return nil
+	}
 	return err
-}
\ No newline at end of file
+}
+
+func revokeUpdate(reason string, at time.Time) bson.M {
+	return bson.M{"$set": bson.M{
+		"is_active":      false,
+		"revoked_at":     at,
+		"revoked_reason": reason,
+	}}
+}
+
+func issueTokens(user *models.User, sessionID primitive.ObjectID, refreshToken string) (*TokenPair, error) {
+	accessToken, err := GenerateToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int(AccessTokenTTL().Seconds()),
+		RefreshExpiresIn: int(RefreshTokenTTL().Seconds()),
+		SessionID:        sessionID,
+	}, nil
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken is how refresh tokens are stored, so a leaked sessions
+// collection cannot be used to sign in
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
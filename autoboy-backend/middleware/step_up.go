@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RequireStepUp guards sensitive actions for users with two-factor
+// authentication on: their session must have passed a second-factor check
+// recently. Clients seeing step_up_required verify at /user/2fa/step-up and
+// retry.
+func RequireStepUp() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			utils.ErrorResponse(c, http.StatusUnauthorized, "Authentication required", nil)
+			c.Abort()
+			return
+		}
+		if !user.(*models.User).TwoFactorEnabled {
+			c.Next()
+			return
+		}
+
+		if !hasRecentStepUp(c.GetString("session_id")) {
+			utils.ErrorResponse(c, http.StatusForbidden, "Two-factor verification required", "step_up_required")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func hasRecentStepUp(sessionID string) bool {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	window := time.Duration(utils.GetEnvAsInt("TWO_FACTOR_STEP_UP_MINUTES", 10)) * time.Minute
+	count, err := config.Coll.UserSessions.CountDocuments(ctx, bson.M{
+		"_id":        id,
+		"is_active":  true,
+		"step_up_at": bson.M{"$gt": time.Now().Add(-window)},
+	})
+	return err == nil && count > 0
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Admin permissions, checked per route. A role holding PermissionAll has
+// every permission, including ones added later.
+const (
+	PermissionAll = "*"
+
+	PermissionUsersRead        = "users.read"
+	PermissionUsersManage      = "users.manage"
+	PermissionUsersUnlock      = "users.unlock"
+	PermissionSecurityRead     = "security.read"
+	PermissionProductsRead     = "products.read"
+	PermissionProductsModerate = "products.moderate"
+	PermissionCategoriesManage = "categories.manage"
+	PermissionOrdersRead       = "orders.read"
+	PermissionOrdersManage     = "orders.manage"
+	PermissionOrdersRefund     = "orders.refund"
+	PermissionReturnsManage    = "returns.manage"
+	PermissionDisputesRead     = "disputes.read"
+	PermissionDisputesResolve  = "disputes.resolve"
+	PermissionEscrowsRead      = "escrows.read"
+	PermissionEscrowsManage    = "escrows.manage"
+	PermissionWebhooksManage   = "webhooks.manage"
+	PermissionPayoutsRead      = "payouts.read"
+	PermissionPayoutsManage    = "payouts.manage"
+	PermissionAnalyticsRead    = "analytics.read"
+	PermissionSystemManage     = "system.manage"
+	PermissionRolesManage      = "roles.manage"
+	PermissionAuditRead        = "audit.read"
+)
+
+// AdminPermission describes a permission for the admin console
+type AdminPermission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AdminPermissions lists every permission that can be granted
+var AdminPermissions = []AdminPermission{
+	{PermissionUsersRead, "View user accounts"},
+	{PermissionUsersManage, "Suspend, activate and deactivate users"},
+	{PermissionUsersUnlock, "Lift sign-in lockouts"},
+	{PermissionSecurityRead, "Review failed sign-in attempts"},
+	{PermissionProductsRead, "View all listings"},
+	{PermissionProductsModerate, "Approve and reject listings"},
+	{PermissionCategoriesManage, "Edit category attributes, ranking and listing lifecycle"},
+	{PermissionOrdersRead, "View orders, refunds and returns"},
+	{PermissionOrdersManage, "Change order status"},
+	{PermissionOrdersRefund, "Issue refunds"},
+	{PermissionReturnsManage, "Approve, reject and receive returns, which refunds the buyer"},
+	{PermissionDisputesRead, "View disputes"},
+	{PermissionDisputesResolve, "Resolve disputes"},
+	{PermissionEscrowsRead, "View escrows"},
+	{PermissionEscrowsManage, "Release and refund escrows"},
+	{PermissionWebhooksManage, "View and retry payment webhooks"},
+	{PermissionPayoutsRead, "View withdrawal requests"},
+	{PermissionPayoutsManage, "Approve, reject and pay out withdrawals"},
+	{PermissionAnalyticsRead, "View platform analytics and search reports"},
+	{PermissionSystemManage, "Initialise the database, rebuild search and run diagnostics"},
+	{PermissionRolesManage, "Manage staff roles and assign them"},
+	{PermissionAuditRead, "Read the admin action log"},
+}
+
+// IsAdminPermission reports whether name is a grantable permission
+func IsAdminPermission(name string) bool {
+	if name == PermissionAll {
+		return true
+	}
+	for _, p := range AdminPermissions {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Built-in staff roles, created at startup
+const (
+	AdminRoleSuperAdmin = "super_admin"
+	AdminRoleModerator  = "moderator"
+	AdminRoleSupport    = "support"
+	AdminRoleFinance    = "finance"
+)
+
+// AdminRole is a named set of admin permissions assigned to staff
+type AdminRole struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	DisplayName string             `bson:"display_name" json:"display_name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	System      bool               `bson:"system" json:"system"` // built in; cannot be deleted
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// DefaultAdminRoles are the built-in roles. Their permissions are only
+// written when a role is first created, so edits made later are kept.
+var DefaultAdminRoles = []AdminRole{
+	{
+		Name: AdminRoleSuperAdmin, DisplayName: "Super admin",
+		Description: "Full access, including staff roles and system tools",
+		Permissions: []string{PermissionAll},
+	},
+	{
+		Name: AdminRoleModerator, DisplayName: "Moderator",
+		Description: "Reviews listings and deals with abusive users",
+		Permissions: []string{
+			PermissionUsersRead, PermissionUsersManage, PermissionProductsRead, PermissionProductsModerate,
+			PermissionCategoriesManage, PermissionDisputesRead,
+		},
+	},
+	{
+		Name: AdminRoleSupport, DisplayName: "Support agent",
+		Description: "Looks into accounts, orders and disputes for customers; cannot move money",
+		Permissions: []string{
+			PermissionUsersRead, PermissionUsersUnlock, PermissionSecurityRead, PermissionProductsRead,
+			PermissionOrdersRead, PermissionDisputesRead,
+		},
+	},
+	{
+		Name: AdminRoleFinance, DisplayName: "Finance",
+		Description: "Handles refunds, returns, escrows, disputes and seller payouts",
+		Permissions: []string{
+			PermissionUsersRead, PermissionOrdersRead, PermissionOrdersRefund, PermissionReturnsManage,
+			PermissionDisputesRead, PermissionDisputesResolve, PermissionEscrowsRead, PermissionEscrowsManage,
+			PermissionWebhooksManage, PermissionPayoutsRead, PermissionPayoutsManage, PermissionAnalyticsRead,
+		},
+	},
+}
+
+// AdminLog records an action taken by admin staff
+type AdminLog struct {
+	ID           primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	AdminID      primitive.ObjectID     `bson:"admin_id" json:"admin_id"`
+	Action       string                 `bson:"action" json:"action"`
+	Permission   string                 `bson:"permission,omitempty" json:"permission,omitempty"`
+	Method       string                 `bson:"method,omitempty" json:"method,omitempty"`
+	Path         string                 `bson:"path,omitempty" json:"path,omitempty"`
+	ResourceID   string                 `bson:"resource_id,omitempty" json:"resource_id,omitempty"`
+	TargetUserID *primitive.ObjectID    `bson:"target_user_id,omitempty" json:"target_user_id,omitempty"`
+	Details      map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	StatusCode   int                    `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	IPAddress    string                 `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	UserAgent    string                 `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	CreatedAt    time.Time              `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LedgerEntry is one balanced journal entry in the double-entry ledger.
+// The sum of debits across its lines always equals the sum of credits.
+type LedgerEntry struct {
+	ID             primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	EntryNumber    string                 `bson:"entry_number" json:"entry_number"`
+	IdempotencyKey string                 `bson:"idempotency_key,omitempty" json:"idempotency_key,omitempty"`
+	Description    string                 `bson:"description" json:"description"`
+	Currency       string                 `bson:"currency" json:"currency"`
+	ReferenceType  string                 `bson:"reference_type,omitempty" json:"reference_type,omitempty"`
+	ReferenceID    *primitive.ObjectID    `bson:"reference_id,omitempty" json:"reference_id,omitempty"`
+	Lines          []LedgerLine           `bson:"lines" json:"lines"`
+	Metadata       map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	CreatedAt      time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// LedgerLine debits or credits a single account. Exactly one of Debit and
+// Credit is non-zero.
+type LedgerLine struct {
+	Account string  `bson:"account" json:"account"`
+	Debit   float64 `bson:"debit" json:"debit"`
+	Credit  float64 `bson:"credit" json:"credit"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginThrottle counts failed sign-ins on one account from one IP address,
+// so a single source guessing passwords is stopped before the whole account
+// has to be locked for its owner
+type LoginThrottle struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	IPAddress     string             `bson:"ip_address" json:"ip_address"`
+	Failures      int                `bson:"failures" json:"failures"`
+	LockedUntil   *time.Time         `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	LastFailureAt time.Time          `bson:"last_failure_at" json:"last_failure_at"`
+	ExpiresAt     time.Time          `bson:"expires_at" json:"expires_at"` // removed by a TTL index once idle
+}
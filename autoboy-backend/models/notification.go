@@ -22,6 +22,8 @@ const (
 	NotificationTypePriceDrop   NotificationType = "price_drop"
 	NotificationTypeAchievement NotificationType = "achievement"
 	NotificationTypeExclusive   NotificationType = "exclusive"
+	NotificationTypeSavedSearch NotificationType = "saved_search"
+	NotificationTypeListing     NotificationType = "listing"
 )
 
 // Notification represents a user notification
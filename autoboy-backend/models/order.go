@@ -38,6 +38,7 @@ type Order struct {
 	OrderNumber     string             `bson:"order_number" json:"order_number"`
 	BuyerID         primitive.ObjectID `bson:"buyer_id" json:"buyer_id"`
 	SellerID        primitive.ObjectID `bson:"seller_id" json:"seller_id"`
+	CheckoutID      *primitive.ObjectID `bson:"checkout_id,omitempty" json:"checkout_id,omitempty"`
 
 	// Order items
 	Items           []OrderItem        `bson:"items" json:"items"`
@@ -47,8 +48,11 @@ type Order struct {
 	ShippingAmount  float64            `bson:"shipping_amount" json:"shipping_amount"`
 	TaxAmount       float64            `bson:"tax_amount" json:"tax_amount"`
 	DiscountAmount  float64            `bson:"discount_amount" json:"discount_amount"`
+	PromoCode       string             `bson:"promo_code,omitempty" json:"promo_code,omitempty"`
 	TotalAmount     float64            `bson:"total_amount" json:"total_amount"`
 	Currency        string             `bson:"currency" json:"currency"`
+	RefundedAmount  float64            `bson:"refunded_amount" json:"refunded_amount"`
+	ShippingRefunded bool              `bson:"shipping_refunded" json:"shipping_refunded"`
 
 	// Status tracking
 	Status          OrderStatus        `bson:"status" json:"status"`
@@ -61,6 +65,9 @@ type Order struct {
 	// Payment information
 	PaymentMethod   string             `bson:"payment_method" json:"payment_method"`
 	PaymentID       *primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	PaymentReference string            `bson:"payment_reference,omitempty" json:"payment_reference,omitempty"`
+	EscrowID        *primitive.ObjectID `bson:"escrow_id,omitempty" json:"escrow_id,omitempty"`
+	EscrowPending   bool               `bson:"escrow_pending,omitempty" json:"-"` // paid, escrow not opened yet
 
 	// Shipping information
 	ShippingMethod  string             `bson:"shipping_method" json:"shipping_method"`
@@ -97,6 +104,49 @@ type Order struct {
 	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// Checkout groups the per-seller orders created from one cart so that a
+// single payment covers all of them
+type Checkout struct {
+	ID              primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	CheckoutNumber  string               `bson:"checkout_number" json:"checkout_number"`
+	BuyerID         primitive.ObjectID   `bson:"buyer_id" json:"buyer_id"`
+	OrderIDs        []primitive.ObjectID `bson:"order_ids" json:"order_ids"`
+
+	// How the payment is split between the sellers' orders
+	Allocations     []CheckoutAllocation `bson:"allocations" json:"allocations"`
+
+	// Pricing
+	SubtotalAmount  float64            `bson:"subtotal_amount" json:"subtotal_amount"`
+	ShippingAmount  float64            `bson:"shipping_amount" json:"shipping_amount"`
+	DiscountAmount  float64            `bson:"discount_amount" json:"discount_amount"`
+	PromoCode       string             `bson:"promo_code,omitempty" json:"promo_code,omitempty"`
+	TotalAmount     float64            `bson:"total_amount" json:"total_amount"`
+	Currency        string             `bson:"currency" json:"currency"`
+
+	// Payment information
+	PaymentMethod   string             `bson:"payment_method" json:"payment_method"`
+	PaymentStatus   PaymentStatus      `bson:"payment_status" json:"payment_status"`
+	PaymentID       *primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	PaymentReference string            `bson:"payment_reference,omitempty" json:"payment_reference,omitempty"`
+	PaidAt          *time.Time         `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+
+	// Orders is filled in for responses only
+	Orders          []Order            `bson:"-" json:"orders,omitempty"`
+
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// CheckoutAllocation is one seller's share of a checkout payment
+type CheckoutAllocation struct {
+	OrderID         primitive.ObjectID `bson:"order_id" json:"order_id"`
+	SellerID        primitive.ObjectID `bson:"seller_id" json:"seller_id"`
+	SubtotalAmount  float64            `bson:"subtotal_amount" json:"subtotal_amount"`
+	ShippingAmount  float64            `bson:"shipping_amount" json:"shipping_amount"`
+	DiscountAmount  float64            `bson:"discount_amount,omitempty" json:"discount_amount,omitempty"`
+	Amount          float64            `bson:"amount" json:"amount"`
+}
+
 // OrderItem represents individual items in an order
 type OrderItem struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -114,6 +164,7 @@ type OrderItem struct {
 
 	// Item status (for partial fulfillment)
 	Status          OrderStatus        `bson:"status" json:"status"`
+	RefundedQuantity int               `bson:"refunded_quantity" json:"refunded_quantity"`
 	ProcessedAt     *time.Time         `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
 	ShippedAt       *time.Time         `bson:"shipped_at,omitempty" json:"shipped_at,omitempty"`
 	DeliveredAt     *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
@@ -262,6 +313,8 @@ type OrderTracking struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	OrderID     primitive.ObjectID `bson:"order_id" json:"order_id"`
 	Status      OrderStatus        `bson:"status" json:"status"`
+	FromStatus  OrderStatus        `bson:"from_status,omitempty" json:"from_status,omitempty"`
+	ActorRole   string             `bson:"actor_role,omitempty" json:"actor_role,omitempty"` // buyer, seller, admin, system
 	Location    string             `bson:"location,omitempty" json:"location,omitempty"`
 	Description string             `bson:"description" json:"description"`
 	Timestamp   time.Time          `bson:"timestamp" json:"timestamp"`
@@ -274,6 +327,8 @@ type OrderReturn struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	OrderID        primitive.ObjectID `bson:"order_id" json:"order_id"`
 	OrderItemID    primitive.ObjectID `bson:"order_item_id" json:"order_item_id"`
+	Quantity       int                `bson:"quantity" json:"quantity"`
+	SellerID       primitive.ObjectID `bson:"seller_id" json:"seller_id"`
 	RequestedBy    primitive.ObjectID `bson:"requested_by" json:"requested_by"`
 	Reason         string             `bson:"reason" json:"reason"`
 	Description    string             `bson:"description" json:"description"`
@@ -292,8 +347,12 @@ type OrderReturn struct {
 	// Shipping information
 	ReturnShipping ShippingInfo       `bson:"return_shipping,omitempty" json:"return_shipping,omitempty"`
 
+	ReceivedAt     *time.Time         `bson:"received_at,omitempty" json:"received_at,omitempty"`
+
 	// Refund information
 	RefundAmount   float64            `bson:"refund_amount,omitempty" json:"refund_amount,omitempty"`
+	RefundTo       RefundDestination  `bson:"refund_to" json:"refund_to"`
+	RefundID       *primitive.ObjectID `bson:"refund_id,omitempty" json:"refund_id,omitempty"`
 	RefundedAt     *time.Time         `bson:"refunded_at,omitempty" json:"refunded_at,omitempty"`
 
 	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
@@ -311,5 +370,6 @@ const (
 	ReturnStatusReceived   ReturnStatus = "received"
 	ReturnStatusProcessed  ReturnStatus = "processed"
 	ReturnStatusCompleted  ReturnStatus = "completed"
+	ReturnStatusRefunded   ReturnStatus = "refunded"
 	ReturnStatusCancelled  ReturnStatus = "cancelled"
 )
\ No newline at end of file
@@ -15,6 +15,7 @@ const (
 	PaymentGatewayBank        PaymentGateway = "bank_transfer"
 	PaymentGatewayCrypto      PaymentGateway = "cryptocurrency"
 	PaymentGatewayWallet      PaymentGateway = "wallet"
+	PaymentGatewayFake        PaymentGateway = "fake" // in-process gateway for tests and local development
 )
 
 // PaymentMethod represents different payment methods
@@ -51,6 +52,7 @@ type Payment struct {
 	PaymentNumber       string             `bson:"payment_number" json:"payment_number"`
 	UserID              primitive.ObjectID `bson:"user_id" json:"user_id"`
 	OrderID             *primitive.ObjectID `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	CheckoutID          *primitive.ObjectID `bson:"checkout_id,omitempty" json:"checkout_id,omitempty"`
 	SwapDealID          *primitive.ObjectID `bson:"swap_deal_id,omitempty" json:"swap_deal_id,omitempty"`
 
 	// Payment details
@@ -159,6 +161,8 @@ type Escrow struct {
 	Currency        string             `bson:"currency" json:"currency"`
 	EscrowFee       float64            `bson:"escrow_fee" json:"escrow_fee"`
 	NetAmount       float64            `bson:"net_amount" json:"net_amount"`
+	// Part of Amount the platform paid in for a promo discount
+	Subsidy         float64            `bson:"subsidy,omitempty" json:"subsidy,omitempty"`
 
 	// Status and conditions
 	Status          EscrowStatus       `bson:"status" json:"status"`
@@ -169,6 +173,8 @@ type Escrow struct {
 	ReleasedBy      *primitive.ObjectID `bson:"released_by,omitempty" json:"released_by,omitempty"`
 	ReleaseReason   string             `bson:"release_reason,omitempty" json:"release_reason,omitempty"`
 	PartialReleases []PartialRelease   `bson:"partial_releases,omitempty" json:"partial_releases,omitempty"`
+	ReleasedAmount  float64            `bson:"released_amount" json:"released_amount"`
+	RefundedAmount  float64            `bson:"refunded_amount" json:"refunded_amount"`
 
 	// Dispute handling
 	DisputeID       *primitive.ObjectID `bson:"dispute_id,omitempty" json:"dispute_id,omitempty"`
@@ -281,6 +287,7 @@ type PaymentWebhook struct {
 	ProcessedAt     *time.Time         `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
 	FailureReason   string             `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
 	RetryCount      int                `bson:"retry_count" json:"retry_count"`
+	AttemptedAt     *time.Time         `bson:"attempted_at,omitempty" json:"attempted_at,omitempty"` // last time processing started
 	Headers         map[string]string  `bson:"headers,omitempty" json:"headers,omitempty"`
 	IPAddress       string             `bson:"ip_address" json:"ip_address"`
 	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
@@ -311,10 +318,20 @@ type Refund struct {
 	Reason          string             `bson:"reason" json:"reason"`
 	Description     string             `bson:"description,omitempty" json:"description,omitempty"`
 
+	// What is being refunded
+	RefundType      RefundType         `bson:"refund_type" json:"refund_type"`
+	Items           []RefundItem       `bson:"items,omitempty" json:"items,omitempty"`
+	ShippingAmount  float64            `bson:"shipping_amount" json:"shipping_amount"`
+	ReturnID        *primitive.ObjectID `bson:"return_id,omitempty" json:"return_id,omitempty"`
+	DisputeID       *primitive.ObjectID `bson:"dispute_id,omitempty" json:"dispute_id,omitempty"`
+
 	// Processing information
 	RefundMethod    PaymentMethod      `bson:"refund_method" json:"refund_method"`
+	Destination     RefundDestination  `bson:"destination" json:"destination"`
+	RefundPaymentID *primitive.ObjectID `bson:"refund_payment_id,omitempty" json:"refund_payment_id,omitempty"`
 	GatewayRefundID string             `bson:"gateway_refund_id,omitempty" json:"gateway_refund_id,omitempty"`
 	Status          RefundStatus       `bson:"status" json:"status"`
+	FailureReason   string             `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
 
 	// Approval workflow
 	RequiresApproval bool               `bson:"requires_approval" json:"requires_approval"`
@@ -344,6 +361,32 @@ const (
 	RefundStatusCancelled  RefundStatus = "cancelled"
 )
 
+// RefundType describes what part of an order a refund covers
+type RefundType string
+
+const (
+	RefundTypeFull     RefundType = "full"
+	RefundTypePartial  RefundType = "partial"
+	RefundTypeShipping RefundType = "shipping"
+)
+
+// RefundDestination is where refunded money goes
+type RefundDestination string
+
+const (
+	RefundDestinationOriginal RefundDestination = "original"
+	RefundDestinationWallet   RefundDestination = "wallet"
+)
+
+// RefundItem is the part of one order item covered by a refund
+type RefundItem struct {
+	OrderItemID primitive.ObjectID `bson:"order_item_id" json:"order_item_id"`
+	ProductID   primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Quantity    int                `bson:"quantity" json:"quantity"`
+	Amount      float64            `bson:"amount" json:"amount"`
+	Restocked   bool               `bson:"restocked" json:"restocked"`
+}
+
 // BankAccount represents user bank accounts for withdrawals
 type BankAccount struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
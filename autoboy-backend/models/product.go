@@ -16,6 +16,7 @@ const (
 	ProductStatusSuspended ProductStatus = "suspended"
 	ProductStatusDeleted   ProductStatus = "deleted"
 	ProductStatusRejected  ProductStatus = "rejected"
+	ProductStatusExpired   ProductStatus = "expired"
 )
 
 // ProductCondition represents the condition of a product
@@ -68,6 +69,7 @@ type Product struct {
 	SaveCount     int                `bson:"save_count" json:"save_count"`
 	WishlistCount int                `bson:"wishlist_count" json:"wishlist_count"`
 	QuestionCount int                `bson:"question_count" json:"question_count"`
+	PopularityScore float64          `bson:"popularity_score" json:"popularity_score"` // time-decayed, set by the ranking job
 	
 	// Rating and reviews
 	AverageRating float64            `bson:"average_rating" json:"average_rating"`
@@ -83,6 +85,8 @@ type Product struct {
 	IsTrending    bool               `bson:"is_trending" json:"is_trending"`
 	BoostLevel    int                `bson:"boost_level" json:"boost_level"`
 	BoostExpiresAt *time.Time        `bson:"boost_expires_at,omitempty" json:"boost_expires_at,omitempty"`
+	AutoRelist    bool               `bson:"auto_relist" json:"auto_relist"` // relist instead of expiring
+	RelistCount   int                `bson:"relist_count" json:"relist_count"`
 	
 	// Seller information (denormalized for performance)
 	SellerName    string             `bson:"seller_name" json:"seller_name"`
@@ -99,6 +103,8 @@ type Product struct {
 	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
 	PublishedAt   *time.Time         `bson:"published_at,omitempty" json:"published_at,omitempty"`
 	ExpiresAt     *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	RenewedAt     *time.Time         `bson:"renewed_at,omitempty" json:"renewed_at,omitempty"`
+	ExpiryWarnedAt *time.Time        `bson:"expiry_warned_at,omitempty" json:"-"`
 
 	// Admin fields
 	AdminNotes    string             `bson:"admin_notes,omitempty" json:"admin_notes,omitempty"`
@@ -126,6 +132,7 @@ type ProductMedia struct {
 
 // ProductLocation represents product location information
 type ProductLocation struct {
+	Address     string    `bson:"address,omitempty" json:"address,omitempty"`
 	City        string    `bson:"city" json:"city"`
 	State       string    `bson:"state" json:"state"`
 	Country     string    `bson:"country" json:"country"`
@@ -165,20 +172,56 @@ type Category struct {
 	// Category attributes/filters
 	Attributes []CategoryAttribute `bson:"attributes,omitempty" json:"attributes,omitempty"`
 
+	// Ranking thresholds; nil uses the defaults
+	Ranking *CategoryRanking `bson:"ranking,omitempty" json:"ranking,omitempty"`
+	// Listing lifetimes; nil uses the defaults
+	Lifecycle *CategoryLifecycle `bson:"lifecycle,omitempty" json:"lifecycle,omitempty"`
+
 	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
 }
 
+// CategoryRanking overrides the ranking job's thresholds for a category.
+// Zero fields use the defaults.
+type CategoryRanking struct {
+	HotScore        float64 `bson:"hot_score,omitempty" json:"hot_score,omitempty"`               // popularity needed to be hot
+	TrendingScore   float64 `bson:"trending_score,omitempty" json:"trending_score,omitempty"`     // popularity needed to be trending
+	TrendingPercent float64 `bson:"trending_percent,omitempty" json:"trending_percent,omitempty"` // share of the category that can be trending
+	NewDays         int     `bson:"new_days,omitempty" json:"new_days,omitempty"`                 // days a listing counts as new
+}
+
+// CategoryLifecycle overrides how many days listings in a category stay
+// live before they expire, keyed by the seller's premium tier. Tiers left
+// out use the defaults.
+type CategoryLifecycle struct {
+	ListingDays map[PremiumStatus]int `bson:"listing_days,omitempty" json:"listing_days,omitempty"`
+}
+
 // CategoryAttribute represents filterable attributes for categories
 type CategoryAttribute struct {
 	Name        string   `bson:"name" json:"name"`
 	Type        string   `bson:"type" json:"type"` // text, number, select, multi_select, boolean, range
 	Options     []string `bson:"options,omitempty" json:"options,omitempty"`
+	Unit        string   `bson:"unit,omitempty" json:"unit,omitempty"`
+	Min         *float64 `bson:"min,omitempty" json:"min,omitempty"` // bounds for number and range
+	Max         *float64 `bson:"max,omitempty" json:"max,omitempty"`
 	IsRequired  bool     `bson:"is_required" json:"is_required"`
 	IsFilterable bool    `bson:"is_filterable" json:"is_filterable"`
 	SortOrder   int      `bson:"sort_order" json:"sort_order"`
 }
 
+// Category attribute types. Number and range attributes both hold a single
+// number on a listing; range attributes are meant to be filtered by bounds
+// (mileage, battery health) rather than by exact value.
+const (
+	AttributeTypeText        = "text"
+	AttributeTypeNumber      = "number"
+	AttributeTypeSelect      = "select"
+	AttributeTypeMultiSelect = "multi_select"
+	AttributeTypeBoolean     = "boolean"
+	AttributeTypeRange       = "range"
+)
+
 // ProductReview represents product reviews and ratings
 type ProductReview struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -269,6 +312,82 @@ type ProductAnalytics struct {
 	ContactSeller  int                `bson:"contact_seller" json:"contact_seller"`
 	SwapRequests   int                `bson:"swap_requests" json:"swap_requests"`
 	PurchaseClicks int                `bson:"purchase_clicks" json:"purchase_clicks"`
-	ConversionRate float64            `bson:"conversion_rate" json:"conversion_rate"`
+	Orders         int                `bson:"orders" json:"orders"`
+	ConversionRate float64            `bson:"conversion_rate" json:"conversion_rate"` // orders per 100 unique views
 	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
-}
\ No newline at end of file
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ProductEventType is a step a buyer takes towards buying a listing
+type ProductEventType string
+
+const (
+	ProductEventSave          ProductEventType = "save"
+	ProductEventShare         ProductEventType = "share"
+	ProductEventContactSeller ProductEventType = "contact_seller"
+	ProductEventSwapRequest   ProductEventType = "swap_request"
+	ProductEventPurchaseClick ProductEventType = "purchase_click"
+)
+
+// ProductEvent records a funnel event on a listing; views are recorded as
+// ProductView
+type ProductEvent struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductID  primitive.ObjectID  `bson:"product_id" json:"product_id"`
+	Type       ProductEventType    `bson:"type" json:"type"`
+	UserID     *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	SessionID  string              `bson:"session_id" json:"session_id"`
+	IPAddress  string              `bson:"ip_address" json:"ip_address"`
+	OccurredAt time.Time           `bson:"occurred_at" json:"occurred_at"`
+}
+
+// ProductBump raises a listing's BoostLevel for a number of days. Sellers
+// pay for bumps from their wallet, or use their premium tier's monthly
+// allowance of free ones.
+type ProductBump struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductID     primitive.ObjectID  `bson:"product_id" json:"product_id"`
+	SellerID      primitive.ObjectID  `bson:"seller_id" json:"seller_id"`
+	Level         int                 `bson:"level" json:"level"`
+	Days          int                 `bson:"days" json:"days"`
+	Amount        float64             `bson:"amount" json:"amount"`
+	Currency      string              `bson:"currency" json:"currency"`
+	Free          bool                `bson:"free" json:"free"`
+	TransactionID *primitive.ObjectID `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"`
+	StartsAt      time.Time           `bson:"starts_at" json:"starts_at"`
+	ExpiresAt     time.Time           `bson:"expires_at" json:"expires_at"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// ReservationStatus represents the state of a stock reservation
+type ReservationStatus string
+
+const (
+	ReservationStatusActive    ReservationStatus = "active"    // held while payment is pending
+	ReservationStatusCommitted ReservationStatus = "committed" // order paid, stock sold
+	ReservationStatusReleased  ReservationStatus = "released"  // stock returned to the product
+)
+
+// StockReservation holds the stock taken for an order until the order is
+// paid for, or gives it back when the order is cancelled, its payment fails
+// or the reservation expires
+type StockReservation struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	OrderID       primitive.ObjectID  `bson:"order_id" json:"order_id"`
+	CheckoutID    *primitive.ObjectID `bson:"checkout_id,omitempty" json:"checkout_id,omitempty"`
+	BuyerID       primitive.ObjectID  `bson:"buyer_id" json:"buyer_id"`
+	Items         []ReservedItem      `bson:"items" json:"items"`
+	Status        ReservationStatus   `bson:"status" json:"status"`
+	ExpiresAt     time.Time           `bson:"expires_at" json:"expires_at"`
+	CommittedAt   *time.Time          `bson:"committed_at,omitempty" json:"committed_at,omitempty"`
+	ReleasedAt    *time.Time          `bson:"released_at,omitempty" json:"released_at,omitempty"`
+	ReleaseReason string              `bson:"release_reason,omitempty" json:"release_reason,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// ReservedItem is the quantity of one product held by a reservation
+type ReservedItem struct {
+	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Quantity  int                `bson:"quantity" json:"quantity"`
+}
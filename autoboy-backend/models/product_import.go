@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductImportFormat is the file format of a bulk listing import or export
+type ProductImportFormat string
+
+const (
+	ProductImportCSV  ProductImportFormat = "csv"
+	ProductImportJSON ProductImportFormat = "json"
+)
+
+// ProductImportStatus is how far a bulk listing import has got
+type ProductImportStatus string
+
+const (
+	ProductImportPending    ProductImportStatus = "pending"
+	ProductImportProcessing ProductImportStatus = "processing"
+	ProductImportCompleted  ProductImportStatus = "completed"
+	ProductImportFailed     ProductImportStatus = "failed"
+)
+
+// ProductImport is a seller's uploaded catalogue file. Its rows create or
+// update the seller's listings by SKU in the background; rows that fail
+// validation are reported in Errors and the rest still go through.
+type ProductImport struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	SellerID    primitive.ObjectID   `bson:"seller_id" json:"seller_id"`
+	Format      ProductImportFormat  `bson:"format" json:"format"`
+	FileName    string               `bson:"file_name" json:"file_name"`
+	Status      ProductImportStatus  `bson:"status" json:"status"`
+	TotalRows   int                  `bson:"total_rows" json:"total_rows"`
+	Processed   int                  `bson:"processed" json:"processed"`
+	Created     int                  `bson:"created" json:"created"`
+	Updated     int                  `bson:"updated" json:"updated"`
+	Failed      int                  `bson:"failed" json:"failed"`
+	Errors      []ProductImportError `bson:"errors" json:"errors"`
+	Message     string               `bson:"message,omitempty" json:"message,omitempty"` // why the whole import failed
+	Rows        []ProductImportRow   `bson:"rows,omitempty" json:"-"`                    // dropped once processed
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+	StartedAt   *time.Time           `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt *time.Time           `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// ProductImportRow is one parsed row of an import file, keyed by column.
+// Line is the row's line in a CSV file or its position in a JSON array.
+type ProductImportRow struct {
+	Line           int                    `bson:"line" json:"line"`
+	Fields         map[string]string      `bson:"fields" json:"fields"`
+	Specifications map[string]interface{} `bson:"specifications,omitempty" json:"specifications,omitempty"`
+}
+
+// ProductImportError is why one row of an import was skipped, by column
+type ProductImportError struct {
+	Line   int               `bson:"line" json:"line"`
+	SKU    string            `bson:"sku,omitempty" json:"sku,omitempty"`
+	Errors map[string]string `bson:"errors" json:"errors"`
+}
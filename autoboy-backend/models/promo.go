@@ -22,6 +22,7 @@ type PromoCode struct {
 	Name        string             `bson:"name" json:"name" validate:"required"`
 	Description string             `bson:"description,omitempty" json:"description,omitempty"`
 	Type        PromoCodeType      `bson:"type" json:"type" validate:"required"`
+	Currency    string             `bson:"currency,omitempty" json:"currency,omitempty"` // for fixed and shipping codes
 	
 	// Discount details
 	DiscountValue   float64 `bson:"discount_value" json:"discount_value"` // percentage (0-100) or fixed amount
@@ -62,6 +63,7 @@ type PromoCodeUsage struct {
 	PromoCodeID  primitive.ObjectID `bson:"promo_code_id" json:"promo_code_id"`
 	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
 	OrderID      primitive.ObjectID `bson:"order_id" json:"order_id"`
+	CheckoutID   *primitive.ObjectID `bson:"checkout_id,omitempty" json:"checkout_id,omitempty"`
 	Code         string             `bson:"code" json:"code"`
 	DiscountAmount float64          `bson:"discount_amount" json:"discount_amount"`
 	OrderAmount    float64          `bson:"order_amount" json:"order_amount"`
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecommendationKind names a precomputed recommendation list
+type RecommendationKind string
+
+const (
+	RecommendationAlsoViewed RecommendationKind = "also_viewed"
+	RecommendationAlsoBought RecommendationKind = "also_bought"
+	RecommendationForUser    RecommendationKind = "for_user"
+	RecommendationTrending   RecommendationKind = "trending"
+)
+
+// Recommendation is a ranked list of products recomputed by the
+// recommendations job. SubjectID is the product (also viewed/bought) or
+// user (for you) the list belongs to. Trending products are not stored;
+// they come from the ranking job's flags.
+type Recommendation struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Kind      RecommendationKind  `bson:"kind" json:"kind"`
+	SubjectID *primitive.ObjectID `bson:"subject_id,omitempty" json:"subject_id,omitempty"`
+	Items     []RecommendedItem   `bson:"items" json:"items"`
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// RecommendedItem is one product in a recommendation list
+type RecommendedItem struct {
+	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Score     float64            `bson:"score" json:"score"`
+}
@@ -14,11 +14,32 @@ type SavedSearch struct {
 	Filters     SearchFilters      `bson:"filters" json:"filters"`
 	ResultCount int                `bson:"result_count" json:"result_count"`
 	IsActive    bool               `bson:"is_active" json:"is_active"`
+	Frequency   SavedSearchFrequency `bson:"frequency" json:"frequency"`
+	Channels    []SavedSearchChannel `bson:"channels" json:"channels"`
 	LastChecked *time.Time         `bson:"last_checked,omitempty" json:"last_checked,omitempty"`
+	LastNotifiedAt *time.Time      `bson:"last_notified_at,omitempty" json:"last_notified_at,omitempty"`
 	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// SavedSearchFrequency is how often a saved search is re-run for new listings
+type SavedSearchFrequency string
+
+const (
+	SavedSearchInstant SavedSearchFrequency = "instant"
+	SavedSearchDaily   SavedSearchFrequency = "daily"
+	SavedSearchWeekly  SavedSearchFrequency = "weekly"
+)
+
+// SavedSearchChannel is a way of telling a user about new matches
+type SavedSearchChannel string
+
+const (
+	SavedSearchChannelInApp     SavedSearchChannel = "in_app"
+	SavedSearchChannelEmail     SavedSearchChannel = "email"
+	SavedSearchChannelWebSocket SavedSearchChannel = "websocket"
+)
+
 // SearchFilters represents search filter criteria
 type SearchFilters struct {
 	Categories   []primitive.ObjectID `bson:"categories,omitempty" json:"categories,omitempty"`
@@ -27,6 +48,7 @@ type SearchFilters struct {
 	PriceMin     *float64             `bson:"price_min,omitempty" json:"price_min,omitempty"`
 	PriceMax     *float64             `bson:"price_max,omitempty" json:"price_max,omitempty"`
 	Location     LocationFilter       `bson:"location,omitempty" json:"location,omitempty"`
+	Specifications map[string][]string `bson:"specifications,omitempty" json:"specifications,omitempty"`
 	SwapOnly     bool                 `bson:"swap_only" json:"swap_only"`
 	FeaturedOnly bool                 `bson:"featured_only" json:"featured_only"`
 	SortBy       string               `bson:"sort_by,omitempty" json:"sort_by,omitempty"`
@@ -59,9 +81,27 @@ type SearchHistory struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"` // nil for anonymous
 	Query     string             `bson:"query" json:"query"`
+	NormalizedQuery string       `bson:"normalized_query" json:"normalized_query"`
+	Source    string             `bson:"source" json:"source"` // search, advanced
 	Filters   SearchFilters      `bson:"filters,omitempty" json:"filters,omitempty"`
 	Results   int                `bson:"results" json:"results"`
-	IPAddress string             `bson:"ip_address" json:"ip_address"`
-	UserAgent string             `bson:"user_agent" json:"user_agent"`
+	Clicks    []SearchClick      `bson:"clicks,omitempty" json:"clicks,omitempty"`
+	IPAddress string             `bson:"ip_address" json:"ip_address"` // truncated for guests
+	UserAgent string             `bson:"user_agent" json:"user_agent"` // empty for guests
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-}
\ No newline at end of file
+}
+
+// SearchClick is a result opened from a search
+type SearchClick struct {
+	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Position  int                `bson:"position" json:"position"`
+	ClickedAt time.Time          `bson:"clicked_at" json:"clicked_at"`
+}
+
+// Search suggestion types
+const (
+	SuggestionTypeQuery    = "query"
+	SuggestionTypeProduct  = "product"
+	SuggestionTypeBrand    = "brand"
+	SuggestionTypeCategory = "category"
+)
\ No newline at end of file
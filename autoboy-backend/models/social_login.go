@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SocialProvider is an OpenID Connect provider users can sign in with
+type SocialProvider string
+
+const (
+	SocialProviderGoogle SocialProvider = "google"
+	SocialProviderApple  SocialProvider = "apple"
+)
+
+// SocialAccount is a provider identity linked to a user
+type SocialAccount struct {
+	Provider SocialProvider `bson:"provider" json:"provider"`
+	Subject  string         `bson:"subject" json:"-"` // the provider's stable user ID
+	Email    string         `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time      `bson:"linked_at" json:"linked_at"`
+}
+
+// OAuthState is a browser sign-in in progress with a provider. The state
+// and PKCE verifier tie the provider's callback to the request that started
+// it, and the nonce ties the ID token to it.
+type OAuthState struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Provider     SocialProvider     `bson:"provider" json:"provider"`
+	StateHash    string             `bson:"state_hash" json:"-"`
+	Nonce        string             `bson:"nonce" json:"-"`
+	CodeVerifier string             `bson:"code_verifier" json:"-"`
+	RedirectURI  string             `bson:"redirect_uri" json:"redirect_uri"`
+	IPAddress    string             `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// SocialSignup is a provider identity with no account yet, held until the
+// user gives the username and phone number every account needs
+type SocialSignup struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenHash     string             `bson:"token_hash" json:"-"`
+	Provider      SocialProvider     `bson:"provider" json:"provider"`
+	Subject       string             `bson:"subject" json:"-"`
+	Email         string             `bson:"email" json:"email"`
+	EmailVerified bool               `bson:"email_verified" json:"email_verified"`
+	FirstName     string             `bson:"first_name,omitempty" json:"first_name,omitempty"`
+	LastName      string             `bson:"last_name,omitempty" json:"last_name,omitempty"`
+	Avatar        string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	ExpiresAt     time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TwoFactorMethod is how a user proves a second factor
+type TwoFactorMethod string
+
+const (
+	TwoFactorTOTP       TwoFactorMethod = "totp"
+	TwoFactorSMS        TwoFactorMethod = "sms"
+	TwoFactorBackupCode TwoFactorMethod = "backup_code"
+)
+
+// TwoFactorPurpose is what a two-factor challenge unlocks
+type TwoFactorPurpose string
+
+const (
+	TwoFactorPurposeLogin  TwoFactorPurpose = "login"   // second step of signing in
+	TwoFactorPurposeStepUp TwoFactorPurpose = "step_up" // sensitive action in a signed-in session
+	TwoFactorPurposeEnrol  TwoFactorPurpose = "enrol"   // confirming SMS before turning it on
+	TwoFactorPurposePhone  TwoFactorPurpose = "phone"   // passwordless sign-in with a phone number
+)
+
+// TwoFactorChallenge is a pending second-factor check. Login challenges are
+// found by their token, which the client got from the password step; SMS
+// codes are stored hashed on the challenge they were sent for.
+type TwoFactorChallenge struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Purpose    TwoFactorPurpose    `bson:"purpose" json:"purpose"`
+	SessionID  *primitive.ObjectID `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	TokenHash  string              `bson:"token_hash,omitempty" json:"-"`
+	CodeHash   string              `bson:"code_hash,omitempty" json:"-"`
+	CodeSentAt *time.Time          `bson:"code_sent_at,omitempty" json:"code_sent_at,omitempty"`
+	Attempts   int                 `bson:"attempts" json:"attempts"`
+	IPAddress  string              `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	UsedAt     *time.Time          `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	ExpiresAt  time.Time           `bson:"expires_at" json:"expires_at"`
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+}
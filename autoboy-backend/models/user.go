@@ -57,6 +57,7 @@ type User struct {
 	IsEmailVerified bool               `bson:"is_email_verified" json:"is_email_verified"`
 	IsPhoneVerified bool               `bson:"is_phone_verified" json:"is_phone_verified"`
 	TwoFactorEnabled bool              `bson:"two_factor_enabled" json:"two_factor_enabled"`
+	TwoFactorMethod TwoFactorMethod    `bson:"two_factor_method,omitempty" json:"two_factor_method,omitempty"`
 	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
 
@@ -82,6 +83,23 @@ type User struct {
 	LockedUntil     *time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
 	PasswordResetToken string `bson:"password_reset_token,omitempty" json:"-"`
 	PasswordResetExpiry *time.Time `bson:"password_reset_expiry,omitempty" json:"-"`
+	UnlockTokenHash    string     `bson:"unlock_token_hash,omitempty" json:"-"` // emailed when the account locks
+	UnlockTokenExpiry  *time.Time `bson:"unlock_token_expiry,omitempty" json:"-"`
+
+	// Two-factor authentication; secrets are stored sealed and backup codes hashed
+	TOTPSecret        string   `bson:"totp_secret,omitempty" json:"-"`
+	TOTPPendingSecret string   `bson:"totp_pending_secret,omitempty" json:"-"` // awaiting a first valid code
+	TOTPLastStep      int64    `bson:"totp_last_step,omitempty" json:"-"`      // stops a code being replayed
+	BackupCodes       []string `bson:"backup_codes,omitempty" json:"-"`
+	// Wrong codes in a row on step-up checks, and the lock they led to
+	StepUpFailures    int        `bson:"step_up_failures,omitempty" json:"-"`
+	StepUpLockedUntil *time.Time `bson:"step_up_locked_until,omitempty" json:"-"`
+
+	// Google and Apple identities the user can sign in with
+	SocialAccounts []SocialAccount `bson:"social_accounts,omitempty" json:"social_accounts,omitempty"`
+
+	// Staff roles granting admin permissions; only meaningful for admins
+	AdminRoles []string `bson:"admin_roles,omitempty" json:"admin_roles,omitempty"`
 }
 
 // Profile represents user profile information
@@ -189,29 +207,47 @@ type PremiumMembership struct {
 	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
-// UserSession represents user session data
+// UserSession represents user session data. A session is one signed-in
+// device: short-lived access tokens name it, and its refresh token rotates
+// on every use.
 type UserSession struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
-	SessionToken string             `bson:"session_token" json:"session_token"`
-	DeviceInfo   DeviceInfo         `bson:"device_info" json:"device_info"`
-	IPAddress    string             `bson:"ip_address" json:"ip_address"`
-	Location     string             `bson:"location,omitempty" json:"location,omitempty"`
-	IsActive     bool               `bson:"is_active" json:"is_active"`
-	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
-	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
-	LastActivity time.Time          `bson:"last_activity" json:"last_activity"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	SessionToken   string             `bson:"session_token" json:"-"`             // hash of the current refresh token
+	PreviousTokens []string           `bson:"previous_tokens,omitempty" json:"-"` // hashes of rotated-out refresh tokens, newest last
+	DeviceInfo     DeviceInfo         `bson:"device_info" json:"device_info"`
+	IPAddress      string             `bson:"ip_address" json:"ip_address"`
+	Location       string             `bson:"location,omitempty" json:"location,omitempty"`
+	IsActive       bool               `bson:"is_active" json:"is_active"`
+	Current        bool               `bson:"-" json:"current"`
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	LastActivity   time.Time          `bson:"last_activity" json:"last_activity"`
+	RotatedAt      *time.Time         `bson:"rotated_at,omitempty" json:"-"`
+	StepUpAt       *time.Time         `bson:"step_up_at,omitempty" json:"step_up_at,omitempty"` // last second-factor check for sensitive actions
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	RevokedReason  string             `bson:"revoked_reason,omitempty" json:"revoked_reason,omitempty"`
 }
 
 // DeviceInfo represents device information for sessions
 type DeviceInfo struct {
 	UserAgent   string `bson:"user_agent" json:"user_agent"`
+	DeviceID    string `bson:"device_id,omitempty" json:"device_id,omitempty"` // sent by the mobile app
 	DeviceType  string `bson:"device_type" json:"device_type"` // mobile, desktop, tablet
 	OS          string `bson:"os" json:"os"`
 	Browser     string `bson:"browser" json:"browser"`
 	AppVersion  string `bson:"app_version,omitempty" json:"app_version,omitempty"`
 }
 
+// Reasons a session was revoked
+const (
+	SessionRevokedLogout         = "logout"
+	SessionRevokedByUser         = "revoked_by_user"
+	SessionRevokedReplaced       = "replaced_by_new_login"
+	SessionRevokedTokenReuse     = "refresh_token_reused"
+	SessionRevokedPasswordChange = "password_changed"
+)
+
 // UserActivity represents user activity logs
 type UserActivity struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
@@ -225,6 +261,20 @@ type UserActivity struct {
 	Timestamp   time.Time          `bson:"timestamp" json:"timestamp"`
 }
 
+// User activity actions read back by background jobs
+const (
+	ActivityWishlistAdd = "wishlist_add"
+)
+
+// Sign-in security events, kept for admins reviewing brute-force attempts
+const (
+	ActivityLoginFailed     = "login_failed"
+	ActivityLoginBlocked    = "login_blocked"    // attempted while locked out
+	ActivityLoginThrottled  = "login_throttled"  // one IP locked out of one account
+	ActivityAccountLocked   = "account_locked"   // locked out from everywhere
+	ActivityAccountUnlocked = "account_unlocked"
+)
+
 // FollowRelationship represents user following relationships
 type FollowRelationship struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
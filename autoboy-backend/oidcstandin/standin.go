@@ -0,0 +1,322 @@
+// Package oidcstandin is a local OpenID Connect provider for trying Google
+// and Apple sign-in without either. It approves every sign-in straight away
+// as the user named in the authorize request (email, sub, given_name,
+// family_name and email_verified query parameters), and /mint hands out an
+// ID token directly for testing the app flow. cmd/oidc-standin serves it;
+// tests run it in process.
+package oidcstandin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "standin-1"
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	email         string
+	subject       string
+	givenName     string
+	familyName    string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+// Server is a stand-in provider for one issuer
+type Server struct {
+	issuer string
+	method jwt.SigningMethod
+	key    interface{}
+	jwk    map[string]string
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+var formPost = template.Must(template.New("form").Parse(`<!DOCTYPE html>
+<html><body onload="document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Fields}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body></html>`))
+
+// New returns a provider that clients reach at issuer and that signs ID
+// tokens with alg: RS256 (like Google) or ES256 (like Apple)
+func New(issuer, alg string) (*Server, error) {
+	s := &Server{issuer: strings.TrimRight(issuer, "/"), grants: make(map[string]*grant)}
+	switch alg {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		s.method, s.key = jwt.SigningMethodRS256, key
+		s.jwk = map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case "ES256":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		s.method, s.key = jwt.SigningMethodES256, key
+		s.jwk = map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	s.jwk["kid"], s.jwk["use"], s.jwk["alg"] = keyID, "sig", alg
+	return s, nil
+}
+
+// Issuer is the URL clients reach the provider at
+func (s *Server) Issuer() string {
+	return s.issuer
+}
+
+// Handler serves the provider's endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.keys)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/mint", s.mint)
+	return mux
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{s.method.Alg()},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{s.jwk}})
+}
+
+// authorize approves the sign-in and sends the browser back with a code
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("response_type") != "code" || q.Get("client_id") == "" || redirectURI == "" {
+		http.Error(w, "response_type=code, client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") != "" && q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "only S256 code challenges are supported", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	g := identity(q)
+	g.clientID = q.Get("client_id")
+	g.redirectURI = redirectURI
+	g.challenge = q.Get("code_challenge")
+	g.nonce = q.Get("nonce")
+	g.expiresAt = time.Now().Add(5 * time.Minute)
+	s.mu.Lock()
+	s.grants[code] = g
+	s.mu.Unlock()
+	log.Printf("Approved sign-in for %s (%s) to %s", g.email, g.subject, g.clientID)
+
+	fields := map[string]string{"code": code, "state": q.Get("state")}
+	if q.Get("response_mode") == "form_post" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		formPost.Execute(w, map[string]interface{}{"Action": redirectURI, "Fields": fields})
+		return
+	}
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := target.Query()
+	for name, value := range fields {
+		values.Set(name, value)
+	}
+	target.RawQuery = values.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token swaps a code for an ID token, checking the PKCE verifier
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	g, ok := s.grants[code]
+	delete(s.grants, code)
+	s.mu.Unlock()
+	if !ok || time.Now().After(g.expiresAt) {
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if user, _, ok := r.BasicAuth(); ok {
+		clientID = user
+	}
+	if clientID != g.clientID || r.PostForm.Get("redirect_uri") != g.redirectURI {
+		tokenError(w, "invalid_grant", "client_id or redirect_uri does not match the authorize request")
+		return
+	}
+	if g.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			tokenError(w, "invalid_grant", "code_verifier does not match the code challenge")
+			return
+		}
+	}
+
+	idToken, err := s.sign(g)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// mint returns an ID token for the user in the query, as a platform SDK
+// would hand one to the app. aud is the client ID it is for.
+func (s *Server) mint(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("aud") == "" {
+		http.Error(w, "aud is required", http.StatusBadRequest)
+		return
+	}
+	g := identity(q)
+	g.clientID = q.Get("aud")
+	g.nonce = q.Get("nonce")
+
+	idToken, err := s.sign(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": idToken})
+}
+
+func (s *Server) sign(g *grant) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.issuer,
+		"aud":            g.clientID,
+		"sub":            g.subject,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if g.givenName != "" {
+		claims["given_name"] = g.givenName
+	}
+	if g.familyName != "" {
+		claims["family_name"] = g.familyName
+	}
+
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(s.key)
+}
+
+// identity reads the user to sign in as from query parameters. The subject
+// defaults to one derived from the email, so the same email is always the
+// same user.
+func identity(q url.Values) *grant {
+	email := q.Get("email")
+	if email == "" {
+		email = q.Get("login_hint")
+	}
+	if email == "" {
+		email = "tester@example.com"
+	}
+	subject := q.Get("sub")
+	if subject == "" {
+		sum := sha256.Sum256([]byte(strings.ToLower(email)))
+		subject = hex.EncodeToString(sum[:10])
+	}
+	return &grant{
+		email:         email,
+		subject:       subject,
+		givenName:     q.Get("given_name"),
+		familyName:    q.Get("family_name"),
+		emailVerified: q.Get("email_verified") != "false",
+	}
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("random: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
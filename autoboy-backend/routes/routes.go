@@ -18,45 +18,63 @@ func SetupRoutes(router *gin.Engine) {
 	// Initialize WebSocket hub
 	go services.WSHub.Run()
 
-	// Initialize services
-	emailService := services.NewEmailService()
-	smsService := services.NewSMSService()
-	imageService := services.NewImageService()
-	paymentService := services.NewPaymentService()
+	// Every service comes from the shared registry, so handlers and the
+	// background jobs work with the same instances
+	svc := services.GetServices()
+	emailService := svc.Email
+	searchService := svc.Search
+	smsService := svc.SMS
+	imageService := svc.Image
+	paymentService := svc.Payment
+	walletService := svc.Wallet
+	escrowService := svc.Escrow
+	withdrawalService := svc.Withdrawal
+	orderService := svc.Order
+	refundService := svc.Refund
+	checkoutService := svc.Checkout
+	webhookService := svc.Webhook
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(emailService, smsService)
-	productHandler := handlers.NewProductHandler(imageService)
+	authHandler := handlers.NewAuthHandler(emailService, smsService, svc.TwoFactor, svc.LoginGuard, svc.SocialLogin)
+	twoFactorHandler := handlers.NewTwoFactorHandler(svc.TwoFactor)
+	productHandler := handlers.NewProductHandler(imageService, searchService, svc.Recommendation, svc.Events)
 	userHandler := handlers.NewUserHandler(emailService, smsService)
-	orderHandler := handlers.NewOrderHandler(paymentService, emailService)
+	orderHandler := handlers.NewOrderHandler(paymentService, emailService, checkoutService, orderService, refundService)
 	sellerHandler := handlers.NewSellerHandler(emailService)
-	cartHandler := handlers.NewCartHandler()
-	categoryHandler := handlers.NewCategoryHandler()
+	cartHandler := handlers.NewCartHandler(svc.Promo)
+	categoryHandler := handlers.NewCategoryHandler(searchService, svc.Ranking, svc.ListingLifecycle)
 	notificationHandler := handlers.NewNotificationHandler()
 	badgeHandler := handlers.NewBadgeHandler()
-	walletHandler := handlers.NewWalletHandler()
+	walletHandler := handlers.NewWalletHandler(walletService, paymentService, withdrawalService)
 	reportHandler := handlers.NewReportHandler()
-	disputeHandler := handlers.NewDisputeHandler()
+	disputeHandler := handlers.NewDisputeHandler(escrowService, refundService, orderService)
 	chatHandler := handlers.NewChatHandler()
 	alertHandler := handlers.NewAlertHandler()
 	dealHandler := handlers.NewDealHandler()
-	swapHandler := handlers.NewSwapHandler()
+	swapHandler := handlers.NewSwapHandler(svc.Events)
 	analyticsHandler := handlers.NewAnalyticsHandler()
-	sellerDashboardHandler := handlers.NewSellerDashboardHandler()
-	buyerDashboardHandler := handlers.NewBuyerDashboardHandler()
+	sellerDashboardHandler := handlers.NewSellerDashboardHandler(svc.ProductAnalytics)
+	listingHandler := handlers.NewListingHandler(svc.ListingLifecycle)
+	productImportHandler := handlers.NewProductImportHandler(svc.ProductImport)
+	buyerDashboardHandler := handlers.NewBuyerDashboardHandler(svc.Recommendation)
 	reviewHandler := handlers.NewReviewHandler()
-	searchHandler := handlers.NewSearchHandler()
-	adminHandler := handlers.NewAdminHandler()
+	searchHandler := handlers.NewSearchHandler(searchService, svc.SearchAnalytics)
+	adminHandler := handlers.NewAdminHandler(searchService, svc.LoginGuard)
+	adminRoleHandler := handlers.NewAdminRoleHandler(svc.AdminRoles)
 	subscriptionHandler := handlers.NewSubscriptionHandler()
-	paystackHandler := handlers.NewPaystackHandler()
-	wishlistHandler := handlers.NewWishlistHandler()
+	paymentHandler := handlers.NewPaymentHandler(paymentService, webhookService, refundService)
+	wishlistHandler := handlers.NewWishlistHandler(svc.Events)
 	followHandler := handlers.NewFollowHandler()
 	activityHandler := handlers.NewActivityHandler()
-	savedSearchHandler := handlers.NewSavedSearchHandler()
+	savedSearchHandler := handlers.NewSavedSearchHandler(svc.SavedSearch)
 	questionHandler := handlers.NewQuestionHandler()
 	trackingHandler := handlers.NewTrackingHandler()
 	priceAlertHandler := handlers.NewPriceAlertHandler()
 	systemHandler := handlers.NewSystemHandler()
+	escrowHandler := handlers.NewEscrowHandler(escrowService)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	withdrawalHandler := handlers.NewWithdrawalHandler(withdrawalService)
+	refundHandler := handlers.NewRefundHandler(refundService)
 
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
@@ -79,9 +97,22 @@ func SetupRoutes(router *gin.Engine) {
 			{
 				auth.POST("/register", authHandler.Register)
 				auth.POST("/login", authHandler.Login)
+				auth.POST("/refresh", authHandler.RefreshToken)
+				auth.POST("/2fa/verify", authHandler.VerifyLoginTwoFactor)
+				auth.POST("/2fa/sms", authHandler.SendLoginTwoFactorCode)
 				auth.GET("/verify-email", authHandler.VerifyEmail)
 				auth.POST("/forgot-password", authHandler.ForgotPassword)
 				auth.POST("/reset-password", authHandler.ResetPassword)
+				auth.POST("/unlock", authHandler.UnlockAccount)
+
+				// Google and Apple sign-in, and passwordless phone sign-in
+				auth.GET("/social/providers", authHandler.GetSocialProviders)
+				auth.POST("/social/register", authHandler.CompleteSocialSignup)
+				auth.GET("/social/:provider/authorize", authHandler.AuthorizeSocial)
+				auth.POST("/social/:provider/callback", authHandler.SocialCallback)
+				auth.POST("/social/:provider/token", authHandler.SocialTokenSignIn)
+				auth.POST("/phone/code", authHandler.SendPhoneLoginCode)
+				auth.POST("/phone/verify", authHandler.PhoneLogin)
 				auth.POST("/resend-email-verification", authHandler.ResendEmailVerification)
 				auth.POST("/resend-verification", authHandler.ResendEmailVerification)
 			}
@@ -94,6 +125,15 @@ func SetupRoutes(router *gin.Engine) {
 				products.GET("/:id", productHandler.GetProduct)
 				products.GET("/:id/reviews", reviewHandler.GetProductReviews)
 				products.GET("/:id/questions", questionHandler.GetProductQuestions)
+				products.GET("/:id/recommendations", productHandler.GetProductRecommendations)
+				products.POST("/:id/events", productHandler.TrackProductEvent)
+			}
+
+			// Home page recommendations; signed-in users also get a personal feed
+			recommendations := public.Group("/recommendations")
+			recommendations.Use(middleware.OptionalAuthMiddleware())
+			{
+				recommendations.GET("/home", productHandler.GetHomeRecommendations)
 			}
 
 			// Public category routes
@@ -101,18 +141,26 @@ func SetupRoutes(router *gin.Engine) {
 			{
 				categories.GET("/", categoryHandler.GetCategories)
 				categories.GET("/:id", categoryHandler.GetCategory)
+				categories.GET("/:id/attributes", categoryHandler.GetCategoryAttributes)
+			}
+
+			// Search routes; signed-in searches are logged against the user
+			search := public.Group("/search")
+			search.Use(middleware.OptionalAuthMiddleware())
+			{
+				search.GET("", searchHandler.SearchProducts)
+				search.POST("/advanced", searchHandler.AdvancedSearch)
+				search.GET("/suggestions", searchHandler.GetSearchSuggestions)
+				search.GET("/geocode", searchHandler.Geocode)
+				search.POST("/click", searchHandler.RecordSearchClick)
 			}
 
-			// Search routes
-			public.GET("/search", searchHandler.SearchProducts)
-			public.POST("/search/advanced", searchHandler.AdvancedSearch)
-			public.GET("/search/suggestions", searchHandler.GetSearchSuggestions)
+			// Payment gateway webhooks (verified by signature, not JWT)
+			public.POST("/payment/webhook", paymentHandler.HandleWebhook)
+			public.POST("/payment/webhook/:gateway", paymentHandler.HandleWebhook)
 
 			// Order tracking (public with order number)
 			public.GET("/orders/:id/track", trackingHandler.GetOrderTracking)
-
-			// Temporary public system initialization (remove in production)
-			public.POST("/system/init-database", systemHandler.InitializeDatabase)
 		}
 
 		// Protected routes (authentication required)
@@ -127,10 +175,32 @@ func SetupRoutes(router *gin.Engine) {
 				user.PUT("/profile", userHandler.UpdateProfile)
 				user.POST("/verify-phone", authHandler.VerifyPhone)
 				user.POST("/resend-phone-otp", authHandler.ResendPhoneOTP)
-				user.POST("/change-password", userHandler.ChangePassword)
+				user.POST("/change-password", middleware.RequireStepUp(), userHandler.ChangePassword)
 				user.POST("/logout", authHandler.Logout)
 				user.DELETE("/account", userHandler.DeleteAccount)
 
+				// Signed-in devices
+				user.GET("/sessions", authHandler.GetSessions)
+				user.DELETE("/sessions", authHandler.RevokeSessions)
+				user.DELETE("/sessions/:id", authHandler.RevokeSession)
+
+				// Linked Google and Apple accounts
+				user.DELETE("/social-accounts/:provider", authHandler.UnlinkSocialAccount)
+
+				// Two-factor authentication
+				twoFactor := user.Group("/2fa")
+				{
+					twoFactor.GET("/", twoFactorHandler.GetTwoFactorStatus)
+					twoFactor.POST("/totp/setup", twoFactorHandler.SetupTOTP)
+					twoFactor.POST("/totp/enable", twoFactorHandler.EnableTOTP)
+					twoFactor.POST("/sms/setup", twoFactorHandler.SetupSMS)
+					twoFactor.POST("/sms/enable", twoFactorHandler.EnableSMS)
+					twoFactor.POST("/disable", twoFactorHandler.DisableTwoFactor)
+					twoFactor.POST("/backup-codes", twoFactorHandler.RegenerateBackupCodes)
+					twoFactor.POST("/step-up/sms", twoFactorHandler.SendStepUpCode)
+					twoFactor.POST("/step-up", twoFactorHandler.StepUp)
+				}
+
 				// User addresses
 				addresses := user.Group("/addresses")
 				{
@@ -146,10 +216,15 @@ func SetupRoutes(router *gin.Engine) {
 					orders.GET("/", orderHandler.GetUserOrders)
 					orders.GET("/:id", orderHandler.GetOrder)
 					orders.POST("/:id/cancel", orderHandler.CancelOrder)
+					orders.POST("/:id/confirm-delivery", orderHandler.ConfirmDelivery)
 					orders.POST("/:id/return", orderHandler.RequestReturn)
 					orders.POST("/:id/refund", orderHandler.RequestRefund)
 				}
 
+				// User returns
+				user.GET("/returns", refundHandler.GetMyReturns)
+				user.POST("/returns/:id/ship", refundHandler.ShipReturn)
+
 				// User notifications
 				user.GET("/notifications", userHandler.GetNotifications)
 				user.PUT("/notifications/:id/read", userHandler.MarkNotificationRead)
@@ -188,6 +263,7 @@ func SetupRoutes(router *gin.Engine) {
 			{
 				searches.GET("/", savedSearchHandler.GetSavedSearches)
 				searches.POST("/", savedSearchHandler.CreateSavedSearch)
+				searches.PUT("/:id", savedSearchHandler.UpdateSavedSearch)
 				searches.DELETE("/:id", savedSearchHandler.DeleteSavedSearch)
 			}
 
@@ -218,6 +294,8 @@ func SetupRoutes(router *gin.Engine) {
 			orders := protected.Group("/orders")
 			{
 				orders.POST("/", orderHandler.CreateOrder)
+				orders.POST("/checkout", orderHandler.CheckoutCart)
+				orders.GET("/checkouts/:id", orderHandler.GetCheckout)
 			}
 
 			// Review routes
@@ -265,11 +343,22 @@ func SetupRoutes(router *gin.Engine) {
 				{
 					products.GET("/", sellerHandler.GetProducts)
 					products.POST("/", productHandler.CreateProduct)
+					products.GET("/import/columns", productImportHandler.GetImportColumns)
+					products.POST("/import", productImportHandler.ImportProducts)
+					products.GET("/imports", productImportHandler.GetImports)
+					products.GET("/imports/:id", productImportHandler.GetImport)
+					products.GET("/export", productImportHandler.ExportProducts)
 					products.GET("/:id", productHandler.GetProduct)
 					products.PUT("/:id", productHandler.UpdateProduct)
 					products.DELETE("/:id", productHandler.DeleteProduct)
+					products.POST("/:id/renew", listingHandler.RenewListing)
+					products.PUT("/:id/auto-relist", listingHandler.SetAutoRelist)
+					products.POST("/:id/bump", listingHandler.BumpListing)
 				}
 
+				// Paid and free listing bumps
+				seller.GET("/bumps", listingHandler.GetBumps)
+
 				// Seller orders
 				orders := seller.Group("/orders")
 				{
@@ -279,6 +368,15 @@ func SetupRoutes(router *gin.Engine) {
 					orders.POST("/:id/ship", orderHandler.ShipOrder)
 				}
 
+				// Seller returns
+				returns := seller.Group("/returns")
+				{
+					returns.GET("/", refundHandler.GetSellerReturns)
+					returns.POST("/:id/approve", refundHandler.ApproveReturn)
+					returns.POST("/:id/reject", refundHandler.RejectReturn)
+					returns.POST("/:id/receive", refundHandler.ReceiveReturn)
+				}
+
 				// Seller tracking
 				seller.POST("/tracking", trackingHandler.AddTrackingEvent)
 
@@ -291,6 +389,8 @@ func SetupRoutes(router *gin.Engine) {
 					sellerAnalytics.GET("/sales", sellerDashboardHandler.GetSellerSalesAnalytics)
 					sellerAnalytics.GET("/products", sellerDashboardHandler.GetSellerProductAnalytics)
 					sellerAnalytics.GET("/revenue", sellerDashboardHandler.GetSellerRevenueAnalytics)
+					sellerAnalytics.GET("/listings", sellerDashboardHandler.GetSellerListingAnalytics)
+					sellerAnalytics.GET("/listings/:id", sellerDashboardHandler.GetSellerListingAnalyticsDetail)
 				}
 
 				// Seller profile
@@ -300,30 +400,85 @@ func SetupRoutes(router *gin.Engine) {
 
 			// Admin routes
 			admin := protected.Group("/admin")
-			admin.Use(middleware.RequireUserType(models.UserTypeAdmin))
+			admin.Use(middleware.RequireUserType(models.UserTypeAdmin), middleware.AdminAudit())
 			{
+				// Admin staff roles and permissions
+				admin.GET("/permissions", adminRoleHandler.GetPermissions)
+				admin.GET("/roles", middleware.RequirePermission(models.PermissionRolesManage), adminRoleHandler.GetRoles)
+				admin.POST("/roles", middleware.RequirePermission(models.PermissionRolesManage), adminRoleHandler.CreateRole)
+				admin.PUT("/roles/:name", middleware.RequirePermission(models.PermissionRolesManage), adminRoleHandler.UpdateRole)
+				admin.DELETE("/roles/:name", middleware.RequirePermission(models.PermissionRolesManage), adminRoleHandler.DeleteRole)
+				admin.GET("/staff", middleware.RequirePermission(models.PermissionRolesManage), adminRoleHandler.GetStaff)
+				admin.PUT("/users/:id/roles", middleware.RequirePermission(models.PermissionRolesManage), adminRoleHandler.AssignUserRoles)
+				admin.GET("/logs", middleware.RequirePermission(models.PermissionAuditRead), adminRoleHandler.GetAdminLogs)
+
 				// Admin user management
-				admin.GET("/users", adminHandler.GetUsers)
-				admin.GET("/users/:id", adminHandler.GetUser)
-				admin.PUT("/users/:id/status", adminHandler.UpdateUserStatus)
+				admin.GET("/users", middleware.RequirePermission(models.PermissionUsersRead), adminHandler.GetUsers)
+				admin.GET("/users/:id", middleware.RequirePermission(models.PermissionUsersRead), adminHandler.GetUser)
+				admin.PUT("/users/:id/status", middleware.RequirePermission(models.PermissionUsersManage), adminHandler.UpdateUserStatus)
+				admin.POST("/users/:id/unlock", middleware.RequirePermission(models.PermissionUsersUnlock), adminHandler.UnlockUser)
+
+				// Admin sign-in security review
+				admin.GET("/security/login-attempts", middleware.RequirePermission(models.PermissionSecurityRead), adminHandler.GetLoginAttempts)
+				admin.GET("/security/login-sources", middleware.RequirePermission(models.PermissionSecurityRead), adminHandler.GetLoginAttemptSources)
 
 				// Admin product management
-				admin.GET("/products", adminHandler.GetAllProducts)
-				admin.PUT("/products/:id/approve", adminHandler.ApproveProduct)
-				admin.PUT("/products/:id/reject", adminHandler.RejectProduct)
+				admin.GET("/products", middleware.RequirePermission(models.PermissionProductsRead), adminHandler.GetAllProducts)
+				admin.PUT("/products/:id/approve", middleware.RequirePermission(models.PermissionProductsModerate), adminHandler.ApproveProduct)
+				admin.PUT("/products/:id/reject", middleware.RequirePermission(models.PermissionProductsModerate), adminHandler.RejectProduct)
+
+				// Category attribute schemas
+				admin.PUT("/categories/:id/attributes", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.SetCategoryAttributes)
+				admin.PUT("/categories/:id/attributes/:name", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.UpsertCategoryAttribute)
+				admin.DELETE("/categories/:id/attributes/:name", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.DeleteCategoryAttribute)
+				admin.GET("/categories/:id/ranking", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.GetCategoryRanking)
+				admin.PUT("/categories/:id/ranking", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.SetCategoryRanking)
+				admin.GET("/categories/:id/lifecycle", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.GetCategoryLifecycle)
+				admin.PUT("/categories/:id/lifecycle", middleware.RequirePermission(models.PermissionCategoriesManage), categoryHandler.SetCategoryLifecycle)
 
 				// Admin order management
-				admin.GET("/orders", orderHandler.GetAllTransactions)
-				admin.GET("/orders/:id", adminHandler.GetOrder)
+				admin.GET("/orders", middleware.RequirePermission(models.PermissionOrdersRead), orderHandler.GetAllTransactions)
+				admin.GET("/orders/:id", middleware.RequirePermission(models.PermissionOrdersRead), adminHandler.GetOrder)
+				admin.PUT("/orders/:id/status", middleware.RequirePermission(models.PermissionOrdersManage), orderHandler.AdminUpdateOrderStatus)
+				admin.GET("/orders/:id/refundable", middleware.RequirePermission(models.PermissionOrdersRead), refundHandler.GetRefundable)
+				admin.POST("/orders/:id/refund", middleware.RequirePermission(models.PermissionOrdersRefund), refundHandler.RefundOrder)
+
+				// Admin refunds and returns
+				admin.GET("/refunds", middleware.RequirePermission(models.PermissionOrdersRead), refundHandler.GetRefunds)
+				admin.POST("/payments/refund", middleware.RequirePermission(models.PermissionOrdersRefund), paymentHandler.ProcessRefund)
+				admin.GET("/returns", middleware.RequirePermission(models.PermissionOrdersRead), refundHandler.GetReturns)
+				admin.POST("/returns/:id/approve", middleware.RequirePermission(models.PermissionReturnsManage), refundHandler.ApproveReturn)
+				admin.POST("/returns/:id/reject", middleware.RequirePermission(models.PermissionReturnsManage), refundHandler.RejectReturn)
+				admin.POST("/returns/:id/receive", middleware.RequirePermission(models.PermissionReturnsManage), refundHandler.ReceiveReturn)
+				admin.POST("/returns/:id/refund", middleware.RequirePermission(models.PermissionOrdersRefund), refundHandler.RetryReturnRefund)
+
+				// Admin dispute and escrow management
+				admin.GET("/disputes", middleware.RequirePermission(models.PermissionDisputesRead), disputeHandler.GetAllDisputes)
+				admin.PUT("/disputes/:id/resolve", middleware.RequirePermission(models.PermissionDisputesResolve), disputeHandler.ResolveDispute)
+				admin.GET("/escrows", middleware.RequirePermission(models.PermissionEscrowsRead), escrowHandler.GetEscrows)
+				admin.POST("/escrows/:id/release", middleware.RequirePermission(models.PermissionEscrowsManage), escrowHandler.ReleaseEscrow)
+				admin.POST("/escrows/:id/refund", middleware.RequirePermission(models.PermissionEscrowsManage), escrowHandler.RefundEscrow)
+
+				// Admin payment webhook management
+				admin.GET("/webhooks", middleware.RequirePermission(models.PermissionWebhooksManage), webhookHandler.GetWebhooks)
+				admin.POST("/webhooks/:id/retry", middleware.RequirePermission(models.PermissionWebhooksManage), webhookHandler.RetryWebhook)
+
+				// Admin withdrawal approval and payouts
+				admin.GET("/withdrawals", middleware.RequirePermission(models.PermissionPayoutsRead), withdrawalHandler.GetWithdrawals)
+				admin.POST("/withdrawals/process", middleware.RequirePermission(models.PermissionPayoutsManage), withdrawalHandler.ProcessWithdrawals)
+				admin.POST("/withdrawals/:id/approve", middleware.RequirePermission(models.PermissionPayoutsManage), withdrawalHandler.ApproveWithdrawal)
+				admin.POST("/withdrawals/:id/reject", middleware.RequirePermission(models.PermissionPayoutsManage), withdrawalHandler.RejectWithdrawal)
 
 				// Admin analytics
-				admin.GET("/analytics", adminHandler.GetSystemAnalytics)
-				admin.GET("/dashboard", adminHandler.GetAdminDashboard)
+				admin.GET("/analytics", middleware.RequirePermission(models.PermissionAnalyticsRead), adminHandler.GetSystemAnalytics)
+				admin.GET("/dashboard", middleware.RequirePermission(models.PermissionAnalyticsRead), adminHandler.GetAdminDashboard)
 
 				// System management endpoints
-				admin.POST("/system/init-database", systemHandler.InitializeDatabase)
-				admin.GET("/system/test-endpoints", systemHandler.TestEndpoints)
-				admin.GET("/system/status", systemHandler.GetSystemStatus)
+				admin.POST("/system/init-database", middleware.RequirePermission(models.PermissionSystemManage), systemHandler.InitializeDatabase)
+				admin.POST("/system/search/reindex", middleware.RequirePermission(models.PermissionSystemManage), searchHandler.RebuildIndex)
+				admin.GET("/search/report", middleware.RequirePermission(models.PermissionAnalyticsRead), searchHandler.GetSearchReport)
+				admin.GET("/system/test-endpoints", middleware.RequirePermission(models.PermissionSystemManage), systemHandler.TestEndpoints)
+				admin.GET("/system/status", middleware.RequirePermission(models.PermissionSystemManage), systemHandler.GetSystemStatus)
 			}
 
 			// Swap deals routes
@@ -367,7 +522,16 @@ func SetupRoutes(router *gin.Engine) {
 			{
 				wallet.GET("/balance", walletHandler.GetWalletBalance)
 				wallet.GET("/transactions", walletHandler.GetWalletTransactions)
-				wallet.POST("/withdraw", walletHandler.RequestWithdrawal)
+				wallet.POST("/topup", walletHandler.InitializeTopup)
+				wallet.GET("/topup/verify/:reference", walletHandler.VerifyTopup)
+				wallet.POST("/withdraw", middleware.RequireStepUp(), walletHandler.RequestWithdrawal)
+				wallet.GET("/withdrawals", walletHandler.GetWithdrawals)
+				wallet.GET("/withdrawals/limits", walletHandler.GetWithdrawalLimits)
+				wallet.POST("/withdrawals/:id/cancel", walletHandler.CancelWithdrawal)
+				wallet.GET("/bank-accounts", walletHandler.GetBankAccounts)
+				wallet.POST("/bank-accounts", middleware.RequireStepUp(), walletHandler.AddBankAccount)
+				wallet.PUT("/bank-accounts/:id/default", middleware.RequireStepUp(), walletHandler.SetDefaultBankAccount)
+				wallet.DELETE("/bank-accounts/:id", middleware.RequireStepUp(), walletHandler.DeleteBankAccount)
 			}
 
 			// User-specific routes
@@ -386,6 +550,7 @@ func SetupRoutes(router *gin.Engine) {
 			{
 				buyer.GET("/dashboard", buyerDashboardHandler.GetBuyerDashboard)
 				buyer.GET("/recent-activity", buyerDashboardHandler.GetBuyerRecentActivity)
+				buyer.GET("/recommendations", buyerDashboardHandler.GetBuyerRecommendations)
 			}
 
 			// Badges routes
@@ -426,11 +591,9 @@ func SetupRoutes(router *gin.Engine) {
 			// Payment routes
 			payment := protected.Group("/payment")
 			{
-				payment.POST("/initialize", paystackHandler.InitializePayment)
-				payment.GET("/verify/:reference", paystackHandler.VerifyPayment)
-				payment.POST("/refund", paystackHandler.ProcessRefund)
-				payment.POST("/dispute", paystackHandler.HandlePaymentDispute)
-				payment.POST("/webhook", paystackHandler.HandleWebhook)
+				payment.POST("/initialize", paymentHandler.InitializePayment)
+				payment.GET("/verify/:reference", paymentHandler.VerifyPayment)
+				payment.POST("/dispute", paymentHandler.HandlePaymentDispute)
 			}
 		}
 	}
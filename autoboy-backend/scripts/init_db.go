@@ -178,6 +178,7 @@ func createAdminUser(ctx context.Context) error {
 		Password:        string(hashedPassword),
 		Phone:           "+234800000000",
 		UserType:        models.UserTypeAdmin,
+		AdminRoles:      []string{models.AdminRoleSuperAdmin},
 		Status:          models.UserStatusActive,
 		IsEmailVerified: true,
 		IsPhoneVerified: true,
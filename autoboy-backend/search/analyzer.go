@@ -0,0 +1,161 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords are dropped from both documents and queries
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "the": true, "this": true,
+	"to": true, "with": true,
+}
+
+// Analyze splits text into lowercase, stemmed terms. Mixed letter and digit
+// tokens such as "iphone13" also yield their parts, so they match
+// "iphone 13".
+func Analyze(text string) []string {
+	var terms []string
+	for _, token := range tokenize(text) {
+		if stopWords[token] {
+			continue
+		}
+		terms = append(terms, stem(token))
+		for _, part := range splitAlnum(token) {
+			terms = append(terms, stem(part))
+		}
+	}
+	return terms
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// splitAlnum breaks a token at letter/digit boundaries, keeping parts of at
+// least two characters. Tokens that are all letters or all digits yield
+// nothing.
+func splitAlnum(token string) []string {
+	var parts []string
+	start := 0
+	runes := []rune(token)
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && unicode.IsDigit(runes[i]) == unicode.IsDigit(runes[i-1]) {
+			continue
+		}
+		if start == 0 && i == len(runes) {
+			return nil
+		}
+		if i-start >= 2 {
+			parts = append(parts, string(runes[start:i]))
+		}
+		start = i
+	}
+	return parts
+}
+
+// stem is a light English suffix stripper. It only has to map inflections of
+// a word to the same term; the result need not be a real word.
+func stem(term string) string {
+	if len(term) <= 3 || !isLetters(term) {
+		return term
+	}
+
+	switch {
+	case strings.HasSuffix(term, "ies") && len(term) > 4:
+		return term[:len(term)-3] + "y"
+	case strings.HasSuffix(term, "sses"):
+		return term[:len(term)-2]
+	case strings.HasSuffix(term, "ss"), strings.HasSuffix(term, "us"), strings.HasSuffix(term, "is"):
+		return term
+	case strings.HasSuffix(term, "ing") && len(term) > 5:
+		return undouble(term[:len(term)-3])
+	case strings.HasSuffix(term, "ed") && len(term) > 4:
+		return undouble(term[:len(term)-2])
+	case strings.HasSuffix(term, "ly") && len(term) > 4:
+		return term[:len(term)-2]
+	case strings.HasSuffix(term, "es") && len(term) > 4 && strings.ContainsAny(term[len(term)-3:len(term)-2], "sxz"):
+		return term[:len(term)-2]
+	case strings.HasSuffix(term, "s"):
+		return term[:len(term)-1]
+	}
+	return term
+}
+
+// undouble trims a doubled final consonant left by a suffix, e.g. "shipp"
+func undouble(term string) string {
+	n := len(term)
+	if n > 2 && term[n-1] == term[n-2] && !strings.ContainsRune("aeiouslz", rune(term[n-1])) {
+		return term[:n-1]
+	}
+	return term
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b, or
+// max+1 once it is certain to exceed max
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+// maxEdits is how many typos a query term of this length may contain
+func maxEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
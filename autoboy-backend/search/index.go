@@ -0,0 +1,770 @@
+// Package search is an embedded full-text index for product listings. It
+// keeps an inverted index in memory, persists its documents to a file on
+// local disk and supports typo-tolerant, weighted queries with facet counts.
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"autoboy-backend/geo"
+)
+
+// Field is a searchable document field
+type Field int
+
+const (
+	FieldTitle Field = iota
+	FieldBrand
+	FieldModel
+	FieldTags
+	FieldDescription
+	numFields
+)
+
+// fieldWeights ranks a match in the title above brand and model, then tags,
+// then description
+var fieldWeights = [numFields]float64{
+	FieldTitle:       4,
+	FieldBrand:       2.5,
+	FieldModel:       2.5,
+	FieldTags:        1.5,
+	FieldDescription: 1,
+}
+
+// Score multipliers for terms that only match approximately
+const (
+	prefixFactor   = 0.8
+	oneEditFactor  = 0.7
+	twoEditsFactor = 0.5
+	tfSaturation   = 1.2
+)
+
+// Relevance boost for listings near the searcher, so items that can be
+// picked up in the buyer's own city rank first among equal matches. The
+// boost halves roughly every proximityScaleKm.
+const (
+	proximityBoost   = 0.5
+	proximityScaleKm = 25.0
+)
+
+// Relevance multiplier per boost level of a bumped listing
+const boostWeight = 0.25
+
+// Document is the indexed form of a product
+type Document struct {
+	ID          string
+	Title       string
+	Brand       string
+	Model       string
+	Tags        []string
+	Description string
+	Category    string
+	Condition   string
+	City        string
+	State       string
+	Point       *geo.Point // nil when the listing has no known location
+	Price       float64
+	CreatedAt   time.Time
+	PublishedAt time.Time // when the listing went live
+	Popularity  float64   // time-decayed score from the ranking job
+	Boost       int       // level of the bump running on the listing, if any
+	// Filterable category attributes, keyed by lowercased attribute name
+	Attributes map[string][]string
+	Numbers    map[string]float64
+}
+
+// Query is a search request. Empty filter fields match everything and a
+// zero price bound is unset.
+type Query struct {
+	Text       string
+	Categories []string
+	Brands     []string
+	Conditions []string
+	Location   string
+	Near       *geo.Point
+	RadiusKm   float64 // only applies with Near; zero is unlimited
+	MinPrice   float64
+	MaxPrice   float64
+	// Attributes matches any of the listed values per attribute; Ranges
+	// bounds numeric attributes. Keys are attribute names in any case.
+	Attributes map[string][]string
+	Ranges     map[string]Range
+	// PublishedAfter keeps listings that went live after it; zero is unset
+	PublishedAfter time.Time
+	Sort           string // relevance, distance, popular, price_asc, price_desc or newest
+	Offset         int
+	Limit          int
+}
+
+// Hit is one matching document
+type Hit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+	// DistanceKm is set when the query has a Near point and the document a location
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
+
+// Range bounds a numeric attribute; a zero bound is unset
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// RangeFacet is the spread of a numeric attribute over the matches
+type RangeFacet struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// FacetCount is how many matches share a field value
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// Facets counts the matches of a query by field
+type Facets struct {
+	Categories []FacetCount `json:"categories"`
+	Brands     []FacetCount `json:"brands"`
+	Conditions []FacetCount `json:"conditions"`
+	Prices     []FacetCount `json:"prices"`
+	// Attributes counts values of filterable category attributes and
+	// Ranges spans their numeric ones, keyed by lowercased attribute name
+	Attributes map[string][]FacetCount `json:"attributes"`
+	Ranges     map[string]RangeFacet   `json:"ranges"`
+}
+
+// Result is one page of hits with facets over every match
+type Result struct {
+	Hits   []Hit  `json:"hits"`
+	Total  int    `json:"total"`
+	Facets Facets `json:"facets"`
+	// Relaxed is set when no document matched every term and the results
+	// match only some of them
+	Relaxed bool `json:"relaxed"`
+}
+
+type posting [numFields]uint16
+
+// Index is an in-memory inverted index backed by a file. It is safe for
+// concurrent use.
+type Index struct {
+	mu           sync.RWMutex
+	path         string
+	docs         map[string]*Document
+	terms        map[string]map[string]*posting
+	priceBuckets []float64
+	dirty        bool
+	// pending holds the changes made since StartRebuild, for Replace to
+	// apply over the rebuilt documents; a nil document is a deletion
+	pending map[string]*Document
+}
+
+// DefaultPriceBuckets are the upper bounds of the price facet ranges
+var DefaultPriceBuckets = []float64{10000, 50000, 100000, 250000, 500000, 1000000}
+
+// New returns an empty index that saves to path
+func New(path string) *Index {
+	return &Index{
+		path:         path,
+		docs:         make(map[string]*Document),
+		terms:        make(map[string]map[string]*posting),
+		priceBuckets: DefaultPriceBuckets,
+	}
+}
+
+// Open loads the index saved at path, or returns an empty one if there is
+// no file yet
+func Open(path string) (*Index, error) {
+	index := New(path)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var docs []Document
+	if err := gob.NewDecoder(file).Decode(&docs); err != nil {
+		return nil, fmt.Errorf("corrupt search index %s: %w", path, err)
+	}
+	for i := range docs {
+		index.add(&docs[i])
+	}
+	return index, nil
+}
+
+// SetPriceBuckets replaces the upper bounds of the price facet ranges
+func (ix *Index) SetPriceBuckets(bounds []float64) {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	ix.mu.Lock()
+	ix.priceBuckets = sorted
+	ix.mu.Unlock()
+}
+
+// Put adds or replaces a document
+func (ix *Index) Put(doc Document) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(doc.ID)
+	ix.add(&doc)
+	ix.dirty = true
+	if ix.pending != nil {
+		ix.pending[doc.ID] = &doc
+	}
+}
+
+// Delete removes a document if it is indexed
+func (ix *Index) Delete(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.docs[id]; ok {
+		ix.remove(id)
+		ix.dirty = true
+	}
+	if ix.pending != nil {
+		ix.pending[id] = nil
+	}
+}
+
+// StartRebuild marks the start of reading the documents for Replace. Puts
+// and Deletes from then on are kept by Replace, so changes that land while
+// the documents are being read are not lost.
+func (ix *Index) StartRebuild() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.pending == nil {
+		ix.pending = make(map[string]*Document)
+	}
+}
+
+// CancelRebuild stops keeping changes for a rebuild that failed
+func (ix *Index) CancelRebuild() {
+	ix.mu.Lock()
+	ix.pending = nil
+	ix.mu.Unlock()
+}
+
+// Replace swaps the whole index for the given documents, with any changes
+// made since StartRebuild applied on top
+func (ix *Index) Replace(docs []Document) {
+	fresh := New(ix.path)
+	for i := range docs {
+		fresh.add(&docs[i])
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for id, doc := range ix.pending {
+		fresh.remove(id)
+		if doc != nil {
+			fresh.add(doc)
+		}
+	}
+	ix.docs = fresh.docs
+	ix.terms = fresh.terms
+	ix.pending = nil
+	ix.dirty = true
+}
+
+// SetPopularity updates the popularity of indexed documents, keyed by ID.
+// Documents not in scores keep theirs.
+func (ix *Index) SetPopularity(scores map[string]float64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for id, score := range scores {
+		if doc, ok := ix.docs[id]; ok && doc.Popularity != score {
+			doc.Popularity = score
+			ix.dirty = true
+		}
+	}
+}
+
+// Len returns the number of indexed documents
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Save writes the documents to disk if anything changed since the last save
+func (ix *Index) Save() error {
+	ix.mu.Lock()
+	if !ix.dirty {
+		ix.mu.Unlock()
+		return nil
+	}
+	docs := make([]Document, 0, len(ix.docs))
+	for _, doc := range ix.docs {
+		docs = append(docs, *doc)
+	}
+	ix.dirty = false
+	ix.mu.Unlock()
+
+	err := ix.write(docs)
+	if err != nil {
+		ix.mu.Lock()
+		ix.dirty = true
+		ix.mu.Unlock()
+	}
+	return err
+}
+
+// write replaces the index file atomically
+func (ix *Index) write(docs []Document) error {
+	if err := os.MkdirAll(filepath.Dir(ix.path), 0o755); err != nil {
+		return err
+	}
+	tmp := ix.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(docs); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, ix.path)
+}
+
+// Get returns an indexed document
+func (ix *Index) Get(id string) (Document, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	doc, ok := ix.docs[id]
+	if !ok {
+		return Document{}, false
+	}
+	return *doc, true
+}
+
+// Search runs a query. Every query term must match, allowing for typos and,
+// on the last term, an unfinished word; when nothing matches every term the
+// documents matching the most terms are returned instead.
+func (ix *Index) Search(q Query) Result {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := uniqueTerms(Analyze(q.Text))
+	scores := make(map[string]float64)
+	relaxed := false
+
+	if len(terms) == 0 {
+		for id, doc := range ix.docs {
+			if ix.matchesFilters(doc, q) {
+				scores[id] = 0
+			}
+		}
+	} else {
+		matched := make(map[string]int)
+		for i, term := range terms {
+			for id, score := range ix.scoreTerm(term, i == len(terms)-1) {
+				if !ix.matchesFilters(ix.docs[id], q) {
+					continue
+				}
+				scores[id] += score
+				matched[id]++
+			}
+		}
+
+		best := 0
+		for _, n := range matched {
+			best = max(best, n)
+		}
+		if best < len(terms) {
+			relaxed = best > 0
+		}
+		for id, n := range matched {
+			if n < best {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hit := Hit{ID: id, Score: score}
+		if d, ok := distance(ix.docs[id], q); ok {
+			hit.DistanceKm = &d
+			hit.Score *= 1 + proximityBoost*math.Exp(-d/proximityScaleKm)
+		}
+		hit.Score *= 1 + boostWeight*float64(ix.docs[id].Boost)
+		hits = append(hits, hit)
+	}
+	sortOrder := q.Sort
+	if sortOrder == "" && q.Near != nil && len(terms) == 0 {
+		sortOrder = "distance"
+	}
+	ix.sortHits(hits, sortOrder)
+
+	result := Result{
+		Total:   len(hits),
+		Facets:  ix.facets(hits),
+		Relaxed: relaxed,
+	}
+	start := min(max(q.Offset, 0), len(hits))
+	end := len(hits)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, len(hits))
+	}
+	result.Hits = hits[start:end]
+	return result
+}
+
+// scoreTerm scores every document containing the term or a close variant
+// of it. A document is scored by its best matching variant only.
+func (ix *Index) scoreTerm(term string, isLast bool) map[string]float64 {
+	scores := make(map[string]float64)
+	total := float64(len(ix.docs))
+
+	for variant, factor := range ix.expand(term, isLast) {
+		postings := ix.terms[variant]
+		df := float64(len(postings))
+		idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+		for id, p := range postings {
+			var fieldScore float64
+			for field, tf := range p {
+				if tf > 0 {
+					fieldScore += fieldWeights[field] * float64(tf) / (float64(tf) + tfSaturation)
+				}
+			}
+			if score := factor * idf * fieldScore; score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+	return scores
+}
+
+// expand finds the indexed terms a query term may stand for: itself, terms
+// within its typo allowance and, for the last term, terms it is a prefix of
+func (ix *Index) expand(term string, allowPrefix bool) map[string]float64 {
+	variants := make(map[string]float64)
+	if _, ok := ix.terms[term]; ok {
+		variants[term] = 1
+	}
+
+	edits := maxEdits(term)
+	prefix := allowPrefix && len([]rune(term)) >= 2
+	if edits == 0 && !prefix {
+		return variants
+	}
+
+	for candidate := range ix.terms {
+		if candidate == term {
+			continue
+		}
+		factor := 0.0
+		if prefix && strings.HasPrefix(candidate, term) {
+			factor = prefixFactor
+		}
+		if edits > 0 {
+			switch d := editDistance(term, candidate, edits); {
+			case d == 1:
+				factor = math.Max(factor, oneEditFactor)
+			case d == 2 && edits >= 2:
+				factor = math.Max(factor, twoEditsFactor)
+			}
+		}
+		if factor > 0 {
+			variants[candidate] = factor
+		}
+	}
+	return variants
+}
+
+func (ix *Index) matchesFilters(doc *Document, q Query) bool {
+	if len(q.Categories) > 0 && !containsFold(q.Categories, doc.Category) {
+		return false
+	}
+	if len(q.Brands) > 0 && !containsFold(q.Brands, doc.Brand) {
+		return false
+	}
+	if len(q.Conditions) > 0 && !containsFold(q.Conditions, doc.Condition) {
+		return false
+	}
+	if q.Location != "" {
+		location := strings.ToLower(q.Location)
+		if !strings.Contains(strings.ToLower(doc.City), location) && !strings.Contains(strings.ToLower(doc.State), location) {
+			return false
+		}
+	}
+	if q.Near != nil && q.RadiusKm > 0 {
+		if d, ok := distance(doc, q); !ok || d > q.RadiusKm {
+			return false
+		}
+	}
+	if !q.PublishedAfter.IsZero() && !doc.PublishedAt.After(q.PublishedAfter) {
+		return false
+	}
+	if q.MinPrice > 0 && doc.Price < q.MinPrice {
+		return false
+	}
+	if q.MaxPrice > 0 && doc.Price > q.MaxPrice {
+		return false
+	}
+	for name, values := range q.Attributes {
+		if len(values) > 0 && !containsAny(values, doc.Attributes[strings.ToLower(name)]) {
+			return false
+		}
+	}
+	for name, r := range q.Ranges {
+		n, ok := doc.Numbers[strings.ToLower(name)]
+		if !ok && (r.Min != 0 || r.Max != 0) {
+			return false
+		}
+		if (r.Min != 0 && n < r.Min) || (r.Max != 0 && n > r.Max) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ix *Index) sortHits(hits []Hit, order string) {
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := ix.docs[hits[i].ID], ix.docs[hits[j].ID]
+		switch order {
+		case "distance":
+			if (hits[i].DistanceKm == nil) != (hits[j].DistanceKm == nil) {
+				return hits[i].DistanceKm != nil
+			}
+			if hits[i].DistanceKm != nil && *hits[i].DistanceKm != *hits[j].DistanceKm {
+				return *hits[i].DistanceKm < *hits[j].DistanceKm
+			}
+		case "price_asc":
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+		case "price_desc":
+			if a.Price != b.Price {
+				return a.Price > b.Price
+			}
+		case "popular":
+			if a.Popularity != b.Popularity {
+				return a.Popularity > b.Popularity
+			}
+		case "newest":
+		default:
+			if hits[i].Score != hits[j].Score {
+				return hits[i].Score > hits[j].Score
+			}
+			// Browsing without a query scores everything alike
+			if a.Boost != b.Boost {
+				return a.Boost > b.Boost
+			}
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// distance returns how far a document lies from the query's Near point
+func distance(doc *Document, q Query) (float64, bool) {
+	if q.Near == nil || doc.Point == nil {
+		return 0, false
+	}
+	return geo.DistanceKm(*q.Near, *doc.Point), true
+}
+
+// facets counts every hit by category, brand, condition, price range and
+// category attribute
+func (ix *Index) facets(hits []Hit) Facets {
+	categories := make(map[string]int)
+	brands := make(map[string]int)
+	brandNames := make(map[string]string)
+	conditions := make(map[string]int)
+	prices := make([]int, len(ix.priceBuckets)+1)
+	attributes := make(map[string]map[string]int)
+	attributeNames := make(map[string]map[string]string)
+	ranges := make(map[string]RangeFacet)
+
+	for _, hit := range hits {
+		doc := ix.docs[hit.ID]
+		if doc.Category != "" {
+			categories[doc.Category]++
+		}
+		if brand := strings.TrimSpace(doc.Brand); brand != "" {
+			key := strings.ToLower(brand)
+			brands[key]++
+			if _, ok := brandNames[key]; !ok {
+				brandNames[key] = brand
+			}
+		}
+		if doc.Condition != "" {
+			conditions[doc.Condition]++
+		}
+		prices[sort.SearchFloat64s(ix.priceBuckets, doc.Price)]++
+
+		for name, values := range doc.Attributes {
+			if attributes[name] == nil {
+				attributes[name] = make(map[string]int)
+				attributeNames[name] = make(map[string]string)
+			}
+			for _, value := range values {
+				key := strings.ToLower(value)
+				attributes[name][key]++
+				if _, ok := attributeNames[name][key]; !ok {
+					attributeNames[name][key] = value
+				}
+			}
+		}
+		for name, n := range doc.Numbers {
+			r, ok := ranges[name]
+			if !ok {
+				r = RangeFacet{Min: n, Max: n}
+			}
+			r.Min, r.Max = min(r.Min, n), max(r.Max, n)
+			r.Count++
+			ranges[name] = r
+		}
+	}
+
+	facets := Facets{
+		Categories: sortedCounts(categories, nil),
+		Brands:     sortedCounts(brands, brandNames),
+		Conditions: sortedCounts(conditions, nil),
+		Prices:     []FacetCount{},
+		Attributes: make(map[string][]FacetCount, len(attributes)),
+		Ranges:     ranges,
+	}
+	for name, counts := range attributes {
+		facets.Attributes[name] = sortedCounts(counts, attributeNames[name])
+	}
+	for i, count := range prices {
+		if count == 0 {
+			continue
+		}
+		facets.Prices = append(facets.Prices, FacetCount{
+			Value: ix.priceRange(i),
+			Count: count,
+		})
+	}
+	return facets
+}
+
+// priceRange names a price bucket as "min-max", with an open upper end for
+// the last bucket
+func (ix *Index) priceRange(bucket int) string {
+	lower := 0.0
+	if bucket > 0 {
+		lower = ix.priceBuckets[bucket-1]
+	}
+	if bucket == len(ix.priceBuckets) {
+		return fmt.Sprintf("%.0f-", lower)
+	}
+	return fmt.Sprintf("%.0f-%.0f", lower, ix.priceBuckets[bucket])
+}
+
+func (ix *Index) add(doc *Document) {
+	ix.docs[doc.ID] = doc
+	fields := [numFields]string{
+		FieldTitle:       doc.Title,
+		FieldBrand:       doc.Brand,
+		FieldModel:       doc.Model,
+		FieldTags:        strings.Join(doc.Tags, " "),
+		FieldDescription: doc.Description,
+	}
+	for field, text := range fields {
+		for _, term := range Analyze(text) {
+			postings, ok := ix.terms[term]
+			if !ok {
+				postings = make(map[string]*posting)
+				ix.terms[term] = postings
+			}
+			p, ok := postings[doc.ID]
+			if !ok {
+				p = &posting{}
+				postings[doc.ID] = p
+			}
+			if p[field] < math.MaxUint16 {
+				p[field]++
+			}
+		}
+	}
+}
+
+func (ix *Index) remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	text := strings.Join([]string{doc.Title, doc.Brand, doc.Model, strings.Join(doc.Tags, " "), doc.Description}, " ")
+	for _, term := range Analyze(text) {
+		if postings, ok := ix.terms[term]; ok {
+			delete(postings, id)
+			if len(postings) == 0 {
+				delete(ix.terms, term)
+			}
+		}
+	}
+	delete(ix.docs, id)
+}
+
+func sortedCounts(counts map[string]int, labels map[string]string) []FacetCount {
+	facets := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facet := FacetCount{Value: value, Count: count}
+		if labels != nil {
+			facet.Value = labels[value]
+		}
+		facets = append(facets, facet)
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	return facets
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+func containsAny(wanted, values []string) bool {
+	for _, value := range values {
+		if containsFold(wanted, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/middleware"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAdminRoleNotFound    = errors.New("admin role not found")
+	ErrAdminRoleExists      = errors.New("an admin role with this name already exists")
+	ErrAdminRoleInvalidName = errors.New("role names are 3-40 lowercase letters, digits and underscores, starting with a letter")
+	ErrAdminRoleProtected   = errors.New("the super admin role cannot be changed or deleted")
+	ErrAdminRoleBuiltIn     = errors.New("built-in roles cannot be deleted")
+	ErrAdminRoleInUse       = errors.New("role is still assigned to staff")
+	ErrUnknownPermission    = errors.New("unknown permission")
+	ErrPermissionEscalation = errors.New("you can only grant or revoke permissions you hold yourself")
+	ErrLastSuperAdmin       = errors.New("at least one active super admin must remain")
+	ErrNotAdminUser         = errors.New("roles can only be assigned to admin accounts")
+)
+
+var adminRoleName = regexp.MustCompile(`^[a-z][a-z0-9_]{2,39}$`)
+
+// AdminRoleService manages staff roles, which grant the permissions admin
+// routes check, and reads the admin action log
+type AdminRoleService struct{}
+
+// AdminRoleInput is a role as created or edited by a super admin
+type AdminRoleInput struct {
+	Name        string
+	DisplayName string
+	Description string
+	Permissions []string
+}
+
+// AdminLogFilter narrows the admin action log
+type AdminLogFilter struct {
+	AdminID      primitive.ObjectID
+	TargetUserID primitive.ObjectID
+	Action       string
+	Since        time.Time
+}
+
+// NewAdminRoleService creates a new admin role service
+func NewAdminRoleService() *AdminRoleService {
+	return &AdminRoleService{}
+}
+
+// EnsureDefaultRoles creates any missing built-in role. The first time the
+// super admin role is created, admins from before roles existed are made
+// super admins so nobody loses access; admins added later start with none.
+func (s *AdminRoleService) EnsureDefaultRoles(ctx context.Context) error {
+	now := time.Now()
+	for _, role := range models.DefaultAdminRoles {
+		result, err := config.Coll.AdminRoles.UpdateOne(ctx,
+			bson.M{"name": role.Name},
+			bson.M{
+				"$setOnInsert": bson.M{
+					"display_name": role.DisplayName,
+					"description":  role.Description,
+					"permissions":  role.Permissions,
+					"system":       true,
+					"created_at":   now,
+					"updated_at":   now,
+				},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+		if result.UpsertedCount == 0 || role.Name != models.AdminRoleSuperAdmin {
+			continue
+		}
+
+		migrated, err := config.Coll.Users.UpdateMany(ctx,
+			bson.M{"user_type": models.UserTypeAdmin, "admin_roles": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"admin_roles": []string{models.AdminRoleSuperAdmin}, "updated_at": now}},
+		)
+		if err != nil {
+			return err
+		}
+		if migrated.ModifiedCount > 0 {
+			log.Printf("Granted the super admin role to %d existing admins", migrated.ModifiedCount)
+		}
+	}
+
+	middleware.InvalidateAdminRoles()
+	return nil
+}
+
+// Roles lists every staff role
+func (s *AdminRoleService) Roles(ctx context.Context) ([]models.AdminRole, error) {
+	cursor, err := config.Coll.AdminRoles.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	roles := []models.AdminRole{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// CreateRole adds a custom staff role
+func (s *AdminRoleService) CreateRole(ctx context.Context, actor *models.User, input AdminRoleInput) (*models.AdminRole, error) {
+	if !adminRoleName.MatchString(input.Name) {
+		return nil, ErrAdminRoleInvalidName
+	}
+	permissions, err := s.checkGrant(ctx, actor, input.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	role := &models.AdminRole{
+		ID:          primitive.NewObjectID(),
+		Name:        input.Name,
+		DisplayName: strings.TrimSpace(input.DisplayName),
+		Description: strings.TrimSpace(input.Description),
+		Permissions: permissions,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if role.DisplayName == "" {
+		role.DisplayName = role.Name
+	}
+
+	if _, err := config.Coll.AdminRoles.InsertOne(ctx, role); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAdminRoleExists
+		}
+		return nil, err
+	}
+	middleware.InvalidateAdminRoles()
+	return role, nil
+}
+
+// UpdateRole replaces a role's permissions and description, returning the
+// role as it was before and after
+func (s *AdminRoleService) UpdateRole(ctx context.Context, actor *models.User, name string, input AdminRoleInput) (*models.AdminRole, *models.AdminRole, error) {
+	if name == models.AdminRoleSuperAdmin {
+		return nil, nil, ErrAdminRoleProtected
+	}
+	before, err := s.role(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Revoking a permission is as sensitive as granting it
+	if _, err := s.checkGrant(ctx, actor, before.Permissions); err != nil {
+		return nil, nil, err
+	}
+	permissions, err := s.checkGrant(ctx, actor, input.Permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := bson.M{"permissions": permissions, "updated_at": time.Now()}
+	if displayName := strings.TrimSpace(input.DisplayName); displayName != "" {
+		set["display_name"] = displayName
+	}
+	if description := strings.TrimSpace(input.Description); description != "" {
+		set["description"] = description
+	}
+
+	var after models.AdminRole
+	err = config.Coll.AdminRoles.FindOneAndUpdate(ctx, bson.M{"name": name}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrAdminRoleNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	middleware.InvalidateAdminRoles()
+	return before, &after, nil
+}
+
+// DeleteRole removes a custom role nobody holds any more
+func (s *AdminRoleService) DeleteRole(ctx context.Context, name string) (*models.AdminRole, error) {
+	if name == models.AdminRoleSuperAdmin {
+		return nil, ErrAdminRoleProtected
+	}
+	role, err := s.role(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if role.System {
+		return nil, ErrAdminRoleBuiltIn
+	}
+
+	holders, err := config.Coll.Users.CountDocuments(ctx, bson.M{"admin_roles": name})
+	if err != nil {
+		return nil, err
+	}
+	if holders > 0 {
+		return nil, ErrAdminRoleInUse
+	}
+
+	if _, err := config.Coll.AdminRoles.DeleteOne(ctx, bson.M{"name": name}); err != nil {
+		return nil, err
+	}
+	middleware.InvalidateAdminRoles()
+	return role, nil
+}
+
+// AssignRoles sets an admin's roles, returning the roles they held before.
+// The actor must hold every permission the roles added or removed grant.
+func (s *AdminRoleService) AssignRoles(ctx context.Context, actor *models.User, userID primitive.ObjectID, roleNames []string) ([]string, error) {
+	var user models.User
+	if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if user.UserType != models.UserTypeAdmin {
+		return nil, ErrNotAdminUser
+	}
+
+	roles, err := s.Roles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.AdminRole, len(roles))
+	for _, role := range roles {
+		byName[role.Name] = role
+	}
+
+	assigned := uniqueSorted(roleNames)
+	var changed []string
+	for _, name := range assigned {
+		if _, ok := byName[name]; !ok {
+			return nil, ErrAdminRoleNotFound
+		}
+		if !utils.Contains(user.AdminRoles, name) {
+			changed = append(changed, byName[name].Permissions...)
+		}
+	}
+	for _, name := range user.AdminRoles {
+		if !utils.Contains(assigned, name) {
+			changed = append(changed, byName[name].Permissions...)
+		}
+	}
+	if _, err := s.checkGrant(ctx, actor, changed); err != nil {
+		return nil, err
+	}
+
+	if utils.Contains(user.AdminRoles, models.AdminRoleSuperAdmin) && !utils.Contains(assigned, models.AdminRoleSuperAdmin) {
+		others, err := config.Coll.Users.CountDocuments(ctx, bson.M{
+			"_id":         bson.M{"$ne": userID},
+			"user_type":   models.UserTypeAdmin,
+			"status":      models.UserStatusActive,
+			"admin_roles": models.AdminRoleSuperAdmin,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if others == 0 {
+			return nil, ErrLastSuperAdmin
+		}
+	}
+
+	_, err = config.Coll.Users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"admin_roles": assigned, "updated_at": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.AdminRoles, nil
+}
+
+// Staff lists admin accounts and the roles they hold
+func (s *AdminRoleService) Staff(ctx context.Context) ([]models.User, error) {
+	cursor, err := config.Coll.Users.Find(ctx,
+		bson.M{"user_type": models.UserTypeAdmin},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	staff := []models.User{}
+	if err := cursor.All(ctx, &staff); err != nil {
+		return nil, err
+	}
+	return staff, nil
+}
+
+// Logs pages through the admin action log, newest first
+func (s *AdminRoleService) Logs(ctx context.Context, filter AdminLogFilter, page, limit int) ([]models.AdminLog, int64, error) {
+	query := bson.M{}
+	if !filter.AdminID.IsZero() {
+		query["admin_id"] = filter.AdminID
+	}
+	if !filter.TargetUserID.IsZero() {
+		query["target_user_id"] = filter.TargetUserID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if !filter.Since.IsZero() {
+		query["created_at"] = bson.M{"$gte": filter.Since}
+	}
+
+	cursor, err := config.Coll.AdminLogs.Find(ctx, query,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	logs := []models.AdminLog{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+	total, err := config.Coll.AdminLogs.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+func (s *AdminRoleService) role(ctx context.Context, name string) (*models.AdminRole, error) {
+	var role models.AdminRole
+	err := config.Coll.AdminRoles.FindOne(ctx, bson.M{"name": name}).Decode(&role)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAdminRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// checkGrant validates permissions and makes sure the actor holds each of
+// them, so nobody can hand out more access than they have
+func (s *AdminRoleService) checkGrant(ctx context.Context, actor *models.User, permissions []string) ([]string, error) {
+	granted, err := middleware.AdminPermissions(ctx, actor)
+	if err != nil {
+		return nil, err
+	}
+	permissions = uniqueSorted(permissions)
+	for _, permission := range permissions {
+		if !models.IsAdminPermission(permission) {
+			return nil, ErrUnknownPermission
+		}
+		if !granted[models.PermissionAll] && !granted[permission] {
+			return nil, ErrPermissionEscalation
+		}
+	}
+	return permissions, nil
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
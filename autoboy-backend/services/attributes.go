@@ -0,0 +1,315 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"autoboy-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxTextAttributeLength = 200
+
+var ErrInvalidAttributeSchema = errors.New("invalid category attribute schema")
+
+// DefaultCategoryAttributes returns the attribute schema a seeded category
+// starts with, keyed by its slug
+func DefaultCategoryAttributes(slug string) []models.CategoryAttribute {
+	zero, hundred := 0.0, 100.0
+	memory := []string{"2GB", "3GB", "4GB", "6GB", "8GB", "12GB", "16GB", "32GB", "64GB"}
+
+	switch slug {
+	case "phones-tablets":
+		return []models.CategoryAttribute{
+			{Name: "storage", Type: models.AttributeTypeSelect, Options: []string{"16GB", "32GB", "64GB", "128GB", "256GB", "512GB", "1TB"}, IsRequired: true, IsFilterable: true, SortOrder: 1},
+			{Name: "ram", Type: models.AttributeTypeSelect, Options: memory, IsFilterable: true, SortOrder: 2},
+			{Name: "battery_health", Type: models.AttributeTypeRange, Unit: "%", Min: &zero, Max: &hundred, IsFilterable: true, SortOrder: 3},
+			{Name: "network", Type: models.AttributeTypeMultiSelect, Options: []string{"3G", "4G", "5G"}, IsFilterable: true, SortOrder: 4},
+			{Name: "dual_sim", Type: models.AttributeTypeBoolean, IsFilterable: true, SortOrder: 5},
+		}
+	case "laptops-computers":
+		return []models.CategoryAttribute{
+			{Name: "ram", Type: models.AttributeTypeSelect, Options: memory, IsFilterable: true, SortOrder: 1},
+			{Name: "processor", Type: models.AttributeTypeText, IsFilterable: true, SortOrder: 2},
+			{Name: "screen_size", Type: models.AttributeTypeNumber, Unit: "inches", IsFilterable: true, SortOrder: 3},
+		}
+	}
+	return nil
+}
+
+// ValidateAttributeSchema checks a category's attribute definitions and
+// returns them tidied and in display order. Names must be unique regardless
+// of case, since listings may send them in any case.
+func ValidateAttributeSchema(attributes []models.CategoryAttribute) ([]models.CategoryAttribute, error) {
+	seen := make(map[string]bool, len(attributes))
+	cleaned := make([]models.CategoryAttribute, 0, len(attributes))
+	for _, attr := range attributes {
+		attr.Name = strings.TrimSpace(attr.Name)
+		if attr.Name == "" {
+			return nil, fmt.Errorf("%w: attribute name is required", ErrInvalidAttributeSchema)
+		}
+		key := strings.ToLower(attr.Name)
+		if seen[key] {
+			return nil, fmt.Errorf("%w: duplicate attribute %q", ErrInvalidAttributeSchema, attr.Name)
+		}
+		seen[key] = true
+
+		switch attr.Type {
+		case models.AttributeTypeSelect, models.AttributeTypeMultiSelect:
+			options := make([]string, 0, len(attr.Options))
+			for _, option := range attr.Options {
+				if option = strings.TrimSpace(option); option != "" && !containsFold(options, option) {
+					options = append(options, option)
+				}
+			}
+			if len(options) == 0 {
+				return nil, fmt.Errorf("%w: %s needs at least one option", ErrInvalidAttributeSchema, attr.Name)
+			}
+			attr.Options = options
+			attr.Min, attr.Max = nil, nil
+		case models.AttributeTypeNumber, models.AttributeTypeRange:
+			if attr.Min != nil && attr.Max != nil && *attr.Min > *attr.Max {
+				return nil, fmt.Errorf("%w: %s has min above max", ErrInvalidAttributeSchema, attr.Name)
+			}
+			attr.Options = nil
+		case models.AttributeTypeText, models.AttributeTypeBoolean:
+			attr.Options = nil
+			attr.Min, attr.Max = nil, nil
+		default:
+			return nil, fmt.Errorf("%w: %s has unknown type %q", ErrInvalidAttributeSchema, attr.Name, attr.Type)
+		}
+		cleaned = append(cleaned, attr)
+	}
+
+	sort.SliceStable(cleaned, func(i, j int) bool {
+		return cleaned[i].SortOrder < cleaned[j].SortOrder
+	})
+	return cleaned, nil
+}
+
+// ValidateSpecifications checks a listing's specifications against its
+// category's attributes. Keys are matched to attribute names regardless of
+// case and stored under the attribute name; values are converted to the
+// attribute's type (numbers, booleans, canonical option spellings). Keys
+// with no matching attribute are kept as free-form details. It returns the
+// cleaned specifications, or a message per failing attribute.
+func ValidateSpecifications(attributes []models.CategoryAttribute, specs map[string]interface{}) (map[string]interface{}, map[string]string) {
+	cleaned := make(map[string]interface{}, len(specs))
+	problems := make(map[string]string)
+
+	byName := make(map[string]models.CategoryAttribute, len(attributes))
+	for _, attr := range attributes {
+		byName[strings.ToLower(attr.Name)] = attr
+	}
+
+	provided := make(map[string]interface{}, len(specs))
+	for key, value := range specs {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if attr, ok := byName[strings.ToLower(key)]; ok {
+			provided[attr.Name] = value
+		} else {
+			cleaned[key] = value
+		}
+	}
+
+	for _, attr := range attributes {
+		raw, ok := provided[attr.Name]
+		if !ok || isBlank(raw) {
+			if attr.IsRequired {
+				problems[attr.Name] = "is required"
+			}
+			continue
+		}
+		value, err := attributeValue(attr, raw)
+		if err != nil {
+			problems[attr.Name] = err.Error()
+			continue
+		}
+		cleaned[attr.Name] = value
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cleaned, nil
+}
+
+// attributeValue converts a submitted value to the attribute's type
+func attributeValue(attr models.CategoryAttribute, raw interface{}) (interface{}, error) {
+	switch attr.Type {
+	case models.AttributeTypeNumber, models.AttributeTypeRange:
+		n, ok := toNumber(raw)
+		if !ok {
+			return nil, errors.New("must be a number")
+		}
+		if attr.Min != nil && n < *attr.Min {
+			return nil, fmt.Errorf("must be at least %s", formatNumber(*attr.Min))
+		}
+		if attr.Max != nil && n > *attr.Max {
+			return nil, fmt.Errorf("must be at most %s", formatNumber(*attr.Max))
+		}
+		return n, nil
+
+	case models.AttributeTypeBoolean:
+		switch v := raw.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "1":
+				return true, nil
+			case "false", "no", "0":
+				return false, nil
+			}
+		}
+		return nil, errors.New("must be true or false")
+
+	case models.AttributeTypeSelect:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, errors.New("must be one of the listed options")
+		}
+		option, ok := matchOption(attr.Options, s)
+		if !ok {
+			return nil, fmt.Errorf("must be one of: %s", strings.Join(attr.Options, ", "))
+		}
+		return option, nil
+
+	case models.AttributeTypeMultiSelect:
+		values := AttributeStrings(raw)
+		if len(values) == 0 {
+			return nil, errors.New("must be a list of the listed options")
+		}
+		options := make([]string, 0, len(values))
+		for _, v := range values {
+			option, ok := matchOption(attr.Options, v)
+			if !ok {
+				return nil, fmt.Errorf("%q is not one of: %s", v, strings.Join(attr.Options, ", "))
+			}
+			if !containsFold(options, option) {
+				options = append(options, option)
+			}
+		}
+		return options, nil
+
+	default:
+		var s string
+		switch v := raw.(type) {
+		case string:
+			s = strings.TrimSpace(v)
+		case float64, int, int32, int64, bool:
+			s = fmt.Sprint(v)
+		default:
+			return nil, errors.New("must be text")
+		}
+		if len(s) > maxTextAttributeLength {
+			return nil, fmt.Errorf("must be at most %d characters", maxTextAttributeLength)
+		}
+		return s, nil
+	}
+}
+
+// AttributeStrings reads a stored or submitted specification value as a
+// list of strings. Lists come back from Mongo as primitive.A; a comma
+// separated string is split.
+func AttributeStrings(value interface{}) []string {
+	var items []interface{}
+	switch v := value.(type) {
+	case []string:
+		for _, s := range v {
+			items = append(items, s)
+		}
+	case []interface{}:
+		items = v
+	case primitive.A:
+		items = v
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			items = append(items, s)
+		}
+	case nil:
+		return nil
+	default:
+		items = []interface{}{v}
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		var s string
+		switch v := item.(type) {
+		case string:
+			s = strings.TrimSpace(v)
+		case float64:
+			s = formatNumber(v)
+		default:
+			s = strings.TrimSpace(fmt.Sprint(v))
+		}
+		if s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		n, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(v), ",", ""), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func matchOption(options []string, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	for _, option := range options {
+		if strings.EqualFold(option, value) {
+			return option, true
+		}
+	}
+	return "", false
+}
+
+func isBlank(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
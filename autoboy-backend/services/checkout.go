@@ -0,0 +1,690 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCheckoutEmpty      = errors.New("checkout has no items")
+	ErrCheckoutNotFound   = errors.New("checkout not found")
+	ErrProductUnavailable = errors.New("product not found or inactive")
+	ErrInsufficientStock  = errors.New("insufficient product quantity")
+	ErrMixedCurrency      = errors.New("items priced in different currencies cannot be bought together")
+)
+
+// CheckoutItem is one product line of a checkout
+type CheckoutItem struct {
+	ProductID primitive.ObjectID
+	Quantity  int
+}
+
+// CheckoutRequest describes a purchase that may span several sellers
+type CheckoutRequest struct {
+	BuyerID         primitive.ObjectID
+	Items           []CheckoutItem
+	ShippingAddress models.Address
+	PaymentMethod   string
+	PromoCode       string
+}
+
+// CheckoutService turns a cart into one order per seller under a single
+// checkout, so one payment can be split between the sellers while each order
+// keeps its own shipping and escrow.
+type CheckoutService struct {
+	wallet             *WalletService
+	escrow             *EscrowService
+	inventory          *InventoryService
+	orders             *OrderService
+	refunds            *RefundService
+	promos             *PromoService
+	escrowGrace        time.Duration
+	sameStateShipping  float64
+	interstateShipping float64
+	freeShippingOver   float64
+}
+
+func NewCheckoutService(wallet *WalletService, escrow *EscrowService, inventory *InventoryService, orders *OrderService, refunds *RefundService, promos *PromoService) *CheckoutService {
+	return &CheckoutService{
+		wallet:             wallet,
+		escrow:             escrow,
+		inventory:          inventory,
+		orders:             orders,
+		refunds:            refunds,
+		promos:             promos,
+		escrowGrace:        time.Duration(utils.GetEnvAsInt("ESCROW_PENDING_GRACE_MINUTES", 5)) * time.Minute,
+		sameStateShipping:  utils.GetEnvAsFloat("SHIPPING_FEE_SAME_STATE", 1500),
+		interstateShipping: utils.GetEnvAsFloat("SHIPPING_FEE_INTERSTATE", 3500),
+		freeShippingOver:   utils.GetEnvAsFloat("SHIPPING_FREE_OVER", 0),
+	}
+}
+
+// CartItems returns the buyer's cart as checkout items, leaving out items
+// saved for later
+func (s *CheckoutService) CartItems(ctx context.Context, buyerID primitive.ObjectID) ([]CheckoutItem, error) {
+	cursor, err := config.Coll.CartItems.Find(ctx, bson.M{
+		"user_id":         buyerID,
+		"saved_for_later": bson.M{"$ne": true},
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var cartItems []struct {
+		ProductID primitive.ObjectID `bson:"product_id"`
+		Quantity  int                `bson:"quantity"`
+	}
+	if err := cursor.All(ctx, &cartItems); err != nil {
+		return nil, err
+	}
+
+	items := make([]CheckoutItem, 0, len(cartItems))
+	for _, item := range cartItems {
+		items = append(items, CheckoutItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return items, nil
+}
+
+// Create splits the items into one order per seller, each priced with its
+// own shipping, and records them under a single checkout. The stock is
+// reserved in the same transaction as the orders. Wallet checkouts are paid
+// straight away and every order's escrow is opened with the payment; other
+// payment methods
+// hold the stock until the checkout is paid or its reservation expires.
+func (s *CheckoutService) Create(ctx context.Context, req CheckoutRequest) (*models.Checkout, []*models.Order, error) {
+	checkout, orders, err := s.build(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.PaymentMethod == string(models.PaymentMethodWallet) {
+		var payment *models.Payment
+		err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+			if err := s.inventory.Reserve(sc, orders, true); err != nil {
+				return err
+			}
+			var err error
+			if payment, err = s.wallet.PayCheckout(sc, checkout, orders); err != nil {
+				return err
+			}
+			// The buyer gets the checkout confirmation email instead of a
+			// status update, so these transitions are not notified. The
+			// escrow opens with the payment, so a paid order always has one.
+			for _, order := range orders {
+				if err := s.orders.Record(sc, order, OrderTransition{
+					OrderID: order.ID,
+					To:      models.OrderStatusConfirmed,
+					Actor:   OrderActorSystem,
+					Note:    "Paid from wallet",
+				}); err != nil {
+					return err
+				}
+				if _, err := s.escrow.Open(sc, order, payment.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, ErrInsufficientBalance) || errors.Is(err, ErrInsufficientStock) {
+			return nil, nil, err
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("wallet checkout failed: %w", err)
+		}
+		s.inventory.Reindex(ctx, orderProductIDs(orders))
+		return checkout, orders, nil
+	}
+
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if _, err := config.Coll.Checkouts.InsertOne(sc, checkout); err != nil {
+			return err
+		}
+		for _, order := range orders {
+			if _, err := config.Coll.Orders.InsertOne(sc, order); err != nil {
+				return err
+			}
+		}
+		return s.inventory.Reserve(sc, orders, false)
+	})
+	if errors.Is(err, ErrInsufficientStock) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create checkout: %w", err)
+	}
+	s.inventory.Reindex(ctx, orderProductIDs(orders))
+	return checkout, orders, nil
+}
+
+// Get loads a buyer's checkout together with its orders
+func (s *CheckoutService) Get(ctx context.Context, checkoutID, buyerID primitive.ObjectID) (*models.Checkout, error) {
+	var checkout models.Checkout
+	err := config.Coll.Checkouts.FindOne(ctx, bson.M{"_id": checkoutID, "buyer_id": buyerID}).Decode(&checkout)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCheckoutNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := config.Coll.Orders.Find(ctx, bson.M{"checkout_id": checkout.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	if err := cursor.All(ctx, &checkout.Orders); err != nil {
+		return nil, err
+	}
+	return &checkout, nil
+}
+
+// MarkPaid settles a confirmed checkout payment: the checkout and all of its
+// orders are marked paid, their stock reservations are committed and each
+// order's allocation is placed in its own escrow. Orders cancelled before the
+// payment landed, or whose stock was sold while the payment was late, get no
+// escrow; their share is refunded to the buyer. Safe to call again for the
+// same payment.
+func (s *CheckoutService) MarkPaid(ctx context.Context, payment *models.Payment) ([]*models.Escrow, error) {
+	if payment.CheckoutID == nil {
+		return nil, fmt.Errorf("payment %s has no checkout", payment.PaymentNumber)
+	}
+
+	var checkout models.Checkout
+	err := config.Coll.Checkouts.FindOne(ctx, bson.M{"_id": *payment.CheckoutID}).Decode(&checkout)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCheckoutNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var allocated float64
+	for _, allocation := range checkout.Allocations {
+		allocated += allocation.Amount
+	}
+	if utils.RoundCurrency(allocated) != utils.RoundCurrency(payment.Amount) {
+		return nil, fmt.Errorf("checkout %s allocates %.2f but payment is %.2f", checkout.CheckoutNumber, allocated, payment.Amount)
+	}
+
+	now := time.Now()
+	var confirmed []*models.Order
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		confirmed = nil
+		if _, err := config.Coll.Checkouts.UpdateOne(sc, bson.M{
+			"_id":            checkout.ID,
+			"payment_status": bson.M{"$ne": models.PaymentStatusPaid},
+		}, bson.M{"$set": bson.M{
+			"payment_status":    models.PaymentStatusPaid,
+			"payment_id":        payment.ID,
+			"payment_reference": payment.GatewayReference,
+			"paid_at":           now,
+			"updated_at":        now,
+		}}); err != nil {
+			return err
+		}
+
+		cursor, err := config.Coll.Orders.Find(sc, bson.M{
+			"checkout_id":    checkout.ID,
+			"payment_status": bson.M{"$nin": []models.PaymentStatus{models.PaymentStatusPaid, models.PaymentStatusRefunded}},
+		})
+		if err != nil {
+			return err
+		}
+		var unpaid []models.Order
+		if err := cursor.All(sc, &unpaid); err != nil {
+			return err
+		}
+		for i := range unpaid {
+			changed, err := s.confirmPayment(sc, &unpaid[i], payment, now)
+			if err != nil {
+				return err
+			}
+			if changed {
+				confirmed = append(confirmed, &unpaid[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range confirmed {
+		s.orders.Notify(ctx, order)
+	}
+
+	cursor, err := config.Coll.Orders.Find(ctx, bson.M{"checkout_id": checkout.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	escrows := make([]*models.Escrow, 0, len(orders))
+	for i := range orders {
+		escrow, err := s.settle(ctx, &orders[i], payment.ID)
+		if err != nil {
+			return escrows, err
+		}
+		if escrow != nil {
+			escrows = append(escrows, escrow)
+		}
+	}
+	return escrows, nil
+}
+
+// MarkOrderPaid settles a confirmed payment for a single order outside any
+// checkout: the order is confirmed, its stock committed and its escrow
+// opened. An order cancelled before the payment landed, or whose stock was
+// sold while the payment was late, is refunded instead and no escrow is
+// returned. Safe to call again for the same payment.
+func (s *CheckoutService) MarkOrderPaid(ctx context.Context, payment *models.Payment) (*models.Escrow, error) {
+	if payment.OrderID == nil {
+		return nil, fmt.Errorf("order payment %s has no order", payment.PaymentNumber)
+	}
+
+	order, err := loadOrder(ctx, *payment.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.PaymentStatus != models.PaymentStatusPaid && order.PaymentStatus != models.PaymentStatusRefunded {
+		var changed bool
+		err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+			// Reload in the transaction so a retry starts from the stored order
+			current, err := loadOrder(sc, order.ID)
+			if err != nil {
+				return err
+			}
+			*order = *current
+			changed, err = s.confirmPayment(sc, order, payment, time.Now())
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			s.orders.Notify(ctx, order)
+		}
+	}
+
+	return s.settle(ctx, order, payment.ID)
+}
+
+// SettlePendingEscrows finishes settling paid orders whose escrow was never
+// opened, e.g. because the process stopped between confirming the payment
+// and opening it, and returns how many it settled
+func (s *CheckoutService) SettlePendingEscrows(ctx context.Context) (int, error) {
+	cursor, err := config.Coll.Orders.Find(ctx, bson.M{
+		"escrow_pending": true,
+		// Leave payments that are being settled right now alone
+		"paid_at": bson.M{"$lte": time.Now().Add(-s.escrowGrace)},
+	}, options.Find().SetSort(bson.D{{Key: "paid_at", Value: 1}}).SetLimit(100))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for i := range orders {
+		if orders[i].PaymentID == nil {
+			log.Printf("Order %s is waiting for escrow but has no payment", orders[i].OrderNumber)
+			continue
+		}
+		if _, err := s.settle(ctx, &orders[i], *orders[i].PaymentID); err != nil {
+			log.Printf("Failed to settle escrow for order %s: %v", orders[i].OrderNumber, err)
+			continue
+		}
+		settled++
+	}
+	return settled, nil
+}
+
+// settle finishes a paid order: its stock is committed and its escrow
+// opened, or the payment is refunded when the order was cancelled or its
+// stock is gone. It returns the escrow, or nil when there is none.
+func (s *CheckoutService) settle(ctx context.Context, order *models.Order, paymentID primitive.ObjectID) (*models.Escrow, error) {
+	switch order.Status {
+	case models.OrderStatusRefunded:
+		return nil, clearEscrowPending(ctx, order.ID)
+	case models.OrderStatusCancelled:
+		if err := s.refundUnfulfilled(ctx, order, refundReasonCancelled); err != nil {
+			return nil, err
+		}
+		return nil, clearEscrowPending(ctx, order.ID)
+	}
+
+	err := s.inventory.Commit(ctx, order.ID)
+	if err == ErrStockUnavailable {
+		if err := s.refundUnfulfilled(ctx, order, refundReasonSoldOut); err != nil {
+			return nil, err
+		}
+		return nil, clearEscrowPending(ctx, order.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.escrow.OpenForOrder(ctx, order, paymentID)
+}
+
+// clearEscrowPending marks an order that will get no escrow as settled
+func clearEscrowPending(ctx context.Context, orderID primitive.ObjectID) error {
+	_, err := config.Coll.Orders.UpdateOne(ctx, bson.M{"_id": orderID}, bson.M{
+		"$unset": bson.M{"escrow_pending": ""},
+	})
+	return err
+}
+
+// Reasons given for refunding a payment whose order cannot be fulfilled
+const (
+	refundReasonCancelled = "Order was cancelled before the payment arrived"
+	refundReasonSoldOut   = "The items sold out before the payment arrived"
+)
+
+// refundUnfulfilled gives the buyer back a payment for an order that will not
+// be fulfilled. A refund already under way is left to finish.
+func (s *CheckoutService) refundUnfulfilled(ctx context.Context, order *models.Order, reason string) error {
+	_, err := s.refunds.Refund(ctx, RefundRequest{
+		OrderID: order.ID,
+		Type:    models.RefundTypeFull,
+		Reason:  reason,
+	})
+	if err == ErrRefundAmount {
+		return nil
+	}
+	return err
+}
+
+// confirmPayment records the payment on an unpaid order in the caller's
+// transaction and confirms the order when its status allows it. Cancelled
+// orders are only revived when they lapsed; the rest keep their status so
+// the payment can be refunded. It reports whether the status changed.
+func (s *CheckoutService) confirmPayment(sc mongo.SessionContext, order *models.Order, payment *models.Payment, now time.Time) (bool, error) {
+	if order.PaymentStatus == models.PaymentStatusPaid || order.PaymentStatus == models.PaymentStatusRefunded {
+		return false, nil
+	}
+	// Cleared once the escrow is opened or the payment refunded; until then
+	// the pending escrow job picks the order up
+	set := bson.M{
+		"payment_status":    models.PaymentStatusPaid,
+		"payment_id":        payment.ID,
+		"payment_reference": payment.GatewayReference,
+		"paid_at":           now,
+		"escrow_pending":    true,
+	}
+	order.EscrowPending = true
+	order.PaymentStatus = models.PaymentStatusPaid
+	order.PaymentID = &payment.ID
+	order.PaymentReference = payment.GatewayReference
+	order.PaidAt = &now
+
+	revive := order.Status != models.OrderStatusCancelled || orderLapsed(order)
+	if !revive || !CanTransition(order.Status, models.OrderStatusConfirmed, OrderActorSystem) {
+		set["updated_at"] = now
+		_, err := config.Coll.Orders.UpdateOne(sc, bson.M{"_id": order.ID}, bson.M{"$set": set})
+		return false, err
+	}
+	err := s.orders.Record(sc, order, OrderTransition{
+		OrderID: order.ID,
+		To:      models.OrderStatusConfirmed,
+		Actor:   OrderActorSystem,
+		Note:    "Payment received",
+		Set:     set,
+	})
+	return err == nil, err
+}
+
+// build prices the items and groups them into one pending order per seller
+func (s *CheckoutService) build(ctx context.Context, req CheckoutRequest) (*models.Checkout, []*models.Order, error) {
+	quantities := make(map[primitive.ObjectID]int)
+	var productIDs []primitive.ObjectID
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			continue
+		}
+		if _, ok := quantities[item.ProductID]; !ok {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		quantities[item.ProductID] += item.Quantity
+	}
+	if len(productIDs) == 0 {
+		return nil, nil, ErrCheckoutEmpty
+	}
+
+	now := time.Now()
+	checkout := &models.Checkout{
+		ID:             primitive.NewObjectID(),
+		CheckoutNumber: utils.GenerateCheckoutNumber(),
+		BuyerID:        req.BuyerID,
+		PaymentMethod:  req.PaymentMethod,
+		PaymentStatus:  models.PaymentStatusPending,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	var orders []*models.Order
+	bySeller := make(map[primitive.ObjectID]*models.Order)
+	origins := make(map[primitive.ObjectID]string)
+
+	for _, productID := range productIDs {
+		var product models.Product
+		err := config.Coll.Products.FindOne(ctx, bson.M{
+			"_id":    productID,
+			"status": models.ProductStatusActive,
+		}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, ErrProductUnavailable
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The checkout is charged in its items' currency; nothing converts
+		// between currencies, so they must all agree
+		currency := currencyOrDefault(product.Currency)
+		if checkout.Currency == "" {
+			checkout.Currency = currency
+		} else if currency != checkout.Currency {
+			return nil, nil, ErrMixedCurrency
+		}
+
+		quantity := quantities[productID]
+		if product.Quantity < quantity {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInsufficientStock, product.Title)
+		}
+
+		order, ok := bySeller[product.SellerID]
+		if !ok {
+			checkoutID := checkout.ID
+			order = &models.Order{
+				ID:              primitive.NewObjectID(),
+				OrderNumber:     utils.GenerateOrderNumber(),
+				BuyerID:         req.BuyerID,
+				SellerID:        product.SellerID,
+				CheckoutID:      &checkoutID,
+				Currency:        checkout.Currency,
+				Status:          models.OrderStatusPending,
+				PaymentStatus:   models.PaymentStatusPending,
+				ShippingAddress: req.ShippingAddress,
+				BillingAddress:  req.ShippingAddress,
+				PaymentMethod:   req.PaymentMethod,
+				CreatedAt:       now,
+				UpdatedAt:       now,
+			}
+			bySeller[product.SellerID] = order
+			origins[product.SellerID] = product.Location.State
+			orders = append(orders, order)
+		}
+
+		totalPrice := product.Price * float64(quantity)
+		item := models.OrderItem{
+			ID:           primitive.NewObjectID(),
+			ProductID:    product.ID,
+			ProductTitle: product.Title,
+			Quantity:     quantity,
+			UnitPrice:    product.Price,
+			TotalPrice:   totalPrice,
+			Currency:     product.Currency,
+			Status:       models.OrderStatusPending,
+			ProductSnapshot: models.ProductSnapshot{
+				Title:       product.Title,
+				Description: product.Description,
+				Brand:       product.Brand,
+				Model:       product.Model,
+				Condition:   product.Condition,
+				CategoryID:  product.CategoryID,
+			},
+		}
+		if len(product.Images) > 0 {
+			item.ProductImage = product.Images[0].URL
+		}
+
+		order.Items = append(order.Items, item)
+		order.SubtotalAmount += totalPrice
+	}
+
+	for _, order := range orders {
+		order.SubtotalAmount = utils.RoundCurrency(order.SubtotalAmount)
+		order.ShippingAmount = s.shipping(origins[order.SellerID], req.ShippingAddress.State, order.SubtotalAmount)
+		checkout.SubtotalAmount += order.SubtotalAmount
+		checkout.ShippingAmount += order.ShippingAmount
+	}
+	checkout.SubtotalAmount = utils.RoundCurrency(checkout.SubtotalAmount)
+	checkout.ShippingAmount = utils.RoundCurrency(checkout.ShippingAmount)
+
+	if req.PromoCode != "" {
+		if err := s.applyPromo(ctx, req, checkout, orders); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, order := range orders {
+		order.TotalAmount = utils.RoundCurrency(order.SubtotalAmount + order.ShippingAmount - order.DiscountAmount)
+
+		checkout.OrderIDs = append(checkout.OrderIDs, order.ID)
+		checkout.Allocations = append(checkout.Allocations, models.CheckoutAllocation{
+			OrderID:        order.ID,
+			SellerID:       order.SellerID,
+			SubtotalAmount: order.SubtotalAmount,
+			ShippingAmount: order.ShippingAmount,
+			DiscountAmount: order.DiscountAmount,
+			Amount:         order.TotalAmount,
+		})
+		checkout.TotalAmount += order.TotalAmount
+	}
+	checkout.TotalAmount = utils.RoundCurrency(checkout.TotalAmount)
+
+	return checkout, orders, nil
+}
+
+// applyPromo prices the checkout's promo code and spreads the discount over
+// the orders in proportion to what each contributes to it: the qualifying
+// items, or the shipping for a shipping code
+func (s *CheckoutService) applyPromo(ctx context.Context, req CheckoutRequest, checkout *models.Checkout, orders []*models.Order) error {
+	var lines []PromoLine
+	for _, order := range orders {
+		for _, item := range order.Items {
+			lines = append(lines, orderPromoLine(item))
+		}
+	}
+	promo, discount, err := s.promos.Quote(ctx, req.PromoCode, req.BuyerID, checkout.Currency, lines, checkout.ShippingAmount)
+	if err != nil {
+		return err
+	}
+	if discount <= 0 {
+		return fmt.Errorf("%w: nothing to discount", ErrPromoNotApplicable)
+	}
+
+	shares := make([]float64, len(orders))
+	for i, order := range orders {
+		if promo.Type == models.PromoCodeTypeShipping {
+			shares[i] = order.ShippingAmount
+			continue
+		}
+		for _, item := range order.Items {
+			if promoCovers(promo, orderPromoLine(item)) {
+				shares[i] += item.TotalPrice
+			}
+		}
+	}
+	for i, part := range splitDiscount(discount, shares) {
+		if part > 0 {
+			orders[i].DiscountAmount = part
+			orders[i].PromoCode = promo.Code
+		}
+	}
+
+	checkout.DiscountAmount = discount
+	checkout.PromoCode = promo.Code
+	return nil
+}
+
+// splitDiscount divides a discount in proportion to shares. The last
+// nonzero share takes the rounding so the parts add up to the discount.
+func splitDiscount(discount float64, shares []float64) []float64 {
+	base, last := 0.0, -1
+	for i, share := range shares {
+		if share > 0 {
+			base += share
+			last = i
+		}
+	}
+
+	parts := make([]float64, len(shares))
+	remaining := discount
+	for i, share := range shares {
+		if share <= 0 {
+			continue
+		}
+		part := utils.RoundCurrency(discount * share / base)
+		if i == last || part > remaining {
+			part = remaining
+		}
+		parts[i] = part
+		remaining = utils.RoundCurrency(remaining - part)
+	}
+	return parts
+}
+
+func orderPromoLine(item models.OrderItem) PromoLine {
+	return PromoLine{
+		ProductID:  item.ProductID,
+		CategoryID: item.ProductSnapshot.CategoryID,
+		Amount:     item.TotalPrice,
+	}
+}
+
+// shipping prices delivery of one seller's order: a flat same-state or
+// interstate fee, waived when the order reaches the free-shipping threshold
+func (s *CheckoutService) shipping(originState, destinationState string, subtotal float64) float64 {
+	if s.freeShippingOver > 0 && subtotal >= s.freeShippingOver {
+		return 0
+	}
+	origin := strings.TrimSpace(originState)
+	if origin != "" && strings.EqualFold(origin, strings.TrimSpace(destinationState)) {
+		return s.sameStateShipping
+	}
+	return s.interstateShipping
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"net/smtp"
 	"os"
+	"strings"
+	"time"
 
+	"autoboy-backend/models"
 	"autoboy-backend/utils"
 )
 
@@ -515,6 +519,174 @@ func (s *EmailService) SendSellerApplicationEmail(email, name string) error {
 	return s.SendEmail(email, "Seller Application Received - AutoBoy", body)
 }
 
+// SendSavedSearchDigestEmail sends new listings matching a user's saved searches
+func (s *EmailService) SendSavedSearchDigestEmail(email, name string, digests []SavedSearchDigest) error {
+	template := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>New Matches - AutoBoy</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #22C55E; color: white; padding: 20px; text-align: center; }
+        .content { padding: 20px; background: #f9f9f9; }
+        .listing { padding: 8px 0; border-bottom: 1px solid #e5e5e5; }
+        .price { color: #22C55E; font-weight: bold; }
+        .footer { text-align: center; padding: 20px; font-size: 12px; color: #666; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>New Listings For You</h1>
+        </div>
+        <div class="content">
+            <h2>Hi %s,</h2>
+            <p>We found new listings matching your saved searches.</p>
+            %s
+            <p>You can change how often we send these from your saved searches.</p>
+        </div>
+        <div class="footer">
+            <p>&copy; 2024 AutoBoy. All rights reserved.</p>
+        </div>
+    </div>
+</body>
+</html>`
+
+	frontendURL := utils.GetEnv("FRONTEND_URL", "http://localhost:3000")
+	var sections strings.Builder
+	total := 0
+	for _, digest := range digests {
+		fmt.Fprintf(&sections, "<h3>%s</h3>", html.EscapeString(digest.Search.Name))
+		for _, product := range digest.Products {
+			fmt.Fprintf(&sections, `<div class="listing"><a href="%s/products/%s">%s</a> <span class="price">%s</span> &middot; %s</div>`,
+				frontendURL, product.ID.Hex(), html.EscapeString(product.Title),
+				utils.FormatCurrency(product.Price), html.EscapeString(product.Location.City))
+			total++
+		}
+	}
+
+	body := fmt.Sprintf(template, html.EscapeString(name), sections.String())
+	return s.SendEmail(email, fmt.Sprintf("%d new listings match your saved searches - AutoBoy", total), body)
+}
+
+// SendListingExpiryEmail tells a seller which of their listings expire
+// soon and which have expired
+func (s *EmailService) SendListingExpiryEmail(email, name string, expiring, expired []models.Product) error {
+	template := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Your Listings - AutoBoy</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #22C55E; color: white; padding: 20px; text-align: center; }
+        .content { padding: 20px; background: #f9f9f9; }
+        .listing { padding: 8px 0; border-bottom: 1px solid #e5e5e5; }
+        .footer { text-align: center; padding: 20px; font-size: 12px; color: #666; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>Keep Your Listings Live</h1>
+        </div>
+        <div class="content">
+            <h2>Hi %s,</h2>
+            %s
+            <p>Renew a listing to keep it on sale, or turn on auto-relist so it goes back up by itself.</p>
+        </div>
+        <div class="footer">
+            <p>&copy; 2024 AutoBoy. All rights reserved.</p>
+        </div>
+    </div>
+</body>
+</html>`
+
+	frontendURL := utils.GetEnv("FRONTEND_URL", "http://localhost:3000")
+	var sections strings.Builder
+	for _, group := range []struct {
+		heading  string
+		products []models.Product
+	}{
+		{"Expiring soon", expiring},
+		{"Expired and hidden from buyers", expired},
+	} {
+		if len(group.products) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sections, "<h3>%s</h3>", group.heading)
+		for _, product := range group.products {
+			expires := ""
+			if product.ExpiresAt != nil {
+				expires = " &middot; " + product.ExpiresAt.Format("2 Jan 2006")
+			}
+			fmt.Fprintf(&sections, `<div class="listing"><a href="%s/seller/products/%s">%s</a>%s</div>`,
+				frontendURL, product.ID.Hex(), html.EscapeString(product.Title), expires)
+		}
+	}
+
+	subject := "Your listings are expiring soon - AutoBoy"
+	if len(expired) > 0 {
+		subject = "Your listings have expired - AutoBoy"
+	}
+	body := fmt.Sprintf(template, html.EscapeString(name), sections.String())
+	return s.SendEmail(email, subject, body)
+}
+
+// SendAccountLockedEmail tells a user their account was locked after
+// failed sign-ins, with a link to unlock it
+func (s *EmailService) SendAccountLockedEmail(email, name, token string, until time.Time) error {
+	template := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Account Locked - AutoBoy</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #22C55E; color: white; padding: 20px; text-align: center; }
+        .content { padding: 20px; background: #f9f9f9; }
+        .button { display: inline-block; background: #22C55E; color: white; padding: 12px 24px; text-decoration: none; border-radius: 5px; margin: 20px 0; }
+        .footer { text-align: center; padding: 20px; font-size: 12px; color: #666; }
+        .warning { background: #FEF3C7; border: 1px solid #F59E0B; padding: 15px; border-radius: 5px; margin: 15px 0; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>Your Account Was Locked</h1>
+        </div>
+        <div class="content">
+            <h2>Hi %s,</h2>
+            <p>There were too many failed attempts to sign in to your AutoBoy account, so we have locked it until %s.</p>
+            <p>If it was you, unlock your account now:</p>
+            <a href="%s" class="button">Unlock Account</a>
+            <p>If the button doesn't work, copy and paste this link into your browser:</p>
+            <p><a href="%s">%s</a></p>
+            <div class="warning">
+                <strong>Wasn't you?</strong> Someone may be trying to guess your password. Reset your password and turn on two-factor authentication.
+            </div>
+        </div>
+        <div class="footer">
+            <p>&copy; 2024 AutoBoy. All rights reserved.</p>
+            <p>This is an automated email. Please do not reply.</p>
+        </div>
+    </div>
+</body>
+</html>`
+
+	unlockURL := fmt.Sprintf("%s/unlock-account?token=%s",
+		utils.GetEnv("FRONTEND_URL", "http://localhost:3000"), token)
+	body := fmt.Sprintf(template, html.EscapeString(name), until.UTC().Format("15:04 MST, 2 Jan 2006"), unlockURL, unlockURL, unlockURL)
+	return s.SendEmail(email, "Your AutoBoy account was locked", body)
+}
+
 // sendWithResend sends email using Resend API
 func (s *EmailService) sendWithResend(to, subject, body, apiKey string) error {
 	log.Printf("=== RESEND API START ===")
@@ -0,0 +1,686 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrEscrowNotFound = errors.New("escrow not found")
+	ErrEscrowNotHeld  = errors.New("escrow is not in a releasable state")
+	ErrEscrowAmount   = errors.New("amount exceeds remaining escrow balance")
+	ErrClawback       = errors.New("seller balance is too low to reverse released funds")
+)
+
+const escrowReferenceType = "escrow"
+
+// EscrowService holds buyer funds until the order is confirmed, disputed or
+// auto-released. Amounts on an escrow are gross (what the buyer paid); the
+// seller receives the gross share minus the proportional escrow fee.
+type EscrowService struct {
+	wallet          *WalletService
+	autoReleaseDays int
+	feePercent      float64
+}
+
+func NewEscrowService(wallet *WalletService) *EscrowService {
+	return &EscrowService{
+		wallet:          wallet,
+		autoReleaseDays: utils.GetEnvAsInt("ESCROW_AUTO_RELEASE_DAYS", 14),
+		feePercent:      utils.GetEnvAsFloat("ESCROW_FEE_PERCENT", 2.5),
+	}
+}
+
+// OpenForOrder places the order total in escrow once payment is confirmed.
+// It is idempotent: calling it again for the same order returns the existing escrow.
+// The auto release clock only starts once the order is delivered.
+func (s *EscrowService) OpenForOrder(ctx context.Context, order *models.Order, paymentID primitive.ObjectID) (*models.Escrow, error) {
+	var escrow *models.Escrow
+	err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		escrow, err = s.Open(sc, order, paymentID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open escrow: %w", err)
+	}
+	return escrow, nil
+}
+
+// Open does the work of OpenForOrder in the caller's transaction, so the
+// escrow can be opened together with the payment that funds it. It clears
+// the order's escrow_pending flag.
+func (s *EscrowService) Open(sc mongo.SessionContext, order *models.Order, paymentID primitive.ObjectID) (*models.Escrow, error) {
+	var existing models.Escrow
+	err := config.Coll.Escrows.FindOne(sc, bson.M{"order_id": order.ID}).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// The seller is paid the full price; the platform makes up a promo
+	// discount out of its own pocket
+	now := time.Now()
+	amount := order.TotalAmount
+	subsidy := 0.0
+	if order.PromoCode != "" && order.DiscountAmount > 0 {
+		subsidy = order.DiscountAmount
+		amount = utils.RoundCurrency(amount + subsidy)
+	}
+	fee := utils.RoundCurrency(amount * s.feePercent / 100)
+	orderID := order.ID
+
+	escrow := &models.Escrow{
+		ID:                primitive.NewObjectID(),
+		EscrowNumber:      utils.GenerateEscrowNumber(),
+		PaymentID:         paymentID,
+		OrderID:           &orderID,
+		PayerID:           order.BuyerID,
+		PayeeID:           order.SellerID,
+		Amount:            amount,
+		Currency:          currencyOrDefault(order.Currency),
+		EscrowFee:         fee,
+		NetAmount:         utils.RoundCurrency(amount - fee),
+		Subsidy:           subsidy,
+		Status:            models.EscrowStatusHeld,
+		ReleaseConditions: []string{"buyer_confirmation", "auto_release"},
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	if _, err := config.Coll.Escrows.InsertOne(sc, escrow); err != nil {
+		return nil, err
+	}
+
+	if subsidy > 0 {
+		if _, err := ledger.Post(sc, ledger.Entry{
+			IdempotencyKey: "promo:" + order.ID.Hex(),
+			Description:    fmt.Sprintf("Promo %s discount on order %s", order.PromoCode, order.OrderNumber),
+			Currency:       escrow.Currency,
+			ReferenceType:  escrowReferenceType,
+			ReferenceID:    &escrow.ID,
+			Lines: []ledger.Line{
+				ledger.Debit(ledger.AccountPromoSubsidies, subsidy),
+				ledger.Credit(ledger.AccountEscrow, subsidy),
+			},
+		}); err != nil {
+			return nil, err
+		}
+		if err := redeemPromo(sc, order); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := config.Coll.Orders.UpdateOne(sc, bson.M{"_id": order.ID}, bson.M{
+		"$set": bson.M{
+			"escrow_id":  escrow.ID,
+			"updated_at": now,
+		},
+		"$unset": bson.M{"escrow_pending": ""},
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.wallet.RecordPending(sc, escrow.PayeeID, WalletEntry{
+		Type:          models.WalletTransactionEscrow,
+		Amount:        escrow.NetAmount,
+		Currency:      escrow.Currency,
+		ReferenceType: escrowReferenceType,
+		ReferenceID:   &escrow.ID,
+		PaymentID:     &paymentID,
+		Description:   fmt.Sprintf("Funds held in escrow for order %s", order.OrderNumber),
+		Metadata: map[string]interface{}{
+			"order_id":   order.ID.Hex(),
+			"escrow_fee": escrow.EscrowFee,
+		},
+	}); err != nil {
+		return nil, err
+	}
+	order.EscrowID = &escrow.ID
+	return escrow, nil
+}
+
+// GetByOrder returns the escrow attached to an order
+func (s *EscrowService) GetByOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Escrow, error) {
+	var escrow models.Escrow
+	err := config.Coll.Escrows.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&escrow)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrEscrowNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// Release pays the full remaining balance to the seller
+func (s *EscrowService) Release(ctx context.Context, escrowID primitive.ObjectID, releasedBy primitive.ObjectID, reason string) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		escrow, err := s.load(sc, bson.M{"_id": escrowID})
+		if err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusHeld {
+			return ErrEscrowNotHeld
+		}
+		return s.payout(sc, escrow, remainingEscrow(escrow), releasedBy, reason)
+	})
+}
+
+// ReleaseForOrder pays the escrow attached to an order to the seller in the
+// caller's transaction
+func (s *EscrowService) ReleaseForOrder(sc mongo.SessionContext, orderID primitive.ObjectID, releasedBy primitive.ObjectID, reason string) error {
+	escrow, err := s.load(sc, bson.M{"order_id": orderID})
+	if err != nil {
+		return err
+	}
+	if escrow.Status != models.EscrowStatusHeld {
+		return ErrEscrowNotHeld
+	}
+	return s.payout(sc, escrow, remainingEscrow(escrow), releasedBy, reason)
+}
+
+// PartialRelease pays part of the escrow to the seller and keeps the rest held
+func (s *EscrowService) PartialRelease(ctx context.Context, escrowID primitive.ObjectID, amount float64, releasedBy primitive.ObjectID, reason string) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		escrow, err := s.load(sc, bson.M{"_id": escrowID})
+		if err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusHeld {
+			return ErrEscrowNotHeld
+		}
+		if amount <= 0 || utils.RoundCurrency(amount) > remainingEscrow(escrow) {
+			return ErrEscrowAmount
+		}
+		return s.payout(sc, escrow, utils.RoundCurrency(amount), releasedBy, reason)
+	})
+}
+
+// Refund returns funds to the buyer's wallet. An amount of zero refunds
+// everything still held.
+func (s *EscrowService) Refund(ctx context.Context, escrowID primitive.ObjectID, amount float64, refundedBy primitive.ObjectID, reason string) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		escrow, err := s.load(sc, bson.M{"_id": escrowID})
+		if err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusHeld && escrow.Status != models.EscrowStatusDisputed {
+			return ErrEscrowNotHeld
+		}
+		if amount == 0 {
+			amount = refundableEscrow(escrow)
+		}
+		if amount <= 0 || utils.RoundCurrency(amount) > remainingEscrow(escrow) {
+			return ErrEscrowAmount
+		}
+		return s.refund(sc, escrow, utils.RoundCurrency(amount), refundedBy, reason, true)
+	})
+}
+
+// RefundForOrder takes gross back out of the order's escrow for a buyer
+// refund. Anything already released to the seller is clawed back first,
+// commission included. With toWallet the buyer's wallet is credited;
+// otherwise the money leaves through the payment gateway, which posts its
+// own ledger entry. It runs inside the caller's transaction.
+func (s *EscrowService) RefundForOrder(sc mongo.SessionContext, orderID primitive.ObjectID, gross float64, toWallet bool, refundedBy primitive.ObjectID, reason string) error {
+	escrow, err := s.load(sc, bson.M{"order_id": orderID})
+	if err != nil {
+		return err
+	}
+
+	gross = utils.RoundCurrency(gross)
+	if shortfall := utils.RoundCurrency(gross - remainingEscrow(escrow)); shortfall > 0 {
+		if shortfall > utils.RoundCurrency(escrow.ReleasedAmount) {
+			return ErrEscrowAmount
+		}
+		if err := s.clawback(sc, escrow, shortfall, reason); err != nil {
+			return err
+		}
+		if escrow, err = s.load(sc, bson.M{"_id": escrow.ID}); err != nil {
+			return err
+		}
+	}
+	return s.refund(sc, escrow, gross, refundedBy, reason, toWallet)
+}
+
+// CheckRefund reports whether RefundForOrder could take gross out of the
+// order's escrow right now, including any clawback from the seller
+func (s *EscrowService) CheckRefund(ctx context.Context, orderID primitive.ObjectID, gross float64) error {
+	escrow, err := s.load(ctx, bson.M{"order_id": orderID})
+	if err != nil {
+		return err
+	}
+
+	shortfall := utils.RoundCurrency(gross - remainingEscrow(escrow))
+	if shortfall <= 0 {
+		return nil
+	}
+	if shortfall > utils.RoundCurrency(escrow.ReleasedAmount) {
+		return ErrEscrowAmount
+	}
+
+	wallet, err := s.wallet.GetOrCreateWallet(ctx, escrow.PayeeID)
+	if err != nil {
+		return err
+	}
+	if wallet.Balance < s.netShare(escrow, shortfall) {
+		return ErrClawback
+	}
+	return nil
+}
+
+// FreezeForDispute stops any release of the order's escrow while a dispute is open
+func (s *EscrowService) FreezeForDispute(ctx context.Context, orderID, disputeID primitive.ObjectID) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		escrow, err := s.load(sc, bson.M{"order_id": orderID})
+		if err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusHeld {
+			return ErrEscrowNotHeld
+		}
+
+		now := time.Now()
+		if _, err := config.Coll.Escrows.UpdateOne(sc, bson.M{"_id": escrow.ID}, bson.M{"$set": bson.M{
+			"status":      models.EscrowStatusDisputed,
+			"is_disputed": true,
+			"dispute_id":  disputeID,
+			"updated_at":  now,
+		}}); err != nil {
+			return err
+		}
+
+		_, err = s.wallet.RecordPending(sc, escrow.PayeeID, WalletEntry{
+			Type:          models.WalletTransactionEscrow,
+			Amount:        s.netShare(escrow, remainingEscrow(escrow)),
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			Description:   fmt.Sprintf("Escrow %s frozen pending dispute", escrow.EscrowNumber),
+			Metadata: map[string]interface{}{
+				"dispute_id": disputeID.Hex(),
+				"event":      "frozen",
+			},
+		})
+		return err
+	})
+}
+
+// SettleDispute refunds refundAmount to the buyer and releases whatever is
+// left to the seller, closing the escrow.
+func (s *EscrowService) SettleDispute(ctx context.Context, orderID primitive.ObjectID, refundAmount float64, settledBy primitive.ObjectID, reason string) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		escrow, err := s.load(sc, bson.M{"order_id": orderID})
+		if err != nil {
+			return err
+		}
+		if escrow.Status != models.EscrowStatusDisputed && escrow.Status != models.EscrowStatusHeld {
+			return ErrEscrowNotHeld
+		}
+
+		refundAmount = utils.RoundCurrency(refundAmount)
+		remaining := remainingEscrow(escrow)
+		if refundAmount < 0 || refundAmount > remaining {
+			return ErrEscrowAmount
+		}
+
+		if refundAmount > 0 {
+			if err := s.refund(sc, escrow, refundAmount, settledBy, reason, true); err != nil {
+				return err
+			}
+			if escrow, err = s.load(sc, bson.M{"_id": escrow.ID}); err != nil {
+				return err
+			}
+		}
+
+		if rest := remainingEscrow(escrow); rest > 0 {
+			return s.payout(sc, escrow, rest, settledBy, reason)
+		}
+		return nil
+	})
+}
+
+// StartReleaseClock sets when an order's held escrow is auto-released,
+// counted from its delivery. It runs in the transaction recording the
+// delivery.
+func (s *EscrowService) StartReleaseClock(sc mongo.SessionContext, orderID primitive.ObjectID, deliveredAt time.Time) error {
+	_, err := config.Coll.Escrows.UpdateOne(sc,
+		bson.M{"order_id": orderID, "status": models.EscrowStatusHeld},
+		bson.M{"$set": bson.M{
+			"auto_release_date": deliveredAt.AddDate(0, 0, s.autoReleaseDays),
+			"updated_at":        time.Now(),
+		}},
+	)
+	return err
+}
+
+// ProcessAutoReleases releases every undisputed escrow whose auto release
+// date has passed and whose order has been delivered, and returns the
+// orders it paid out, so the caller can complete them
+func (s *EscrowService) ProcessAutoReleases(ctx context.Context) ([]primitive.ObjectID, error) {
+	filter := bson.M{
+		"status":            models.EscrowStatusHeld,
+		"is_disputed":       false,
+		"auto_release_date": bson.M{"$lte": time.Now()},
+	}
+
+	cursor, err := config.Coll.Escrows.Find(ctx, filter, options.Find().SetLimit(500))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var escrows []models.Escrow
+	if err := cursor.All(ctx, &escrows); err != nil {
+		return nil, err
+	}
+
+	delivered, err := deliveredOrders(ctx, escrows)
+	if err != nil {
+		return nil, err
+	}
+
+	var released []primitive.ObjectID
+	for _, escrow := range escrows {
+		if escrow.OrderID == nil || !delivered[*escrow.OrderID] {
+			log.Printf("Skipping auto-release of escrow %s: its order has not been delivered", escrow.EscrowNumber)
+			continue
+		}
+		if err := s.Release(ctx, escrow.ID, primitive.NilObjectID, "Auto-released after holding period"); err != nil {
+			log.Printf("Failed to auto-release escrow %s: %v", escrow.EscrowNumber, err)
+			continue
+		}
+		if escrow.OrderID != nil {
+			released = append(released, *escrow.OrderID)
+		}
+	}
+	return released, nil
+}
+
+// deliveredOrders reports which of the escrows' orders are delivered
+func deliveredOrders(ctx context.Context, escrows []models.Escrow) (map[primitive.ObjectID]bool, error) {
+	var orderIDs []primitive.ObjectID
+	for _, escrow := range escrows {
+		if escrow.OrderID != nil {
+			orderIDs = append(orderIDs, *escrow.OrderID)
+		}
+	}
+	delivered := make(map[primitive.ObjectID]bool, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return delivered, nil
+	}
+
+	cursor, err := config.Coll.Orders.Find(ctx,
+		bson.M{"_id": bson.M{"$in": orderIDs}, "status": models.OrderStatusDelivered},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		delivered[order.ID] = true
+	}
+	return delivered, nil
+}
+
+func (s *EscrowService) load(ctx context.Context, filter bson.M) (*models.Escrow, error) {
+	var escrow models.Escrow
+	err := config.Coll.Escrows.FindOne(ctx, filter).Decode(&escrow)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrEscrowNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &escrow, nil
+}
+
+// payout moves gross from escrow to the seller's wallet (net of fee)
+func (s *EscrowService) payout(sc mongo.SessionContext, escrow *models.Escrow, gross float64, releasedBy primitive.ObjectID, reason string) error {
+	now := time.Now()
+	net := s.netShare(escrow, gross)
+
+	if net > 0 {
+		if _, err := s.wallet.Credit(sc, escrow.PayeeID, WalletEntry{
+			Type:          models.WalletTransactionCredit,
+			Counterparty:  ledger.AccountEscrow,
+			Amount:        net,
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			PaymentID:     &escrow.PaymentID,
+			Description:   fmt.Sprintf("Escrow %s released", escrow.EscrowNumber),
+			Metadata: map[string]interface{}{
+				"gross_amount": gross,
+				"escrow_fee":   utils.RoundCurrency(gross - net),
+				"reason":       reason,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// The rest of the gross share is the platform's commission
+	if fee := utils.RoundCurrency(gross - net); fee > 0 {
+		if _, err := ledger.Post(sc, ledger.Entry{
+			Description:   fmt.Sprintf("Commission on escrow %s", escrow.EscrowNumber),
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			Lines: []ledger.Line{
+				ledger.Debit(ledger.AccountEscrow, fee),
+				ledger.Credit(ledger.AccountCommissionRevenue, fee),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	released := utils.RoundCurrency(escrow.ReleasedAmount + gross)
+	closed := utils.RoundCurrency(escrow.Amount-released-escrow.RefundedAmount) <= 0
+
+	set := bson.M{
+		"released_amount": released,
+		"updated_at":      now,
+	}
+	update := bson.M{"$set": set}
+	if closed {
+		set["status"] = models.EscrowStatusReleased
+		set["released_at"] = now
+		set["release_reason"] = reason
+		if !releasedBy.IsZero() {
+			set["released_by"] = releasedBy
+		}
+	} else {
+		update["$push"] = bson.M{"partial_releases": models.PartialRelease{
+			Amount:     gross,
+			Reason:     reason,
+			ReleasedBy: releasedBy,
+			ReleasedAt: now,
+		}}
+	}
+
+	if _, err := config.Coll.Escrows.UpdateOne(sc, bson.M{"_id": escrow.ID}, update); err != nil {
+		return err
+	}
+
+	if closed {
+		return s.wallet.SettlePending(sc, escrowReferenceType, escrow.ID, models.WalletTransactionStatusCompleted)
+	}
+	return nil
+}
+
+// clawback reverses gross of what was released to the seller: their net
+// share comes back out of their wallet and the commission out of revenue,
+// both into escrow
+func (s *EscrowService) clawback(sc mongo.SessionContext, escrow *models.Escrow, gross float64, reason string) error {
+	net := s.netShare(escrow, gross)
+
+	if net > 0 {
+		if _, err := s.wallet.Debit(sc, escrow.PayeeID, WalletEntry{
+			Type:          models.WalletTransactionDebit,
+			Counterparty:  ledger.AccountEscrow,
+			Amount:        net,
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			PaymentID:     &escrow.PaymentID,
+			Description:   fmt.Sprintf("Refund reversal on escrow %s", escrow.EscrowNumber),
+			Metadata: map[string]interface{}{
+				"gross_amount": gross,
+				"reason":       reason,
+			},
+		}); err != nil {
+			if errors.Is(err, ErrInsufficientBalance) {
+				return ErrClawback
+			}
+			return err
+		}
+	}
+
+	if fee := utils.RoundCurrency(gross - net); fee > 0 {
+		if _, err := ledger.Post(sc, ledger.Entry{
+			Description:   fmt.Sprintf("Commission reversed on escrow %s", escrow.EscrowNumber),
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			Lines: []ledger.Line{
+				ledger.Debit(ledger.AccountCommissionRevenue, fee),
+				ledger.Credit(ledger.AccountEscrow, fee),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	_, err := config.Coll.Escrows.UpdateOne(sc, bson.M{"_id": escrow.ID}, bson.M{"$set": bson.M{
+		"released_amount": utils.RoundCurrency(escrow.ReleasedAmount - gross),
+		"updated_at":      time.Now(),
+	}})
+	return err
+}
+
+// refund takes gross out of escrow for the buyer, crediting their wallet
+// when toWallet is set
+func (s *EscrowService) refund(sc mongo.SessionContext, escrow *models.Escrow, gross float64, refundedBy primitive.ObjectID, reason string, toWallet bool) error {
+	now := time.Now()
+
+	if toWallet {
+		if _, err := s.wallet.Credit(sc, escrow.PayerID, WalletEntry{
+			Type:          models.WalletTransactionRefund,
+			Counterparty:  ledger.AccountEscrow,
+			Amount:        gross,
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			PaymentID:     &escrow.PaymentID,
+			Description:   fmt.Sprintf("Refund from escrow %s", escrow.EscrowNumber),
+			Metadata: map[string]interface{}{
+				"reason": reason,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	refunded := utils.RoundCurrency(escrow.RefundedAmount + gross)
+	if back := subsidyReturn(escrow, refunded); back > 0 {
+		if _, err := ledger.Post(sc, ledger.Entry{
+			Description:   fmt.Sprintf("Promo subsidy returned from escrow %s", escrow.EscrowNumber),
+			Currency:      escrow.Currency,
+			ReferenceType: escrowReferenceType,
+			ReferenceID:   &escrow.ID,
+			Lines: []ledger.Line{
+				ledger.Debit(ledger.AccountEscrow, back),
+				ledger.Credit(ledger.AccountPromoSubsidies, back),
+			},
+		}); err != nil {
+			return err
+		}
+		refunded = utils.RoundCurrency(refunded + back)
+	}
+	closed := utils.RoundCurrency(escrow.Amount-escrow.ReleasedAmount-refunded) <= 0
+
+	set := bson.M{
+		"refunded_amount": refunded,
+		"updated_at":      now,
+	}
+	if closed {
+		set["refunded_at"] = now
+		if escrow.ReleasedAmount > 0 {
+			set["status"] = models.EscrowStatusReleased
+		} else {
+			set["status"] = models.EscrowStatusRefunded
+		}
+		if !refundedBy.IsZero() {
+			set["released_by"] = refundedBy
+		}
+		set["release_reason"] = reason
+	}
+
+	if _, err := config.Coll.Escrows.UpdateOne(sc, bson.M{"_id": escrow.ID}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+
+	if closed {
+		status := models.WalletTransactionStatusCompleted
+		if escrow.ReleasedAmount == 0 {
+			status = models.WalletTransactionStatusCancelled
+		}
+		return s.wallet.SettlePending(sc, escrowReferenceType, escrow.ID, status)
+	}
+	return nil
+}
+
+// netShare returns the seller's share of a gross escrow amount
+func (s *EscrowService) netShare(escrow *models.Escrow, gross float64) float64 {
+	if escrow.Amount <= 0 {
+		return 0
+	}
+	return utils.RoundCurrency(gross * escrow.NetAmount / escrow.Amount)
+}
+
+func remainingEscrow(escrow *models.Escrow) float64 {
+	return utils.RoundCurrency(escrow.Amount - escrow.ReleasedAmount - escrow.RefundedAmount)
+}
+
+// subsidyReturn is what goes back to the platform of an escrow's promo
+// subsidy once the buyer has had refunded everything they paid in
+func subsidyReturn(escrow *models.Escrow, refunded float64) float64 {
+	if escrow.Subsidy <= 0 || refunded < utils.RoundCurrency(escrow.Amount-escrow.Subsidy) {
+		return 0
+	}
+	if back := utils.RoundCurrency(escrow.Amount - escrow.ReleasedAmount - refunded); back > 0 {
+		return back
+	}
+	return 0
+}
+
+// refundableEscrow is what remains of the money the buyer paid in, leaving
+// out any promo subsidy
+func refundableEscrow(escrow *models.Escrow) float64 {
+	if amount := utils.RoundCurrency(remainingEscrow(escrow) - escrow.Subsidy); amount > 0 {
+		return amount
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+
+	"autoboy-backend/models"
+)
+
+func TestEscrowShares(t *testing.T) {
+	s := &EscrowService{}
+	escrow := &models.Escrow{Amount: 10000, NetAmount: 9500, ReleasedAmount: 4000, RefundedAmount: 1000}
+
+	if got := s.netShare(escrow, 5000); got != 4750 {
+		t.Errorf("netShare(5000) = %v, want 4750", got)
+	}
+	if got := s.netShare(&models.Escrow{}, 5000); got != 0 {
+		t.Errorf("netShare of an empty escrow = %v, want 0", got)
+	}
+	if got := remainingEscrow(escrow); got != 5000 {
+		t.Errorf("remainingEscrow() = %v, want 5000", got)
+	}
+}
+
+func TestRefundableEscrow(t *testing.T) {
+	tests := []struct {
+		name   string
+		escrow models.Escrow
+		want   float64
+	}{
+		{"no subsidy", models.Escrow{Amount: 10000}, 10000},
+		{"subsidy left out", models.Escrow{Amount: 10000, Subsidy: 1000}, 9000},
+		{"after a partial refund", models.Escrow{Amount: 10000, Subsidy: 1000, RefundedAmount: 4000}, 5000},
+		{"only the subsidy left", models.Escrow{Amount: 10000, Subsidy: 1000, RefundedAmount: 9000}, 0},
+		{"released beyond what the buyer paid", models.Escrow{Amount: 10000, Subsidy: 1000, ReleasedAmount: 9500}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refundableEscrow(&tt.escrow); got != tt.want {
+				t.Fatalf("refundableEscrow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubsidyReturn(t *testing.T) {
+	tests := []struct {
+		name     string
+		escrow   models.Escrow
+		refunded float64
+		want     float64
+	}{
+		{"no subsidy", models.Escrow{Amount: 10000}, 10000, 0},
+		{"buyer not fully refunded", models.Escrow{Amount: 10000, Subsidy: 1000}, 8999.99, 0},
+		{"buyer fully refunded", models.Escrow{Amount: 10000, Subsidy: 1000}, 9000, 1000},
+		{"subsidy already returned", models.Escrow{Amount: 10000, Subsidy: 1000}, 10000, 0},
+		{"part released to the seller", models.Escrow{Amount: 10000, Subsidy: 1000, ReleasedAmount: 500}, 9000, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subsidyReturn(&tt.escrow, tt.refunded); got != tt.want {
+				t.Fatalf("subsidyReturn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventTracker buffers product views and funnel events in memory and
+// writes them to Mongo in batches, so recording one costs a request
+// nothing but a lock. A viewer repeating the same view or event on a
+// listing within the session window is only recorded once.
+type EventTracker struct {
+	mu      sync.Mutex
+	views   []interface{}
+	events  []interface{}
+	seen    map[string]time.Time
+	dropped int
+
+	sessionWindow time.Duration
+	flushInterval time.Duration
+	batchSize     int
+	maxBuffered   int
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewEventTracker() *EventTracker {
+	return &EventTracker{
+		seen:          make(map[string]time.Time),
+		sessionWindow: time.Duration(utils.GetEnvAsInt("EVENT_SESSION_WINDOW_MINUTES", 30)) * time.Minute,
+		flushInterval: time.Duration(utils.GetEnvAsInt("EVENT_FLUSH_INTERVAL_SECONDS", 5)) * time.Second,
+		batchSize:     utils.GetEnvAsInt("EVENT_BATCH_SIZE", 500),
+		maxBuffered:   utils.GetEnvAsInt("EVENT_MAX_BUFFERED", 50000),
+		flushNow:      make(chan struct{}, 1),
+	}
+}
+
+// SessionKey identifies an anonymous visitor who sent no session ID by
+// hashing their IP address and user agent
+func SessionKey(ipAddress, userAgent string) string {
+	sum := sha256.Sum256([]byte(ipAddress + "|" + userAgent))
+	return "anon-" + hex.EncodeToString(sum[:8])
+}
+
+// TrackView buffers a product view unless the same viewer already viewed
+// the listing within the session window. It reports whether the view was
+// recorded.
+func (t *EventTracker) TrackView(view models.ProductView) bool {
+	if view.ID.IsZero() {
+		view.ID = primitive.NewObjectID()
+	}
+	if view.ViewedAt.IsZero() {
+		view.ViewedAt = time.Now()
+	}
+	key := "view|" + view.ProductID.Hex() + "|" + viewerKey(view.UserID, view.SessionID)
+	return t.buffer(key, view.ViewedAt, func() { t.views = append(t.views, view) })
+}
+
+// TrackEvent buffers a funnel event unless the same viewer already sent it
+// for the listing within the session window. It reports whether the event
+// was recorded.
+func (t *EventTracker) TrackEvent(event models.ProductEvent) bool {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	key := string(event.Type) + "|" + event.ProductID.Hex() + "|" + viewerKey(event.UserID, event.SessionID)
+	return t.buffer(key, event.OccurredAt, func() { t.events = append(t.events, event) })
+}
+
+func (t *EventTracker) buffer(key string, at time.Time, add func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.seen[key]; ok && at.Sub(last) < t.sessionWindow {
+		return false
+	}
+	if len(t.views)+len(t.events) >= t.maxBuffered {
+		t.dropped++
+		return false
+	}
+	t.seen[key] = at
+	add()
+
+	if len(t.views) >= t.batchSize || len(t.events) >= t.batchSize {
+		select {
+		case t.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Start writes buffered events every EVENT_FLUSH_INTERVAL_SECONDS, or
+// sooner once a batch fills up, until Stop is called
+func (t *EventTracker) Start() {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			case <-t.flushNow:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := t.Flush(ctx); err != nil {
+				log.Printf("Failed to write product events: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// Stop ends the flush loop and writes whatever is still buffered
+func (t *EventTracker) Stop() {
+	if t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := t.Flush(ctx); err != nil {
+		log.Printf("Failed to write product events: %v", err)
+	}
+}
+
+// Flush writes the buffered views and events. Batches that fail are put
+// back to be retried on the next flush while there is room for them.
+func (t *EventTracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	views, events := t.views, t.events
+	t.views, t.events = nil, nil
+	dropped := t.dropped
+	t.dropped = 0
+	now := time.Now()
+	for key, at := range t.seen {
+		if now.Sub(at) >= t.sessionWindow {
+			delete(t.seen, key)
+		}
+	}
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Event buffer full, dropped %d product events", dropped)
+	}
+
+	var firstErr error
+	if err := t.write(ctx, config.Coll.ProductViews, views); err != nil {
+		firstErr = err
+		t.requeue(&t.views, views)
+	}
+	if err := t.write(ctx, config.Coll.ProductEvents, events); err != nil {
+		if firstErr == nil {
+			firstErr = err
+		}
+		t.requeue(&t.events, events)
+	}
+	return firstErr
+}
+
+func (t *EventTracker) write(ctx context.Context, coll *mongo.Collection, docs []interface{}) error {
+	for start := 0; start < len(docs); start += t.batchSize {
+		end := min(start+t.batchSize, len(docs))
+		_, err := coll.InsertMany(ctx, docs[start:end], options.InsertMany().SetOrdered(false))
+		// Duplicate keys are documents an earlier, partly failed flush wrote
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *EventTracker) requeue(buffer *[]interface{}, docs []interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	room := t.maxBuffered - len(t.views) - len(t.events)
+	if room <= 0 {
+		t.dropped += len(docs)
+		return
+	}
+	if len(docs) > room {
+		t.dropped += len(docs) - room
+		docs = docs[:room]
+	}
+	*buffer = append(docs, *buffer...)
+}
+
+// viewerKey is who a view or event came from: the user when signed in,
+// otherwise their session
+func viewerKey(userID *primitive.ObjectID, sessionID string) string {
+	if userID != nil {
+		return userID.Hex()
+	}
+	return sessionID
+}
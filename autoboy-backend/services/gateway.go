@@ -0,0 +1,230 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"autoboy-backend/models"
+)
+
+var (
+	ErrGatewayNotConfigured = errors.New("payment gateway not configured")
+	ErrInvalidSignature     = errors.New("invalid webhook signature")
+	// ErrGatewayRejected means the gateway refused the request outright, so
+	// nothing happened there; timeouts and server errors leave that unknown
+	ErrGatewayRejected = errors.New("rejected by the payment gateway")
+	ErrGatewayNotFound = fmt.Errorf("%w: not found", ErrGatewayRejected)
+)
+
+// WebhookEventKind is the gateway independent meaning of a webhook event
+type WebhookEventKind string
+
+const (
+	WebhookChargeSuccess       WebhookEventKind = "charge_success"
+	WebhookChargeFailed        WebhookEventKind = "charge_failed"
+	WebhookRefundProcessed     WebhookEventKind = "refund_processed"
+	WebhookTransferSuccess     WebhookEventKind = "transfer_success"
+	WebhookTransferFailed      WebhookEventKind = "transfer_failed"
+	WebhookSubscriptionCreate  WebhookEventKind = "subscription_create"
+	WebhookSubscriptionDisable WebhookEventKind = "subscription_disable"
+)
+
+// PaymentGateway is implemented by every payment provider adapter.
+// Amounts are always in major currency units (naira, dollars).
+type PaymentGateway interface {
+	Name() models.PaymentGateway
+	// Supports reports whether the gateway can charge in the currency
+	Supports(currency string) bool
+	// Fee estimates the gateway charge for a payment of amount
+	Fee(amount float64, currency string) float64
+
+	Initialize(ctx context.Context, req GatewayInitializeRequest) (*GatewayInitializeResult, error)
+	Verify(ctx context.Context, reference string) (*GatewayVerifyResult, error)
+	Refund(ctx context.Context, req GatewayRefundRequest) (*GatewayRefundResult, error)
+	Transfer(ctx context.Context, req GatewayTransferRequest) (*GatewayTransferResult, error)
+
+	// VerifyWebhook checks the signature headers against the raw body
+	VerifyWebhook(headers http.Header, body []byte) bool
+	// ParseWebhook normalises a raw webhook body
+	ParseWebhook(body []byte) (*WebhookEvent, error)
+}
+
+// AccountResolver is implemented by gateways that can look up the name on a
+// bank account before money is sent to it
+type AccountResolver interface {
+	ResolveAccount(ctx context.Context, bankCode, accountNumber string) (string, error)
+}
+
+// TransferVerifier is implemented by gateways that can look up a transfer by
+// our reference, so one whose outcome never arrived can still be settled
+type TransferVerifier interface {
+	VerifyTransfer(ctx context.Context, reference string) (*GatewayTransferResult, error)
+}
+
+type GatewayInitializeRequest struct {
+	Reference   string
+	Amount      float64
+	Currency    string
+	Email       string
+	CallbackURL string
+	Metadata    map[string]interface{}
+}
+
+type GatewayInitializeResult struct {
+	Reference        string `json:"reference"`
+	AuthorizationURL string `json:"checkout_url"`
+	AccessCode       string `json:"access_code,omitempty"`
+	GatewayPaymentID string `json:"gateway_payment_id,omitempty"`
+}
+
+type GatewayVerifyResult struct {
+	Reference        string
+	GatewayPaymentID string
+	Status           models.PaymentStatus
+	Amount           float64
+	Currency         string
+	Fee              float64
+	Channel          string
+	CustomerEmail    string
+	Metadata         map[string]interface{}
+}
+
+type GatewayRefundRequest struct {
+	Reference        string
+	GatewayPaymentID string
+	Amount           float64
+	Currency         string
+	Reason           string
+}
+
+type GatewayRefundResult struct {
+	RefundID string
+	Status   string
+	Amount   float64
+}
+
+type GatewayTransferRequest struct {
+	Reference     string
+	Amount        float64
+	Currency      string
+	BankCode      string
+	AccountNumber string
+	AccountName   string
+	Reason        string
+}
+
+type GatewayTransferResult struct {
+	Reference    string
+	TransferCode string
+	Status       string
+	Fee          float64
+}
+
+// WebhookEvent is a parsed webhook with the fields our handlers need
+type WebhookEvent struct {
+	Event         string
+	EventID       string
+	Kind          WebhookEventKind
+	Reference     string
+	GatewayID     string
+	RefundID      string // the gateway's id for the refund, on refund events
+	Amount        float64
+	Fee           float64
+	Currency      string
+	Channel       string
+	CustomerEmail string
+	Reason        string
+	Data          map[string]interface{}
+	Payload       map[string]interface{}
+}
+
+// gatewayClient is the JSON-over-HTTP helper shared by the adapters
+type gatewayClient struct {
+	baseURL string
+	headers map[string]string
+	http    *http.Client
+}
+
+func newGatewayClient(baseURL string, headers map[string]string) *gatewayClient {
+	return &gatewayClient{
+		baseURL: baseURL,
+		headers: headers,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *gatewayClient) do(ctx context.Context, method, path string, body interface{}) (map[string]interface{}, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	return c.send(req)
+}
+
+func (c *gatewayClient) send(req *http.Request) (map[string]interface{}, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("invalid gateway response (HTTP %d)", resp.StatusCode)
+	}
+
+	if resp.StatusCode >= 400 {
+		message := mapString(result, "message")
+		if message == "" {
+			message = mapString(mapMap(result, "error"), "message")
+		}
+		err := fmt.Errorf("gateway error (HTTP %d): %s", resp.StatusCode, message)
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return result, fmt.Errorf("%w: %v", ErrGatewayNotFound, err)
+		case resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout &&
+			resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusTooManyRequests:
+			return result, fmt.Errorf("%w: %v", ErrGatewayRejected, err)
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// toMinor converts a major unit amount to the smallest currency unit
+func toMinor(amount float64) int64 {
+	return int64(amount*100 + 0.5)
+}
+
+func payloadFromBody(body []byte) (map[string]interface{}, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	return payload, nil
+}
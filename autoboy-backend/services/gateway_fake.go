@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+)
+
+// FakeGateway is an in-process gateway for tests and local development.
+// Every initialized payment succeeds on verify unless it was marked failed,
+// and webhooks are signed with a shared secret.
+type FakeGateway struct {
+	secret    string
+	mu        sync.Mutex
+	payments  map[string]*GatewayVerifyResult
+	accounts  map[string]string
+	transfers map[string]bool
+	sent      map[string]*GatewayTransferResult
+	seq       int
+}
+
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{
+		secret:    secret,
+		payments:  make(map[string]*GatewayVerifyResult),
+		accounts:  make(map[string]string),
+		transfers: make(map[string]bool),
+		sent:      make(map[string]*GatewayTransferResult),
+	}
+}
+
+func (g *FakeGateway) Name() models.PaymentGateway {
+	return models.PaymentGatewayFake
+}
+
+func (g *FakeGateway) Supports(currency string) bool {
+	return true
+}
+
+func (g *FakeGateway) Fee(amount float64, currency string) float64 {
+	return utils.RoundCurrency(amount * 0.01)
+}
+
+func (g *FakeGateway) Initialize(ctx context.Context, req GatewayInitializeRequest) (*GatewayInitializeResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	id := fmt.Sprintf("fake_%d", g.seq)
+	g.payments[req.Reference] = &GatewayVerifyResult{
+		Reference:        req.Reference,
+		GatewayPaymentID: id,
+		Status:           models.PaymentStatusPaid,
+		Amount:           req.Amount,
+		Currency:         req.Currency,
+		Fee:              g.Fee(req.Amount, req.Currency),
+		Channel:          "card",
+		CustomerEmail:    req.Email,
+		Metadata:         req.Metadata,
+	}
+
+	return &GatewayInitializeResult{
+		Reference:        req.Reference,
+		AuthorizationURL: utils.GetEnv("FRONTEND_URL", "http://localhost:3000") + "/payment/fake?reference=" + req.Reference,
+		AccessCode:       id,
+		GatewayPaymentID: id,
+	}, nil
+}
+
+func (g *FakeGateway) Verify(ctx context.Context, reference string) (*GatewayVerifyResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payment, ok := g.payments[reference]
+	if !ok {
+		return nil, fmt.Errorf("unknown reference %s", reference)
+	}
+	result := *payment
+	return &result, nil
+}
+
+// Fail makes the next verify of reference report a failed payment
+func (g *FakeGateway) Fail(reference string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if payment, ok := g.payments[reference]; ok {
+		payment.Status = models.PaymentStatusFailed
+	}
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, req GatewayRefundRequest) (*GatewayRefundResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payment, ok := g.payments[req.Reference]
+	if !ok {
+		return nil, fmt.Errorf("unknown reference %s", req.Reference)
+	}
+	amount := req.Amount
+	if amount == 0 {
+		amount = payment.Amount
+	}
+	if amount >= payment.Amount {
+		payment.Status = models.PaymentStatusRefunded
+	}
+
+	g.seq++
+	return &GatewayRefundResult{
+		RefundID: fmt.Sprintf("fake_refund_%d", g.seq),
+		Status:   "processed",
+		Amount:   amount,
+	}, nil
+}
+
+func (g *FakeGateway) Transfer(ctx context.Context, req GatewayTransferRequest) (*GatewayTransferResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.transfers[req.AccountNumber] {
+		return nil, fmt.Errorf("%w: transfer to %s declined", ErrGatewayRejected, req.AccountNumber)
+	}
+
+	g.seq++
+	result := &GatewayTransferResult{
+		Reference:    req.Reference,
+		TransferCode: fmt.Sprintf("fake_transfer_%d", g.seq),
+		Status:       "pending",
+	}
+	g.sent[req.Reference] = result
+	return result, nil
+}
+
+func (g *FakeGateway) VerifyTransfer(ctx context.Context, reference string) (*GatewayTransferResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	result, ok := g.sent[reference]
+	if !ok {
+		return nil, fmt.Errorf("%w: transfer %s", ErrGatewayNotFound, reference)
+	}
+	copied := *result
+	return &copied, nil
+}
+
+// SettleTransfer sets the status VerifyTransfer reports for a transfer
+func (g *FakeGateway) SettleTransfer(reference, status string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if result, ok := g.sent[reference]; ok {
+		result.Status = status
+	}
+}
+
+// DeclineTransfers makes every transfer to accountNumber fail
+func (g *FakeGateway) DeclineTransfers(accountNumber string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.transfers[accountNumber] = true
+}
+
+// SetAccountName registers the name ResolveAccount returns for an account
+func (g *FakeGateway) SetAccountName(bankCode, accountNumber, name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.accounts[bankCode+"/"+accountNumber] = name
+}
+
+// ResolveAccount returns the registered name, or a placeholder for any
+// ten-digit account number
+func (g *FakeGateway) ResolveAccount(ctx context.Context, bankCode, accountNumber string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if name, ok := g.accounts[bankCode+"/"+accountNumber]; ok {
+		return name, nil
+	}
+	if len(accountNumber) != 10 {
+		return "", fmt.Errorf("could not resolve account %s", accountNumber)
+	}
+	return "TEST ACCOUNT " + accountNumber[6:], nil
+}
+
+// Sign returns the X-Fake-Signature value for a webhook body
+func (g *FakeGateway) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(g.secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *FakeGateway) VerifyWebhook(headers http.Header, body []byte) bool {
+	signature := headers.Get("X-Fake-Signature")
+	if g.secret == "" || signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(g.Sign(body)), []byte(signature))
+}
+
+// ParseWebhook accepts {"id", "event", "data": {...}} where event is one of
+// the WebhookEventKind values and data carries reference, amount and currency
+func (g *FakeGateway) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	payload, err := payloadFromBody(body)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(payload, "data")
+
+	event := &WebhookEvent{
+		Event:         mapString(payload, "event"),
+		EventID:       mapID(payload, "id"),
+		Kind:          WebhookEventKind(mapString(payload, "event")),
+		Reference:     mapString(data, "reference"),
+		GatewayID:     mapID(data, "id"),
+		RefundID:      mapID(data, "refund_id"),
+		Amount:        mapFloat(data, "amount"),
+		Fee:           mapFloat(data, "fee"),
+		Currency:      mapString(data, "currency"),
+		Channel:       "card",
+		CustomerEmail: mapString(data, "email"),
+		Reason:        mapString(data, "reason"),
+		Data:          data,
+		Payload:       payload,
+	}
+	if event.EventID == "" {
+		event.EventID = webhookEventID(event.Event, data, body)
+	}
+	return event, nil
+}
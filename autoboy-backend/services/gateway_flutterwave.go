@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+)
+
+// FlutterwaveGateway talks to the Flutterwave v3 API
+type FlutterwaveGateway struct {
+	webhookHash string
+	client      *gatewayClient
+}
+
+func NewFlutterwaveGateway(secretKey, webhookHash string) *FlutterwaveGateway {
+	return &FlutterwaveGateway{
+		webhookHash: webhookHash,
+		client: newGatewayClient("https://api.flutterwave.com/v3", map[string]string{
+			"Authorization": "Bearer " + secretKey,
+		}),
+	}
+}
+
+func (g *FlutterwaveGateway) Name() models.PaymentGateway {
+	return models.PaymentGatewayFlutterwave
+}
+
+func (g *FlutterwaveGateway) Supports(currency string) bool {
+	switch currency {
+	case "NGN", "GHS", "KES", "UGX", "TZS", "ZAR", "XAF", "XOF", "USD", "GBP", "EUR", "CAD":
+		return true
+	}
+	return false
+}
+
+// Fee is 1.4% capped at ₦2,000 for local payments and 3.8% otherwise
+func (g *FlutterwaveGateway) Fee(amount float64, currency string) float64 {
+	if currency == "NGN" {
+		return utils.RoundCurrency(math.Min(amount*0.014, 2000))
+	}
+	return utils.RoundCurrency(amount * 0.038)
+}
+
+func (g *FlutterwaveGateway) Initialize(ctx context.Context, req GatewayInitializeRequest) (*GatewayInitializeResult, error) {
+	payload := map[string]interface{}{
+		"tx_ref":       req.Reference,
+		"amount":       req.Amount,
+		"currency":     req.Currency,
+		"redirect_url": req.CallbackURL,
+		"customer":     map[string]interface{}{"email": req.Email},
+		"meta":         req.Metadata,
+	}
+
+	result, err := g.client.do(ctx, http.MethodPost, "/payments", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GatewayInitializeResult{
+		Reference:        req.Reference,
+		AuthorizationURL: mapString(mapMap(result, "data"), "link"),
+	}, nil
+}
+
+func (g *FlutterwaveGateway) Verify(ctx context.Context, reference string) (*GatewayVerifyResult, error) {
+	result, err := g.client.do(ctx, http.MethodGet, "/transactions/verify_by_reference?tx_ref="+url.QueryEscape(reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayVerifyResult{
+		Reference:        mapString(data, "tx_ref"),
+		GatewayPaymentID: mapID(data, "id"),
+		Status:           flutterwaveStatus(mapString(data, "status")),
+		Amount:           mapFloat(data, "amount"),
+		Currency:         mapString(data, "currency"),
+		Fee:              mapFloat(data, "app_fee"),
+		Channel:          mapString(data, "payment_type"),
+		CustomerEmail:    mapString(mapMap(data, "customer"), "email"),
+		Metadata:         mapMap(data, "meta"),
+	}, nil
+}
+
+// Refund needs the Flutterwave transaction id; it is looked up from the
+// reference when the caller does not have it
+func (g *FlutterwaveGateway) Refund(ctx context.Context, req GatewayRefundRequest) (*GatewayRefundResult, error) {
+	transactionID := req.GatewayPaymentID
+	if transactionID == "" {
+		verified, err := g.Verify(ctx, req.Reference)
+		if err != nil {
+			return nil, err
+		}
+		transactionID = verified.GatewayPaymentID
+	}
+
+	payload := map[string]interface{}{}
+	if req.Amount > 0 {
+		payload["amount"] = req.Amount
+	}
+
+	result, err := g.client.do(ctx, http.MethodPost, "/transactions/"+transactionID+"/refund", payload)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayRefundResult{
+		RefundID: mapID(data, "id"),
+		Status:   mapString(data, "status"),
+		Amount:   mapFloat(data, "amount_refunded"),
+	}, nil
+}
+
+func (g *FlutterwaveGateway) Transfer(ctx context.Context, req GatewayTransferRequest) (*GatewayTransferResult, error) {
+	result, err := g.client.do(ctx, http.MethodPost, "/transfers", map[string]interface{}{
+		"account_bank":     req.BankCode,
+		"account_number":   req.AccountNumber,
+		"amount":           req.Amount,
+		"currency":         req.Currency,
+		"narration":        req.Reason,
+		"reference":        req.Reference,
+		"beneficiary_name": req.AccountName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayTransferResult{
+		Reference:    req.Reference,
+		TransferCode: mapID(data, "id"),
+		Status:       strings.ToLower(mapString(data, "status")),
+		Fee:          mapFloat(data, "fee"),
+	}, nil
+}
+
+// ResolveAccount returns the account holder's name for a bank account
+func (g *FlutterwaveGateway) ResolveAccount(ctx context.Context, bankCode, accountNumber string) (string, error) {
+	result, err := g.client.do(ctx, http.MethodPost, "/accounts/resolve", map[string]interface{}{
+		"account_number": accountNumber,
+		"account_bank":   bankCode,
+	})
+	if err != nil {
+		return "", err
+	}
+	return mapString(mapMap(result, "data"), "account_name"), nil
+}
+
+// VerifyWebhook compares the verif-hash header with the configured secret hash
+func (g *FlutterwaveGateway) VerifyWebhook(headers http.Header, body []byte) bool {
+	hash := headers.Get("verif-hash")
+	if g.webhookHash == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(g.webhookHash)) == 1
+}
+
+func (g *FlutterwaveGateway) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	payload, err := payloadFromBody(body)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(payload, "data")
+	status := strings.ToLower(mapString(data, "status"))
+
+	event := &WebhookEvent{
+		Event:         mapString(payload, "event"),
+		Reference:     mapString(data, "tx_ref"),
+		GatewayID:     mapID(data, "id"),
+		Amount:        mapFloat(data, "amount"),
+		Fee:           mapFloat(data, "app_fee"),
+		Currency:      mapString(data, "currency"),
+		Channel:       mapString(data, "payment_type"),
+		CustomerEmail: mapString(mapMap(data, "customer"), "email"),
+		Reason:        mapString(data, "complete_message"),
+		Data:          data,
+		Payload:       payload,
+	}
+
+	switch event.Event {
+	case "charge.completed":
+		if status == "successful" {
+			event.Kind = WebhookChargeSuccess
+		} else {
+			event.Kind = WebhookChargeFailed
+		}
+	case "transfer.completed":
+		event.Reference = mapString(data, "reference")
+		event.Fee = mapFloat(data, "fee")
+		if status == "successful" {
+			event.Kind = WebhookTransferSuccess
+		} else {
+			event.Kind = WebhookTransferFailed
+		}
+	case "refund.completed":
+		event.Kind = WebhookRefundProcessed
+		event.RefundID = event.GatewayID
+		event.GatewayID = mapID(data, "transaction_id")
+		event.Reference = mapString(data, "tx_ref")
+		event.Amount = mapFloat(data, "amount_refunded")
+	}
+
+	eventObject := event.GatewayID
+	if event.RefundID != "" {
+		// Several refunds can be made against one transaction
+		eventObject = event.RefundID
+	}
+	event.EventID = fmt.Sprintf("%s:%s:%s", event.Event, eventObject, status)
+	if event.GatewayID == "" {
+		event.EventID = webhookEventID(event.Event, data, body)
+	}
+	return event, nil
+}
+
+func flutterwaveStatus(status string) models.PaymentStatus {
+	switch strings.ToLower(status) {
+	case "successful":
+		return models.PaymentStatusPaid
+	case "failed":
+		return models.PaymentStatusFailed
+	case "cancelled":
+		return models.PaymentStatusCancelled
+	}
+	return models.PaymentStatusPending
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+)
+
+// PaystackGateway talks to the Paystack API
+type PaystackGateway struct {
+	secretKey string
+	client    *gatewayClient
+}
+
+func NewPaystackGateway(secretKey string) *PaystackGateway {
+	return &PaystackGateway{
+		secretKey: secretKey,
+		client: newGatewayClient("https://api.paystack.co", map[string]string{
+			"Authorization": "Bearer " + secretKey,
+		}),
+	}
+}
+
+func (g *PaystackGateway) Name() models.PaymentGateway {
+	return models.PaymentGatewayPaystack
+}
+
+func (g *PaystackGateway) Supports(currency string) bool {
+	switch currency {
+	case "NGN", "GHS", "ZAR", "KES", "USD":
+		return true
+	}
+	return false
+}
+
+// Fee is 1.5% + ₦100 capped at ₦2,000 for local payments; the flat part is
+// waived under ₦2,500. Other currencies are charged 3.9% + 100.
+func (g *PaystackGateway) Fee(amount float64, currency string) float64 {
+	if currency != "NGN" {
+		return utils.RoundCurrency(amount*0.039 + 100)
+	}
+	fee := amount * 0.015
+	if amount >= 2500 {
+		fee += 100
+	}
+	return utils.RoundCurrency(math.Min(fee, 2000))
+}
+
+func (g *PaystackGateway) Initialize(ctx context.Context, req GatewayInitializeRequest) (*GatewayInitializeResult, error) {
+	payload := map[string]interface{}{
+		"email":     req.Email,
+		"amount":    toMinor(req.Amount),
+		"reference": req.Reference,
+		"currency":  req.Currency,
+		"metadata":  req.Metadata,
+	}
+	if req.CallbackURL != "" {
+		payload["callback_url"] = req.CallbackURL
+	}
+
+	result, err := g.client.do(ctx, http.MethodPost, "/transaction/initialize", payload)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayInitializeResult{
+		Reference:        mapString(data, "reference"),
+		AuthorizationURL: mapString(data, "authorization_url"),
+		AccessCode:       mapString(data, "access_code"),
+	}, nil
+}
+
+func (g *PaystackGateway) Verify(ctx context.Context, reference string) (*GatewayVerifyResult, error) {
+	result, err := g.client.do(ctx, http.MethodGet, "/transaction/verify/"+reference, nil)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	status := models.PaymentStatusPending
+	switch mapString(data, "status") {
+	case "success":
+		status = models.PaymentStatusPaid
+	case "failed", "reversed":
+		status = models.PaymentStatusFailed
+	case "abandoned":
+		status = models.PaymentStatusCancelled
+	}
+
+	return &GatewayVerifyResult{
+		Reference:        mapString(data, "reference"),
+		GatewayPaymentID: mapID(data, "id"),
+		Status:           status,
+		Amount:           mapFloat(data, "amount") / 100,
+		Currency:         mapString(data, "currency"),
+		Fee:              mapFloat(data, "fees") / 100,
+		Channel:          mapString(data, "channel"),
+		CustomerEmail:    mapString(mapMap(data, "customer"), "email"),
+		Metadata:         mapMap(data, "metadata"),
+	}, nil
+}
+
+func (g *PaystackGateway) Refund(ctx context.Context, req GatewayRefundRequest) (*GatewayRefundResult, error) {
+	payload := map[string]interface{}{
+		"transaction": req.Reference,
+	}
+	if req.Amount > 0 {
+		payload["amount"] = toMinor(req.Amount)
+	}
+	if req.Reason != "" {
+		payload["merchant_note"] = req.Reason
+	}
+
+	result, err := g.client.do(ctx, http.MethodPost, "/refund", payload)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayRefundResult{
+		RefundID: mapID(data, "id"),
+		Status:   mapString(data, "status"),
+		Amount:   mapFloat(data, "amount") / 100,
+	}, nil
+}
+
+// Transfer creates a transfer recipient for the bank account and pays it
+func (g *PaystackGateway) Transfer(ctx context.Context, req GatewayTransferRequest) (*GatewayTransferResult, error) {
+	recipient, err := g.client.do(ctx, http.MethodPost, "/transferrecipient", map[string]interface{}{
+		"type":           "nuban",
+		"name":           req.AccountName,
+		"account_number": req.AccountNumber,
+		"bank_code":      req.BankCode,
+		"currency":       req.Currency,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer recipient: %w", err)
+	}
+	recipientCode := mapString(mapMap(recipient, "data"), "recipient_code")
+
+	result, err := g.client.do(ctx, http.MethodPost, "/transfer", map[string]interface{}{
+		"source":    "balance",
+		"amount":    toMinor(req.Amount),
+		"recipient": recipientCode,
+		"reason":    req.Reason,
+		"reference": req.Reference,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayTransferResult{
+		Reference:    req.Reference,
+		TransferCode: mapString(data, "transfer_code"),
+		Status:       mapString(data, "status"),
+	}, nil
+}
+
+// VerifyTransfer looks up a transfer by our reference
+func (g *PaystackGateway) VerifyTransfer(ctx context.Context, reference string) (*GatewayTransferResult, error) {
+	result, err := g.client.do(ctx, http.MethodGet, "/transfer/verify/"+url.PathEscape(reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(result, "data")
+
+	return &GatewayTransferResult{
+		Reference:    reference,
+		TransferCode: mapString(data, "transfer_code"),
+		Status:       mapString(data, "status"),
+	}, nil
+}
+
+// ResolveAccount returns the account holder's name for a NUBAN account
+func (g *PaystackGateway) ResolveAccount(ctx context.Context, bankCode, accountNumber string) (string, error) {
+	query := url.Values{}
+	query.Set("account_number", accountNumber)
+	query.Set("bank_code", bankCode)
+
+	result, err := g.client.do(ctx, http.MethodGet, "/bank/resolve?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	return mapString(mapMap(result, "data"), "account_name"), nil
+}
+
+// VerifyWebhook checks x-paystack-signature, the HMAC-SHA512 of the raw
+// body keyed with the secret key
+func (g *PaystackGateway) VerifyWebhook(headers http.Header, body []byte) bool {
+	signature := headers.Get("x-paystack-signature")
+	if g.secretKey == "" || signature == "" {
+		return false
+	}
+	mac := hmac.New(sha512.New, []byte(g.secretKey))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (g *PaystackGateway) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	payload, err := payloadFromBody(body)
+	if err != nil {
+		return nil, err
+	}
+	data := mapMap(payload, "data")
+
+	event := &WebhookEvent{
+		Event:         mapString(payload, "event"),
+		Reference:     mapString(data, "reference"),
+		GatewayID:     mapID(data, "id"),
+		Amount:        mapFloat(data, "amount") / 100,
+		Fee:           mapFloat(data, "fees") / 100,
+		Currency:      mapString(data, "currency"),
+		Channel:       mapString(data, "channel"),
+		CustomerEmail: mapString(mapMap(data, "customer"), "email"),
+		Reason:        mapString(data, "reason"),
+		Data:          data,
+		Payload:       payload,
+	}
+
+	switch event.Event {
+	case "charge.success":
+		event.Kind = WebhookChargeSuccess
+	case "refund.processed":
+		event.Kind = WebhookRefundProcessed
+		// data is the refund; the payment is found through its transaction
+		event.RefundID = event.GatewayID
+		event.GatewayID = mapID(mapMap(data, "transaction"), "id")
+		event.Reference = mapString(data, "transaction_reference")
+		if event.Reference == "" {
+			event.Reference = mapString(mapMap(data, "transaction"), "reference")
+		}
+	case "transfer.success":
+		event.Kind = WebhookTransferSuccess
+	case "transfer.failed", "transfer.reversed":
+		event.Kind = WebhookTransferFailed
+	case "subscription.create":
+		event.Kind = WebhookSubscriptionCreate
+	case "subscription.disable":
+		event.Kind = WebhookSubscriptionDisable
+	}
+
+	// Paystack does not send an event id, so the event type and the gateway
+	// object id are used for deduplication
+	event.EventID = webhookEventID(event.Event, data, body)
+	return event, nil
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+)
+
+// stripeSignatureTolerance is how old a signed webhook may be
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeGateway uses Stripe Checkout for international card payments
+type StripeGateway struct {
+	webhookSecret string
+	successURL    string
+	cancelURL     string
+	client        *gatewayClient
+}
+
+func NewStripeGateway(secretKey, webhookSecret string) *StripeGateway {
+	frontendURL := utils.GetEnv("FRONTEND_URL", "http://localhost:3000")
+	return &StripeGateway{
+		webhookSecret: webhookSecret,
+		successURL:    frontendURL + "/payment/success",
+		cancelURL:     frontendURL + "/payment/cancelled",
+		client: newGatewayClient("https://api.stripe.com/v1", map[string]string{
+			"Authorization": "Bearer " + secretKey,
+		}),
+	}
+}
+
+func (g *StripeGateway) Name() models.PaymentGateway {
+	return models.PaymentGatewayStripe
+}
+
+func (g *StripeGateway) Supports(currency string) bool {
+	switch currency {
+	case "USD", "GBP", "EUR", "CAD", "AUD":
+		return true
+	}
+	return false
+}
+
+// Fee is 2.9% + 0.30 per successful card charge
+func (g *StripeGateway) Fee(amount float64, currency string) float64 {
+	return utils.RoundCurrency(amount*0.029 + 0.30)
+}
+
+func (g *StripeGateway) Initialize(ctx context.Context, req GatewayInitializeRequest) (*GatewayInitializeResult, error) {
+	successURL := g.successURL
+	if req.CallbackURL != "" {
+		successURL = req.CallbackURL
+	}
+
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("success_url", successURL)
+	form.Set("cancel_url", g.cancelURL)
+	form.Set("customer_email", req.Email)
+	form.Set("client_reference_id", req.Reference)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(req.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(toMinor(req.Amount), 10))
+	form.Set("line_items[0][price_data][product_data][name]", "AutoBoy payment "+req.Reference)
+	form.Set("payment_intent_data[metadata][reference]", req.Reference)
+	for k, v := range req.Metadata {
+		form.Set(fmt.Sprintf("payment_intent_data[metadata][%s]", k), fmt.Sprintf("%v", v))
+	}
+
+	result, err := g.form(ctx, http.MethodPost, "/checkout/sessions", form)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GatewayInitializeResult{
+		Reference:        req.Reference,
+		AuthorizationURL: mapString(result, "url"),
+		AccessCode:       mapString(result, "id"),
+	}, nil
+}
+
+// Verify finds the payment intent carrying our reference in its metadata
+func (g *StripeGateway) Verify(ctx context.Context, reference string) (*GatewayVerifyResult, error) {
+	intent, err := g.findIntent(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := mapFloat(intent, "amount_received") / 100
+	currency := strings.ToUpper(mapString(intent, "currency"))
+	result := &GatewayVerifyResult{
+		Reference:        reference,
+		GatewayPaymentID: mapString(intent, "id"),
+		Status:           stripeIntentStatus(mapString(intent, "status")),
+		Amount:           amount,
+		Currency:         currency,
+		Channel:          "card",
+		CustomerEmail:    mapString(intent, "receipt_email"),
+		Metadata:         mapMap(intent, "metadata"),
+	}
+	if result.Status == models.PaymentStatusPaid {
+		result.Fee = g.Fee(amount, currency)
+	}
+	return result, nil
+}
+
+func (g *StripeGateway) Refund(ctx context.Context, req GatewayRefundRequest) (*GatewayRefundResult, error) {
+	intentID := req.GatewayPaymentID
+	if intentID == "" {
+		intent, err := g.findIntent(ctx, req.Reference)
+		if err != nil {
+			return nil, err
+		}
+		intentID = mapString(intent, "id")
+	}
+
+	form := url.Values{}
+	form.Set("payment_intent", intentID)
+	if req.Amount > 0 {
+		form.Set("amount", strconv.FormatInt(toMinor(req.Amount), 10))
+	}
+	form.Set("metadata[reason]", req.Reason)
+
+	result, err := g.form(ctx, http.MethodPost, "/refunds", form)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GatewayRefundResult{
+		RefundID: mapString(result, "id"),
+		Status:   mapString(result, "status"),
+		Amount:   mapFloat(result, "amount") / 100,
+	}, nil
+}
+
+// Transfer is not offered: Stripe cannot pay out to Nigerian bank accounts
+func (g *StripeGateway) Transfer(ctx context.Context, req GatewayTransferRequest) (*GatewayTransferResult, error) {
+	return nil, fmt.Errorf("%w: bank transfers are not supported by %s", ErrGatewayRejected, g.Name())
+}
+
+// VerifyWebhook checks the Stripe-Signature header: an HMAC-SHA256 of
+// "timestamp.body" keyed with the endpoint secret
+func (g *StripeGateway) VerifyWebhook(headers http.Header, body []byte) bool {
+	header := headers.Get("Stripe-Signature")
+	if g.webhookSecret == "" || header == "" {
+		return false
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)) > stripeSignatureTolerance {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(g.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *StripeGateway) ParseWebhook(body []byte) (*WebhookEvent, error) {
+	payload, err := payloadFromBody(body)
+	if err != nil {
+		return nil, err
+	}
+	object := mapMap(mapMap(payload, "data"), "object")
+
+	event := &WebhookEvent{
+		Event:     mapString(payload, "type"),
+		EventID:   mapString(payload, "id"),
+		Reference: mapString(mapMap(object, "metadata"), "reference"),
+		GatewayID: mapString(object, "id"),
+		Currency:  strings.ToUpper(mapString(object, "currency")),
+		Channel:   "card",
+		Data:      object,
+		Payload:   payload,
+	}
+
+	switch event.Event {
+	case "payment_intent.succeeded":
+		event.Kind = WebhookChargeSuccess
+		event.Amount = mapFloat(object, "amount_received") / 100
+		event.Fee = g.Fee(event.Amount, event.Currency)
+		event.CustomerEmail = mapString(object, "receipt_email")
+	case "payment_intent.payment_failed":
+		event.Kind = WebhookChargeFailed
+		event.Amount = mapFloat(object, "amount") / 100
+		event.Reason = mapString(mapMap(object, "last_payment_error"), "message")
+	case "charge.refunded":
+		// Charges do not carry the intent metadata, so match on the intent id
+		event.Kind = WebhookRefundProcessed
+		event.GatewayID = mapString(object, "payment_intent")
+		event.Amount = mapFloat(object, "amount_refunded") / 100
+		// amount_refunded is the running total; the newest refund comes first
+		if refunds, ok := mapMap(object, "refunds")["data"].([]interface{}); ok && len(refunds) > 0 {
+			if latest, ok := refunds[0].(map[string]interface{}); ok {
+				event.RefundID = mapString(latest, "id")
+				event.Amount = mapFloat(latest, "amount") / 100
+			}
+		}
+	}
+
+	if event.EventID == "" {
+		event.EventID = webhookEventID(event.Event, object, body)
+	}
+	return event, nil
+}
+
+func (g *StripeGateway) form(ctx context.Context, method, path string, form url.Values) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, method, g.client.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range g.client.headers {
+		req.Header.Set(k, v)
+	}
+	return g.client.send(req)
+}
+
+func (g *StripeGateway) findIntent(ctx context.Context, reference string) (map[string]interface{}, error) {
+	query := url.QueryEscape(fmt.Sprintf("metadata['reference']:'%s'", reference))
+	result, err := g.client.do(ctx, http.MethodGet, "/payment_intents/search?query="+query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	intents, _ := result["data"].([]interface{})
+	if len(intents) == 0 {
+		return nil, fmt.Errorf("no stripe payment found for reference %s", reference)
+	}
+	intent, _ := intents[0].(map[string]interface{})
+	return intent, nil
+}
+
+func stripeIntentStatus(status string) models.PaymentStatus {
+	switch status {
+	case "succeeded":
+		return models.PaymentStatusPaid
+	case "canceled":
+		return models.PaymentStatusCancelled
+	}
+	return models.PaymentStatusPending
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrStockUnavailable means an order was paid after its reservation lapsed
+// and the stock has since been sold to someone else
+var ErrStockUnavailable = errors.New("stock is no longer available for this order")
+
+// InventoryService reserves product stock for orders. Stock is only ever
+// taken with a conditional decrement, so concurrent checkouts cannot sell
+// more than is left; unpaid reservations expire and give the stock back.
+// Selling the last unit takes a listing out of search and restocking puts
+// it back, so callers reindex the products once their transaction commits.
+type InventoryService struct {
+	search    *SearchService
+	ttl       time.Duration
+	batchSize int
+}
+
+func NewInventoryService(search *SearchService) *InventoryService {
+	return &InventoryService{
+		search:    search,
+		ttl:       time.Duration(utils.GetEnvAsInt("STOCK_RESERVATION_TTL_MINUTES", 30)) * time.Minute,
+		batchSize: utils.GetEnvAsInt("STOCK_RESERVATION_BATCH_SIZE", 100),
+	}
+}
+
+// Reindex brings the search listings of products up to date after their
+// stock was taken or returned
+func (s *InventoryService) Reindex(ctx context.Context, productIDs []primitive.ObjectID) {
+	s.search.IndexProducts(ctx, productIDs)
+}
+
+// Reserve takes the stock for each order in the caller's transaction and
+// records a reservation for it. Paid orders are committed straight away;
+// others hold the stock until the reservation expires.
+func (s *InventoryService) Reserve(sc mongo.SessionContext, orders []*models.Order, paid bool) error {
+	now := time.Now()
+	for _, order := range orders {
+		reservation := models.StockReservation{
+			ID:         primitive.NewObjectID(),
+			OrderID:    order.ID,
+			CheckoutID: order.CheckoutID,
+			BuyerID:    order.BuyerID,
+			Status:     models.ReservationStatusActive,
+			ExpiresAt:  now.Add(s.ttl),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if paid {
+			reservation.Status = models.ReservationStatusCommitted
+			reservation.CommittedAt = &now
+		}
+
+		for _, item := range order.Items {
+			if err := takeStock(sc, item.ProductID, item.Quantity); err != nil {
+				return fmt.Errorf("%w: %s", err, item.ProductTitle)
+			}
+			reservation.Items = append(reservation.Items, models.ReservedItem{
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+			})
+		}
+
+		if _, err := config.Coll.StockReservations.InsertOne(sc, reservation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit marks an order's reservation as sold once the order is paid. If the
+// reservation had already been released the stock is taken again; when that
+// is no longer possible it returns ErrStockUnavailable so the caller can
+// refund the buyer.
+func (s *InventoryService) Commit(ctx context.Context, orderID primitive.ObjectID) error {
+	now := time.Now()
+	result, err := config.Coll.StockReservations.UpdateOne(ctx, bson.M{
+		"order_id": orderID,
+		"status":   models.ReservationStatusActive,
+	}, bson.M{"$set": bson.M{
+		"status":       models.ReservationStatusCommitted,
+		"committed_at": now,
+		"updated_at":   now,
+	}})
+	if err != nil || result.MatchedCount > 0 {
+		return err
+	}
+
+	var reservation models.StockReservation
+	err = config.Coll.StockReservations.FindOne(ctx, bson.M{
+		"order_id": orderID,
+		"status":   models.ReservationStatusReleased,
+	}).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		// Already committed, or an order placed before reservations existed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		for _, item := range reservation.Items {
+			if err := takeStock(sc, item.ProductID, item.Quantity); err != nil {
+				return err
+			}
+		}
+		_, err := config.Coll.StockReservations.UpdateOne(sc, bson.M{
+			"_id":    reservation.ID,
+			"status": models.ReservationStatusReleased,
+		}, bson.M{"$set": bson.M{
+			"status":       models.ReservationStatusCommitted,
+			"committed_at": now,
+			"updated_at":   now,
+		}})
+		return err
+	})
+	if errors.Is(err, ErrInsufficientStock) {
+		log.Printf("Order %s was paid after its stock was released and sold: %v", orderID.Hex(), err)
+		return ErrStockUnavailable
+	}
+	if err != nil {
+		return err
+	}
+	s.Reindex(ctx, reservedProductIDs(reservation.Items))
+	return nil
+}
+
+// Release gives back the stock of an order's pending reservation. Paid
+// orders are left alone; their stock comes back through a restocking refund.
+func (s *InventoryService) Release(ctx context.Context, orderID primitive.ObjectID, reason string) error {
+	_, err := s.releaseOne(ctx, orderID, reason)
+	return err
+}
+
+func (s *InventoryService) releaseOne(ctx context.Context, orderID primitive.ObjectID, reason string) (bool, error) {
+	var reservation *models.StockReservation
+	err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		reservation, err = s.release(sc, orderID, reason)
+		return err
+	})
+	if err != nil || reservation == nil {
+		return false, err
+	}
+	s.Reindex(ctx, reservedProductIDs(reservation.Items))
+	return true, nil
+}
+
+// ReleaseExpired releases reservations whose payment window has passed and
+// returns their orders, which the caller cancels if they are still unpaid
+func (s *InventoryService) ReleaseExpired(ctx context.Context) ([]primitive.ObjectID, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "expires_at", Value: 1}}).
+		SetLimit(int64(s.batchSize))
+
+	cursor, err := config.Coll.StockReservations.Find(ctx, bson.M{
+		"status":     models.ReservationStatusActive,
+		"expires_at": bson.M{"$lte": time.Now()},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var reservations []models.StockReservation
+	if err := cursor.All(ctx, &reservations); err != nil {
+		return nil, err
+	}
+
+	var released []primitive.ObjectID
+	for _, reservation := range reservations {
+		ok, err := s.releaseOne(ctx, reservation.OrderID, "expired")
+		if err != nil {
+			log.Printf("Failed to release expired reservation for order %s: %v", reservation.OrderID.Hex(), err)
+			continue
+		}
+		if ok {
+			released = append(released, reservation.OrderID)
+		}
+	}
+	return released, nil
+}
+
+// release returns the stock of an active reservation in the caller's
+// transaction and returns the reservation, or nil if there was none
+func (s *InventoryService) release(sc mongo.SessionContext, orderID primitive.ObjectID, reason string) (*models.StockReservation, error) {
+	now := time.Now()
+	var reservation models.StockReservation
+	err := config.Coll.StockReservations.FindOneAndUpdate(sc, bson.M{
+		"order_id": orderID,
+		"status":   models.ReservationStatusActive,
+	}, bson.M{"$set": bson.M{
+		"status":         models.ReservationStatusReleased,
+		"release_reason": reason,
+		"released_at":    now,
+		"updated_at":     now,
+	}}).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range reservation.Items {
+		if err := restockProduct(sc, item.ProductID, item.Quantity); err != nil {
+			return nil, err
+		}
+	}
+	return &reservation, nil
+}
+
+// takeStock decrements a product's quantity only if enough is left, and
+// marks the product sold out when the last unit goes
+func takeStock(ctx context.Context, productID primitive.ObjectID, quantity int) error {
+	var product models.Product
+	err := config.Coll.Products.FindOneAndUpdate(ctx, bson.M{
+		"_id":      productID,
+		"status":   models.ProductStatusActive,
+		"quantity": bson.M{"$gte": quantity},
+	}, bson.M{
+		"$inc": bson.M{"quantity": -quantity},
+		"$set": bson.M{"updated_at": time.Now()},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return ErrInsufficientStock
+	}
+	if err != nil {
+		return err
+	}
+
+	if product.Quantity <= 0 {
+		_, err = config.Coll.Products.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": bson.M{
+			"is_in_stock": false,
+			"status":      models.ProductStatusSold,
+		}})
+	}
+	return err
+}
+
+// restockProduct puts units back on sale, reopening a sold-out listing
+func restockProduct(ctx context.Context, productID primitive.ObjectID, quantity int) error {
+	if _, err := config.Coll.Products.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{
+		"$inc": bson.M{"quantity": quantity},
+		"$set": bson.M{"is_in_stock": true, "updated_at": time.Now()},
+	}); err != nil {
+		return err
+	}
+	_, err := config.Coll.Products.UpdateOne(ctx, bson.M{
+		"_id":    productID,
+		"status": models.ProductStatusSold,
+	}, bson.M{"$set": bson.M{"status": models.ProductStatusActive}})
+	return err
+}
+
+func reservedProductIDs(items []models.ReservedItem) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+	return ids
+}
+
+func orderProductIDs(orders []*models.Order) []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	for _, order := range orders {
+		for _, item := range order.Items {
+			ids = append(ids, item.ProductID)
+		}
+	}
+	return ids
+}
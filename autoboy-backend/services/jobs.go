@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// JobFunc is a unit of periodic background work
+type JobFunc func(ctx context.Context) error
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      JobFunc
+}
+
+// Scheduler runs registered jobs on fixed intervals until stopped
+type Scheduler struct {
+	jobs   []job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var AppScheduler *Scheduler
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Register adds a job. It must be called before Start.
+func (s *Scheduler) Register(name string, interval time.Duration, fn JobFunc) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: fn})
+}
+
+// Start launches one goroutine per registered job
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+		log.Printf("Background job %q scheduled every %s", j.name, j.interval)
+	}
+}
+
+// Stop cancels all jobs and waits for running ones to finish
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx, cancel := context.WithTimeout(ctx, j.interval)
+			if err := j.run(runCtx); err != nil {
+				log.Printf("Background job %q failed: %v", j.name, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// StartBackgroundJobs registers the application's periodic jobs and starts them
+func StartBackgroundJobs() *Scheduler {
+	svc := GetServices()
+	scheduler := NewScheduler()
+
+	escrowInterval := time.Duration(utils.GetEnvAsInt("ESCROW_AUTO_RELEASE_INTERVAL_MINUTES", 15)) * time.Minute
+	scheduler.Register("escrow_auto_release", escrowInterval, func(ctx context.Context) error {
+		orderIDs, err := svc.Escrow.ProcessAutoReleases(ctx)
+		if len(orderIDs) > 0 {
+			log.Printf("Auto-released escrow for %d orders", len(orderIDs))
+		}
+		failed := 0
+		for _, orderID := range orderIDs {
+			_, terr := svc.Order.Transition(ctx, OrderTransition{
+				OrderID: orderID,
+				To:      models.OrderStatusCompleted,
+				Actor:   OrderActorSystem,
+				Note:    "Completed after the escrow holding period",
+			})
+			if terr != nil {
+				// The seller has been paid, so the order must not stay open
+				log.Printf("ALERT: escrow for order %s was released but the order could not be completed: %v", orderID.Hex(), terr)
+				failed++
+			}
+		}
+		if err == nil && failed > 0 {
+			err = fmt.Errorf("%d orders with released escrow could not be completed", failed)
+		}
+		return err
+	})
+
+	payoutInterval := time.Duration(utils.GetEnvAsInt("WITHDRAWAL_PAYOUT_INTERVAL_MINUTES", 30)) * time.Minute
+	scheduler.Register("withdrawal_payouts", payoutInterval, func(ctx context.Context) error {
+		sent, failed, err := svc.Withdrawal.ProcessBatch(ctx)
+		if sent > 0 || failed > 0 {
+			log.Printf("Withdrawal payouts: %d sent, %d failed", sent, failed)
+		}
+		return err
+	})
+
+	reconcileInterval := time.Duration(utils.GetEnvAsInt("WITHDRAWAL_RECONCILE_INTERVAL_MINUTES", 30)) * time.Minute
+	scheduler.Register("withdrawal_transfer_reconcile", reconcileInterval, func(ctx context.Context) error {
+		completed, failed, err := svc.Withdrawal.ReconcileTransfers(ctx)
+		if completed > 0 || failed > 0 {
+			log.Printf("Withdrawal transfers reconciled: %d completed, %d failed", completed, failed)
+		}
+		return err
+	})
+
+	webhookInterval := time.Duration(utils.GetEnvAsInt("WEBHOOK_SWEEP_INTERVAL_MINUTES", 5)) * time.Minute
+	scheduler.Register("webhook_pending_sweep", webhookInterval, func(ctx context.Context) error {
+		processed, failed, err := svc.Webhook.ProcessStalePending(ctx)
+		if processed > 0 || failed > 0 {
+			log.Printf("Stale pending webhooks: %d processed, %d failed", processed, failed)
+		}
+		return err
+	})
+
+	reservationInterval := time.Duration(utils.GetEnvAsInt("STOCK_RESERVATION_SWEEP_INTERVAL_MINUTES", 5)) * time.Minute
+	scheduler.Register("stock_reservation_expiry", reservationInterval, func(ctx context.Context) error {
+		orderIDs, err := svc.Inventory.ReleaseExpired(ctx)
+		if len(orderIDs) > 0 {
+			log.Printf("Released %d expired stock reservations", len(orderIDs))
+		}
+		for _, orderID := range orderIDs {
+			// Orders that were paid in the meantime are no longer pending
+			// and are left alone
+			_, terr := svc.Order.Transition(ctx, OrderTransition{
+				OrderID: orderID,
+				To:      models.OrderStatusCancelled,
+				Actor:   OrderActorSystem,
+				Note:    orderLapsedNote,
+				Set:     bson.M{"payment_status": models.PaymentStatusCancelled},
+			})
+			if terr != nil && !errors.Is(terr, ErrOrderTransition) {
+				log.Printf("Failed to cancel expired order %s: %v", orderID.Hex(), terr)
+			}
+		}
+		return err
+	})
+
+	escrowPendingInterval := time.Duration(utils.GetEnvAsInt("ESCROW_PENDING_SWEEP_INTERVAL_MINUTES", 5)) * time.Minute
+	scheduler.Register("escrow_pending_sweep", escrowPendingInterval, func(ctx context.Context) error {
+		settled, err := svc.Checkout.SettlePendingEscrows(ctx)
+		if settled > 0 {
+			log.Printf("Settled escrow for %d paid orders", settled)
+		}
+		return err
+	})
+
+	refundRetryInterval := time.Duration(utils.GetEnvAsInt("ORDER_REFUND_RETRY_INTERVAL_MINUTES", 15)) * time.Minute
+	scheduler.Register("cancelled_order_refunds", refundRetryInterval, func(ctx context.Context) error {
+		refunded, err := svc.Order.RetryCancelledRefunds(ctx)
+		if refunded > 0 {
+			log.Printf("Refunded %d cancelled orders", refunded)
+		}
+		return err
+	})
+
+	flushInterval := time.Duration(utils.GetEnvAsInt("SEARCH_INDEX_FLUSH_INTERVAL_MINUTES", 1)) * time.Minute
+	scheduler.Register("search_index_flush", flushInterval, func(ctx context.Context) error {
+		return svc.Search.Flush()
+	})
+
+	suggestionInterval := time.Duration(utils.GetEnvAsInt("SEARCH_SUGGESTIONS_INTERVAL_MINUTES", 60)) * time.Minute
+	scheduler.Register("search_suggestions", suggestionInterval, func(ctx context.Context) error {
+		_, err := svc.SearchAnalytics.RefreshSuggestions(ctx)
+		return err
+	})
+
+	digestInterval := time.Duration(utils.GetEnvAsInt("SAVED_SEARCH_DIGEST_INTERVAL_MINUTES", 15)) * time.Minute
+	scheduler.Register("saved_search_digests", digestInterval, func(ctx context.Context) error {
+		notified, err := svc.SavedSearch.RunDigests(ctx)
+		if notified > 0 {
+			log.Printf("Sent saved search digests to %d users", notified)
+		}
+		return err
+	})
+
+	recommendationInterval := time.Duration(utils.GetEnvAsInt("RECOMMENDATIONS_INTERVAL_MINUTES", 360)) * time.Minute
+	scheduler.Register("recommendations", recommendationInterval, func(ctx context.Context) error {
+		stats, err := svc.Recommendation.Recompute(ctx)
+		if stats != nil {
+			log.Printf("Recomputed recommendations: %d also viewed, %d also bought, %d user feeds",
+				stats.AlsoViewed, stats.AlsoBought, stats.Users)
+		}
+		return err
+	})
+
+	rankingInterval := time.Duration(utils.GetEnvAsInt("RANKING_INTERVAL_MINUTES", 30)) * time.Minute
+	scheduler.Register("product_ranking", rankingInterval, func(ctx context.Context) error {
+		stats, err := svc.Ranking.Rank(ctx)
+		if stats != nil {
+			log.Printf("Ranked %d listings: %d hot, %d trending, %d new, %d updated",
+				stats.Ranked, stats.Hot, stats.Trending, stats.New, stats.Updated)
+		}
+		return err
+	})
+
+	rollupInterval := time.Duration(utils.GetEnvAsInt("ANALYTICS_ROLLUP_INTERVAL_MINUTES", 60)) * time.Minute
+	scheduler.Register("product_analytics_rollup", rollupInterval, func(ctx context.Context) error {
+		// Yesterday is rolled up again to pick up events flushed after midnight
+		now := time.Now()
+		for _, day := range []time.Time{now.Add(-24 * time.Hour), now} {
+			if _, err := svc.ProductAnalytics.Rollup(ctx, day); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	lifecycleInterval := time.Duration(utils.GetEnvAsInt("LISTING_LIFECYCLE_INTERVAL_MINUTES", 60)) * time.Minute
+	scheduler.Register("listing_lifecycle", lifecycleInterval, func(ctx context.Context) error {
+		stats, err := svc.ListingLifecycle.Run(ctx)
+		if stats != nil && *stats != (LifecycleStats{}) {
+			log.Printf("Listing lifecycle: %d scheduled, %d warned, %d expired, %d relisted, %d sold out, %d boosts ended",
+				stats.Scheduled, stats.Warned, stats.Expired, stats.Relisted, stats.SoldOut, stats.BoostsEnded)
+		}
+		return err
+	})
+
+	importInterval := time.Duration(utils.GetEnvAsInt("PRODUCT_IMPORT_INTERVAL_MINUTES", 5)) * time.Minute
+	scheduler.Register("product_imports", importInterval, func(ctx context.Context) error {
+		processed, err := svc.ProductImport.ProcessPending(ctx)
+		if processed > 0 {
+			log.Printf("Processed %d product imports", processed)
+		}
+		return err
+	})
+
+	scheduler.Start()
+	svc.Events.Start()
+	AppScheduler = scheduler
+	return scheduler
+}
@@ -0,0 +1,750 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrListingNotFound     = errors.New("listing not found")
+	ErrListingNotActive    = errors.New("only active listings can be bumped")
+	ErrListingNotRenewable = errors.New("listing cannot be renewed in its current state")
+	ErrListingOutOfStock   = errors.New("listing has no stock left to sell")
+	ErrInvalidBump         = errors.New("invalid bump level or duration")
+	ErrNoFreeBumps         = errors.New("no free bumps left this month")
+	ErrBumpDowngrade       = errors.New("listing already has a higher bump running")
+)
+
+// premiumTiers are the seller tiers listing lifetimes and free bumps are set for
+var premiumTiers = []models.PremiumStatus{
+	models.PremiumStatusNone,
+	models.PremiumStatusBasic,
+	models.PremiumStatusPremium,
+	models.PremiumStatusVIP,
+}
+
+// ListingLifecycleService expires listings after their category's and
+// seller tier's lifetime, warns sellers ahead of expiry, relists listings
+// that asked for it, takes stale sold-out listings off sale and sells bumps
+// that raise a listing's BoostLevel for a while
+type ListingLifecycleService struct {
+	search *SearchService
+	wallet *WalletService
+	email  *EmailService
+
+	listingDays  map[models.PremiumStatus]int
+	freeBumps    map[models.PremiumStatus]int
+	warning      time.Duration
+	soldOutGrace time.Duration
+	bumpPrice    float64
+	maxBumpLevel int
+	maxBumpDays  int
+	freeBumpDays int
+}
+
+// LifecycleStats summarises one lifecycle run
+type LifecycleStats struct {
+	Scheduled   int `json:"scheduled"`
+	Warned      int `json:"warned"`
+	Expired     int `json:"expired"`
+	Relisted    int `json:"relisted"`
+	SoldOut     int `json:"sold_out"`
+	BoostsEnded int `json:"boosts_ended"`
+}
+
+// BumpRequest asks for a listing to be bumped to Level for Days. Free bumps
+// come out of the seller's monthly allowance instead of their wallet.
+type BumpRequest struct {
+	Level int
+	Days  int
+	Free  bool
+}
+
+// BumpOptions is what a seller can buy and how many free bumps they have left
+type BumpOptions struct {
+	DailyPrice    float64 `json:"daily_price"` // per level, per day
+	Currency      string  `json:"currency"`
+	MaxLevel      int     `json:"max_level"`
+	MaxDays       int     `json:"max_days"`
+	FreeDays      int     `json:"free_days"` // longest free bump; free bumps are level 1
+	FreePerMonth  int     `json:"free_per_month"`
+	FreeRemaining int     `json:"free_remaining"`
+}
+
+// sellerNotice collects what a run did to one seller's listings
+type sellerNotice struct {
+	expiring []models.Product
+	expired  []models.Product
+	relisted []models.Product
+}
+
+func NewListingLifecycleService(search *SearchService, wallet *WalletService, email *EmailService) *ListingLifecycleService {
+	return &ListingLifecycleService{
+		search: search,
+		wallet: wallet,
+		email:  email,
+		listingDays: map[models.PremiumStatus]int{
+			models.PremiumStatusNone:    utils.GetEnvAsInt("LISTING_DAYS_NONE", 30),
+			models.PremiumStatusBasic:   utils.GetEnvAsInt("LISTING_DAYS_BASIC", 45),
+			models.PremiumStatusPremium: utils.GetEnvAsInt("LISTING_DAYS_PREMIUM", 60),
+			models.PremiumStatusVIP:     utils.GetEnvAsInt("LISTING_DAYS_VIP", 90),
+		},
+		freeBumps: map[models.PremiumStatus]int{
+			models.PremiumStatusNone:    0,
+			models.PremiumStatusBasic:   utils.GetEnvAsInt("BUMP_FREE_PER_MONTH_BASIC", 1),
+			models.PremiumStatusPremium: utils.GetEnvAsInt("BUMP_FREE_PER_MONTH_PREMIUM", 3),
+			models.PremiumStatusVIP:     utils.GetEnvAsInt("BUMP_FREE_PER_MONTH_VIP", 10),
+		},
+		warning:      time.Duration(utils.GetEnvAsInt("LISTING_EXPIRY_WARNING_DAYS", 3)) * 24 * time.Hour,
+		soldOutGrace: time.Duration(utils.GetEnvAsInt("LISTING_SOLD_OUT_GRACE_HOURS", 24)) * time.Hour,
+		bumpPrice:    utils.GetEnvAsFloat("BUMP_DAILY_PRICE", 200),
+		maxBumpLevel: utils.GetEnvAsInt("BUMP_MAX_LEVEL", 3),
+		maxBumpDays:  utils.GetEnvAsInt("BUMP_MAX_DAYS", 30),
+		freeBumpDays: utils.GetEnvAsInt("BUMP_FREE_DAYS", 3),
+	}
+}
+
+// Defaults are the listing lifetimes, in days per seller tier, used where a
+// category sets none
+func (s *ListingLifecycleService) Defaults() map[models.PremiumStatus]int {
+	return s.ListingDays(nil)
+}
+
+// ListingDays fills in a category's unset lifetimes from the defaults
+func (s *ListingLifecycleService) ListingDays(overrides *models.CategoryLifecycle) map[models.PremiumStatus]int {
+	days := make(map[models.PremiumStatus]int, len(premiumTiers))
+	for _, tier := range premiumTiers {
+		days[tier] = s.days(tier, overrides)
+	}
+	return days
+}
+
+// ValidPremiumTier reports whether a premium status is one lifetimes are set for
+func ValidPremiumTier(tier models.PremiumStatus) bool {
+	for _, t := range premiumTiers {
+		if t == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// Run applies the lifecycle to every listing:
+//   - boosts past their end drop back to level 0
+//   - active listings out of stock for LISTING_SOLD_OUT_GRACE_HOURS are
+//     marked sold and leave search
+//   - active listings without an expiry get one from when they went live,
+//     never sooner than the warning period so their sellers hear first
+//   - listings past their expiry are relisted if they asked for it and
+//     expire otherwise
+//   - sellers are warned LISTING_EXPIRY_WARNING_DAYS ahead of expiry
+func (s *ListingLifecycleService) Run(ctx context.Context) (*LifecycleStats, error) {
+	now := time.Now()
+	stats := &LifecycleStats{}
+	notices := make(map[primitive.ObjectID]*sellerNotice)
+	notice := func(sellerID primitive.ObjectID) *sellerNotice {
+		n, ok := notices[sellerID]
+		if !ok {
+			n = &sellerNotice{}
+			notices[sellerID] = n
+		}
+		return n
+	}
+
+	var err error
+	if stats.BoostsEnded, err = s.endBoosts(ctx, now); err != nil {
+		return stats, err
+	}
+	if stats.SoldOut, err = s.closeSoldOut(ctx, now); err != nil {
+		return stats, err
+	}
+
+	lifetimes, err := s.categoryLifetimes(ctx)
+	if err != nil {
+		return stats, err
+	}
+	if stats.Scheduled, err = s.schedule(ctx, lifetimes, now); err != nil {
+		return stats, err
+	}
+
+	due, err := s.findListings(ctx, bson.M{
+		"status":     models.ProductStatusActive,
+		"expires_at": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return stats, err
+	}
+	tiers, err := s.sellerTiers(ctx, due)
+	if err != nil {
+		return stats, err
+	}
+	for _, product := range due {
+		if product.AutoRelist {
+			days := s.days(tiers[product.SellerID], lifetimes[product.CategoryID])
+			relisted, err := s.relist(ctx, product.ID, now, days)
+			if err != nil {
+				return stats, err
+			}
+			if relisted {
+				stats.Relisted++
+				notice(product.SellerID).relisted = append(notice(product.SellerID).relisted, product)
+			}
+			continue
+		}
+		result, err := config.Coll.Products.UpdateOne(ctx,
+			bson.M{"_id": product.ID, "status": models.ProductStatusActive, "expires_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"status": models.ProductStatusExpired, "updated_at": now}},
+		)
+		if err != nil {
+			return stats, err
+		}
+		if result.ModifiedCount > 0 {
+			s.search.RemoveProduct(product.ID)
+			stats.Expired++
+			notice(product.SellerID).expired = append(notice(product.SellerID).expired, product)
+		}
+	}
+
+	expiring, err := s.findListings(ctx, bson.M{
+		"status":           models.ProductStatusActive,
+		"auto_relist":      bson.M{"$ne": true},
+		"expires_at":       bson.M{"$gt": now, "$lte": now.Add(s.warning)},
+		"expiry_warned_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return stats, err
+	}
+	if len(expiring) > 0 {
+		ids := make([]primitive.ObjectID, len(expiring))
+		for i, product := range expiring {
+			ids[i] = product.ID
+			notice(product.SellerID).expiring = append(notice(product.SellerID).expiring, product)
+		}
+		// Marked before sending so a failed notification is not repeated
+		// on every run
+		if _, err := config.Coll.Products.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{"expiry_warned_at": now}},
+		); err != nil {
+			return stats, err
+		}
+		stats.Warned = len(expiring)
+	}
+
+	for sellerID, n := range notices {
+		if err := s.notify(ctx, sellerID, n); err != nil {
+			log.Printf("Failed to notify seller %s about listing expiry: %v", sellerID.Hex(), err)
+		}
+	}
+	return stats, nil
+}
+
+// Renew gives an active, expired or sold listing a fresh lifetime from now,
+// putting it back on sale if it had come off. The listing needs stock left.
+func (s *ListingLifecycleService) Renew(ctx context.Context, sellerID, productID primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+	err := config.Coll.Products.FindOne(ctx, bson.M{"_id": productID, "seller_id": sellerID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrListingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch product.Status {
+	case models.ProductStatusActive, models.ProductStatusExpired, models.ProductStatusSold:
+	case models.ProductStatusDeleted:
+		return nil, ErrListingNotFound
+	default:
+		return nil, ErrListingNotRenewable
+	}
+	if product.Quantity <= 0 {
+		return nil, ErrListingOutOfStock
+	}
+
+	days, err := s.lifetime(ctx, &product)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	set := bson.M{
+		"status":      models.ProductStatusActive,
+		"is_in_stock": true,
+		"expires_at":  now.AddDate(0, 0, days),
+		"renewed_at":  now,
+		"updated_at":  now,
+	}
+	if product.PublishedAt == nil {
+		set["published_at"] = now
+	}
+	err = config.Coll.Products.FindOneAndUpdate(ctx,
+		bson.M{"_id": productID, "status": product.Status},
+		bson.M{"$set": set, "$unset": bson.M{"expiry_warned_at": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrListingNotRenewable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.reindex(ctx, productID)
+	return &product, nil
+}
+
+// SetAutoRelist turns automatic relisting on expiry on or off
+func (s *ListingLifecycleService) SetAutoRelist(ctx context.Context, sellerID, productID primitive.ObjectID, enabled bool) error {
+	result, err := config.Coll.Products.UpdateOne(ctx,
+		bson.M{"_id": productID, "seller_id": sellerID, "status": bson.M{"$ne": models.ProductStatusDeleted}},
+		bson.M{"$set": bson.M{"auto_relist": enabled, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrListingNotFound
+	}
+	return nil
+}
+
+// Bump raises an active listing's BoostLevel for a number of days. A bump
+// at the level already running extends it; a higher level replaces it from
+// now. Paid bumps cost BUMP_DAILY_PRICE per level per day from the seller's
+// wallet.
+func (s *ListingLifecycleService) Bump(ctx context.Context, sellerID, productID primitive.ObjectID, req BumpRequest) (*models.ProductBump, error) {
+	if req.Level < 1 || req.Level > s.maxBumpLevel || req.Days < 1 || req.Days > s.maxBumpDays {
+		return nil, ErrInvalidBump
+	}
+	if req.Free && (req.Level != 1 || req.Days > s.freeBumpDays) {
+		return nil, ErrInvalidBump
+	}
+
+	var product models.Product
+	err := config.Coll.Products.FindOne(ctx, bson.M{"_id": productID, "seller_id": sellerID}).Decode(&product)
+	if err == mongo.ErrNoDocuments || (err == nil && product.Status == models.ProductStatusDeleted) {
+		return nil, ErrListingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if product.Status != models.ProductStatusActive {
+		return nil, ErrListingNotActive
+	}
+
+	now := time.Now()
+	start := now
+	if product.BoostExpiresAt != nil && product.BoostExpiresAt.After(now) {
+		if product.BoostLevel > req.Level {
+			return nil, ErrBumpDowngrade
+		}
+		if product.BoostLevel == req.Level {
+			start = *product.BoostExpiresAt
+		}
+	}
+	bump := models.ProductBump{
+		ID:        primitive.NewObjectID(),
+		ProductID: productID,
+		SellerID:  sellerID,
+		Level:     req.Level,
+		Days:      req.Days,
+		Currency:  currencyOrDefault(product.Currency),
+		Free:      req.Free,
+		StartsAt:  start,
+		ExpiresAt: start.AddDate(0, 0, req.Days),
+		CreatedAt: now,
+	}
+	if !req.Free {
+		bump.Amount = s.bumpPrice * float64(req.Level*req.Days)
+	}
+
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if req.Free {
+			allowance, used, err := s.freeBumpUsage(sc, sellerID, now)
+			if err != nil {
+				return err
+			}
+			if used >= allowance {
+				return ErrNoFreeBumps
+			}
+		} else {
+			tx, err := s.wallet.Debit(sc, sellerID, WalletEntry{
+				Type:          models.WalletTransactionDebit,
+				Counterparty:  ledger.AccountPromotionRevenue,
+				Amount:        bump.Amount,
+				Currency:      bump.Currency,
+				ReferenceType: "product_bump",
+				ReferenceID:   &bump.ID,
+				Description:   fmt.Sprintf("Level %d bump for %q, %d days", bump.Level, product.Title, bump.Days),
+			})
+			if err != nil {
+				return err
+			}
+			bump.TransactionID = &tx.ID
+		}
+
+		if _, err := config.Coll.ProductBumps.InsertOne(sc, bump); err != nil {
+			return err
+		}
+		result, err := config.Coll.Products.UpdateOne(sc,
+			bson.M{"_id": productID, "status": models.ProductStatusActive},
+			bson.M{"$set": bson.M{
+				"boost_level":      bump.Level,
+				"boost_expires_at": bump.ExpiresAt,
+				"updated_at":       now,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrListingNotActive
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.reindex(ctx, productID)
+	return &bump, nil
+}
+
+// BumpOptions returns bump pricing and the seller's free bumps left this month
+func (s *ListingLifecycleService) BumpOptions(ctx context.Context, sellerID primitive.ObjectID) (*BumpOptions, error) {
+	allowance, used, err := s.freeBumpUsage(ctx, sellerID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &BumpOptions{
+		DailyPrice:    s.bumpPrice,
+		Currency:      currencyOrDefault(""),
+		MaxLevel:      s.maxBumpLevel,
+		MaxDays:       s.maxBumpDays,
+		FreeDays:      s.freeBumpDays,
+		FreePerMonth:  allowance,
+		FreeRemaining: max(allowance-used, 0),
+	}, nil
+}
+
+// Bumps returns the seller's bumps, newest first
+func (s *ListingLifecycleService) Bumps(ctx context.Context, sellerID primitive.ObjectID, limit int) ([]models.ProductBump, error) {
+	cursor, err := config.Coll.ProductBumps.Find(ctx,
+		bson.M{"seller_id": sellerID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	bumps := []models.ProductBump{}
+	if err := cursor.All(ctx, &bumps); err != nil {
+		return nil, err
+	}
+	return bumps, nil
+}
+
+// endBoosts drops boosts that have run out back to level 0
+func (s *ListingLifecycleService) endBoosts(ctx context.Context, now time.Time) (int, error) {
+	filter := bson.M{"boost_level": bson.M{"$gt": 0}, "boost_expires_at": bson.M{"$lte": now}}
+	ended, err := s.findListings(ctx, filter)
+	if err != nil || len(ended) == 0 {
+		return 0, err
+	}
+	if _, err := config.Coll.Products.UpdateMany(ctx, filter, bson.M{
+		"$set":   bson.M{"boost_level": 0},
+		"$unset": bson.M{"boost_expires_at": ""},
+	}); err != nil {
+		return 0, err
+	}
+	for _, product := range ended {
+		s.reindex(ctx, product.ID)
+	}
+	return len(ended), nil
+}
+
+// closeSoldOut marks active listings that have had no stock for the grace
+// period as sold, taking them out of search
+func (s *ListingLifecycleService) closeSoldOut(ctx context.Context, now time.Time) (int, error) {
+	filter := bson.M{
+		"status":     models.ProductStatusActive,
+		"quantity":   bson.M{"$lte": 0},
+		"updated_at": bson.M{"$lte": now.Add(-s.soldOutGrace)},
+	}
+	soldOut, err := s.findListings(ctx, filter)
+	if err != nil || len(soldOut) == 0 {
+		return 0, err
+	}
+	ids := make([]primitive.ObjectID, len(soldOut))
+	for i, product := range soldOut {
+		ids[i] = product.ID
+	}
+	filter["_id"] = bson.M{"$in": ids}
+	if _, err := config.Coll.Products.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"status":      models.ProductStatusSold,
+		"is_in_stock": false,
+		"updated_at":  now,
+	}}); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		s.search.RemoveProduct(id)
+	}
+	return len(ids), nil
+}
+
+// schedule gives active listings without an expiry one
+func (s *ListingLifecycleService) schedule(ctx context.Context, lifetimes map[primitive.ObjectID]*models.CategoryLifecycle, now time.Time) (int, error) {
+	unscheduled, err := s.findListings(ctx, bson.M{
+		"status":     models.ProductStatusActive,
+		"expires_at": nil,
+	})
+	if err != nil || len(unscheduled) == 0 {
+		return 0, err
+	}
+	tiers, err := s.sellerTiers(ctx, unscheduled)
+	if err != nil {
+		return 0, err
+	}
+
+	earliest := now.Add(s.warning)
+	writes := make([]mongo.WriteModel, 0, len(unscheduled))
+	for _, product := range unscheduled {
+		published := product.CreatedAt
+		if product.PublishedAt != nil {
+			published = *product.PublishedAt
+		}
+		expires := published.AddDate(0, 0, s.days(tiers[product.SellerID], lifetimes[product.CategoryID]))
+		if expires.Before(earliest) {
+			expires = earliest
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": product.ID, "expires_at": nil}).
+			SetUpdate(bson.M{"$set": bson.M{"expires_at": expires}}))
+	}
+
+	scheduled := 0
+	for start := 0; start < len(writes); start += bulkWriteBatch {
+		end := min(start+bulkWriteBatch, len(writes))
+		result, err := config.Coll.Products.BulkWrite(ctx, writes[start:end], options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return scheduled, err
+		}
+		scheduled += int(result.ModifiedCount)
+	}
+	return scheduled, nil
+}
+
+// relist puts an expired auto-relist listing back up as if newly published
+func (s *ListingLifecycleService) relist(ctx context.Context, productID primitive.ObjectID, now time.Time, days int) (bool, error) {
+	result, err := config.Coll.Products.UpdateOne(ctx,
+		bson.M{"_id": productID, "status": models.ProductStatusActive, "expires_at": bson.M{"$lte": now}},
+		bson.M{
+			"$set": bson.M{
+				"published_at": now,
+				"expires_at":   now.AddDate(0, 0, days),
+				"updated_at":   now,
+			},
+			"$inc":   bson.M{"relist_count": 1},
+			"$unset": bson.M{"expiry_warned_at": ""},
+		},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		return false, err
+	}
+	s.reindex(ctx, productID)
+	return true, nil
+}
+
+// notify tells a seller which of their listings are about to expire,
+// expired or were relisted, in app and, for the first two, by email
+func (s *ListingLifecycleService) notify(ctx context.Context, sellerID primitive.ObjectID, n *sellerNotice) error {
+	messages := []struct {
+		products []models.Product
+		title    string
+		message  string
+	}{
+		{n.expiring, "Listings expiring soon", "%s expiring soon. Renew from your listings to keep selling."},
+		{n.expired, "Listings expired", "%s now expired and hidden from buyers. Renew from your listings to sell again."},
+		{n.relisted, "Listings relisted", "%s live again after being relisted automatically."},
+	}
+	for _, m := range messages {
+		if len(m.products) == 0 {
+			continue
+		}
+		notification := models.Notification{
+			ID:        primitive.NewObjectID(),
+			UserID:    sellerID,
+			Type:      models.NotificationTypeListing,
+			Title:     m.title,
+			Message:   fmt.Sprintf(m.message, listingCount(m.products)),
+			ActionURL: "/seller/products",
+			Priority:  2,
+			CreatedAt: time.Now(),
+		}
+		if len(m.products) == 1 {
+			productID := m.products[0].ID
+			notification.ActionURL = "/seller/products/" + productID.Hex()
+			notification.RelatedID = &productID
+		}
+		if len(m.products[0].Images) > 0 {
+			notification.ImageURL = m.products[0].Images[0].URL
+		}
+		if _, err := config.Coll.Notifications.InsertOne(ctx, notification); err != nil {
+			return err
+		}
+		SendNotification(sellerID.Hex(), notification)
+	}
+
+	if len(n.expiring) == 0 && len(n.expired) == 0 {
+		return nil
+	}
+	var seller models.User
+	if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": sellerID}).Decode(&seller); err != nil {
+		return err
+	}
+	name := seller.Profile.FirstName
+	if name == "" {
+		name = seller.Username
+	}
+	return s.email.SendListingExpiryEmail(seller.Email, name, n.expiring, n.expired)
+}
+
+// lifetime is how many days a listing stays live for its seller and category
+func (s *ListingLifecycleService) lifetime(ctx context.Context, product *models.Product) (int, error) {
+	tiers, err := s.sellerTiers(ctx, []models.Product{*product})
+	if err != nil {
+		return 0, err
+	}
+	var category models.Category
+	err = config.Coll.Categories.FindOne(ctx, bson.M{"_id": product.CategoryID},
+		options.FindOne().SetProjection(bson.M{"lifecycle": 1})).Decode(&category)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	return s.days(tiers[product.SellerID], category.Lifecycle), nil
+}
+
+func (s *ListingLifecycleService) days(tier models.PremiumStatus, overrides *models.CategoryLifecycle) int {
+	if !ValidPremiumTier(tier) {
+		tier = models.PremiumStatusNone
+	}
+	if overrides != nil && overrides.ListingDays[tier] > 0 {
+		return overrides.ListingDays[tier]
+	}
+	return s.listingDays[tier]
+}
+
+// freeBumpUsage returns the seller's monthly free bump allowance and how
+// many they have used this calendar month
+func (s *ListingLifecycleService) freeBumpUsage(ctx context.Context, sellerID primitive.ObjectID, now time.Time) (int, int, error) {
+	var seller models.User
+	err := config.Coll.Users.FindOne(ctx, bson.M{"_id": sellerID},
+		options.FindOne().SetProjection(bson.M{"profile.premium_status": 1})).Decode(&seller)
+	if err != nil {
+		return 0, 0, err
+	}
+	allowance := s.freeBumps[seller.Profile.PremiumStatus]
+	if allowance == 0 {
+		return 0, 0, nil
+	}
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	used, err := config.Coll.ProductBumps.CountDocuments(ctx, bson.M{
+		"seller_id":  sellerID,
+		"free":       true,
+		"created_at": bson.M{"$gte": monthStart},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return allowance, int(used), nil
+}
+
+// findListings loads the fields the lifecycle needs of every matching listing
+func (s *ListingLifecycleService) findListings(ctx context.Context, filter bson.M) ([]models.Product, error) {
+	cursor, err := config.Coll.Products.Find(ctx, filter, options.Find().SetProjection(bson.M{
+		"seller_id": 1, "category_id": 1, "title": 1, "images": bson.M{"$slice": 1},
+		"created_at": 1, "published_at": 1, "expires_at": 1, "auto_relist": 1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// sellerTiers looks up the premium tier of each listing's seller
+func (s *ListingLifecycleService) sellerTiers(ctx context.Context, products []models.Product) (map[primitive.ObjectID]models.PremiumStatus, error) {
+	tiers := make(map[primitive.ObjectID]models.PremiumStatus)
+	if len(products) == 0 {
+		return tiers, nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(products))
+	seen := make(map[primitive.ObjectID]bool)
+	for _, product := range products {
+		if !seen[product.SellerID] {
+			seen[product.SellerID] = true
+			ids = append(ids, product.SellerID)
+		}
+	}
+
+	cursor, err := config.Coll.Users.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"profile.premium_status": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var sellers []models.User
+	if err := cursor.All(ctx, &sellers); err != nil {
+		return nil, err
+	}
+	for _, seller := range sellers {
+		tiers[seller.ID] = seller.Profile.PremiumStatus
+	}
+	return tiers, nil
+}
+
+// categoryLifetimes loads every category's lifetime overrides
+func (s *ListingLifecycleService) categoryLifetimes(ctx context.Context) (map[primitive.ObjectID]*models.CategoryLifecycle, error) {
+	cursor, err := config.Coll.Categories.Find(ctx, bson.M{"lifecycle": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"lifecycle": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	lifetimes := make(map[primitive.ObjectID]*models.CategoryLifecycle, len(categories))
+	for _, category := range categories {
+		lifetimes[category.ID] = category.Lifecycle
+	}
+	return lifetimes, nil
+}
+
+func (s *ListingLifecycleService) reindex(ctx context.Context, productID primitive.ObjectID) {
+	if err := s.search.IndexProduct(ctx, productID); err != nil {
+		log.Printf("Failed to index product %s: %v", productID.Hex(), err)
+	}
+}
+
+func listingCount(products []models.Product) string {
+	if len(products) == 1 {
+		return fmt.Sprintf("%q is", products[0].Title)
+	}
+	return fmt.Sprintf("%d of your listings are", len(products))
+}
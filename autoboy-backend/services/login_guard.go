@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAccountLocked      = errors.New("account is temporarily locked after too many failed sign-in attempts")
+	ErrLoginThrottled     = errors.New("too many failed sign-in attempts from this network; try again later")
+	ErrUnlockTokenInvalid = errors.New("invalid or expired unlock link")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// loginSecurityActions are the activity entries admins review for
+// brute-force attempts
+var loginSecurityActions = []string{
+	models.ActivityLoginFailed,
+	models.ActivityLoginBlocked,
+	models.ActivityLoginThrottled,
+	models.ActivityAccountLocked,
+	models.ActivityAccountUnlocked,
+}
+
+// LoginGuardService slows password guessing down. Failures are counted per
+// account and per IP address on each account; past a threshold every
+// further failure locks for twice as long as the last, up to a cap. The
+// per-IP lock comes first, so one source guessing is stopped without
+// locking the owner out; the account-wide lock catches guesses spread over
+// many addresses and emails the owner a link to lift it.
+type LoginGuardService struct {
+	email            *EmailService
+	sms              *SMSService
+	accountThreshold int
+	ipThreshold      int
+	baseLock         time.Duration
+	maxLock          time.Duration
+	failureWindow    time.Duration
+	unlockTTL        time.Duration
+}
+
+// LoginAttemptFilter narrows the sign-in security log
+type LoginAttemptFilter struct {
+	UserID    primitive.ObjectID
+	IPAddress string
+	Action    string
+	Since     time.Time
+}
+
+// LoginAttemptSource is an IP address with failed sign-ins, and how many
+// accounts it tried; one address failing on many accounts is credential
+// stuffing
+type LoginAttemptSource struct {
+	IPAddress   string    `bson:"_id" json:"ip_address"`
+	Failures    int       `bson:"failures" json:"failures"`
+	Accounts    int       `bson:"accounts" json:"accounts"`
+	UnknownHits int       `bson:"unknown_hits" json:"unknown_hits"` // emails with no account
+	LastSeen    time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+func NewLoginGuardService(email *EmailService, sms *SMSService) *LoginGuardService {
+	return &LoginGuardService{
+		email:            email,
+		sms:              sms,
+		accountThreshold: utils.GetEnvAsInt("LOGIN_LOCKOUT_ACCOUNT_ATTEMPTS", 10),
+		ipThreshold:      utils.GetEnvAsInt("LOGIN_LOCKOUT_IP_ATTEMPTS", 5),
+		baseLock:         time.Duration(utils.GetEnvAsInt("LOGIN_LOCKOUT_BASE_MINUTES", 5)) * time.Minute,
+		maxLock:          time.Duration(utils.GetEnvAsInt("LOGIN_LOCKOUT_MAX_HOURS", 24)) * time.Hour,
+		failureWindow:    time.Duration(utils.GetEnvAsInt("LOGIN_FAILURE_WINDOW_HOURS", 24)) * time.Hour,
+		unlockTTL:        time.Duration(utils.GetEnvAsInt("LOGIN_UNLOCK_LINK_HOURS", 24)) * time.Hour,
+	}
+}
+
+// Allow checks whether the account may try a password from an address. A
+// refusal is recorded and says when the lock lifts.
+func (s *LoginGuardService) Allow(ctx context.Context, user *models.User, ipAddress, userAgent string) (time.Time, error) {
+	now := time.Now()
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		s.record(ctx, user.ID, models.ActivityLoginBlocked, ipAddress, userAgent, map[string]interface{}{
+			"lock":         "account",
+			"locked_until": *user.LockedUntil,
+		})
+		return *user.LockedUntil, ErrAccountLocked
+	}
+
+	var throttle models.LoginThrottle
+	err := config.Coll.LoginThrottles.FindOne(ctx, bson.M{"user_id": user.ID, "ip_address": ipAddress}).Decode(&throttle)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to read login throttle for user %s: %v", user.ID.Hex(), err)
+		return time.Time{}, nil
+	}
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		s.record(ctx, user.ID, models.ActivityLoginBlocked, ipAddress, userAgent, map[string]interface{}{
+			"lock":         "ip",
+			"locked_until": *throttle.LockedUntil,
+		})
+		return *throttle.LockedUntil, ErrLoginThrottled
+	}
+	return time.Time{}, nil
+}
+
+// RecordFailure counts a wrong password. When it locks the account or the
+// address it returns the lock and when it lifts.
+func (s *LoginGuardService) RecordFailure(ctx context.Context, user *models.User, ipAddress, userAgent string) (time.Time, error) {
+	now := time.Now()
+
+	var updated models.User
+	err := config.Coll.Users.FindOneAndUpdate(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$inc": bson.M{"login_attempts": 1}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"login_attempts": 1}),
+	).Decode(&updated)
+	if err != nil {
+		log.Printf("Failed to count failed sign-in for user %s: %v", user.ID.Hex(), err)
+		return time.Time{}, nil
+	}
+
+	var throttle models.LoginThrottle
+	err = config.Coll.LoginThrottles.FindOneAndUpdate(ctx,
+		bson.M{"user_id": user.ID, "ip_address": ipAddress},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure_at": now, "expires_at": now.Add(s.failureWindow)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&throttle)
+	if err != nil {
+		log.Printf("Failed to count failed sign-in from %s for user %s: %v", ipAddress, user.ID.Hex(), err)
+	}
+
+	s.record(ctx, user.ID, models.ActivityLoginFailed, ipAddress, userAgent, map[string]interface{}{
+		"account_failures": updated.LoginAttempts,
+		"ip_failures":      throttle.Failures,
+	})
+
+	if updated.LoginAttempts >= s.accountThreshold {
+		until := now.Add(s.lockFor(updated.LoginAttempts - s.accountThreshold))
+		s.lockAccount(ctx, user, updated.LoginAttempts, until, ipAddress, userAgent)
+		return until, ErrAccountLocked
+	}
+
+	if throttle.Failures >= s.ipThreshold {
+		until := now.Add(s.lockFor(throttle.Failures - s.ipThreshold))
+		expires := now.Add(s.failureWindow)
+		if until.After(expires) {
+			expires = until
+		}
+		if _, err := config.Coll.LoginThrottles.UpdateOne(ctx,
+			bson.M{"_id": throttle.ID},
+			bson.M{"$set": bson.M{"locked_until": until, "expires_at": expires}},
+		); err != nil {
+			log.Printf("Failed to throttle sign-in from %s for user %s: %v", ipAddress, user.ID.Hex(), err)
+			return time.Time{}, nil
+		}
+		s.record(ctx, user.ID, models.ActivityLoginThrottled, ipAddress, userAgent, map[string]interface{}{
+			"ip_failures":  throttle.Failures,
+			"locked_until": until,
+		})
+		return until, ErrLoginThrottled
+	}
+	return time.Time{}, nil
+}
+
+// RecordUnknownAccount records a sign-in for an email with no account, which
+// is how credential stuffing from a leaked list shows up
+func (s *LoginGuardService) RecordUnknownAccount(ctx context.Context, email, ipAddress, userAgent string) {
+	s.record(ctx, primitive.NilObjectID, models.ActivityLoginFailed, ipAddress, userAgent, map[string]interface{}{
+		"email":  email,
+		"reason": "unknown_account",
+	})
+}
+
+// RecordSuccess clears the failure counts once the right password is given
+func (s *LoginGuardService) RecordSuccess(ctx context.Context, user *models.User, ipAddress string) {
+	s.clear(ctx, user.ID, bson.M{"last_login": time.Now()})
+	if _, err := config.Coll.LoginThrottles.DeleteOne(ctx, bson.M{"user_id": user.ID, "ip_address": ipAddress}); err != nil {
+		log.Printf("Failed to clear login throttle for user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// Unlock lifts an account lock with the link emailed when it locked. The
+// address the link is opened from is let back in too.
+func (s *LoginGuardService) Unlock(ctx context.Context, token, ipAddress, userAgent string) error {
+	var user models.User
+	err := config.Coll.Users.FindOne(ctx, bson.M{
+		"unlock_token_hash":   hashSecret(token),
+		"unlock_token_expiry": bson.M{"$gt": time.Now()},
+	}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return ErrUnlockTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.clear(ctx, user.ID, nil); err != nil {
+		return err
+	}
+	if _, err := config.Coll.LoginThrottles.DeleteOne(ctx, bson.M{"user_id": user.ID, "ip_address": ipAddress}); err != nil {
+		return err
+	}
+	s.record(ctx, user.ID, models.ActivityAccountUnlocked, ipAddress, userAgent, map[string]interface{}{
+		"via": "email",
+	})
+	return nil
+}
+
+// AdminUnlock lifts every lock on an account
+func (s *LoginGuardService) AdminUnlock(ctx context.Context, userID, adminID primitive.ObjectID, ipAddress, userAgent string) error {
+	count, err := config.Coll.Users.CountDocuments(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := s.clear(ctx, userID, nil); err != nil {
+		return err
+	}
+	if _, err := config.Coll.LoginThrottles.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	s.record(ctx, userID, models.ActivityAccountUnlocked, ipAddress, userAgent, map[string]interface{}{
+		"via":      "admin",
+		"admin_id": adminID.Hex(),
+	})
+	return nil
+}
+
+// Attempts returns the sign-in security log, newest first
+func (s *LoginGuardService) Attempts(ctx context.Context, filter LoginAttemptFilter, page, limit int) ([]models.UserActivity, int64, error) {
+	query := bson.M{"action": bson.M{"$in": loginSecurityActions}}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	if filter.IPAddress != "" {
+		query["ip_address"] = filter.IPAddress
+	}
+	if !filter.Since.IsZero() {
+		query["timestamp"] = bson.M{"$gte": filter.Since}
+	}
+
+	cursor, err := config.Coll.UserActivities.Find(ctx, query,
+		options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	attempts := []models.UserActivity{}
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, 0, err
+	}
+	total, err := config.Coll.UserActivities.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return attempts, total, nil
+}
+
+// Sources ranks the addresses with the most failed sign-ins since a time
+func (s *LoginGuardService) Sources(ctx context.Context, since time.Time, limit int) ([]LoginAttemptSource, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"action": models.ActivityLoginFailed, "timestamp": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":          "$ip_address",
+			"failures":     bson.M{"$sum": 1},
+			"users":        bson.M{"$addToSet": "$user_id"},
+			"unknown_hits": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$user_id", primitive.NilObjectID}}, 1, 0}}},
+			"last_seen":    bson.M{"$max": "$timestamp"},
+		}},
+		{"$project": bson.M{
+			"failures":     1,
+			"unknown_hits": 1,
+			"last_seen":    1,
+			"accounts":     bson.M{"$size": bson.M{"$setDifference": bson.A{"$users", bson.A{primitive.NilObjectID}}}},
+		}},
+		{"$sort": bson.D{{Key: "failures", Value: -1}}},
+		{"$limit": limit},
+	}
+
+	cursor, err := config.Coll.UserActivities.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	sources := []LoginAttemptSource{}
+	if err := cursor.All(ctx, &sources); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// lockAccount locks the account until a time. The first lock in a run of
+// failures emails an unlock link and texts the owner; later ones only
+// extend it, unless the link has expired.
+func (s *LoginGuardService) lockAccount(ctx context.Context, user *models.User, attempts int, until time.Time, ipAddress, userAgent string) {
+	set := bson.M{"locked_until": until}
+	first := attempts == s.accountThreshold ||
+		user.UnlockTokenExpiry == nil || user.UnlockTokenExpiry.Before(time.Now())
+
+	var token string
+	if first {
+		var err error
+		if token, err = randomToken(); err != nil {
+			log.Printf("Failed to create unlock link for user %s: %v", user.ID.Hex(), err)
+		} else {
+			set["unlock_token_hash"] = hashSecret(token)
+			set["unlock_token_expiry"] = time.Now().Add(s.unlockTTL)
+		}
+	}
+
+	if _, err := config.Coll.Users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to lock user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	s.record(ctx, user.ID, models.ActivityAccountLocked, ipAddress, userAgent, map[string]interface{}{
+		"account_failures": attempts,
+		"locked_until":     until,
+	})
+	log.Printf("Locked user %s until %s after %d failed sign-ins", user.ID.Hex(), until.Format(time.RFC3339), attempts)
+
+	if !first {
+		return
+	}
+	if token != "" {
+		go func() {
+			if err := s.email.SendAccountLockedEmail(user.Email, user.Profile.FirstName, token, until); err != nil {
+				log.Printf("Failed to send unlock email to user %s: %v", user.ID.Hex(), err)
+			}
+		}()
+	}
+	if user.IsPhoneVerified {
+		go func() {
+			if err := s.sms.SendSecurityAlert(user.Phone, "suspicious_activity"); err != nil {
+				log.Printf("Failed to send security alert to user %s: %v", user.ID.Hex(), err)
+			}
+		}()
+	}
+}
+
+// lockFor is how long the nth lock past the threshold lasts, doubling each
+// time up to the cap
+func (s *LoginGuardService) lockFor(n int) time.Duration {
+	lock := s.baseLock
+	for i := 0; i < n && lock < s.maxLock; i++ {
+		lock *= 2
+	}
+	if lock > s.maxLock {
+		lock = s.maxLock
+	}
+	return lock
+}
+
+// clear resets the account's failure count and lock, along with any
+// unlock link
+func (s *LoginGuardService) clear(ctx context.Context, userID primitive.ObjectID, set bson.M) error {
+	if set == nil {
+		set = bson.M{}
+	}
+	set["login_attempts"] = 0
+	_, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set":   set,
+			"$unset": bson.M{"locked_until": "", "unlock_token_hash": "", "unlock_token_expiry": ""},
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to clear sign-in lock for user %s: %v", userID.Hex(), err)
+	}
+	return err
+}
+
+func (s *LoginGuardService) record(ctx context.Context, userID primitive.ObjectID, action, ipAddress, userAgent string, details map[string]interface{}) {
+	activity := models.UserActivity{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Action:    action,
+		Resource:  "auth",
+		Details:   details,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Timestamp: time.Now(),
+	}
+	if _, err := config.Coll.UserActivities.InsertOne(ctx, activity); err != nil {
+		log.Printf("Failed to record %s for user %s: %v", action, userID.Hex(), err)
+	}
+}
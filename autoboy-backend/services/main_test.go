@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"autoboy-backend/config"
+)
+
+// TestMain connects to the MongoDB at MONGODB_TEST_URI, if set, using a
+// throwaway database. Tests that need it call requireDatabase and are
+// skipped without one.
+func TestMain(m *testing.M) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		os.Exit(m.Run())
+	}
+
+	os.Setenv("MONGODB_URI", uri)
+	os.Setenv("MONGODB_DATABASE", fmt.Sprintf("autoboy_test_%d", time.Now().UnixNano()))
+	if err := config.InitializeDatabase(); err != nil {
+		log.Fatalf("Failed to set up the test database: %v", err)
+	}
+
+	code := m.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := config.DB.Database.Drop(ctx); err != nil {
+		log.Printf("Failed to drop the test database: %v", err)
+	}
+	os.Exit(code)
+}
+
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	if config.Coll == nil {
+		t.Skip("set MONGODB_TEST_URI to run tests against MongoDB")
+	}
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOrderTransition    = errors.New("order status change not allowed")
+	ErrOrderRefundPending = errors.New("order cancelled but its refund has not gone through yet")
+)
+
+// orderLapsedNote is the cancellation reason given to orders whose stock
+// reservation expired before they were paid
+const orderLapsedNote = "Payment was not completed in time"
+
+// OrderActor is the kind of party moving an order between statuses
+type OrderActor string
+
+const (
+	OrderActorBuyer  OrderActor = "buyer"
+	OrderActorSeller OrderActor = "seller"
+	OrderActorAdmin  OrderActor = "admin"
+	OrderActorSystem OrderActor = "system"
+)
+
+// orderTransitions lists, for each status, the statuses an order may move to
+// and the actors allowed to make each move
+var orderTransitions = map[models.OrderStatus]map[models.OrderStatus][]OrderActor{
+	models.OrderStatusPending: {
+		models.OrderStatusPaid:      {OrderActorSystem},
+		models.OrderStatusConfirmed: {OrderActorAdmin, OrderActorSystem},
+		models.OrderStatusCancelled: {OrderActorBuyer, OrderActorSeller, OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusPaid: {
+		models.OrderStatusConfirmed:  {OrderActorSeller, OrderActorAdmin, OrderActorSystem},
+		models.OrderStatusProcessing: {OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusCancelled:  {OrderActorBuyer, OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusRefunded:   {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusConfirmed: {
+		models.OrderStatusProcessing: {OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusShipped:    {OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusCancelled:  {OrderActorBuyer, OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusDisputed:   {OrderActorBuyer, OrderActorAdmin},
+		models.OrderStatusRefunded:   {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusProcessing: {
+		models.OrderStatusShipped:   {OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusCancelled: {OrderActorSeller, OrderActorAdmin},
+		models.OrderStatusDisputed:  {OrderActorBuyer, OrderActorAdmin},
+		models.OrderStatusRefunded:  {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusShipped: {
+		models.OrderStatusDelivered: {OrderActorSeller, OrderActorAdmin, OrderActorSystem},
+		models.OrderStatusCompleted: {OrderActorBuyer, OrderActorAdmin, OrderActorSystem},
+		models.OrderStatusDisputed:  {OrderActorBuyer, OrderActorAdmin},
+		models.OrderStatusRefunded:  {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusDelivered: {
+		models.OrderStatusCompleted: {OrderActorBuyer, OrderActorAdmin, OrderActorSystem},
+		models.OrderStatusDisputed:  {OrderActorBuyer, OrderActorAdmin},
+		models.OrderStatusRefunded:  {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusCompleted: {
+		models.OrderStatusDisputed: {OrderActorBuyer, OrderActorAdmin},
+		models.OrderStatusRefunded: {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusDisputed: {
+		models.OrderStatusShipped:   {OrderActorAdmin},
+		models.OrderStatusDelivered: {OrderActorAdmin},
+		models.OrderStatusCompleted: {OrderActorAdmin, OrderActorSystem},
+		models.OrderStatusRefunded:  {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusCancelled: {
+		// A payment that lands after the order lapsed revives it; see
+		// orderLapsed. Orders cancelled on purpose are refunded instead.
+		models.OrderStatusConfirmed: {OrderActorSystem},
+		models.OrderStatusRefunded:  {OrderActorAdmin, OrderActorSystem},
+	},
+	models.OrderStatusRefunded: {},
+}
+
+// orderStatusTimestamps names the field stamped when an order enters a status
+var orderStatusTimestamps = map[models.OrderStatus]string{
+	models.OrderStatusConfirmed:  "confirmed_at",
+	models.OrderStatusPaid:       "paid_at",
+	models.OrderStatusProcessing: "processed_at",
+	models.OrderStatusShipped:    "shipped_at",
+	models.OrderStatusDelivered:  "delivered_at",
+	models.OrderStatusCompleted:  "completed_at",
+	models.OrderStatusCancelled:  "cancelled_at",
+}
+
+// OrderTransition describes a requested status change
+type OrderTransition struct {
+	OrderID  primitive.ObjectID
+	To       models.OrderStatus
+	Actor    OrderActor
+	ActorID  primitive.ObjectID // zero for system transitions
+	Note     string
+	Location string
+	Set      bson.M // extra order fields written with the status
+}
+
+// OrderService owns order status changes. Every change is checked against
+// the transition table, recorded as a tracking event and followed by its
+// side effects, so handlers never write an order's status directly.
+type OrderService struct {
+	email     *EmailService
+	escrow    *EscrowService
+	inventory *InventoryService
+	// Set by InitializeServices; the refund service records order
+	// transitions itself, so it cannot be passed in here
+	refunds   *RefundService
+	batchSize int
+}
+
+func NewOrderService(email *EmailService, escrow *EscrowService, inventory *InventoryService) *OrderService {
+	return &OrderService{
+		email:     email,
+		escrow:    escrow,
+		inventory: inventory,
+		batchSize: utils.GetEnvAsInt("ORDER_REFUND_RETRY_BATCH_SIZE", 50),
+	}
+}
+
+// CanTransition reports whether actor may move an order from one status to another
+func CanTransition(from, to models.OrderStatus, actor OrderActor) bool {
+	for _, allowed := range orderTransitions[from][to] {
+		if allowed == actor {
+			return true
+		}
+	}
+	return false
+}
+
+// orderLapsed reports whether an order was cancelled by the system because
+// its stock reservation expired, rather than by someone choosing to cancel it
+func orderLapsed(order *models.Order) bool {
+	return order.Status == models.OrderStatusCancelled &&
+		order.CancelledBy == nil &&
+		order.CancellationReason == orderLapsedNote
+}
+
+// AllowedTransitions lists the statuses actor may move an order to from its current status
+func AllowedTransitions(from models.OrderStatus, actor OrderActor) []models.OrderStatus {
+	statuses := []models.OrderStatus{}
+	for to, actors := range orderTransitions[from] {
+		for _, allowed := range actors {
+			if allowed == actor {
+				statuses = append(statuses, to)
+				break
+			}
+		}
+	}
+	return statuses
+}
+
+// Transition moves an order to a new status on behalf of an actor. Buyers
+// and sellers may only move their own orders. Escrow is released in the same
+// transaction as the completion; stock is released and the parties are
+// notified after the change is saved. Cancelling a paid order refunds it,
+// and refunding goes through the refund engine so the money moves with the
+// status.
+func (s *OrderService) Transition(ctx context.Context, t OrderTransition) (*models.Order, error) {
+	order, err := loadOrder(ctx, t.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Actor {
+	case OrderActorBuyer:
+		if order.BuyerID != t.ActorID {
+			return nil, ErrOrderNotFound
+		}
+	case OrderActorSeller:
+		if order.SellerID != t.ActorID {
+			return nil, ErrOrderNotFound
+		}
+	}
+
+	from := order.Status
+	if !CanTransition(from, t.To, t.Actor) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrOrderTransition, from, t.To)
+	}
+
+	// The refund engine records the move to refunded once the refund is booked
+	if t.To == models.OrderStatusRefunded {
+		if err := s.refundOrder(ctx, order, t); err != nil {
+			return nil, err
+		}
+		return loadOrder(ctx, order.ID)
+	}
+
+	if err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		return s.Record(sc, order, t)
+	}); err != nil {
+		return nil, err
+	}
+
+	if t.To == models.OrderStatusCancelled {
+		if err := s.inventory.Release(ctx, order.ID, "cancelled"); err != nil {
+			log.Printf("Failed to release stock for cancelled order %s: %v", order.OrderNumber, err)
+		}
+	}
+
+	s.Notify(ctx, order)
+
+	// Paid orders go straight back to the buyer; a refund that fails here
+	// is retried by the cancelled order refund job
+	if t.To == models.OrderStatusCancelled && order.PaymentStatus == models.PaymentStatusPaid {
+		t.Note = cancelRefundReason(t)
+		if err := s.refundOrder(ctx, order, t); err != nil {
+			log.Printf("Failed to refund cancelled order %s: %v", order.OrderNumber, err)
+			return order, fmt.Errorf("%w: %v", ErrOrderRefundPending, err)
+		}
+		return loadOrder(ctx, order.ID)
+	}
+	return order, nil
+}
+
+// RetryCancelledRefunds refunds cancelled orders that are still marked paid
+// because their refund failed when they were cancelled, and returns how many
+// it refunded
+func (s *OrderService) RetryCancelledRefunds(ctx context.Context) (int, error) {
+	cursor, err := config.Coll.Orders.Find(ctx, bson.M{
+		"status":         models.OrderStatusCancelled,
+		"payment_status": models.PaymentStatusPaid,
+	}, options.Find().SetSort(bson.D{{Key: "cancelled_at", Value: 1}}).SetLimit(int64(s.batchSize)))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return 0, err
+	}
+
+	refunded := 0
+	for i := range orders {
+		order := &orders[i]
+		if err := s.refundOrder(ctx, order, OrderTransition{
+			OrderID: order.ID,
+			To:      models.OrderStatusRefunded,
+			Actor:   OrderActorSystem,
+			Note:    "Refund for cancelled order " + order.OrderNumber,
+		}); err != nil {
+			log.Printf("Failed to refund cancelled order %s: %v", order.OrderNumber, err)
+			continue
+		}
+		refunded++
+	}
+	return refunded, nil
+}
+
+// refundOrder refunds whatever is left on a paid order through the refund
+// engine, which takes it out of the escrow and moves the order to refunded.
+// The stock comes back unless the goods may have left the seller. A refund
+// already under way is left to finish.
+func (s *OrderService) refundOrder(ctx context.Context, order *models.Order, t OrderTransition) error {
+	restock := false
+	switch order.Status {
+	case models.OrderStatusPaid, models.OrderStatusConfirmed, models.OrderStatusProcessing, models.OrderStatusCancelled:
+		restock = true
+	}
+	reason := t.Note
+	if reason == "" {
+		reason = "Order refunded"
+	}
+
+	_, err := s.refunds.Refund(ctx, RefundRequest{
+		OrderID:     order.ID,
+		Type:        models.RefundTypeFull,
+		Restock:     restock,
+		Reason:      reason,
+		RequestedBy: t.ActorID,
+	})
+	if err == ErrRefundAmount {
+		return nil
+	}
+	return err
+}
+
+// cancelRefundReason describes the refund that follows a cancellation
+func cancelRefundReason(t OrderTransition) string {
+	switch t.Actor {
+	case OrderActorBuyer:
+		return "Order cancelled by buyer"
+	case OrderActorSeller:
+		return "Order cancelled by seller"
+	case OrderActorAdmin:
+		return "Order cancelled by admin"
+	}
+	return "Order cancelled"
+}
+
+// Record checks and saves a status change in the caller's transaction and
+// adds its tracking event, updating order in place. It does not notify
+// anyone; callers outside Transition call Notify once they have committed.
+func (s *OrderService) Record(sc mongo.SessionContext, order *models.Order, t OrderTransition) error {
+	from := order.Status
+	if !CanTransition(from, t.To, t.Actor) {
+		return fmt.Errorf("%w: %s to %s", ErrOrderTransition, from, t.To)
+	}
+
+	now := time.Now()
+	set := bson.M{}
+	for k, v := range t.Set {
+		set[k] = v
+	}
+	set["status"] = t.To
+	set["updated_at"] = now
+	if field, ok := orderStatusTimestamps[t.To]; ok {
+		set[field] = now
+	}
+	if t.To == models.OrderStatusCompleted && order.DeliveredAt == nil {
+		set["delivered_at"] = now
+	}
+	if t.To == models.OrderStatusCancelled {
+		if !t.ActorID.IsZero() {
+			set["cancelled_by"] = t.ActorID
+		}
+		if t.Note != "" {
+			set["cancellation_reason"] = t.Note
+		}
+	}
+
+	// Match on the old status so a concurrent change cannot be overwritten
+	result, err := config.Coll.Orders.UpdateOne(sc, bson.M{"_id": order.ID, "status": from}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: order status changed concurrently", ErrOrderTransition)
+	}
+
+	description := t.Note
+	if description == "" {
+		description = fmt.Sprintf("Order %s", t.To)
+	}
+	event := models.OrderTracking{
+		ID:          primitive.NewObjectID(),
+		OrderID:     order.ID,
+		Status:      t.To,
+		FromStatus:  from,
+		ActorRole:   string(t.Actor),
+		Location:    t.Location,
+		Description: description,
+		Timestamp:   now,
+		IsPublic:    true,
+	}
+	if !t.ActorID.IsZero() {
+		event.CreatedBy = &t.ActorID
+	}
+	if _, err := config.Coll.OrderTracking.InsertOne(sc, event); err != nil {
+		return err
+	}
+
+	// The seller is paid in the same transaction, so a completion that loses
+	// a race (e.g. to a dispute) does not leave the escrow released
+	if t.To == models.OrderStatusCompleted {
+		if err := s.releaseEscrow(sc, order, t); err != nil {
+			return err
+		}
+	}
+
+	// The buyer has the holding period from delivery to raise a problem
+	if t.To == models.OrderStatusDelivered {
+		if err := s.escrow.StartReleaseClock(sc, order.ID, now); err != nil {
+			return err
+		}
+	}
+
+	order.Status = t.To
+	order.UpdatedAt = now
+	return nil
+}
+
+// Notify tells the buyer and seller about an order's current status
+func (s *OrderService) Notify(ctx context.Context, order *models.Order) {
+	SendOrderUpdate(order.BuyerID.Hex(), *order)
+	SendOrderUpdate(order.SellerID.Hex(), *order)
+
+	var buyer models.User
+	if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": order.BuyerID}).Decode(&buyer); err != nil {
+		return
+	}
+	go s.email.SendOrderStatusEmail(
+		buyer.Email,
+		buyer.Profile.FirstName,
+		order.OrderNumber,
+		string(order.Status),
+	)
+}
+
+// releaseEscrow pays the seller as an order completes. An escrow that
+// was already settled (by the auto-release job or a dispute resolution) is
+// fine; one frozen by a dispute blocks completion.
+func (s *OrderService) releaseEscrow(sc mongo.SessionContext, order *models.Order, t OrderTransition) error {
+	reason := t.Note
+	if reason == "" {
+		reason = "Order completed"
+	}
+	err := s.escrow.ReleaseForOrder(sc, order.ID, t.ActorID, reason)
+	if err == nil || err == ErrEscrowNotFound {
+		return nil
+	}
+	if err == ErrEscrowNotHeld {
+		escrow, getErr := s.escrow.GetByOrder(sc, order.ID)
+		if getErr == nil && escrow.Status != models.EscrowStatusDisputed {
+			return nil
+		}
+	}
+	return err
+}
@@ -1,104 +1,714 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"log"
+	"strings"
 	"time"
 
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+	"autoboy-backend/models"
 	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// gatewayPreference is the fallback order when no rule picks a gateway
+var gatewayPreference = []models.PaymentGateway{
+	models.PaymentGatewayPaystack,
+	models.PaymentGatewayFlutterwave,
+	models.PaymentGatewayStripe,
+	models.PaymentGatewayFake,
+}
+
+// PaymentService selects a gateway for each payment and keeps a
+// models.Payment record of every initialize, verify, refund and transfer
 type PaymentService struct {
-	paystackSecretKey string
-	paystackPublicKey string
+	gateways         map[models.PaymentGateway]PaymentGateway
+	defaultGateway   models.PaymentGateway
+	currencyGateways map[string]models.PaymentGateway
+}
+
+// PaymentRequest describes a payment to collect through a gateway
+type PaymentRequest struct {
+	UserID      primitive.ObjectID
+	OrderID     *primitive.ObjectID
+	CheckoutID  *primitive.ObjectID
+	Type        models.PaymentType
+	Amount      float64
+	Currency    string
+	Email       string
+	Gateway     models.PaymentGateway // optional; overrides currency routing
+	CallbackURL string
+	Metadata    map[string]interface{}
+	IPAddress   string
+	UserAgent   string
+}
+
+// TransferRequest describes a payout to a bank account
+type TransferRequest struct {
+	UserID        primitive.ObjectID
+	Reference     string
+	Amount        float64
+	Currency      string
+	BankCode      string
+	AccountNumber string
+	AccountName   string
+	Reason        string
+	Gateway       models.PaymentGateway
+	Metadata      map[string]interface{}
 }
 
 func NewPaymentService() *PaymentService {
-	return &PaymentService{
-		paystackSecretKey: utils.GetEnv("PAYSTACK_SECRET_KEY", ""),
-		paystackPublicKey: utils.GetEnv("PAYSTACK_PUBLIC_KEY", ""),
+	s := &PaymentService{
+		gateways:         make(map[models.PaymentGateway]PaymentGateway),
+		defaultGateway:   models.PaymentGateway(utils.GetEnv("PAYMENT_DEFAULT_GATEWAY", "paystack")),
+		currencyGateways: parseCurrencyGateways(utils.GetEnv("PAYMENT_CURRENCY_GATEWAYS", "NGN:paystack,USD:stripe,GBP:stripe,EUR:stripe")),
+	}
+
+	if key := utils.GetEnv("PAYSTACK_SECRET_KEY", ""); key != "" {
+		s.RegisterGateway(NewPaystackGateway(key))
+	}
+	if key := utils.GetEnv("FLUTTERWAVE_SECRET_KEY", ""); key != "" {
+		s.RegisterGateway(NewFlutterwaveGateway(key, utils.GetEnv("FLUTTERWAVE_WEBHOOK_HASH", "")))
+	}
+	if key := utils.GetEnv("STRIPE_SECRET_KEY", ""); key != "" {
+		s.RegisterGateway(NewStripeGateway(key, utils.GetEnv("STRIPE_WEBHOOK_SECRET", "")))
+	}
+	if utils.GetEnvAsBool("PAYMENT_FAKE_GATEWAY", false) {
+		s.RegisterGateway(NewFakeGateway(utils.GetEnv("PAYMENT_FAKE_SECRET", "fake-secret")))
+		log.Println("Warning: fake payment gateway is enabled")
+	}
+
+	return s
+}
+
+// RegisterGateway adds or replaces a gateway adapter
+func (s *PaymentService) RegisterGateway(gateway PaymentGateway) {
+	s.gateways[gateway.Name()] = gateway
+}
+
+// Gateway returns a configured gateway by name
+func (s *PaymentService) Gateway(name models.PaymentGateway) (PaymentGateway, error) {
+	gateway, ok := s.gateways[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrGatewayNotConfigured, name)
 	}
+	return gateway, nil
 }
 
-func (s *PaymentService) InitializePayment(amount float64, email string) (map[string]interface{}, error) {
-	if s.paystackSecretKey == "" {
-		return nil, fmt.Errorf("paystack secret key not configured")
+// SelectGateway picks the gateway for a currency: an explicit preference,
+// then the PAYMENT_CURRENCY_GATEWAYS rule, then the default, then any
+// configured gateway that supports the currency
+func (s *PaymentService) SelectGateway(currency string, preferred models.PaymentGateway) (PaymentGateway, error) {
+	currency = strings.ToUpper(currency)
+
+	if preferred != "" {
+		gateway, err := s.Gateway(preferred)
+		if err != nil {
+			return nil, err
+		}
+		if !gateway.Supports(currency) {
+			return nil, fmt.Errorf("%s does not support %s", preferred, currency)
+		}
+		return gateway, nil
+	}
+
+	candidates := []models.PaymentGateway{}
+	if name, ok := s.currencyGateways[currency]; ok {
+		candidates = append(candidates, name)
 	}
+	candidates = append(candidates, s.defaultGateway)
+	candidates = append(candidates, gatewayPreference...)
 
-	// Create payment reference
+	for _, name := range candidates {
+		if gateway, ok := s.gateways[name]; ok && gateway.Supports(currency) {
+			return gateway, nil
+		}
+	}
+	return nil, fmt.Errorf("%w for %s", ErrGatewayNotConfigured, currency)
+}
+
+// InitializePayment records a pending payment and starts checkout with the selected gateway
+func (s *PaymentService) InitializePayment(ctx context.Context, req PaymentRequest) (*models.Payment, *GatewayInitializeResult, error) {
+	if req.Amount <= 0 {
+		return nil, nil, fmt.Errorf("payment amount must be positive")
+	}
+	req.Currency = strings.ToUpper(currencyOrDefault(req.Currency))
+
+	gateway, err := s.SelectGateway(req.Currency, req.Gateway)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
 	reference := utils.GeneratePaymentNumber()
+	fee := gateway.Fee(req.Amount, req.Currency)
+
+	payment := &models.Payment{
+		ID:               primitive.NewObjectID(),
+		PaymentNumber:    reference,
+		UserID:           req.UserID,
+		OrderID:          req.OrderID,
+		CheckoutID:       req.CheckoutID,
+		Amount:           req.Amount,
+		Currency:         req.Currency,
+		PaymentType:      req.Type,
+		PaymentMethod:    models.PaymentMethodCard,
+		PaymentGateway:   gateway.Name(),
+		GatewayReference: reference,
+		Status:           models.PaymentStatusPending,
+		MaxRetries:       3,
+		GatewayFee:       fee,
+		NetAmount:        utils.RoundCurrency(req.Amount - fee),
+		CustomerEmail:    req.Email,
+		Metadata:         req.Metadata,
+		IPAddress:        req.IPAddress,
+		UserAgent:        req.UserAgent,
+		InitiatedAt:      now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	if _, err := config.Coll.Payments.InsertOne(ctx, payment); err != nil {
+		return nil, nil, fmt.Errorf("failed to record payment: %w", err)
+	}
 
-	// Prepare Paystack request
-	payload := map[string]interface{}{
-		"email":     email,
-		"amount":    int(amount * 100), // Convert to kobo
-		"reference": reference,
-		"currency":  "NGN",
+	metadata := map[string]interface{}{
+		"payment_id":   payment.ID.Hex(),
+		"payment_type": string(req.Type),
+		"user_id":      req.UserID.Hex(),
+	}
+	for k, v := range req.Metadata {
+		metadata[k] = v
 	}
 
-	jsonData, err := json.Marshal(payload)
+	result, err := gateway.Initialize(ctx, GatewayInitializeRequest{
+		Reference:   reference,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Email:       req.Email,
+		CallbackURL: req.CallbackURL,
+		Metadata:    metadata,
+	})
+	if err != nil {
+		s.markFailed(ctx, payment.ID, err.Error())
+		return nil, nil, err
+	}
+
+	if result.GatewayPaymentID != "" {
+		payment.GatewayPaymentID = result.GatewayPaymentID
+		config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": payment.ID}, bson.M{"$set": bson.M{
+			"gateway_payment_id": result.GatewayPaymentID,
+		}})
+	}
+
+	return payment, result, nil
+}
+
+// VerifyPayment asks the gateway for the latest status of a payment and
+// stores it along with the actual fees
+func (s *PaymentService) VerifyPayment(ctx context.Context, reference string) (*models.Payment, error) {
+	payment, err := s.FindByReference(ctx, reference)
 	if err != nil {
 		return nil, err
 	}
+	return s.verify(ctx, payment)
+}
 
-	// Make request to Paystack
-	req, err := http.NewRequest("POST", "https://api.paystack.co/transaction/initialize", bytes.NewBuffer(jsonData))
+// VerifyUserPayment is VerifyPayment for a payment made by userID. Anyone
+// else's reference is reported as not found.
+func (s *PaymentService) VerifyUserPayment(ctx context.Context, userID primitive.ObjectID, reference string) (*models.Payment, error) {
+	var payment models.Payment
+	err := config.Coll.Payments.FindOne(ctx, bson.M{"gateway_reference": reference, "user_id": userID}).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPaymentNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	return s.verify(ctx, &payment)
+}
 
-	req.Header.Set("Authorization", "Bearer "+s.paystackSecretKey)
-	req.Header.Set("Content-Type", "application/json")
+func (s *PaymentService) verify(ctx context.Context, payment *models.Payment) (*models.Payment, error) {
+	gateway, err := s.Gateway(payment.PaymentGateway)
+	if err != nil {
+		return nil, err
+	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	result, err := gateway.Verify(ctx, payment.GatewayReference)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	now := time.Now()
+	set := bson.M{
+		"status":     result.Status,
+		"updated_at": now,
+	}
+	if result.GatewayPaymentID != "" {
+		set["gateway_payment_id"] = result.GatewayPaymentID
+		payment.GatewayPaymentID = result.GatewayPaymentID
+	}
+	if result.Channel != "" {
+		set["payment_method"] = models.PaymentMethod(result.Channel)
+		payment.PaymentMethod = models.PaymentMethod(result.Channel)
+	}
+
+	switch result.Status {
+	case models.PaymentStatusPaid:
+		if !currencyMatches(result.Currency, payment.Currency) {
+			set["status"] = models.PaymentStatusFailed
+			set["failure_reason"] = fmt.Sprintf("paid in %s but the payment is in %s", result.Currency, currencyOrDefault(payment.Currency))
+			set["failed_at"] = now
+			result.Status = models.PaymentStatusFailed
+			break
+		}
+		if utils.RoundCurrency(result.Amount) < utils.RoundCurrency(payment.Amount) {
+			set["status"] = models.PaymentStatusFailed
+			set["failure_reason"] = fmt.Sprintf("paid amount %.2f is less than expected %.2f", result.Amount, payment.Amount)
+			set["failed_at"] = now
+			result.Status = models.PaymentStatusFailed
+			break
+		}
+		fee := result.Fee
+		if fee == 0 {
+			fee = gateway.Fee(result.Amount, result.Currency)
+		}
+		set["gateway_fee"] = fee
+		set["net_amount"] = utils.RoundCurrency(result.Amount - fee - payment.PlatformFee)
+		set["is_verified"] = true
+		if payment.ConfirmedAt == nil {
+			set["confirmed_at"] = now
+			payment.ConfirmedAt = &now
+		}
+		payment.GatewayFee = fee
+		payment.NetAmount = utils.RoundCurrency(result.Amount - fee - payment.PlatformFee)
+		payment.IsVerified = true
+	case models.PaymentStatusFailed:
+		set["failed_at"] = now
+	case models.PaymentStatusCancelled:
+		set["cancelled_at"] = now
+	}
+
+	if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": payment.ID}, bson.M{"$set": set}); err != nil {
 		return nil, err
 	}
+	payment.Status = result.Status
 
-	if !result["status"].(bool) {
-		return nil, fmt.Errorf("payment initialization failed: %v", result["message"])
+	if payment.Status == models.PaymentStatusPaid {
+		if err := s.PostCapture(ctx, payment); err != nil {
+			return nil, err
+		}
 	}
+	return payment, nil
+}
+
+// ApplyPremium extends the payer's premium membership by the months a paid
+// premium payment bought, counting from the current expiry if it is still
+// running. Each payment is applied once however often it is verified.
+func (s *PaymentService) ApplyPremium(ctx context.Context, payment *models.Payment, months int) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		now := time.Now()
+		result, err := config.Coll.Payments.UpdateOne(sc, bson.M{
+			"_id":                payment.ID,
+			"status":             models.PaymentStatusPaid,
+			"premium_applied_at": bson.M{"$exists": false},
+		}, bson.M{"$set": bson.M{"premium_applied_at": now}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return nil
+		}
 
-	return result["data"].(map[string]interface{}), nil
+		var user struct {
+			PremiumExpiresAt *time.Time `bson:"premium_expires_at"`
+		}
+		if err := config.Coll.Users.FindOne(sc, bson.M{"_id": payment.UserID}).Decode(&user); err != nil {
+			return fmt.Errorf("user not found: %w", err)
+		}
+		from := now
+		if user.PremiumExpiresAt != nil && user.PremiumExpiresAt.After(now) {
+			from = *user.PremiumExpiresAt
+		}
+
+		_, err = config.Coll.Users.UpdateOne(sc, bson.M{"_id": payment.UserID}, bson.M{"$set": bson.M{
+			"premium_status":     "active",
+			"premium_expires_at": from.AddDate(0, months, 0),
+			"updated_at":         now,
+		}})
+		return err
+	})
+}
+
+// currencyMatches reports whether a gateway charged in the payment's currency
+func currencyMatches(charged, expected string) bool {
+	return strings.EqualFold(charged, currencyOrDefault(expected))
 }
 
-func (s *PaymentService) VerifyPayment(reference string) (map[string]interface{}, error) {
-	if s.paystackSecretKey == "" {
-		return nil, fmt.Errorf("paystack secret key not configured")
+// PostCapture records a paid gateway payment in the ledger: the money lands
+// in gateway clearing against whatever the payment was for, and the gateway
+// fee is expensed. It is safe to call again for the same payment.
+func (s *PaymentService) PostCapture(ctx context.Context, payment *models.Payment) error {
+	if payment.PaymentGateway == models.PaymentGatewayWallet {
+		return nil
 	}
 
-	// Make request to Paystack
-	req, err := http.NewRequest("GET", "https://api.paystack.co/transaction/verify/"+reference, nil)
-	if err != nil {
-		return nil, err
+	// Top-ups are posted by the wallet credit itself
+	if payment.PaymentType != models.PaymentTypeTopup {
+		_, err := ledger.Post(ctx, ledger.Entry{
+			IdempotencyKey: "capture:" + payment.ID.Hex(),
+			Description:    fmt.Sprintf("Payment %s captured by %s", payment.PaymentNumber, payment.PaymentGateway),
+			Currency:       payment.Currency,
+			ReferenceType:  "payment",
+			ReferenceID:    &payment.ID,
+			Lines: []ledger.Line{
+				ledger.Debit(ledger.AccountGatewayClearing, payment.Amount),
+				ledger.Credit(captureAccount(payment.PaymentType), payment.Amount),
+			},
+		})
+		if err != nil && err != ledger.ErrAlreadyPosted {
+			return err
+		}
 	}
 
-	req.Header.Set("Authorization", "Bearer "+s.paystackSecretKey)
+	return s.postGatewayFee(ctx, payment, payment.GatewayFee)
+}
+
+// postGatewayFee expenses the fee a gateway kept from a payment or transfer
+func (s *PaymentService) postGatewayFee(ctx context.Context, payment *models.Payment, fee float64) error {
+	if fee <= 0 {
+		return nil
+	}
+	_, err := ledger.Post(ctx, ledger.Entry{
+		IdempotencyKey: "fee:" + payment.ID.Hex(),
+		Description:    fmt.Sprintf("%s fee on %s", payment.PaymentGateway, payment.PaymentNumber),
+		Currency:       payment.Currency,
+		ReferenceType:  "payment",
+		ReferenceID:    &payment.ID,
+		Lines: []ledger.Line{
+			ledger.Debit(ledger.AccountGatewayFees, fee),
+			ledger.Credit(ledger.AccountGatewayClearing, fee),
+		},
+	})
+	if err == ledger.ErrAlreadyPosted {
+		return nil
+	}
+	return err
+}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+// captureAccount is the ledger account a captured payment is owed to
+func captureAccount(paymentType models.PaymentType) ledger.Account {
+	switch paymentType {
+	case models.PaymentTypeOrder, models.PaymentTypeEscrow:
+		return ledger.AccountEscrow
+	case models.PaymentTypePremium:
+		return ledger.AccountSubscriptionRevenue
+	}
+	return ledger.AccountSuspense
+}
+
+// refundAccount is the ledger account a gateway refund is paid out of
+func refundAccount(paymentType models.PaymentType) ledger.Account {
+	switch paymentType {
+	case models.PaymentTypeOrder, models.PaymentTypeEscrow:
+		return ledger.AccountEscrow
+	case models.PaymentTypePremium:
+		return ledger.AccountSubscriptionRevenue
+	}
+	return ledger.AccountRefunds
+}
+
+// RefundPayment refunds a paid payment through its gateway. The refund is
+// recorded as its own payment of type refund linked to the original.
+func (s *PaymentService) RefundPayment(ctx context.Context, original *models.Payment, amount float64, reason string) (*models.Payment, error) {
+	if original.Status != models.PaymentStatusPaid {
+		return nil, fmt.Errorf("only paid payments can be refunded")
+	}
+	if amount <= 0 {
+		amount = original.Amount
+	}
+	if utils.RoundCurrency(amount) > utils.RoundCurrency(original.Amount) {
+		return nil, fmt.Errorf("refund amount exceeds payment amount")
+	}
+
+	gateway, err := s.Gateway(original.PaymentGateway)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	result, err := gateway.Refund(ctx, GatewayRefundRequest{
+		Reference:        original.GatewayReference,
+		GatewayPaymentID: original.GatewayPaymentID,
+		Amount:           amount,
+		Currency:         original.Currency,
+		Reason:           reason,
+	})
+	if err != nil {
 		return nil, err
 	}
+	return s.RecordRefund(ctx, original, amount, reason, result)
+}
+
+// RecordRefund records a refund the gateway has made against a payment, as
+// a payment of type refund, and books it. RefundPayment calls it after the
+// gateway call; it is also used for refunds made from the gateway's
+// dashboard, which arrive only as a webhook.
+func (s *PaymentService) RecordRefund(ctx context.Context, original *models.Payment, amount float64, reason string, result *GatewayRefundResult) (*models.Payment, error) {
+	now := time.Now()
+	status := models.PaymentStatusPending
+	switch strings.ToLower(result.Status) {
+	case "processed", "succeeded", "completed":
+		status = models.PaymentStatusRefunded
+	case "failed":
+		status = models.PaymentStatusFailed
+	}
+
+	refund := &models.Payment{
+		ID:               primitive.NewObjectID(),
+		PaymentNumber:    utils.GeneratePaymentNumber(),
+		UserID:           original.UserID,
+		OrderID:          original.OrderID,
+		Amount:           amount,
+		Currency:         original.Currency,
+		PaymentType:      models.PaymentTypeRefund,
+		PaymentMethod:    original.PaymentMethod,
+		PaymentGateway:   original.PaymentGateway,
+		GatewayPaymentID: result.RefundID,
+		Status:           status,
+		NetAmount:        amount,
+		IsVerified:       true,
+		CustomerEmail:    original.CustomerEmail,
+		Metadata: map[string]interface{}{
+			"original_payment_id": original.ID.Hex(),
+			"original_reference":  original.GatewayReference,
+			"reason":              reason,
+		},
+		InitiatedAt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	refund.GatewayReference = refund.PaymentNumber
+	if status == models.PaymentStatusRefunded {
+		refund.RefundedAt = &now
+	}
+
+	if _, err := config.Coll.Payments.InsertOne(ctx, refund); err != nil {
+		return nil, fmt.Errorf("failed to record refund: %w", err)
+	}
+
+	if status != models.PaymentStatusFailed {
+		_, err := ledger.Post(ctx, ledger.Entry{
+			IdempotencyKey: "refund:" + refund.ID.Hex(),
+			Description:    fmt.Sprintf("Refund of %s via %s", original.PaymentNumber, original.PaymentGateway),
+			Currency:       original.Currency,
+			ReferenceType:  "payment",
+			ReferenceID:    &original.ID,
+			Lines: []ledger.Line{
+				ledger.Debit(refundAccount(original.PaymentType), amount),
+				ledger.Credit(ledger.AccountGatewayClearing, amount),
+			},
+			Metadata: map[string]interface{}{"refund_payment_id": refund.ID.Hex()},
+		})
+		if err != nil && err != ledger.ErrAlreadyPosted {
+			return nil, err
+		}
+	}
+	return refund, nil
+}
+
+// Transfer pays out to a bank account and records a withdrawal payment
+func (s *PaymentService) Transfer(ctx context.Context, req TransferRequest) (*models.Payment, *GatewayTransferResult, error) {
+	req.Currency = strings.ToUpper(currencyOrDefault(req.Currency))
+
+	gateway, err := s.SelectGateway(req.Currency, req.Gateway)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.Reference == "" {
+		req.Reference = utils.GeneratePaymentNumber()
+	}
+
+	now := time.Now()
+	payment := &models.Payment{
+		ID:               primitive.NewObjectID(),
+		PaymentNumber:    utils.GeneratePaymentNumber(),
+		UserID:           req.UserID,
+		Amount:           req.Amount,
+		Currency:         req.Currency,
+		PaymentType:      models.PaymentTypeWithdrawal,
+		PaymentMethod:    models.PaymentMethodBankTransfer,
+		PaymentGateway:   gateway.Name(),
+		GatewayReference: req.Reference,
+		Status:           models.PaymentStatusPending,
+		NetAmount:        req.Amount,
+		PaymentDetails: models.PaymentDetails{
+			BankCode:      req.BankCode,
+			AccountNumber: req.AccountNumber,
+			AccountName:   req.AccountName,
+		},
+		Metadata:    req.Metadata,
+		InitiatedAt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if _, err := config.Coll.Payments.InsertOne(ctx, payment); err != nil {
+		return nil, nil, fmt.Errorf("failed to record transfer: %w", err)
+	}
+
+	result, err := gateway.Transfer(ctx, GatewayTransferRequest{
+		Reference:     req.Reference,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		BankCode:      req.BankCode,
+		AccountNumber: req.AccountNumber,
+		AccountName:   req.AccountName,
+		Reason:        req.Reason,
+	})
+	if err != nil {
+		// Only a refused request is known to have sent nothing; otherwise the
+		// transfer stays pending for its webhook or VerifyTransfer
+		if errors.Is(err, ErrGatewayRejected) {
+			s.markFailed(ctx, payment.ID, err.Error())
+		}
+		return nil, nil, err
+	}
+
+	payment.GatewayPaymentID = result.TransferCode
+	payment.GatewayFee = result.Fee
+	config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": payment.ID}, bson.M{"$set": bson.M{
+		"gateway_payment_id": result.TransferCode,
+		"gateway_fee":        result.Fee,
+		"updated_at":         time.Now(),
+	}})
 
-	if !result["status"].(bool) {
-		return nil, fmt.Errorf("payment verification failed: %v", result["message"])
+	if err := s.postGatewayFee(ctx, payment, result.Fee); err != nil {
+		log.Printf("Failed to post transfer fee for %s: %v", payment.PaymentNumber, err)
 	}
 
-	return result["data"].(map[string]interface{}), nil
-}
\ No newline at end of file
+	return payment, result, nil
+}
+
+// VerifyTransfer asks the gateway that made a transfer how it ended and
+// records a final outcome on the transfer's payment. It returns
+// ErrPaymentNotFound when no transfer was ever started for reference, and
+// ErrGatewayNotFound when the gateway has no record of it.
+func (s *PaymentService) VerifyTransfer(ctx context.Context, reference string) (models.PaymentStatus, error) {
+	var payment models.Payment
+	err := config.Coll.Payments.FindOne(ctx, bson.M{
+		"gateway_reference": reference,
+		"payment_type":      models.PaymentTypeWithdrawal,
+	}).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrPaymentNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if payment.Status == models.PaymentStatusFailed {
+		return payment.Status, nil
+	}
+
+	gateway, err := s.Gateway(payment.PaymentGateway)
+	if err != nil {
+		return "", err
+	}
+	verifier, ok := gateway.(TransferVerifier)
+	if !ok {
+		return "", fmt.Errorf("%w: %s cannot look up transfers", ErrGatewayNotConfigured, payment.PaymentGateway)
+	}
+	result, err := verifier.VerifyTransfer(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	status := models.PaymentStatusPending
+	switch strings.ToLower(result.Status) {
+	case "success", "successful", "completed":
+		status = models.PaymentStatusPaid
+		set["confirmed_at"] = now
+	case "failed", "reversed":
+		status = models.PaymentStatusFailed
+		set["failure_reason"] = "transfer " + strings.ToLower(result.Status)
+		set["failed_at"] = now
+	default:
+		return status, nil
+	}
+	set["status"] = status
+	if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": payment.ID}, bson.M{"$set": set}); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// ResolveAccount looks up the holder's name on a bank account using the
+// first gateway for the currency that supports name resolution
+func (s *PaymentService) ResolveAccount(ctx context.Context, currency, bankCode, accountNumber string) (string, error) {
+	currency = strings.ToUpper(currencyOrDefault(currency))
+
+	candidates := []models.PaymentGateway{}
+	if name, ok := s.currencyGateways[currency]; ok {
+		candidates = append(candidates, name)
+	}
+	candidates = append(candidates, s.defaultGateway)
+	candidates = append(candidates, gatewayPreference...)
+
+	for _, name := range candidates {
+		gateway, ok := s.gateways[name]
+		if !ok || !gateway.Supports(currency) {
+			continue
+		}
+		if resolver, ok := gateway.(AccountResolver); ok {
+			return resolver.ResolveAccount(ctx, bankCode, accountNumber)
+		}
+	}
+	return "", fmt.Errorf("%w for account resolution in %s", ErrGatewayNotConfigured, currency)
+}
+
+// FindByReference loads a payment by its gateway reference
+func (s *PaymentService) FindByReference(ctx context.Context, reference string) (*models.Payment, error) {
+	var payment models.Payment
+	err := config.Coll.Payments.FindOne(ctx, bson.M{"gateway_reference": reference}).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (s *PaymentService) markFailed(ctx context.Context, paymentID primitive.ObjectID, reason string) {
+	now := time.Now()
+	config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": paymentID}, bson.M{"$set": bson.M{
+		"status":         models.PaymentStatusFailed,
+		"failure_reason": reason,
+		"failed_at":      now,
+		"updated_at":     now,
+	}})
+}
+
+// parseCurrencyGateways reads rules such as "NGN:paystack,USD:stripe"
+func parseCurrencyGateways(value string) map[string]models.PaymentGateway {
+	rules := make(map[string]models.PaymentGateway)
+	for _, rule := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rules[strings.ToUpper(parts[0])] = models.PaymentGateway(strings.ToLower(parts[1]))
+	}
+	return rules
+}
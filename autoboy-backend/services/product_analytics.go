@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductAnalyticsService rolls recorded views and funnel events up into
+// daily ProductAnalytics and reports them to sellers
+type ProductAnalyticsService struct{}
+
+// AnalyticsTotals are a listing's counts over a period
+type AnalyticsTotals struct {
+	Views          int     `json:"views"`
+	UniqueViews    int     `json:"unique_views"`
+	Saves          int     `json:"saves"`
+	Shares         int     `json:"shares"`
+	ContactSeller  int     `json:"contact_seller"`
+	SwapRequests   int     `json:"swap_requests"`
+	PurchaseClicks int     `json:"purchase_clicks"`
+	Orders         int     `json:"orders"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// ListingAnalytics is how a listing performed over a period. ViewCount is
+// its all-time view count.
+type ListingAnalytics struct {
+	ProductID primitive.ObjectID        `json:"product_id"`
+	Title     string                    `json:"title"`
+	Status    models.ProductStatus      `json:"status"`
+	ViewCount int                       `json:"view_count"`
+	Totals    AnalyticsTotals           `json:"totals"`
+	Days      []models.ProductAnalytics `json:"days,omitempty"`
+}
+
+func NewProductAnalyticsService() *ProductAnalyticsService {
+	return &ProductAnalyticsService{}
+}
+
+// AnalyticsDay returns the start of the UTC day t falls in, which is how
+// ProductAnalytics rows are dated
+func AnalyticsDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Rollup recomputes one UTC day's analytics for every listing with
+// activity that day. It can be re-run for the same day as late events
+// arrive: rows are overwritten and Product.ViewCount only moves by the
+// difference from the previous run. It returns how many listings were
+// rolled up.
+func (s *ProductAnalyticsService) Rollup(ctx context.Context, day time.Time) (int, error) {
+	start := AnalyticsDay(day)
+	end := start.Add(24 * time.Hour)
+	rows := make(map[primitive.ObjectID]*models.ProductAnalytics)
+	row := func(productID primitive.ObjectID) *models.ProductAnalytics {
+		r, ok := rows[productID]
+		if !ok {
+			r = &models.ProductAnalytics{ProductID: productID, Date: start}
+			rows[productID] = r
+		}
+		return r
+	}
+
+	views, err := config.Coll.ProductViews.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"viewed_at": bson.M{"$gte": start, "$lt": end}}},
+		{"$group": bson.M{
+			"_id":   "$product_id",
+			"views": bson.M{"$sum": 1},
+			"viewers": bson.M{"$addToSet": bson.M{"$ifNull": []interface{}{
+				bson.M{"$toString": "$user_id"}, "$session_id", "$ip_address",
+			}}},
+		}},
+		{"$project": bson.M{"views": 1, "unique_views": bson.M{"$size": "$viewers"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for views.Next(ctx) {
+		var result struct {
+			ProductID   primitive.ObjectID `bson:"_id"`
+			Views       int                `bson:"views"`
+			UniqueViews int                `bson:"unique_views"`
+		}
+		if err := views.Decode(&result); err != nil {
+			views.Close(ctx)
+			return 0, err
+		}
+		r := row(result.ProductID)
+		r.Views, r.UniqueViews = result.Views, result.UniqueViews
+	}
+	views.Close(ctx)
+	if err := views.Err(); err != nil {
+		return 0, err
+	}
+
+	events, err := config.Coll.ProductEvents.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"occurred_at": bson.M{"$gte": start, "$lt": end}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"product_id": "$product_id", "type": "$type"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for events.Next(ctx) {
+		var result struct {
+			ID struct {
+				ProductID primitive.ObjectID      `bson:"product_id"`
+				Type      models.ProductEventType `bson:"type"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		if err := events.Decode(&result); err != nil {
+			events.Close(ctx)
+			return 0, err
+		}
+		r := row(result.ID.ProductID)
+		switch result.ID.Type {
+		case models.ProductEventSave:
+			r.Saves = result.Count
+		case models.ProductEventShare:
+			r.Shares = result.Count
+		case models.ProductEventContactSeller:
+			r.ContactSeller = result.Count
+		case models.ProductEventSwapRequest:
+			r.SwapRequests = result.Count
+		case models.ProductEventPurchaseClick:
+			r.PurchaseClicks = result.Count
+		}
+	}
+	events.Close(ctx)
+	if err := events.Err(); err != nil {
+		return 0, err
+	}
+
+	orders, err := config.Coll.Orders.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"created_at": bson.M{"$gte": start, "$lt": end},
+			"status":     bson.M{"$ne": models.OrderStatusCancelled},
+		}},
+		{"$unwind": "$items"},
+		{"$group": bson.M{"_id": "$items.product_id", "orders": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for orders.Next(ctx) {
+		var result struct {
+			ProductID primitive.ObjectID `bson:"_id"`
+			Orders    int                `bson:"orders"`
+		}
+		if err := orders.Decode(&result); err != nil {
+			orders.Close(ctx)
+			return 0, err
+		}
+		row(result.ProductID).Orders = result.Orders
+	}
+	orders.Close(ctx)
+	if err := orders.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for productID, r := range rows {
+		r.ConversionRate = conversionRate(r.Orders, r.UniqueViews)
+		var previous models.ProductAnalytics
+		err := config.Coll.ProductAnalytics.FindOneAndUpdate(ctx,
+			bson.M{"product_id": productID, "date": start},
+			bson.M{
+				"$set": bson.M{
+					"views":           r.Views,
+					"unique_views":    r.UniqueViews,
+					"saves":           r.Saves,
+					"shares":          r.Shares,
+					"contact_seller":  r.ContactSeller,
+					"swap_requests":   r.SwapRequests,
+					"purchase_clicks": r.PurchaseClicks,
+					"orders":          r.Orders,
+					"conversion_rate": r.ConversionRate,
+					"updated_at":      now,
+				},
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+		).Decode(&previous)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, err
+		}
+
+		if delta := r.Views - previous.Views; delta != 0 {
+			_, err := config.Coll.Products.UpdateOne(ctx,
+				bson.M{"_id": productID},
+				bson.M{"$inc": bson.M{"view_count": delta}},
+			)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(rows), nil
+}
+
+// ForProduct reports a listing's daily analytics between two days,
+// inclusive
+func (s *ProductAnalyticsService) ForProduct(ctx context.Context, product *models.Product, from, to time.Time) (*ListingAnalytics, error) {
+	cursor, err := config.Coll.ProductAnalytics.Find(ctx,
+		bson.M{
+			"product_id": product.ID,
+			"date":       bson.M{"$gte": AnalyticsDay(from), "$lte": AnalyticsDay(to)},
+		},
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	days := []models.ProductAnalytics{}
+	if err := cursor.All(ctx, &days); err != nil {
+		return nil, err
+	}
+
+	listing := &ListingAnalytics{
+		ProductID: product.ID,
+		Title:     product.Title,
+		Status:    product.Status,
+		ViewCount: product.ViewCount,
+		Days:      days,
+	}
+	for _, day := range days {
+		listing.Totals.add(day)
+	}
+	listing.Totals.ConversionRate = conversionRate(listing.Totals.Orders, listing.Totals.UniqueViews)
+	return listing, nil
+}
+
+// ForSeller reports each of a seller's listings' totals between two days,
+// inclusive, most viewed first
+func (s *ProductAnalyticsService) ForSeller(ctx context.Context, sellerID primitive.ObjectID, from, to time.Time) ([]ListingAnalytics, error) {
+	cursor, err := config.Coll.Products.Find(ctx,
+		bson.M{"seller_id": sellerID, "status": bson.M{"$ne": models.ProductStatusDeleted}},
+		options.Find().SetProjection(bson.M{"title": 1, "status": 1, "view_count": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+
+	listings := make([]ListingAnalytics, 0, len(products))
+	byID := make(map[primitive.ObjectID]*ListingAnalytics, len(products))
+	ids := make([]primitive.ObjectID, 0, len(products))
+	for _, product := range products {
+		listings = append(listings, ListingAnalytics{
+			ProductID: product.ID,
+			Title:     product.Title,
+			Status:    product.Status,
+			ViewCount: product.ViewCount,
+		})
+		ids = append(ids, product.ID)
+	}
+	for i := range listings {
+		byID[listings[i].ProductID] = &listings[i]
+	}
+
+	rows, err := config.Coll.ProductAnalytics.Find(ctx, bson.M{
+		"product_id": bson.M{"$in": ids},
+		"date":       bson.M{"$gte": AnalyticsDay(from), "$lte": AnalyticsDay(to)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close(ctx)
+	for rows.Next(ctx) {
+		var day models.ProductAnalytics
+		if err := rows.Decode(&day); err != nil {
+			return nil, err
+		}
+		if listing, ok := byID[day.ProductID]; ok {
+			listing.Totals.add(day)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range listings {
+		listings[i].Totals.ConversionRate = conversionRate(listings[i].Totals.Orders, listings[i].Totals.UniqueViews)
+	}
+	sort.SliceStable(listings, func(i, j int) bool {
+		return listings[i].Totals.Views > listings[j].Totals.Views
+	})
+	return listings, nil
+}
+
+// add sums a day into the totals. Unique views are summed per day, so a
+// viewer who comes back on another day counts again.
+func (t *AnalyticsTotals) add(day models.ProductAnalytics) {
+	t.Views += day.Views
+	t.UniqueViews += day.UniqueViews
+	t.Saves += day.Saves
+	t.Shares += day.Shares
+	t.ContactSeller += day.ContactSeller
+	t.SwapRequests += day.SwapRequests
+	t.PurchaseClicks += day.PurchaseClicks
+	t.Orders += day.Orders
+}
+
+// conversionRate is orders per 100 unique views, to two decimal places
+func conversionRate(orders, uniqueViews int) float64 {
+	if uniqueViews == 0 {
+		return 0
+	}
+	return math.Round(float64(orders)/float64(uniqueViews)*10000) / 100
+}
@@ -0,0 +1,991 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"autoboy-backend/config"
+	"autoboy-backend/geo"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrImportFormat   = errors.New("unsupported import format, use csv or json")
+	ErrImportFile     = errors.New("invalid import file")
+	ErrImportTooLarge = errors.New("import file has too many rows")
+	ErrImportNotFound = errors.New("import not found")
+)
+
+const (
+	// specColumnPrefix marks a column holding a category attribute
+	specColumnPrefix = "spec."
+	// listSeparator separates values in tags, images and multi-select cells
+	listSeparator = "|"
+	// importProgressEvery is how many rows are processed between progress saves
+	importProgressEvery = 50
+)
+
+// ImportColumn documents one column of the bulk listing format. Required
+// columns are needed to create a listing; updates only need the SKU.
+type ImportColumn struct {
+	Name        string `json:"name"`
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+// ImportColumns is the bulk listing format shared by imports and exports,
+// in export order. A JSON file is an array of objects with the same keys;
+// it may give specifications as an object and lists as arrays.
+var ImportColumns = []ImportColumn{
+	{"sku", true, "Your stock-keeping unit. A row updates your listing with this SKU, or creates one if you have none"},
+	{"title", true, "5 to 200 characters"},
+	{"description", true, "20 to 5000 characters"},
+	{"category", true, "Category slug or ID"},
+	{"price", true, "Price as a plain number, e.g. 250000"},
+	{"currency", false, "Currency code; defaults to NGN"},
+	{"condition", true, "new, uk_used, nigeria_used or refurbished"},
+	{"quantity", false, "Units in stock; defaults to 1 for new listings"},
+	{"brand", false, ""},
+	{"model", false, ""},
+	{"color", false, ""},
+	{"address", false, "Street address"},
+	{"city", true, ""},
+	{"state", true, ""},
+	{"country", false, "Defaults to Nigeria"},
+	{"postal_code", false, ""},
+	{"latitude", false, "With longitude, pins the listing; otherwise the address is geocoded"},
+	{"longitude", false, ""},
+	{"swap_available", false, "true or false"},
+	{"tags", false, "Separated by " + listSeparator},
+	{"images", false, "Image URLs separated by " + listSeparator + "; the first is the main image"},
+	{specColumnPrefix + "<attribute>", false, "One column per category attribute, e.g. spec.storage. Multi-select values are separated by " + listSeparator},
+}
+
+// ProductImportService creates and updates a seller's listings in bulk from
+// CSV or JSON files, and exports their catalogue in the same format
+type ProductImportService struct {
+	search     *SearchService
+	maxRows    int
+	staleAfter time.Duration
+}
+
+// exportedListing is a listing in the bulk format
+type exportedListing struct {
+	SKU            string                 `json:"sku"`
+	Title          string                 `json:"title"`
+	Description    string                 `json:"description"`
+	Category       string                 `json:"category"`
+	Price          float64                `json:"price"`
+	Currency       string                 `json:"currency"`
+	Condition      string                 `json:"condition"`
+	Quantity       int                    `json:"quantity"`
+	Brand          string                 `json:"brand,omitempty"`
+	Model          string                 `json:"model,omitempty"`
+	Color          string                 `json:"color,omitempty"`
+	Address        string                 `json:"address,omitempty"`
+	City           string                 `json:"city"`
+	State          string                 `json:"state"`
+	Country        string                 `json:"country"`
+	PostalCode     string                 `json:"postal_code,omitempty"`
+	Latitude       *float64               `json:"latitude,omitempty"`
+	Longitude      *float64               `json:"longitude,omitempty"`
+	SwapAvailable  bool                   `json:"swap_available"`
+	Tags           []string               `json:"tags,omitempty"`
+	Images         []string               `json:"images,omitempty"`
+	Specifications map[string]interface{} `json:"specifications,omitempty"`
+}
+
+// importRun is the state shared by the rows of one import
+type importRun struct {
+	seller     *models.User
+	categories map[string]*models.Category // by slug and by ID
+	skus       map[string]int              // line each SKU was first seen on
+}
+
+func NewProductImportService(search *SearchService) *ProductImportService {
+	return &ProductImportService{
+		search:     search,
+		maxRows:    utils.GetEnvAsInt("PRODUCT_IMPORT_MAX_ROWS", 2000),
+		staleAfter: time.Duration(utils.GetEnvAsInt("PRODUCT_IMPORT_STALE_MINUTES", 10)) * time.Minute,
+	}
+}
+
+// Submit parses an uploaded file and queues its rows to be applied in the
+// background. Problems with the file as a whole are returned here; problems
+// with single rows are reported on the import as it runs.
+func (s *ProductImportService) Submit(ctx context.Context, sellerID primitive.ObjectID, format models.ProductImportFormat, fileName string, r io.Reader) (*models.ProductImport, error) {
+	rows, err := s.parse(format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	imp := &models.ProductImport{
+		ID:        primitive.NewObjectID(),
+		SellerID:  sellerID,
+		Format:    format,
+		FileName:  fileName,
+		Status:    models.ProductImportPending,
+		TotalRows: len(rows),
+		Errors:    []models.ProductImportError{},
+		Rows:      rows,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := config.Coll.ProductImports.InsertOne(ctx, imp); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		if _, err := s.processNext(ctx, bson.M{"_id": imp.ID, "status": models.ProductImportPending}); err != nil {
+			log.Printf("Failed to process product import %s: %v", imp.ID.Hex(), err)
+		}
+	}()
+
+	imp.Rows = nil
+	return imp, nil
+}
+
+// ProcessPending works through queued imports, and imports a restart left
+// half done, one at a time. Rows are applied by SKU, so running an import
+// again does not duplicate listings. It returns how many imports ran.
+func (s *ProductImportService) ProcessPending(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		found, err := s.processNext(ctx, bson.M{"$or": []bson.M{
+			{"status": models.ProductImportPending},
+			{"status": models.ProductImportProcessing, "updated_at": bson.M{"$lt": time.Now().Add(-s.staleAfter)}},
+		}})
+		if err != nil || !found {
+			return processed, err
+		}
+		processed++
+	}
+	return processed, ctx.Err()
+}
+
+// Imports returns the seller's imports, newest first, without their rows
+func (s *ProductImportService) Imports(ctx context.Context, sellerID primitive.ObjectID, limit int) ([]models.ProductImport, error) {
+	cursor, err := config.Coll.ProductImports.Find(ctx,
+		bson.M{"seller_id": sellerID},
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"rows": 0, "errors": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	imports := []models.ProductImport{}
+	if err := cursor.All(ctx, &imports); err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// Import returns one of the seller's imports with its row errors
+func (s *ProductImportService) Import(ctx context.Context, sellerID, importID primitive.ObjectID) (*models.ProductImport, error) {
+	var imp models.ProductImport
+	err := config.Coll.ProductImports.FindOne(ctx,
+		bson.M{"_id": importID, "seller_id": sellerID},
+		options.FindOne().SetProjection(bson.M{"rows": 0}),
+	).Decode(&imp)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+// Export writes the seller's listings in the bulk format. It returns how
+// many listings were written.
+func (s *ProductImportService) Export(ctx context.Context, sellerID primitive.ObjectID, format models.ProductImportFormat, w io.Writer) (int, error) {
+	if format != models.ProductImportCSV && format != models.ProductImportJSON {
+		return 0, ErrImportFormat
+	}
+
+	cursor, err := config.Coll.Products.Find(ctx,
+		bson.M{"seller_id": sellerID, "status": bson.M{"$ne": models.ProductStatusDeleted}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+
+	slugs, err := categorySlugs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	listings := make([]exportedListing, len(products))
+	for i := range products {
+		listings[i] = exportListing(&products[i], slugs)
+	}
+
+	if format == models.ProductImportJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return len(listings), encoder.Encode(listings)
+	}
+
+	specNames := make(map[string]bool)
+	for _, listing := range listings {
+		for name := range listing.Specifications {
+			specNames[name] = true
+		}
+	}
+	specColumns := make([]string, 0, len(specNames))
+	for name := range specNames {
+		specColumns = append(specColumns, name)
+	}
+	sort.Strings(specColumns)
+
+	header := make([]string, 0, len(ImportColumns)+len(specColumns))
+	for _, column := range ImportColumns[:len(ImportColumns)-1] {
+		header = append(header, column.Name)
+	}
+	for _, name := range specColumns {
+		header = append(header, specColumnPrefix+name)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+	for _, listing := range listings {
+		cells := listing.cells()
+		record := make([]string, len(header))
+		for i, column := range header {
+			if name, ok := strings.CutPrefix(column, specColumnPrefix); ok {
+				record[i] = specCell(listing.Specifications[name])
+			} else {
+				record[i] = cells[column]
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return 0, err
+		}
+	}
+	writer.Flush()
+	return len(listings), writer.Error()
+}
+
+// parse reads an import file into rows
+func (s *ProductImportService) parse(format models.ProductImportFormat, r io.Reader) ([]models.ProductImportRow, error) {
+	var rows []models.ProductImportRow
+	var err error
+	switch format {
+	case models.ProductImportCSV:
+		rows, err = parseImportCSV(r, s.maxRows)
+	case models.ProductImportJSON:
+		rows, err = parseImportJSON(r, s.maxRows)
+	default:
+		return nil, ErrImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no rows", ErrImportFile)
+	}
+	return rows, nil
+}
+
+func parseImportCSV(r io.Reader, maxRows int) ([]models.ProductImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = false
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: no header row", ErrImportFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportFile, err)
+	}
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))
+		if err := checkImportColumn(name); err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: column %q appears twice", ErrImportFile, name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+
+	var rows []models.ProductImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrImportFile, err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		row := models.ProductImportRow{Line: line, Fields: make(map[string]string)}
+		blank := true
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			blank = false
+			if name, ok := strings.CutPrefix(columns[i], specColumnPrefix); ok {
+				if row.Specifications == nil {
+					row.Specifications = make(map[string]interface{})
+				}
+				row.Specifications[name] = value
+			} else {
+				row.Fields[columns[i]] = value
+			}
+		}
+		if blank {
+			continue
+		}
+		if len(rows) == maxRows {
+			return nil, fmt.Errorf("%w: at most %d", ErrImportTooLarge, maxRows)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseImportJSON(r io.Reader, maxRows int) ([]models.ProductImportRow, error) {
+	var objects []map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&objects); err != nil {
+		return nil, fmt.Errorf("%w: expected an array of listings: %v", ErrImportFile, err)
+	}
+	if len(objects) > maxRows {
+		return nil, fmt.Errorf("%w: at most %d", ErrImportTooLarge, maxRows)
+	}
+
+	rows := make([]models.ProductImportRow, 0, len(objects))
+	for i, object := range objects {
+		row := models.ProductImportRow{Line: i + 1, Fields: make(map[string]string)}
+		for key, value := range object {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if key == "specifications" {
+				specs, ok := value.(map[string]interface{})
+				if !ok && value != nil {
+					return nil, fmt.Errorf("%w: listing %d: specifications must be an object", ErrImportFile, row.Line)
+				}
+				for name, spec := range specs {
+					if row.Specifications == nil {
+						row.Specifications = make(map[string]interface{})
+					}
+					row.Specifications[name] = spec
+				}
+				continue
+			}
+			if err := checkImportColumn(key); err != nil {
+				return nil, fmt.Errorf("listing %d: %w", row.Line, err)
+			}
+			if name, ok := strings.CutPrefix(key, specColumnPrefix); ok {
+				if row.Specifications == nil {
+					row.Specifications = make(map[string]interface{})
+				}
+				row.Specifications[name] = value
+				continue
+			}
+			cell, ok := jsonCell(value)
+			if !ok {
+				return nil, fmt.Errorf("%w: listing %d: %s must be text, a number, true/false or a list", ErrImportFile, row.Line, key)
+			}
+			if cell != "" {
+				row.Fields[key] = cell
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// checkImportColumn rejects columns that are not part of the format, so a
+// misspelt header fails the upload instead of being silently ignored
+func checkImportColumn(name string) error {
+	if spec, ok := strings.CutPrefix(name, specColumnPrefix); ok {
+		if strings.TrimSpace(spec) == "" {
+			return fmt.Errorf("%w: column %q has no attribute name", ErrImportFile, name)
+		}
+		return nil
+	}
+	for _, column := range ImportColumns {
+		if column.Name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown column %q", ErrImportFile, name)
+}
+
+// jsonCell turns a JSON value into the text a CSV cell would hold
+func jsonCell(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return strings.TrimSpace(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			part, ok := jsonCell(item)
+			if !ok {
+				return "", false
+			}
+			if _, nested := item.([]interface{}); nested {
+				return "", false
+			}
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return strings.Join(parts, listSeparator), true
+	}
+	return "", false
+}
+
+// processNext claims one import matching the filter and applies its rows.
+// It reports whether there was one to claim.
+func (s *ProductImportService) processNext(ctx context.Context, filter bson.M) (bool, error) {
+	now := time.Now()
+	var imp models.ProductImport
+	err := config.Coll.ProductImports.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{
+			"status":     models.ProductImportProcessing,
+			"processed":  0,
+			"created":    0,
+			"updated":    0,
+			"failed":     0,
+			"errors":     []models.ProductImportError{},
+			"started_at": now,
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&imp)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.process(ctx, &imp); err != nil {
+		if ctx.Err() != nil {
+			// Left processing, so it is picked up again once stale
+			return true, err
+		}
+		finished := time.Now()
+		_, uerr := config.Coll.ProductImports.UpdateOne(context.Background(), bson.M{"_id": imp.ID}, bson.M{
+			"$set":   bson.M{"status": models.ProductImportFailed, "message": err.Error(), "updated_at": finished, "completed_at": finished},
+			"$unset": bson.M{"rows": ""},
+		})
+		if uerr != nil {
+			log.Printf("Failed to mark product import %s failed: %v", imp.ID.Hex(), uerr)
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+// process applies every row of a claimed import, saving progress as it goes
+func (s *ProductImportService) process(ctx context.Context, imp *models.ProductImport) error {
+	var seller models.User
+	if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": imp.SellerID}).Decode(&seller); err != nil {
+		return fmt.Errorf("failed to load seller: %w", err)
+	}
+	categories, err := importCategories(ctx)
+	if err != nil {
+		return err
+	}
+	run := &importRun{seller: &seller, categories: categories, skus: make(map[string]int)}
+
+	var pending []models.ProductImportError
+	save := func(set bson.M) error {
+		set["processed"] = imp.Processed
+		set["created"] = imp.Created
+		set["updated"] = imp.Updated
+		set["failed"] = imp.Failed
+		set["updated_at"] = time.Now()
+		update := bson.M{"$set": set}
+		if len(pending) > 0 {
+			update["$push"] = bson.M{"errors": bson.M{"$each": pending}}
+		}
+		_, err := config.Coll.ProductImports.UpdateOne(ctx, bson.M{"_id": imp.ID}, update)
+		pending = nil
+		return err
+	}
+
+	for _, row := range imp.Rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		created, problems, err := s.applyRow(ctx, run, row)
+		if err != nil {
+			problems = map[string]string{"row": "could not be saved: " + err.Error()}
+		}
+		imp.Processed++
+		switch {
+		case len(problems) > 0:
+			imp.Failed++
+			pending = append(pending, models.ProductImportError{Line: row.Line, SKU: row.Fields["sku"], Errors: problems})
+		case created:
+			imp.Created++
+		default:
+			imp.Updated++
+		}
+		if imp.Processed%importProgressEvery == 0 {
+			if err := save(bson.M{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	now := time.Now()
+	if err := save(bson.M{"status": models.ProductImportCompleted, "completed_at": now}); err != nil {
+		return err
+	}
+	_, err = config.Coll.ProductImports.UpdateOne(ctx, bson.M{"_id": imp.ID}, bson.M{"$unset": bson.M{"rows": ""}})
+	return err
+}
+
+// applyRow creates or updates the seller's listing with the row's SKU. Cells
+// left blank keep a listing's current values. It returns the problems that
+// stopped the row, by column, or whether a listing was created.
+func (s *ProductImportService) applyRow(ctx context.Context, run *importRun, row models.ProductImportRow) (bool, map[string]string, error) {
+	fields := row.Fields
+	problems := make(map[string]string)
+
+	sku := fields["sku"]
+	if sku == "" {
+		problems["sku"] = "is required"
+		return false, problems, nil
+	}
+	if line, ok := run.skus[sku]; ok {
+		problems["sku"] = fmt.Sprintf("is already used on line %d", line)
+		return false, problems, nil
+	}
+	run.skus[sku] = row.Line
+
+	now := time.Now()
+	var product models.Product
+	err := config.Coll.Products.FindOne(ctx, bson.M{
+		"seller_id": run.seller.ID,
+		"sku":       sku,
+		"status":    bson.M{"$ne": models.ProductStatusDeleted},
+	}).Decode(&product)
+	exists := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, nil, err
+	}
+	if !exists {
+		product = models.Product{
+			ID:        primitive.NewObjectID(),
+			SellerID:  run.seller.ID,
+			SKU:       sku,
+			Currency:  "NGN",
+			Quantity:  1,
+			Status:    models.ProductStatusDraft,
+			CreatedAt: now,
+		}
+		product.Location.Country = "Nigeria"
+		if run.seller.UserType == models.UserTypeAdmin {
+			product.Status = models.ProductStatusActive
+			product.PublishedAt = &now
+		}
+	}
+	previousCategory := product.CategoryID
+
+	if v, ok := fields["title"]; ok {
+		product.Title = utils.SanitizeString(v)
+	}
+	if v, ok := fields["description"]; ok {
+		product.Description = utils.SanitizeString(v)
+	}
+	if v, ok := fields["category"]; ok {
+		if category, found := run.categories[strings.ToLower(v)]; found {
+			product.CategoryID = category.ID
+		} else {
+			problems["category"] = "is not an active category"
+		}
+	}
+	if v, ok := fields["price"]; ok {
+		if price, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", ""), 64); err != nil {
+			problems["price"] = "must be a number"
+		} else if price < 0 {
+			problems["price"] = "must not be negative"
+		} else {
+			product.Price = price
+		}
+	}
+	if v, ok := fields["currency"]; ok {
+		product.Currency = strings.ToUpper(v)
+	}
+	if v, ok := fields["condition"]; ok {
+		switch condition := models.ProductCondition(strings.ToLower(v)); condition {
+		case models.ProductConditionNew, models.ProductConditionUKUsed, models.ProductConditionNigeriaUsed, models.ProductConditionRefurbished:
+			product.Condition = condition
+		default:
+			problems["condition"] = "must be one of: new, uk_used, nigeria_used, refurbished"
+		}
+	}
+	if v, ok := fields["quantity"]; ok {
+		if quantity, err := strconv.Atoi(v); err != nil || quantity < 0 {
+			problems["quantity"] = "must be a whole number, 0 or more"
+		} else {
+			product.Quantity = quantity
+		}
+	}
+	if v, ok := fields["brand"]; ok {
+		product.Brand = v
+	}
+	if v, ok := fields["model"]; ok {
+		product.Model = v
+	}
+	if v, ok := fields["color"]; ok {
+		product.Color = v
+	}
+	if v, ok := fields["swap_available"]; ok {
+		switch strings.ToLower(v) {
+		case "true", "yes", "1":
+			product.SwapAvailable = true
+		case "false", "no", "0":
+			product.SwapAvailable = false
+		default:
+			problems["swap_available"] = "must be true or false"
+		}
+	}
+	if v, ok := fields["tags"]; ok {
+		product.Tags = splitList(v)
+	}
+	if v, ok := fields["images"]; ok {
+		product.Images = product.Images[:0:0]
+		for i, url := range splitList(v) {
+			product.Images = append(product.Images, models.ProductMedia{
+				ID:         primitive.NewObjectID(),
+				URL:        url,
+				Type:       "image",
+				SortOrder:  i,
+				IsMain:     i == 0,
+				UploadedAt: now,
+			})
+		}
+	}
+	s.applyLocation(&product, fields, problems)
+
+	if !product.CategoryID.IsZero() && problems["category"] == "" {
+		category := run.categories[product.CategoryID.Hex()]
+		if category == nil {
+			problems["category"] = "is not an active category"
+		} else if !exists || len(row.Specifications) > 0 || product.CategoryID != previousCategory {
+			specs := make(map[string]interface{}, len(product.Specifications)+len(row.Specifications))
+			for name, value := range product.Specifications {
+				specs[name] = value
+			}
+			for name, value := range importSpecifications(category.Attributes, row.Specifications) {
+				for existing := range specs {
+					if strings.EqualFold(existing, name) {
+						delete(specs, existing)
+					}
+				}
+				specs[name] = value
+			}
+			cleaned, specProblems := ValidateSpecifications(category.Attributes, specs)
+			for name, problem := range specProblems {
+				problems[specColumnPrefix+name] = problem
+			}
+			product.Specifications = cleaned
+		}
+	}
+
+	required := map[string]bool{
+		"title":       product.Title != "",
+		"description": product.Description != "",
+		"category":    !product.CategoryID.IsZero(),
+		"price":       exists || fields["price"] != "",
+		"condition":   product.Condition != "",
+		"city":        product.Location.City != "",
+		"state":       product.Location.State != "",
+	}
+	for column, present := range required {
+		if !present && problems[column] == "" {
+			problems[column] = "is required"
+		}
+	}
+	if n := utf8.RuneCountInString(product.Title); product.Title != "" && (n < 5 || n > 200) {
+		problems["title"] = "must be 5 to 200 characters"
+	}
+	if n := utf8.RuneCountInString(product.Description); product.Description != "" && (n < 20 || n > 5000) {
+		problems["description"] = "must be 20 to 5000 characters"
+	}
+	if len(problems) > 0 {
+		return false, problems, nil
+	}
+
+	product.IsInStock = product.Quantity > 0
+	product.UpdatedAt = now
+	if !exists {
+		if _, err := config.Coll.Products.InsertOne(ctx, product); err != nil {
+			return false, nil, err
+		}
+	} else {
+		_, err := config.Coll.Products.UpdateOne(ctx, bson.M{"_id": product.ID}, bson.M{"$set": bson.M{
+			"title":          product.Title,
+			"description":    product.Description,
+			"category_id":    product.CategoryID,
+			"price":          product.Price,
+			"currency":       product.Currency,
+			"condition":      product.Condition,
+			"quantity":       product.Quantity,
+			"is_in_stock":    product.IsInStock,
+			"brand":          product.Brand,
+			"model":          product.Model,
+			"color":          product.Color,
+			"location":       product.Location,
+			"swap_available": product.SwapAvailable,
+			"tags":           product.Tags,
+			"images":         product.Images,
+			"specifications": product.Specifications,
+			"updated_at":     now,
+		}})
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	if err := s.search.IndexProduct(ctx, product.ID); err != nil {
+		log.Printf("Failed to index product %s: %v", product.ID.Hex(), err)
+	}
+	return !exists, nil, nil
+}
+
+// applyLocation sets the listing's location from the row. Without
+// coordinates a changed address is geocoded, as for listings created one
+// at a time.
+func (s *ProductImportService) applyLocation(product *models.Product, fields map[string]string, problems map[string]string) {
+	location := &product.Location
+	moved := false
+	for column, target := range map[string]*string{
+		"address":     &location.Address,
+		"city":        &location.City,
+		"state":       &location.State,
+		"country":     &location.Country,
+		"postal_code": &location.PostalCode,
+	} {
+		if v, ok := fields[column]; ok {
+			if column == "address" {
+				v = utils.SanitizeString(v)
+			}
+			moved = moved || *target != v
+			*target = v
+		}
+	}
+
+	lat, hasLat := fields["latitude"]
+	lng, hasLng := fields["longitude"]
+	if hasLat || hasLng {
+		latitude, latErr := strconv.ParseFloat(lat, 64)
+		longitude, lngErr := strconv.ParseFloat(lng, 64)
+		coordinates := []float64{longitude, latitude}
+		if latErr != nil || lngErr != nil {
+			problems["latitude"] = "latitude and longitude must both be numbers"
+			return
+		}
+		if _, ok := geo.FromCoordinates(coordinates); !ok {
+			problems["latitude"] = "is not a valid position"
+			return
+		}
+		location.Coordinates = coordinates
+		return
+	}
+	if moved || len(location.Coordinates) == 0 {
+		location.Coordinates = nil
+		if place, ok := geo.Geocode(location.Address, location.City, location.State); ok {
+			location.Coordinates = place.Point.Coordinates()
+		}
+	}
+}
+
+// importSpecifications splits multi-select cells into their values, since
+// the bulk format separates them with listSeparator
+func importSpecifications(attributes []models.CategoryAttribute, specs map[string]interface{}) map[string]interface{} {
+	multi := make(map[string]bool)
+	for _, attr := range attributes {
+		if attr.Type == models.AttributeTypeMultiSelect {
+			multi[strings.ToLower(attr.Name)] = true
+		}
+	}
+	out := make(map[string]interface{}, len(specs))
+	for name, value := range specs {
+		if text, ok := value.(string); ok && multi[strings.ToLower(name)] {
+			out[name] = splitList(text)
+			continue
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// importCategories loads active categories keyed by lowercased slug and by ID
+func importCategories(ctx context.Context) (map[string]*models.Category, error) {
+	cursor, err := config.Coll.Categories.Find(ctx, bson.M{"is_active": true})
+	if err != nil {
+		return nil, err
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*models.Category, 2*len(categories))
+	for i := range categories {
+		category := &categories[i]
+		byKey[strings.ToLower(category.Slug)] = category
+		byKey[category.ID.Hex()] = category
+	}
+	return byKey, nil
+}
+
+// categorySlugs maps every category's ID to its slug
+func categorySlugs(ctx context.Context) (map[primitive.ObjectID]string, error) {
+	cursor, err := config.Coll.Categories.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"slug": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	slugs := make(map[primitive.ObjectID]string, len(categories))
+	for _, category := range categories {
+		slugs[category.ID] = category.Slug
+	}
+	return slugs, nil
+}
+
+func exportListing(product *models.Product, slugs map[primitive.ObjectID]string) exportedListing {
+	listing := exportedListing{
+		SKU:            product.SKU,
+		Title:          product.Title,
+		Description:    product.Description,
+		Category:       slugs[product.CategoryID],
+		Price:          product.Price,
+		Currency:       product.Currency,
+		Condition:      string(product.Condition),
+		Quantity:       product.Quantity,
+		Brand:          product.Brand,
+		Model:          product.Model,
+		Color:          product.Color,
+		Address:        product.Location.Address,
+		City:           product.Location.City,
+		State:          product.Location.State,
+		Country:        product.Location.Country,
+		PostalCode:     product.Location.PostalCode,
+		SwapAvailable:  product.SwapAvailable,
+		Tags:           product.Tags,
+		Specifications: product.Specifications,
+	}
+	if listing.Category == "" {
+		listing.Category = product.CategoryID.Hex()
+	}
+	if point, ok := geo.FromCoordinates(product.Location.Coordinates); ok {
+		listing.Latitude, listing.Longitude = &point.Lat, &point.Lng
+	}
+	images := append([]models.ProductMedia{}, product.Images...)
+	sort.SliceStable(images, func(i, j int) bool {
+		if images[i].IsMain != images[j].IsMain {
+			return images[i].IsMain
+		}
+		return images[i].SortOrder < images[j].SortOrder
+	})
+	for _, image := range images {
+		listing.Images = append(listing.Images, image.URL)
+	}
+	return listing
+}
+
+// cells returns the listing's CSV cells by column, apart from specifications
+func (l exportedListing) cells() map[string]string {
+	cells := map[string]string{
+		"sku":            l.SKU,
+		"title":          l.Title,
+		"description":    l.Description,
+		"category":       l.Category,
+		"price":          strconv.FormatFloat(l.Price, 'f', -1, 64),
+		"currency":       l.Currency,
+		"condition":      l.Condition,
+		"quantity":       strconv.Itoa(l.Quantity),
+		"brand":          l.Brand,
+		"model":          l.Model,
+		"color":          l.Color,
+		"address":        l.Address,
+		"city":           l.City,
+		"state":          l.State,
+		"country":        l.Country,
+		"postal_code":    l.PostalCode,
+		"swap_available": strconv.FormatBool(l.SwapAvailable),
+		"tags":           strings.Join(l.Tags, listSeparator),
+		"images":         strings.Join(l.Images, listSeparator),
+	}
+	if l.Latitude != nil && l.Longitude != nil {
+		cells["latitude"] = strconv.FormatFloat(*l.Latitude, 'f', -1, 64)
+		cells["longitude"] = strconv.FormatFloat(*l.Longitude, 'f', -1, 64)
+	}
+	return cells
+}
+
+// specCell formats a stored specification value as a CSV cell
+func specCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case primitive.A, []interface{}, []string:
+		return strings.Join(AttributeStrings(v), listSeparator)
+	}
+	return fmt.Sprint(value)
+}
+
+// splitList splits a list cell, dropping empty values
+func splitList(cell string) []string {
+	var values []string
+	for _, value := range strings.Split(cell, listSeparator) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPromoInvalid       = errors.New("invalid or expired promo code")
+	ErrPromoNotApplicable = errors.New("promo code does not apply to this purchase")
+)
+
+// premiumRank orders the tiers for codes that require a minimum tier
+var premiumRank = map[models.PremiumStatus]int{
+	models.PremiumStatusNone:    0,
+	models.PremiumStatusBasic:   1,
+	models.PremiumStatusPremium: 2,
+	models.PremiumStatusVIP:     3,
+}
+
+// PromoLine is one product in a purchase being priced for a promo code
+type PromoLine struct {
+	ProductID  primitive.ObjectID
+	CategoryID primitive.ObjectID
+	Amount     float64
+}
+
+// PromoService prices promo codes from the promo_codes collection. The
+// platform funds the discounts: a seller's escrow is still opened for the
+// full price, and the difference is booked to the promo subsidies account
+// when the order is paid.
+type PromoService struct{}
+
+func NewPromoService() *PromoService {
+	return &PromoService{}
+}
+
+// Quote checks that buyerID may use code on a purchase of lines plus
+// shipping, all in currency, and returns the code and its discount
+func (s *PromoService) Quote(ctx context.Context, code string, buyerID primitive.ObjectID, currency string, lines []PromoLine, shipping float64) (*models.PromoCode, float64, error) {
+	currency = currencyOrDefault(currency)
+	var promo models.PromoCode
+	err := config.Coll.PromoCodes.FindOne(ctx, bson.M{
+		"code":      strings.ToUpper(strings.TrimSpace(code)),
+		"is_active": true,
+	}).Decode(&promo)
+	if err == mongo.ErrNoDocuments {
+		return nil, 0, ErrPromoInvalid
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	if now.Before(promo.StartDate) || (!promo.EndDate.IsZero() && now.After(promo.EndDate)) {
+		return nil, 0, ErrPromoInvalid
+	}
+	if promo.UsageLimit > 0 && promo.UsageCount >= promo.UsageLimit {
+		return nil, 0, ErrPromoInvalid
+	}
+	// Fixed amounts are in the code's currency; nothing converts them
+	if promo.Type != models.PromoCodeTypePercentage && !currencyMatches(currency, promo.Currency) {
+		return nil, 0, fmt.Errorf("%w: the code is for %s purchases", ErrPromoNotApplicable, currencyOrDefault(promo.Currency))
+	}
+	if err := s.checkBuyer(ctx, &promo, buyerID); err != nil {
+		return nil, 0, err
+	}
+
+	eligible := 0.0
+	for _, line := range lines {
+		if promoCovers(&promo, line) {
+			eligible += line.Amount
+		}
+	}
+	eligible = utils.RoundCurrency(eligible)
+	if eligible <= 0 {
+		return nil, 0, fmt.Errorf("%w: none of the items qualify", ErrPromoNotApplicable)
+	}
+	if eligible < promo.MinOrderAmount {
+		return nil, 0, fmt.Errorf("%w: minimum order amount is %.2f", ErrPromoNotApplicable, promo.MinOrderAmount)
+	}
+
+	return &promo, promoDiscount(&promo, eligible, shipping), nil
+}
+
+// checkBuyer applies the code's per-user restrictions and usage limit
+func (s *PromoService) checkBuyer(ctx context.Context, promo *models.PromoCode, buyerID primitive.ObjectID) error {
+	if len(promo.EligibleUsers) > 0 && !hasObjectID(promo.EligibleUsers, buyerID) {
+		return ErrPromoNotApplicable
+	}
+
+	if promo.RequiredUserType != "" || promo.RequiredPremiumTier != "" {
+		var user models.User
+		if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": buyerID}).Decode(&user); err != nil {
+			return fmt.Errorf("user not found: %w", err)
+		}
+		if promo.RequiredUserType != "" && user.UserType != promo.RequiredUserType {
+			return ErrPromoNotApplicable
+		}
+		if promo.RequiredPremiumTier != "" && premiumRank[user.Profile.PremiumStatus] < premiumRank[promo.RequiredPremiumTier] {
+			return fmt.Errorf("%w: requires %s membership", ErrPromoNotApplicable, promo.RequiredPremiumTier)
+		}
+	}
+
+	if promo.UserUsageLimit > 0 {
+		used, err := config.Coll.PromoCodeUsage.CountDocuments(ctx, bson.M{"promo_code_id": promo.ID, "user_id": buyerID})
+		if err != nil {
+			return err
+		}
+		if used >= int64(promo.UserUsageLimit) {
+			return fmt.Errorf("%w: already used", ErrPromoNotApplicable)
+		}
+	}
+
+	if promo.FirstTimeUsersOnly {
+		paid, err := config.Coll.Orders.CountDocuments(ctx, bson.M{
+			"buyer_id":       buyerID,
+			"payment_status": bson.M{"$in": []models.PaymentStatus{models.PaymentStatusPaid, models.PaymentStatusRefunded}},
+		}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if paid > 0 {
+			return fmt.Errorf("%w: only for a first order", ErrPromoNotApplicable)
+		}
+	}
+	return nil
+}
+
+// redeemPromo records that a paid order used its promo code. It runs in the
+// transaction that opens the order's escrow, so once per order; the orders
+// of one checkout share a single use of the code.
+func redeemPromo(sc mongo.SessionContext, order *models.Order) error {
+	var promo models.PromoCode
+	err := config.Coll.PromoCodes.FindOne(sc, bson.M{"code": order.PromoCode}).Decode(&promo)
+	if err == mongo.ErrNoDocuments {
+		return nil // the code was deleted after checkout; the order keeps its discount
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	filter := bson.M{"promo_code_id": promo.ID, "user_id": order.BuyerID, "order_id": order.ID}
+	if order.CheckoutID != nil {
+		filter = bson.M{"promo_code_id": promo.ID, "user_id": order.BuyerID, "checkout_id": *order.CheckoutID}
+	}
+	result, err := config.Coll.PromoCodeUsage.UpdateOne(sc, filter, bson.M{
+		"$setOnInsert": bson.M{
+			"order_id": order.ID,
+			"code":     promo.Code,
+			"used_at":  now,
+		},
+		"$inc": bson.M{
+			"discount_amount": order.DiscountAmount,
+			"order_amount":    order.TotalAmount,
+		},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	if result.UpsertedCount == 0 {
+		return nil
+	}
+
+	_, err = config.Coll.PromoCodes.UpdateOne(sc, bson.M{"_id": promo.ID}, bson.M{
+		"$inc": bson.M{"usage_count": 1},
+		"$set": bson.M{"updated_at": now},
+	})
+	return err
+}
+
+// promoCovers reports whether a product counts towards the code's discount
+func promoCovers(promo *models.PromoCode, line PromoLine) bool {
+	if hasObjectID(promo.ExcludedProducts, line.ProductID) || hasObjectID(promo.ExcludedCategories, line.CategoryID) {
+		return false
+	}
+	if len(promo.ApplicableProducts) == 0 && len(promo.ApplicableCategories) == 0 {
+		return true
+	}
+	return hasObjectID(promo.ApplicableProducts, line.ProductID) || hasObjectID(promo.ApplicableCategories, line.CategoryID)
+}
+
+// promoDiscount is the code's discount on the eligible amount. Shipping
+// codes waive the shipping fee; the others never exceed what they apply to.
+func promoDiscount(promo *models.PromoCode, eligible, shipping float64) float64 {
+	var discount float64
+	switch promo.Type {
+	case models.PromoCodeTypePercentage:
+		discount = eligible * promo.DiscountValue / 100
+	case models.PromoCodeTypeFixed:
+		discount = promo.DiscountValue
+	case models.PromoCodeTypeShipping:
+		discount = shipping
+	}
+	if promo.MaxDiscount > 0 && discount > promo.MaxDiscount {
+		discount = promo.MaxDiscount
+	}
+	if promo.Type != models.PromoCodeTypeShipping && discount > eligible {
+		discount = eligible
+	}
+	return utils.RoundCurrency(discount)
+}
+
+func hasObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"testing"
+
+	"autoboy-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPromoDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		promo    models.PromoCode
+		eligible float64
+		shipping float64
+		want     float64
+	}{
+		{"percentage", models.PromoCode{Type: models.PromoCodeTypePercentage, DiscountValue: 10}, 5000, 0, 500},
+		{"percentage capped", models.PromoCode{Type: models.PromoCodeTypePercentage, DiscountValue: 10, MaxDiscount: 300}, 5000, 0, 300},
+		{"percentage rounded", models.PromoCode{Type: models.PromoCodeTypePercentage, DiscountValue: 15}, 222.22, 0, 33.33},
+		{"fixed", models.PromoCode{Type: models.PromoCodeTypeFixed, DiscountValue: 1000}, 5000, 0, 1000},
+		{"fixed above the eligible amount", models.PromoCode{Type: models.PromoCodeTypeFixed, DiscountValue: 2000}, 1500, 0, 1500},
+		{"shipping", models.PromoCode{Type: models.PromoCodeTypeShipping}, 500, 1200, 1200},
+		{"shipping capped", models.PromoCode{Type: models.PromoCodeTypeShipping, MaxDiscount: 1000}, 500, 1200, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := promoDiscount(&tt.promo, tt.eligible, tt.shipping); got != tt.want {
+				t.Fatalf("promoDiscount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromoCovers(t *testing.T) {
+	product, category := primitive.NewObjectID(), primitive.NewObjectID()
+	line := PromoLine{ProductID: product, CategoryID: category, Amount: 100}
+
+	tests := []struct {
+		name  string
+		promo models.PromoCode
+		want  bool
+	}{
+		{"no restrictions", models.PromoCode{}, true},
+		{"applicable product", models.PromoCode{ApplicableProducts: []primitive.ObjectID{product}}, true},
+		{"applicable category", models.PromoCode{ApplicableCategories: []primitive.ObjectID{category}}, true},
+		{"other products only", models.PromoCode{ApplicableProducts: []primitive.ObjectID{primitive.NewObjectID()}}, false},
+		{"excluded product", models.PromoCode{ExcludedProducts: []primitive.ObjectID{product}}, false},
+		{"excluded category wins", models.PromoCode{
+			ApplicableProducts: []primitive.ObjectID{product},
+			ExcludedCategories: []primitive.ObjectID{category},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := promoCovers(&tt.promo, line); got != tt.want {
+				t.Fatalf("promoCovers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount float64
+		shares   []float64
+		want     []float64
+	}{
+		{"proportional", 300, []float64{1000, 2000}, []float64{100, 200}},
+		{"last share takes the rounding", 100, []float64{1, 1, 1}, []float64{33.33, 33.33, 33.34}},
+		{"orders with no share", 10, []float64{0, 2, 1, 0}, []float64{0, 6.67, 3.33, 0}},
+		{"one share", 750, []float64{0, 4000}, []float64{0, 750}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitDiscount(tt.discount, tt.shares)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("splitDiscount() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reviewSignal is what a five star review adds to a product's popularity;
+// lower ratings add proportionally less
+const reviewSignal = 2.0
+
+// RankingService scores active listings by time-decayed popularity and
+// sets their hot, trending and new flags
+type RankingService struct {
+	search *SearchService
+
+	halfLife   time.Duration
+	window     time.Duration
+	boostScore float64
+	defaults   models.CategoryRanking
+}
+
+// RankingStats summarises one ranking run
+type RankingStats struct {
+	Ranked   int `json:"ranked"`
+	Hot      int `json:"hot"`
+	Trending int `json:"trending"`
+	New      int `json:"new"`
+	Updated  int `json:"updated"`
+}
+
+// popularitySignal is one kind of buyer activity that counts towards
+// popularity, read from the collection it is recorded in
+type popularitySignal struct {
+	coll      *mongo.Collection
+	match     bson.M
+	unwind    string      // array field holding the products, if any
+	timeField string      // when the activity happened
+	product   interface{} // expression for the product ID
+	weight    interface{} // expression for what one event is worth
+}
+
+type rankedProduct struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	CategoryID     primitive.ObjectID `bson:"category_id"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	PublishedAt    *time.Time         `bson:"published_at"`
+	Score          float64            `bson:"popularity_score"`
+	IsHot          bool               `bson:"is_hot"`
+	IsTrending     bool               `bson:"is_trending"`
+	IsNew          bool               `bson:"is_new"`
+	BoostLevel     int                `bson:"boost_level"`
+	BoostExpiresAt *time.Time         `bson:"boost_expires_at"`
+}
+
+func NewRankingService(search *SearchService) *RankingService {
+	return &RankingService{
+		search:     search,
+		halfLife:   time.Duration(utils.GetEnvAsInt("RANKING_HALF_LIFE_HOURS", 72)) * time.Hour,
+		window:     time.Duration(utils.GetEnvAsInt("RANKING_WINDOW_DAYS", 30)) * 24 * time.Hour,
+		boostScore: utils.GetEnvAsFloat("RANKING_BOOST_SCORE", 10),
+		defaults: models.CategoryRanking{
+			HotScore:        utils.GetEnvAsFloat("RANKING_HOT_SCORE", 50),
+			TrendingScore:   utils.GetEnvAsFloat("RANKING_TRENDING_SCORE", 10),
+			TrendingPercent: utils.GetEnvAsFloat("RANKING_TRENDING_PERCENT", 10),
+			NewDays:         utils.GetEnvAsInt("RANKING_NEW_DAYS", 7),
+		},
+	}
+}
+
+// Defaults are the thresholds used where a category sets none
+func (s *RankingService) Defaults() models.CategoryRanking {
+	return s.defaults
+}
+
+// Thresholds fills in a category's unset thresholds from the defaults
+func (s *RankingService) Thresholds(overrides *models.CategoryRanking) models.CategoryRanking {
+	thresholds := s.defaults
+	if overrides == nil {
+		return thresholds
+	}
+	if overrides.HotScore > 0 {
+		thresholds.HotScore = overrides.HotScore
+	}
+	if overrides.TrendingScore > 0 {
+		thresholds.TrendingScore = overrides.TrendingScore
+	}
+	if overrides.TrendingPercent > 0 {
+		thresholds.TrendingPercent = overrides.TrendingPercent
+	}
+	if overrides.NewDays > 0 {
+		thresholds.NewDays = overrides.NewDays
+	}
+	return thresholds
+}
+
+// Rank recomputes every active listing's popularity from the last
+// RANKING_WINDOW_DAYS of activity, each event's weight halving every
+// RANKING_HALF_LIFE_HOURS, and sets its flags against its category's
+// thresholds:
+//   - hot: popularity of at least HotScore
+//   - trending: among the top TrendingPercent of the category by
+//     popularity, with at least TrendingScore
+//   - new: went live within NewDays
+//
+// A bumped listing's stored popularity gets RANKING_BOOST_SCORE per boost
+// level on top, so it sorts higher while the bump runs; its flags are set
+// from its organic popularity alone.
+func (s *RankingService) Rank(ctx context.Context) (*RankingStats, error) {
+	now := time.Now()
+	scores := make(map[primitive.ObjectID]float64)
+	for _, signal := range s.signals() {
+		if err := s.addDecayed(ctx, scores, signal, now); err != nil {
+			return nil, err
+		}
+	}
+
+	thresholds, err := s.categoryThresholds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := config.Coll.Products.Find(ctx,
+		bson.M{"status": models.ProductStatusActive},
+		options.Find().SetProjection(bson.M{
+			"category_id": 1, "created_at": 1, "published_at": 1,
+			"popularity_score": 1, "is_hot": 1, "is_trending": 1, "is_new": 1,
+			"boost_level": 1, "boost_expires_at": 1,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var products []rankedProduct
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[primitive.ObjectID][]*rankedProduct)
+	for i := range products {
+		p := &products[i]
+		byCategory[p.CategoryID] = append(byCategory[p.CategoryID], p)
+	}
+
+	stats := &RankingStats{Ranked: len(products)}
+	popularity := make(map[primitive.ObjectID]float64, len(products))
+	var writes []mongo.WriteModel
+	for categoryID, listings := range byCategory {
+		t, ok := thresholds[categoryID]
+		if !ok {
+			t = s.defaults
+		}
+		sort.Slice(listings, func(i, j int) bool {
+			return scores[listings[i].ID] > scores[listings[j].ID]
+		})
+		trendingSlots := int(math.Ceil(float64(len(listings)) * t.TrendingPercent / 100))
+		newSince := now.AddDate(0, 0, -t.NewDays)
+
+		for rank, p := range listings {
+			score := math.Round(scores[p.ID]*100) / 100
+			stored := score
+			if p.BoostLevel > 0 && p.BoostExpiresAt != nil && p.BoostExpiresAt.After(now) {
+				stored += float64(p.BoostLevel) * s.boostScore
+			}
+			published := p.CreatedAt
+			if p.PublishedAt != nil {
+				published = *p.PublishedAt
+			}
+			hot := score >= t.HotScore
+			trending := rank < trendingSlots && score >= t.TrendingScore
+			isNew := published.After(newSince)
+
+			popularity[p.ID] = stored
+			if hot {
+				stats.Hot++
+			}
+			if trending {
+				stats.Trending++
+			}
+			if isNew {
+				stats.New++
+			}
+			if stored == p.Score && hot == p.IsHot && trending == p.IsTrending && isNew == p.IsNew {
+				continue
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": p.ID}).
+				SetUpdate(bson.M{"$set": bson.M{
+					"popularity_score": stored,
+					"is_hot":           hot,
+					"is_trending":      trending,
+					"is_new":           isNew,
+				}}))
+		}
+	}
+
+	for start := 0; start < len(writes); start += bulkWriteBatch {
+		end := min(start+bulkWriteBatch, len(writes))
+		result, err := config.Coll.Products.BulkWrite(ctx, writes[start:end], options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return stats, err
+		}
+		stats.Updated += int(result.ModifiedCount)
+	}
+
+	// Listings that stopped being active keep no flags
+	_, err = config.Coll.Products.UpdateMany(ctx,
+		bson.M{
+			"status": bson.M{"$ne": models.ProductStatusActive},
+			"$or":    []bson.M{{"is_hot": true}, {"is_trending": true}, {"is_new": true}},
+		},
+		bson.M{"$set": bson.M{"is_hot": false, "is_trending": false, "is_new": false}},
+	)
+	if err != nil {
+		return stats, err
+	}
+
+	s.search.SetPopularity(popularity)
+	return stats, nil
+}
+
+// signals lists the activity popularity is built from
+func (s *RankingService) signals() []popularitySignal {
+	return []popularitySignal{
+		{coll: config.Coll.ProductViews, timeField: "viewed_at", product: "$product_id", weight: viewSignal},
+		{
+			coll:      config.Coll.UserActivities,
+			match:     bson.M{"action": models.ActivityWishlistAdd, "resource": "product"},
+			timeField: "timestamp",
+			product: bson.M{"$convert": bson.M{
+				"input": "$resource_id", "to": "objectId", "onError": nil, "onNull": nil,
+			}},
+			weight: wishlistSignal,
+		},
+		{coll: config.Coll.CartItems, timeField: "created_at", product: "$product_id", weight: cartSignal},
+		{
+			coll:      config.Coll.Orders,
+			match:     bson.M{"status": bson.M{"$ne": models.OrderStatusCancelled}},
+			unwind:    "items",
+			timeField: "created_at",
+			product:   "$items.product_id",
+			weight:    bson.M{"$multiply": []interface{}{purchaseSignal, bson.M{"$max": []interface{}{"$items.quantity", 1}}}},
+		},
+		{
+			coll:      config.Coll.ProductReviews,
+			match:     bson.M{"is_approved": true},
+			timeField: "created_at",
+			product:   "$product_id",
+			weight:    bson.M{"$multiply": []interface{}{reviewSignal / 5, "$rating"}},
+		},
+	}
+}
+
+// addDecayed adds each product's decayed activity from one signal to scores
+func (s *RankingService) addDecayed(ctx context.Context, scores map[primitive.ObjectID]float64, signal popularitySignal, now time.Time) error {
+	match := bson.M{signal.timeField: bson.M{"$gte": now.Add(-s.window), "$lte": now}}
+	for key, value := range signal.match {
+		match[key] = value
+	}
+	pipeline := []bson.M{{"$match": match}}
+	if signal.unwind != "" {
+		pipeline = append(pipeline, bson.M{"$unwind": "$" + signal.unwind})
+	}
+	decay := bson.M{"$pow": []interface{}{0.5, bson.M{"$divide": []interface{}{
+		bson.M{"$subtract": []interface{}{now, "$" + signal.timeField}},
+		s.halfLife.Milliseconds(),
+	}}}}
+	pipeline = append(pipeline,
+		bson.M{"$group": bson.M{
+			"_id":   signal.product,
+			"score": bson.M{"$sum": bson.M{"$multiply": []interface{}{signal.weight, decay}}},
+		}},
+		bson.M{"$match": bson.M{"_id": bson.M{"$ne": nil}}},
+	)
+
+	cursor, err := signal.coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			ProductID primitive.ObjectID `bson:"_id"`
+			Score     float64            `bson:"score"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return err
+		}
+		scores[row.ProductID] += row.Score
+	}
+	return cursor.Err()
+}
+
+// categoryThresholds resolves every category's ranking thresholds
+func (s *RankingService) categoryThresholds(ctx context.Context) (map[primitive.ObjectID]models.CategoryRanking, error) {
+	cursor, err := config.Coll.Categories.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"ranking": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	thresholds := make(map[primitive.ObjectID]models.CategoryRanking, len(categories))
+	for _, category := range categories {
+		thresholds[category.ID] = s.Thresholds(category.Ranking)
+	}
+	return thresholds, nil
+}
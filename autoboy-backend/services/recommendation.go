@@ -0,0 +1,659 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How strongly each thing a buyer does with a product signals interest in it
+const (
+	viewSignal     = 1.0
+	wishlistSignal = 3.0
+	cartSignal     = 4.0
+	purchaseSignal = 5.0
+)
+
+const (
+	// maxBasketSize bounds how many of one buyer's products are paired with
+	// each other, keeping the pair count quadratic in a small number
+	maxBasketSize = 50
+	// minPairCount is how many buyers must share two products before one
+	// is recommended from the other
+	minPairCount = 2
+	// bulkWriteBatch is how many writes the background jobs send at once
+	bulkWriteBatch = 500
+)
+
+// RecommendationService precomputes "customers also viewed/bought" tables
+// and per-user feeds from views, wishlists, carts and orders, and serves
+// them from the recommendations collection. Trending products come from
+// the ranking job's flags.
+type RecommendationService struct {
+	window   time.Duration
+	listSize int
+}
+
+// ProductRecommendations are the products shown alongside a listing
+type ProductRecommendations struct {
+	AlsoBought []models.Product `json:"also_bought"`
+	AlsoViewed []models.Product `json:"also_viewed"`
+	Similar    []models.Product `json:"similar"`
+}
+
+// RecommendedProduct is a feed entry with the list it came from
+type RecommendedProduct struct {
+	models.Product `bson:",inline"`
+	Reason         models.RecommendationKind `json:"reason"`
+}
+
+// HomeRecommendations are the lists shown on the home page. Guests only
+// get trending products.
+type HomeRecommendations struct {
+	ForYou   []RecommendedProduct `json:"for_you,omitempty"`
+	Trending []models.Product     `json:"trending"`
+}
+
+// RecommendationStats summarises one recompute
+type RecommendationStats struct {
+	AlsoViewed int `json:"also_viewed"`
+	AlsoBought int `json:"also_bought"`
+	Users      int `json:"users"`
+}
+
+func NewRecommendationService() *RecommendationService {
+	return &RecommendationService{
+		window:   time.Duration(utils.GetEnvAsInt("RECOMMENDATION_WINDOW_DAYS", 90)) * 24 * time.Hour,
+		listSize: utils.GetEnvAsInt("RECOMMENDATION_LIST_SIZE", 30),
+	}
+}
+
+// buyerSignals is what one buyer (a user, or an anonymous session) has
+// done, keeping the strongest signal per product
+type buyerSignals struct {
+	userID   *primitive.ObjectID
+	interest map[primitive.ObjectID]float64
+	bought   map[primitive.ObjectID]bool
+}
+
+func (b *buyerSignals) add(productID primitive.ObjectID, weight float64) {
+	if weight > b.interest[productID] {
+		b.interest[productID] = weight
+	}
+}
+
+// recommendationSignals collects buyer activity for one recompute
+type recommendationSignals struct {
+	since  time.Time
+	buyers map[string]*buyerSignals
+}
+
+func (s *recommendationSignals) user(userID primitive.ObjectID) *buyerSignals {
+	return s.buyer(userID.Hex(), &userID)
+}
+
+func (s *recommendationSignals) buyer(key string, userID *primitive.ObjectID) *buyerSignals {
+	b, ok := s.buyers[key]
+	if !ok {
+		b = &buyerSignals{
+			userID:   userID,
+			interest: make(map[primitive.ObjectID]float64),
+			bought:   make(map[primitive.ObjectID]bool),
+		}
+		s.buyers[key] = b
+	}
+	return b
+}
+
+// Recompute rebuilds every recommendation list from activity in the last
+// RECOMMENDATION_WINDOW_DAYS and replaces the stored lists
+func (s *RecommendationService) Recompute(ctx context.Context) (*RecommendationStats, error) {
+	now := time.Now()
+	active, err := activeSellers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	signals := &recommendationSignals{
+		since:  now.Add(-s.window),
+		buyers: make(map[string]*buyerSignals),
+	}
+	loaders := []func(context.Context, *recommendationSignals) error{
+		loadViewSignals, loadWishlistSignals, loadCartSignals, loadOrderSignals,
+	}
+	for _, load := range loaders {
+		if err := load(ctx, signals); err != nil {
+			return nil, err
+		}
+	}
+
+	var interest, purchases [][]primitive.ObjectID
+	for _, buyer := range signals.buyers {
+		interest = append(interest, basket(buyer.interest))
+		if len(buyer.bought) > 1 {
+			bought := make(map[primitive.ObjectID]float64, len(buyer.bought))
+			for id := range buyer.bought {
+				bought[id] = purchaseSignal
+			}
+			purchases = append(purchases, basket(bought))
+		}
+	}
+	alsoViewed := coOccurrence(interest, active, s.listSize)
+	alsoBought := coOccurrence(purchases, active, s.listSize)
+
+	var lists []models.Recommendation
+	stats := &RecommendationStats{}
+	addLists := func(kind models.RecommendationKind, neighbours map[primitive.ObjectID][]models.RecommendedItem) int {
+		count := 0
+		for id, items := range neighbours {
+			if _, ok := active[id]; !ok {
+				continue
+			}
+			subjectID := id
+			lists = append(lists, models.Recommendation{Kind: kind, SubjectID: &subjectID, Items: items, UpdatedAt: now})
+			count++
+		}
+		return count
+	}
+	stats.AlsoViewed = addLists(models.RecommendationAlsoViewed, alsoViewed)
+	stats.AlsoBought = addLists(models.RecommendationAlsoBought, alsoBought)
+
+	for _, buyer := range signals.buyers {
+		if buyer.userID == nil {
+			continue
+		}
+		if items := s.feed(buyer, alsoViewed, alsoBought, active); len(items) > 0 {
+			lists = append(lists, models.Recommendation{Kind: models.RecommendationForUser, SubjectID: buyer.userID, Items: items, UpdatedAt: now})
+			stats.Users++
+		}
+	}
+
+	if err := saveRecommendations(ctx, lists); err != nil {
+		return stats, err
+	}
+	_, err = config.Coll.Recommendations.DeleteMany(ctx, bson.M{"updated_at": bson.M{"$lt": now}})
+	return stats, err
+}
+
+// feed scores the products related to what a user has looked at and
+// bought, leaving out ones they already know about and their own listings
+func (s *RecommendationService) feed(buyer *buyerSignals, alsoViewed, alsoBought map[primitive.ObjectID][]models.RecommendedItem, active map[primitive.ObjectID]primitive.ObjectID) []models.RecommendedItem {
+	scores := make(map[primitive.ObjectID]float64)
+	for id, weight := range buyer.interest {
+		for _, item := range alsoViewed[id] {
+			scores[item.ProductID] += weight * item.Score
+		}
+	}
+	for id := range buyer.bought {
+		for _, item := range alsoBought[id] {
+			scores[item.ProductID] += purchaseSignal * item.Score
+		}
+	}
+	for id := range scores {
+		if _, seen := buyer.interest[id]; seen || active[id] == *buyer.userID {
+			delete(scores, id)
+		}
+	}
+	return topItems(scores, s.listSize)
+}
+
+// activeSellers maps each active listing to its seller
+func activeSellers(ctx context.Context) (map[primitive.ObjectID]primitive.ObjectID, error) {
+	cursor, err := config.Coll.Products.Find(ctx,
+		bson.M{"status": models.ProductStatusActive},
+		options.Find().SetProjection(bson.M{"seller_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	active := make(map[primitive.ObjectID]primitive.ObjectID)
+	for cursor.Next(ctx) {
+		var row struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			SellerID primitive.ObjectID `bson:"seller_id"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		active[row.ID] = row.SellerID
+	}
+	return active, cursor.Err()
+}
+
+// loadViewSignals reads product views. Signed-in views belong to the user
+// and anonymous ones to their session; views with neither are skipped.
+func loadViewSignals(ctx context.Context, signals *recommendationSignals) error {
+	cursor, err := config.Coll.ProductViews.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"viewed_at": bson.M{"$gte": signals.since}}},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"product_id": "$product_id",
+				"viewer": bson.M{"$ifNull": []interface{}{
+					bson.M{"$toString": "$user_id"},
+					bson.M{"$concat": []interface{}{"session:", "$session_id"}},
+				}},
+			},
+			"user_id": bson.M{"$first": "$user_id"},
+		}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			ID struct {
+				ProductID primitive.ObjectID `bson:"product_id"`
+				Viewer    *string            `bson:"viewer"`
+			} `bson:"_id"`
+			UserID *primitive.ObjectID `bson:"user_id"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return err
+		}
+		if row.ID.Viewer != nil {
+			signals.buyer(*row.ID.Viewer, row.UserID).add(row.ID.ProductID, viewSignal)
+		}
+	}
+	return cursor.Err()
+}
+
+func loadWishlistSignals(ctx context.Context, signals *recommendationSignals) error {
+	cursor, err := config.Coll.Wishlists.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"user_id": 1, "product_ids": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var wishlist models.ProductWishlist
+		if err := cursor.Decode(&wishlist); err != nil {
+			return err
+		}
+		buyer := signals.user(wishlist.UserID)
+		for _, id := range wishlist.ProductIDs {
+			buyer.add(id, wishlistSignal)
+		}
+	}
+	return cursor.Err()
+}
+
+func loadCartSignals(ctx context.Context, signals *recommendationSignals) error {
+	cursor, err := config.Coll.CartItems.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"user_id": 1, "product_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var item struct {
+			UserID    primitive.ObjectID `bson:"user_id"`
+			ProductID primitive.ObjectID `bson:"product_id"`
+		}
+		if err := cursor.Decode(&item); err != nil {
+			return err
+		}
+		signals.user(item.UserID).add(item.ProductID, cartSignal)
+	}
+	return cursor.Err()
+}
+
+func loadOrderSignals(ctx context.Context, signals *recommendationSignals) error {
+	cursor, err := config.Coll.Orders.Find(ctx,
+		bson.M{
+			"created_at": bson.M{"$gte": signals.since},
+			"status":     bson.M{"$ne": models.OrderStatusCancelled},
+		},
+		options.Find().SetProjection(bson.M{"buyer_id": 1, "items.product_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var order models.Order
+		if err := cursor.Decode(&order); err != nil {
+			return err
+		}
+		buyer := signals.user(order.BuyerID)
+		for _, item := range order.Items {
+			buyer.add(item.ProductID, purchaseSignal)
+			buyer.bought[item.ProductID] = true
+		}
+	}
+	return cursor.Err()
+}
+
+// basket lists a buyer's products, strongest interest first, capped at
+// maxBasketSize
+func basket(interest map[primitive.ObjectID]float64) []primitive.ObjectID {
+	items := topItems(interest, maxBasketSize)
+	ids := make([]primitive.ObjectID, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+	return ids
+}
+
+// coOccurrence counts how many baskets each pair of products shares and
+// scores each pair by cosine similarity, so products everyone looks at do
+// not crowd out everything else. It returns each product's most similar
+// active products.
+func coOccurrence(baskets [][]primitive.ObjectID, active map[primitive.ObjectID]primitive.ObjectID, size int) map[primitive.ObjectID][]models.RecommendedItem {
+	type pair struct{ a, b primitive.ObjectID }
+	counts := make(map[primitive.ObjectID]int)
+	pairs := make(map[pair]int)
+	for _, ids := range baskets {
+		for i, a := range ids {
+			counts[a]++
+			for _, b := range ids[i+1:] {
+				if bytes.Compare(a[:], b[:]) > 0 {
+					pairs[pair{b, a}]++
+				} else {
+					pairs[pair{a, b}]++
+				}
+			}
+		}
+	}
+
+	scores := make(map[primitive.ObjectID]map[primitive.ObjectID]float64)
+	link := func(from, to primitive.ObjectID, score float64) {
+		if _, ok := active[to]; !ok {
+			return
+		}
+		if scores[from] == nil {
+			scores[from] = make(map[primitive.ObjectID]float64)
+		}
+		scores[from][to] = score
+	}
+	for p, n := range pairs {
+		if n < minPairCount {
+			continue
+		}
+		score := float64(n) / math.Sqrt(float64(counts[p.a])*float64(counts[p.b]))
+		link(p.a, p.b, score)
+		link(p.b, p.a, score)
+	}
+
+	neighbours := make(map[primitive.ObjectID][]models.RecommendedItem, len(scores))
+	for id, related := range scores {
+		neighbours[id] = topItems(related, size)
+	}
+	return neighbours
+}
+
+// topItems returns the n highest scoring products, best first
+func topItems(scores map[primitive.ObjectID]float64, n int) []models.RecommendedItem {
+	items := make([]models.RecommendedItem, 0, len(scores))
+	for id, score := range scores {
+		if score > 0 {
+			items = append(items, models.RecommendedItem{ProductID: id, Score: score})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return bytes.Compare(items[i].ProductID[:], items[j].ProductID[:]) > 0
+	})
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+func saveRecommendations(ctx context.Context, lists []models.Recommendation) error {
+	for start := 0; start < len(lists); start += bulkWriteBatch {
+		end := min(start+bulkWriteBatch, len(lists))
+		writes := make([]mongo.WriteModel, 0, end-start)
+		for _, list := range lists[start:end] {
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"kind": list.Kind, "subject_id": list.SubjectID}).
+				SetReplacement(list).
+				SetUpsert(true))
+		}
+		if _, err := config.Coll.Recommendations.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForProduct returns what buyers of a product also bought and viewed,
+// topped up with similar listings (same category, brand, price band and
+// condition) when there is too little activity to go on
+func (s *RecommendationService) ForProduct(ctx context.Context, product *models.Product, limit int) (*ProductRecommendations, error) {
+	shown := map[primitive.ObjectID]bool{product.ID: true}
+	notShown := func(p *models.Product) bool { return !shown[p.ID] }
+
+	alsoBought, err := s.products(ctx, models.RecommendationAlsoBought, &product.ID, limit, notShown)
+	if err != nil {
+		return nil, err
+	}
+	markShown(shown, alsoBought)
+
+	alsoViewed, err := s.products(ctx, models.RecommendationAlsoViewed, &product.ID, limit, notShown)
+	if err != nil {
+		return nil, err
+	}
+	markShown(shown, alsoViewed)
+
+	similar := []models.Product{}
+	if len(alsoViewed) < limit {
+		if similar, err = similarProducts(ctx, product, shown, limit-len(alsoViewed)); err != nil {
+			return nil, err
+		}
+	}
+	return &ProductRecommendations{AlsoBought: alsoBought, AlsoViewed: alsoViewed, Similar: similar}, nil
+}
+
+// ForUser returns a user's "recommended for you" feed, filled up with
+// trending products when the user has too little history
+func (s *RecommendationService) ForUser(ctx context.Context, userID primitive.ObjectID, limit int) ([]RecommendedProduct, error) {
+	shown := make(map[primitive.ObjectID]bool)
+	keep := func(p *models.Product) bool { return !shown[p.ID] && p.SellerID != userID }
+
+	products, err := s.products(ctx, models.RecommendationForUser, &userID, limit, keep)
+	if err != nil {
+		return nil, err
+	}
+	markShown(shown, products)
+	feed := make([]RecommendedProduct, 0, limit)
+	for _, product := range products {
+		feed = append(feed, RecommendedProduct{Product: product, Reason: models.RecommendationForUser})
+	}
+
+	if len(feed) < limit {
+		trending, err := s.trending(ctx, limit-len(feed), keep)
+		if err != nil {
+			return nil, err
+		}
+		for _, product := range trending {
+			feed = append(feed, RecommendedProduct{Product: product, Reason: models.RecommendationTrending})
+		}
+	}
+	return feed, nil
+}
+
+// Trending returns the products with the most recent activity
+func (s *RecommendationService) Trending(ctx context.Context, limit int) ([]models.Product, error) {
+	return s.trending(ctx, limit, func(*models.Product) bool { return true })
+}
+
+// Home returns the home page lists; userID is nil for guests
+func (s *RecommendationService) Home(ctx context.Context, userID *primitive.ObjectID, limit int) (*HomeRecommendations, error) {
+	home := &HomeRecommendations{}
+	if userID == nil {
+		trending, err := s.Trending(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		home.Trending = trending
+		return home, nil
+	}
+
+	forYou, err := s.ForUser(ctx, *userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	home.ForYou = forYou
+	shown := make(map[primitive.ObjectID]bool, len(forYou))
+	for _, product := range forYou {
+		shown[product.ID] = true
+	}
+	home.Trending, err = s.trending(ctx, limit, func(p *models.Product) bool {
+		return !shown[p.ID] && p.SellerID != *userID
+	})
+	if err != nil {
+		return nil, err
+	}
+	return home, nil
+}
+
+// trending returns active products flagged trending by the ranking job,
+// most popular first, followed by the most popular of the rest
+func (s *RecommendationService) trending(ctx context.Context, limit int, keep func(*models.Product) bool) ([]models.Product, error) {
+	cursor, err := config.Coll.Products.Find(ctx,
+		bson.M{"status": models.ProductStatusActive},
+		options.Find().
+			SetSort(bson.D{{Key: "is_trending", Value: -1}, {Key: "popularity_score", Value: -1}, {Key: "created_at", Value: -1}}).
+			SetLimit(int64(limit*2)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var popular []models.Product
+	if err := cursor.All(ctx, &popular); err != nil {
+		return nil, err
+	}
+	products := make([]models.Product, 0, limit)
+	for i := range popular {
+		if len(products) == limit {
+			break
+		}
+		if keep(&popular[i]) {
+			products = append(products, popular[i])
+		}
+	}
+	return products, nil
+}
+
+// products loads the active products of a stored list in rank order,
+// skipping any keep rejects
+func (s *RecommendationService) products(ctx context.Context, kind models.RecommendationKind, subjectID *primitive.ObjectID, limit int, keep func(*models.Product) bool) ([]models.Product, error) {
+	products := []models.Product{}
+	var list models.Recommendation
+	err := config.Coll.Recommendations.FindOne(ctx, bson.M{"kind": kind, "subject_id": subjectID}).Decode(&list)
+	if err == mongo.ErrNoDocuments {
+		return products, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(list.Items))
+	for i, item := range list.Items {
+		ids[i] = item.ProductID
+	}
+	cursor, err := config.Coll.Products.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "status": models.ProductStatusActive})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Product
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]*models.Product, len(found))
+	for i := range found {
+		byID[found[i].ID] = &found[i]
+	}
+	for _, id := range ids {
+		if len(products) == limit {
+			break
+		}
+		if product, ok := byID[id]; ok && keep(product) {
+			products = append(products, *product)
+		}
+	}
+	return products, nil
+}
+
+// similarProducts scores active listings by how much they have in common
+// with a product
+func similarProducts(ctx context.Context, product *models.Product, exclude map[primitive.ObjectID]bool, limit int) ([]models.Product, error) {
+	ids := make([]primitive.ObjectID, 0, len(exclude))
+	for id := range exclude {
+		ids = append(ids, id)
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"_id":    bson.M{"$nin": ids},
+			"status": models.ProductStatusActive,
+		}},
+		{"$addFields": bson.M{
+			"score": bson.M{
+				"$add": []interface{}{
+					// Same category: +10 points
+					bson.M{"$cond": []interface{}{
+						bson.M{"$eq": []interface{}{"$category_id", product.CategoryID}},
+						10, 0,
+					}},
+					// Same brand: +5 points
+					bson.M{"$cond": []interface{}{
+						bson.M{"$eq": []interface{}{"$brand", product.Brand}},
+						5, 0,
+					}},
+					// Similar price range: +3 points
+					bson.M{"$cond": []interface{}{
+						bson.M{"$and": []interface{}{
+							bson.M{"$gte": []interface{}{"$price", product.Price * 0.7}},
+							bson.M{"$lte": []interface{}{"$price", product.Price * 1.3}},
+						}},
+						3, 0,
+					}},
+					// Same condition: +2 points
+					bson.M{"$cond": []interface{}{
+						bson.M{"$eq": []interface{}{"$condition", product.Condition}},
+						2, 0,
+					}},
+				},
+			},
+		}},
+		{"$match": bson.M{"score": bson.M{"$gte": 10}}},
+		{"$sort": bson.D{{Key: "score", Value: -1}, {Key: "created_at", Value: -1}}},
+		{"$limit": limit},
+	}
+
+	cursor, err := config.Coll.Products.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	similar := []models.Product{}
+	if err := cursor.All(ctx, &similar); err != nil {
+		return nil, err
+	}
+	return similar, nil
+}
+
+func markShown(shown map[primitive.ObjectID]bool, products []models.Product) {
+	for _, product := range products {
+		shown[product.ID] = true
+	}
+}
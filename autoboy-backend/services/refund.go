@@ -0,0 +1,907 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrRefundNotAllowed   = errors.New("order has no payment that can be refunded")
+	ErrRefundAmount       = errors.New("refund exceeds the amount still refundable")
+	ErrRefundItem         = errors.New("invalid refund item or quantity")
+	ErrReturnNotFound     = errors.New("return not found")
+	ErrReturnState        = errors.New("return cannot be changed in its current state")
+	ErrReturnExists       = errors.New("a return is already open for this item")
+	ErrReturnNotDelivered = errors.New("only shipped or delivered orders can be returned")
+)
+
+const (
+	ReturnTypeReturn = "return"
+	ReturnTypeRefund = "refund"
+)
+
+// openReturnStatuses are returns still being worked on
+var openReturnStatuses = []models.ReturnStatus{
+	models.ReturnStatusRequested,
+	models.ReturnStatusApproved,
+	models.ReturnStatusShipped,
+	models.ReturnStatusReceived,
+}
+
+// RefundLine selects a quantity of one order item
+type RefundLine struct {
+	OrderItemID primitive.ObjectID
+	Quantity    int
+}
+
+// RefundRequest describes a refund against an order. Full refunds cover
+// everything not yet refunded; partial refunds cover the listed items and,
+// optionally, shipping, or a plain Amount when no items are given (goodwill
+// or dispute settlements); shipping refunds cover shipping only.
+type RefundRequest struct {
+	OrderID         primitive.ObjectID
+	Type            models.RefundType
+	Items           []RefundLine
+	IncludeShipping bool
+	Amount          float64
+	Destination     models.RefundDestination
+	Restock         bool
+	Reason          string
+	RequestedBy     primitive.ObjectID
+	ReturnID        *primitive.ObjectID
+	DisputeID       *primitive.ObjectID
+	// Set for a refund the gateway has already paid out, e.g. one made from
+	// its dashboard; it is booked without calling the gateway again
+	GatewayRefundID string
+}
+
+// RefundableItem is what is left to refund on one order item
+type RefundableItem struct {
+	OrderItemID  primitive.ObjectID `json:"order_item_id"`
+	ProductTitle string             `json:"product_title"`
+	Quantity     int                `json:"quantity"`
+	Amount       float64            `json:"amount"`
+}
+
+// Refundable is what is left to refund on an order
+type Refundable struct {
+	Items    []RefundableItem `json:"items"`
+	Shipping float64          `json:"shipping"`
+	Total    float64          `json:"total"`
+}
+
+// ReturnRequest is a buyer asking to send an item back, or to be refunded
+// without sending anything back
+type ReturnRequest struct {
+	OrderID     primitive.ObjectID
+	BuyerID     primitive.ObjectID
+	OrderItemID primitive.ObjectID
+	Quantity    int
+	ReturnType  string
+	Reason      string
+	Description string
+	Images      []string
+	RefundTo    models.RefundDestination
+}
+
+// RefundService refunds orders in whole or in part, to the original payment
+// method or to the buyer's wallet. Money comes back out of the order's
+// escrow; anything already released to the seller is clawed back.
+type RefundService struct {
+	payments *PaymentService
+	wallet   *WalletService
+	escrow   *EscrowService
+	orders   *OrderService
+}
+
+func NewRefundService(payments *PaymentService, wallet *WalletService, escrow *EscrowService, orders *OrderService) *RefundService {
+	return &RefundService{
+		payments: payments,
+		wallet:   wallet,
+		escrow:   escrow,
+		orders:   orders,
+	}
+}
+
+// Refundable returns what can still be refunded on an order
+func (s *RefundService) Refundable(order *models.Order) *Refundable {
+	refundable := &Refundable{Items: []RefundableItem{}}
+	for i := range order.Items {
+		item := &order.Items[i]
+		if left := item.Quantity - item.RefundedQuantity; left > 0 {
+			refundable.Items = append(refundable.Items, RefundableItem{
+				OrderItemID:  item.ID,
+				ProductTitle: item.ProductTitle,
+				Quantity:     left,
+				Amount:       itemRefundAmount(order, item, left),
+			})
+		}
+	}
+	if !order.ShippingRefunded {
+		refundable.Shipping = order.ShippingAmount
+	}
+	refundable.Total = utils.RoundCurrency(order.TotalAmount - order.RefundedAmount)
+	return refundable
+}
+
+// Refund issues a refund against an order. Wallet refunds complete in one
+// transaction. Refunds to the original payment method reserve the amount on
+// the order, call the gateway, then settle the escrow; the gateway's refund
+// webhook completes them if the gateway processes refunds asynchronously.
+func (s *RefundService) Refund(ctx context.Context, req RefundRequest) (*models.Refund, error) {
+	order, err := loadOrder(ctx, req.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.PaymentStatus != models.PaymentStatusPaid || order.PaymentID == nil {
+		return nil, ErrRefundNotAllowed
+	}
+
+	var payment models.Payment
+	if err := config.Coll.Payments.FindOne(ctx, bson.M{"_id": *order.PaymentID}).Decode(&payment); err != nil {
+		return nil, ErrRefundNotAllowed
+	}
+
+	if req.Type == "" {
+		req.Type = models.RefundTypeFull
+	}
+	if req.Destination == "" {
+		req.Destination = models.RefundDestinationOriginal
+	}
+	// Wallet payments are refunded to the wallet either way
+	if payment.PaymentGateway == models.PaymentGatewayWallet {
+		req.Destination = models.RefundDestinationWallet
+	}
+	if req.GatewayRefundID != "" {
+		req.Destination = models.RefundDestinationOriginal
+	}
+
+	now := time.Now()
+	refund := &models.Refund{
+		ID:              primitive.NewObjectID(),
+		RefundNumber:    utils.GenerateRefundNumber(),
+		PaymentID:       payment.ID,
+		OrderID:         &order.ID,
+		RequestedBy:     req.RequestedBy,
+		OriginalAmount:  order.TotalAmount,
+		Currency:        currencyOrDefault(order.Currency),
+		Reason:          req.Reason,
+		RefundType:      req.Type,
+		ReturnID:        req.ReturnID,
+		DisputeID:       req.DisputeID,
+		Destination:     req.Destination,
+		RefundMethod:    payment.PaymentMethod,
+		GatewayRefundID: req.GatewayRefundID,
+		Status:          models.RefundStatusProcessing,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if !req.RequestedBy.IsZero() {
+		refund.ApprovedBy = &req.RequestedBy
+		refund.ApprovedAt = &now
+	}
+	if req.Destination == models.RefundDestinationWallet {
+		refund.RefundMethod = models.PaymentMethodWallet
+		var refunded *models.Order
+		err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+			if err := s.reserve(sc, refund, req); err != nil {
+				return err
+			}
+			if err := s.settleEscrow(sc, order, refund, true, req.RequestedBy); err != nil {
+				return err
+			}
+			var err error
+			refunded, err = s.complete(sc, refund, req.Restock, models.RefundStatusCompleted)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.reindex(ctx, refund)
+		if refunded != nil {
+			s.orders.Notify(ctx, refunded)
+		}
+		return refund, nil
+	}
+
+	// Reserve first so concurrent refunds cannot exceed what was paid
+	if err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		return s.reserve(sc, refund, req)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Make sure the escrow can cover the refund before any money leaves
+	if err := s.escrow.CheckRefund(ctx, order.ID, refund.RefundAmount); err != nil && err != ErrEscrowNotFound {
+		if rollbackErr := s.rollback(ctx, refund, err.Error()); rollbackErr != nil {
+			log.Printf("Failed to roll back refund %s: %v", refund.RefundNumber, rollbackErr)
+		}
+		return nil, err
+	}
+
+	// A checkout payment covers several orders; the refund record belongs to
+	// the one being refunded
+	if payment.CheckoutID != nil {
+		payment.OrderID = &order.ID
+	}
+
+	var refundPayment *models.Payment
+	if req.GatewayRefundID != "" {
+		refundPayment, err = s.payments.RecordRefund(ctx, &payment, refund.RefundAmount, req.Reason, &GatewayRefundResult{
+			RefundID: req.GatewayRefundID,
+			Status:   "processed",
+			Amount:   refund.RefundAmount,
+		})
+	} else {
+		refundPayment, err = s.payments.RefundPayment(ctx, &payment, refund.RefundAmount, req.Reason)
+	}
+	if err == nil && refundPayment.Status == models.PaymentStatusFailed {
+		err = fmt.Errorf("gateway declined the refund")
+	}
+	if err != nil {
+		if rollbackErr := s.rollback(ctx, refund, err.Error()); rollbackErr != nil {
+			log.Printf("Failed to roll back refund %s: %v", refund.RefundNumber, rollbackErr)
+		}
+		return nil, fmt.Errorf("gateway refund failed: %w", err)
+	}
+
+	refund.RefundPaymentID = &refundPayment.ID
+	refund.GatewayRefundID = refundPayment.GatewayPaymentID
+	status := models.RefundStatusProcessing
+	if refundPayment.Status == models.PaymentStatusRefunded {
+		status = models.RefundStatusCompleted
+	}
+
+	var refunded *models.Order
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := s.settleEscrow(sc, order, refund, false, req.RequestedBy); err != nil {
+			return err
+		}
+		var err error
+		refunded, err = s.complete(sc, refund, req.Restock, status)
+		return err
+	})
+	if err != nil {
+		// The gateway has already sent the money; leave the refund for an
+		// admin to reconcile rather than pretend it did not happen
+		log.Printf("Refund %s was paid by the gateway but could not be settled: %v", refund.RefundNumber, err)
+		config.Coll.Refunds.UpdateOne(ctx, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{
+			"refund_payment_id": refund.RefundPaymentID,
+			"gateway_refund_id": refund.GatewayRefundID,
+			"failure_reason":    "settlement failed: " + err.Error(),
+			"updated_at":        time.Now(),
+		}})
+		return nil, err
+	}
+	s.reindex(ctx, refund)
+	if refunded != nil {
+		s.orders.Notify(ctx, refunded)
+	}
+	return refund, nil
+}
+
+// RecordGatewayRefund books a refund that was made at the gateway rather
+// than through Refund, so the orders it covers are refunded out of their
+// escrow like any other refund. A checkout payment's refund is spread over
+// its orders in turn. Whatever an earlier delivery of the same refund
+// already booked is skipped, so it is never booked twice.
+func (s *RefundService) RecordGatewayRefund(ctx context.Context, payment *models.Payment, gatewayRefundID string, amount float64, reason string) error {
+	orderIDs, err := paymentOrderIDs(ctx, payment)
+	if err != nil {
+		return err
+	}
+	if len(orderIDs) == 0 {
+		// Nothing was bought with it; only the money movement is recorded
+		_, err := s.payments.RecordRefund(ctx, payment, amount, reason, &GatewayRefundResult{
+			RefundID: gatewayRefundID,
+			Status:   "processed",
+			Amount:   amount,
+		})
+		return err
+	}
+
+	// Part of the refund may have been booked by an earlier delivery
+	cursor, err := config.Coll.Refunds.Find(ctx, bson.M{
+		"payment_id":        payment.ID,
+		"gateway_refund_id": gatewayRefundID,
+		"status":            bson.M{"$ne": models.RefundStatusFailed},
+	})
+	if err != nil {
+		return err
+	}
+	var booked []models.Refund
+	if err := cursor.All(ctx, &booked); err != nil {
+		return err
+	}
+	remaining := utils.RoundCurrency(amount)
+	done := make(map[primitive.ObjectID]bool, len(booked))
+	for _, refund := range booked {
+		remaining = utils.RoundCurrency(remaining - refund.RefundAmount)
+		if refund.OrderID != nil {
+			done[*refund.OrderID] = true
+		}
+	}
+
+	for _, orderID := range orderIDs {
+		if remaining <= 0 {
+			break
+		}
+		if done[orderID] {
+			continue
+		}
+		order, err := loadOrder(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		refundable := utils.RoundCurrency(order.TotalAmount - order.RefundedAmount)
+		if refundable <= 0 || order.PaymentStatus != models.PaymentStatusPaid {
+			continue
+		}
+
+		req := RefundRequest{
+			OrderID:         orderID,
+			Type:            models.RefundTypeFull,
+			Reason:          reason,
+			GatewayRefundID: gatewayRefundID,
+		}
+		share := refundable
+		if remaining < refundable {
+			req.Type = models.RefundTypePartial
+			req.Amount = remaining
+			share = remaining
+		}
+		if _, err := s.Refund(ctx, req); err != nil {
+			return fmt.Errorf("order %s: %w", order.OrderNumber, err)
+		}
+		remaining = utils.RoundCurrency(remaining - share)
+	}
+	return nil
+}
+
+// RequestReturn opens a return (or refund-only) request for an order item.
+// Refund-only requests may leave OrderItemID empty to cover the whole order.
+func (s *RefundService) RequestReturn(ctx context.Context, req ReturnRequest) (*models.OrderReturn, error) {
+	var order models.Order
+	if err := config.Coll.Orders.FindOne(ctx, bson.M{"_id": req.OrderID, "buyer_id": req.BuyerID}).Decode(&order); err != nil {
+		return nil, ErrOrderNotFound
+	}
+	if order.PaymentStatus != models.PaymentStatusPaid {
+		return nil, ErrRefundNotAllowed
+	}
+
+	if req.ReturnType != ReturnTypeRefund {
+		req.ReturnType = ReturnTypeReturn
+		switch order.Status {
+		case models.OrderStatusShipped, models.OrderStatusDelivered, models.OrderStatusCompleted:
+		default:
+			return nil, ErrReturnNotDelivered
+		}
+		if req.OrderItemID.IsZero() {
+			return nil, ErrRefundItem
+		}
+	}
+
+	amount := utils.RoundCurrency(order.TotalAmount - order.RefundedAmount)
+	if !req.OrderItemID.IsZero() {
+		item := findOrderItem(&order, req.OrderItemID)
+		if item == nil {
+			return nil, ErrRefundItem
+		}
+		left := item.Quantity - item.RefundedQuantity
+		if req.Quantity == 0 {
+			req.Quantity = left
+		}
+		if req.Quantity < 1 || req.Quantity > left {
+			return nil, ErrRefundItem
+		}
+		amount = itemRefundAmount(&order, item, req.Quantity)
+	}
+	if amount <= 0 {
+		return nil, ErrRefundAmount
+	}
+
+	open, err := config.Coll.OrderReturns.CountDocuments(ctx, bson.M{
+		"order_id":      order.ID,
+		"order_item_id": req.OrderItemID,
+		"status":        bson.M{"$in": openReturnStatuses},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if open > 0 {
+		return nil, ErrReturnExists
+	}
+
+	if req.RefundTo == "" {
+		req.RefundTo = models.RefundDestinationOriginal
+	}
+
+	now := time.Now()
+	ret := &models.OrderReturn{
+		ID:           primitive.NewObjectID(),
+		OrderID:      order.ID,
+		OrderItemID:  req.OrderItemID,
+		Quantity:     req.Quantity,
+		SellerID:     order.SellerID,
+		RequestedBy:  req.BuyerID,
+		Reason:       req.Reason,
+		Description:  req.Description,
+		Images:       req.Images,
+		ReturnType:   req.ReturnType,
+		Status:       models.ReturnStatusRequested,
+		RefundAmount: amount,
+		RefundTo:     req.RefundTo,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if _, err := config.Coll.OrderReturns.InsertOne(ctx, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ApproveReturn accepts a return request. Refund-only requests are refunded
+// straight away; returns wait for the item to come back.
+func (s *RefundService) ApproveReturn(ctx context.Context, returnID, actorID primitive.ObjectID, isAdmin bool) (*models.OrderReturn, error) {
+	now := time.Now()
+	ret, err := s.transitionReturn(ctx, returnID, actorID, isAdmin, []models.ReturnStatus{models.ReturnStatusRequested}, bson.M{
+		"status":      models.ReturnStatusApproved,
+		"approved_by": actorID,
+		"approved_at": now,
+		"updated_at":  now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ret.ReturnType == ReturnTypeRefund {
+		return s.refundReturn(ctx, ret, actorID, false)
+	}
+	return ret, nil
+}
+
+// RejectReturn declines a return request
+func (s *RefundService) RejectReturn(ctx context.Context, returnID, actorID primitive.ObjectID, isAdmin bool, reason string) (*models.OrderReturn, error) {
+	return s.transitionReturn(ctx, returnID, actorID, isAdmin, []models.ReturnStatus{models.ReturnStatusRequested}, bson.M{
+		"status":          models.ReturnStatusRejected,
+		"rejected_reason": reason,
+		"approved_by":     actorID,
+		"updated_at":      time.Now(),
+	})
+}
+
+// ShipReturn records the buyer sending the item back
+func (s *RefundService) ShipReturn(ctx context.Context, returnID, buyerID primitive.ObjectID, carrier, trackingNumber string) (*models.OrderReturn, error) {
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ret models.OrderReturn
+	err := config.Coll.OrderReturns.FindOneAndUpdate(ctx, bson.M{
+		"_id":          returnID,
+		"requested_by": buyerID,
+		"status":       models.ReturnStatusApproved,
+		"return_type":  ReturnTypeReturn,
+	}, bson.M{"$set": bson.M{
+		"status":                          models.ReturnStatusShipped,
+		"return_shipping.carrier_name":    carrier,
+		"return_shipping.tracking_number": trackingNumber,
+		"return_shipping.shipped_at":      now,
+		"updated_at":                      now,
+	}}, opts).Decode(&ret)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrReturnState
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// ReceiveReturn records the item arriving back with the seller, then
+// refunds the buyer and puts the item back in stock
+func (s *RefundService) ReceiveReturn(ctx context.Context, returnID, actorID primitive.ObjectID, isAdmin bool) (*models.OrderReturn, error) {
+	now := time.Now()
+	ret, err := s.transitionReturn(ctx, returnID, actorID, isAdmin, []models.ReturnStatus{models.ReturnStatusApproved, models.ReturnStatusShipped}, bson.M{
+		"status":                       models.ReturnStatusReceived,
+		"received_at":                  now,
+		"return_shipping.delivered_at": now,
+		"updated_at":                   now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret.ReturnType != ReturnTypeReturn {
+		return ret, nil
+	}
+	return s.refundReturn(ctx, ret, actorID, true)
+}
+
+// RefundReturn retries the refund for a received return, or an approved
+// refund-only request, whose refund failed earlier
+func (s *RefundService) RefundReturn(ctx context.Context, returnID, actorID primitive.ObjectID) (*models.OrderReturn, error) {
+	var ret models.OrderReturn
+	if err := config.Coll.OrderReturns.FindOne(ctx, bson.M{"_id": returnID}).Decode(&ret); err != nil {
+		return nil, ErrReturnNotFound
+	}
+
+	switch {
+	case ret.Status == models.ReturnStatusReceived:
+		return s.refundReturn(ctx, &ret, actorID, true)
+	case ret.Status == models.ReturnStatusApproved && ret.ReturnType == ReturnTypeRefund:
+		return s.refundReturn(ctx, &ret, actorID, false)
+	}
+	return nil, ErrReturnState
+}
+
+// refundReturn refunds what a return covers and marks it refunded
+func (s *RefundService) refundReturn(ctx context.Context, ret *models.OrderReturn, actorID primitive.ObjectID, restock bool) (*models.OrderReturn, error) {
+	req := RefundRequest{
+		OrderID:     ret.OrderID,
+		Type:        models.RefundTypeFull,
+		Destination: ret.RefundTo,
+		Restock:     restock,
+		Reason:      ret.Reason,
+		RequestedBy: actorID,
+		ReturnID:    &ret.ID,
+	}
+	if !ret.OrderItemID.IsZero() {
+		req.Type = models.RefundTypePartial
+		req.Items = []RefundLine{{OrderItemID: ret.OrderItemID, Quantity: ret.Quantity}}
+	}
+
+	refund, err := s.Refund(ctx, req)
+	if err != nil {
+		return ret, err
+	}
+
+	now := time.Now()
+	ret.Status = models.ReturnStatusRefunded
+	ret.RefundID = &refund.ID
+	ret.RefundAmount = refund.RefundAmount
+	ret.RefundedAt = &now
+	ret.UpdatedAt = now
+
+	_, err = config.Coll.OrderReturns.UpdateOne(ctx, bson.M{"_id": ret.ID}, bson.M{"$set": bson.M{
+		"status":        ret.Status,
+		"refund_id":     refund.ID,
+		"refund_amount": refund.RefundAmount,
+		"refunded_at":   now,
+		"updated_at":    now,
+	}})
+	return ret, err
+}
+
+// transitionReturn moves a return the actor may manage from one of the
+// given states. Sellers can only manage returns on their own orders.
+func (s *RefundService) transitionReturn(ctx context.Context, returnID, actorID primitive.ObjectID, isAdmin bool, from []models.ReturnStatus, set bson.M) (*models.OrderReturn, error) {
+	filter := bson.M{"_id": returnID}
+	if !isAdmin {
+		filter["seller_id"] = actorID
+	}
+
+	var current models.OrderReturn
+	if err := config.Coll.OrderReturns.FindOne(ctx, filter).Decode(&current); err != nil {
+		return nil, ErrReturnNotFound
+	}
+
+	filter["status"] = bson.M{"$in": from}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ret models.OrderReturn
+	err := config.Coll.OrderReturns.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&ret)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrReturnState
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// reserve prices the refund against the current order and records it on
+// the order, so the amount can no longer be refunded twice
+func (s *RefundService) reserve(sc mongo.SessionContext, refund *models.Refund, req RefundRequest) error {
+	order, err := loadOrder(sc, req.OrderID)
+	if err != nil {
+		return err
+	}
+
+	items, shipping, total, err := quoteRefund(order, req)
+	if err != nil {
+		return err
+	}
+	refund.Items = items
+	refund.ShippingAmount = shipping
+	refund.RefundAmount = total
+
+	if _, err := config.Coll.Refunds.InsertOne(sc, refund); err != nil {
+		return err
+	}
+	return applyRefundToOrder(sc, order, refund, 1)
+}
+
+// rollback releases a reservation whose gateway refund failed
+func (s *RefundService) rollback(ctx context.Context, refund *models.Refund, reason string) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		order, err := loadOrder(sc, *refund.OrderID)
+		if err != nil {
+			return err
+		}
+		if err := applyRefundToOrder(sc, order, refund, -1); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		_, err = config.Coll.Refunds.UpdateOne(sc, bson.M{"_id": refund.ID}, bson.M{"$set": bson.M{
+			"status":         models.RefundStatusFailed,
+			"failure_reason": reason,
+			"failed_at":      now,
+			"updated_at":     now,
+		}})
+		return err
+	})
+}
+
+// settleEscrow takes the refund out of the order's escrow. Orders paid
+// before escrow existed have none; wallet refunds for them are booked as a
+// refund expense instead.
+func (s *RefundService) settleEscrow(sc mongo.SessionContext, order *models.Order, refund *models.Refund, toWallet bool, by primitive.ObjectID) error {
+	reason := fmt.Sprintf("Refund %s", refund.RefundNumber)
+	if refund.Reason != "" {
+		reason += ": " + refund.Reason
+	}
+
+	err := s.escrow.RefundForOrder(sc, order.ID, refund.RefundAmount, toWallet, by, reason)
+	if err != ErrEscrowNotFound {
+		return err
+	}
+	if !toWallet {
+		return nil
+	}
+
+	_, err = s.wallet.Credit(sc, order.BuyerID, WalletEntry{
+		Type:          models.WalletTransactionRefund,
+		Counterparty:  ledger.AccountRefunds,
+		Amount:        refund.RefundAmount,
+		Currency:      refund.Currency,
+		ReferenceType: "refund",
+		ReferenceID:   &refund.ID,
+		PaymentID:     &refund.PaymentID,
+		Description:   fmt.Sprintf("Refund for order %s", order.OrderNumber),
+	})
+	return err
+}
+
+// reindex puts the products a committed refund restocked back in search
+func (s *RefundService) reindex(ctx context.Context, refund *models.Refund) {
+	var ids []primitive.ObjectID
+	for _, item := range refund.Items {
+		if item.Restocked {
+			ids = append(ids, item.ProductID)
+		}
+	}
+	if len(ids) > 0 {
+		s.orders.inventory.Reindex(ctx, ids)
+	}
+}
+
+// complete restocks refunded items, finalises the refund and marks the
+// order refunded once nothing is left to refund. It returns the order when
+// its status changed so the caller can notify the parties after commit.
+func (s *RefundService) complete(sc mongo.SessionContext, refund *models.Refund, restock bool, status models.RefundStatus) (*models.Order, error) {
+	if restock {
+		for i := range refund.Items {
+			if err := restockProduct(sc, refund.Items[i].ProductID, refund.Items[i].Quantity); err != nil {
+				return nil, err
+			}
+			refund.Items[i].Restocked = true
+		}
+	}
+
+	now := time.Now()
+	refund.Status = status
+	refund.ProcessedAt = &now
+	refund.UpdatedAt = now
+	set := bson.M{
+		"status":            status,
+		"items":             refund.Items,
+		"refund_method":     refund.RefundMethod,
+		"refund_payment_id": refund.RefundPaymentID,
+		"gateway_refund_id": refund.GatewayRefundID,
+		"processed_at":      now,
+		"updated_at":        now,
+	}
+	if status == models.RefundStatusCompleted {
+		refund.CompletedAt = &now
+		set["completed_at"] = now
+	}
+	if _, err := config.Coll.Refunds.UpdateOne(sc, bson.M{"_id": refund.ID}, bson.M{"$set": set}); err != nil {
+		return nil, err
+	}
+
+	order, err := loadOrder(sc, *refund.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	orderSet := bson.M{"updated_at": now}
+	for i, item := range order.Items {
+		if item.Quantity > 0 && item.RefundedQuantity >= item.Quantity {
+			orderSet[fmt.Sprintf("items.%d.status", i)] = models.OrderStatusRefunded
+		}
+	}
+	if _, err := config.Coll.Orders.UpdateOne(sc, bson.M{"_id": order.ID}, bson.M{"$set": orderSet}); err != nil {
+		return nil, err
+	}
+
+	if utils.RoundCurrency(order.TotalAmount-order.RefundedAmount) > 0 {
+		return nil, nil
+	}
+	paymentSet := bson.M{"payment_status": models.PaymentStatusRefunded}
+	if !CanTransition(order.Status, models.OrderStatusRefunded, OrderActorSystem) {
+		paymentSet["updated_at"] = now
+		_, err := config.Coll.Orders.UpdateOne(sc, bson.M{"_id": order.ID}, bson.M{"$set": paymentSet})
+		return nil, err
+	}
+	if err := s.orders.Record(sc, order, OrderTransition{
+		OrderID: order.ID,
+		To:      models.OrderStatusRefunded,
+		Actor:   OrderActorSystem,
+		ActorID: refund.RequestedBy,
+		Note:    fmt.Sprintf("Refunded in full (%s)", refund.RefundNumber),
+		Set:     paymentSet,
+	}); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// quoteRefund prices a refund request against what is left on the order
+func quoteRefund(order *models.Order, req RefundRequest) ([]models.RefundItem, float64, float64, error) {
+	remaining := utils.RoundCurrency(order.TotalAmount - order.RefundedAmount)
+	if remaining <= 0 {
+		return nil, 0, 0, ErrRefundAmount
+	}
+
+	var items []models.RefundItem
+	var shipping, total float64
+
+	addItem := func(item *models.OrderItem, qty int) {
+		amount := itemRefundAmount(order, item, qty)
+		items = append(items, models.RefundItem{
+			OrderItemID: item.ID,
+			ProductID:   item.ProductID,
+			Quantity:    qty,
+			Amount:      amount,
+		})
+		total += amount
+	}
+	addShipping := func() error {
+		if order.ShippingRefunded || order.ShippingAmount <= 0 {
+			return ErrRefundItem
+		}
+		shipping = order.ShippingAmount
+		total += shipping
+		return nil
+	}
+
+	switch req.Type {
+	case models.RefundTypeFull:
+		for i := range order.Items {
+			if left := order.Items[i].Quantity - order.Items[i].RefundedQuantity; left > 0 {
+				addItem(&order.Items[i], left)
+			}
+		}
+		if !order.ShippingRefunded {
+			shipping = order.ShippingAmount
+		}
+		// A full refund returns exactly what is left, whatever the rounding
+		return items, shipping, remaining, nil
+
+	case models.RefundTypeShipping:
+		if err := addShipping(); err != nil {
+			return nil, 0, 0, err
+		}
+
+	case models.RefundTypePartial:
+		seen := make(map[primitive.ObjectID]bool)
+		for _, line := range req.Items {
+			item := findOrderItem(order, line.OrderItemID)
+			if item == nil || seen[item.ID] || line.Quantity < 1 || line.Quantity > item.Quantity-item.RefundedQuantity {
+				return nil, 0, 0, ErrRefundItem
+			}
+			seen[item.ID] = true
+			addItem(item, line.Quantity)
+		}
+		if req.IncludeShipping {
+			if err := addShipping(); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		if len(req.Items) == 0 && !req.IncludeShipping {
+			total = req.Amount
+		}
+
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown refund type %q", req.Type)
+	}
+
+	total = utils.RoundCurrency(total)
+	if total <= 0 {
+		return nil, 0, 0, ErrRefundItem
+	}
+	// Item amounts are rounded one by one, so allow a cent of drift
+	if total > remaining {
+		if utils.RoundCurrency(total-remaining) > 0.01 {
+			return nil, 0, 0, ErrRefundAmount
+		}
+		total = remaining
+	}
+	return items, shipping, total, nil
+}
+
+// applyRefundToOrder adds (sign 1) or removes (sign -1) a refund's amounts
+// from the order's refunded tallies
+func applyRefundToOrder(sc mongo.SessionContext, order *models.Order, refund *models.Refund, sign int) error {
+	set := bson.M{
+		"refunded_amount": utils.RoundCurrency(order.RefundedAmount + float64(sign)*refund.RefundAmount),
+		"updated_at":      time.Now(),
+	}
+	for _, ri := range refund.Items {
+		for i, item := range order.Items {
+			if item.ID == ri.OrderItemID {
+				set[fmt.Sprintf("items.%d.refunded_quantity", i)] = item.RefundedQuantity + sign*ri.Quantity
+			}
+		}
+	}
+	if refund.ShippingAmount > 0 {
+		set["shipping_refunded"] = sign > 0
+	}
+
+	_, err := config.Coll.Orders.UpdateOne(sc, bson.M{"_id": order.ID}, bson.M{"$set": set})
+	return err
+}
+
+// itemRefundAmount is what qty units of an item cost the buyer, with the
+// order's tax and discount spread over the subtotal
+func itemRefundAmount(order *models.Order, item *models.OrderItem, qty int) float64 {
+	gross := item.UnitPrice * float64(qty)
+	if order.SubtotalAmount > 0 {
+		gross += gross * (order.TaxAmount - order.DiscountAmount) / order.SubtotalAmount
+	}
+	return utils.RoundCurrency(gross)
+}
+
+func findOrderItem(order *models.Order, itemID primitive.ObjectID) *models.OrderItem {
+	for i := range order.Items {
+		if order.Items[i].ID == itemID {
+			return &order.Items[i]
+		}
+	}
+	return nil
+}
+
+func loadOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Order, error) {
+	var order models.Order
+	err := config.Coll.Orders.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"autoboy-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// refundTestOrder is two of a 1,000 item and one 3,000 item, with 5% tax,
+// a 500 discount and 1,500 shipping: 6,250 in all
+func refundTestOrder() *models.Order {
+	return &models.Order{
+		Items: []models.OrderItem{
+			{ID: primitive.NewObjectID(), ProductID: primitive.NewObjectID(), Quantity: 2, UnitPrice: 1000, TotalPrice: 2000},
+			{ID: primitive.NewObjectID(), ProductID: primitive.NewObjectID(), Quantity: 1, UnitPrice: 3000, TotalPrice: 3000},
+		},
+		SubtotalAmount: 5000,
+		TaxAmount:      250,
+		DiscountAmount: 500,
+		ShippingAmount: 1500,
+		TotalAmount:    6250,
+	}
+}
+
+func TestItemRefundAmount(t *testing.T) {
+	order := refundTestOrder()
+	tests := []struct {
+		item int
+		qty  int
+		want float64
+	}{
+		{0, 1, 950},
+		{0, 2, 1900},
+		{1, 1, 2850},
+	}
+	for _, tt := range tests {
+		if got := itemRefundAmount(order, &order.Items[tt.item], tt.qty); got != tt.want {
+			t.Errorf("itemRefundAmount(item %d, %d) = %v, want %v", tt.item, tt.qty, got, tt.want)
+		}
+	}
+
+	// The items and shipping add up to what the buyer paid
+	total := itemRefundAmount(order, &order.Items[0], 2) + itemRefundAmount(order, &order.Items[1], 1) + order.ShippingAmount
+	if total != order.TotalAmount {
+		t.Errorf("items and shipping refund %v, want the order total %v", total, order.TotalAmount)
+	}
+}
+
+func TestQuoteRefund(t *testing.T) {
+	tests := []struct {
+		name         string
+		prepare      func(order *models.Order)
+		request      func(order *models.Order) RefundRequest
+		wantItems    int
+		wantShipping float64
+		wantTotal    float64
+		wantErr      error
+	}{
+		{
+			name: "full",
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypeFull}
+			},
+			wantItems:    2,
+			wantShipping: 1500,
+			wantTotal:    6250,
+		},
+		{
+			name: "full after a partial refund",
+			prepare: func(order *models.Order) {
+				order.Items[0].RefundedQuantity = 1
+				order.RefundedAmount = 950
+			},
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypeFull}
+			},
+			wantItems:    2,
+			wantShipping: 1500,
+			wantTotal:    5300,
+		},
+		{
+			name: "one unit with shipping",
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{
+					Type:            models.RefundTypePartial,
+					Items:           []RefundLine{{OrderItemID: order.Items[0].ID, Quantity: 1}},
+					IncludeShipping: true,
+				}
+			},
+			wantItems:    1,
+			wantShipping: 1500,
+			wantTotal:    2450,
+		},
+		{
+			name: "shipping only",
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypeShipping}
+			},
+			wantShipping: 1500,
+			wantTotal:    1500,
+		},
+		{
+			name: "an amount with no items",
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypePartial, Amount: 300}
+			},
+			wantTotal: 300,
+		},
+		{
+			name: "more than was paid",
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypePartial, Amount: 7000}
+			},
+			wantErr: ErrRefundAmount,
+		},
+		{
+			name: "more units than are left",
+			prepare: func(order *models.Order) {
+				order.Items[0].RefundedQuantity = 1
+				order.RefundedAmount = 950
+			},
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{
+					Type:  models.RefundTypePartial,
+					Items: []RefundLine{{OrderItemID: order.Items[0].ID, Quantity: 2}},
+				}
+			},
+			wantErr: ErrRefundItem,
+		},
+		{
+			name: "the same item twice",
+			request: func(order *models.Order) RefundRequest {
+				line := RefundLine{OrderItemID: order.Items[0].ID, Quantity: 1}
+				return RefundRequest{Type: models.RefundTypePartial, Items: []RefundLine{line, line}}
+			},
+			wantErr: ErrRefundItem,
+		},
+		{
+			name: "shipping refunded twice",
+			prepare: func(order *models.Order) {
+				order.ShippingRefunded = true
+				order.RefundedAmount = 1500
+			},
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypeShipping}
+			},
+			wantErr: ErrRefundItem,
+		},
+		{
+			name: "nothing left",
+			prepare: func(order *models.Order) {
+				order.RefundedAmount = order.TotalAmount
+			},
+			request: func(order *models.Order) RefundRequest {
+				return RefundRequest{Type: models.RefundTypeFull}
+			},
+			wantErr: ErrRefundAmount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := refundTestOrder()
+			if tt.prepare != nil {
+				tt.prepare(order)
+			}
+			items, shipping, total, err := quoteRefund(order, tt.request(order))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("quoteRefund() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("quoteRefund() error = %v", err)
+			}
+			if len(items) != tt.wantItems || shipping != tt.wantShipping || total != tt.wantTotal {
+				t.Fatalf("quoteRefund() = %d items, %v shipping, %v total; want %d, %v, %v",
+					len(items), shipping, total, tt.wantItems, tt.wantShipping, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestQuoteRefundAbsorbsRoundingDrift(t *testing.T) {
+	// Each item's share of the discount rounds up, so the items come to a
+	// cent more than the order
+	order := &models.Order{
+		Items: []models.OrderItem{
+			{ID: primitive.NewObjectID(), Quantity: 1, UnitPrice: 33.33},
+			{ID: primitive.NewObjectID(), Quantity: 1, UnitPrice: 33.33},
+			{ID: primitive.NewObjectID(), Quantity: 1, UnitPrice: 33.34},
+		},
+		SubtotalAmount: 100,
+		DiscountAmount: 10,
+		TotalAmount:    90,
+	}
+	var lines []RefundLine
+	for _, item := range order.Items {
+		lines = append(lines, RefundLine{OrderItemID: item.ID, Quantity: 1})
+	}
+
+	_, _, total, err := quoteRefund(order, RefundRequest{Type: models.RefundTypePartial, Items: lines})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 90 {
+		t.Fatalf("total = %v, want the 90 left on the order", total)
+	}
+}
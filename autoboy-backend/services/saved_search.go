@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/geo"
+	"autoboy-backend/models"
+	"autoboy-backend/search"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxMatchesPerSearch bounds how many new listings one saved search loads per run
+const maxMatchesPerSearch = 100
+
+// SavedSearchService re-runs saved searches for listings published since
+// they were last checked and tells their owners about them
+type SavedSearchService struct {
+	search *SearchService
+	email  *EmailService
+
+	maxResultsPerUser int
+	defaultFrequency  models.SavedSearchFrequency
+}
+
+// SavedSearchDigest is the new listings found for one saved search
+type SavedSearchDigest struct {
+	Search   models.SavedSearch `json:"search"`
+	Products []models.Product   `json:"products"`
+	Total    int                `json:"total"`
+}
+
+func NewSavedSearchService(search *SearchService, email *EmailService) *SavedSearchService {
+	frequency := models.SavedSearchFrequency(utils.GetEnv("SAVED_SEARCH_DEFAULT_FREQUENCY", string(models.SavedSearchDaily)))
+	if !ValidSavedSearchFrequency(frequency) {
+		frequency = models.SavedSearchDaily
+	}
+	return &SavedSearchService{
+		search:            search,
+		email:             email,
+		maxResultsPerUser: utils.GetEnvAsInt("SAVED_SEARCH_MAX_RESULTS_PER_USER", 20),
+		defaultFrequency:  frequency,
+	}
+}
+
+// DefaultFrequency is the frequency of saved searches created without one
+func (s *SavedSearchService) DefaultFrequency() models.SavedSearchFrequency {
+	return s.defaultFrequency
+}
+
+// DefaultSavedSearchChannels are used when a saved search names none
+var DefaultSavedSearchChannels = []models.SavedSearchChannel{models.SavedSearchChannelInApp, models.SavedSearchChannelEmail}
+
+// ValidSavedSearchFrequency reports whether a frequency is supported
+func ValidSavedSearchFrequency(frequency models.SavedSearchFrequency) bool {
+	switch frequency {
+	case models.SavedSearchInstant, models.SavedSearchDaily, models.SavedSearchWeekly:
+		return true
+	}
+	return false
+}
+
+// ValidSavedSearchChannel reports whether a notification channel is supported
+func ValidSavedSearchChannel(channel models.SavedSearchChannel) bool {
+	switch channel {
+	case models.SavedSearchChannelInApp, models.SavedSearchChannelEmail, models.SavedSearchChannelWebSocket:
+		return true
+	}
+	return false
+}
+
+// RunDigests re-runs every saved search that is due and notifies each user
+// once about all of their searches' new listings. A user hears about at most
+// SAVED_SEARCH_MAX_RESULTS_PER_USER listings per run; the rest are counted
+// but not listed. It returns how many users were notified.
+func (s *SavedSearchService) RunDigests(ctx context.Context) (int, error) {
+	now := time.Now()
+	cursor, err := config.Coll.SavedSearches.Find(ctx,
+		bson.M{"is_active": true},
+		options.Find().SetSort(bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	notified := 0
+	var userID primitive.ObjectID
+	var digests []SavedSearchDigest
+	flush := func() {
+		if len(digests) > 0 {
+			if err := s.notify(ctx, userID, digests); err != nil {
+				log.Printf("Failed to send saved search digest to %s: %v", userID.Hex(), err)
+			} else {
+				notified++
+			}
+		}
+		digests = nil
+	}
+
+	remaining := 0
+	for cursor.Next(ctx) {
+		var saved models.SavedSearch
+		if err := cursor.Decode(&saved); err != nil {
+			log.Printf("Skipping unreadable saved search: %v", err)
+			continue
+		}
+		if saved.UserID != userID {
+			flush()
+			userID = saved.UserID
+			remaining = s.maxResultsPerUser
+		}
+		if !s.due(&saved, now) {
+			continue
+		}
+
+		digest, err := s.run(ctx, &saved, remaining, now)
+		if err != nil {
+			log.Printf("Failed to run saved search %s: %v", saved.ID.Hex(), err)
+			continue
+		}
+		s.markChecked(ctx, &saved, digest, now)
+		if digest.Total > 0 {
+			remaining -= len(digest.Products)
+			digests = append(digests, *digest)
+		}
+	}
+	flush()
+	return notified, cursor.Err()
+}
+
+// due reports whether a saved search should be re-run now
+func (s *SavedSearchService) due(saved *models.SavedSearch, now time.Time) bool {
+	if saved.LastChecked == nil {
+		return true
+	}
+	frequency := saved.Frequency
+	if frequency == "" {
+		frequency = s.defaultFrequency
+	}
+	switch frequency {
+	case models.SavedSearchWeekly:
+		return now.Sub(*saved.LastChecked) >= 7*24*time.Hour
+	case models.SavedSearchDaily:
+		return now.Sub(*saved.LastChecked) >= 24*time.Hour
+	}
+	return true
+}
+
+// run finds the listings published since a saved search was last checked,
+// listing up to limit of them. Listings published after now are left for
+// the next run, which starts from now.
+func (s *SavedSearchService) run(ctx context.Context, saved *models.SavedSearch, limit int, now time.Time) (*SavedSearchDigest, error) {
+	since := saved.CreatedAt
+	if saved.LastChecked != nil {
+		since = *saved.LastChecked
+	}
+
+	query := savedSearchQuery(saved)
+	query.PublishedAfter = since
+	query.Limit = maxMatchesPerSearch
+	result, err := s.search.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &SavedSearchDigest{Search: *saved, Products: []models.Product{}}
+	if digest.Search.Name == "" {
+		digest.Search.Name = saved.Query
+	}
+	for _, hit := range result.Products {
+		product := hit.Product
+		published := product.CreatedAt
+		if product.PublishedAt != nil {
+			published = *product.PublishedAt
+		}
+		if published.After(now) || product.SellerID == saved.UserID ||
+			(saved.Filters.SwapOnly && !product.SwapAvailable) ||
+			(saved.Filters.FeaturedOnly && !product.IsFeatured) {
+			continue
+		}
+		digest.Total++
+		if len(digest.Products) < limit {
+			digest.Products = append(digest.Products, product)
+		}
+	}
+	return digest, nil
+}
+
+func (s *SavedSearchService) markChecked(ctx context.Context, saved *models.SavedSearch, digest *SavedSearchDigest, now time.Time) {
+	set := bson.M{
+		"last_checked": now,
+		"result_count": digest.Total,
+		"updated_at":   now,
+	}
+	if digest.Total > 0 {
+		set["last_notified_at"] = now
+	}
+	if _, err := config.Coll.SavedSearches.UpdateOne(ctx, bson.M{"_id": saved.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to update saved search %s: %v", saved.ID.Hex(), err)
+	}
+}
+
+// notify tells a user about new matches on each channel any of the
+// matching searches asked for
+func (s *SavedSearchService) notify(ctx context.Context, userID primitive.ObjectID, digests []SavedSearchDigest) error {
+	channels := make(map[models.SavedSearchChannel][]SavedSearchDigest)
+	for _, digest := range digests {
+		searchChannels := digest.Search.Channels
+		if len(searchChannels) == 0 {
+			searchChannels = DefaultSavedSearchChannels
+		}
+		for _, channel := range searchChannels {
+			channels[channel] = append(channels[channel], digest)
+		}
+	}
+
+	for _, digest := range channels[models.SavedSearchChannelInApp] {
+		searchID := digest.Search.ID
+		notification := models.Notification{
+			ID:        primitive.NewObjectID(),
+			UserID:    userID,
+			Type:      models.NotificationTypeSavedSearch,
+			Title:     "New matches for " + digest.Search.Name,
+			Message:   matchesMessage(digest.Total),
+			ActionURL: "/saved-searches/" + searchID.Hex(),
+			RelatedID: &searchID,
+			Priority:  2,
+			CreatedAt: time.Now(),
+		}
+		if len(digest.Products) > 0 && len(digest.Products[0].Images) > 0 {
+			notification.ImageURL = digest.Products[0].Images[0].URL
+		}
+		if _, err := config.Coll.Notifications.InsertOne(ctx, notification); err != nil {
+			return err
+		}
+		SendNotification(userID.Hex(), notification)
+	}
+
+	if matches := channels[models.SavedSearchChannelWebSocket]; len(matches) > 0 {
+		SendSavedSearchMatches(userID.Hex(), matches)
+	}
+
+	if matches := channels[models.SavedSearchChannelEmail]; len(matches) > 0 {
+		var user models.User
+		if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return err
+		}
+		name := user.Profile.FirstName
+		if name == "" {
+			name = user.Username
+		}
+		if err := s.email.SendSavedSearchDigestEmail(user.Email, name, matches); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savedSearchQuery turns saved search filters into an index query
+func savedSearchQuery(saved *models.SavedSearch) search.Query {
+	filters := saved.Filters
+	query := search.Query{
+		Text:       saved.Query,
+		Brands:     filters.Brands,
+		Attributes: filters.Specifications,
+		Sort:       "newest",
+	}
+	for _, id := range filters.Categories {
+		query.Categories = append(query.Categories, id.Hex())
+	}
+	for _, condition := range filters.Conditions {
+		query.Conditions = append(query.Conditions, string(condition))
+	}
+	if filters.PriceMin != nil {
+		query.MinPrice = *filters.PriceMin
+	}
+	if filters.PriceMax != nil {
+		query.MaxPrice = *filters.PriceMax
+	}
+
+	location := filters.Location
+	if location.Radius > 0 {
+		point := geo.Point{Lat: location.Latitude, Lng: location.Longitude}
+		if !point.Valid() {
+			if place, ok := geo.Geocode("", location.City, location.State); ok {
+				point = place.Point
+			}
+		}
+		if point.Valid() {
+			query.Near = &point
+			query.RadiusKm = location.Radius
+		}
+	} else if location.City != "" {
+		query.Location = location.City
+	} else if location.State != "" {
+		query.Location = location.State
+	}
+	return query
+}
+
+func matchesMessage(total int) string {
+	if total == 1 {
+		return "1 new listing matches your saved search"
+	}
+	return fmt.Sprintf("%d new listings match your saved search", total)
+}
@@ -1,17 +1,388 @@
 package services
 
-type SearchService struct{}
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/geo"
+	"autoboy-backend/models"
+	"autoboy-backend/search"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SearchService keeps the embedded product search index in step with Mongo
+// and answers product searches from it. Only active listings are indexed.
+type SearchService struct {
+	index *search.Index
+}
+
+// SearchResult is a page of matching products with facet counts over every match
+type SearchResult struct {
+	Products []ProductHit  `json:"products"`
+	Total    int           `json:"total"`
+	Facets   search.Facets `json:"facets"`
+	Relaxed  bool          `json:"relaxed"`
+}
+
+// ProductHit is a product in search results, with its distance from the
+// searcher when the search was near a point
+type ProductHit struct {
+	models.Product
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
 
 func NewSearchService() *SearchService {
-	return &SearchService{}
+	path := utils.GetEnv("SEARCH_INDEX_PATH", "./data/search/products.idx")
+	index, err := search.Open(path)
+	if err != nil {
+		log.Printf("Search index unreadable, starting empty until rebuilt: %v", err)
+		index = search.New(path)
+	}
+	if buckets := parsePriceBuckets(utils.GetEnv("SEARCH_PRICE_BUCKETS", "")); len(buckets) > 0 {
+		index.SetPriceBuckets(buckets)
+	}
+	return &SearchService{index: index}
 }
 
-func (s *SearchService) IndexProduct(productID string, data map[string]interface{}) error {
-	// Mock implementation
+// IndexProduct reloads a product from Mongo and indexes it, or drops it from
+// the index when it is no longer an active listing
+func (s *SearchService) IndexProduct(ctx context.Context, productID primitive.ObjectID) error {
+	var product models.Product
+	err := config.Coll.Products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments || (err == nil && product.Status != models.ProductStatusActive) {
+		s.index.Delete(productID.Hex())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": product.CategoryID}).Decode(&category); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	s.index.Put(productDocument(&product, category.Attributes))
 	return nil
 }
 
-func (s *SearchService) SearchProducts(query string, filters map[string]interface{}) ([]map[string]interface{}, error) {
-	// Mock implementation
-	return []map[string]interface{}{}, nil
-}
\ No newline at end of file
+// IndexProducts reindexes products whose listing status may have changed,
+// such as by selling their last unit. It runs after the change commits;
+// failures are logged and left for the next rebuild.
+func (s *SearchService) IndexProducts(ctx context.Context, productIDs []primitive.ObjectID) {
+	seen := make(map[primitive.ObjectID]bool, len(productIDs))
+	for _, id := range productIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := s.IndexProduct(ctx, id); err != nil {
+			log.Printf("Failed to reindex product %s: %v", id.Hex(), err)
+		}
+	}
+}
+
+// IndexCategory reindexes every active product in a category, after its
+// attribute schema changes what is filterable
+func (s *SearchService) IndexCategory(ctx context.Context, categoryID primitive.ObjectID) (int, error) {
+	var category models.Category
+	if err := config.Coll.Categories.FindOne(ctx, bson.M{"_id": categoryID}).Decode(&category); err != nil {
+		return 0, err
+	}
+
+	cursor, err := config.Coll.Products.Find(ctx, bson.M{
+		"category_id": categoryID,
+		"status":      models.ProductStatusActive,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	indexed := 0
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return indexed, err
+		}
+		s.index.Put(productDocument(&product, category.Attributes))
+		indexed++
+	}
+	return indexed, cursor.Err()
+}
+
+// RemoveProduct drops a product from the index
+func (s *SearchService) RemoveProduct(productID primitive.ObjectID) {
+	s.index.Delete(productID.Hex())
+}
+
+// Search runs a query against the index and loads the matching products in
+// ranked order. A listing that stopped being active without being reindexed
+// is left out of the page and dropped from the index.
+func (s *SearchService) Search(ctx context.Context, query search.Query) (*SearchResult, error) {
+	result := s.index.Search(query)
+
+	ids := make([]primitive.ObjectID, 0, len(result.Hits))
+	distances := make(map[primitive.ObjectID]*float64, len(result.Hits))
+	for _, hit := range result.Hits {
+		if id, err := primitive.ObjectIDFromHex(hit.ID); err == nil {
+			ids = append(ids, id)
+			distances[id] = hit.DistanceKm
+		}
+	}
+
+	products := []ProductHit{}
+	if len(ids) > 0 {
+		cursor, err := config.Coll.Products.Find(ctx, bson.M{
+			"_id":    bson.M{"$in": ids},
+			"status": models.ProductStatusActive,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+
+		var found []models.Product
+		if err := cursor.All(ctx, &found); err != nil {
+			return nil, err
+		}
+		byID := make(map[primitive.ObjectID]models.Product, len(found))
+		for _, product := range found {
+			byID[product.ID] = product
+		}
+		for _, id := range ids {
+			product, ok := byID[id]
+			if !ok {
+				s.index.Delete(id.Hex())
+				result.Total--
+				continue
+			}
+			products = append(products, ProductHit{Product: product, DistanceKm: distances[id]})
+		}
+	}
+
+	s.labelCategories(ctx, result.Facets.Categories)
+	return &SearchResult{
+		Products: products,
+		Total:    result.Total,
+		Facets:   result.Facets,
+		Relaxed:  result.Relaxed,
+	}, nil
+}
+
+// MatchingIDs returns the IDs of up to limit products matching the query text,
+// best first, for callers that filter further in Mongo
+func (s *SearchService) MatchingIDs(text string, limit int) []primitive.ObjectID {
+	result := s.index.Search(search.Query{Text: text, Limit: limit})
+	ids := make([]primitive.ObjectID, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		if id, err := primitive.ObjectIDFromHex(hit.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SuggestProducts returns the titles of the best matching listings for a
+// partly typed query
+func (s *SearchService) SuggestProducts(prefix string, limit int) []ProductSuggestion {
+	result := s.index.Search(search.Query{Text: prefix, Limit: limit})
+	suggestions := make([]ProductSuggestion, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		if doc, ok := s.index.Get(hit.ID); ok {
+			suggestions = append(suggestions, ProductSuggestion{ID: doc.ID, Title: doc.Title})
+		}
+	}
+	return suggestions
+}
+
+// Rebuild regenerates the whole index from the active products in Mongo and
+// saves it to disk. Products reindexed while it runs keep their new state.
+func (s *SearchService) Rebuild(ctx context.Context) (int, error) {
+	s.index.StartRebuild()
+	docs, err := activeDocuments(ctx)
+	if err != nil {
+		s.index.CancelRebuild()
+		return 0, err
+	}
+
+	s.index.Replace(docs)
+	return len(docs), s.index.Save()
+}
+
+// activeDocuments loads every active product as a search document
+func activeDocuments(ctx context.Context) ([]search.Document, error) {
+	schemas, err := categoryAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := config.Coll.Products.Find(ctx, bson.M{"status": models.ProductStatusActive})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []search.Document
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, err
+		}
+		docs = append(docs, productDocument(&product, schemas[product.CategoryID]))
+	}
+	return docs, cursor.Err()
+}
+
+// SetPopularity updates the popularity of indexed products for the popular sort
+func (s *SearchService) SetPopularity(scores map[primitive.ObjectID]float64) {
+	byID := make(map[string]float64, len(scores))
+	for id, score := range scores {
+		byID[id.Hex()] = score
+	}
+	s.index.SetPopularity(byID)
+}
+
+// Flush saves pending index changes to disk
+func (s *SearchService) Flush() error {
+	return s.index.Save()
+}
+
+// labelCategories fills in category names for the category facet
+func (s *SearchService) labelCategories(ctx context.Context, facets []search.FacetCount) {
+	ids := make([]primitive.ObjectID, 0, len(facets))
+	for _, facet := range facets {
+		if id, err := primitive.ObjectIDFromHex(facet.Value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	cursor, err := config.Coll.Categories.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return
+	}
+	names := make(map[string]string, len(categories))
+	for _, category := range categories {
+		names[category.ID.Hex()] = category.Name
+	}
+	for i := range facets {
+		facets[i].Label = names[facets[i].Value]
+	}
+}
+
+// categoryAttributes loads the attribute schema of every category
+func categoryAttributes(ctx context.Context) (map[primitive.ObjectID][]models.CategoryAttribute, error) {
+	cursor, err := config.Coll.Categories.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	schemas := make(map[primitive.ObjectID][]models.CategoryAttribute, len(categories))
+	for _, category := range categories {
+		schemas[category.ID] = category.Attributes
+	}
+	return schemas, nil
+}
+
+func productDocument(product *models.Product, attributes []models.CategoryAttribute) search.Document {
+	doc := search.Document{
+		ID:          product.ID.Hex(),
+		Title:       product.Title,
+		Brand:       product.Brand,
+		Model:       product.Model,
+		Tags:        append(append([]string{}, product.Tags...), product.Keywords...),
+		Description: product.Description,
+		Category:    product.CategoryID.Hex(),
+		Condition:   string(product.Condition),
+		City:        product.Location.City,
+		State:       product.Location.State,
+		Point:       productPoint(product.Location),
+		Price:       product.Price,
+		CreatedAt:   product.CreatedAt,
+		PublishedAt: product.CreatedAt,
+		Popularity:  product.PopularityScore,
+		Attributes:  make(map[string][]string),
+		Numbers:     make(map[string]float64),
+	}
+
+	if product.PublishedAt != nil {
+		doc.PublishedAt = *product.PublishedAt
+	}
+	if product.BoostExpiresAt != nil && product.BoostExpiresAt.After(time.Now()) {
+		doc.Boost = product.BoostLevel
+	}
+
+	// Only filterable attributes become facets; specifications are matched
+	// to them the same way listings were validated
+	specs := make(map[string]interface{}, len(product.Specifications))
+	for key, value := range product.Specifications {
+		specs[strings.ToLower(key)] = value
+	}
+	for _, attr := range attributes {
+		key := strings.ToLower(attr.Name)
+		value, ok := specs[key]
+		if !attr.IsFilterable || !ok {
+			continue
+		}
+		switch attr.Type {
+		case models.AttributeTypeNumber, models.AttributeTypeRange:
+			n, ok := toNumber(value)
+			if !ok {
+				continue
+			}
+			doc.Numbers[key] = n
+			if attr.Type == models.AttributeTypeNumber {
+				doc.Attributes[key] = []string{formatNumber(n)}
+			}
+		default:
+			if values := AttributeStrings(value); len(values) > 0 {
+				doc.Attributes[key] = values
+			}
+		}
+	}
+	return doc
+}
+
+// productPoint returns where a listing is, from its coordinates or, for
+// listings saved without them, from its city and state
+func productPoint(location models.ProductLocation) *geo.Point {
+	if point, ok := geo.FromCoordinates(location.Coordinates); ok {
+		return &point
+	}
+	if place, ok := geo.Geocode(location.Address, location.City, location.State); ok {
+		return &place.Point
+	}
+	return nil
+}
+
+// parsePriceBuckets reads comma-separated price facet bounds, skipping bad values
+func parsePriceBuckets(raw string) []float64 {
+	var bounds []float64
+	for _, part := range strings.Split(raw, ",") {
+		if bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64); err == nil && bound > 0 {
+			bounds = append(bounds, bound)
+		}
+	}
+	return bounds
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// clickWindow is how long after a search a click is still credited to it
+const clickWindow = 24 * time.Hour
+
+var ErrSearchNotFound = errors.New("search not found")
+
+// SearchAnalyticsService logs searches and clicks, turns popular queries
+// into suggestions and reports on what buyers look for
+type SearchAnalyticsService struct {
+	search *SearchService
+
+	window   time.Duration
+	minCount int
+}
+
+// SearchLog is one search to record. A zero ID is assigned on logging.
+type SearchLog struct {
+	ID        primitive.ObjectID
+	UserID    *primitive.ObjectID
+	Query     string
+	Source    string
+	Filters   models.SearchFilters
+	Results   int
+	IPAddress string
+	UserAgent string
+}
+
+// Suggestions are autocomplete candidates for a prefix
+type Suggestions struct {
+	Queries    []string             `json:"queries"`
+	Products   []ProductSuggestion  `json:"products"`
+	Brands     []string             `json:"brands"`
+	Categories []CategorySuggestion `json:"categories"`
+}
+
+type ProductSuggestion struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type CategorySuggestion struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id"`
+	Name string             `json:"name" bson:"name"`
+	Slug string             `json:"slug" bson:"slug"`
+}
+
+// QueryStat summarises the searches for one normalized query
+type QueryStat struct {
+	Query            string    `json:"query" bson:"_id"`
+	Searches         int       `json:"searches" bson:"searches"`
+	SearchesClicked  int       `json:"searches_clicked" bson:"searches_clicked"`
+	Clicks           int       `json:"clicks" bson:"clicks"`
+	ClickThroughRate float64   `json:"click_through_rate" bson:"-"`
+	AverageResults   float64   `json:"average_results" bson:"average_results"`
+	LastSearchedAt   time.Time `json:"last_searched_at" bson:"last_searched_at"`
+}
+
+// SearchReport covers searches since a point in time
+type SearchReport struct {
+	Since             time.Time   `json:"since"`
+	TotalSearches     int         `json:"total_searches"`
+	SearchesClicked   int         `json:"searches_clicked"`
+	ZeroResults       int         `json:"zero_results"`
+	ClickThroughRate  float64     `json:"click_through_rate"`
+	ZeroResultRate    float64     `json:"zero_result_rate"`
+	TopQueries        []QueryStat `json:"top_queries"`
+	ZeroResultQueries []QueryStat `json:"zero_result_queries"`
+}
+
+func NewSearchAnalyticsService(search *SearchService) *SearchAnalyticsService {
+	return &SearchAnalyticsService{
+		search:   search,
+		window:   time.Duration(utils.GetEnvAsInt("SEARCH_SUGGESTIONS_WINDOW_DAYS", 30)) * 24 * time.Hour,
+		minCount: utils.GetEnvAsInt("SEARCH_SUGGESTIONS_MIN_COUNT", 3),
+	}
+}
+
+// NormalizeQuery lowercases a query and collapses its spacing so that the
+// same search typed differently is counted once
+func NormalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// Log records a search and returns its ID for click tracking. Guest
+// searches keep no user agent and only a truncated IP address.
+func (s *SearchAnalyticsService) Log(ctx context.Context, entry SearchLog) (primitive.ObjectID, error) {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	history := models.SearchHistory{
+		ID:              entry.ID,
+		UserID:          entry.UserID,
+		Query:           strings.TrimSpace(entry.Query),
+		NormalizedQuery: NormalizeQuery(entry.Query),
+		Source:          entry.Source,
+		Filters:         entry.Filters,
+		Results:         entry.Results,
+		IPAddress:       entry.IPAddress,
+		UserAgent:       entry.UserAgent,
+		CreatedAt:       time.Now(),
+	}
+	if entry.UserID == nil {
+		history.IPAddress = anonymizeIP(entry.IPAddress)
+		history.UserAgent = ""
+	}
+
+	_, err := config.Coll.SearchHistory.InsertOne(ctx, history)
+	return history.ID, err
+}
+
+// RecordClick credits a result click to the search it came from. Repeat
+// clicks on the same product are counted once.
+func (s *SearchAnalyticsService) RecordClick(ctx context.Context, searchID, productID primitive.ObjectID, position int) error {
+	var history models.SearchHistory
+	err := config.Coll.SearchHistory.FindOne(ctx, bson.M{
+		"_id":        searchID,
+		"created_at": bson.M{"$gte": time.Now().Add(-clickWindow)},
+	}).Decode(&history)
+	if err != nil {
+		return ErrSearchNotFound
+	}
+
+	_, err = config.Coll.SearchHistory.UpdateOne(ctx,
+		bson.M{"_id": searchID, "clicks.product_id": bson.M{"$ne": productID}},
+		bson.M{"$push": bson.M{"clicks": models.SearchClick{
+			ProductID: productID,
+			Position:  position,
+			ClickedAt: time.Now(),
+		}}},
+	)
+	return err
+}
+
+// RefreshSuggestions recounts the queries searched in the suggestion window
+// that found results. Queries searched at least SEARCH_SUGGESTIONS_MIN_COUNT
+// times become suggestions; ones that fall below are switched off. It
+// returns the number of active query suggestions.
+func (s *SearchAnalyticsService) RefreshSuggestions(ctx context.Context) (int, error) {
+	now := time.Now()
+	cursor, err := config.Coll.SearchHistory.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"created_at":       bson.M{"$gte": now.Add(-s.window)},
+			"normalized_query": bson.M{"$ne": ""},
+			"results":          bson.M{"$gt": 0},
+		}},
+		{"$group": bson.M{"_id": "$normalized_query", "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gte": s.minCount}}},
+		{"$sort": bson.M{"count": -1}},
+		{"$limit": 5000},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	active := 0
+	for cursor.Next(ctx) {
+		var row struct {
+			Query string `bson:"_id"`
+			Count int    `bson:"count"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return active, err
+		}
+		_, err := config.Coll.SearchSuggestions.UpdateOne(ctx,
+			bson.M{"type": models.SuggestionTypeQuery, "query": row.Query},
+			bson.M{
+				"$set":         bson.M{"count": row.Count, "is_active": true, "updated_at": now},
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return active, err
+		}
+		active++
+	}
+	if err := cursor.Err(); err != nil {
+		return active, err
+	}
+
+	_, err = config.Coll.SearchSuggestions.UpdateMany(ctx,
+		bson.M{"type": models.SuggestionTypeQuery, "updated_at": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"is_active": false, "count": 0, "updated_at": now}},
+	)
+	return active, err
+}
+
+// Suggest returns autocomplete candidates for what a buyer has typed so
+// far: popular queries, matching listings, brands and categories
+func (s *SearchAnalyticsService) Suggest(ctx context.Context, prefix string, limit int) (*Suggestions, error) {
+	prefix = NormalizeQuery(prefix)
+	suggestions := &Suggestions{
+		Queries:    []string{},
+		Products:   []ProductSuggestion{},
+		Brands:     []string{},
+		Categories: []CategorySuggestion{},
+	}
+	if prefix == "" {
+		return suggestions, nil
+	}
+	startsWith := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}
+	wordStart := primitive.Regex{Pattern: `(^|\s)` + regexp.QuoteMeta(prefix), Options: "i"}
+
+	cursor, err := config.Coll.SearchSuggestions.Find(ctx,
+		bson.M{"type": models.SuggestionTypeQuery, "is_active": true, "query": startsWith},
+		options.Find().SetSort(bson.D{{Key: "count", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var popular []models.SearchSuggestion
+	if err := cursor.All(ctx, &popular); err != nil {
+		return nil, err
+	}
+	for _, suggestion := range popular {
+		suggestions.Queries = append(suggestions.Queries, suggestion.Query)
+	}
+
+	suggestions.Products = s.search.SuggestProducts(prefix, limit)
+
+	brands, err := config.Coll.Products.Distinct(ctx, "brand", bson.M{
+		"status": models.ProductStatusActive,
+		"brand":  wordStart,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, brand := range brands {
+		if name, ok := brand.(string); ok && name != "" && !containsFold(suggestions.Brands, name) {
+			suggestions.Brands = append(suggestions.Brands, name)
+		}
+		if len(suggestions.Brands) >= 3 {
+			break
+		}
+	}
+
+	cursor, err = config.Coll.Categories.Find(ctx,
+		bson.M{"is_active": true, "name": wordStart},
+		options.Find().SetProjection(bson.M{"name": 1, "slug": 1}).SetSort(bson.D{{Key: "sort_order", Value: 1}}).SetLimit(3),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &suggestions.Categories); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// Report summarises searches since a point in time, with the limit most
+// searched queries and the limit most searched queries that found nothing
+func (s *SearchAnalyticsService) Report(ctx context.Context, since time.Time, limit int) (*SearchReport, error) {
+	report := &SearchReport{Since: since}
+
+	clicked := bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$clicks", bson.A{}}}}, 0}}, 1, 0}}
+	cursor, err := config.Coll.SearchHistory.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":     nil,
+			"total":   bson.M{"$sum": 1},
+			"clicked": bson.M{"$sum": clicked},
+			"zero":    bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$results", 0}}, 1, 0}}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var totals []struct {
+		Total   int `bson:"total"`
+		Clicked int `bson:"clicked"`
+		Zero    int `bson:"zero"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	if len(totals) > 0 {
+		report.TotalSearches = totals[0].Total
+		report.SearchesClicked = totals[0].Clicked
+		report.ZeroResults = totals[0].Zero
+		report.ClickThroughRate = rate(totals[0].Clicked, totals[0].Total)
+		report.ZeroResultRate = rate(totals[0].Zero, totals[0].Total)
+	}
+
+	if report.TopQueries, err = queryStats(ctx, bson.M{"created_at": bson.M{"$gte": since}, "normalized_query": bson.M{"$ne": ""}}, clicked, limit); err != nil {
+		return nil, err
+	}
+	if report.ZeroResultQueries, err = queryStats(ctx, bson.M{"created_at": bson.M{"$gte": since}, "normalized_query": bson.M{"$ne": ""}, "results": 0}, clicked, limit); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func queryStats(ctx context.Context, match bson.M, clicked bson.M, limit int) ([]QueryStat, error) {
+	cursor, err := config.Coll.SearchHistory.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":              "$normalized_query",
+			"searches":         bson.M{"$sum": 1},
+			"searches_clicked": bson.M{"$sum": clicked},
+			"clicks":           bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": bson.A{"$clicks", bson.A{}}}}},
+			"average_results":  bson.M{"$avg": "$results"},
+			"last_searched_at": bson.M{"$max": "$created_at"},
+		}},
+		{"$sort": bson.D{{Key: "searches", Value: -1}, {Key: "_id", Value: 1}}},
+		{"$limit": limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := []QueryStat{}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		stats[i].ClickThroughRate = rate(stats[i].SearchesClicked, stats[i].Searches)
+	}
+	return stats, nil
+}
+
+func rate(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+// anonymizeIP drops the host part of an address: the last octet of IPv4
+// and all but the first 48 bits of IPv6
+func anonymizeIP(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -13,6 +13,27 @@ type Services struct {
 	Cache    *CacheService
 	Search   *SearchService
 	Analytics *AnalyticsService
+	Wallet    *WalletService
+	Escrow    *EscrowService
+	Withdrawal *WithdrawalService
+	Refund     *RefundService
+	Checkout   *CheckoutService
+	Promo      *PromoService
+	Inventory  *InventoryService
+	Order      *OrderService
+	SavedSearch *SavedSearchService
+	SearchAnalytics *SearchAnalyticsService
+	Recommendation  *RecommendationService
+	Ranking         *RankingService
+	Events          *EventTracker
+	ProductAnalytics *ProductAnalyticsService
+	ListingLifecycle *ListingLifecycleService
+	ProductImport    *ProductImportService
+	TwoFactor        *TwoFactorService
+	LoginGuard       *LoginGuardService
+	SocialLogin      *SocialLoginService
+	AdminRoles       *AdminRoleService
+	Webhook          *WebhookService
 }
 
 var AppServices *Services
@@ -21,14 +42,49 @@ var AppServices *Services
 func InitializeServices() {
 	log.Println("Initializing application services...")
 
+	wallet := NewWalletService()
+	payment := NewPaymentService()
+	escrow := NewEscrowService(wallet)
+	search := NewSearchService()
+	inventory := NewInventoryService(search)
+	email := NewEmailService()
+	orders := NewOrderService(email, escrow, inventory)
+	sms := NewSMSService()
+	withdrawals := NewWithdrawalService(wallet, payment)
+	refunds := NewRefundService(payment, wallet, escrow, orders)
+	orders.refunds = refunds
+	promos := NewPromoService()
+	checkouts := NewCheckoutService(wallet, escrow, inventory, orders, refunds, promos)
+
 	AppServices = &Services{
-		Email:     NewEmailService(),
-		SMS:       NewSMSService(),
+		Email:     email,
+		SMS:       sms,
 		Image:     NewImageService(),
-		Payment:   NewPaymentService(),
+		Payment:   payment,
 		Cache:     NewCacheService(),
-		Search:    NewSearchService(),
+		Search:    search,
 		Analytics: NewAnalyticsService(),
+		Wallet:    wallet,
+		Escrow:    escrow,
+		Withdrawal: withdrawals,
+		Refund:     refunds,
+		Checkout:   checkouts,
+		Promo:      promos,
+		Inventory:  inventory,
+		Order:      orders,
+		SavedSearch: NewSavedSearchService(search, email),
+		SearchAnalytics: NewSearchAnalyticsService(search),
+		Recommendation:  NewRecommendationService(),
+		Ranking:         NewRankingService(search),
+		Events:          NewEventTracker(),
+		ProductAnalytics: NewProductAnalyticsService(),
+		ListingLifecycle: NewListingLifecycleService(search, wallet, email),
+		ProductImport:    NewProductImportService(search),
+		TwoFactor:        NewTwoFactorService(sms),
+		LoginGuard:       NewLoginGuardService(email, sms),
+		SocialLogin:      NewSocialLoginService(),
+		AdminRoles:       NewAdminRoleService(),
+		Webhook:          NewWebhookService(payment, wallet, escrow, withdrawals, checkouts, inventory, refunds),
 	}
 
 	log.Println("All services initialized successfully")
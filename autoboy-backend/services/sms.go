@@ -149,6 +149,12 @@ func (s *SMSService) SendSecurityAlert(phone, alertType string) error {
 		message = "New login detected on your AutoBoy account. If this wasn't you, please secure your account immediately."
 	case "password_change":
 		message = "Your AutoBoy account password was changed. If you didn't make this change, contact support immediately."
+	case "two_factor_enabled":
+		message = "Two-factor authentication was turned on for your AutoBoy account. If this wasn't you, contact support immediately."
+	case "two_factor_disabled":
+		message = "Two-factor authentication was turned off for your AutoBoy account. If this wasn't you, secure your account and contact support immediately."
+	case "two_factor_locked":
+		message = "Too many wrong verification codes were entered on your AutoBoy account, so sensitive changes are paused for a while. If this wasn't you, change your password."
 	case "suspicious_activity":
 		message = "Suspicious activity detected on your AutoBoy account. Please review your account security settings."
 	default:
@@ -0,0 +1,852 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/middleware"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrSocialProviderDisabled = errors.New("sign-in with this provider is not available")
+	ErrSocialTokenInvalid     = errors.New("invalid or expired sign-in token")
+	ErrSocialStateInvalid     = errors.New("sign-in expired or was already used; start again")
+	ErrSocialEmailUnverified  = errors.New("the provider has not verified this email address")
+	ErrSocialSignupNotFound   = errors.New("registration expired; sign in with the provider again")
+	ErrSocialAccountExists    = errors.New("user with this email, username, or phone already exists")
+	ErrSocialNotLinked        = errors.New("no account from this provider is linked")
+	ErrSocialLastSignIn       = errors.New("set a password or verify your phone before unlinking your last way to sign in")
+)
+
+const (
+	oidcDiscoveryTTL  = 24 * time.Hour
+	oidcKeysTTL       = time.Hour
+	oidcKeysMinReload = time.Minute // an unknown key ID reloads the keys at most this often
+)
+
+// SocialLoginService signs users in with Google and Apple over OpenID
+// Connect. Apps send the ID token their platform SDK returned; browsers
+// go through the authorization code flow with PKCE. An identity is matched
+// to a user by its provider subject, then by verified email, which links
+// it; one with no account becomes a registration the user finishes with a
+// username and phone number.
+type SocialLoginService struct {
+	providers   map[models.SocialProvider]*oidcProvider
+	redirectURL string
+	stateTTL    time.Duration
+	signupTTL   time.Duration
+}
+
+// SocialIdentity is who a provider's ID token says the user is
+type SocialIdentity struct {
+	Provider      models.SocialProvider
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+	Avatar        string
+}
+
+// SocialAuthorization is where to send a browser to sign in with a provider
+type SocialAuthorization struct {
+	URL       string `json:"authorization_url"`
+	State     string `json:"state"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// SocialSignIn is the outcome of signing in with a provider: either a user
+// to sign in or a registration to finish
+type SocialSignIn struct {
+	User   *models.User
+	Signup *PendingSocialSignup
+}
+
+// PendingSocialSignup is what a client needs to ask a new user for the rest
+// of their details
+type PendingSocialSignup struct {
+	Token     string                `json:"registration_token"`
+	Provider  models.SocialProvider `json:"provider"`
+	Email     string                `json:"email"`
+	FirstName string                `json:"first_name,omitempty"`
+	LastName  string                `json:"last_name,omitempty"`
+	Avatar    string                `json:"avatar,omitempty"`
+	ExpiresIn int                   `json:"expires_in"`
+}
+
+// SocialRegistration is what a new user adds to a provider identity
+type SocialRegistration struct {
+	Username     string
+	Phone        string
+	UserType     models.UserType
+	FirstName    string
+	LastName     string
+	ShopName     string
+	ShopLocation string
+	AccountType  string
+}
+
+func NewSocialLoginService() *SocialLoginService {
+	s := &SocialLoginService{
+		providers:   make(map[models.SocialProvider]*oidcProvider),
+		redirectURL: utils.GetEnv("SOCIAL_LOGIN_REDIRECT_URL", utils.GetEnv("FRONTEND_URL", "http://localhost:3000")+"/auth/callback"),
+		stateTTL:    10 * time.Minute,
+		signupTTL:   time.Duration(utils.GetEnvAsInt("SOCIAL_SIGNUP_MINUTES", 30)) * time.Minute,
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	if ids := splitClientIDs(utils.GetEnv("GOOGLE_CLIENT_IDS", "")); len(ids) > 0 {
+		secret := utils.GetEnv("GOOGLE_CLIENT_SECRET", "")
+		s.providers[models.SocialProviderGoogle] = &oidcProvider{
+			name:      models.SocialProviderGoogle,
+			issuer:    utils.GetEnv("GOOGLE_OIDC_ISSUER", "https://accounts.google.com"),
+			clientIDs: ids,
+			scopes:    "openid email profile",
+			client:    client,
+			clientSecret: func(issuer string) (string, error) {
+				return secret, nil
+			},
+		}
+	}
+
+	if ids := splitClientIDs(utils.GetEnv("APPLE_CLIENT_IDS", "")); len(ids) > 0 {
+		s.providers[models.SocialProviderApple] = &oidcProvider{
+			name:      models.SocialProviderApple,
+			issuer:    utils.GetEnv("APPLE_OIDC_ISSUER", "https://appleid.apple.com"),
+			clientIDs: ids,
+			scopes:    "openid email name",
+			formPost:  true,
+			client:    client,
+			clientSecret: appleClientSecret(
+				ids[0],
+				utils.GetEnv("APPLE_TEAM_ID", ""),
+				utils.GetEnv("APPLE_KEY_ID", ""),
+				utils.GetEnv("APPLE_PRIVATE_KEY", ""),
+				utils.GetEnv("APPLE_CLIENT_SECRET", ""),
+			),
+		}
+	}
+
+	return s
+}
+
+// Providers lists the providers users can sign in with
+func (s *SocialLoginService) Providers() []models.SocialProvider {
+	providers := []models.SocialProvider{}
+	for _, name := range []models.SocialProvider{models.SocialProviderGoogle, models.SocialProviderApple} {
+		if _, ok := s.providers[name]; ok {
+			providers = append(providers, name)
+		}
+	}
+	return providers
+}
+
+// Authorize starts a browser sign-in, returning the provider URL to send
+// the user to
+func (s *SocialLoginService) Authorize(ctx context.Context, provider models.SocialProvider, ipAddress string) (*SocialAuthorization, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	record := models.OAuthState{
+		ID:           primitive.NewObjectID(),
+		Provider:     provider,
+		StateHash:    hashSecret(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RedirectURI:  s.redirectURI(provider),
+		IPAddress:    ipAddress,
+		ExpiresAt:    now.Add(s.stateTTL),
+		CreatedAt:    now,
+	}
+	if _, err := config.Coll.OAuthStates.InsertOne(ctx, record); err != nil {
+		return nil, err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientIDs[0]},
+		"redirect_uri":          {record.RedirectURI},
+		"scope":                 {p.scopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.formPost {
+		query.Set("response_mode", "form_post")
+	}
+
+	return &SocialAuthorization{
+		URL:       discovery.AuthorizationEndpoint + "?" + query.Encode(),
+		State:     state,
+		ExpiresIn: int(s.stateTTL.Seconds()),
+	}, nil
+}
+
+// Callback finishes a browser sign-in with the code the provider sent back.
+// Each state works once.
+func (s *SocialLoginService) Callback(ctx context.Context, provider models.SocialProvider, code, state string, name *SocialIdentity) (*SocialSignIn, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var record models.OAuthState
+	err = config.Coll.OAuthStates.FindOneAndDelete(ctx, bson.M{
+		"provider":   provider,
+		"state_hash": hashSecret(state),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSocialStateInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := p.exchange(ctx, code, record.CodeVerifier, record.RedirectURI)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := p.verify(ctx, idToken, record.Nonce, false)
+	if err != nil {
+		return nil, err
+	}
+	identity.withName(name)
+	return s.resolve(ctx, identity)
+}
+
+// SignInWithIDToken signs in with an ID token from a platform SDK. When the
+// app sent a nonce with its sign-in request it has to send it here too;
+// Apple's SDK puts its SHA-256 in the token.
+func (s *SocialLoginService) SignInWithIDToken(ctx context.Context, provider models.SocialProvider, idToken, nonce string, name *SocialIdentity) (*SocialSignIn, error) {
+	p, err := s.provider(provider)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := p.verify(ctx, idToken, nonce, true)
+	if err != nil {
+		return nil, err
+	}
+	identity.withName(name)
+	return s.resolve(ctx, identity)
+}
+
+// Register opens an account for a provider identity with no user yet. The
+// email is taken as verified; the phone still needs verifying.
+func (s *SocialLoginService) Register(ctx context.Context, token string, reg SocialRegistration) (*models.User, error) {
+	var signup models.SocialSignup
+	err := config.Coll.SocialSignups.FindOne(ctx, bson.M{
+		"token_hash": hashSecret(token),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&signup)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSocialSignupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	phone := utils.NormalizePhone(reg.Phone)
+	count, err := config.Coll.Users.CountDocuments(ctx, bson.M{
+		"$or": []bson.M{
+			{"email": bson.M{"$in": emailVariants(signup.Email)}},
+			{"username": reg.Username},
+			{"phone": phone},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrSocialAccountExists
+	}
+
+	firstName, lastName := reg.FirstName, reg.LastName
+	if firstName == "" {
+		firstName = signup.FirstName
+	}
+	if lastName == "" {
+		lastName = signup.LastName
+	}
+
+	now := time.Now()
+	user := models.User{
+		ID:              primitive.NewObjectID(),
+		Username:        reg.Username,
+		Email:           signup.Email,
+		Phone:           phone,
+		UserType:        reg.UserType,
+		Status:          models.UserStatusActive,
+		IsEmailVerified: true,
+		IsPhoneVerified: false,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		SocialAccounts: []models.SocialAccount{{
+			Provider: signup.Provider,
+			Subject:  signup.Subject,
+			Email:    signup.Email,
+			LinkedAt: now,
+		}},
+		Profile: models.Profile{
+			FirstName:          firstName,
+			LastName:           lastName,
+			Avatar:             signup.Avatar,
+			VerificationStatus: models.VerificationStatusUnverified,
+			PremiumStatus:      models.PremiumStatusNone,
+			BadgeLevel:         1,
+			ShopName:           reg.ShopName,
+			ShopLocation:       reg.ShopLocation,
+			AccountType:        reg.AccountType,
+			Preferences: models.UserPreferences{
+				Language:           "en",
+				Currency:           "NGN",
+				Timezone:           "Africa/Lagos",
+				EmailNotifications: true,
+				SMSNotifications:   true,
+				PushNotifications:  true,
+				MarketingEmails:    false,
+				Theme:              "light",
+			},
+		},
+	}
+	if _, err := config.Coll.Users.InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrSocialAccountExists
+		}
+		return nil, err
+	}
+
+	if _, err := config.Coll.SocialSignups.DeleteOne(ctx, bson.M{"_id": signup.ID}); err != nil {
+		log.Printf("Failed to remove social signup %s: %v", signup.ID.Hex(), err)
+	}
+	return &user, nil
+}
+
+// Unlink removes a provider from the user's sign-in methods, as long as
+// they keep another way to sign in
+func (s *SocialLoginService) Unlink(ctx context.Context, user *models.User, provider models.SocialProvider) error {
+	linked, others := false, false
+	for _, account := range user.SocialAccounts {
+		if account.Provider == provider {
+			linked = true
+		} else {
+			others = true
+		}
+	}
+	if !linked {
+		return ErrSocialNotLinked
+	}
+	if user.Password == "" && !user.IsPhoneVerified && !others {
+		return ErrSocialLastSignIn
+	}
+
+	_, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$pull": bson.M{"social_accounts": bson.M{"provider": provider}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// resolve finds the user an identity belongs to. A verified email that
+// matches an account links the identity to it; an account nobody verified
+// the email of loses its password, since whoever set it never proved they
+// own the address.
+func (s *SocialLoginService) resolve(ctx context.Context, identity *SocialIdentity) (*SocialSignIn, error) {
+	var user models.User
+	err := config.Coll.Users.FindOne(ctx, bson.M{
+		"social_accounts": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}},
+	}).Decode(&user)
+	if err == nil {
+		return &SocialSignIn{User: &user}, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrSocialEmailUnverified
+	}
+
+	err = config.Coll.Users.FindOne(ctx, bson.M{"email": bson.M{"$in": emailVariants(identity.Email)}}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		signup, err := s.startSignup(ctx, identity)
+		if err != nil {
+			return nil, err
+		}
+		return &SocialSignIn{Signup: signup}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.link(ctx, &user, identity); err != nil {
+		return nil, err
+	}
+	return &SocialSignIn{User: &user}, nil
+}
+
+func (s *SocialLoginService) link(ctx context.Context, user *models.User, identity *SocialIdentity) error {
+	now := time.Now()
+	account := models.SocialAccount{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: now,
+	}
+	set := bson.M{"updated_at": now}
+	takeover := !user.IsEmailVerified
+	if takeover {
+		set["is_email_verified"] = true
+		set["password"] = ""
+	}
+
+	_, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$push": bson.M{"social_accounts": account}, "$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	user.SocialAccounts = append(user.SocialAccounts, account)
+
+	if takeover {
+		user.IsEmailVerified = true
+		user.Password = ""
+		if _, err := middleware.RevokeUserSessions(user.ID, primitive.NilObjectID, models.SessionRevokedPasswordChange); err != nil {
+			log.Printf("Failed to revoke sessions for user %s: %v", user.ID.Hex(), err)
+		}
+		log.Printf("User %s claimed by verified %s email; password removed", user.ID.Hex(), identity.Provider)
+	}
+	return nil
+}
+
+func (s *SocialLoginService) startSignup(ctx context.Context, identity *SocialIdentity) (*PendingSocialSignup, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	signup := models.SocialSignup{
+		ID:            primitive.NewObjectID(),
+		TokenHash:     hashSecret(token),
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		FirstName:     identity.FirstName,
+		LastName:      identity.LastName,
+		Avatar:        identity.Avatar,
+		ExpiresAt:     now.Add(s.signupTTL),
+		CreatedAt:     now,
+	}
+	if _, err := config.Coll.SocialSignups.InsertOne(ctx, signup); err != nil {
+		return nil, err
+	}
+
+	return &PendingSocialSignup{
+		Token:     token,
+		Provider:  signup.Provider,
+		Email:     signup.Email,
+		FirstName: signup.FirstName,
+		LastName:  signup.LastName,
+		Avatar:    signup.Avatar,
+		ExpiresIn: int(s.signupTTL.Seconds()),
+	}, nil
+}
+
+func (s *SocialLoginService) provider(name models.SocialProvider) (*oidcProvider, error) {
+	p, ok := s.providers[name]
+	if !ok {
+		return nil, ErrSocialProviderDisabled
+	}
+	return p, nil
+}
+
+func (s *SocialLoginService) redirectURI(provider models.SocialProvider) string {
+	return strings.TrimRight(s.redirectURL, "/") + "/" + string(provider)
+}
+
+// withName fills in a name the client passed along, which Apple only
+// shares with the app on the first sign-in
+func (identity *SocialIdentity) withName(name *SocialIdentity) {
+	if name == nil {
+		return
+	}
+	if identity.FirstName == "" {
+		identity.FirstName = name.FirstName
+	}
+	if identity.LastName == "" {
+		identity.LastName = name.LastName
+	}
+}
+
+// oidcProvider talks to one OpenID Connect provider, found through its
+// discovery document. Any issuer that serves one works, including a local
+// stand-in (cmd/oidc-standin).
+type oidcProvider struct {
+	name         models.SocialProvider
+	issuer       string
+	clientIDs    []string // the first is used for browser sign-in; the rest are app clients
+	scopes       string
+	formPost     bool
+	client       *http.Client
+	clientSecret func(issuer string) (string, error)
+
+	mu         sync.Mutex
+	discovery  *oidcDiscovery
+	discoverAt time.Time
+	keys       map[string]crypto.PublicKey
+	keysAt     time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // Apple sends "true" as a string
+	Nonce         string      `json:"nonce"`
+	GivenName     string      `json:"given_name"`
+	FamilyName    string      `json:"family_name"`
+	Picture       string      `json:"picture"`
+	jwt.RegisteredClaims
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoverAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimRight(p.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("%s discovery: %w", p.name, err)
+	}
+	if discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery document is incomplete", p.name)
+	}
+	p.discovery = &discovery
+	p.discoverAt = time.Now()
+	return p.discovery, nil
+}
+
+// key returns the provider's signing key with an ID. Keys rotate, so an
+// unknown ID reloads them.
+func (p *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok && time.Since(p.keysAt) < oidcKeysTTL {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysAt) < oidcKeysMinReload {
+		if key, ok := p.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, ErrSocialTokenInvalid
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("%s keys: %w", p.name, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrSocialTokenInvalid
+	}
+	return key, nil
+}
+
+// verify checks an ID token's signature, issuer, audience, expiry and
+// nonce. A nonce is required unless an app signed in without one.
+func (p *oidcProvider) verify(ctx context.Context, idToken, nonce string, nonceOptional bool) (*SocialIdentity, error) {
+	var claims oidcClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		if errors.Is(err, ErrSocialTokenInvalid) || errors.Is(err, jwt.ErrTokenMalformed) ||
+			errors.Is(err, jwt.ErrTokenSignatureInvalid) || errors.Is(err, jwt.ErrTokenExpired) ||
+			errors.Is(err, jwt.ErrTokenNotValidYet) || errors.Is(err, jwt.ErrTokenRequiredClaimMissing) {
+			return nil, ErrSocialTokenInvalid
+		}
+		return nil, err
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Google signs some tokens with its issuer minus the scheme
+	if claims.Issuer != discovery.Issuer && "https://"+claims.Issuer != discovery.Issuer {
+		return nil, ErrSocialTokenInvalid
+	}
+	if !p.acceptsAudience(claims.Audience) {
+		return nil, ErrSocialTokenInvalid
+	}
+	if claims.Subject == "" {
+		return nil, ErrSocialTokenInvalid
+	}
+	if nonce != "" || claims.Nonce != "" || !nonceOptional {
+		hashed := sha256.Sum256([]byte(nonce))
+		if nonce == "" || (claims.Nonce != nonce && claims.Nonce != fmt.Sprintf("%x", hashed)) {
+			return nil, ErrSocialTokenInvalid
+		}
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	return &SocialIdentity{
+		Provider:      p.name,
+		Subject:       claims.Subject,
+		Email:         strings.TrimSpace(claims.Email),
+		EmailVerified: verified,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		Avatar:        claims.Picture,
+	}, nil
+}
+
+func (p *oidcProvider) acceptsAudience(audience jwt.ClaimStrings) bool {
+	for _, aud := range audience {
+		for _, id := range p.clientIDs {
+			if aud == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exchange swaps an authorization code for the ID token
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier, redirectURI string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	secret, err := p.clientSecret(discovery.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientIDs[0]},
+		"code_verifier": {verifier},
+	}
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s token exchange: %w", p.name, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", fmt.Errorf("%s token exchange: %w", p.name, err)
+	}
+	if result.Error == "invalid_grant" {
+		return "", ErrSocialStateInvalid
+	}
+	if resp.StatusCode != http.StatusOK || result.IDToken == "" {
+		return "", fmt.Errorf("%s token exchange failed: %s %s", p.name, result.Error, result.ErrorDescription)
+	}
+	return result.IDToken, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// appleClientSecret returns how Apple's client secret is made: a fixed one
+// if set, otherwise a short-lived JWT signed with the team's private key
+func appleClientSecret(clientID, teamID, keyID, privateKey, fixed string) func(issuer string) (string, error) {
+	return func(issuer string) (string, error) {
+		if fixed != "" || privateKey == "" {
+			return fixed, nil
+		}
+		key, err := jwt.ParseECPrivateKeyFromPEM([]byte(strings.ReplaceAll(privateKey, `\n`, "\n")))
+		if err != nil {
+			return "", fmt.Errorf("apple private key: %w", err)
+		}
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    teamID,
+			Subject:   clientID,
+			Audience:  jwt.ClaimStrings{issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		})
+		token.Header["kid"] = keyID
+		return token.SignedString(key)
+	}
+}
+
+// emailVariants matches an email however its case was stored
+func emailVariants(email string) []string {
+	if lower := strings.ToLower(email); lower != email {
+		return []string{email, lower}
+	}
+	return []string{email}
+}
+
+func splitClientIDs(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
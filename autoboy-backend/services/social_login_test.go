@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/oidcstandin"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	standinWebClient = "web-client"
+	standinAppClient = "app-client"
+)
+
+// standinLogin is a social login service whose Google provider is an
+// in-process OIDC stand-in
+type standinLogin struct {
+	*SocialLoginService
+	issuer *httptest.Server
+}
+
+func newStandinLogin(t *testing.T) *standinLogin {
+	t.Helper()
+	var handler http.Handler
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(issuer.Close)
+
+	standin, err := oidcstandin.New(issuer.URL, "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler = standin.Handler()
+
+	service := &SocialLoginService{
+		providers: map[models.SocialProvider]*oidcProvider{
+			models.SocialProviderGoogle: {
+				name:      models.SocialProviderGoogle,
+				issuer:    issuer.URL,
+				clientIDs: []string{standinWebClient, standinAppClient},
+				scopes:    "openid email profile",
+				client:    issuer.Client(),
+				clientSecret: func(string) (string, error) {
+					return "", nil
+				},
+			},
+		},
+		redirectURL: "http://app.test/auth/callback",
+		stateTTL:    10 * time.Minute,
+		signupTTL:   30 * time.Minute,
+	}
+	return &standinLogin{SocialLoginService: service, issuer: issuer}
+}
+
+// mint has the stand-in issue an ID token to the app, as a platform SDK would
+func (s *standinLogin) mint(t *testing.T, email string, verified bool, nonce string) string {
+	t.Helper()
+	query := url.Values{
+		"aud":            {standinAppClient},
+		"email":          {email},
+		"email_verified": {fmt.Sprint(verified)},
+	}
+	if nonce != "" {
+		query.Set("nonce", nonce)
+	}
+	resp, err := s.issuer.Client().Get(s.issuer.URL + "/mint?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.IDToken
+}
+
+// authorize runs a browser sign-in up to the provider's redirect, signing
+// in as email, and returns the code and state sent back. override replaces
+// query parameters of the authorization URL.
+func (s *standinLogin) authorize(t *testing.T, email string, override url.Values) (string, string) {
+	t.Helper()
+	auth, err := s.Authorize(context.Background(), models.SocialProviderGoogle, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := url.Parse(auth.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := target.Query()
+	query.Set("email", email)
+	for name := range override {
+		query.Set(name, override.Get(name))
+	}
+	target.RawQuery = query.Encode()
+
+	client := *s.issuer.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(target.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func insertTestUser(t *testing.T, email string, emailVerified bool) *models.User {
+	t.Helper()
+	now := time.Now()
+	user := &models.User{
+		ID:              primitive.NewObjectID(),
+		Username:        "user_" + primitive.NewObjectID().Hex(),
+		Email:           email,
+		Phone:           "phone_" + primitive.NewObjectID().Hex(),
+		Password:        "password-hash",
+		UserType:        models.UserTypeBuyer,
+		Status:          models.UserStatusActive,
+		IsEmailVerified: emailVerified,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if _, err := config.Coll.Users.InsertOne(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config.Coll.Users.DeleteOne(context.Background(), bson.M{"_id": user.ID})
+	})
+	return user
+}
+
+func reloadUser(t *testing.T, id primitive.ObjectID) *models.User {
+	t.Helper()
+	var user models.User
+	if err := config.Coll.Users.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func TestVerifyNonce(t *testing.T) {
+	s := newStandinLogin(t)
+	p := s.providers[models.SocialProviderGoogle]
+	ctx := context.Background()
+	hashed := fmt.Sprintf("%x", sha256.Sum256([]byte("app-nonce")))
+
+	tests := []struct {
+		name          string
+		tokenNonce    string
+		nonce         string
+		nonceOptional bool
+		wantErr       bool
+	}{
+		{"matching nonce", "app-nonce", "app-nonce", false, false},
+		{"hashed nonce as Apple sends it", hashed, "app-nonce", true, false},
+		{"different nonce", "app-nonce", "other-nonce", true, true},
+		{"nonce in token but none sent", "app-nonce", "", true, true},
+		{"nonce sent but none in token", "", "app-nonce", true, true},
+		{"app without a nonce", "", "", true, false},
+		{"browser sign-in needs a nonce", "", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := s.mint(t, "nonce@example.com", true, tt.tokenNonce)
+			_, err := p.verify(ctx, token, tt.nonce, tt.nonceOptional)
+			if tt.wantErr && !errors.Is(err, ErrSocialTokenInvalid) {
+				t.Fatalf("verify() error = %v, want ErrSocialTokenInvalid", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsOtherAudience(t *testing.T) {
+	s := newStandinLogin(t)
+	resp, err := s.issuer.Client().Get(s.issuer.URL + "/mint?aud=someone-else&email=aud@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.providers[models.SocialProviderGoogle].verify(context.Background(), body.IDToken, "", true)
+	if !errors.Is(err, ErrSocialTokenInvalid) {
+		t.Fatalf("verify() error = %v, want ErrSocialTokenInvalid", err)
+	}
+}
+
+func TestSignInWithIDTokenRejectsWrongNonce(t *testing.T) {
+	s := newStandinLogin(t)
+	token := s.mint(t, "wrong-nonce@example.com", true, "issued-nonce")
+
+	_, err := s.SignInWithIDToken(context.Background(), models.SocialProviderGoogle, token, "replayed-nonce", nil)
+	if !errors.Is(err, ErrSocialTokenInvalid) {
+		t.Fatalf("SignInWithIDToken() error = %v, want ErrSocialTokenInvalid", err)
+	}
+}
+
+func TestSignInWithIDTokenLinksVerifiedEmail(t *testing.T) {
+	requireDatabase(t)
+	s := newStandinLogin(t)
+	user := insertTestUser(t, "linked@example.com", true)
+	// Providers may return the address in another case than it was registered in
+	token := s.mint(t, "Linked@Example.com", true, "link-nonce")
+
+	signIn, err := s.SignInWithIDToken(context.Background(), models.SocialProviderGoogle, token, "link-nonce", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signIn.User == nil || signIn.User.ID != user.ID {
+		t.Fatalf("signed in as %+v, want user %s", signIn.User, user.ID.Hex())
+	}
+
+	stored := reloadUser(t, user.ID)
+	if len(stored.SocialAccounts) != 1 || stored.SocialAccounts[0].Provider != models.SocialProviderGoogle {
+		t.Fatalf("social accounts = %+v, want the Google identity linked", stored.SocialAccounts)
+	}
+	if stored.Password != user.Password {
+		t.Fatal("linking an account with a verified email removed its password")
+	}
+
+	// The identity now signs in by subject, without matching the email again
+	token = s.mint(t, "Linked@Example.com", true, "second-nonce")
+	signIn, err = s.SignInWithIDToken(context.Background(), models.SocialProviderGoogle, token, "second-nonce", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signIn.User == nil || signIn.User.ID != user.ID {
+		t.Fatalf("second sign-in as %+v, want user %s", signIn.User, user.ID.Hex())
+	}
+	if stored := reloadUser(t, user.ID); len(stored.SocialAccounts) != 1 {
+		t.Fatalf("second sign-in linked the identity again: %+v", stored.SocialAccounts)
+	}
+}
+
+func TestSignInWithIDTokenNeedsVerifiedEmail(t *testing.T) {
+	requireDatabase(t)
+	s := newStandinLogin(t)
+	user := insertTestUser(t, "unverified-idp@example.com", true)
+	token := s.mint(t, "unverified-idp@example.com", false, "")
+
+	_, err := s.SignInWithIDToken(context.Background(), models.SocialProviderGoogle, token, "", nil)
+	if !errors.Is(err, ErrSocialEmailUnverified) {
+		t.Fatalf("SignInWithIDToken() error = %v, want ErrSocialEmailUnverified", err)
+	}
+	if stored := reloadUser(t, user.ID); len(stored.SocialAccounts) != 0 {
+		t.Fatalf("an unverified email was linked: %+v", stored.SocialAccounts)
+	}
+}
+
+func TestCallbackTakesOverUnverifiedAccount(t *testing.T) {
+	requireDatabase(t)
+	s := newStandinLogin(t)
+	user := insertTestUser(t, "squatted@example.com", false)
+	session := models.UserSession{
+		ID:           primitive.NewObjectID(),
+		UserID:       user.ID,
+		SessionToken: primitive.NewObjectID().Hex(),
+		IsActive:     true,
+		ExpiresAt:    time.Now().Add(time.Hour),
+		CreatedAt:    time.Now(),
+	}
+	if _, err := config.Coll.UserSessions.InsertOne(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config.Coll.UserSessions.DeleteOne(context.Background(), bson.M{"_id": session.ID})
+	})
+
+	code, state := s.authorize(t, "squatted@example.com", nil)
+	signIn, err := s.Callback(context.Background(), models.SocialProviderGoogle, code, state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signIn.User == nil || signIn.User.ID != user.ID {
+		t.Fatalf("signed in as %+v, want user %s", signIn.User, user.ID.Hex())
+	}
+
+	stored := reloadUser(t, user.ID)
+	if stored.Password != "" {
+		t.Fatal("the password set by whoever registered the unverified email was kept")
+	}
+	if !stored.IsEmailVerified {
+		t.Fatal("the email is not marked verified after the provider verified it")
+	}
+	var revoked models.UserSession
+	if err := config.Coll.UserSessions.FindOne(context.Background(), bson.M{"_id": session.ID}).Decode(&revoked); err != nil {
+		t.Fatal(err)
+	}
+	if revoked.IsActive {
+		t.Fatal("the existing sessions of the taken-over account are still active")
+	}
+
+	// Each state works once
+	if _, err := s.Callback(context.Background(), models.SocialProviderGoogle, code, state, nil); !errors.Is(err, ErrSocialStateInvalid) {
+		t.Fatalf("reused state error = %v, want ErrSocialStateInvalid", err)
+	}
+}
+
+func TestCallbackRejectsWrongNonce(t *testing.T) {
+	requireDatabase(t)
+	s := newStandinLogin(t)
+	user := insertTestUser(t, "callback-nonce@example.com", true)
+
+	code, state := s.authorize(t, "callback-nonce@example.com", url.Values{"nonce": {"not-the-stored-nonce"}})
+	_, err := s.Callback(context.Background(), models.SocialProviderGoogle, code, state, nil)
+	if !errors.Is(err, ErrSocialTokenInvalid) {
+		t.Fatalf("Callback() error = %v, want ErrSocialTokenInvalid", err)
+	}
+	if stored := reloadUser(t, user.ID); len(stored.SocialAccounts) != 0 {
+		t.Fatalf("an identity with the wrong nonce was linked: %+v", stored.SocialAccounts)
+	}
+}
@@ -0,0 +1,894 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrTwoFactorEnabled      = errors.New("two-factor authentication is already on")
+	ErrTwoFactorNotEnabled   = errors.New("two-factor authentication is not on")
+	ErrTwoFactorSetupMissing = errors.New("start two-factor setup first")
+	ErrTwoFactorMethod       = errors.New("unsupported two-factor method")
+	ErrInvalidTwoFactorCode  = errors.New("invalid or expired code")
+	ErrChallengeNotFound     = errors.New("invalid or expired sign-in challenge; sign in again")
+	ErrChallengeAttempts     = errors.New("too many wrong codes; sign in again")
+	ErrPhoneNotVerified      = errors.New("verify your phone number first")
+	ErrTwoFactorCodeTooSoon  = errors.New("a code was sent recently; wait before asking for another")
+	ErrStepUpSessionRequired = errors.New("sign in again to verify this session")
+	ErrStepUpLocked          = errors.New("too many wrong codes; try again later")
+)
+
+const (
+	totpDigits     = 6
+	totpPeriod     = 30 // seconds
+	totpSkewSteps  = 1  // codes from one step either side are accepted
+	backupCodeSize = 10
+)
+
+// TwoFactorService runs opt-in two-factor authentication: TOTP apps with
+// one-time backup codes, and SMS codes either as the main method or as a
+// fallback. It issues the second step of login and the step-up checks
+// sensitive actions require.
+type TwoFactorService struct {
+	sms             *SMSService
+	issuer          string
+	key             []byte
+	challengeTTL    time.Duration
+	codeTTL         time.Duration
+	resendAfter     time.Duration
+	maxAttempts     int
+	stepUpLockout   time.Duration
+	backupCodeCount int
+}
+
+// LoginChallenge is what a client gets when a password is right but a
+// second factor is still needed
+type LoginChallenge struct {
+	Token     string                   `json:"challenge_token"`
+	Method    models.TwoFactorMethod   `json:"method"`
+	Methods   []models.TwoFactorMethod `json:"methods"`
+	Phone     string                   `json:"phone,omitempty"`
+	ExpiresIn int                      `json:"expires_in"`
+}
+
+// TwoFactorStatus describes a user's two-factor settings
+type TwoFactorStatus struct {
+	Enabled         bool                     `json:"enabled"`
+	Method          models.TwoFactorMethod   `json:"method,omitempty"`
+	Methods         []models.TwoFactorMethod `json:"methods,omitempty"`
+	BackupCodesLeft int                      `json:"backup_codes_left"`
+	Phone           string                   `json:"phone,omitempty"`
+	SetupPending    bool                     `json:"setup_pending"`
+}
+
+// TOTPSetup is what an authenticator app needs to add an account. The URI
+// is shown as a QR code; the secret is for typing in by hand.
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func NewTwoFactorService(sms *SMSService) *TwoFactorService {
+	key := utils.GetEnv("TWO_FACTOR_ENCRYPTION_KEY", "")
+	if key == "" {
+		key = utils.GetEnv("JWT_SECRET", "your-secret-key")
+	}
+	sum := sha256.Sum256([]byte(key))
+
+	return &TwoFactorService{
+		sms:             sms,
+		issuer:          utils.GetEnv("TWO_FACTOR_ISSUER", "AutoBoy"),
+		key:             sum[:],
+		challengeTTL:    time.Duration(utils.GetEnvAsInt("TWO_FACTOR_CHALLENGE_MINUTES", 5)) * time.Minute,
+		codeTTL:         10 * time.Minute,
+		resendAfter:     time.Duration(utils.GetEnvAsInt("TWO_FACTOR_SMS_RESEND_SECONDS", 60)) * time.Second,
+		maxAttempts:     utils.GetEnvAsInt("TWO_FACTOR_MAX_ATTEMPTS", 5),
+		stepUpLockout:   time.Duration(utils.GetEnvAsInt("TWO_FACTOR_STEP_UP_LOCKOUT_MINUTES", 15)) * time.Minute,
+		backupCodeCount: utils.GetEnvAsInt("TWO_FACTOR_BACKUP_CODES", 10),
+	}
+}
+
+// Status reports the user's two-factor settings
+func (s *TwoFactorService) Status(user *models.User) TwoFactorStatus {
+	status := TwoFactorStatus{
+		Enabled:         user.TwoFactorEnabled,
+		BackupCodesLeft: len(user.BackupCodes),
+		SetupPending:    user.TOTPPendingSecret != "",
+	}
+	if user.TwoFactorEnabled {
+		status.Method = user.TwoFactorMethod
+		status.Methods = s.methods(user)
+		if user.IsPhoneVerified {
+			status.Phone = maskPhone(user.Phone)
+		}
+	}
+	return status
+}
+
+// SetupTOTP starts TOTP enrolment with a new secret. Nothing changes for the
+// user until EnableTOTP sees a code from it.
+func (s *TwoFactorService) SetupTOTP(ctx context.Context, user *models.User) (*TOTPSetup, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	sealed, err := s.seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"totp_pending_secret": sealed, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPSetup{Secret: secret, URI: s.provisioningURI(user, secret)}, nil
+}
+
+// EnableTOTP turns TOTP on once the user proves their app has the secret,
+// and returns their backup codes. They are only ever shown here.
+func (s *TwoFactorService) EnableTOTP(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, ErrTwoFactorSetupMissing
+	}
+	secret, err := s.open(user.TOTPPendingSecret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := matchTOTP(secret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := s.newBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	result, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "two_factor_enabled": bson.M{"$ne": true}},
+		bson.M{
+			"$set": bson.M{
+				"two_factor_enabled": true,
+				"two_factor_method":  models.TwoFactorTOTP,
+				"totp_secret":        user.TOTPPendingSecret,
+				"totp_last_step":     step,
+				"backup_codes":       hashes,
+				"updated_at":         time.Now(),
+			},
+			"$unset": bson.M{"totp_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	go s.alert(user, "two_factor_enabled")
+	return codes, nil
+}
+
+// SetupSMS texts a code to the user's verified phone so they can confirm it
+// before SMS two-factor is turned on
+func (s *TwoFactorService) SetupSMS(ctx context.Context, user *models.User) error {
+	if user.TwoFactorEnabled {
+		return ErrTwoFactorEnabled
+	}
+	if !user.IsPhoneVerified {
+		return ErrPhoneNotVerified
+	}
+	_, err := s.sendCode(ctx, user, models.TwoFactorPurposeEnrol, nil)
+	return err
+}
+
+// EnableSMS turns SMS two-factor on with the code SetupSMS sent, and
+// returns the user's backup codes
+func (s *TwoFactorService) EnableSMS(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if err := s.checkCode(ctx, user.ID, models.TwoFactorPurposeEnrol, nil, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := s.newBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	result, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "two_factor_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{
+			"two_factor_enabled": true,
+			"two_factor_method":  models.TwoFactorSMS,
+			"backup_codes":       hashes,
+			"updated_at":         time.Now(),
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	go s.alert(user, "two_factor_enabled")
+	return codes, nil
+}
+
+// Disable turns two-factor off after a final check of a current code
+func (s *TwoFactorService) Disable(ctx context.Context, user *models.User, method models.TwoFactorMethod, code string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := s.verify(ctx, user, method, code, models.TwoFactorPurposeStepUp, nil); err != nil {
+		return err
+	}
+
+	_, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{"two_factor_enabled": false, "updated_at": time.Now()},
+			"$unset": bson.M{
+				"two_factor_method":   "",
+				"totp_secret":         "",
+				"totp_pending_secret": "",
+				"totp_last_step":      "",
+				"backup_codes":        "",
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	go s.alert(user, "two_factor_disabled")
+	return nil
+}
+
+// RegenerateBackupCodes replaces the user's backup codes after checking a
+// current code, and returns the new ones
+func (s *TwoFactorService) RegenerateBackupCodes(ctx context.Context, user *models.User, method models.TwoFactorMethod, code string) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := s.verify(ctx, user, method, code, models.TwoFactorPurposeStepUp, nil); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := s.newBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"backup_codes": hashes, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// StartLogin opens the second step of signing in for a user whose password
+// was right. SMS users are sent their code straight away.
+func (s *TwoFactorService) StartLogin(ctx context.Context, user *models.User, ipAddress string) (*LoginChallenge, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	challenge := models.TwoFactorChallenge{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Purpose:   models.TwoFactorPurposeLogin,
+		TokenHash: hashSecret(token),
+		IPAddress: ipAddress,
+		ExpiresAt: now.Add(s.challengeTTL),
+		CreatedAt: now,
+	}
+	if _, err := config.Coll.TwoFactorChallenges.InsertOne(ctx, challenge); err != nil {
+		return nil, err
+	}
+
+	login := &LoginChallenge{
+		Token:     token,
+		Method:    user.TwoFactorMethod,
+		Methods:   s.methods(user),
+		ExpiresIn: int(s.challengeTTL.Seconds()),
+	}
+	if user.IsPhoneVerified {
+		login.Phone = maskPhone(user.Phone)
+	}
+	if user.TwoFactorMethod == models.TwoFactorSMS {
+		if err := s.textCode(ctx, user, &challenge); err != nil {
+			return nil, err
+		}
+	}
+	return login, nil
+}
+
+// SendLoginCode texts a code for a sign-in challenge, as a TOTP user's
+// fallback or to resend one
+func (s *TwoFactorService) SendLoginCode(ctx context.Context, token string) error {
+	challenge, user, err := s.loginChallenge(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !user.IsPhoneVerified {
+		return ErrPhoneNotVerified
+	}
+	return s.textCode(ctx, user, challenge)
+}
+
+// CompleteLogin checks the second factor for a sign-in challenge and
+// returns the user to sign in. A challenge works once and allows only a
+// few wrong codes.
+func (s *TwoFactorService) CompleteLogin(ctx context.Context, token string, method models.TwoFactorMethod, code string) (*models.User, error) {
+	challenge, user, err := s.loginChallenge(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verify(ctx, user, method, code, models.TwoFactorPurposeLogin, challenge); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result, err := config.Coll.TwoFactorChallenges.UpdateOne(ctx,
+		bson.M{"_id": challenge.ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrChallengeNotFound
+	}
+	return user, nil
+}
+
+// SendStepUpCode texts a code the user can use to verify their session
+func (s *TwoFactorService) SendStepUpCode(ctx context.Context, user *models.User, sessionID primitive.ObjectID) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if !user.IsPhoneVerified {
+		return ErrPhoneNotVerified
+	}
+	if sessionID.IsZero() {
+		return ErrStepUpSessionRequired
+	}
+	_, err := s.sendCode(ctx, user, models.TwoFactorPurposeStepUp, &sessionID)
+	return err
+}
+
+// StepUp checks a second factor for a signed-in session, letting it make
+// sensitive changes for a while. It returns when that ends.
+func (s *TwoFactorService) StepUp(ctx context.Context, user *models.User, sessionID primitive.ObjectID, method models.TwoFactorMethod, code string) (time.Time, error) {
+	if !user.TwoFactorEnabled {
+		return time.Time{}, ErrTwoFactorNotEnabled
+	}
+	if sessionID.IsZero() {
+		return time.Time{}, ErrStepUpSessionRequired
+	}
+	if err := s.verify(ctx, user, method, code, models.TwoFactorPurposeStepUp, &models.TwoFactorChallenge{SessionID: &sessionID}); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	result, err := config.Coll.UserSessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "user_id": user.ID, "is_active": true},
+		bson.M{"$set": bson.M{"step_up_at": now}},
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if result.MatchedCount == 0 {
+		return time.Time{}, ErrStepUpSessionRequired
+	}
+	return now.Add(StepUpWindow()), nil
+}
+
+// SendPhoneLoginCode texts a code for signing in without a password to
+// the user's verified phone
+func (s *TwoFactorService) SendPhoneLoginCode(ctx context.Context, user *models.User) error {
+	if !user.IsPhoneVerified {
+		return ErrPhoneNotVerified
+	}
+	_, err := s.sendCode(ctx, user, models.TwoFactorPurposePhone, nil)
+	return err
+}
+
+// CheckPhoneLoginCode uses a code SendPhoneLoginCode texted
+func (s *TwoFactorService) CheckPhoneLoginCode(ctx context.Context, user *models.User, code string) error {
+	return s.checkCode(ctx, user.ID, models.TwoFactorPurposePhone, nil, code)
+}
+
+// StepUpWindow is how long a session may make sensitive changes after its
+// last second-factor check
+func StepUpWindow() time.Duration {
+	return time.Duration(utils.GetEnvAsInt("TWO_FACTOR_STEP_UP_MINUTES", 10)) * time.Minute
+}
+
+// verify checks a code by method. Login SMS codes are held on the login
+// challenge, which also counts wrong codes; other SMS codes are found by
+// purpose and session. Step-up checks count wrong codes on the user.
+func (s *TwoFactorService) verify(ctx context.Context, user *models.User, method models.TwoFactorMethod, code string, purpose models.TwoFactorPurpose, challenge *models.TwoFactorChallenge) error {
+	if purpose == models.TwoFactorPurposeStepUp {
+		if err := s.takeStepUpAttempt(ctx, user); err != nil {
+			return err
+		}
+		err := s.check(ctx, user, method, code, purpose, challenge)
+		return s.settleStepUpAttempt(ctx, user, err)
+	}
+	return s.check(ctx, user, method, code, purpose, challenge)
+}
+
+// check verifies a code without counting step-up attempts
+func (s *TwoFactorService) check(ctx context.Context, user *models.User, method models.TwoFactorMethod, code string, purpose models.TwoFactorPurpose, challenge *models.TwoFactorChallenge) error {
+	code = strings.TrimSpace(code)
+	if method == "" {
+		method = user.TwoFactorMethod
+	}
+
+	var err error
+	switch method {
+	case models.TwoFactorTOTP:
+		if user.TOTPSecret == "" {
+			return ErrTwoFactorMethod
+		}
+		err = s.useTOTP(ctx, user, code)
+	case models.TwoFactorBackupCode:
+		err = s.useBackupCode(ctx, user, code)
+	case models.TwoFactorSMS:
+		if purpose == models.TwoFactorPurposeLogin {
+			if challenge.CodeHash == "" || challenge.CodeSentAt == nil || time.Since(*challenge.CodeSentAt) > s.codeTTL ||
+				subtle.ConstantTimeCompare([]byte(challenge.CodeHash), []byte(hashSecret(code))) != 1 {
+				err = ErrInvalidTwoFactorCode
+			}
+		} else {
+			var sessionID *primitive.ObjectID
+			if challenge != nil {
+				sessionID = challenge.SessionID
+			}
+			err = s.checkCode(ctx, user.ID, purpose, sessionID, code)
+		}
+	default:
+		return ErrTwoFactorMethod
+	}
+
+	if err == ErrInvalidTwoFactorCode && purpose == models.TwoFactorPurposeLogin {
+		if _, uerr := config.Coll.TwoFactorChallenges.UpdateOne(ctx,
+			bson.M{"_id": challenge.ID},
+			bson.M{"$inc": bson.M{"attempts": 1}},
+		); uerr != nil {
+			return uerr
+		}
+	}
+	return err
+}
+
+// takeStepUpAttempt counts a step-up check against the user before its code
+// is looked at, so guesses sent in parallel cannot get past the limit
+func (s *TwoFactorService) takeStepUpAttempt(ctx context.Context, user *models.User) error {
+	result, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{
+			"_id":              user.ID,
+			"step_up_failures": bson.M{"$not": bson.M{"$gte": s.maxAttempts}},
+			"$or": []bson.M{
+				{"step_up_locked_until": bson.M{"$exists": false}},
+				{"step_up_locked_until": bson.M{"$lte": time.Now()}},
+			},
+		},
+		bson.M{"$inc": bson.M{"step_up_failures": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrStepUpLocked
+	}
+	return nil
+}
+
+// settleStepUpAttempt clears the count after a right code and takes back
+// an attempt that failed for another reason. A wrong code stays counted,
+// and the one that reaches the limit locks step-up checks for a while.
+func (s *TwoFactorService) settleStepUpAttempt(ctx context.Context, user *models.User, err error) error {
+	switch {
+	case err == nil:
+		_, uerr := config.Coll.Users.UpdateOne(ctx,
+			bson.M{"_id": user.ID},
+			bson.M{"$unset": bson.M{"step_up_failures": "", "step_up_locked_until": ""}},
+		)
+		return uerr
+	case err != ErrInvalidTwoFactorCode:
+		if _, uerr := config.Coll.Users.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "step_up_failures": bson.M{"$gt": 0}},
+			bson.M{"$inc": bson.M{"step_up_failures": -1}},
+		); uerr != nil {
+			return uerr
+		}
+		return err
+	}
+
+	result, uerr := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "step_up_failures": bson.M{"$gte": s.maxAttempts}},
+		bson.M{
+			"$set":   bson.M{"step_up_locked_until": time.Now().Add(s.stepUpLockout)},
+			"$unset": bson.M{"step_up_failures": ""},
+		},
+	)
+	if uerr != nil {
+		return uerr
+	}
+	if result.ModifiedCount > 0 {
+		go s.alert(user, "two_factor_locked")
+		return ErrStepUpLocked
+	}
+	return err
+}
+
+// loginChallenge finds an open sign-in challenge and its user
+func (s *TwoFactorService) loginChallenge(ctx context.Context, token string) (*models.TwoFactorChallenge, *models.User, error) {
+	var challenge models.TwoFactorChallenge
+	err := config.Coll.TwoFactorChallenges.FindOne(ctx, bson.M{
+		"token_hash": hashSecret(token),
+		"purpose":    models.TwoFactorPurposeLogin,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if challenge.Attempts >= s.maxAttempts {
+		return nil, nil, ErrChallengeAttempts
+	}
+
+	var user models.User
+	err = config.Coll.Users.FindOne(ctx, bson.M{"_id": challenge.UserID, "status": models.UserStatusActive}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &challenge, &user, nil
+}
+
+// sendCode opens a challenge for the purpose and texts its code
+func (s *TwoFactorService) sendCode(ctx context.Context, user *models.User, purpose models.TwoFactorPurpose, sessionID *primitive.ObjectID) (*models.TwoFactorChallenge, error) {
+	filter := bson.M{"user_id": user.ID, "purpose": purpose, "code_sent_at": bson.M{"$gt": time.Now().Add(-s.resendAfter)}}
+	if sessionID != nil {
+		filter["session_id"] = *sessionID
+	}
+	recent, err := config.Coll.TwoFactorChallenges.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if recent > 0 {
+		return nil, ErrTwoFactorCodeTooSoon
+	}
+
+	now := time.Now()
+	challenge := &models.TwoFactorChallenge{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		SessionID: sessionID,
+		ExpiresAt: now.Add(s.codeTTL),
+		CreatedAt: now,
+	}
+	if _, err := config.Coll.TwoFactorChallenges.InsertOne(ctx, challenge); err != nil {
+		return nil, err
+	}
+	return challenge, s.textCode(ctx, user, challenge)
+}
+
+// textCode puts a new code on the challenge and texts it
+func (s *TwoFactorService) textCode(ctx context.Context, user *models.User, challenge *models.TwoFactorChallenge) error {
+	if challenge.CodeSentAt != nil && time.Since(*challenge.CodeSentAt) < s.resendAfter {
+		return ErrTwoFactorCodeTooSoon
+	}
+
+	code := utils.GenerateOTP()
+	now := time.Now()
+	_, err := config.Coll.TwoFactorChallenges.UpdateOne(ctx,
+		bson.M{"_id": challenge.ID},
+		bson.M{"$set": bson.M{"code_hash": hashSecret(code), "code_sent_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	challenge.CodeSentAt = &now
+	return s.sms.SendOTP(user.Phone, code)
+}
+
+// checkCode uses the newest open code texted for the purpose
+func (s *TwoFactorService) checkCode(ctx context.Context, userID primitive.ObjectID, purpose models.TwoFactorPurpose, sessionID *primitive.ObjectID, code string) error {
+	filter := bson.M{
+		"user_id":    userID,
+		"purpose":    purpose,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	if sessionID != nil {
+		filter["session_id"] = *sessionID
+	}
+
+	var challenge models.TwoFactorChallenge
+	err := config.Coll.TwoFactorChallenges.FindOne(ctx, filter,
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return err
+	}
+	if challenge.Attempts >= s.maxAttempts {
+		return ErrInvalidTwoFactorCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(challenge.CodeHash), []byte(hashSecret(strings.TrimSpace(code)))) != 1 {
+		_, err := config.Coll.TwoFactorChallenges.UpdateOne(ctx,
+			bson.M{"_id": challenge.ID},
+			bson.M{"$inc": bson.M{"attempts": 1}},
+		)
+		if err != nil {
+			return err
+		}
+		return ErrInvalidTwoFactorCode
+	}
+
+	result, err := config.Coll.TwoFactorChallenges.UpdateOne(ctx,
+		bson.M{"_id": challenge.ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// useTOTP accepts a code from the user's app once; recording the step it
+// matched stops the same code, or an older one, working again
+func (s *TwoFactorService) useTOTP(ctx context.Context, user *models.User, code string) error {
+	secret, err := s.open(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTP(secret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	result, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "$or": []bson.M{
+			{"totp_last_step": bson.M{"$lt": step}},
+			{"totp_last_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+// useBackupCode spends one of the user's backup codes
+func (s *TwoFactorService) useBackupCode(ctx context.Context, user *models.User, code string) error {
+	hash := hashSecret(normalizeBackupCode(code))
+	result, err := config.Coll.Users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "backup_codes": hash},
+		bson.M{"$pull": bson.M{"backup_codes": hash}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// methods lists the ways the user can pass a second-factor check
+func (s *TwoFactorService) methods(user *models.User) []models.TwoFactorMethod {
+	var methods []models.TwoFactorMethod
+	if user.TOTPSecret != "" {
+		methods = append(methods, models.TwoFactorTOTP)
+	}
+	if user.IsPhoneVerified {
+		methods = append(methods, models.TwoFactorSMS)
+	}
+	if len(user.BackupCodes) > 0 {
+		methods = append(methods, models.TwoFactorBackupCode)
+	}
+	return methods
+}
+
+func (s *TwoFactorService) provisioningURI(user *models.User, secret string) string {
+	label := url.PathEscape(s.issuer + ":" + user.Email)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// newBackupCodes returns fresh backup codes and the hashes to store
+func (s *TwoFactorService) newBackupCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, s.backupCodeCount)
+	hashes := make([]string, s.backupCodeCount)
+	for i := range codes {
+		raw := make([]byte, backupCodeSize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for j, r := range raw {
+			if j == backupCodeSize/2 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(alphabet[int(r)%len(alphabet)])
+		}
+		codes[i] = b.String()
+		hashes[i] = hashSecret(normalizeBackupCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+func (s *TwoFactorService) alert(user *models.User, alertType string) {
+	if user.IsPhoneVerified {
+		s.sms.SendSecurityAlert(user.Phone, alertType)
+	}
+}
+
+// seal encrypts a TOTP secret for storage
+func (s *TwoFactorService) seal(plaintext string) (string, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// open decrypts a sealed TOTP secret
+func (s *TwoFactorService) open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("sealed secret is too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// matchTOTP finds the time step a code belongs to, within the allowed clock
+// skew and after the last step used (RFC 6238)
+func matchTOTP(secret, code string, at time.Time, lastStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := at.Unix() / totpPeriod
+	for step := current - totpSkewSteps; step <= current+totpSkewSteps; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp is the RFC 4226 one-time password for a counter
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecret(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskPhone shows only the last digits of a phone number
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return phone
+	}
+	return strings.Repeat("*", len(phone)-4) + phone[len(phone)-4:]
+}
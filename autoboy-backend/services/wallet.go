@@ -0,0 +1,449 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInsufficientBalance is returned when a debit would take a wallet below zero
+var ErrInsufficientBalance = errors.New("insufficient wallet balance")
+
+// ErrWalletCurrency is returned for money in a currency other than the wallet's
+var ErrWalletCurrency = errors.New("currency does not match the wallet's currency")
+
+var errNoCounterparty = errors.New("wallet entry has no ledger counterparty")
+
+// WalletEntry describes a single wallet movement to be recorded. Counterparty
+// is the ledger account on the other side of the movement: where credited
+// funds come from, or where debited funds go.
+type WalletEntry struct {
+	Type          models.WalletTransactionType
+	Counterparty  ledger.Account
+	Amount        float64
+	Currency      string
+	ReferenceType string
+	ReferenceID   *primitive.ObjectID
+	PaymentID     *primitive.ObjectID
+	Description   string
+	Metadata      map[string]interface{}
+}
+
+type WalletService struct{}
+
+func NewWalletService() *WalletService {
+	return &WalletService{}
+}
+
+// GetOrCreateWallet returns the user's wallet, creating an empty one if needed
+func (s *WalletService) GetOrCreateWallet(ctx context.Context, userID primitive.ObjectID) (*models.Wallet, error) {
+	now := time.Now()
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"user_id":      userID,
+			"balance":      0.0,
+			"held_balance": 0.0,
+			"currency":     "NGN",
+			"created_at":   now,
+			"updated_at":   now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var wallet models.Wallet
+	if err := config.Coll.Wallets.FindOneAndUpdate(ctx, bson.M{"user_id": userID}, update, opts).Decode(&wallet); err != nil {
+		return nil, fmt.Errorf("failed to load wallet: %w", err)
+	}
+	return &wallet, nil
+}
+
+// Credit adds funds to the user's wallet and records the movement.
+// Pass a mongo.SessionContext to make it part of a larger transaction.
+func (s *WalletService) Credit(ctx context.Context, userID primitive.ObjectID, entry WalletEntry) (*models.WalletTransaction, error) {
+	if entry.Amount <= 0 {
+		return nil, fmt.Errorf("credit amount must be positive")
+	}
+	if entry.Counterparty == "" {
+		return nil, errNoCounterparty
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$inc": bson.M{"balance": entry.Amount},
+		"$set": bson.M{"updated_at": now},
+		"$setOnInsert": bson.M{
+			"user_id":      userID,
+			"held_balance": 0.0,
+			"currency":     currencyOrDefault(entry.Currency),
+			"created_at":   now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var wallet models.Wallet
+	if err := config.Coll.Wallets.FindOneAndUpdate(ctx, bson.M{"user_id": userID}, update, opts).Decode(&wallet); err != nil {
+		return nil, fmt.Errorf("failed to credit wallet: %w", err)
+	}
+
+	if err := s.post(ctx, entry, ledger.Debit(entry.Counterparty, entry.Amount), ledger.Credit(ledger.WalletAccount(userID), entry.Amount)); err != nil {
+		return nil, err
+	}
+	return s.insertTransaction(ctx, &wallet, entry, wallet.Balance-entry.Amount, wallet.Balance, models.WalletTransactionStatusCompleted)
+}
+
+// Debit removes funds from the user's wallet. The balance never goes negative;
+// ErrInsufficientBalance is returned instead.
+func (s *WalletService) Debit(ctx context.Context, userID primitive.ObjectID, entry WalletEntry) (*models.WalletTransaction, error) {
+	if entry.Amount <= 0 {
+		return nil, fmt.Errorf("debit amount must be positive")
+	}
+	if entry.Counterparty == "" {
+		return nil, errNoCounterparty
+	}
+
+	filter := bson.M{
+		"user_id": userID,
+		"balance": bson.M{"$gte": entry.Amount},
+	}
+	update := bson.M{
+		"$inc": bson.M{"balance": -entry.Amount},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var wallet models.Wallet
+	err := config.Coll.Wallets.FindOneAndUpdate(ctx, filter, update, opts).Decode(&wallet)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInsufficientBalance
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to debit wallet: %w", err)
+	}
+
+	if err := s.post(ctx, entry, ledger.Debit(ledger.WalletAccount(userID), entry.Amount), ledger.Credit(entry.Counterparty, entry.Amount)); err != nil {
+		return nil, err
+	}
+	return s.insertTransaction(ctx, &wallet, entry, wallet.Balance+entry.Amount, wallet.Balance, models.WalletTransactionStatusCompleted)
+}
+
+// Hold moves funds from the available balance to held_balance, e.g. while a
+// withdrawal is pending. Like Debit, it fails with ErrInsufficientBalance
+// rather than letting the balance go negative.
+func (s *WalletService) Hold(ctx context.Context, userID primitive.ObjectID, entry WalletEntry) (*models.WalletTransaction, error) {
+	if entry.Amount <= 0 {
+		return nil, fmt.Errorf("hold amount must be positive")
+	}
+
+	filter := bson.M{
+		"user_id": userID,
+		"balance": bson.M{"$gte": entry.Amount},
+	}
+	update := bson.M{
+		"$inc": bson.M{"balance": -entry.Amount, "held_balance": entry.Amount},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var wallet models.Wallet
+	err := config.Coll.Wallets.FindOneAndUpdate(ctx, filter, update, opts).Decode(&wallet)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInsufficientBalance
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to hold wallet funds: %w", err)
+	}
+
+	if err := s.post(ctx, entry, ledger.Debit(ledger.WalletAccount(userID), entry.Amount), ledger.Credit(ledger.HeldAccount(userID), entry.Amount)); err != nil {
+		return nil, err
+	}
+	return s.insertTransaction(ctx, &wallet, entry, wallet.Balance+entry.Amount, wallet.Balance, models.WalletTransactionStatusPending)
+}
+
+// ReleaseHold returns held funds to the available balance, e.g. when a
+// withdrawal is rejected or its transfer fails. The pending hold
+// transaction for the reference is cancelled rather than offset by a new
+// credit, so completed wallet transactions still sum to the balance.
+func (s *WalletService) ReleaseHold(ctx context.Context, userID primitive.ObjectID, entry WalletEntry) error {
+	if entry.ReferenceID == nil {
+		return fmt.Errorf("held funds must be released against a reference")
+	}
+	if _, err := s.moveHeld(ctx, userID, entry.Amount, entry.Amount); err != nil {
+		return err
+	}
+
+	if err := s.post(ctx, entry, ledger.Debit(ledger.HeldAccount(userID), entry.Amount), ledger.Credit(ledger.WalletAccount(userID), entry.Amount)); err != nil {
+		return err
+	}
+	return s.SettlePending(ctx, entry.ReferenceType, *entry.ReferenceID, models.WalletTransactionStatusCancelled)
+}
+
+// SettleHold pays held funds out to the counterparty, e.g. when a withdrawal
+// transfer completes, and completes the pending hold transaction
+func (s *WalletService) SettleHold(ctx context.Context, userID primitive.ObjectID, entry WalletEntry) error {
+	if entry.Counterparty == "" {
+		return errNoCounterparty
+	}
+	if entry.ReferenceID == nil {
+		return fmt.Errorf("held funds must be settled against a reference")
+	}
+	if _, err := s.moveHeld(ctx, userID, entry.Amount, 0); err != nil {
+		return err
+	}
+
+	if err := s.post(ctx, entry, ledger.Debit(ledger.HeldAccount(userID), entry.Amount), ledger.Credit(entry.Counterparty, entry.Amount)); err != nil {
+		return err
+	}
+	return s.SettlePending(ctx, entry.ReferenceType, *entry.ReferenceID, models.WalletTransactionStatusCompleted)
+}
+
+// moveHeld takes amount out of held_balance, adding toBalance back to the
+// available balance
+func (s *WalletService) moveHeld(ctx context.Context, userID primitive.ObjectID, amount, toBalance float64) (*models.Wallet, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("held amount must be positive")
+	}
+
+	filter := bson.M{
+		"user_id":      userID,
+		"held_balance": bson.M{"$gte": amount},
+	}
+	update := bson.M{
+		"$inc": bson.M{"held_balance": -amount, "balance": toBalance},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var wallet models.Wallet
+	err := config.Coll.Wallets.FindOneAndUpdate(ctx, filter, update, opts).Decode(&wallet)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInsufficientBalance
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update held funds: %w", err)
+	}
+	return &wallet, nil
+}
+
+// RecordPending records a movement that does not touch the balance yet,
+// e.g. seller earnings still held in escrow.
+func (s *WalletService) RecordPending(ctx context.Context, userID primitive.ObjectID, entry WalletEntry) (*models.WalletTransaction, error) {
+	wallet, err := s.GetOrCreateWallet(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.insertTransaction(ctx, wallet, entry, wallet.Balance, wallet.Balance, models.WalletTransactionStatusPending)
+}
+
+// SettlePending marks pending transactions for a reference as completed or cancelled
+func (s *WalletService) SettlePending(ctx context.Context, referenceType string, referenceID primitive.ObjectID, status models.WalletTransactionStatus) error {
+	now := time.Now()
+	_, err := config.Coll.WalletTransactions.UpdateMany(ctx, bson.M{
+		"reference_type": referenceType,
+		"reference_id":   referenceID,
+		"status":         models.WalletTransactionStatusPending,
+	}, bson.M{"$set": bson.M{
+		"status":       status,
+		"processed_at": now,
+	}})
+	return err
+}
+
+// CreditTopup credits a paid top-up payment to its owner's wallet. Calling it
+// again for the same payment (webhook and verify both arriving) is a no-op.
+func (s *WalletService) CreditTopup(ctx context.Context, payment *models.Payment) (*models.WalletTransaction, error) {
+	if payment.PaymentType != models.PaymentTypeTopup {
+		return nil, fmt.Errorf("payment %s is not a wallet top-up", payment.PaymentNumber)
+	}
+	if payment.Status != models.PaymentStatusPaid {
+		return nil, fmt.Errorf("top-up %s has not been paid", payment.PaymentNumber)
+	}
+	wallet, err := s.GetOrCreateWallet(ctx, payment.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Wallets hold one currency and nothing converts between them
+	if !currencyMatches(payment.Currency, wallet.Currency) {
+		return nil, fmt.Errorf("%w: top-up %s is in %s", ErrWalletCurrency, payment.PaymentNumber, payment.Currency)
+	}
+
+	var txn *models.WalletTransaction
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		txn = nil
+
+		var existing models.WalletTransaction
+		err := config.Coll.WalletTransactions.FindOne(sc, bson.M{
+			"payment_id": payment.ID,
+			"type":       models.WalletTransactionTopup,
+		}).Decode(&existing)
+		if err == nil {
+			txn = &existing
+			return nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		// The gateway fee is absorbed by the platform; the user gets what they paid
+		txn, err = s.Credit(sc, payment.UserID, WalletEntry{
+			Type:          models.WalletTransactionTopup,
+			Counterparty:  ledger.AccountGatewayClearing,
+			Amount:        payment.Amount,
+			Currency:      payment.Currency,
+			ReferenceType: "payment",
+			ReferenceID:   &payment.ID,
+			PaymentID:     &payment.ID,
+			Description:   fmt.Sprintf("Wallet top-up via %s", payment.PaymentGateway),
+			Metadata: map[string]interface{}{
+				"gateway_reference": payment.GatewayReference,
+				"gateway_fee":       payment.GatewayFee,
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to credit top-up: %w", err)
+	}
+	return txn, nil
+}
+
+// PayCheckout inserts a checkout and its orders and pays for all of them
+// from the buyer's wallet in the caller's transaction, so no order is
+// created unpaid and the wallet is never debited without its orders. The
+// returned payment is the wallet payment record to open each order's escrow
+// against. The orders keep their pending status for the caller to confirm.
+func (s *WalletService) PayCheckout(sc mongo.SessionContext, checkout *models.Checkout, orders []*models.Order) (*models.Payment, error) {
+	if checkout.TotalAmount <= 0 {
+		return nil, fmt.Errorf("checkout total must be positive")
+	}
+	wallet, err := s.GetOrCreateWallet(sc, checkout.BuyerID)
+	if err != nil {
+		return nil, err
+	}
+	if !currencyMatches(checkout.Currency, wallet.Currency) {
+		return nil, fmt.Errorf("%w: checkout %s is in %s", ErrWalletCurrency, checkout.CheckoutNumber, checkout.Currency)
+	}
+
+	now := time.Now()
+	checkoutID := checkout.ID
+	payment := &models.Payment{
+		ID:             primitive.NewObjectID(),
+		PaymentNumber:  utils.GeneratePaymentNumber(),
+		UserID:         checkout.BuyerID,
+		CheckoutID:     &checkoutID,
+		Amount:         checkout.TotalAmount,
+		Currency:       currencyOrDefault(checkout.Currency),
+		PaymentType:    models.PaymentTypeOrder,
+		PaymentMethod:  models.PaymentMethodWallet,
+		PaymentGateway: models.PaymentGatewayWallet,
+		Status:         models.PaymentStatusPaid,
+		NetAmount:      checkout.TotalAmount,
+		IsVerified:     true,
+		InitiatedAt:    now,
+		ConfirmedAt:    &now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	payment.GatewayReference = payment.PaymentNumber
+	if len(orders) == 1 {
+		payment.OrderID = &orders[0].ID
+	}
+
+	if _, err := s.Debit(sc, checkout.BuyerID, WalletEntry{
+		Type:          models.WalletTransactionDebit,
+		Counterparty:  ledger.AccountEscrow,
+		Amount:        checkout.TotalAmount,
+		Currency:      checkout.Currency,
+		ReferenceType: "checkout",
+		ReferenceID:   &checkoutID,
+		PaymentID:     &payment.ID,
+		Description:   fmt.Sprintf("Payment for checkout %s", checkout.CheckoutNumber),
+	}); err != nil {
+		return nil, err
+	}
+
+	if _, err := config.Coll.Payments.InsertOne(sc, payment); err != nil {
+		return nil, err
+	}
+
+	checkout.PaymentID = &payment.ID
+	checkout.PaymentReference = payment.GatewayReference
+	checkout.PaymentStatus = models.PaymentStatusPaid
+	checkout.PaidAt = &now
+	if _, err := config.Coll.Checkouts.InsertOne(sc, checkout); err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		order.PaymentID = &payment.ID
+		order.PaymentReference = payment.GatewayReference
+		order.PaymentStatus = models.PaymentStatusPaid
+		order.PaidAt = &now
+		if _, err := config.Coll.Orders.InsertOne(sc, order); err != nil {
+			return nil, err
+		}
+	}
+	return payment, nil
+}
+
+// post writes the ledger entry for a wallet movement in the caller's session
+func (s *WalletService) post(ctx context.Context, entry WalletEntry, lines ...ledger.Line) error {
+	_, err := ledger.Post(ctx, ledger.Entry{
+		Description:   entry.Description,
+		Currency:      currencyOrDefault(entry.Currency),
+		ReferenceType: entry.ReferenceType,
+		ReferenceID:   entry.ReferenceID,
+		Lines:         lines,
+		Metadata: map[string]interface{}{
+			"wallet_transaction_type": string(entry.Type),
+		},
+	})
+	return err
+}
+
+func (s *WalletService) insertTransaction(ctx context.Context, wallet *models.Wallet, entry WalletEntry, before, after float64, status models.WalletTransactionStatus) (*models.WalletTransaction, error) {
+	now := time.Now()
+	txn := &models.WalletTransaction{
+		ID:                primitive.NewObjectID(),
+		TransactionNumber: utils.GenerateTransactionNumber(),
+		UserID:            wallet.UserID,
+		WalletID:          wallet.ID,
+		Type:              entry.Type,
+		Amount:            entry.Amount,
+		Currency:          currencyOrDefault(entry.Currency),
+		BalanceBefore:     utils.RoundCurrency(before),
+		BalanceAfter:      utils.RoundCurrency(after),
+		ReferenceType:     entry.ReferenceType,
+		ReferenceID:       entry.ReferenceID,
+		PaymentID:         entry.PaymentID,
+		Description:       entry.Description,
+		Metadata:          entry.Metadata,
+		Status:            status,
+		CreatedAt:         now,
+	}
+	if status == models.WalletTransactionStatusCompleted {
+		txn.ProcessedAt = &now
+	}
+
+	if _, err := config.Coll.WalletTransactions.InsertOne(ctx, txn); err != nil {
+		return nil, fmt.Errorf("failed to record wallet transaction: %w", err)
+	}
+	return txn, nil
+}
+
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return "NGN"
+	}
+	return currency
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrDuplicateWebhook = errors.New("webhook event already received")
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrWebhookNotFailed = errors.New("only failed webhooks, or pending ones stuck past the processing timeout, can be retried")
+)
+
+// maxWebhookSweepAttempts stops the sweep retrying an event that keeps
+// crashing mid-dispatch; an admin can still retry it by hand
+const maxWebhookSweepAttempts = 5
+
+// WebhookEventHandler processes one parsed gateway event
+type WebhookEventHandler func(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error
+
+// WebhookService stores incoming gateway events and dispatches them to
+// registered handlers. Every event is saved before processing so failures
+// can be retried.
+type WebhookService struct {
+	payments    *PaymentService
+	wallet      *WalletService
+	escrow      *EscrowService
+	withdrawals *WithdrawalService
+	checkouts   *CheckoutService
+	inventory   *InventoryService
+	refunds     *RefundService
+	handlers    map[WebhookEventKind]WebhookEventHandler
+
+	// pendingTimeout is how long an event may stay pending before it is
+	// taken to have been abandoned mid-dispatch and is processed again
+	pendingTimeout time.Duration
+}
+
+func NewWebhookService(payments *PaymentService, wallet *WalletService, escrow *EscrowService, withdrawals *WithdrawalService, checkouts *CheckoutService, inventory *InventoryService, refunds *RefundService) *WebhookService {
+	s := &WebhookService{
+		payments:    payments,
+		wallet:      wallet,
+		escrow:      escrow,
+		withdrawals: withdrawals,
+		checkouts:   checkouts,
+		inventory:   inventory,
+		refunds:     refunds,
+		handlers:    make(map[WebhookEventKind]WebhookEventHandler),
+
+		pendingTimeout: time.Duration(utils.GetEnvAsInt("WEBHOOK_PENDING_TIMEOUT_MINUTES", 10)) * time.Minute,
+	}
+	s.registerDefaultHandlers()
+	return s
+}
+
+// Register adds a handler for an event kind, replacing any existing one
+func (s *WebhookService) Register(kind WebhookEventKind, fn WebhookEventHandler) {
+	s.handlers[kind] = fn
+}
+
+// Receive verifies, records and processes a raw gateway event. Duplicate
+// events return ErrDuplicateWebhook without being processed again, unless
+// the stored copy failed or was abandoned while pending, in which case the
+// gateway's redelivery processes it again.
+func (s *WebhookService) Receive(ctx context.Context, gatewayName models.PaymentGateway, headers http.Header, body []byte, ip string) (*models.PaymentWebhook, error) {
+	gateway, err := s.payments.Gateway(gatewayName)
+	if err != nil {
+		return nil, err
+	}
+	if !gateway.VerifyWebhook(headers, body) {
+		return nil, ErrInvalidSignature
+	}
+
+	event, err := gateway.ParseWebhook(body)
+	if err != nil {
+		return nil, err
+	}
+	if event.Event == "" {
+		return nil, fmt.Errorf("webhook event type missing")
+	}
+
+	now := time.Now()
+	webhook := &models.PaymentWebhook{
+		ID:          primitive.NewObjectID(),
+		Gateway:     gatewayName,
+		Event:       event.Event,
+		EventID:     event.EventID,
+		RawPayload:  event.Payload,
+		Status:      models.WebhookStatusPending,
+		Headers:     flattenHeaders(headers),
+		IPAddress:   ip,
+		AttemptedAt: &now,
+		CreatedAt:   now,
+	}
+
+	if _, err := config.Coll.PaymentWebhooks.InsertOne(ctx, webhook); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to store webhook: %w", err)
+		}
+		stored, claimErr := s.claim(ctx, bson.M{"gateway": gatewayName, "event_id": event.EventID})
+		if claimErr == mongo.ErrNoDocuments {
+			return nil, ErrDuplicateWebhook
+		}
+		if claimErr != nil {
+			return nil, claimErr
+		}
+		return stored, s.dispatch(ctx, stored, event)
+	}
+
+	return webhook, s.dispatch(ctx, webhook, event)
+}
+
+// Process re-parses a stored webhook and dispatches it
+func (s *WebhookService) Process(ctx context.Context, webhook *models.PaymentWebhook) error {
+	gateway, err := s.payments.Gateway(webhook.Gateway)
+	if err != nil {
+		return err
+	}
+
+	// Relaxed extended JSON turns the stored BSON back into plain JSON
+	body, err := bson.MarshalExtJSON(webhook.RawPayload, false, false)
+	if err != nil {
+		return err
+	}
+	event, err := gateway.ParseWebhook(body)
+	if err != nil {
+		return err
+	}
+
+	return s.dispatch(ctx, webhook, event)
+}
+
+// Retry processes a failed webhook, or one stuck pending, again
+func (s *WebhookService) Retry(ctx context.Context, webhookID primitive.ObjectID) (*models.PaymentWebhook, error) {
+	webhook, err := s.claim(ctx, bson.M{"_id": webhookID})
+	if err == mongo.ErrNoDocuments {
+		count, countErr := config.Coll.PaymentWebhooks.CountDocuments(ctx, bson.M{"_id": webhookID})
+		if countErr != nil {
+			return nil, countErr
+		}
+		if count == 0 {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, ErrWebhookNotFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return webhook, s.Process(ctx, webhook)
+}
+
+// ProcessStalePending processes events left pending past the timeout, as
+// happens when the server stops mid-dispatch, and returns how many were
+// processed and how many failed
+func (s *WebhookService) ProcessStalePending(ctx context.Context) (int, int, error) {
+	filter := s.stalePendingFilter()
+	filter["retry_count"] = bson.M{"$lt": maxWebhookSweepAttempts}
+
+	cursor, err := config.Coll.PaymentWebhooks.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(100).
+		SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, 0, err
+	}
+	var stale []models.PaymentWebhook
+	if err := cursor.All(ctx, &stale); err != nil {
+		return 0, 0, err
+	}
+
+	processed, failed := 0, 0
+	for _, w := range stale {
+		webhook, err := s.claim(ctx, bson.M{"_id": w.ID})
+		if err == mongo.ErrNoDocuments {
+			continue // taken by another worker or a redelivery
+		}
+		if err != nil {
+			return processed, failed, err
+		}
+		if err := s.Process(ctx, webhook); err != nil {
+			log.Printf("Stale %s webhook %s (%s) failed: %v", webhook.Gateway, webhook.EventID, webhook.Event, err)
+			failed++
+			continue
+		}
+		processed++
+	}
+	return processed, failed, nil
+}
+
+// claim atomically takes a failed or abandoned pending webhook for another
+// attempt, so two workers never process it at once. It returns
+// mongo.ErrNoDocuments when nothing matching may be retried.
+func (s *WebhookService) claim(ctx context.Context, filter bson.M) (*models.PaymentWebhook, error) {
+	query := bson.M{"$or": bson.A{
+		bson.M{"status": models.WebhookStatusFailed},
+		s.stalePendingFilter(),
+	}}
+	for k, v := range filter {
+		query[k] = v
+	}
+
+	var webhook models.PaymentWebhook
+	err := config.Coll.PaymentWebhooks.FindOneAndUpdate(ctx, query,
+		bson.M{
+			"$set": bson.M{"status": models.WebhookStatusPending, "attempted_at": time.Now()},
+			"$inc": bson.M{"retry_count": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&webhook)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// stalePendingFilter matches events whose processing started longer ago
+// than the pending timeout without finishing
+func (s *WebhookService) stalePendingFilter() bson.M {
+	cutoff := time.Now().Add(-s.pendingTimeout)
+	return bson.M{
+		"status": models.WebhookStatusPending,
+		"$or": bson.A{
+			bson.M{"attempted_at": bson.M{"$lte": cutoff}},
+			bson.M{"attempted_at": bson.M{"$exists": false}, "created_at": bson.M{"$lte": cutoff}},
+		},
+	}
+}
+
+// dispatch runs the handler for the event kind and records the outcome
+func (s *WebhookService) dispatch(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	handler, ok := s.handlers[event.Kind]
+	now := time.Now()
+
+	if !ok {
+		webhook.Status = models.WebhookStatusIgnored
+		_, err := config.Coll.PaymentWebhooks.UpdateOne(ctx, bson.M{"_id": webhook.ID}, bson.M{"$set": bson.M{
+			"status":       webhook.Status,
+			"processed_at": now,
+		}})
+		return err
+	}
+
+	handlerErr := handler(ctx, webhook, event)
+
+	set := bson.M{"processed_at": now}
+	if handlerErr != nil {
+		webhook.Status = models.WebhookStatusFailed
+		set["failure_reason"] = handlerErr.Error()
+	} else {
+		webhook.Status = models.WebhookStatusProcessed
+		set["failure_reason"] = ""
+	}
+	set["status"] = webhook.Status
+	if webhook.PaymentID != nil {
+		set["payment_id"] = webhook.PaymentID
+	}
+	if webhook.ProcessedData != nil {
+		set["processed_data"] = webhook.ProcessedData
+	}
+
+	if _, err := config.Coll.PaymentWebhooks.UpdateOne(ctx, bson.M{"_id": webhook.ID}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+	return handlerErr
+}
+
+// webhookEventID derives a stable id for deduplication from the event type
+// and the gateway object id, falling back to a hash of the body
+func webhookEventID(event string, data map[string]interface{}, body []byte) string {
+	for _, key := range []string{"id", "reference", "subscription_code"} {
+		if v := mapID(data, key); v != "" {
+			return event + ":" + v
+		}
+	}
+	sum := sha256.Sum256(body)
+	return event + ":" + hex.EncodeToString(sum[:])
+}
+
+// flattenHeaders keeps the headers worth storing with a webhook
+func flattenHeaders(headers http.Header) map[string]string {
+	flat := make(map[string]string)
+	for _, key := range []string{"User-Agent", "Content-Type", "X-Paystack-Signature", "Verif-Hash", "Stripe-Signature", "X-Fake-Signature"} {
+		if v := headers.Get(key); v != "" {
+			flat[key] = v
+		}
+	}
+	return flat
+}
+
+func mapString(m map[string]interface{}, key string) string {
+	if v, ok := m[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// mapID returns an identifier field as a string; numeric JSON ids are
+// formatted without exponent notation
+func mapID(m map[string]interface{}, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int32, int64, int:
+		return fmt.Sprintf("%d", v)
+	}
+	return ""
+}
+
+func mapFloat(m map[string]interface{}, key string) float64 {
+	switch v := m[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+func mapMap(m map[string]interface{}, key string) map[string]interface{} {
+	switch v := m[key].(type) {
+	case map[string]interface{}:
+		return v
+	case primitive.M:
+		return v
+	case primitive.D:
+		return v.Map()
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *WebhookService) registerDefaultHandlers() {
+	s.Register(WebhookChargeSuccess, s.handleChargeSuccess)
+	s.Register(WebhookChargeFailed, s.handleChargeFailed)
+	s.Register(WebhookRefundProcessed, s.handleRefundProcessed)
+	s.Register(WebhookTransferSuccess, s.handleTransferSuccess)
+	s.Register(WebhookTransferFailed, s.handleTransferFailed)
+	s.Register(WebhookSubscriptionCreate, s.handleSubscriptionCreate)
+	s.Register(WebhookSubscriptionDisable, s.handleSubscriptionDisable)
+}
+
+// findEventPayment loads the payment an event refers to, by our reference
+// or by the gateway's own id
+func findEventPayment(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) (*models.Payment, error) {
+	filters := []bson.M{}
+	if event.Reference != "" {
+		filters = append(filters, bson.M{"gateway_reference": event.Reference})
+	}
+	if event.GatewayID != "" {
+		filters = append(filters, bson.M{"payment_gateway": webhook.Gateway, "gateway_payment_id": event.GatewayID})
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("event carries no payment reference")
+	}
+
+	var payment models.Payment
+	err := config.Coll.Payments.FindOne(ctx, bson.M{"$or": filters}).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// handleChargeSuccess confirms the payment and settles whatever it paid for
+func (s *WebhookService) handleChargeSuccess(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	payment, err := findEventPayment(ctx, webhook, event)
+	if err == ErrPaymentNotFound {
+		payment, err = s.recordLegacyOrderPayment(ctx, webhook, event)
+	}
+	if err != nil {
+		return err
+	}
+	if payment == nil {
+		webhook.ProcessedData = map[string]interface{}{"skipped": "no payment for reference"}
+		return nil
+	}
+	webhook.PaymentID = &payment.ID
+
+	if !currencyMatches(event.Currency, payment.Currency) {
+		return fmt.Errorf("paid in %s but the payment is in %s", event.Currency, currencyOrDefault(payment.Currency))
+	}
+	if utils.RoundCurrency(event.Amount) < utils.RoundCurrency(payment.Amount) {
+		return fmt.Errorf("paid amount %.2f is less than expected %.2f", event.Amount, payment.Amount)
+	}
+
+	now := time.Now()
+	fee := event.Fee
+	if fee == 0 {
+		fee = payment.GatewayFee
+	}
+	set := bson.M{
+		"status":            models.PaymentStatusPaid,
+		"gateway_fee":       fee,
+		"net_amount":        utils.RoundCurrency(event.Amount - fee - payment.PlatformFee),
+		"is_verified":       true,
+		"webhook_processed": true,
+		"updated_at":        now,
+	}
+	if event.GatewayID != "" {
+		set["gateway_payment_id"] = event.GatewayID
+	}
+	if event.Channel != "" {
+		set["payment_method"] = models.PaymentMethod(event.Channel)
+	}
+	if payment.ConfirmedAt == nil {
+		set["confirmed_at"] = now
+	}
+	if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": payment.ID}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+	payment.Status = models.PaymentStatusPaid
+	payment.GatewayFee = fee
+
+	if err := s.payments.PostCapture(ctx, payment); err != nil {
+		return err
+	}
+
+	switch payment.PaymentType {
+	case models.PaymentTypeOrder:
+		return s.settleOrderPayment(ctx, webhook, payment)
+	case models.PaymentTypeTopup:
+		txn, err := s.wallet.CreditTopup(ctx, payment)
+		if err != nil {
+			return err
+		}
+		webhook.ProcessedData = map[string]interface{}{"wallet_transaction_id": txn.ID.Hex()}
+	}
+	return nil
+}
+
+// recordLegacyOrderPayment covers checkouts started before payments were
+// recorded up front: the order is found by its payment reference
+func (s *WebhookService) recordLegacyOrderPayment(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) (*models.Payment, error) {
+	if event.Reference == "" {
+		return nil, nil
+	}
+
+	var order models.Order
+	err := config.Coll.Orders.FindOne(ctx, bson.M{"payment_reference": event.Reference}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	orderID := order.ID
+	payment := &models.Payment{
+		ID:               primitive.NewObjectID(),
+		PaymentNumber:    utils.GeneratePaymentNumber(),
+		UserID:           order.BuyerID,
+		OrderID:          &orderID,
+		Amount:           order.TotalAmount,
+		Currency:         currencyOrDefault(order.Currency),
+		PaymentType:      models.PaymentTypeOrder,
+		PaymentMethod:    models.PaymentMethod(event.Channel),
+		PaymentGateway:   webhook.Gateway,
+		GatewayPaymentID: event.GatewayID,
+		GatewayReference: event.Reference,
+		Status:           models.PaymentStatusPending,
+		GatewayFee:       event.Fee,
+		NetAmount:        utils.RoundCurrency(order.TotalAmount - event.Fee),
+		CustomerEmail:    event.CustomerEmail,
+		InitiatedAt:      now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if _, err := config.Coll.Payments.InsertOne(ctx, payment); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// settleOrderPayment marks the order paid and places the funds in escrow.
+// Checkout payments cover several orders and are split between them.
+func (s *WebhookService) settleOrderPayment(ctx context.Context, webhook *models.PaymentWebhook, payment *models.Payment) error {
+	if payment.CheckoutID != nil {
+		escrows, err := s.checkouts.MarkPaid(ctx, payment)
+		if err != nil {
+			return err
+		}
+		escrowIDs := make([]string, 0, len(escrows))
+		for _, escrow := range escrows {
+			escrowIDs = append(escrowIDs, escrow.ID.Hex())
+		}
+		webhook.ProcessedData = map[string]interface{}{
+			"checkout_id": payment.CheckoutID.Hex(),
+			"escrow_ids":  escrowIDs,
+		}
+		return nil
+	}
+
+	escrow, err := s.checkouts.MarkOrderPaid(ctx, payment)
+	if err != nil {
+		return err
+	}
+	if escrow == nil {
+		// The order was cancelled before the payment landed and was refunded
+		webhook.ProcessedData = map[string]interface{}{
+			"order_id": payment.OrderID.Hex(),
+			"refunded": true,
+		}
+		return nil
+	}
+	webhook.ProcessedData = map[string]interface{}{
+		"order_id":  payment.OrderID.Hex(),
+		"escrow_id": escrow.ID.Hex(),
+	}
+	return nil
+}
+
+func (s *WebhookService) handleChargeFailed(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	payment, err := findEventPayment(ctx, webhook, event)
+	if err == ErrPaymentNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	webhook.PaymentID = &payment.ID
+
+	now := time.Now()
+	result, err := config.Coll.Payments.UpdateOne(ctx, bson.M{
+		"_id":    payment.ID,
+		"status": models.PaymentStatusPending,
+	}, bson.M{"$set": bson.M{
+		"status":            models.PaymentStatusFailed,
+		"failure_reason":    event.Reason,
+		"failed_at":         now,
+		"webhook_processed": true,
+		"updated_at":        now,
+	}})
+	if err != nil || result.ModifiedCount == 0 || payment.PaymentType != models.PaymentTypeOrder {
+		return err
+	}
+
+	// Give the held stock back; paying again later takes it afresh
+	orderIDs, err := paymentOrderIDs(ctx, payment)
+	if err != nil {
+		return err
+	}
+	for _, orderID := range orderIDs {
+		if err := s.inventory.Release(ctx, orderID, "payment_failed"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paymentOrderIDs lists the orders an order payment covers
+func paymentOrderIDs(ctx context.Context, payment *models.Payment) ([]primitive.ObjectID, error) {
+	if payment.CheckoutID == nil {
+		if payment.OrderID == nil {
+			return nil, nil
+		}
+		return []primitive.ObjectID{*payment.OrderID}, nil
+	}
+
+	var checkout models.Checkout
+	if err := config.Coll.Checkouts.FindOne(ctx, bson.M{"_id": *payment.CheckoutID}).Decode(&checkout); err != nil {
+		return nil, err
+	}
+	return checkout.OrderIDs, nil
+}
+
+// handleRefundProcessed completes the refund the event is for. A refund
+// the engine did not start, such as one made from the gateway dashboard,
+// is booked through the refund engine so the escrow pays for it. The
+// payment only flips to refunded once its refunds add up to the full amount.
+func (s *WebhookService) handleRefundProcessed(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	payment, err := findEventPayment(ctx, webhook, event)
+	if err == ErrPaymentNotFound {
+		webhook.ProcessedData = map[string]interface{}{"skipped": "no payment for reference"}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	webhook.PaymentID = &payment.ID
+
+	filter := bson.M{"payment_id": payment.ID}
+	if event.RefundID != "" {
+		filter["gateway_refund_id"] = event.RefundID
+	} else {
+		// Without a refund id the in-flight refund of this amount is the match
+		filter["status"] = bson.M{"$in": []models.RefundStatus{models.RefundStatusApproved, models.RefundStatusProcessing}}
+		filter["refund_amount"] = utils.RoundCurrency(event.Amount)
+	}
+	cursor, err := config.Coll.Refunds.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var refunds []models.Refund
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return err
+	}
+	if event.RefundID == "" && len(refunds) > 1 {
+		refunds = refunds[:1]
+	}
+
+	now := time.Now()
+	if len(refunds) == 0 {
+		// The engine writes the gateway's refund id only once the gateway has
+		// answered; until then this may be that refund, so try again later
+		inFlight, err := config.Coll.Refunds.CountDocuments(ctx, bson.M{
+			"payment_id":        payment.ID,
+			"status":            bson.M{"$in": []models.RefundStatus{models.RefundStatusApproved, models.RefundStatusProcessing}},
+			"gateway_refund_id": bson.M{"$in": bson.A{nil, ""}},
+		})
+		if err != nil {
+			return err
+		}
+		if inFlight > 0 {
+			return fmt.Errorf("a refund on payment %s is still being placed", payment.PaymentNumber)
+		}
+
+		refundID := event.RefundID
+		if refundID == "" {
+			refundID = event.EventID
+		}
+		reason := event.Reason
+		if reason == "" {
+			reason = "Refunded at the gateway"
+		}
+		if err := s.refunds.RecordGatewayRefund(ctx, payment, refundID, event.Amount, reason); err != nil {
+			return err
+		}
+		webhook.ProcessedData = map[string]interface{}{"booked_refund": refundID}
+	}
+
+	for _, refund := range refunds {
+		if refund.Status != models.RefundStatusApproved && refund.Status != models.RefundStatusProcessing {
+			continue
+		}
+		if _, err := config.Coll.Refunds.UpdateOne(ctx, bson.M{"_id": refund.ID, "status": refund.Status}, bson.M{"$set": bson.M{
+			"status":       models.RefundStatusCompleted,
+			"completed_at": now,
+			"updated_at":   now,
+		}}); err != nil {
+			return err
+		}
+		if refund.RefundPaymentID == nil {
+			continue
+		}
+		if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{
+			"_id":    *refund.RefundPaymentID,
+			"status": models.PaymentStatusPending,
+		}, bson.M{"$set": bson.M{
+			"status":      models.PaymentStatusRefunded,
+			"refunded_at": now,
+			"updated_at":  now,
+		}}); err != nil {
+			return err
+		}
+	}
+
+	refunded, err := refundedAmount(ctx, payment)
+	if err != nil {
+		return err
+	}
+	if refunded < utils.RoundCurrency(payment.Amount) {
+		if webhook.ProcessedData == nil {
+			webhook.ProcessedData = map[string]interface{}{}
+		}
+		webhook.ProcessedData["partial_refund"] = refunded
+		return nil
+	}
+
+	if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{"_id": payment.ID}, bson.M{"$set": bson.M{
+		"status":      models.PaymentStatusRefunded,
+		"refunded_at": now,
+		"updated_at":  now,
+	}}); err != nil {
+		return err
+	}
+	if payment.CheckoutID != nil {
+		_, err := config.Coll.Checkouts.UpdateOne(ctx, bson.M{"_id": *payment.CheckoutID}, bson.M{"$set": bson.M{
+			"payment_status": models.PaymentStatusRefunded,
+			"updated_at":     now,
+		}})
+		return err
+	}
+	return nil
+}
+
+// refundedAmount sums the refunds that have gone through against a payment
+func refundedAmount(ctx context.Context, payment *models.Payment) (float64, error) {
+	cursor, err := config.Coll.Payments.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"payment_type":                 models.PaymentTypeRefund,
+			"metadata.original_payment_id": payment.ID.Hex(),
+			"status":                       models.PaymentStatusRefunded,
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var result []struct {
+		Total float64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return utils.RoundCurrency(result[0].Total), nil
+}
+
+// handleTransferSuccess completes the withdrawal paid out by the transfer
+func (s *WebhookService) handleTransferSuccess(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	if event.Reference == "" {
+		return fmt.Errorf("missing transfer reference")
+	}
+
+	now := time.Now()
+	if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{
+		"gateway_reference": event.Reference,
+		"payment_type":      models.PaymentTypeWithdrawal,
+	}, bson.M{"$set": bson.M{
+		"status":            models.PaymentStatusPaid,
+		"confirmed_at":      now,
+		"webhook_processed": true,
+		"updated_at":        now,
+	}}); err != nil {
+		return err
+	}
+
+	_, err := s.withdrawals.CompleteTransfer(ctx, event.Reference)
+	return err
+}
+
+// handleTransferFailed marks the withdrawal paid out by the transfer as failed
+// and returns the held funds to the seller's wallet
+func (s *WebhookService) handleTransferFailed(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	if event.Reference == "" {
+		return fmt.Errorf("missing transfer reference")
+	}
+
+	reason := event.Reason
+	if reason == "" {
+		reason = event.Event
+	}
+
+	now := time.Now()
+	if _, err := config.Coll.Payments.UpdateOne(ctx, bson.M{
+		"gateway_reference": event.Reference,
+		"payment_type":      models.PaymentTypeWithdrawal,
+	}, bson.M{"$set": bson.M{
+		"status":            models.PaymentStatusFailed,
+		"failure_reason":    reason,
+		"failed_at":         now,
+		"webhook_processed": true,
+		"updated_at":        now,
+	}}); err != nil {
+		return err
+	}
+
+	_, err := s.withdrawals.FailTransfer(ctx, event.Reference, reason)
+	return err
+}
+
+func (s *WebhookService) handleSubscriptionCreate(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	subscriptionCode := mapString(event.Data, "subscription_code")
+	if subscriptionCode == "" || event.CustomerEmail == "" {
+		return fmt.Errorf("missing subscription code or customer email")
+	}
+
+	_, err := config.Coll.Users.UpdateOne(ctx, bson.M{"email": event.CustomerEmail}, bson.M{"$set": bson.M{
+		"subscription_code":   subscriptionCode,
+		"subscription_status": "active",
+		"updated_at":          time.Now(),
+	}})
+	return err
+}
+
+func (s *WebhookService) handleSubscriptionDisable(ctx context.Context, webhook *models.PaymentWebhook, event *WebhookEvent) error {
+	subscriptionCode := mapString(event.Data, "subscription_code")
+	if subscriptionCode == "" {
+		return fmt.Errorf("missing subscription code")
+	}
+
+	var user models.User
+	if err := config.Coll.Users.FindOne(ctx, bson.M{"subscription_code": subscriptionCode}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	if _, err := config.Coll.Users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"subscription_status": "cancelled",
+		"updated_at":          now,
+	}}); err != nil {
+		return err
+	}
+
+	_, err := config.Coll.PremiumMemberships.UpdateMany(ctx, bson.M{
+		"user_id": user.ID,
+		"status":  "active",
+	}, bson.M{"$set": bson.M{
+		"status":       "cancelled",
+		"auto_renew":   false,
+		"cancelled_at": now,
+		"updated_at":   now,
+	}})
+	return err
+}
@@ -388,6 +388,14 @@ func SendDisputeUpdate(userID string, dispute models.Dispute) {
 	})
 }
 
+func SendSavedSearchMatches(userID string, matches interface{}) {
+	WSHub.SendToUser(userID, WSMessage{
+		Type:      "saved_search_matches",
+		Data:      matches,
+		Timestamp: time.Now(),
+	})
+}
+
 func SendNotification(userID string, notification interface{}) {
 	WSHub.SendToUser(userID, WSMessage{
 		Type:      "notification",
@@ -0,0 +1,651 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"autoboy-backend/config"
+	"autoboy-backend/ledger"
+	"autoboy-backend/models"
+	"autoboy-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrBankAccountNotFound   = errors.New("bank account not found")
+	ErrBankAccountExists     = errors.New("bank account already added")
+	ErrBankAccountUnverified = errors.New("bank account is not verified")
+	ErrBankAccountInUse      = errors.New("bank account has withdrawals in progress")
+	ErrWithdrawalNotFound    = errors.New("withdrawal not found")
+	ErrWithdrawalState       = errors.New("withdrawal cannot be changed in its current state")
+	ErrWithdrawalLimit       = errors.New("withdrawal limit exceeded")
+)
+
+const withdrawalReferenceType = "withdrawal"
+
+// openWithdrawalStatuses are withdrawals whose funds are still held
+var openWithdrawalStatuses = []models.WithdrawalStatus{
+	models.WithdrawalStatusRequested,
+	models.WithdrawalStatusApproved,
+	models.WithdrawalStatusProcessing,
+}
+
+// WithdrawalLimit caps how much and how often a user can withdraw
+type WithdrawalLimit struct {
+	Daily         float64 `json:"daily"`
+	PerWithdrawal float64 `json:"per_withdrawal"`
+	MaxPerDay     int     `json:"max_per_day"`
+	MaxPerHour    int     `json:"max_per_hour"`
+}
+
+// withdrawalLimits are keyed by the seller's premium tier
+var withdrawalLimits = map[models.PremiumStatus]WithdrawalLimit{
+	models.PremiumStatusNone:    {Daily: 200000, PerWithdrawal: 100000, MaxPerDay: 2, MaxPerHour: 1},
+	models.PremiumStatusBasic:   {Daily: 500000, PerWithdrawal: 250000, MaxPerDay: 3, MaxPerHour: 2},
+	models.PremiumStatusPremium: {Daily: 2000000, PerWithdrawal: 1000000, MaxPerDay: 5, MaxPerHour: 3},
+	models.PremiumStatusVIP:     {Daily: 10000000, PerWithdrawal: 5000000, MaxPerDay: 10, MaxPerHour: 5},
+}
+
+// WithdrawalUsage is what a user has withdrawn in the current windows
+type WithdrawalUsage struct {
+	AmountToday float64 `json:"amount_today"`
+	CountToday  int     `json:"count_today"`
+	CountHour   int     `json:"count_hour"`
+}
+
+// BankAccountRequest describes a bank account to add
+type BankAccountRequest struct {
+	BankName      string
+	BankCode      string
+	AccountNumber string
+	Currency      string
+}
+
+// WithdrawalService takes withdrawals from request through admin approval to
+// a gateway transfer. Funds are held in the wallet from the moment of the
+// request until the transfer completes, fails or is rejected.
+type WithdrawalService struct {
+	wallet           *WalletService
+	payments         *PaymentService
+	minAmount        float64
+	autoApproveLimit float64
+	batchSize        int
+	// transferTimeout is how long a transfer may stay unsettled before the
+	// gateway is asked how it ended
+	transferTimeout time.Duration
+}
+
+func NewWithdrawalService(wallet *WalletService, payments *PaymentService) *WithdrawalService {
+	return &WithdrawalService{
+		wallet:           wallet,
+		payments:         payments,
+		minAmount:        utils.GetEnvAsFloat("WITHDRAWAL_MIN_AMOUNT", 1000),
+		autoApproveLimit: utils.GetEnvAsFloat("WITHDRAWAL_AUTO_APPROVE_LIMIT", 0),
+		batchSize:        utils.GetEnvAsInt("WITHDRAWAL_BATCH_SIZE", 50),
+		transferTimeout:  time.Duration(utils.GetEnvAsInt("WITHDRAWAL_TRANSFER_TIMEOUT_MINUTES", 60)) * time.Minute,
+	}
+}
+
+// AddBankAccount resolves the account holder's name through the gateway and
+// saves the account as verified. The user's first account becomes the default.
+func (s *WithdrawalService) AddBankAccount(ctx context.Context, userID primitive.ObjectID, req BankAccountRequest) (*models.BankAccount, error) {
+	req.AccountNumber = strings.TrimSpace(req.AccountNumber)
+	req.Currency = strings.ToUpper(currencyOrDefault(req.Currency))
+
+	name, err := s.payments.ResolveAccount(ctx, req.Currency, req.BankCode, req.AccountNumber)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify bank account: %w", err)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("could not verify bank account: no account name returned")
+	}
+
+	count, err := config.Coll.BankAccounts.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	account := &models.BankAccount{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		BankName:      req.BankName,
+		BankCode:      req.BankCode,
+		AccountNumber: req.AccountNumber,
+		AccountName:   name,
+		Currency:      req.Currency,
+		IsVerified:    true,
+		IsDefault:     count == 0,
+		VerifiedAt:    &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if _, err := config.Coll.BankAccounts.InsertOne(ctx, account); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrBankAccountExists
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// ListBankAccounts returns the user's bank accounts, default first
+func (s *WithdrawalService) ListBankAccounts(ctx context.Context, userID primitive.ObjectID) ([]models.BankAccount, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "is_default", Value: -1}, {Key: "created_at", Value: -1}})
+	cursor, err := config.Coll.BankAccounts.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	accounts := []models.BankAccount{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// SetDefaultBankAccount makes one account the default for withdrawals
+func (s *WithdrawalService) SetDefaultBankAccount(ctx context.Context, userID, accountID primitive.ObjectID) error {
+	return config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := config.Coll.BankAccounts.UpdateOne(sc, bson.M{"_id": accountID, "user_id": userID}, bson.M{"$set": bson.M{
+			"is_default": true,
+			"updated_at": time.Now(),
+		}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrBankAccountNotFound
+		}
+
+		_, err = config.Coll.BankAccounts.UpdateMany(sc, bson.M{
+			"user_id": userID,
+			"_id":     bson.M{"$ne": accountID},
+		}, bson.M{"$set": bson.M{"is_default": false}})
+		return err
+	})
+}
+
+// DeleteBankAccount removes an account that no open withdrawal is paying to
+func (s *WithdrawalService) DeleteBankAccount(ctx context.Context, userID, accountID primitive.ObjectID) error {
+	open, err := config.Coll.Withdrawals.CountDocuments(ctx, bson.M{
+		"bank_account_id": accountID,
+		"status":          bson.M{"$in": openWithdrawalStatuses},
+	})
+	if err != nil {
+		return err
+	}
+	if open > 0 {
+		return ErrBankAccountInUse
+	}
+
+	result, err := config.Coll.BankAccounts.DeleteOne(ctx, bson.M{"_id": accountID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrBankAccountNotFound
+	}
+	return nil
+}
+
+// Limits returns the user's tier limits and current usage
+func (s *WithdrawalService) Limits(ctx context.Context, userID primitive.ObjectID) (WithdrawalLimit, *WithdrawalUsage, error) {
+	limit, err := s.tierLimit(ctx, userID)
+	if err != nil {
+		return limit, nil, err
+	}
+
+	usage, err := s.usage(ctx, userID)
+	if err != nil {
+		return limit, nil, err
+	}
+	return limit, usage, nil
+}
+
+// Request holds amount in the user's wallet and records a withdrawal to one
+// of their verified bank accounts. Small withdrawals may be approved
+// automatically; the rest wait for an admin.
+func (s *WithdrawalService) Request(ctx context.Context, userID primitive.ObjectID, amount float64, bankAccountID primitive.ObjectID) (*models.Withdrawal, error) {
+	amount = utils.RoundCurrency(amount)
+	if amount < s.minAmount {
+		return nil, fmt.Errorf("%w: minimum withdrawal is %.2f", ErrWithdrawalLimit, s.minAmount)
+	}
+
+	var account models.BankAccount
+	if err := config.Coll.BankAccounts.FindOne(ctx, bson.M{"_id": bankAccountID, "user_id": userID}).Decode(&account); err != nil {
+		return nil, ErrBankAccountNotFound
+	}
+	if !account.IsVerified {
+		return nil, ErrBankAccountUnverified
+	}
+	// The wallet is paid out as it is held; nothing converts it on the way
+	wallet, err := s.wallet.GetOrCreateWallet(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !currencyMatches(account.Currency, wallet.Currency) {
+		return nil, fmt.Errorf("%w: the account is in %s and the wallet in %s", ErrWalletCurrency, account.Currency, currencyOrDefault(wallet.Currency))
+	}
+
+	limit, err := s.tierLimit(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if amount > limit.PerWithdrawal {
+		return nil, fmt.Errorf("%w: at most %.2f per withdrawal", ErrWithdrawalLimit, limit.PerWithdrawal)
+	}
+
+	now := time.Now()
+	withdrawal := &models.Withdrawal{
+		ID:               primitive.NewObjectID(),
+		WithdrawalNumber: utils.GenerateWithdrawalNumber(),
+		UserID:           userID,
+		BankAccountID:    account.ID,
+		Amount:           amount,
+		Currency:         account.Currency,
+		NetAmount:        amount,
+		Status:           models.WithdrawalStatusRequested,
+		ProcessingMethod: "gateway_transfer",
+		RequiresApproval: s.autoApproveLimit <= 0 || amount > s.autoApproveLimit,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if !withdrawal.RequiresApproval {
+		withdrawal.Status = models.WithdrawalStatusApproved
+		withdrawal.ApprovedAt = &now
+	}
+
+	err = config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		// Bumping the user's counter first makes concurrent requests from the
+		// same user conflict, so the usage read below always includes every
+		// withdrawal committed ahead of this one
+		if _, err := config.Coll.WithdrawalCounters.UpdateOne(sc, bson.M{"_id": userID}, bson.M{
+			"$inc": bson.M{"requests": 1},
+			"$set": bson.M{"updated_at": now},
+		}, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+		usage, err := s.usage(sc, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkUsage(limit, usage, amount); err != nil {
+			return err
+		}
+
+		if _, err := s.wallet.Hold(sc, userID, WalletEntry{
+			Type:          models.WalletTransactionWithdrawal,
+			Amount:        amount,
+			Currency:      withdrawal.Currency,
+			ReferenceType: withdrawalReferenceType,
+			ReferenceID:   &withdrawal.ID,
+			Description:   fmt.Sprintf("Withdrawal %s to %s %s", withdrawal.WithdrawalNumber, account.BankName, maskAccountNumber(account.AccountNumber)),
+		}); err != nil {
+			return err
+		}
+		_, err = config.Coll.Withdrawals.InsertOne(sc, withdrawal)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrInsufficientBalance) {
+			return nil, ErrInsufficientBalance
+		}
+		if errors.Is(err, ErrWithdrawalLimit) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to request withdrawal: %w", err)
+	}
+	return withdrawal, nil
+}
+
+// Approve lets a requested withdrawal go out with the next payout batch
+func (s *WithdrawalService) Approve(ctx context.Context, withdrawalID, adminID primitive.ObjectID) (*models.Withdrawal, error) {
+	now := time.Now()
+	return s.transition(ctx, bson.M{
+		"_id":    withdrawalID,
+		"status": models.WithdrawalStatusRequested,
+	}, bson.M{
+		"status":      models.WithdrawalStatusApproved,
+		"approved_by": adminID,
+		"approved_at": now,
+		"updated_at":  now,
+	})
+}
+
+// Reject refuses a withdrawal that has not been sent and returns the held funds
+func (s *WithdrawalService) Reject(ctx context.Context, withdrawalID, adminID primitive.ObjectID, reason string) (*models.Withdrawal, error) {
+	return s.closeAndRelease(ctx, bson.M{
+		"_id":    withdrawalID,
+		"status": bson.M{"$in": []models.WithdrawalStatus{models.WithdrawalStatusRequested, models.WithdrawalStatusApproved}},
+	}, bson.M{
+		"status":          models.WithdrawalStatusRejected,
+		"approved_by":     adminID,
+		"rejected_reason": reason,
+	}, "Withdrawal rejected: "+reason)
+}
+
+// Cancel lets the user withdraw a request that has not been sent yet
+func (s *WithdrawalService) Cancel(ctx context.Context, withdrawalID, userID primitive.ObjectID) (*models.Withdrawal, error) {
+	return s.closeAndRelease(ctx, bson.M{
+		"_id":     withdrawalID,
+		"user_id": userID,
+		"status":  bson.M{"$in": []models.WithdrawalStatus{models.WithdrawalStatusRequested, models.WithdrawalStatusApproved}},
+	}, bson.M{
+		"status": models.WithdrawalStatusCancelled,
+	}, "Withdrawal cancelled")
+}
+
+// ProcessBatch sends approved withdrawals to the gateway, oldest first.
+// Transfers that the gateway refuses outright fail immediately and return
+// the funds. Accepted ones, and ones whose outcome is unknown because the
+// call timed out or the gateway erred, complete or fail later through
+// transfer webhooks or ReconcileTransfers.
+func (s *WithdrawalService) ProcessBatch(ctx context.Context) (sent int, failed int, err error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetLimit(int64(s.batchSize))
+
+	cursor, err := config.Coll.Withdrawals.Find(ctx, bson.M{"status": models.WithdrawalStatusApproved}, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	var withdrawals []models.Withdrawal
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		return 0, 0, err
+	}
+
+	for _, candidate := range withdrawals {
+		// Claim the withdrawal so concurrent batches never send it twice.
+		// The gateway reference is set before the transfer so an early
+		// webhook can still find it.
+		now := time.Now()
+		withdrawal, err := s.transition(ctx, bson.M{
+			"_id":    candidate.ID,
+			"status": models.WithdrawalStatusApproved,
+		}, bson.M{
+			"status":            models.WithdrawalStatusProcessing,
+			"gateway_reference": candidate.WithdrawalNumber,
+			"processed_at":      now,
+			"updated_at":        now,
+		})
+		if err == ErrWithdrawalState {
+			continue
+		}
+		if err != nil {
+			return sent, failed, err
+		}
+
+		if err := s.send(ctx, withdrawal); err != nil {
+			if !transferRejected(err) {
+				// The money may have gone out; returning it now could pay twice
+				log.Printf("Withdrawal %s transfer outcome unknown, left processing: %v", withdrawal.WithdrawalNumber, err)
+				continue
+			}
+			log.Printf("Withdrawal %s transfer failed: %v", withdrawal.WithdrawalNumber, err)
+			if _, failErr := s.FailTransfer(ctx, withdrawal.GatewayReference, err.Error()); failErr != nil {
+				log.Printf("Failed to return funds for withdrawal %s: %v", withdrawal.WithdrawalNumber, failErr)
+			}
+			failed++
+			continue
+		}
+		sent++
+	}
+	return sent, failed, nil
+}
+
+// ReconcileTransfers settles withdrawals whose transfer has stayed
+// unsettled past the timeout, as happens when the transfer call timed out or
+// its webhook never came, by asking the gateway how the transfer ended
+func (s *WithdrawalService) ReconcileTransfers(ctx context.Context) (completed int, failed int, err error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "processed_at", Value: 1}}).
+		SetLimit(int64(s.batchSize))
+
+	cursor, err := config.Coll.Withdrawals.Find(ctx, bson.M{
+		"status":       models.WithdrawalStatusProcessing,
+		"processed_at": bson.M{"$lte": time.Now().Add(-s.transferTimeout)},
+	}, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	var withdrawals []models.Withdrawal
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		return 0, 0, err
+	}
+
+	for _, withdrawal := range withdrawals {
+		status, err := s.payments.VerifyTransfer(ctx, withdrawal.GatewayReference)
+		switch {
+		case err == ErrPaymentNotFound, errors.Is(err, ErrGatewayNotFound):
+			// The transfer was never made, so the funds can safely go back
+			status = models.PaymentStatusFailed
+		case err != nil:
+			log.Printf("Could not verify transfer for withdrawal %s: %v", withdrawal.WithdrawalNumber, err)
+			continue
+		}
+
+		switch status {
+		case models.PaymentStatusPaid:
+			if _, err := s.CompleteTransfer(ctx, withdrawal.GatewayReference); err != nil {
+				return completed, failed, err
+			}
+			completed++
+		case models.PaymentStatusFailed:
+			if _, err := s.FailTransfer(ctx, withdrawal.GatewayReference, "transfer did not go through"); err != nil {
+				return completed, failed, err
+			}
+			failed++
+		}
+	}
+	return completed, failed, nil
+}
+
+// CompleteTransfer finishes the withdrawal paid by a successful transfer.
+// It returns nil without changes if the withdrawal is already settled.
+func (s *WithdrawalService) CompleteTransfer(ctx context.Context, reference string) (*models.Withdrawal, error) {
+	var withdrawal *models.Withdrawal
+	err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		now := time.Now()
+		var err error
+		withdrawal, err = s.transition(sc, bson.M{
+			"gateway_reference": reference,
+			"status":            models.WithdrawalStatusProcessing,
+		}, bson.M{
+			"status":       models.WithdrawalStatusCompleted,
+			"completed_at": now,
+			"updated_at":   now,
+		})
+		if err != nil {
+			return err
+		}
+
+		return s.wallet.SettleHold(sc, withdrawal.UserID, WalletEntry{
+			Type:          models.WalletTransactionWithdrawal,
+			Counterparty:  ledger.AccountGatewayClearing,
+			Amount:        withdrawal.Amount,
+			Currency:      withdrawal.Currency,
+			ReferenceType: withdrawalReferenceType,
+			ReferenceID:   &withdrawal.ID,
+			Description:   fmt.Sprintf("Withdrawal %s paid out", withdrawal.WithdrawalNumber),
+		})
+	})
+	if err == ErrWithdrawalState {
+		return nil, nil
+	}
+	return withdrawal, err
+}
+
+// FailTransfer marks the withdrawal paid by a failed or reversed transfer as
+// failed and returns the held funds to the wallet. It returns nil without
+// changes if the withdrawal is already settled.
+func (s *WithdrawalService) FailTransfer(ctx context.Context, reference, reason string) (*models.Withdrawal, error) {
+	withdrawal, err := s.closeAndRelease(ctx, bson.M{
+		"gateway_reference": reference,
+		"status":            models.WithdrawalStatusProcessing,
+	}, bson.M{
+		"status":         models.WithdrawalStatusFailed,
+		"failure_reason": reason,
+		"failed_at":      time.Now(),
+	}, "Withdrawal transfer failed: "+reason)
+	if err == ErrWithdrawalState {
+		return nil, nil
+	}
+	return withdrawal, err
+}
+
+// send makes the gateway transfer for a claimed withdrawal
+func (s *WithdrawalService) send(ctx context.Context, withdrawal *models.Withdrawal) error {
+	var account models.BankAccount
+	if err := config.Coll.BankAccounts.FindOne(ctx, bson.M{"_id": withdrawal.BankAccountID}).Decode(&account); err != nil {
+		return ErrBankAccountNotFound
+	}
+
+	_, _, err := s.payments.Transfer(ctx, TransferRequest{
+		UserID:        withdrawal.UserID,
+		Reference:     withdrawal.GatewayReference,
+		Amount:        withdrawal.NetAmount,
+		Currency:      withdrawal.Currency,
+		BankCode:      account.BankCode,
+		AccountNumber: account.AccountNumber,
+		AccountName:   account.AccountName,
+		Reason:        "AutoBoy withdrawal " + withdrawal.WithdrawalNumber,
+		Metadata: map[string]interface{}{
+			"withdrawal_id": withdrawal.ID.Hex(),
+		},
+	})
+	return err
+}
+
+// transferRejected reports whether a failed send is known to have moved no
+// money, so the withdrawal can be failed and its funds returned at once
+func transferRejected(err error) bool {
+	return errors.Is(err, ErrGatewayRejected) ||
+		errors.Is(err, ErrGatewayNotConfigured) ||
+		errors.Is(err, ErrBankAccountNotFound)
+}
+
+// closeAndRelease moves a withdrawal matching filter to a final state and
+// returns its held funds in the same transaction
+func (s *WithdrawalService) closeAndRelease(ctx context.Context, filter, set bson.M, description string) (*models.Withdrawal, error) {
+	var withdrawal *models.Withdrawal
+	err := config.DB.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		set["updated_at"] = time.Now()
+		var err error
+		withdrawal, err = s.transition(sc, filter, set)
+		if err != nil {
+			return err
+		}
+
+		return s.wallet.ReleaseHold(sc, withdrawal.UserID, WalletEntry{
+			Type:          models.WalletTransactionWithdrawal,
+			Amount:        withdrawal.Amount,
+			Currency:      withdrawal.Currency,
+			ReferenceType: withdrawalReferenceType,
+			ReferenceID:   &withdrawal.ID,
+			Description:   description,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withdrawal, nil
+}
+
+// transition applies set to the withdrawal matching filter, returning
+// ErrWithdrawalState when nothing matches
+func (s *WithdrawalService) transition(ctx context.Context, filter, set bson.M) (*models.Withdrawal, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var withdrawal models.Withdrawal
+	err := config.Coll.Withdrawals.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&withdrawal)
+	if err == mongo.ErrNoDocuments {
+		if id, ok := filter["_id"]; ok {
+			if count, _ := config.Coll.Withdrawals.CountDocuments(ctx, bson.M{"_id": id}); count == 0 {
+				return nil, ErrWithdrawalNotFound
+			}
+		}
+		return nil, ErrWithdrawalState
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
+// tierLimit returns the withdrawal limits for the user's premium tier
+func (s *WithdrawalService) tierLimit(ctx context.Context, userID primitive.ObjectID) (WithdrawalLimit, error) {
+	var user models.User
+	if err := config.Coll.Users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return WithdrawalLimit{}, fmt.Errorf("user not found: %w", err)
+	}
+
+	limit, ok := withdrawalLimits[user.Profile.PremiumStatus]
+	if !ok {
+		limit = withdrawalLimits[models.PremiumStatusNone]
+	}
+	return limit, nil
+}
+
+// checkUsage reports whether withdrawing amount on top of usage stays
+// within the daily and hourly limits
+func checkUsage(limit WithdrawalLimit, usage *WithdrawalUsage, amount float64) error {
+	switch {
+	case usage.AmountToday+amount > limit.Daily:
+		return fmt.Errorf("%w: daily limit is %.2f, %.2f already used", ErrWithdrawalLimit, limit.Daily, usage.AmountToday)
+	case usage.CountToday >= limit.MaxPerDay:
+		return fmt.Errorf("%w: at most %d withdrawals per day", ErrWithdrawalLimit, limit.MaxPerDay)
+	case usage.CountHour >= limit.MaxPerHour:
+		return fmt.Errorf("%w: at most %d withdrawals per hour", ErrWithdrawalLimit, limit.MaxPerHour)
+	}
+	return nil
+}
+
+func (s *WithdrawalService) usage(ctx context.Context, userID primitive.ObjectID) (*WithdrawalUsage, error) {
+	now := time.Now()
+	cursor, err := config.Coll.Withdrawals.Find(ctx, bson.M{
+		"user_id":    userID,
+		"created_at": bson.M{"$gte": now.Add(-24 * time.Hour)},
+		"status": bson.M{"$nin": []models.WithdrawalStatus{
+			models.WithdrawalStatusRejected,
+			models.WithdrawalStatusFailed,
+			models.WithdrawalStatusCancelled,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var withdrawals []models.Withdrawal
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		return nil, err
+	}
+
+	usage := &WithdrawalUsage{}
+	hourAgo := now.Add(-time.Hour)
+	for _, w := range withdrawals {
+		usage.AmountToday += w.Amount
+		usage.CountToday++
+		if w.CreatedAt.After(hourAgo) {
+			usage.CountHour++
+		}
+	}
+	usage.AmountToday = utils.RoundCurrency(usage.AmountToday)
+	return usage, nil
+}
+
+func maskAccountNumber(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
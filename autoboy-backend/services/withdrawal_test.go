@@ -0,0 +1,39 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckUsage(t *testing.T) {
+	limit := WithdrawalLimit{Daily: 1000, PerWithdrawal: 500, MaxPerDay: 2, MaxPerHour: 1}
+	tests := []struct {
+		name    string
+		usage   WithdrawalUsage
+		amount  float64
+		wantErr bool
+	}{
+		{"first of the day", WithdrawalUsage{}, 500, false},
+		{"up to the daily limit", WithdrawalUsage{AmountToday: 600, CountToday: 1}, 400, false},
+		{"over the daily limit", WithdrawalUsage{AmountToday: 600, CountToday: 1}, 400.01, true},
+		{"too many today", WithdrawalUsage{AmountToday: 200, CountToday: 2}, 100, true},
+		{"too many this hour", WithdrawalUsage{AmountToday: 200, CountToday: 1, CountHour: 1}, 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUsage(limit, &tt.usage, tt.amount)
+			if tt.wantErr != errors.Is(err, ErrWithdrawalLimit) || (!tt.wantErr && err != nil) {
+				t.Fatalf("checkUsage() error = %v, want limit error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaskAccountNumber(t *testing.T) {
+	if got := maskAccountNumber("0123456789"); got != "******6789" {
+		t.Fatalf("maskAccountNumber() = %q", got)
+	}
+	if got := maskAccountNumber("789"); got != "789" {
+		t.Fatalf("maskAccountNumber() of a short number = %q", got)
+	}
+}
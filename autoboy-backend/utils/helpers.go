@@ -57,6 +57,16 @@ func GetEnvAsBool(key string, fallback bool) bool {
 	return fallback
 }
 
+// GetEnvAsFloat gets environment variable as float with fallback
+func GetEnvAsFloat(key string, fallback float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return fallback
+}
+
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -304,6 +314,14 @@ func GenerateOrderNumber() string {
 	return fmt.Sprintf("ORD-%d-%s", timestamp, strings.ToUpper(random))
 }
 
+// GenerateCheckoutNumber generates a unique checkout number for a group of
+// orders paid together
+func GenerateCheckoutNumber() string {
+	timestamp := time.Now().Unix()
+	random := GenerateRandomString(4)
+	return fmt.Sprintf("CHK-%d-%s", timestamp, strings.ToUpper(random))
+}
+
 // GeneratePaymentNumber generates a unique payment number
 func GeneratePaymentNumber() string {
 	timestamp := time.Now().Unix()
@@ -311,6 +329,47 @@ func GeneratePaymentNumber() string {
 	return fmt.Sprintf("PAY-%d-%s", timestamp, strings.ToUpper(random))
 }
 
+// GenerateEscrowNumber generates a unique escrow number
+func GenerateEscrowNumber() string {
+	timestamp := time.Now().Unix()
+	random := GenerateRandomString(4)
+	return fmt.Sprintf("ESC-%d-%s", timestamp, strings.ToUpper(random))
+}
+
+// GenerateTransactionNumber generates a unique wallet transaction number
+func GenerateTransactionNumber() string {
+	timestamp := time.Now().Unix()
+	random := GenerateRandomString(6)
+	return fmt.Sprintf("TXN-%d-%s", timestamp, strings.ToUpper(random))
+}
+
+// GenerateWithdrawalNumber generates a unique withdrawal number, also used as
+// the gateway transfer reference
+func GenerateWithdrawalNumber() string {
+	timestamp := time.Now().Unix()
+	random := GenerateRandomString(6)
+	return fmt.Sprintf("WDR-%d-%s", timestamp, strings.ToUpper(random))
+}
+
+// GenerateRefundNumber generates a unique refund number
+func GenerateRefundNumber() string {
+	timestamp := time.Now().Unix()
+	random := GenerateRandomString(6)
+	return fmt.Sprintf("RFD-%d-%s", timestamp, strings.ToUpper(random))
+}
+
+// GenerateLedgerEntryNumber generates a unique ledger journal entry number
+func GenerateLedgerEntryNumber() string {
+	timestamp := time.Now().Unix()
+	random := GenerateRandomString(6)
+	return fmt.Sprintf("JRN-%d-%s", timestamp, strings.ToUpper(random))
+}
+
+// RoundCurrency rounds an amount to two decimal places
+func RoundCurrency(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
 // ContainsIgnoreCase checks if string contains substring (case insensitive)
 func ContainsIgnoreCase(str, substr string) bool {
 	return strings.Contains(strings.ToLower(str), strings.ToLower(substr))